@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/audit"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/sirupsen/logrus"
+)
+
+// runAudit audits the legacy DB for data-quality problems, and logs the
+// number of problems found by each check, with examples.
+//
+// The program exits with an error if any problems are found, so that they
+// can be fixed in the legacy DB before migrating.
+func runAudit(conn *sql.DB) {
+	logrus.Info("Auditing legacy DB")
+	report, err := audit.Run(&legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries})
+	if err != nil {
+		logrus.Fatalf("Error auditing legacy DB: %s", err)
+	}
+	for _, f := range report.Findings {
+		if f.Count == 0 {
+			logrus.Infof("%s: none", f.Check)
+			continue
+		}
+		logrus.Warnf("%s: %d", f.Check, f.Count)
+		for _, v := range f.Examples {
+			logrus.Warnf("  %s", v)
+		}
+	}
+	if n := report.Problems(); n > 0 {
+		logrus.Fatalf("Audit found %d problems in the legacy DB. Fix them before migrating, or they will be skipped or migrated as found.", n)
+	}
+	logrus.Info("Audit passed: no problems found in the legacy DB.")
+}
@@ -0,0 +1,188 @@
+// Package audit provides a data-quality audit of a legacy DB, which reports
+// the problems that would cause rows to be skipped or mangled by a migration,
+// so that they can be fixed in the legacy DB beforehand.
+package audit
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+)
+
+// MaxExamples is the number of examples kept for each finding.
+const MaxExamples = 5
+
+// The checks run by the audit, in the order they are reported.
+const (
+	InvalidAddresses   = "Invalid decimal addresses"
+	OutsideSubnet      = "Addresses outside their subnet"
+	DuplicateAddresses = "Duplicate IP addresses"
+	DuplicateVLANs     = "Duplicate VLAN numbers"
+	OrphanAddresses    = "Addresses with missing subnets"
+	OrphanSubnets      = "Subnets with missing sections"
+)
+
+// Finding is the result of a single check.
+type Finding struct {
+	// The name of the check.
+	Check string
+
+	// The number of problems found.
+	Count int
+
+	// Up to MaxExamples of the problems found, to help track them down.
+	Examples []string
+}
+
+// add counts a problem, keeping it as an example if there is room.
+func (f *Finding) add(format string, args ...interface{}) {
+	f.Count++
+	if len(f.Examples) < MaxExamples {
+		f.Examples = append(f.Examples, fmt.Sprintf(format, args...))
+	}
+}
+
+// Report is the result of an audit.
+type Report struct {
+	// The findings of each check, in the order the checks are run.
+	Findings []*Finding
+}
+
+// Problems returns the total number of problems found.
+func (r *Report) Problems() (n int) {
+	for _, v := range r.Findings {
+		n += v.Count
+	}
+	return
+}
+
+// finding returns the finding for the named check.
+func (r *Report) finding(check string) *Finding {
+	for _, v := range r.Findings {
+		if v.Check == check {
+			return v
+		}
+	}
+	panic("unknown audit check " + check)
+}
+
+// Run audits the legacy DB read by r. The subnets and addresses are read
+// section by section, as the same address may exist in more than one
+// section. The reader's section and Skipped callback are overwritten.
+func Run(r *legacydb.Reader) (*Report, error) {
+	report := &Report{}
+	for _, v := range []string{InvalidAddresses, OutsideSubnet, DuplicateAddresses, DuplicateVLANs, OrphanAddresses, OrphanSubnets} {
+		report.Findings = append(report.Findings, &Finding{Check: v})
+	}
+
+	invalid := report.finding(InvalidAddresses)
+	r.Skipped = func(v legacydb.Skip) { invalid.add("%s", describe(v)) }
+	ids, err := r.SectionIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		r.SectionID = id
+		if _, _, err := r.Subnets(); err != nil {
+			return nil, err
+		}
+		addrs, _, err := r.Addresses()
+		if err != nil {
+			return nil, err
+		}
+		checkAddresses(report, id, addrs)
+	}
+	r.SectionID = 0
+
+	vlans, err := r.DuplicateVLANs()
+	if err != nil {
+		return nil, err
+	}
+	checkVLANs(report, vlans)
+
+	orphans := report.finding(OrphanAddresses)
+	r.Skipped = func(v legacydb.Skip) { orphans.add("%s", describe(v)) }
+	if _, err := r.OrphanAddresses(); err != nil {
+		return nil, err
+	}
+	r.Skipped = nil
+
+	skips, err := r.OrphanSubnets()
+	if err != nil {
+		return nil, err
+	}
+	f := report.finding(OrphanSubnets)
+	for _, v := range skips {
+		f.add("%s", describe(v))
+	}
+	return report, nil
+}
+
+// checkAddresses checks the addresses read from the legacy section with ID
+// section for addresses that are outside of their subnet, or that appear in
+// their subnet more than once.
+func checkAddresses(report *Report, section int, addrs []legacydb.Address) {
+	outside := report.finding(OutsideSubnet)
+	seen := make(map[legacydb.AddressKey]int)
+	var keys []legacydb.AddressKey
+	for _, v := range addrs {
+		_, cidr, err := net.ParseCIDR(v.SubnetCIDR)
+		if ip := net.ParseIP(v.IPAddress); err == nil && !cidr.Contains(ip) {
+			outside.add("%s in subnet %s (section %d)", v.IPAddress, v.SubnetCIDR, section)
+		}
+		k := legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}
+		if seen[k] == 0 {
+			keys = append(keys, k)
+		}
+		seen[k]++
+	}
+	duplicates := report.finding(DuplicateAddresses)
+	for _, k := range keys {
+		if seen[k] > 1 {
+			duplicates.add("%s in subnet %s (section %d): %d rows", k.IPAddress, k.SubnetCIDR, section, seen[k])
+		}
+	}
+}
+
+// checkVLANs reports the VLAN numbers used by more than one of vlans, as read
+// by DuplicateVLANs.
+func checkVLANs(report *Report, vlans []legacydb.VLAN) {
+	ids := make(map[int][]string)
+	var numbers []int
+	for _, v := range vlans {
+		if ids[v.Number] == nil {
+			numbers = append(numbers, v.Number)
+		}
+		ids[v.Number] = append(ids[v.Number], fmt.Sprintf("%d (%s)", v.LegacyID, v.Name))
+	}
+	sort.Ints(numbers)
+	f := report.finding(DuplicateVLANs)
+	for _, n := range numbers {
+		f.add("VLAN %d: legacy IDs %s", n, strings.Join(ids[n], ", "))
+	}
+}
+
+// describe describes a skipped legacy row as an example.
+func describe(v legacydb.Skip) string {
+	s := fmt.Sprintf("%s %s", v.Kind, v.Address)
+	if v.Subnet != "" {
+		if v.Kind == "subnet" {
+			s += "/" + v.Subnet
+		} else {
+			s += " in subnet " + v.Subnet
+		}
+	}
+	var ids []string
+	for _, id := range []string{v.Description, v.Hostname} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(ids, ", "))
+	}
+	return s + ": " + v.Reason
+}
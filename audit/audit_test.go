@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/dump"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+)
+
+const testDump = `
+CREATE TABLE sections (id int(11), masterSection int(11));
+INSERT INTO sections VALUES (1,NULL),(2,1);
+CREATE TABLE vlans (vlanId int(11), name varchar(255), number int(4), description text);
+INSERT INTO vlans VALUES (1,'servers',100,''),(2,'office',100,''),(3,'lab',200,'');
+CREATE TABLE subnets (id int(11), subnet varchar(255), mask varchar(255), sectionId int(10), description text, vlanId int(11));
+INSERT INTO subnets VALUES (1,'167772160','24',1,'ten',1),(2,'167772160','24',2,'ten again',NULL),
+  (3,'42540766411282592856903984951653826560','64',1,'v6',NULL),(4,'3232235776','24',9,'lost',NULL);
+CREATE TABLE devices (id int(11), hostname varchar(32));
+CREATE TABLE ipaddresses (id int(11), subnetId int(11), ip_addr varchar(100), description varchar(64), dns_name varchar(255), note text, switch int(11));
+INSERT INTO ipaddresses VALUES (1,1,'167772161','gw','gw.example.com','',NULL),(2,1,'167772161','gw again','','',NULL),
+  (3,1,'167772417','stray','','',NULL),(4,2,'167772161','gw','','',NULL),(5,1,'bogus','','','',NULL),(6,7,'167772162','orphan','','',NULL);
+`
+
+func TestRun(t *testing.T) {
+	d, err := dump.Parse(strings.NewReader(testDump))
+	if err != nil {
+		t.Fatalf("Error parsing dump: %s", err)
+	}
+	sql.Register("audit-run", &dump.Driver{Dump: d})
+	db, err := sql.Open("audit-run", "")
+	if err != nil {
+		t.Fatalf("Error opening dump DB: %s", err)
+	}
+
+	report, err := Run(&legacydb.Reader{DB: db})
+	if err != nil {
+		t.Fatalf("Error running audit: %s", err)
+	}
+	expected := []*Finding{
+		{Check: InvalidAddresses, Count: 2, Examples: []string{
+			"subnet 42540766411282592856903984951653826560/64 (v6): inconvertible decimal address - possibly not an IPv4 address (strconv.ParseUint: parsing \"42540766411282592856903984951653826560\": value out of range)",
+			"address bogus in subnet 167772160/24: inconvertible decimal IP address - possibly not an IPv4 address (strconv.ParseUint: parsing \"bogus\": invalid syntax)",
+		}},
+		{Check: OutsideSubnet, Count: 1, Examples: []string{"10.0.1.1 in subnet 10.0.0.0/24 (section 1)"}},
+		{Check: DuplicateAddresses, Count: 1, Examples: []string{"10.0.0.1 in subnet 10.0.0.0/24 (section 1): 2 rows"}},
+		{Check: DuplicateVLANs, Count: 1, Examples: []string{"VLAN 100: legacy IDs 1 (servers), 2 (office)"}},
+		{Check: OrphanAddresses, Count: 1, Examples: []string{"address 167772162 in subnet ID 7 (orphan): subnet ID 7 does not exist"}},
+		{Check: OrphanSubnets, Count: 1, Examples: []string{"subnet 3232235776/24 (lost): section ID 9 does not exist"}},
+	}
+	if !reflect.DeepEqual(expected, report.Findings) {
+		for _, v := range report.Findings {
+			t.Logf("%#v", v)
+		}
+		t.Fatal("Unexpected findings")
+	}
+	if report.Problems() != 7 {
+		t.Fatalf("Expected 7 problems, got %d", report.Problems())
+	}
+}
+
+func TestFindingExamples(t *testing.T) {
+	f := &Finding{}
+	for i := 0; i < MaxExamples+2; i++ {
+		f.add("example %d", i)
+	}
+	if f.Count != MaxExamples+2 || len(f.Examples) != MaxExamples {
+		t.Fatalf("Expected %d problems and %d examples, got %d and %d", MaxExamples+2, MaxExamples, f.Count, len(f.Examples))
+	}
+}
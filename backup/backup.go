@@ -0,0 +1,84 @@
+// Package backup provides backups of the new PHPIPAM instance, taken through
+// the API before a migration writes to it, so that operators have a restore
+// point if the migration goes wrong.
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// Backup is the existing content of the new PHPIPAM instance at a point in
+// time.
+type Backup struct {
+	// The time the backup was taken.
+	Taken time.Time `json:"taken"`
+
+	// The sections, VLANs, subnets, and IP addresses in the instance.
+	Sections  []sections.Section  `json:"sections"`
+	VLANs     []vlans.VLAN        `json:"vlans"`
+	Subnets   []subnets.Subnet    `json:"subnets"`
+	Addresses []addresses.Address `json:"addresses"`
+}
+
+// Take backs up the sections, VLANs, subnets, and IP addresses read with r.
+// Subnets are read section by section, and IP addresses subnet by subnet.
+func Take(r ipamsink.TargetReader) (*Backup, error) {
+	out := &Backup{
+		Taken:     time.Now(),
+		Sections:  []sections.Section{},
+		VLANs:     []vlans.VLAN{},
+		Subnets:   []subnets.Subnet{},
+		Addresses: []addresses.Address{},
+	}
+	found, err := r.Sections()
+	if err != nil {
+		return nil, err
+	}
+	out.Sections = append(out.Sections, found...)
+	vs, err := r.VLANs()
+	if err != nil {
+		return nil, err
+	}
+	out.VLANs = append(out.VLANs, vs...)
+	for _, section := range out.Sections {
+		nets, err := r.Subnets(section.ID)
+		if err != nil {
+			return nil, err
+		}
+		out.Subnets = append(out.Subnets, nets...)
+	}
+	for _, subnet := range out.Subnets {
+		addrs, err := r.Addresses(subnet.ID)
+		if err != nil {
+			return nil, err
+		}
+		out.Addresses = append(out.Addresses, addrs...)
+	}
+	return out, nil
+}
+
+// Save writes the backup to path as JSON.
+func (b *Backup) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing backup to %s: %s", path, err)
+	}
+	return nil
+}
+
+// Summary returns a summary of the number of objects in the backup.
+func (b *Backup) Summary() string {
+	return fmt.Sprintf("%d sections, %d VLANs, %d subnets, and %d IP addresses", len(b.Sections), len(b.VLANs), len(b.Subnets), len(b.Addresses))
+}
@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamtest"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+func TestTake(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	ts.AddVLAN(vlans.VLAN{Number: 100, Name: "servers"})
+	net1 := ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1})
+	ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.1.0.0", Mask: 24, SectionID: 1})
+	ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.2.0.0", Mask: 24, SectionID: 9})
+	ts.AddAddress(addresses.Address{IPAddress: "10.0.0.1", SubnetID: net1})
+
+	b, err := Take(ipamsink.New(ts.Session(), retry.Policy{}))
+	if err != nil {
+		t.Fatalf("Error taking backup: %s", err)
+	}
+	if expected, actual := "1 sections, 1 VLANs, 2 subnets, and 1 IP addresses", b.Summary(); expected != actual {
+		t.Fatalf("Expected %s, got %s", expected, actual)
+	}
+	if b.Addresses[0].IPAddress != "10.0.0.1" {
+		t.Fatalf("Expected address 10.0.0.1, got %#v", b.Addresses[0])
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.json")
+	if err := b.Save(path); err != nil {
+		t.Fatalf("Error saving backup: %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading backup: %s", err)
+	}
+	var loaded Backup
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Error parsing backup: %s", err)
+	}
+	if loaded.Summary() != b.Summary() || !loaded.Taken.Equal(b.Taken) {
+		t.Fatalf("Expected %s taken at %s, got %s taken at %s", b.Summary(), b.Taken, loaded.Summary(), loaded.Taken)
+	}
+}
@@ -0,0 +1,85 @@
+// Package cache provides a simple read-through cache for PHPIPAM object IDs.
+//
+// Lookups that miss the cache, or that hit an expired entry, are passed
+// through to a fetch function (usually an API search), and the result is
+// stored for subsequent lookups. This keeps redundant API round trips down,
+// while still picking up changes made to the new PHPIPAM instance during long
+// runs when a TTL is set.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// FetchFunc is a function that looks up the ID for key when it is not in the
+// cache.
+type FetchFunc func(key string) (int, error)
+
+// entry is a single cached value.
+type entry struct {
+	// The cached ID.
+	id int
+
+	// The time the entry was stored.
+	stored time.Time
+}
+
+// Cache is a read-through cache of string keys to IDs.
+type Cache struct {
+	// The time after which entries expire and are fetched again. A TTL of zero
+	// means entries never expire.
+	TTL time.Duration
+
+	// The function used to fetch values on a cache miss.
+	Fetch FetchFunc
+
+	entries map[string]entry
+	mu      sync.Mutex
+
+	// now returns the current time, and is overridden in tests.
+	now func() time.Time
+}
+
+// New returns a new Cache with the supplied TTL and fetch function.
+func New(ttl time.Duration, fetch FetchFunc) *Cache {
+	return &Cache{
+		TTL:     ttl,
+		Fetch:   fetch,
+		entries: make(map[string]entry),
+		now:     time.Now,
+	}
+}
+
+// Get returns the ID for key. If key is not in the cache, or its entry has
+// expired, the ID is fetched and stored. Errors from the fetch function are
+// returned as-is and not cached.
+func (c *Cache) Get(key string) (int, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && (c.TTL == 0 || c.now().Sub(e.stored) < c.TTL) {
+		return e.id, nil
+	}
+
+	id, err := c.Fetch(key)
+	if err != nil {
+		return 0, err
+	}
+	c.Set(key, id)
+	return id, nil
+}
+
+// Set stores the ID for key in the cache.
+func (c *Cache) Set(key string, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry{id: id, stored: c.now()}
+}
+
+// Len returns the number of entries in the cache, including expired ones.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
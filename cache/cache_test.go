@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheReadThrough(t *testing.T) {
+	var fetches int
+	c := New(time.Minute, func(key string) (int, error) {
+		fetches++
+		if key == "missing" {
+			return 0, errors.New("not found")
+		}
+		return fetches, nil
+	})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		id, err := c.Get("100")
+		if err != nil {
+			t.Fatalf("Error getting key: %s", err)
+		}
+		if id != 1 {
+			t.Fatalf("Expected cached ID 1, got %d", id)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	id, err := c.Get("100")
+	if err != nil {
+		t.Fatalf("Error getting key: %s", err)
+	}
+	if id != 2 {
+		t.Fatalf("Expected refreshed ID 2 after expiry, got %d", id)
+	}
+
+	if _, err := c.Get("missing"); err == nil {
+		t.Fatal("Expected error for missing key, got none")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("Expected errors not to be cached, got %d entries", c.Len())
+	}
+}
+
+func TestCacheNoTTL(t *testing.T) {
+	var fetches int
+	c := New(0, func(key string) (int, error) {
+		fetches++
+		return 1, nil
+	})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Get("foo")
+	now = now.Add(24 * time.Hour)
+	c.Get("foo")
+	if fetches != 1 {
+		t.Fatalf("Expected 1 fetch with no TTL, got %d", fetches)
+	}
+}
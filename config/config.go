@@ -0,0 +1,69 @@
+// Package config provides the migrator's configuration file.
+//
+// The configuration file is YAML, and holds options that are too complex to
+// supply on the command line.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config represents the migrator's configuration file.
+type Config struct {
+	// The pipeline stages to run, in order. Stages not listed are disabled.
+	// Defaults to pipeline.DefaultStages when empty.
+	Stages []string `yaml:"stages"`
+
+	// Rules that route the legacy subnets within a network to a section in
+	// the new PHPIPAM instance, instead of the section that their legacy
+	// section is migrated to.
+	SectionRoutes []SectionRoute `yaml:"section_routes"`
+}
+
+// SectionRoute routes the legacy subnets within an IPv4 network to a section,
+// either named or given by ID.
+type SectionRoute struct {
+	// The network in CIDR notation (ie: 10.0.0.0/8).
+	Subnet string `yaml:"subnet"`
+
+	// The name of the section.
+	Section string `yaml:"section"`
+
+	// The ID of the section.
+	SectionID int `yaml:"section_id"`
+}
+
+// validate checks that the section routes are well formed.
+func (c *Config) validate() error {
+	for _, v := range c.SectionRoutes {
+		ip, _, err := net.ParseCIDR(v.Subnet)
+		if err != nil || ip.To4() == nil {
+			return fmt.Errorf("section route subnet %q must be an IPv4 CIDR", v.Subnet)
+		}
+		if (v.Section == "") == (v.SectionID == 0) {
+			return fmt.Errorf("section route for %s must have exactly one of section or section_id", v.Subnet)
+		}
+	}
+	return nil
+}
+
+// Load reads and parses the configuration file at path. Unknown keys are
+// treated as errors, so that typos do not go unnoticed.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %s", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("error in config file %s: %s", path, err)
+	}
+	return &cfg, nil
+}
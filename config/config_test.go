@@ -0,0 +1,56 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("stages:\n  - fetch\n  - validate\n"), 0600)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	expected := []string{"fetch", "validate"}
+	if !reflect.DeepEqual(expected, cfg.Stages) {
+		t.Fatalf("Expected %v, got %v", expected, cfg.Stages)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	ioutil.WriteFile(bad, []byte("stagez:\n  - fetch\n"), 0600)
+	if _, err := Load(bad); err == nil {
+		t.Fatal("Expected error loading config with unknown key, got none")
+	}
+}
+
+func TestLoadSectionRoutes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("section_routes:\n  - subnet: 10.0.0.0/8\n    section: Ops\n  - subnet: 192.168.0.0/16\n    section_id: 4\n"), 0600)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	expected := []SectionRoute{{Subnet: "10.0.0.0/8", Section: "Ops"}, {Subnet: "192.168.0.0/16", SectionID: 4}}
+	if !reflect.DeepEqual(expected, cfg.SectionRoutes) {
+		t.Fatalf("Expected %v, got %v", expected, cfg.SectionRoutes)
+	}
+
+	for _, v := range []string{
+		"section_routes:\n  - subnet: 10.0.0.0\n    section: Ops\n",
+		"section_routes:\n  - subnet: 2001:db8::/32\n    section: Ops\n",
+		"section_routes:\n  - subnet: 10.0.0.0/8\n",
+		"section_routes:\n  - subnet: 10.0.0.0/8\n    section: Ops\n    section_id: 4\n",
+	} {
+		ioutil.WriteFile(path, []byte(v), 0600)
+		if _, err := Load(path); err == nil {
+			t.Fatalf("Expected error loading config %q, got none", v)
+		}
+	}
+}
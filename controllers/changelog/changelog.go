@@ -0,0 +1,47 @@
+// Package changelog provides the type of a PHPIPAM changelog entry.
+//
+// The PHPIPAM API has no controller for the changelog, so unlike the other
+// packages here there is no Controller, and changelog entries can only be
+// written straight to the database of a new PHPIPAM instance.
+package changelog
+
+// The types of objects that changelog entries are migrated for.
+const (
+	SubnetType  = "subnet"
+	AddressType = "ip_addr"
+)
+
+// Entry represents a PHPIPAM changelog entry about a subnet or an address.
+//
+// PHPIPAM refers to the changed object and the user who changed it by ID.
+// Entries instead refer to the changed address by IP address, and the user by
+// username, so that they can be written before the IDs are known.
+type Entry struct {
+	// The changelog entry ID.
+	ID int `json:"cid,string,omitempty"`
+
+	// The type of object changed: subnet or ip_addr.
+	Type string `json:"ctype,omitempty"`
+
+	// The ID of the subnet changed, or of the subnet of the address changed.
+	SubnetID int `json:"-"`
+
+	// The address changed, or blank if the entry is about a subnet.
+	IPAddress string `json:"-"`
+
+	// The username of the user who made the change, or blank if the user no
+	// longer exists.
+	Username string `json:"-"`
+
+	// The action taken: add, edit, delete, truncate, resize, or perm_change.
+	Action string `json:"caction,omitempty"`
+
+	// The result of the action: success or error.
+	Result string `json:"cresult,omitempty"`
+
+	// The date and time of the change, as YYYY-MM-DD HH:MM:SS.
+	Date string `json:"cdate,omitempty"`
+
+	// The differences made by the change, as recorded by PHPIPAM.
+	Diff string `json:"cdiff,omitempty"`
+}
@@ -0,0 +1,111 @@
+// Package devices provides types and methods for working with the devices and
+// device types subcontrollers of the tools controller.
+//
+// This controller is not yet available in the PHPIPAM SDK, and so it is
+// implemented here, following the SDK's conventions.
+package devices
+
+import (
+	"fmt"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// Device represents a PHPIPAM device.
+type Device struct {
+	// The device ID.
+	ID int `json:"id,string,omitempty"`
+
+	// The device's hostname.
+	Hostname string `json:"hostname,omitempty"`
+
+	// The device's IP address.
+	IPAddress string `json:"ip_addr,omitempty"`
+
+	// The ID of the device type.
+	Type int `json:"type,string,omitempty"`
+
+	// The device vendor.
+	Vendor string `json:"vendor,omitempty"`
+
+	// The device model.
+	Model string `json:"model,omitempty"`
+
+	// A detailed description of the device.
+	Description string `json:"description,omitempty"`
+
+	// A semicolon-separated list of section IDs that the device belongs to.
+	Sections string `json:"sections,omitempty"`
+
+	// The date of the last edit to this resource.
+	EditDate string `json:"editDate,omitempty"`
+}
+
+// DeviceType represents a PHPIPAM device type.
+type DeviceType struct {
+	// The device type ID.
+	ID int `json:"tid,string,omitempty"`
+
+	// The device type's name.
+	Name string `json:"tname,omitempty"`
+
+	// A detailed description of the device type.
+	Description string `json:"tdescription,omitempty"`
+}
+
+// Controller is the base client for the devices controller.
+type Controller struct {
+	client.Client
+}
+
+// NewController returns a new instance of the client for the devices
+// controller.
+func NewController(sess *session.Session) *Controller {
+	c := &Controller{
+		Client: *client.NewClient(sess),
+	}
+	return c
+}
+
+// CreateDevice creates a device by sending a POST request.
+func (c *Controller) CreateDevice(in Device) (message string, err error) {
+	err = c.SendRequest("POST", "/tools/devices/", &in, &message)
+	return
+}
+
+// GetDeviceByID GETs a device via its ID.
+func (c *Controller) GetDeviceByID(id int) (out Device, err error) {
+	err = c.SendRequest("GET", fmt.Sprintf("/tools/devices/%d/", id), &struct{}{}, &out)
+	return
+}
+
+// ListDevices GETs all devices.
+func (c *Controller) ListDevices() (out []Device, err error) {
+	err = c.SendRequest("GET", "/tools/devices/", &struct{}{}, &out)
+	return
+}
+
+// UpdateDevice updates a device by sending a PATCH request.
+func (c *Controller) UpdateDevice(in Device) (message string, err error) {
+	err = c.SendRequest("PATCH", "/tools/devices/", &in, &message)
+	return
+}
+
+// DeleteDevice deletes a device by its ID.
+func (c *Controller) DeleteDevice(id int) (message string, err error) {
+	err = c.SendRequest("DELETE", fmt.Sprintf("/tools/devices/%d/", id), &struct{}{}, &message)
+	return
+}
+
+// CreateDeviceType creates a device type by sending a POST request.
+func (c *Controller) CreateDeviceType(in DeviceType) (message string, err error) {
+	err = c.SendRequest("POST", "/tools/device_types/", &in, &message)
+	return
+}
+
+// ListDeviceTypes GETs all device types.
+func (c *Controller) ListDeviceTypes() (out []DeviceType, err error) {
+	err = c.SendRequest("GET", "/tools/device_types/", &struct{}{}, &out)
+	return
+}
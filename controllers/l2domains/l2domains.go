@@ -0,0 +1,71 @@
+// Package l2domains provides types and methods for working with the L2
+// domains controller.
+//
+// This controller is not yet available in the PHPIPAM SDK, and so it is
+// implemented here, following the SDK's conventions.
+package l2domains
+
+import (
+	"fmt"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// Domain represents a PHPIPAM L2 domain, which groups VLANs so that their
+// numbers only need to be unique within a domain.
+type Domain struct {
+	// The L2 domain ID.
+	ID int `json:"id,string,omitempty"`
+
+	// The name of the L2 domain.
+	Name string `json:"name,omitempty"`
+
+	// A detailed description of the L2 domain.
+	Description string `json:"description,omitempty"`
+
+	// A semicolon-separated list of section IDs that the L2 domain can be used
+	// in.
+	Permissions string `json:"permissions,omitempty"`
+
+	// The date of the last edit to this resource.
+	EditDate string `json:"editDate,omitempty"`
+}
+
+// Controller is the base client for the L2 domains controller.
+type Controller struct {
+	client.Client
+}
+
+// NewController returns a new instance of the client for the L2 domains
+// controller.
+func NewController(sess *session.Session) *Controller {
+	c := &Controller{
+		Client: *client.NewClient(sess),
+	}
+	return c
+}
+
+// CreateDomain creates an L2 domain by sending a POST request.
+func (c *Controller) CreateDomain(in Domain) (message string, err error) {
+	err = c.SendRequest("POST", "/l2domains/", &in, &message)
+	return
+}
+
+// GetDomainByID GETs an L2 domain via its ID.
+func (c *Controller) GetDomainByID(id int) (out Domain, err error) {
+	err = c.SendRequest("GET", fmt.Sprintf("/l2domains/%d/", id), &struct{}{}, &out)
+	return
+}
+
+// ListDomains GETs all L2 domains.
+func (c *Controller) ListDomains() (out []Domain, err error) {
+	err = c.SendRequest("GET", "/l2domains/", &struct{}{}, &out)
+	return
+}
+
+// DeleteDomain deletes an L2 domain by its ID.
+func (c *Controller) DeleteDomain(id int) (message string, err error) {
+	err = c.SendRequest("DELETE", fmt.Sprintf("/l2domains/%d/", id), &struct{}{}, &message)
+	return
+}
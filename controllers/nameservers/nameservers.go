@@ -0,0 +1,75 @@
+// Package nameservers provides types and methods for working with the
+// nameservers subcontroller of the tools controller.
+//
+// This controller is not yet available in the PHPIPAM SDK, and so it is
+// implemented here, following the SDK's conventions.
+package nameservers
+
+import (
+	"fmt"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// Nameserver represents a PHPIPAM nameserver set, which subnets reference to
+// name the DNS servers used in them.
+type Nameserver struct {
+	// The nameserver set ID.
+	ID int `json:"id,string,omitempty"`
+
+	// The name of the nameserver set.
+	Name string `json:"name,omitempty"`
+
+	// A semicolon-separated list of the addresses of the nameservers in the
+	// set.
+	Addresses string `json:"namesrv1,omitempty"`
+
+	// A detailed description of the nameserver set.
+	Description string `json:"description,omitempty"`
+
+	// A semicolon-separated list of section IDs that the nameserver set can be
+	// used in.
+	Permissions string `json:"permissions,omitempty"`
+
+	// The date of the last edit to this resource.
+	EditDate string `json:"editDate,omitempty"`
+}
+
+// Controller is the base client for the nameservers controller.
+type Controller struct {
+	client.Client
+}
+
+// NewController returns a new instance of the client for the nameservers
+// controller.
+func NewController(sess *session.Session) *Controller {
+	c := &Controller{
+		Client: *client.NewClient(sess),
+	}
+	return c
+}
+
+// CreateNameserver creates a nameserver set by sending a POST request.
+func (c *Controller) CreateNameserver(in Nameserver) (message string, err error) {
+	err = c.SendRequest("POST", "/tools/nameservers/", &in, &message)
+	return
+}
+
+// GetNameserverByID GETs a nameserver set via its ID.
+func (c *Controller) GetNameserverByID(id int) (out Nameserver, err error) {
+	err = c.SendRequest("GET", fmt.Sprintf("/tools/nameservers/%d/", id), &struct{}{}, &out)
+	return
+}
+
+// ListNameservers GETs all nameserver sets.
+func (c *Controller) ListNameservers() (out []Nameserver, err error) {
+	err = c.SendRequest("GET", "/tools/nameservers/", &struct{}{}, &out)
+	return
+}
+
+// DeleteNameserver deletes a nameserver set by its ID.
+func (c *Controller) DeleteNameserver(id int) (message string, err error) {
+	err = c.SendRequest("DELETE", fmt.Sprintf("/tools/nameservers/%d/", id), &struct{}{}, &message)
+	return
+}
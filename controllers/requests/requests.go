@@ -0,0 +1,34 @@
+// Package requests provides the type of a PHPIPAM IP request.
+//
+// The PHPIPAM API has no controller for IP requests, so unlike the other
+// packages here there is no Controller, and requests can only be written
+// straight to the database of a new PHPIPAM instance.
+package requests
+
+// Request represents a pending PHPIPAM IP request.
+type Request struct {
+	// The request ID.
+	ID int `json:"id,string,omitempty"`
+
+	// The ID of the subnet the address is requested in.
+	SubnetID int `json:"subnetId,string,omitempty"`
+
+	// The requested IP address, or blank if the requester left the choice of
+	// address to the administrator.
+	IPAddress string `json:"ip_addr,omitempty"`
+
+	// The description of the requested address.
+	Description string `json:"description,omitempty"`
+
+	// The hostname of the requested address.
+	Hostname string `json:"hostname,omitempty"`
+
+	// The owner of the requested address.
+	Owner string `json:"owner,omitempty"`
+
+	// The email address of the requester.
+	Requester string `json:"requester,omitempty"`
+
+	// The requester's comment on the request.
+	Comment string `json:"comment,omitempty"`
+}
@@ -0,0 +1,76 @@
+// Package sections provides types and methods for working with the sections
+// controller.
+//
+// This controller is not yet available in the PHPIPAM SDK, and so it is
+// implemented here, following the SDK's conventions.
+package sections
+
+import (
+	"fmt"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// Section represents a PHPIPAM section, which holds subnets.
+type Section struct {
+	// The section ID.
+	ID int `json:"id,string,omitempty"`
+
+	// The name of the section.
+	Name string `json:"name,omitempty"`
+
+	// A detailed description of the section.
+	Description string `json:"description,omitempty"`
+
+	// The ID of the section that this section is a subsection of, or 0 if it
+	// is a top-level section.
+	MasterSection int `json:"masterSection,string,omitempty"`
+
+	// The date of the last edit to this resource.
+	EditDate string `json:"editDate,omitempty"`
+}
+
+// Controller is the base client for the sections controller.
+type Controller struct {
+	client.Client
+}
+
+// NewController returns a new instance of the client for the sections
+// controller.
+func NewController(sess *session.Session) *Controller {
+	c := &Controller{
+		Client: *client.NewClient(sess),
+	}
+	return c
+}
+
+// CreateSection creates a section by sending a POST request.
+func (c *Controller) CreateSection(in Section) (message string, err error) {
+	err = c.SendRequest("POST", "/sections/", &in, &message)
+	return
+}
+
+// UpdateSection updates a section by sending a PATCH request.
+func (c *Controller) UpdateSection(in Section) (message string, err error) {
+	err = c.SendRequest("PATCH", "/sections/", &in, &message)
+	return
+}
+
+// GetSectionByID GETs a section via its ID.
+func (c *Controller) GetSectionByID(id int) (out Section, err error) {
+	err = c.SendRequest("GET", fmt.Sprintf("/sections/%d/", id), &struct{}{}, &out)
+	return
+}
+
+// ListSections GETs all sections.
+func (c *Controller) ListSections() (out []Section, err error) {
+	err = c.SendRequest("GET", "/sections/", &struct{}{}, &out)
+	return
+}
+
+// DeleteSection deletes a section by its ID.
+func (c *Controller) DeleteSection(id int) (message string, err error) {
+	err = c.SendRequest("DELETE", fmt.Sprintf("/sections/%d/", id), &struct{}{}, &message)
+	return
+}
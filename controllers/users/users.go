@@ -0,0 +1,46 @@
+// Package users provides the types of PHPIPAM users and groups.
+//
+// The PHPIPAM API has no controller for creating users or groups, so unlike
+// the other packages here there is no Controller, and users and groups can
+// only be written straight to the database of a new PHPIPAM instance.
+package users
+
+// User represents a PHPIPAM user.
+type User struct {
+	// The user ID.
+	ID int `json:"id,string,omitempty"`
+
+	// The user's login name.
+	Username string `json:"username,omitempty"`
+
+	// The user's full name.
+	RealName string `json:"real_name,omitempty"`
+
+	// The user's email address.
+	Email string `json:"email,omitempty"`
+
+	// The user's role: Administrator or User.
+	Role string `json:"role,omitempty"`
+
+	// The IDs of the groups the user belongs to. PHPIPAM stores these as a
+	// JSON object keyed by group ID.
+	Groups []int `json:"-"`
+
+	// The user's password in clear text, which is hashed when the user is
+	// written, or blank to leave the user without a password until one is
+	// set by an administrator. Either way, the user is made to change their
+	// password at their next login.
+	Password string `json:"-"`
+}
+
+// Group represents a PHPIPAM user group.
+type Group struct {
+	// The group ID.
+	ID int `json:"g_id,string,omitempty"`
+
+	// The group's name.
+	Name string `json:"g_name,omitempty"`
+
+	// A description of the group.
+	Description string `json:"g_desc,omitempty"`
+}
@@ -0,0 +1,76 @@
+// Package vrfs provides types and methods for working with the VRF controller.
+//
+// This controller is not yet available in the PHPIPAM SDK, and so it is
+// implemented here, following the SDK's conventions.
+package vrfs
+
+import (
+	"fmt"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// VRF represents a PHPIPAM VRF.
+type VRF struct {
+	// The VRF ID.
+	ID int `json:"vrfId,string,omitempty"`
+
+	// The VRF's name.
+	Name string `json:"name,omitempty"`
+
+	// The VRF's route distinguisher.
+	RD string `json:"rd,omitempty"`
+
+	// A detailed description of the VRF.
+	Description string `json:"description,omitempty"`
+
+	// A semicolon-separated list of section IDs that the VRF belongs to.
+	Sections string `json:"sections,omitempty"`
+
+	// The date of the last edit to this resource.
+	EditDate string `json:"editDate,omitempty"`
+}
+
+// Controller is the base client for the VRF controller.
+type Controller struct {
+	client.Client
+}
+
+// NewController returns a new instance of the client for the VRF controller.
+func NewController(sess *session.Session) *Controller {
+	c := &Controller{
+		Client: *client.NewClient(sess),
+	}
+	return c
+}
+
+// CreateVRF creates a VRF by sending a POST request.
+func (c *Controller) CreateVRF(in VRF) (message string, err error) {
+	err = c.SendRequest("POST", "/vrf/", &in, &message)
+	return
+}
+
+// GetVRFByID GETs a VRF via its ID.
+func (c *Controller) GetVRFByID(id int) (out VRF, err error) {
+	err = c.SendRequest("GET", fmt.Sprintf("/vrf/%d/", id), &struct{}{}, &out)
+	return
+}
+
+// ListVRFs GETs all VRFs.
+func (c *Controller) ListVRFs() (out []VRF, err error) {
+	err = c.SendRequest("GET", "/vrf/", &struct{}{}, &out)
+	return
+}
+
+// UpdateVRF updates a VRF by sending a PATCH request.
+func (c *Controller) UpdateVRF(in VRF) (message string, err error) {
+	err = c.SendRequest("PATCH", "/vrf/", &in, &message)
+	return
+}
+
+// DeleteVRF deletes a VRF by its ID.
+func (c *Controller) DeleteVRF(id int) (message string, err error) {
+	err = c.SendRequest("DELETE", fmt.Sprintf("/vrf/%d/", id), &struct{}{}, &message)
+	return
+}
@@ -0,0 +1,370 @@
+// Package csvsource reads the VLANs, subnets, and addresses to migrate from
+// CSV files, so that data exported from the legacy DB can be fixed by hand
+// before it is migrated.
+//
+// A source is a directory holding the following files. Each file must start
+// with a header row naming its columns, which can be in any order. Columns
+// marked as optional can be left out entirely, or left blank on any row.
+//
+//	vlans.csv
+//	  number       The VLAN number.
+//	  name         The VLAN name.
+//	  description  The VLAN description (optional).
+//
+//	subnets.csv
+//	  subnet       The subnet, in CIDR notation (ie: 10.0.0.0/24).
+//	  description  The subnet description (optional).
+//	  vlan         The number of the VLAN the subnet belongs to (optional).
+//	  section      The legacy section ID of the subnet, used with -sections
+//	               (optional, defaults to 1).
+//
+//	addresses.csv
+//	  ip           The IPv4 address.
+//	  subnet       The CIDR of the subnet the address belongs to, which must be
+//	               in subnets.csv.
+//	  section      The legacy section ID of the subnet, which only needs to be
+//	               supplied if the subnet is in more than one section
+//	               (optional).
+//	  hostname     The hostname of the address (optional).
+//	  description  The address description (optional).
+//	  note         The address note (optional).
+//	  owner        The owner of the address (optional).
+//	  switch       The name of the switch the address is connected to
+//	               (optional).
+//	  port         The switch port the address is connected to (optional).
+//	  mac          The MAC address of the address (optional).
+//	  state        The legacy state of the address: 0 (offline), 1
+//	               (active), 2 (reserved), or 3 (DHCP) (optional).
+//
+// The files are converted to the tables of a legacy DB, which can be read with
+// the database/sql driver in the dump package.
+package csvsource
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/dump"
+)
+
+// The names of the files in a source directory.
+const (
+	VLANsFile     = "vlans.csv"
+	SubnetsFile   = "subnets.csv"
+	AddressesFile = "addresses.csv"
+)
+
+// Load reads the CSV files in dir, and returns them as the tables of a legacy
+// DB.
+func Load(dir string) (*dump.Dump, error) {
+	d := &dump.Dump{Tables: map[string]*dump.Table{
+		"vlans": {
+			Name:    "vlans",
+			Columns: []string{"vlanId", "name", "number", "description"},
+		},
+		"subnets": {
+			Name:    "subnets",
+			Columns: []string{"id", "subnet", "mask", "sectionId", "description", "vlanId", "vrfId"},
+		},
+		"ipaddresses": {
+			Name:    "ipaddresses",
+			Columns: []string{"id", "subnetId", "ip_addr", "description", "dns_name", "owner", "switch", "port", "note", "state", "mac"},
+		},
+		// Users, groups, VRFs, IP requests, and the changelog are not read
+		// from CSV, but are expected in a legacy DB.
+		"users": {
+			Name:    "users",
+			Columns: []string{"id", "username", "real_name", "email", "role", "groups"},
+		},
+		"userGroups": {
+			Name:    "userGroups",
+			Columns: []string{"g_id", "g_name", "g_desc"},
+		},
+		"vrf": {
+			Name:    "vrf",
+			Columns: []string{"vrfId", "name", "rd", "description"},
+		},
+		"requests": {
+			Name:    "requests",
+			Columns: []string{"id", "subnetId", "ip_addr", "description", "dns_name", "owner", "requester", "comment", "processed"},
+		},
+		"changelog": {
+			Name:    "changelog",
+			Columns: []string{"cid", "ctype", "coid", "cuser", "caction", "cresult", "cdate", "cdiff"},
+		},
+	}}
+	l := &loader{
+		dump:       d,
+		vlanIDs:    make(map[int]string),
+		subnetIDs:  make(map[string]string),
+		subnetKeys: make(map[string][]string),
+	}
+	for _, f := range []struct {
+		name string
+		load func(*file) error
+	}{
+		{VLANsFile, l.vlan},
+		{SubnetsFile, l.subnet},
+		{AddressesFile, l.address},
+	} {
+		if err := readFile(filepath.Join(dir, f.name), f.load); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// loader converts CSV rows to legacy DB rows.
+type loader struct {
+	dump *dump.Dump
+
+	// The IDs of the VLANs, keyed by number.
+	vlanIDs map[int]string
+
+	// The IDs of the subnets, keyed by section ID and CIDR (ie: 1/10.0.0.0/8).
+	subnetIDs map[string]string
+
+	// The section and CIDR keys of the subnets, keyed by CIDR.
+	subnetKeys map[string][]string
+}
+
+// add adds a row to the named table, and returns its ID.
+func (l *loader) add(table string, row ...*string) string {
+	t := l.dump.Tables[table]
+	id := strconv.Itoa(len(t.Rows) + 1)
+	t.Rows = append(t.Rows, append([]*string{&id}, row...))
+	return id
+}
+
+// vlan adds a row of vlans.csv.
+func (l *loader) vlan(f *file) error {
+	number, err := f.int("number", true)
+	if err != nil {
+		return err
+	}
+	name, err := f.get("name", true)
+	if err != nil {
+		return err
+	}
+	if _, ok := l.vlanIDs[number]; ok {
+		return f.errorf("duplicate VLAN %d", number)
+	}
+	description, _ := f.get("description", false)
+	l.vlanIDs[number] = l.add("vlans", &name, str(strconv.Itoa(number)), &description)
+	return nil
+}
+
+// subnet adds a row of subnets.csv.
+func (l *loader) subnet(f *file) error {
+	addr, mask, err := f.cidr("subnet")
+	if err != nil {
+		return err
+	}
+	section, err := f.int("section", false)
+	if err != nil {
+		return err
+	}
+	if section == 0 {
+		section = 1
+	}
+	vlan, err := f.int("vlan", false)
+	if err != nil {
+		return err
+	}
+	var vlanID *string
+	if vlan != 0 {
+		id, ok := l.vlanIDs[vlan]
+		if !ok {
+			return f.errorf("VLAN %d is not in %s", vlan, VLANsFile)
+		}
+		vlanID = &id
+	}
+	description, _ := f.get("description", false)
+
+	cidr := fmt.Sprintf("%s/%d", addr, mask)
+	key := fmt.Sprintf("%d/%s", section, cidr)
+	if _, ok := l.subnetIDs[key]; ok {
+		return f.errorf("duplicate subnet %s in section %d", cidr, section)
+	}
+	l.subnetIDs[key] = l.add("subnets", str(decimal(addr)), str(strconv.Itoa(mask)), str(strconv.Itoa(section)), &description, vlanID, nil)
+	l.subnetKeys[cidr] = append(l.subnetKeys[cidr], key)
+	return nil
+}
+
+// address adds a row of addresses.csv.
+func (l *loader) address(f *file) error {
+	ip, err := f.get("ip", true)
+	if err != nil {
+		return err
+	}
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return f.errorf("invalid IPv4 address %q", ip)
+	}
+	addr, mask, err := f.cidr("subnet")
+	if err != nil {
+		return err
+	}
+	cidr := fmt.Sprintf("%s/%d", addr, mask)
+	if _, n, _ := net.ParseCIDR(cidr); !n.Contains(parsed) {
+		return f.errorf("address %s is not in subnet %s", ip, cidr)
+	}
+	section, err := f.int("section", false)
+	if err != nil {
+		return err
+	}
+	var subnetID string
+	switch keys := l.subnetKeys[cidr]; {
+	case section != 0:
+		id, ok := l.subnetIDs[fmt.Sprintf("%d/%s", section, cidr)]
+		if !ok {
+			return f.errorf("subnet %s in section %d is not in %s", cidr, section, SubnetsFile)
+		}
+		subnetID = id
+	case len(keys) == 0:
+		return f.errorf("subnet %s is not in %s", cidr, SubnetsFile)
+	case len(keys) > 1:
+		return f.errorf("subnet %s is in more than one section, so the address's section must be supplied", cidr)
+	default:
+		subnetID = l.subnetIDs[keys[0]]
+	}
+
+	description, _ := f.get("description", false)
+	hostname, _ := f.get("hostname", false)
+	note, _ := f.get("note", false)
+	var owner *string
+	if v, _ := f.get("owner", false); v != "" {
+		owner = &v
+	}
+	var switchName, port *string
+	if v, _ := f.get("switch", false); v != "" {
+		switchName = &v
+	}
+	if v, _ := f.get("port", false); v != "" {
+		port = &v
+	}
+	var state *string
+	if v, _ := f.get("state", false); v != "" {
+		state = &v
+	}
+	var mac *string
+	if v, _ := f.get("mac", false); v != "" {
+		mac = &v
+	}
+	l.add("ipaddresses", &subnetID, str(decimal(parsed)), &description, &hostname, owner, switchName, port, &note, state, mac)
+	return nil
+}
+
+// file is a CSV file being read, positioned at a row.
+type file struct {
+	name    string
+	line    int
+	columns map[string]int
+	row     []string
+}
+
+// readFile reads the CSV file at path, calling load with each row.
+func readFile(path string, load func(*file) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := csv.NewReader(in)
+	r.TrimLeadingSpace = true
+	f := &file{name: filepath.Base(path), columns: make(map[string]int)}
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("error reading header of %s: %s", f.name, err)
+	}
+	for i, v := range header {
+		// Spreadsheets often save CSV files with a byte order mark.
+		if i == 0 {
+			v = strings.TrimPrefix(v, "\ufeff")
+		}
+		f.columns[strings.ToLower(strings.TrimSpace(v))] = i
+	}
+	for {
+		if f.row, err = r.Read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading %s: %s", f.name, err)
+		}
+		f.line, _ = r.FieldPos(0)
+		if err := load(f); err != nil {
+			return err
+		}
+	}
+}
+
+// errorf returns an error for the current row.
+func (f *file) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s line %d: %s", f.name, f.line, fmt.Sprintf(format, args...))
+}
+
+// get returns the trimmed value of a column in the current row. An error is
+// returned if the column is required, and is missing or blank.
+func (f *file) get(column string, required bool) (string, error) {
+	i, ok := f.columns[column]
+	if !ok {
+		if required {
+			return "", fmt.Errorf("%s has no %s column", f.name, column)
+		}
+		return "", nil
+	}
+	v := strings.TrimSpace(f.row[i])
+	if v == "" && required {
+		return "", f.errorf("%s is required", column)
+	}
+	return v, nil
+}
+
+// int returns the value of an integer column in the current row, or 0 if it
+// is optional and blank.
+func (f *file) int(column string, required bool) (int, error) {
+	v, err := f.get(column, required)
+	if err != nil || v == "" {
+		return 0, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, f.errorf("invalid %s %q", column, v)
+	}
+	return n, nil
+}
+
+// cidr returns the network address and mask of a required IPv4 CIDR column
+// in the current row.
+func (f *file) cidr(column string) (net.IP, int, error) {
+	v, err := f.get(column, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	ip, n, err := net.ParseCIDR(v)
+	if err != nil || ip.To4() == nil {
+		return nil, 0, f.errorf("invalid IPv4 %s %q", column, v)
+	}
+	if !ip.Equal(n.IP) {
+		return nil, 0, f.errorf("%s %s is not a network address (did you mean %s?)", column, v, n)
+	}
+	mask, _ := n.Mask.Size()
+	return n.IP.To4(), mask, nil
+}
+
+// decimal returns an IPv4 address in the decimal format of the legacy DB.
+func decimal(ip net.IP) string {
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip.To4())), 10)
+}
+
+// str returns a pointer to v.
+func str(v string) *string {
+	return &v
+}
@@ -0,0 +1,157 @@
+package csvsource
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/dump"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+)
+
+// writeSource writes a source directory with the supplied file contents, and
+// returns its path.
+func writeSource(t *testing.T, vlans, subnets, addresses string) string {
+	dir, err := ioutil.TempDir("", "csvsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{VLANsFile: vlans, SubnetsFile: subnets, AddressesFile: addresses} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeSource(t,
+		"\ufeffnumber,name,description\n100,servers,Server VLAN\n200,users,\n",
+		"subnet,vlan,description,section\n10.0.0.0/8,,parent,\n10.1.0.0/24,100,child,1\n10.1.0.0/24,200,\"other, section\",2\n",
+		"ip,subnet,section,hostname,switch,port,note,state,mac,owner\n10.1.0.1,10.1.0.0/24,1,gw.example.com,sw1,Gi0/1,\"line 1\nline 2\",2,001a.2b3c.4d5e,netops\n10.0.0.5,10.0.0.0/8,,host.example.com,,,,,,\n",
+	)
+	defer os.RemoveAll(dir)
+
+	d, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Error loading source: %s", err)
+	}
+	sql.Register("csvsource-test", &dump.Driver{Dump: d})
+	db, err := sql.Open("csvsource-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &legacydb.Reader{DB: db, SectionID: 1}
+	lans, err := r.VLANs()
+	if err != nil {
+		t.Fatalf("Error reading VLANs: %s", err)
+	}
+	if len(lans) != 2 || lans[0].Number != 100 || lans[0].Description != "Server VLAN" {
+		t.Fatalf("Unexpected VLANs %#v", lans)
+	}
+
+	nets, _, err := r.Subnets()
+	if err != nil {
+		t.Fatalf("Error reading subnets: %s", err)
+	}
+	if len(nets) != 2 || nets[0].SubnetAddress != "10.0.0.0" || nets[0].Mask != 8 || nets[1].VLANNumber != 100 {
+		t.Fatalf("Unexpected subnets %#v", nets)
+	}
+
+	addrs, _, err := r.Addresses()
+	if err != nil {
+		t.Fatalf("Error reading addresses: %s", err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("Expected 2 addresses, got %#v", addrs)
+	}
+	if a := addrs[0]; a.IPAddress != "10.1.0.1" || a.SubnetCIDR != "10.1.0.0/24" || a.Hostname != "gw.example.com" || a.Switch != "sw1" || a.Note != "line 1\nline 2" {
+		t.Fatalf("Unexpected address %#v", a)
+	}
+	ports, err := r.AddressPorts()
+	if err != nil {
+		t.Fatalf("Error reading address ports: %s", err)
+	}
+	if expected := map[legacydb.AddressKey]string{{IPAddress: "10.1.0.1", SubnetCIDR: "10.1.0.0/24"}: "Gi0/1"}; !reflect.DeepEqual(expected, ports) {
+		t.Fatalf("Expected ports %v, got %v", expected, ports)
+	}
+	states, err := r.AddressStates()
+	if err != nil {
+		t.Fatalf("Error reading address states: %s", err)
+	}
+	if expected := map[legacydb.AddressKey]string{{IPAddress: "10.1.0.1", SubnetCIDR: "10.1.0.0/24"}: "2"}; !reflect.DeepEqual(expected, states) {
+		t.Fatalf("Expected states %v, got %v", expected, states)
+	}
+	macs, err := r.AddressMACs()
+	if err != nil {
+		t.Fatalf("Error reading MAC addresses: %s", err)
+	}
+	if expected := map[legacydb.AddressKey]string{{IPAddress: "10.1.0.1", SubnetCIDR: "10.1.0.0/24"}: "001a.2b3c.4d5e"}; !reflect.DeepEqual(expected, macs) {
+		t.Fatalf("Expected MAC addresses %v, got %v", expected, macs)
+	}
+	owners, err := r.AddressOwners()
+	if err != nil {
+		t.Fatalf("Error reading address owners: %s", err)
+	}
+	if expected := map[legacydb.AddressKey]string{{IPAddress: "10.1.0.1", SubnetCIDR: "10.1.0.0/24"}: "netops"}; !reflect.DeepEqual(expected, owners) {
+		t.Fatalf("Expected owners %v, got %v", expected, owners)
+	}
+
+	r.SectionID = 2
+	if nets, _, err = r.Subnets(); err != nil || len(nets) != 1 || nets[0].Description != "other, section" || nets[0].VLANNumber != 200 {
+		t.Fatalf("Unexpected subnets in section 2 %#v (%v)", nets, err)
+	}
+	if addrs, _, err = r.Addresses(); err != nil || len(addrs) != 0 {
+		t.Fatalf("Expected no addresses in section 2, got %#v (%v)", addrs, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		vlans, subnets, addresses string
+		expected                  string
+	}{
+		{
+			vlans:    "name\nservers\n",
+			expected: "vlans.csv has no number column",
+		},
+		{
+			vlans:    "number,name\n100,servers\n100,servers\n",
+			expected: "vlans.csv line 3: duplicate VLAN 100",
+		},
+		{
+			vlans:    "number,name\n",
+			subnets:  "subnet,vlan\n10.0.0.1/8,\n",
+			expected: "subnets.csv line 2: subnet 10.0.0.1/8 is not a network address (did you mean 10.0.0.0/8?)",
+		},
+		{
+			vlans:    "number,name\n",
+			subnets:  "subnet,vlan\n10.0.0.0/8,100\n",
+			expected: "subnets.csv line 2: VLAN 100 is not in vlans.csv",
+		},
+		{
+			vlans:     "number,name\n",
+			subnets:   "subnet\n10.0.0.0/8\n",
+			addresses: "ip,subnet\n10.0.0.1,10.0.0.0/8\n192.168.0.1,10.0.0.0/8\n",
+			expected:  "addresses.csv line 3: address 192.168.0.1 is not in subnet 10.0.0.0/8",
+		},
+		{
+			vlans:     "number,name\n",
+			subnets:   "subnet,section\n10.0.0.0/8,1\n10.0.0.0/8,2\n",
+			addresses: "ip,subnet\n10.0.0.1,10.0.0.0/8\n",
+			expected:  "addresses.csv line 2: subnet 10.0.0.0/8 is in more than one section, so the address's section must be supplied",
+		},
+	}
+	for _, tc := range cases {
+		dir := writeSource(t, tc.vlans, tc.subnets, tc.addresses)
+		_, err := Load(dir)
+		os.RemoveAll(dir)
+		if err == nil || err.Error() != tc.expected {
+			t.Fatalf("Expected error %q, got %v", tc.expected, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/state"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// finalRun switches the tool into the final pass of a two-phase
+	// cutover. A write freeze marker is recorded in the state file, only the
+	// delta since the bulk migration is migrated, and a cutover report is
+	// written to cutoverReportFile.
+	finalRun bool
+
+	// cutoverReportFile is the path the cutover report is written to by the
+	// final pass.
+	cutoverReportFile string
+)
+
+// setupFinal checks the options for the final pass, which picks up from the
+// last run recorded in the state file unless -since is supplied. Outside of
+// the final pass, a warning is logged if the state file shows that the legacy
+// DB has already been frozen for cutover.
+func setupFinal() {
+	if !finalRun {
+		if stateFile == "" || freezeCheck {
+			return
+		}
+		st, err := state.Load(stateFile)
+		if err != nil {
+			logrus.Fatalf("Error loading state file: %s", err)
+		}
+		if st.Freeze != nil {
+			logrus.Warnf("The legacy DB was frozen for cutover by a final pass at %s - run the pass with -final to update the cutover report", st.Freeze.Taken.Format(time.RFC3339))
+		}
+		return
+	}
+	if stateFile == "" {
+		logrus.Fatal("-final requires the -state-file of the bulk migration")
+	}
+	if freezeCheck {
+		logrus.Fatal("-final cannot be combined with -freeze-check")
+	}
+	if sinceFlag == "" {
+		sinceFlag = "last"
+	}
+}
+
+// takeFreeze records the write freeze marker in the state file: a snapshot of
+// the legacy DB taken as the final pass starts, against which writes made
+// during the pass are detected. The state as it was left by the bulk
+// migration is returned, with the marker.
+func takeFreeze(conn *sql.DB) *state.State {
+	st, err := state.Load(stateFile)
+	if err != nil {
+		logrus.Fatalf("Error loading state file: %s", err)
+	}
+	if st.LastSync == nil {
+		logrus.Fatalf("No sync recorded in state file %s - run the bulk migration with -state-file first", stateFile)
+	}
+	if st.Freeze, err = state.TakeSnapshot(conn, legacyTables()); err != nil {
+		logrus.Fatalf("Error taking snapshot of legacy DB: %s", err)
+	}
+	if err := st.Save(stateFile); err != nil {
+		logrus.Fatalf("Error saving state file: %s", err)
+	}
+	logrus.Infof("Took write freeze marker at %s - the legacy DB must not be written to until the cutover is complete", st.Freeze.Taken.Format(time.RFC3339))
+	return st
+}
+
+// finishCutover checks the legacy DB for writes made during the final pass,
+// and writes the cutover report. It returns whether or not the cutover is
+// ready: all sections were migrated and the freeze held.
+func finishCutover(conn *sql.DB, bulk *state.State, runs []*sectionRun) bool {
+	after, err := state.TakeSnapshot(conn, legacyTables())
+	if err != nil {
+		logrus.Fatalf("Error taking snapshot of legacy DB: %s", err)
+	}
+	for _, v := range bulk.Freeze.Changes(after) {
+		logrus.Warnf("Legacy DB changed during final pass: %s", v)
+	}
+	report, ready := cutoverReport(bulk, after, runs, time.Now())
+	if err := ioutil.WriteFile(cutoverReportFile, []byte(report), 0644); err != nil {
+		logrus.Fatalf("Error writing cutover report: %s", err)
+	}
+	logrus.Infof("Wrote cutover report to %s", cutoverReportFile)
+	return ready
+}
+
+// cutoverReport renders the cutover report of a final pass as Markdown, from
+// the state left by the bulk migration, the snapshot of the legacy DB taken
+// after the pass, and the section runs. It also returns whether or not the
+// cutover is ready.
+func cutoverReport(bulk *state.State, after *state.Snapshot, runs []*sectionRun, finished time.Time) (string, bool) {
+	var b bytes.Buffer
+	b.WriteString("# Cutover Report\n\n")
+	fmt.Fprintf(&b, "Generated at %s.\n\n", finished.Format(time.RFC3339))
+	if !bulk.LastRunStarted.IsZero() {
+		fmt.Fprintf(&b, "- Last migration started: %s\n", bulk.LastRunStarted.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "- Last sync: %s\n", bulk.LastSync.Taken.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Write freeze taken: %s\n", bulk.Freeze.Taken.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Delta migrated: addresses and changelog entries edited since %s", deltaSince.Format(legacyTimeLayout))
+	if deltaAfterID > 0 {
+		fmt.Fprintf(&b, ", and addresses with legacy IDs above %d", deltaAfterID)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "- Final pass took: %s\n", finished.Sub(runStarted).Round(time.Second))
+
+	ready := true
+	b.WriteString("\n## Sections\n\n")
+	for _, s := range runs {
+		if s.Err != nil {
+			ready = false
+			fmt.Fprintf(&b, "- %s: failed (%s): %s\n", s, s.summary(), s.Err)
+			continue
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", s, s.summary())
+	}
+
+	b.WriteString("\n## Legacy DB Writes\n")
+	for _, v := range []struct {
+		heading string
+		changes []string
+	}{
+		{"Since the last sync", bulk.LastSync.Changes(bulk.Freeze)},
+		{"During the final pass", bulk.Freeze.Changes(after)},
+	} {
+		fmt.Fprintf(&b, "\n%s:\n\n", v.heading)
+		if len(v.changes) == 0 {
+			b.WriteString("- None\n")
+		}
+		for _, c := range v.changes {
+			fmt.Fprintf(&b, "- %s\n", c)
+		}
+	}
+	frozen := len(bulk.Freeze.Changes(after)) == 0
+
+	b.WriteString("\n## Result\n\n")
+	switch {
+	case !frozen:
+		ready = false
+		b.WriteString("Not ready: the legacy DB was written to during the final pass. Stop the writes and run another final pass.\n")
+	case !ready:
+		b.WriteString("Not ready: some sections failed to migrate. Fix the errors and run another final pass.\n")
+	default:
+		b.WriteString("Ready for cutover: the delta was migrated and the legacy DB was not written to during the final pass.\n")
+	}
+	return b.String(), ready
+}
@@ -0,0 +1,801 @@
+// Package dbsink writes migrated objects straight into the MySQL database of a
+// new PHPIPAM instance, instead of through the API, and looks up the IDs of
+// the objects already in it. This is for environments where the API cannot be
+// reached, or when its write throughput is the bottleneck.
+//
+// Each object is written in its own transaction, along with the checks that
+// the API would make before writing it, so that an object is either written
+// completely or not at all, and a failed object can be counted against the
+// error budget like a failed API call. The tables must use a transactional
+// storage engine (ie: InnoDB) for this to hold.
+//
+// Only the columns of an object that are set are written, so that the
+// database's defaults are used for the rest. Custom fields are written to the
+// columns they are named after, as they are stored by PHPIPAM.
+//
+// The same objects can also be written as a SQL script with Script, to be
+// applied to the database later.
+package dbsink
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// defaultDomainID is the ID of the default L2 domain, which the API also
+// assigns VLANs to if no domain is supplied.
+const defaultDomainID = 1
+
+// Sink writes objects into the database of a new PHPIPAM instance.
+type Sink struct {
+	// The database of the new PHPIPAM instance.
+	DB *sql.DB
+}
+
+// New returns a new Sink for the database.
+func New(db *sql.DB) *Sink {
+	return &Sink{DB: db}
+}
+
+// transact runs f in a transaction, which is committed if f succeeds, and
+// rolled back otherwise. op describes the operation in error messages.
+func (s *Sink) transact(op string, f func(tx *sql.Tx) error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error %s: error starting transaction: %s", op, err)
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error %s: %s", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error %s: error committing transaction: %s", op, err)
+	}
+	return nil
+}
+
+// exists returns true if query returns any rows in tx.
+func exists(tx *sql.Tx, query string, args ...interface{}) (bool, error) {
+	var n int
+	if err := tx.QueryRow(query, args...).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// CreateVLAN creates a VLAN in the default L2 domain, unless it is supplied,
+// setting the supplied custom fields, if any. As with the API, a VLAN number
+// can only be used once in a domain.
+func (s *Sink) CreateVLAN(v vlans.VLAN, fields map[string]string) error {
+	if v.DomainID == 0 {
+		v.DomainID = defaultDomainID
+	}
+	return s.transact(fmt.Sprintf("adding VLAN number %d", v.Number), func(tx *sql.Tx) error {
+		found, err := exists(tx, "select count(*) from vlans where domainId = ? and number = ?", v.DomainID, v.Number)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("VLAN number %d already exists in domain %d", v.Number, v.DomainID)
+		}
+		r, err := vlanRow(v, fields)
+		if err != nil {
+			return err
+		}
+		return r.insert(tx, "vlans")
+	})
+}
+
+// CreateSubnet creates a subnet, setting the supplied custom fields, if any.
+// The subnet is nested under the narrowest existing subnet in its section that
+// contains it, as with ipamsink.Sink, and is given the permissions of its
+// section unless it has its own, as the API does.
+func (s *Sink) CreateSubnet(v subnets.Subnet, fields map[string]string) error {
+	cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+	return s.transact(fmt.Sprintf("creating subnet %s", cidr), func(tx *sql.Tx) error {
+		addr, err := decimal(v.SubnetAddress)
+		if err != nil {
+			return err
+		}
+		found, err := exists(tx, "select count(*) from subnets where sectionId = ? and subnet = ? and mask = ?", v.SectionID, addr, v.Mask)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("subnet %s already exists in section %d", cidr, v.SectionID)
+		}
+		if v.MasterSubnetID, err = parentSubnetID(tx, v.SectionID, v.SubnetAddress, v.Mask); err != nil {
+			return err
+		}
+		if v.Permissions == "" {
+			var perms sql.NullString
+			if err := tx.QueryRow("select permissions from sections where id = ?", v.SectionID).Scan(&perms); err == sql.ErrNoRows {
+				return fmt.Errorf("section %d does not exist", v.SectionID)
+			} else if err != nil {
+				return err
+			}
+			v.Permissions = perms.String
+		}
+
+		r, err := subnetRow(v, addr, fields)
+		if err != nil {
+			return err
+		}
+		return r.insert(tx, "subnets")
+	})
+}
+
+// parentSubnetID finds the ID of the narrowest subnet in the section that
+// contains the subnet addr/mask, in the same way as
+// helper.ParentSubnetIDForCIDR. 0 is returned if there is none.
+func parentSubnetID(tx *sql.Tx, sectionID int, addr string, mask int) (int, error) {
+	for n := mask - 1; n >= 8; n-- {
+		_, parent, err := net.ParseCIDR(fmt.Sprintf("%s/%d", addr, n))
+		if err != nil {
+			return 0, fmt.Errorf("error parsing subnet/CIDR %s/%d: %s", addr, mask, err)
+		}
+		dec, err := decimal(parent.IP.String())
+		if err != nil {
+			return 0, err
+		}
+		var id int
+		err = tx.QueryRow("select id from subnets where sectionId = ? and subnet = ? and mask = ? order by id limit 1", sectionID, dec, n).Scan(&id)
+		switch {
+		case err == nil:
+			return id, nil
+		case err != sql.ErrNoRows:
+			return 0, fmt.Errorf("error searching for subnet: %s", err)
+		}
+	}
+	return 0, nil
+}
+
+// CreateDevice creates a device.
+func (s *Sink) CreateDevice(d devices.Device) error {
+	return s.transact(fmt.Sprintf("adding device %s", d.Hostname), func(tx *sql.Tx) error {
+		return deviceRow(d).insert(tx, "devices")
+	})
+}
+
+// CreateDeviceType creates a device type.
+func (s *Sink) CreateDeviceType(t devices.DeviceType) error {
+	return s.transact(fmt.Sprintf("adding device type %s", t.Name), func(tx *sql.Tx) error {
+		return deviceTypeRow(t).insert(tx, "deviceTypes")
+	})
+}
+
+// CreateVRF creates a VRF. As with the API, a VRF name can only be used once.
+func (s *Sink) CreateVRF(v vrfs.VRF) error {
+	return s.transact(fmt.Sprintf("adding VRF %s", v.Name), func(tx *sql.Tx) error {
+		found, err := exists(tx, "select count(*) from vrf where name = ?", v.Name)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("VRF %s already exists", v.Name)
+		}
+		return vrfRow(v).insert(tx, "vrf")
+	})
+}
+
+// CreateL2Domain creates an L2 domain.
+func (s *Sink) CreateL2Domain(d l2domains.Domain) error {
+	return s.transact(fmt.Sprintf("adding L2 domain %s", d.Name), func(tx *sql.Tx) error {
+		return l2DomainRow(d).insert(tx, "vlanDomains")
+	})
+}
+
+// CreateSection creates a section.
+func (s *Sink) CreateSection(v sections.Section) error {
+	return s.transact(fmt.Sprintf("adding section %s", v.Name), func(tx *sql.Tx) error {
+		return sectionRow(v).insert(tx, "sections")
+	})
+}
+
+// UpdateSection updates the master section of an existing section.
+func (s *Sink) UpdateSection(v sections.Section) error {
+	if _, err := s.DB.Exec("update sections set masterSection = ? where id = ?", v.MasterSection, v.ID); err != nil {
+		return fmt.Errorf("error updating section %d: %s", v.ID, err)
+	}
+	return nil
+}
+
+// CreateNameserver creates a nameserver set.
+func (s *Sink) CreateNameserver(n nameservers.Nameserver) error {
+	return s.transact(fmt.Sprintf("adding nameserver set %s", n.Name), func(tx *sql.Tx) error {
+		return nameserverRow(n).insert(tx, "nameservers")
+	})
+}
+
+// CreateAddress creates an IP address, setting the supplied custom fields, if
+// any. As with the API, an address can only be used once in a subnet.
+func (s *Sink) CreateAddress(a addresses.Address, fields map[string]string) error {
+	return s.transact(fmt.Sprintf("adding IP address %s", a.IPAddress), func(tx *sql.Tx) error {
+		addr, err := decimal(a.IPAddress)
+		if err != nil {
+			return err
+		}
+		found, err := exists(tx, "select count(*) from ipaddresses where subnetId = ? and ip_addr = ?", a.SubnetID, addr)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("IP address %s already exists in subnet ID %d", a.IPAddress, a.SubnetID)
+		}
+		r, err := addressRow(a, addr, fields)
+		if err != nil {
+			return err
+		}
+		return r.insert(tx, "ipaddresses")
+	})
+}
+
+// CreateRequest creates a pending IP request.
+func (s *Sink) CreateRequest(v requests.Request) error {
+	return s.transact(fmt.Sprintf("adding IP request for %s", requestAddress(v)), func(tx *sql.Tx) error {
+		addr, err := requestDecimal(v)
+		if err != nil {
+			return err
+		}
+		return requestRow(v, addr).insert(tx, "requests")
+	})
+}
+
+// CreateGroup creates a user group. As in PHPIPAM, a group name can only be
+// used once.
+func (s *Sink) CreateGroup(g users.Group) error {
+	return s.transact(fmt.Sprintf("adding group %s", g.Name), func(tx *sql.Tx) error {
+		found, err := exists(tx, "select count(*) from userGroups where g_name = ?", g.Name)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("group %s already exists", g.Name)
+		}
+		return groupRow(g).insert(tx, "userGroups")
+	})
+}
+
+// CreateUser creates a user, who belongs to the groups with the supplied IDs.
+// As in PHPIPAM, a username can only be used once.
+func (s *Sink) CreateUser(u users.User) error {
+	return s.transact(fmt.Sprintf("adding user %s", u.Username), func(tx *sql.Tx) error {
+		found, err := exists(tx, "select count(*) from users where username = ?", u.Username)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("user %s already exists", u.Username)
+		}
+		r, err := userRow(u, groupsJSON(u.Groups))
+		if err != nil {
+			return err
+		}
+		return r.insert(tx, "users")
+	})
+}
+
+// CreateChange creates a changelog entry about a subnet or an address, which
+// must already exist. Changes are attributed to the user with the entry's
+// username, or to no user (ID 0) if there is none. As in the legacy DB, the
+// same action on an object can only be logged once at a time.
+func (s *Sink) CreateChange(e changelog.Entry) error {
+	return s.transact(fmt.Sprintf("adding changelog entry for %s", changeObject(e)), func(tx *sql.Tx) error {
+		objectID := e.SubnetID
+		if e.Type == changelog.AddressType {
+			addr, err := decimal(e.IPAddress)
+			if err != nil {
+				return err
+			}
+			err = tx.QueryRow("select id from ipaddresses where subnetId = ? and ip_addr = ? order by id limit 1", e.SubnetID, addr).Scan(&objectID)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("IP address %s does not exist in subnet ID %d", e.IPAddress, e.SubnetID)
+			} else if err != nil {
+				return err
+			}
+		}
+		var userID int
+		if e.Username != "" {
+			if err := tx.QueryRow("select id from users where username = ? order by id limit 1", e.Username).Scan(&userID); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+		found, err := exists(tx, "select count(*) from changelog where ctype = ? and coid = ? and caction = ? and cdate = ?", e.Type, objectID, e.Action, e.Date)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("changelog entry for %s at %s already exists", changeObject(e), e.Date)
+		}
+		return changeRow(e, objectID, userID).insert(tx, "changelog")
+	})
+}
+
+// CreateCustomField adds a column for a custom field to table, as PHPIPAM
+// does when a custom field is added in its administration pages. The column
+// is a nullable varchar(255), PHPIPAM's default type for custom fields. As
+// schema changes cannot be made in a transaction, this is not transactional.
+func (s *Sink) CreateCustomField(table, name string) error {
+	q, err := addColumn(table, name)
+	if err != nil {
+		return fmt.Errorf("error adding custom field %s to %s: %s", name, table, err)
+	}
+	if _, err := s.DB.Exec(q); err != nil {
+		return fmt.Errorf("error adding custom field %s to %s: %s", name, table, err)
+	}
+	return nil
+}
+
+// CustomFields lists the names of all of the columns of table, including the
+// ones that are not custom fields, so that custom fields named after them are
+// not added again.
+func (s *Sink) CustomFields(table string) (out []string, err error) {
+	rows, err := s.DB.Query("select column_name from information_schema.columns where table_schema = database() and table_name = ? order by ordinal_position", table)
+	if err != nil {
+		return nil, fmt.Errorf("error listing columns of %s: %s", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error listing columns of %s: %s", table, err)
+		}
+		out = append(out, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing columns of %s: %s", table, err)
+	}
+	return out, nil
+}
+
+// changeObject describes the object of a changelog entry in messages.
+func changeObject(e changelog.Entry) string {
+	if e.Type == changelog.AddressType {
+		return fmt.Sprintf("IP address %s", e.IPAddress)
+	}
+	return fmt.Sprintf("subnet ID %d", e.SubnetID)
+}
+
+// requestAddress describes the address of an IP request in messages.
+func requestAddress(v requests.Request) string {
+	if v.IPAddress == "" {
+		return fmt.Sprintf("any address in subnet ID %d", v.SubnetID)
+	}
+	return v.IPAddress
+}
+
+// requestDecimal returns the decimal address of an IP request, or blank if
+// no address was requested.
+func requestDecimal(v requests.Request) (string, error) {
+	if v.IPAddress == "" {
+		return "", nil
+	}
+	return decimal(v.IPAddress)
+}
+
+// Devices lists all of the devices.
+func (s *Sink) Devices() (out []devices.Device, err error) {
+	rows, err := s.DB.Query("select id, hostname, description, sections from devices order by id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing devices: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d devices.Device
+		var hostname, description, sections sql.NullString
+		if err := rows.Scan(&d.ID, &hostname, &description, &sections); err != nil {
+			return nil, fmt.Errorf("error listing devices: %s", err)
+		}
+		d.Hostname, d.Description, d.Sections = hostname.String, description.String, sections.String
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing devices: %s", err)
+	}
+	return out, nil
+}
+
+// DeviceTypes lists all of the device types.
+func (s *Sink) DeviceTypes() (out []devices.DeviceType, err error) {
+	rows, err := s.DB.Query("select tid, tname, tdescription from deviceTypes order by tid")
+	if err != nil {
+		return nil, fmt.Errorf("error listing device types: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t devices.DeviceType
+		var name, description sql.NullString
+		if err := rows.Scan(&t.ID, &name, &description); err != nil {
+			return nil, fmt.Errorf("error listing device types: %s", err)
+		}
+		t.Name, t.Description = name.String, description.String
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing device types: %s", err)
+	}
+	return out, nil
+}
+
+// Groups lists all of the user groups.
+func (s *Sink) Groups() (out []users.Group, err error) {
+	rows, err := s.DB.Query("select g_id, g_name, g_desc from userGroups order by g_id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing groups: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g users.Group
+		var name, description sql.NullString
+		if err := rows.Scan(&g.ID, &name, &description); err != nil {
+			return nil, fmt.Errorf("error listing groups: %s", err)
+		}
+		g.Name, g.Description = name.String, description.String
+		out = append(out, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing groups: %s", err)
+	}
+	return out, nil
+}
+
+// Users lists the IDs and usernames of all of the users.
+func (s *Sink) Users() (out []users.User, err error) {
+	rows, err := s.DB.Query("select id, username from users order by id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u users.User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, fmt.Errorf("error listing users: %s", err)
+		}
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing users: %s", err)
+	}
+	return out, nil
+}
+
+// VRFs lists all of the VRFs.
+func (s *Sink) VRFs() (out []vrfs.VRF, err error) {
+	rows, err := s.DB.Query("select vrfId, name, rd, description, sections from vrf order by vrfId")
+	if err != nil {
+		return nil, fmt.Errorf("error listing VRFs: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v vrfs.VRF
+		var rd, description, sections sql.NullString
+		if err := rows.Scan(&v.ID, &v.Name, &rd, &description, &sections); err != nil {
+			return nil, fmt.Errorf("error listing VRFs: %s", err)
+		}
+		v.RD, v.Description, v.Sections = rd.String, description.String, sections.String
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing VRFs: %s", err)
+	}
+	return out, nil
+}
+
+// Sections lists all of the sections.
+func (s *Sink) Sections() (out []sections.Section, err error) {
+	rows, err := s.DB.Query("select id, name, description from sections order by id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing sections: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v sections.Section
+		var description sql.NullString
+		if err := rows.Scan(&v.ID, &v.Name, &description); err != nil {
+			return nil, fmt.Errorf("error listing sections: %s", err)
+		}
+		v.Description = description.String
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing sections: %s", err)
+	}
+	return out, nil
+}
+
+// L2Domains lists all of the L2 domains.
+func (s *Sink) L2Domains() (out []l2domains.Domain, err error) {
+	rows, err := s.DB.Query("select id, name, description, permissions from vlanDomains order by id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing L2 domains: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d l2domains.Domain
+		var description, permissions sql.NullString
+		if err := rows.Scan(&d.ID, &d.Name, &description, &permissions); err != nil {
+			return nil, fmt.Errorf("error listing L2 domains: %s", err)
+		}
+		d.Description, d.Permissions = description.String, permissions.String
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing L2 domains: %s", err)
+	}
+	return out, nil
+}
+
+// Nameservers lists all of the nameserver sets.
+func (s *Sink) Nameservers() (out []nameservers.Nameserver, err error) {
+	rows, err := s.DB.Query("select id, name, namesrv1, description, permissions from nameservers order by id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing nameserver sets: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n nameservers.Nameserver
+		var addrs, description, permissions sql.NullString
+		if err := rows.Scan(&n.ID, &n.Name, &addrs, &description, &permissions); err != nil {
+			return nil, fmt.Errorf("error listing nameserver sets: %s", err)
+		}
+		n.Addresses, n.Description, n.Permissions = addrs.String, description.String, permissions.String
+		out = append(out, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing nameserver sets: %s", err)
+	}
+	return out, nil
+}
+
+// VLANID returns the ID of the VLAN with number n. If the number is used by
+// more than one VLAN, the first one created is used.
+func (s *Sink) VLANID(n int) (int, error) {
+	var id int
+	err := s.DB.QueryRow("select vlanId from vlans where number = ? order by vlanId limit 1", n).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("VLAN number %d not found", n)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error looking up VLAN number %d: %s", n, err)
+	}
+	return id, nil
+}
+
+// VLANs lists all of the VLANs, in the order that they were created.
+func (s *Sink) VLANs() (out []vlans.VLAN, err error) {
+	rows, err := s.DB.Query("select vlanId, domainId, name, number, description from vlans order by vlanId")
+	if err != nil {
+		return nil, fmt.Errorf("error listing VLANs: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v vlans.VLAN
+		var name, description sql.NullString
+		if err := rows.Scan(&v.ID, &v.DomainID, &name, &v.Number, &description); err != nil {
+			return nil, fmt.Errorf("error listing VLANs: %s", err)
+		}
+		v.Name, v.Description = name.String, description.String
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing VLANs: %s", err)
+	}
+	return out, nil
+}
+
+// UpdateVLAN updates the name and description of an existing VLAN.
+func (s *Sink) UpdateVLAN(v vlans.VLAN) error {
+	if _, err := s.DB.Exec("update vlans set name = ?, description = ? where vlanId = ?", v.Name, v.Description, v.ID); err != nil {
+		return fmt.Errorf("error updating VLAN number %d: %s", v.Number, err)
+	}
+	return nil
+}
+
+// VLANIDs returns a map of the numbers of all of the VLANs to their IDs. If a
+// number is used by more than one VLAN, the first one created is used, as with
+// VLANID.
+func (s *Sink) VLANIDs() (map[int]int, error) {
+	found, err := s.VLANs()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]int)
+	for _, v := range found {
+		if _, ok := out[v.Number]; !ok {
+			out[v.Number] = v.ID
+		}
+	}
+	return out, nil
+}
+
+// SubnetID returns the ID of the subnet with the CIDR subnet address in the
+// section with ID sectionID, or in any section if sectionID is 0.
+func (s *Sink) SubnetID(sectionID int, cidr string) (int, error) {
+	ip, n, err := net.ParseCIDR(cidr)
+	if err != nil || ip.To4() == nil {
+		return 0, fmt.Errorf("invalid IPv4 subnet %s", cidr)
+	}
+	addr, _ := decimal(n.IP.String())
+	mask, _ := n.Mask.Size()
+	query, args := "select id from subnets where subnet = ? and mask = ?", []interface{}{addr, mask}
+	if sectionID != 0 {
+		query, args = query+" and sectionId = ?", append(args, sectionID)
+	}
+	var id int
+	err = s.DB.QueryRow(query+" order by id limit 1", args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("subnet %s not found in section %d", cidr, sectionID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error looking up subnet %s: %s", cidr, err)
+	}
+	return id, nil
+}
+
+// Subnets lists all of the IPv4 subnets in the section with ID sectionID.
+func (s *Sink) Subnets(sectionID int) (out []subnets.Subnet, err error) {
+	rows, err := s.DB.Query("select id, subnet, mask, description, vlanId, vrfId, nameserverId from subnets where sectionId = ? order by id", sectionID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing subnets: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := subnets.Subnet{SectionID: sectionID}
+		var addr, mask string
+		var description sql.NullString
+		var vlanID, vrfID, nameserverID sql.NullInt64
+		if err := rows.Scan(&v.ID, &addr, &mask, &description, &vlanID, &vrfID, &nameserverID); err != nil {
+			return nil, fmt.Errorf("error listing subnets: %s", err)
+		}
+		// Folders have no address, and IPv6 subnets cannot be converted.
+		ip, err := legacydb.DecimalToIPv4(addr)
+		if err != nil {
+			continue
+		}
+		if v.Mask, err = strconv.Atoi(mask); err != nil {
+			continue
+		}
+		v.SubnetAddress = ip
+		v.Description = description.String
+		v.VLANID, v.VRFID, v.NameserverID = int(vlanID.Int64), int(vrfID.Int64), int(nameserverID.Int64)
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing subnets: %s", err)
+	}
+	return out, nil
+}
+
+// UpdateSubnet updates the description, VLAN, VRF, and nameserver set of an
+// existing subnet.
+func (s *Sink) UpdateSubnet(v subnets.Subnet) error {
+	_, err := s.DB.Exec("update subnets set description = ?, vlanId = ?, vrfId = ?, nameserverId = ? where id = ?",
+		v.Description, v.VLANID, v.VRFID, v.NameserverID, v.ID)
+	if err != nil {
+		return fmt.Errorf("error updating subnet %s/%d: %s", v.SubnetAddress, v.Mask, err)
+	}
+	return nil
+}
+
+// AddressID returns the ID of the IP address ip in the subnet with ID
+// subnetID, or 0 if it is not found.
+func (s *Sink) AddressID(subnetID int, ip string) (int, error) {
+	addr, err := decimal(ip)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = s.DB.QueryRow("select id from ipaddresses where subnetId = ? and ip_addr = ? order by id limit 1", subnetID, addr).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error looking up IP address %s: %s", ip, err)
+	}
+	return id, nil
+}
+
+// UpdateAddress updates the description, hostname, and note of an existing IP
+// address.
+func (s *Sink) UpdateAddress(a addresses.Address) error {
+	_, err := s.DB.Exec("update ipaddresses set description = ?, hostname = ?, note = ? where id = ?", a.Description, a.Hostname, a.Note, a.ID)
+	if err != nil {
+		return fmt.Errorf("error updating IP address %s: %s", a.IPAddress, err)
+	}
+	return nil
+}
+
+// SubnetIDs returns a map of the CIDRs of all of the IPv4 subnets in the
+// section with ID sectionID to their IDs.
+func (s *Sink) SubnetIDs(sectionID int) (map[string]int, error) {
+	found, err := s.Subnets(sectionID)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int)
+	for _, v := range found {
+		out[fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)] = v.ID
+	}
+	return out, nil
+}
+
+// VerifyAddresses compares addrs against the addresses in the database, and
+// returns any differences found. As with verify.Addresses, the addresses of
+// each subnet are read with a single query.
+func (s *Sink) VerifyAddresses(addrs []addresses.Address) ([]verify.Mismatch, error) {
+	bySubnet := make(map[int][]addresses.Address)
+	for _, v := range addrs {
+		bySubnet[v.SubnetID] = append(bySubnet[v.SubnetID], v)
+	}
+	var ids []int
+	for id := range bySubnet {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var out []verify.Mismatch
+	for _, id := range ids {
+		actual, err := s.subnetAddresses(id)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying IP addresses: %s", err)
+		}
+		out = append(out, verify.CompareAddresses(id, bySubnet[id], actual)...)
+	}
+	return out, nil
+}
+
+// subnetAddresses reads all of the addresses in a subnet.
+func (s *Sink) subnetAddresses(id int) (out []addresses.Address, err error) {
+	rows, err := s.DB.Query("select ip_addr, description, hostname, note from ipaddresses where subnetId = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching addresses for subnet ID %d: %s", id, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addr string
+		var description, hostname, note sql.NullString
+		if err := rows.Scan(&addr, &description, &hostname, &note); err != nil {
+			return nil, fmt.Errorf("error fetching addresses for subnet ID %d: %s", id, err)
+		}
+		ip, err := legacydb.DecimalToIPv4(addr)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching addresses for subnet ID %d: invalid address %q", id, addr)
+		}
+		out = append(out, addresses.Address{
+			SubnetID:    id,
+			IPAddress:   ip,
+			Description: description.String,
+			Hostname:    hostname.String,
+			Note:        note.String,
+		})
+	}
+	return out, rows.Err()
+}
+
+// decimal returns an IPv4 address in the decimal format that PHPIPAM stores
+// addresses in.
+func decimal(addr string) (string, error) {
+	ip := net.ParseIP(addr).To4()
+	if ip == nil {
+		return "", fmt.Errorf("invalid IPv4 address %q", addr)
+	}
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip)), 10), nil
+}
@@ -0,0 +1,557 @@
+package dbsink
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// fakeDriver is a database/sql driver that answers queries with a function,
+// and logs the statements and transaction boundaries it sees.
+type fakeDriver struct {
+	// query returns the rows of a query, or nil for none.
+	query func(q string, args []driver.Value) [][]driver.Value
+
+	// The statements executed, and begin, commit, and rollback.
+	log []string
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return d, nil }
+func (d *fakeDriver) Prepare(q string) (driver.Stmt, error) { return &fakeStmt{d, q}, nil }
+func (d *fakeDriver) Close() error                          { return nil }
+func (d *fakeDriver) Begin() (driver.Tx, error) {
+	d.log = append(d.log, "begin")
+	return d, nil
+}
+func (d *fakeDriver) Commit() error {
+	d.log = append(d.log, "commit")
+	return nil
+}
+func (d *fakeDriver) Rollback() error {
+	d.log = append(d.log, "rollback")
+	return nil
+}
+
+type fakeStmt struct {
+	d *fakeDriver
+	q string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.log = append(s.d.log, fmt.Sprintf("%s %v", s.q, args))
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := s.d.query(s.q, args)
+	if rows == nil {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"a", "b", "c", "d"}
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func testSink(t *testing.T, name string, query func(q string, args []driver.Value) [][]driver.Value) (*Sink, *fakeDriver) {
+	d := &fakeDriver{query: query}
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Error opening fake DB: %s", err)
+	}
+	db.SetMaxOpenConns(1)
+	return New(db), d
+}
+
+func TestCreateAddress(t *testing.T) {
+	existing := int64(0)
+	s, d := testSink(t, "dbsink-address", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{{existing}}
+	})
+
+	err := s.CreateAddress(addresses.Address{SubnetID: 3, IPAddress: "10.1.0.1", Hostname: "gw", DeviceID: 2, PTRIgnore: true, LastSeen: "2016-03-01 12:30:00"}, map[string]string{"custom_site": "yvr"})
+	if err != nil {
+		t.Fatalf("Error creating address: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into ipaddresses (`subnetId`, `ip_addr`, `hostname`, `switch`, `PTRignore`, `lastSeen`, `custom_site`) values (?, ?, ?, ?, ?, ?, ?) [3 167837697 gw 2 1 2016-03-01 12:30:00 yvr]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+
+	d.log, existing = nil, 1
+	if err := s.CreateAddress(addresses.Address{SubnetID: 3, IPAddress: "10.1.0.1"}, nil); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected duplicate address error, got %v", err)
+	}
+	if expected := []string{"begin", "rollback"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+
+	d.log, existing = nil, 0
+	if err := s.CreateAddress(addresses.Address{SubnetID: 3, IPAddress: "10.1.0.2"}, map[string]string{"site`": "x"}); err == nil {
+		t.Fatal("Expected error for invalid custom field name, got none")
+	}
+	if expected := []string{"begin", "rollback"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
+
+func TestCreateSubnet(t *testing.T) {
+	s, d := testSink(t, "dbsink-subnet", func(q string, args []driver.Value) [][]driver.Value {
+		switch {
+		case strings.HasPrefix(q, "select count(*)"):
+			return [][]driver.Value{{int64(0)}}
+		case strings.HasPrefix(q, "select id from subnets") && args[1] == "167772160" && args[2] == int64(8):
+			return [][]driver.Value{{int64(7)}}
+		case strings.HasPrefix(q, "select permissions"):
+			return [][]driver.Value{{[]byte(`{"3":"2"}`)}}
+		}
+		return nil
+	})
+
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.1.0.0", Mask: 16, SectionID: 2}, nil); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into subnets (`subnet`, `mask`, `sectionId`, `masterSubnetId`, `permissions`) values (?, ?, ?, ?, ?) [167837696 16 2 7 {\"3\":\"2\"}]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
+
+func TestCreateVRF(t *testing.T) {
+	existing := int64(0)
+	s, d := testSink(t, "dbsink-vrf", func(q string, args []driver.Value) [][]driver.Value {
+		if strings.HasPrefix(q, "select count(*)") {
+			return [][]driver.Value{{existing}}
+		}
+		return [][]driver.Value{{int64(4), []byte("customers"), []byte("65000:1"), nil, nil}}
+	})
+
+	if err := s.CreateVRF(vrfs.VRF{Name: "customers", RD: "65000:1"}); err != nil {
+		t.Fatalf("Error creating VRF: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into vrf (`name`, `rd`) values (?, ?) [customers 65000:1]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+	found, err := s.VRFs()
+	if err != nil {
+		t.Fatalf("Error listing VRFs: %s", err)
+	}
+	if expected := []vrfs.VRF{{ID: 4, Name: "customers", RD: "65000:1"}}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+
+	existing = 1
+	if err := s.CreateVRF(vrfs.VRF{Name: "customers"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected duplicate VRF error, got %v", err)
+	}
+}
+
+func TestCreateDeviceType(t *testing.T) {
+	s, d := testSink(t, "dbsink-device-type", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{{int64(3), []byte("Switch"), nil}}
+	})
+
+	if err := s.CreateDeviceType(devices.DeviceType{Name: "Switch"}); err != nil {
+		t.Fatalf("Error creating device type: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into deviceTypes (`tname`) values (?) [Switch]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+	found, err := s.DeviceTypes()
+	if err != nil {
+		t.Fatalf("Error listing device types: %s", err)
+	}
+	if expected := []devices.DeviceType{{ID: 3, Name: "Switch"}}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+}
+
+func TestCreateSection(t *testing.T) {
+	s, d := testSink(t, "dbsink-section", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{{int64(1), []byte("Customers"), []byte("Section for customers")}, {int64(3), []byte("Datacenter"), nil}}
+	})
+
+	if err := s.CreateSection(sections.Section{Name: "Datacenter"}); err != nil {
+		t.Fatalf("Error creating section: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into sections (`name`) values (?) [Datacenter]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+	found, err := s.Sections()
+	if err != nil {
+		t.Fatalf("Error listing sections: %s", err)
+	}
+	if expected := []sections.Section{{ID: 1, Name: "Customers", Description: "Section for customers"}, {ID: 3, Name: "Datacenter"}}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+
+	d.log = nil
+	if err := s.UpdateSection(sections.Section{ID: 3, MasterSection: 1}); err != nil {
+		t.Fatalf("Error updating section: %s", err)
+	}
+	if expected := []string{"update sections set masterSection = ? where id = ? [1 3]"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
+
+func TestCreateL2Domain(t *testing.T) {
+	s, d := testSink(t, "dbsink-l2domain", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{{int64(1), []byte("default"), []byte("Default L2 domain"), nil}, {int64(2), []byte("datacenter"), nil, []byte("2")}}
+	})
+
+	if err := s.CreateL2Domain(l2domains.Domain{Name: "datacenter", Permissions: "2"}); err != nil {
+		t.Fatalf("Error creating L2 domain: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into vlanDomains (`name`, `permissions`) values (?, ?) [datacenter 2]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+	found, err := s.L2Domains()
+	if err != nil {
+		t.Fatalf("Error listing L2 domains: %s", err)
+	}
+	if expected := []l2domains.Domain{{ID: 1, Name: "default", Description: "Default L2 domain"}, {ID: 2, Name: "datacenter", Permissions: "2"}}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+}
+
+func TestCreateNameserver(t *testing.T) {
+	s, d := testSink(t, "dbsink-nameserver", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{{int64(2), []byte("Public"), []byte("8.8.8.8;8.8.4.4"), nil, []byte("1;2")}}
+	})
+
+	if err := s.CreateNameserver(nameservers.Nameserver{Name: "Public", Addresses: "8.8.8.8;8.8.4.4", Permissions: "1;2"}); err != nil {
+		t.Fatalf("Error creating nameserver set: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into nameservers (`name`, `namesrv1`, `permissions`) values (?, ?, ?) [Public 8.8.8.8;8.8.4.4 1;2]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+	found, err := s.Nameservers()
+	if err != nil {
+		t.Fatalf("Error listing nameserver sets: %s", err)
+	}
+	if expected := []nameservers.Nameserver{{ID: 2, Name: "Public", Addresses: "8.8.8.8;8.8.4.4", Permissions: "1;2"}}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+}
+
+func TestCreateRequest(t *testing.T) {
+	s, d := testSink(t, "dbsink-request", nil)
+
+	if err := s.CreateRequest(requests.Request{SubnetID: 5, IPAddress: "10.0.0.9", Requester: "jo@example.com"}); err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	if err := s.CreateRequest(requests.Request{SubnetID: 5, Comment: "any"}); err != nil {
+		t.Fatalf("Error creating request without an address: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into requests (`subnetId`, `ip_addr`, `requester`, `processed`) values (?, ?, ?, ?) [5 167772169 jo@example.com 0]",
+		"commit",
+		"begin",
+		"insert into requests (`subnetId`, `comment`, `processed`) values (?, ?, ?) [5 any 0]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	existing := int64(0)
+	s, d := testSink(t, "dbsink-user", func(q string, args []driver.Value) [][]driver.Value {
+		if strings.HasPrefix(q, "select count(*)") {
+			return [][]driver.Value{{existing}}
+		}
+		return [][]driver.Value{{int64(3), []byte("Network"), nil}}
+	})
+
+	if err := s.CreateGroup(users.Group{Name: "Network", Description: "Network team"}); err != nil {
+		t.Fatalf("Error creating group: %s", err)
+	}
+	found, err := s.Groups()
+	if err != nil {
+		t.Fatalf("Error listing groups: %s", err)
+	}
+	if expected := []users.Group{{ID: 3, Name: "Network"}}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+	if err := s.CreateUser(users.User{Username: "jo", Email: "jo@example.com", Groups: []int{3}}); err != nil {
+		t.Fatalf("Error creating user: %s", err)
+	}
+	if err := s.CreateUser(users.User{Username: "admin2", Role: "Administrator", Password: "secret"}); err != nil {
+		t.Fatalf("Error creating user with a password: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into userGroups (`g_name`, `g_desc`) values (?, ?) [Network Network team]",
+		"commit",
+		"begin",
+		"insert into users (`username`, `email`, `role`, `groups`, `passChange`) values (?, ?, ?, ?, ?) [jo jo@example.com User {\"3\":\"3\"} Yes]",
+		"commit",
+		"begin",
+	}
+	if len(d.log) != len(expected)+2 || !reflect.DeepEqual(expected, d.log[:len(expected)]) {
+		t.Fatalf("Expected %#v to begin with %#v", d.log, expected)
+	}
+	if !strings.HasPrefix(d.log[len(expected)], "insert into users (`username`, `role`, `groups`, `password`, `passChange`) values (?, ?, ?, ?, ?) [admin2 Administrator {} $6$") {
+		t.Fatalf("Expected user with hashed password, got %s", d.log[len(expected)])
+	}
+
+	existing = 1
+	if err := s.CreateUser(users.User{Username: "jo"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected duplicate user error, got %v", err)
+	}
+	if err := s.CreateGroup(users.Group{Name: "Network"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected duplicate group error, got %v", err)
+	}
+}
+
+func TestCreateChange(t *testing.T) {
+	existing := int64(0)
+	s, d := testSink(t, "dbsink-change", func(q string, args []driver.Value) [][]driver.Value {
+		switch {
+		case strings.HasPrefix(q, "select count(*)"):
+			return [][]driver.Value{{existing}}
+		case strings.HasPrefix(q, "select id from ipaddresses"):
+			return [][]driver.Value{{int64(12)}}
+		case args[0] == "jo":
+			return [][]driver.Value{{int64(4)}}
+		}
+		return nil
+	})
+
+	if err := s.CreateChange(changelog.Entry{Type: changelog.SubnetType, SubnetID: 5, Username: "jo", Action: "add", Result: "success", Date: "2015-01-01 09:00:00"}); err != nil {
+		t.Fatalf("Error creating subnet changelog entry: %s", err)
+	}
+	if err := s.CreateChange(changelog.Entry{Type: changelog.AddressType, SubnetID: 5, IPAddress: "10.0.0.9", Username: "gone", Action: "edit", Date: "2015-02-01 09:00:00", Diff: "x"}); err != nil {
+		t.Fatalf("Error creating address changelog entry: %s", err)
+	}
+	expected := []string{
+		"begin",
+		"insert into changelog (`ctype`, `coid`, `cuser`, `caction`, `cresult`, `cdate`) values (?, ?, ?, ?, ?, ?) [subnet 5 4 add success 2015-01-01 09:00:00]",
+		"commit",
+		"begin",
+		"insert into changelog (`ctype`, `coid`, `cuser`, `caction`, `cdate`, `cdiff`) values (?, ?, ?, ?, ?, ?) [ip_addr 12 0 edit 2015-02-01 09:00:00 x]",
+		"commit",
+	}
+	if !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+
+	existing = 1
+	if err := s.CreateChange(changelog.Entry{Type: changelog.SubnetType, SubnetID: 5, Action: "add"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected duplicate changelog entry error, got %v", err)
+	}
+}
+
+func TestCreateCustomField(t *testing.T) {
+	s, d := testSink(t, "dbsink-custom-field", func(q string, args []driver.Value) [][]driver.Value {
+		if strings.HasPrefix(q, "select column_name") && args[0] == "ipaddresses" {
+			return [][]driver.Value{{[]byte("id")}, {[]byte("custom_Rack")}}
+		}
+		return nil
+	})
+
+	found, err := s.CustomFields("ipaddresses")
+	if err != nil {
+		t.Fatalf("Error listing custom fields: %s", err)
+	}
+	if expected := []string{"id", "custom_Rack"}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+	if err := s.CreateCustomField("ipaddresses", "custom_Customer"); err != nil {
+		t.Fatalf("Error creating custom field: %s", err)
+	}
+	if expected := []string{"alter table `ipaddresses` add column `custom_Customer` varchar(255) default null []"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+	if err := s.CreateCustomField("ipaddresses", "custom`; drop"); err == nil {
+		t.Fatal("Expected error for invalid custom field name, got none")
+	}
+}
+
+func TestUpdateVLAN(t *testing.T) {
+	s, d := testSink(t, "dbsink-vlans", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{
+			{int64(1), int64(1), []byte("servers"), int64(100), nil},
+			{int64(2), int64(2), nil, int64(100), []byte("Storage")},
+			{int64(3), int64(1), []byte("office"), int64(200), nil},
+		}
+	})
+
+	found, err := s.VLANs()
+	if err != nil {
+		t.Fatalf("Error listing VLANs: %s", err)
+	}
+	expected := []vlans.VLAN{
+		{ID: 1, DomainID: 1, Name: "servers", Number: 100},
+		{ID: 2, DomainID: 2, Number: 100, Description: "Storage"},
+		{ID: 3, DomainID: 1, Name: "office", Number: 200},
+	}
+	if !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+	ids, err := s.VLANIDs()
+	if err != nil {
+		t.Fatalf("Error listing VLAN IDs: %s", err)
+	}
+	if expected := map[int]int{100: 1, 200: 3}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("Expected %#v, got %#v", expected, ids)
+	}
+
+	if err := s.UpdateVLAN(vlans.VLAN{ID: 2, Name: "storage", Number: 100, Description: "Storage"}); err != nil {
+		t.Fatalf("Error updating VLAN: %s", err)
+	}
+	if expected := []string{"update vlans set name = ?, description = ? where vlanId = ? [storage Storage 2]"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
+
+func TestSubnetIDs(t *testing.T) {
+	s, _ := testSink(t, "dbsink-subnet-ids", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{
+			{int64(1), []byte("167772160"), []byte("8"), nil, nil, nil, nil},
+			{int64(2), []byte("42540766411282592856903984951653826560"), []byte("64"), nil, nil, nil, nil},
+			{int64(3), []byte(""), []byte(""), nil, nil, nil, nil},
+		}
+	})
+	ids, err := s.SubnetIDs(1)
+	if err != nil {
+		t.Fatalf("Error listing subnets: %s", err)
+	}
+	if expected := map[string]int{"10.0.0.0/8": 1}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("Expected %#v, got %#v", expected, ids)
+	}
+}
+
+func TestUpdateSubnet(t *testing.T) {
+	s, d := testSink(t, "dbsink-subnets", func(q string, args []driver.Value) [][]driver.Value {
+		return [][]driver.Value{
+			{int64(1), []byte("167772160"), []byte("8"), []byte("Servers"), int64(3), nil, nil},
+			{int64(2), []byte("167837696"), []byte("24"), nil, nil, int64(1), int64(2)},
+		}
+	})
+
+	found, err := s.Subnets(2)
+	if err != nil {
+		t.Fatalf("Error listing subnets: %s", err)
+	}
+	expected := []subnets.Subnet{
+		{ID: 1, SubnetAddress: "10.0.0.0", Mask: 8, SectionID: 2, Description: "Servers", VLANID: 3},
+		{ID: 2, SubnetAddress: "10.1.0.0", Mask: 24, SectionID: 2, VRFID: 1, NameserverID: 2},
+	}
+	if !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+
+	v := found[1]
+	v.Description = "Office"
+	if err := s.UpdateSubnet(v); err != nil {
+		t.Fatalf("Error updating subnet: %s", err)
+	}
+	if expected := []string{"update subnets set description = ?, vlanId = ?, vrfId = ?, nameserverId = ? where id = ? [Office 0 1 2 2]"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
+
+func TestUpdateAddress(t *testing.T) {
+	s, d := testSink(t, "dbsink-address-update", func(q string, args []driver.Value) [][]driver.Value {
+		if args[1] == "167772161" {
+			return [][]driver.Value{{int64(7)}}
+		}
+		return nil
+	})
+
+	if id, err := s.AddressID(3, "10.0.0.1"); err != nil || id != 7 {
+		t.Fatalf("Expected address ID 7, got %d, %v", id, err)
+	}
+	if id, err := s.AddressID(3, "10.0.0.2"); err != nil || id != 0 {
+		t.Fatalf("Expected no address, got %d, %v", id, err)
+	}
+	if err := s.UpdateAddress(addresses.Address{ID: 7, IPAddress: "10.0.0.1", Hostname: "web1"}); err != nil {
+		t.Fatalf("Error updating address: %s", err)
+	}
+	if expected := []string{"update ipaddresses set description = ?, hostname = ?, note = ? where id = ? [ web1  7]"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
+
+func TestTransactError(t *testing.T) {
+	s, d := testSink(t, "dbsink-transact", nil)
+	err := s.transact("doing things", func(tx *sql.Tx) error { return errors.New("boom") })
+	if err == nil || err.Error() != "error doing things: boom" {
+		t.Fatalf("Expected error, got %v", err)
+	}
+	if expected := []string{"begin", "rollback"}; !reflect.DeepEqual(expected, d.log) {
+		t.Fatalf("Expected %#v, got %#v", expected, d.log)
+	}
+}
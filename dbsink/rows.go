@@ -0,0 +1,349 @@
+package dbsink
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+)
+
+// row is a row to insert, built from the columns that are set.
+type row struct {
+	columns []string
+	values  []interface{}
+}
+
+// expr is a SQL expression used as a value in a script, rather than a
+// literal.
+type expr string
+
+// set adds a column to the row if its value is not the zero value.
+func (r *row) set(column string, v interface{}) {
+	switch t := v.(type) {
+	case string:
+		if t == "" {
+			return
+		}
+	case int:
+		if t == 0 {
+			return
+		}
+	case phpipam.BoolIntString:
+		if !t {
+			return
+		}
+		v = 1
+	}
+	r.columns = append(r.columns, column)
+	r.values = append(r.values, v)
+}
+
+// replace sets the value of a column, adding it to the row if it is not
+// already set.
+func (r *row) replace(column string, v interface{}) {
+	for i, c := range r.columns {
+		if c == column {
+			r.values[i] = v
+			return
+		}
+	}
+	r.columns = append(r.columns, column)
+	r.values = append(r.values, v)
+}
+
+// columnRegexp matches the names of columns that custom fields can be
+// written to.
+var columnRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// setFields adds the custom fields to the row, in name order.
+func (r *row) setFields(fields map[string]string) error {
+	var names []string
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if !columnRegexp.MatchString(k) {
+			return fmt.Errorf("invalid custom field name %q", k)
+		}
+		r.columns = append(r.columns, k)
+		r.values = append(r.values, fields[k])
+	}
+	return nil
+}
+
+// customFieldType is the type of the columns added for custom fields, which
+// is PHPIPAM's default type for them.
+const customFieldType = "varchar(255) default null"
+
+// addColumn returns the statement adding a column for the custom field name
+// to table.
+func addColumn(table, name string) (string, error) {
+	if !columnRegexp.MatchString(table) {
+		return "", fmt.Errorf("invalid table name %q", table)
+	}
+	if !columnRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid custom field name %q", name)
+	}
+	return fmt.Sprintf("alter table `%s` add column `%s` %s", table, name, customFieldType), nil
+}
+
+// columnList returns the quoted, comma-separated columns of the row.
+func (r *row) columnList() string {
+	quoted := make([]string, len(r.columns))
+	for i, v := range r.columns {
+		quoted[i] = "`" + v + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// insert inserts the row into table in tx.
+func (r *row) insert(tx *sql.Tx, table string) error {
+	query := fmt.Sprintf("insert into %s (%s) values (%s)", table, r.columnList(),
+		strings.TrimSuffix(strings.Repeat("?, ", len(r.columns)), ", "))
+	_, err := tx.Exec(query, r.values...)
+	return err
+}
+
+// literals returns the row's values as a list of literals.
+func (r *row) literals() string {
+	values := make([]string, len(r.values))
+	for i, v := range r.values {
+		values[i] = literal(v)
+	}
+	return strings.Join(values, ", ")
+}
+
+// statement returns a statement inserting the row into table, with the values
+// written as literals.
+func (r *row) statement(table string) string {
+	return fmt.Sprintf("insert into %s (%s) values (%s);", table, r.columnList(), r.literals())
+}
+
+// literalReplacer escapes the characters in a string literal that MySQL
+// requires to be escaped, as mysql_real_escape_string does.
+var literalReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\"", "\\\"",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+)
+
+// literal returns v as a MySQL literal. Expressions are returned as-is.
+func literal(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "NULL"
+	case expr:
+		return string(t)
+	case int:
+		return strconv.Itoa(t)
+	case string:
+		return "'" + literalReplacer.Replace(t) + "'"
+	}
+	return literal(fmt.Sprint(v))
+}
+
+// The functions below build the rows of each type of object in the schema of
+// a new PHPIPAM instance, from the fields that the API would accept. Addresses
+// are supplied in the decimal format that PHPIPAM stores them in.
+
+// vlanRow returns the row of a VLAN.
+func vlanRow(v vlans.VLAN, fields map[string]string) (*row, error) {
+	r := &row{}
+	r.set("domainId", v.DomainID)
+	r.set("name", v.Name)
+	r.set("number", v.Number)
+	r.set("description", v.Description)
+	return r, r.setFields(fields)
+}
+
+// subnetRow returns the row of a subnet.
+func subnetRow(v subnets.Subnet, addr string, fields map[string]string) (*row, error) {
+	r := &row{}
+	r.set("subnet", addr)
+	r.set("mask", strconv.Itoa(v.Mask))
+	r.set("sectionId", v.SectionID)
+	r.set("description", v.Description)
+	r.set("vlanId", v.VLANID)
+	r.set("vrfId", v.VRFID)
+	r.set("nameserverId", v.NameserverID)
+	r.set("masterSubnetId", v.MasterSubnetID)
+	r.set("permissions", v.Permissions)
+	r.set("showName", v.ShowName)
+	r.set("allowRequests", v.AllowRequests)
+	r.set("pingSubnet", v.PingSubnet)
+	r.set("discoverSubnet", v.DiscoverSubnet)
+	r.set("isFolder", v.IsFolder)
+	r.set("isFull", v.IsFull)
+	return r, r.setFields(fields)
+}
+
+// deviceRow returns the row of a device.
+func deviceRow(d devices.Device) *row {
+	r := &row{}
+	r.set("hostname", d.Hostname)
+	r.set("ip_addr", d.IPAddress)
+	r.set("type", d.Type)
+	r.set("vendor", d.Vendor)
+	r.set("model", d.Model)
+	r.set("description", d.Description)
+	r.set("sections", d.Sections)
+	return r
+}
+
+// deviceTypeRow returns the row of a device type.
+func deviceTypeRow(t devices.DeviceType) *row {
+	r := &row{}
+	r.set("tname", t.Name)
+	r.set("tdescription", t.Description)
+	return r
+}
+
+// vrfRow returns the row of a VRF.
+func vrfRow(v vrfs.VRF) *row {
+	r := &row{}
+	r.set("name", v.Name)
+	r.set("rd", v.RD)
+	r.set("description", v.Description)
+	r.set("sections", v.Sections)
+	return r
+}
+
+// l2DomainRow returns the row of an L2 domain.
+func l2DomainRow(d l2domains.Domain) *row {
+	r := &row{}
+	r.set("name", d.Name)
+	r.set("description", d.Description)
+	r.set("permissions", d.Permissions)
+	return r
+}
+
+// sectionRow returns the row of a section.
+func sectionRow(v sections.Section) *row {
+	r := &row{}
+	r.set("name", v.Name)
+	r.set("description", v.Description)
+	return r
+}
+
+// nameserverRow returns the row of a nameserver set.
+func nameserverRow(n nameservers.Nameserver) *row {
+	r := &row{}
+	r.set("name", n.Name)
+	r.set("namesrv1", n.Addresses)
+	r.set("description", n.Description)
+	r.set("permissions", n.Permissions)
+	return r
+}
+
+// addressRow returns the row of an IP address.
+func addressRow(a addresses.Address, addr string, fields map[string]string) (*row, error) {
+	r := &row{}
+	r.set("subnetId", a.SubnetID)
+	r.set("ip_addr", addr)
+	r.set("is_gateway", a.IsGateway)
+	r.set("description", a.Description)
+	r.set("hostname", a.Hostname)
+	r.set("mac", a.MACAddress)
+	r.set("owner", a.Owner)
+	r.set("state", a.Tag)
+	r.set("switch", a.DeviceID)
+	r.set("port", a.Port)
+	r.set("note", a.Note)
+	r.set("excludePing", a.ExcludePing)
+	r.set("PTRignore", a.PTRIgnore)
+	r.set("lastSeen", a.LastSeen)
+	r.set("editDate", a.EditDate)
+	return r, r.setFields(fields)
+}
+
+// groupRow returns the row of a user group.
+func groupRow(g users.Group) *row {
+	r := &row{}
+	r.set("g_name", g.Name)
+	r.set("g_desc", g.Description)
+	return r
+}
+
+// userRow returns the row of a user, who belongs to the groups in the JSON
+// object groups. The user's password is hashed as PHPIPAM hashes passwords,
+// and the user is made to change it at their next login.
+func userRow(u users.User, groups interface{}) (*row, error) {
+	r := &row{}
+	r.set("username", u.Username)
+	r.set("real_name", u.RealName)
+	r.set("email", u.Email)
+	r.set("role", u.Role)
+	if u.Role == "" {
+		r.set("role", "User")
+	}
+	r.replace("groups", groups)
+	if u.Password != "" {
+		hash, err := helper.HashPassword(u.Password)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing password: %s", err)
+		}
+		r.set("password", hash)
+	}
+	r.set("passChange", "Yes")
+	return r, nil
+}
+
+// groupsJSON returns the JSON object that PHPIPAM stores the group IDs of a
+// user in, with each ID keyed by itself.
+func groupsJSON(ids []int) string {
+	var members []string
+	for _, v := range ids {
+		members = append(members, fmt.Sprintf(`"%d":"%d"`, v, v))
+	}
+	return "{" + strings.Join(members, ",") + "}"
+}
+
+// changeRow returns the row of a changelog entry about the object with ID
+// objectID, made by the user with ID userID, or 0 if the user is unknown.
+func changeRow(e changelog.Entry, objectID, userID interface{}) *row {
+	r := &row{}
+	r.set("ctype", e.Type)
+	r.replace("coid", objectID)
+	r.replace("cuser", userID)
+	r.set("caction", e.Action)
+	r.set("cresult", e.Result)
+	r.set("cdate", e.Date)
+	r.set("cdiff", e.Diff)
+	return r
+}
+
+// requestRow returns the row of an IP request, which is left pending, as it
+// was in the legacy DB. The address is blank if none was requested.
+func requestRow(v requests.Request, addr string) *row {
+	r := &row{}
+	r.set("subnetId", v.SubnetID)
+	r.set("ip_addr", addr)
+	r.set("description", v.Description)
+	r.set("hostname", v.Hostname)
+	r.set("owner", v.Owner)
+	r.set("requester", v.Requester)
+	r.set("comment", v.Comment)
+	r.replace("processed", 0)
+	return r
+}
@@ -0,0 +1,586 @@
+package dbsink
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// scriptHeader starts a script.
+const scriptHeader = `-- PHPIPAM migration script generated by phpipam-legacy-migrator.
+--
+-- Review the script, then apply it to the database of the new PHPIPAM
+-- instance (ie: mysql phpipam < migration.sql). The whole migration is applied
+-- in one transaction, which is only committed at the end of the script.
+
+set names utf8;
+start transaction;`
+
+// Script writes objects as a SQL script of INSERT statements into the schema
+// of a new PHPIPAM instance, so that the migration can be reviewed and applied
+// later, without access to the new instance.
+//
+// As the IDs of the objects are not known until the script is applied, the
+// IDs that the Script returns are handles for MySQL user variables (ie:
+// @subnet_3), which the script sets by looking up the objects when it is
+// applied. Objects that reference other objects by handle are written with
+// the variables in place of IDs, so lookups must come before the objects that
+// reference them, as they do in a migration.
+type Script struct {
+	mu  sync.Mutex
+	out io.Writer
+	w   *bufio.Writer
+	err error
+
+	// The last handle returned.
+	handle int
+
+	// The handles of the VLANs looked up, keyed by number.
+	vlans map[int]int
+
+	// The handles of the subnets looked up, keyed by section ID and CIDR.
+	subnets map[string]int
+
+	// The devices created, with handles for IDs.
+	devices []devices.Device
+
+	// The device types created, with handles for IDs.
+	deviceTypes []devices.DeviceType
+
+	// The VRFs created, with handles for IDs.
+	vrfs []vrfs.VRF
+
+	// The nameserver sets created, with handles for IDs.
+	nameservers []nameservers.Nameserver
+
+	// The L2 domains created, with handles for IDs.
+	l2Domains []l2domains.Domain
+
+	// The user groups created, with handles for IDs.
+	groups []users.Group
+}
+
+// NewScript returns a new Script writing to w, and writes the start of the
+// script. Close must be called to finish the script.
+func NewScript(w io.Writer) *Script {
+	s := &Script{
+		out:     w,
+		w:       bufio.NewWriter(w),
+		vlans:   make(map[int]int),
+		subnets: make(map[string]int),
+	}
+	s.write(scriptHeader)
+	return s
+}
+
+// Close finishes the script, committing the transaction if commit is true,
+// and rolling it back otherwise, flushes it, and closes the writer if it is an
+// io.Closer. The first error writing the script is returned.
+func (s *Script) Close(commit bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if commit {
+		s.write("\ncommit;")
+	} else {
+		s.write("\n-- The migration failed, so nothing is applied.\nrollback;")
+	}
+	if err := s.w.Flush(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if c, ok := s.out.(io.Closer); ok {
+		if err := c.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+	return s.err
+}
+
+// write writes statements to the script. The caller must hold the lock.
+func (s *Script) write(lines ...string) error {
+	for _, v := range lines {
+		if s.err != nil {
+			break
+		}
+		_, s.err = fmt.Fprintln(s.w, v)
+	}
+	if s.err != nil {
+		return fmt.Errorf("error writing SQL script: %s", s.err)
+	}
+	return nil
+}
+
+// comment returns a comment introducing an object in the script.
+func comment(format string, args ...interface{}) string {
+	return "\n-- " + strings.Replace(fmt.Sprintf(format, args...), "\n", " ", -1)
+}
+
+// nextHandle returns a new handle. The caller must hold the lock.
+func (s *Script) nextHandle() int {
+	s.handle++
+	return s.handle
+}
+
+// variable returns the variable for a handle of the supplied kind.
+func variable(kind string, handle int) expr {
+	return expr(fmt.Sprintf("@%s_%d", kind, handle))
+}
+
+// CreateVLAN writes a VLAN in the default L2 domain, unless a handle for one
+// is supplied, setting the supplied custom fields, if any. The VLAN is not
+// written if its domain already has one with the same number when the script
+// is applied.
+func (s *Script) CreateVLAN(v vlans.VLAN, fields map[string]string) error {
+	domain := v.DomainID
+	if v.DomainID == 0 {
+		v.DomainID = defaultDomainID
+	}
+	r, err := vlanRow(v, fields)
+	if err != nil {
+		return fmt.Errorf("error adding VLAN number %d: %s", v.Number, err)
+	}
+	var id interface{} = v.DomainID
+	if domain != 0 {
+		id = variable("l2domain", domain)
+		r.replace("domainId", id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(comment("VLAN number %d (%s)", v.Number, v.Name),
+		fmt.Sprintf("insert into vlans (%s) select %s from dual where not exists (select 1 from vlans where domainId = %s and number = %d);",
+			r.columnList(), r.literals(), literal(id), v.Number))
+}
+
+// CreateSubnet writes a subnet, setting the supplied custom fields, if any.
+// When the script is applied, the subnet is nested under the narrowest subnet
+// in its section that contains it, and is given the permissions of its
+// section unless it has its own, as with Sink. The subnet is not written if
+// its section already has it when the script is applied.
+func (s *Script) CreateSubnet(v subnets.Subnet, fields map[string]string) error {
+	cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+	addr, err := decimal(v.SubnetAddress)
+	if err != nil {
+		return fmt.Errorf("error creating subnet %s: %s", cidr, err)
+	}
+	parents, err := parentConditions(v.SubnetAddress, v.Mask)
+	if err != nil {
+		return fmt.Errorf("error creating subnet %s: %s", cidr, err)
+	}
+	r, err := subnetRow(v, addr, fields)
+	if err != nil {
+		return fmt.Errorf("error creating subnet %s: %s", cidr, err)
+	}
+	if v.VLANID != 0 {
+		r.replace("vlanId", variable("vlan", v.VLANID))
+	}
+	if v.VRFID != 0 {
+		r.replace("vrfId", variable("vrf", v.VRFID))
+	}
+	if v.NameserverID != 0 {
+		r.replace("nameserverId", variable("nameserver", v.NameserverID))
+	}
+	if v.Permissions == "" {
+		r.replace("permissions", expr(fmt.Sprintf("(select permissions from sections where id = %d)", v.SectionID)))
+	}
+
+	lines := []string{comment("Subnet %s in section %d", cidr, v.SectionID)}
+	if parents != "" {
+		lines = append(lines, fmt.Sprintf("set @master = (select id from subnets where sectionId = %d and (%s) order by cast(mask as unsigned) desc limit 1);", v.SectionID, parents))
+		r.replace("masterSubnetId", expr("coalesce(@master, 0)"))
+	}
+	lines = append(lines, fmt.Sprintf("insert into subnets (%s) select %s from dual where not exists (select 1 from subnets where sectionId = %d and subnet = %s and mask = %s);",
+		r.columnList(), r.literals(), v.SectionID, literal(addr), literal(strconv.Itoa(v.Mask))))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(lines...)
+}
+
+// parentConditions returns the conditions matching each of the subnets that
+// could contain the subnet addr/mask, down to a /8 as in parentSubnetID, or
+// blank if there are none.
+func parentConditions(addr string, mask int) (string, error) {
+	var out []string
+	for n := mask - 1; n >= 8; n-- {
+		_, parent, err := net.ParseCIDR(fmt.Sprintf("%s/%d", addr, n))
+		if err != nil {
+			return "", fmt.Errorf("error parsing subnet/CIDR %s/%d: %s", addr, mask, err)
+		}
+		dec, err := decimal(parent.IP.String())
+		if err != nil {
+			return "", err
+		}
+		out = append(out, fmt.Sprintf("(subnet = %s and mask = %s)", literal(dec), literal(strconv.Itoa(n))))
+	}
+	return strings.Join(out, " or "), nil
+}
+
+// CreateDevice writes a device, and records a handle for it that is listed
+// by Devices. Its type ID must be a handle returned by the Script.
+func (s *Script) CreateDevice(d devices.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.ID = s.nextHandle()
+	r := deviceRow(d)
+	if d.Type != 0 {
+		r.replace("type", variable("devicetype", d.Type))
+	}
+	err := s.write(comment("Device %s", d.Hostname), r.statement("devices"),
+		fmt.Sprintf("set %s = last_insert_id();", variable("device", d.ID)))
+	if err != nil {
+		return err
+	}
+	s.devices = append(s.devices, d)
+	return nil
+}
+
+// CreateDeviceType writes a device type, and records a handle for it that is
+// listed by DeviceTypes. As the device types in the new PHPIPAM instance are
+// not known until the script is applied, the type is only inserted if there
+// is no type with the same name, and is then looked up by name.
+func (s *Script) CreateDeviceType(t devices.DeviceType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.ID = s.nextHandle()
+	r := deviceTypeRow(t)
+	err := s.write(comment("Device type %s", t.Name),
+		fmt.Sprintf("insert into deviceTypes (%s) select %s from dual where not exists (select 1 from deviceTypes where tname = %s);",
+			r.columnList(), r.literals(), literal(t.Name)),
+		fmt.Sprintf("set %s = (select tid from deviceTypes where tname = %s order by tid limit 1);", variable("devicetype", t.ID), literal(t.Name)))
+	if err != nil {
+		return err
+	}
+	s.deviceTypes = append(s.deviceTypes, t)
+	return nil
+}
+
+// CreateVRF writes a VRF, and records a handle for it that is listed by VRFs.
+func (s *Script) CreateVRF(v vrfs.VRF) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v.ID = s.nextHandle()
+	err := s.write(comment("VRF %s", v.Name), vrfRow(v).statement("vrf"),
+		fmt.Sprintf("set %s = last_insert_id();", variable("vrf", v.ID)))
+	if err != nil {
+		return err
+	}
+	s.vrfs = append(s.vrfs, v)
+	return nil
+}
+
+// CreateL2Domain writes an L2 domain, unless one of the same name already
+// exists when the script is applied, and records a handle for it that is
+// listed by L2Domains.
+func (s *Script) CreateL2Domain(d l2domains.Domain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.ID = s.nextHandle()
+	r := l2DomainRow(d)
+	err := s.write(comment("L2 domain %s", d.Name),
+		fmt.Sprintf("insert into vlanDomains (%s) select %s from dual where not exists (select 1 from vlanDomains where name = %s);",
+			r.columnList(), r.literals(), literal(d.Name)),
+		fmt.Sprintf("set %s = (select id from vlanDomains where name = %s order by id limit 1);", variable("l2domain", d.ID), literal(d.Name)))
+	if err != nil {
+		return err
+	}
+	s.l2Domains = append(s.l2Domains, d)
+	return nil
+}
+
+// CreateNameserver writes a nameserver set, unless one of the same name
+// already exists when the script is applied, and records a handle for it that
+// is listed by Nameservers.
+func (s *Script) CreateNameserver(n nameservers.Nameserver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n.ID = s.nextHandle()
+	r := nameserverRow(n)
+	err := s.write(comment("Nameserver set %s", n.Name),
+		fmt.Sprintf("insert into nameservers (%s) select %s from dual where not exists (select 1 from nameservers where name = %s);",
+			r.columnList(), r.literals(), literal(n.Name)),
+		fmt.Sprintf("set %s = (select id from nameservers where name = %s order by id limit 1);", variable("nameserver", n.ID), literal(n.Name)))
+	if err != nil {
+		return err
+	}
+	s.nameservers = append(s.nameservers, n)
+	return nil
+}
+
+// CreateAddress writes an IP address, setting the supplied custom fields, if
+// any. Its subnet and device IDs must be handles returned by the Script.
+func (s *Script) CreateAddress(a addresses.Address, fields map[string]string) error {
+	addr, err := decimal(a.IPAddress)
+	if err != nil {
+		return fmt.Errorf("error adding IP address %s: %s", a.IPAddress, err)
+	}
+	r, err := addressRow(a, addr, fields)
+	if err != nil {
+		return fmt.Errorf("error adding IP address %s: %s", a.IPAddress, err)
+	}
+	if a.SubnetID != 0 {
+		r.replace("subnetId", variable("subnet", a.SubnetID))
+	}
+	if a.DeviceID != 0 {
+		r.replace("switch", variable("device", a.DeviceID))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(comment("IP address %s", a.IPAddress), r.statement("ipaddresses"))
+}
+
+// CreateRequest writes a pending IP request. Its subnet ID must be a handle
+// returned by the Script.
+func (s *Script) CreateRequest(v requests.Request) error {
+	addr, err := requestDecimal(v)
+	if err != nil {
+		return fmt.Errorf("error adding IP request for %s: %s", requestAddress(v), err)
+	}
+	r := requestRow(v, addr)
+	if v.SubnetID != 0 {
+		r.replace("subnetId", variable("subnet", v.SubnetID))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(comment("IP request for %s", requestAddress(v)), r.statement("requests"))
+}
+
+// CreateChange writes a changelog entry about a subnet or an address, unless
+// there is already an entry for the same action on it at the same time when
+// the script is applied. Its subnet ID must be a handle returned by the
+// Script. Entries about addresses that do not exist are left out. Changes are
+// attributed to the user with the entry's username, or to no user (ID 0) if
+// there is none.
+func (s *Script) CreateChange(e changelog.Entry) error {
+	object := variable("subnet", e.SubnetID)
+	var lines []string
+	if e.Type == changelog.AddressType {
+		addr, err := decimal(e.IPAddress)
+		if err != nil {
+			return fmt.Errorf("error adding changelog entry for IP address %s: %s", e.IPAddress, err)
+		}
+		lines = append(lines, fmt.Sprintf("set @object = (select id from ipaddresses where subnetId = %s and ip_addr = %s order by id limit 1);", object, literal(addr)))
+		object = expr("@object")
+	}
+	user := expr("0")
+	if e.Username != "" {
+		user = expr(fmt.Sprintf("coalesce((select id from users where username = %s order by id limit 1), 0)", literal(e.Username)))
+	}
+	r := changeRow(e, object, user)
+	lines = append(lines, fmt.Sprintf("insert into changelog (%s) select %s from dual where %s is not null and not exists (select 1 from changelog where ctype = %s and coid = %s and caction = %s and cdate = %s);",
+		r.columnList(), r.literals(), object, literal(e.Type), object, literal(e.Action), literal(e.Date)))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(append([]string{comment("Changelog entry for %s (%s at %s)", changeObject(e), e.Action, e.Date)}, lines...)...)
+}
+
+// CreateGroup writes a user group, and records a handle for it that is listed
+// by Groups. As with device types, the group is only inserted if there is no
+// group with the same name, and is then looked up by name.
+func (s *Script) CreateGroup(g users.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g.ID = s.nextHandle()
+	r := groupRow(g)
+	err := s.write(comment("Group %s", g.Name),
+		fmt.Sprintf("insert into userGroups (%s) select %s from dual where not exists (select 1 from userGroups where g_name = %s);",
+			r.columnList(), r.literals(), literal(g.Name)),
+		fmt.Sprintf("set %s = (select g_id from userGroups where g_name = %s order by g_id limit 1);", variable("group", g.ID), literal(g.Name)))
+	if err != nil {
+		return err
+	}
+	s.groups = append(s.groups, g)
+	return nil
+}
+
+// CreateUser writes a user, unless there is already a user with the same
+// username when the script is applied. Its group IDs must be handles returned
+// by the Script.
+func (s *Script) CreateUser(u users.User) error {
+	groups := interface{}(groupsJSON(nil))
+	if len(u.Groups) > 0 {
+		// Build the JSON object of the group IDs from the group variables.
+		var parts []string
+		for i, v := range u.Groups {
+			sep := `","`
+			if i == 0 {
+				sep = `{"`
+			}
+			parts = append(parts, literal(sep), string(variable("group", v)), literal(`":"`), string(variable("group", v)))
+		}
+		groups = expr("concat(" + strings.Join(append(parts, literal(`"}`)), ", ") + ")")
+	}
+	r, err := userRow(u, groups)
+	if err != nil {
+		return fmt.Errorf("error adding user %s: %s", u.Username, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(comment("User %s", u.Username),
+		fmt.Sprintf("insert into users (%s) select %s from dual where not exists (select 1 from users where username = %s);",
+			r.columnList(), r.literals(), literal(u.Username)))
+}
+
+// CreateCustomField writes the statements adding a column for a custom field
+// to table, unless the column exists when the script is applied.
+//
+// MySQL commits the transaction before any schema change, so custom fields
+// must be created before any objects are written, and a new transaction is
+// started after the column is added.
+func (s *Script) CreateCustomField(table, name string) error {
+	q, err := addColumn(table, name)
+	if err != nil {
+		return fmt.Errorf("error adding custom field %s to %s: %s", name, table, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(comment("Custom field %s of %s", name, table),
+		fmt.Sprintf("set @alter = (select if(count(*) = 0, %s, 'do 0') from information_schema.columns where table_schema = database() and table_name = %s and column_name = %s);",
+			literal(q), literal(table), literal(name)),
+		"prepare alter_column from @alter;",
+		"execute alter_column;",
+		"deallocate prepare alter_column;",
+		"start transaction;")
+}
+
+// CustomFields returns no fields, as the columns of the new database are not
+// known until the script is applied. Columns that already exist are skipped
+// then.
+func (s *Script) CustomFields(table string) ([]string, error) {
+	return nil, nil
+}
+
+// Devices lists the devices created by the script, with handles for IDs.
+func (s *Script) Devices() ([]devices.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]devices.Device(nil), s.devices...), nil
+}
+
+// DeviceTypes lists the device types created by the script, with handles for
+// IDs.
+func (s *Script) DeviceTypes() ([]devices.DeviceType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]devices.DeviceType(nil), s.deviceTypes...), nil
+}
+
+// Groups lists the user groups created by the script, with handles for IDs.
+func (s *Script) Groups() ([]users.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]users.Group(nil), s.groups...), nil
+}
+
+// Users returns no users, as the users in the new database are not known
+// until the script is applied. Users that already exist are skipped then.
+func (s *Script) Users() ([]users.User, error) {
+	return nil, nil
+}
+
+// VRFs lists the VRFs created by the script, with handles for IDs.
+func (s *Script) VRFs() ([]vrfs.VRF, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]vrfs.VRF(nil), s.vrfs...), nil
+}
+
+// L2Domains lists the L2 domains created by the script, with handles for IDs.
+func (s *Script) L2Domains() ([]l2domains.Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]l2domains.Domain(nil), s.l2Domains...), nil
+}
+
+// Nameservers lists the nameserver sets created by the script, with handles
+// for IDs.
+func (s *Script) Nameservers() ([]nameservers.Nameserver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]nameservers.Nameserver(nil), s.nameservers...), nil
+}
+
+// VLANID returns a handle for the VLAN with number n, which is looked up when
+// the script is applied. If the number is used by more than one VLAN, the
+// first one created is used, as with Sink.
+func (s *Script) VLANID(n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.vlans[n]; ok {
+		return h, nil
+	}
+	h := s.nextHandle()
+	err := s.write(comment("Look up VLAN number %d", n),
+		fmt.Sprintf("set %s = (select vlanId from vlans where number = %d order by vlanId limit 1);", variable("vlan", h), n))
+	if err != nil {
+		return 0, err
+	}
+	s.vlans[n] = h
+	return h, nil
+}
+
+// VLANIDs returns no VLANs, as the VLANs in the new PHPIPAM instance are not
+// known until the script is applied. Each VLAN number is looked up with
+// VLANID instead.
+func (s *Script) VLANIDs() (map[int]int, error) {
+	return map[int]int{}, nil
+}
+
+// SubnetID returns a handle for the subnet with the CIDR subnet address in
+// the section with ID sectionID, or in any section if sectionID is 0, which is
+// looked up when the script is applied.
+func (s *Script) SubnetID(sectionID int, cidr string) (int, error) {
+	ip, n, err := net.ParseCIDR(cidr)
+	if err != nil || ip.To4() == nil {
+		return 0, fmt.Errorf("invalid IPv4 subnet %s", cidr)
+	}
+	addr, _ := decimal(n.IP.String())
+	mask, _ := n.Mask.Size()
+	cond := fmt.Sprintf("subnet = %s and mask = %s", literal(addr), literal(strconv.Itoa(mask)))
+	if sectionID != 0 {
+		cond = fmt.Sprintf("sectionId = %d and %s", sectionID, cond)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fmt.Sprintf("%d/%s", sectionID, cidr)
+	if h, ok := s.subnets[key]; ok {
+		return h, nil
+	}
+	h := s.nextHandle()
+	err = s.write(comment("Look up subnet %s in section %d", cidr, sectionID),
+		fmt.Sprintf("set %s = (select id from subnets where %s order by id limit 1);", variable("subnet", h), cond))
+	if err != nil {
+		return 0, err
+	}
+	s.subnets[key] = h
+	return h, nil
+}
+
+// SubnetIDs returns no subnets, as the subnets in the new PHPIPAM instance
+// are not known until the script is applied. Each subnet is looked up with
+// SubnetID instead.
+func (s *Script) SubnetIDs(sectionID int) (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
+// VerifyAddresses returns an error, as there is nothing to verify against
+// until the script is applied.
+func (s *Script) VerifyAddresses(addrs []addresses.Address) ([]verify.Mismatch, error) {
+	return nil, errors.New("IP addresses cannot be verified against a SQL script")
+}
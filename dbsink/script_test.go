@@ -0,0 +1,157 @@
+package dbsink
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+func TestScript(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewScript(&buf)
+
+	if err := s.CreateCustomField("ipaddresses", "custom_Rack"); err != nil {
+		t.Fatalf("Error writing custom field: %s", err)
+	}
+	if err := s.CreateVLAN(vlans.VLAN{Name: "servers", Number: 100, Description: "it's\nnew"}, nil); err != nil {
+		t.Fatalf("Error writing VLAN: %s", err)
+	}
+	vlanID, err := s.VLANID(100)
+	if err != nil {
+		t.Fatalf("Error looking up VLAN: %s", err)
+	}
+	if again, _ := s.VLANID(100); again != vlanID {
+		t.Fatalf("Expected VLAN handle %d to be reused, got %d", vlanID, again)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 14, SectionID: 2, VLANID: vlanID}, map[string]string{"custom_site": "yvr"}); err != nil {
+		t.Fatalf("Error writing subnet: %s", err)
+	}
+	if err := s.CreateDevice(devices.Device{Hostname: "sw1"}); err != nil {
+		t.Fatalf("Error writing device: %s", err)
+	}
+	devs, _ := s.Devices()
+	if len(devs) != 1 || devs[0].ID == 0 {
+		t.Fatalf("Unexpected devices %#v", devs)
+	}
+	subnetID, err := s.SubnetID(2, "10.0.0.0/14")
+	if err != nil {
+		t.Fatalf("Error looking up subnet: %s", err)
+	}
+	if err := s.CreateAddress(addresses.Address{SubnetID: subnetID, IPAddress: "10.0.0.1", DeviceID: devs[0].ID}, nil); err != nil {
+		t.Fatalf("Error writing address: %s", err)
+	}
+	if err := s.CreateVRF(vrfs.VRF{Name: "customers", RD: "65000:1"}); err != nil {
+		t.Fatalf("Error writing VRF: %s", err)
+	}
+	found, _ := s.VRFs()
+	if len(found) != 1 || found[0].ID == 0 {
+		t.Fatalf("Unexpected VRFs %#v", found)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.4.0.0", Mask: 14, SectionID: 2, VRFID: found[0].ID}, nil); err != nil {
+		t.Fatalf("Error writing subnet in VRF: %s", err)
+	}
+	if err := s.CreateDeviceType(devices.DeviceType{Name: "Switch", Description: "Core"}); err != nil {
+		t.Fatalf("Error writing device type: %s", err)
+	}
+	types, _ := s.DeviceTypes()
+	if len(types) != 1 || types[0].ID == 0 {
+		t.Fatalf("Unexpected device types %#v", types)
+	}
+	if err := s.CreateDevice(devices.Device{Hostname: "sw2", Type: types[0].ID}); err != nil {
+		t.Fatalf("Error writing device with type: %s", err)
+	}
+	if err := s.CreateRequest(requests.Request{SubnetID: subnetID, IPAddress: "10.0.0.9", Hostname: "web"}); err != nil {
+		t.Fatalf("Error writing request: %s", err)
+	}
+	if err := s.CreateGroup(users.Group{Name: "Network"}); err != nil {
+		t.Fatalf("Error writing group: %s", err)
+	}
+	groups, _ := s.Groups()
+	if len(groups) != 1 || groups[0].ID == 0 {
+		t.Fatalf("Unexpected groups %#v", groups)
+	}
+	if err := s.CreateUser(users.User{Username: "jo", Groups: []int{groups[0].ID}}); err != nil {
+		t.Fatalf("Error writing user: %s", err)
+	}
+	if err := s.CreateChange(changelog.Entry{Type: changelog.AddressType, SubnetID: subnetID, IPAddress: "10.0.0.1", Username: "jo", Action: "edit", Date: "2015-02-01 09:00:00"}); err != nil {
+		t.Fatalf("Error writing changelog entry: %s", err)
+	}
+	if err := s.CreateNameserver(nameservers.Nameserver{Name: "Public", Addresses: "8.8.8.8;8.8.4.4"}); err != nil {
+		t.Fatalf("Error writing nameserver set: %s", err)
+	}
+	sets, _ := s.Nameservers()
+	if len(sets) != 1 || sets[0].ID == 0 {
+		t.Fatalf("Unexpected nameserver sets %#v", sets)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.8.0.0", Mask: 14, SectionID: 2, NameserverID: sets[0].ID}, nil); err != nil {
+		t.Fatalf("Error writing subnet with nameservers: %s", err)
+	}
+	if err := s.CreateL2Domain(l2domains.Domain{Name: "datacenter", Permissions: "2"}); err != nil {
+		t.Fatalf("Error writing L2 domain: %s", err)
+	}
+	domains, _ := s.L2Domains()
+	if len(domains) != 1 || domains[0].ID == 0 {
+		t.Fatalf("Unexpected L2 domains %#v", domains)
+	}
+	if err := s.CreateVLAN(vlans.VLAN{Name: "storage", Number: 100, DomainID: domains[0].ID}, nil); err != nil {
+		t.Fatalf("Error writing VLAN in L2 domain: %s", err)
+	}
+	if err := s.Close(true); err != nil {
+		t.Fatalf("Error closing script: %s", err)
+	}
+
+	for _, expected := range []string{
+		"start transaction;\n\n-- Custom field custom_Rack of ipaddresses\nset @alter = (select if(count(*) = 0, 'alter table `ipaddresses` add column `custom_Rack` varchar(255) default null', 'do 0') from information_schema.columns where table_schema = database() and table_name = 'ipaddresses' and column_name = 'custom_Rack');\nprepare alter_column from @alter;\nexecute alter_column;\ndeallocate prepare alter_column;\nstart transaction;\n\n-- VLAN number 100 (servers)\n",
+		"insert into vlans (`domainId`, `name`, `number`, `description`) select 1, 'servers', 100, 'it\\'s\\nnew' from dual where not exists (select 1 from vlans where domainId = 1 and number = 100);\n",
+		"set @vlan_1 = (select vlanId from vlans where number = 100 order by vlanId limit 1);\n",
+		"set @master = (select id from subnets where sectionId = 2 and ((subnet = '167772160' and mask = '13') or (subnet = '167772160' and mask = '12') or (subnet = '167772160' and mask = '11') or (subnet = '167772160' and mask = '10') or (subnet = '167772160' and mask = '9') or (subnet = '167772160' and mask = '8')) order by cast(mask as unsigned) desc limit 1);\n",
+		"insert into subnets (`subnet`, `mask`, `sectionId`, `vlanId`, `custom_site`, `permissions`, `masterSubnetId`) select '167772160', '14', 2, @vlan_1, 'yvr', (select permissions from sections where id = 2), coalesce(@master, 0) from dual where not exists (select 1 from subnets where sectionId = 2 and subnet = '167772160' and mask = '14');\n",
+		"insert into devices (`hostname`) values ('sw1');\nset @device_2 = last_insert_id();\n",
+		"set @subnet_3 = (select id from subnets where sectionId = 2 and subnet = '167772160' and mask = '14' order by id limit 1);\n",
+		"insert into ipaddresses (`subnetId`, `ip_addr`, `switch`) values (@subnet_3, '167772161', @device_2);\n",
+		"insert into vrf (`name`, `rd`) values ('customers', '65000:1');\nset @vrf_4 = last_insert_id();\n",
+		"insert into subnets (`subnet`, `mask`, `sectionId`, `vrfId`, `permissions`, `masterSubnetId`) select '168034304', '14', 2, @vrf_4, ",
+		"insert into deviceTypes (`tname`, `tdescription`) select 'Switch', 'Core' from dual where not exists (select 1 from deviceTypes where tname = 'Switch');\nset @devicetype_5 = (select tid from deviceTypes where tname = 'Switch' order by tid limit 1);\n",
+		"insert into devices (`hostname`, `type`) values ('sw2', @devicetype_5);\nset @device_6 = last_insert_id();\n",
+		"insert into userGroups (`g_name`) select 'Network' from dual where not exists (select 1 from userGroups where g_name = 'Network');\nset @group_7 = (select g_id from userGroups where g_name = 'Network' order by g_id limit 1);\n",
+		"insert into users (`username`, `role`, `groups`, `passChange`) select 'jo', 'User', concat('{\\\"', @group_7, '\\\":\\\"', @group_7, '\\\"}'), 'Yes' from dual where not exists (select 1 from users where username = 'jo');\n",
+		"set @object = (select id from ipaddresses where subnetId = @subnet_3 and ip_addr = '167772161' order by id limit 1);\ninsert into changelog (`ctype`, `coid`, `cuser`, `caction`, `cdate`) select 'ip_addr', @object, coalesce((select id from users where username = 'jo' order by id limit 1), 0), 'edit', '2015-02-01 09:00:00' from dual where @object is not null and not exists (select 1 from changelog where ctype = 'ip_addr' and coid = @object and caction = 'edit' and cdate = '2015-02-01 09:00:00');\n",
+		"insert into nameservers (`name`, `namesrv1`) select 'Public', '8.8.8.8;8.8.4.4' from dual where not exists (select 1 from nameservers where name = 'Public');\nset @nameserver_8 = (select id from nameservers where name = 'Public' order by id limit 1);\n",
+		"insert into subnets (`subnet`, `mask`, `sectionId`, `nameserverId`, `permissions`, `masterSubnetId`) select '168296448', '14', 2, @nameserver_8, ",
+		"insert into vlanDomains (`name`, `permissions`) select 'datacenter', '2' from dual where not exists (select 1 from vlanDomains where name = 'datacenter');\nset @l2domain_9 = (select id from vlanDomains where name = 'datacenter' order by id limit 1);\n",
+		"insert into vlans (`domainId`, `name`, `number`) select @l2domain_9, 'storage', 100 from dual where not exists (select 1 from vlans where domainId = @l2domain_9 and number = 100);\n",
+		"-- IP request for 10.0.0.9\ninsert into requests (`subnetId`, `ip_addr`, `hostname`, `processed`) values (@subnet_3, '167772169', 'web', 0);\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("Expected script to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+	if !strings.HasSuffix(buf.String(), "\ncommit;\n") {
+		t.Fatalf("Expected script to end with a commit, got:\n%s", buf.String())
+	}
+}
+
+func TestScriptRollback(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewScript(&buf)
+	if err := s.CreateAddress(addresses.Address{IPAddress: "10.0.0.1"}, map[string]string{"bad name": "x"}); err == nil {
+		t.Fatal("Expected error for invalid custom field name, got none")
+	}
+	if err := s.Close(false); err != nil {
+		t.Fatalf("Error closing script: %s", err)
+	}
+	if strings.Contains(buf.String(), "insert") || !strings.HasSuffix(buf.String(), "\nrollback;\n") {
+		t.Fatalf("Expected script to only roll back, got:\n%s", buf.String())
+	}
+}
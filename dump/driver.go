@@ -0,0 +1,136 @@
+package dump
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Driver is a database/sql driver that queries the tables of a Dump. The data
+// source name is ignored. The dump is read-only.
+type Driver struct {
+	// The dump to query.
+	Dump *Dump
+}
+
+// Open implements driver.Driver.Open for Driver.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	return &dumpConn{dump: d.Dump}, nil
+}
+
+// dumpConn is a driver.Conn that queries a dump.
+type dumpConn struct {
+	dump *Dump
+}
+
+// Prepare implements driver.Conn.Prepare for dumpConn.
+func (c *dumpConn) Prepare(query string) (driver.Stmt, error) {
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return &dumpStmt{query: q, dump: c.dump}, nil
+}
+
+// Close implements driver.Conn.Close for dumpConn.
+func (c *dumpConn) Close() error {
+	return nil
+}
+
+// Begin implements driver.Conn.Begin for dumpConn. Transactions are not
+// supported on a dump.
+func (c *dumpConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported on a database dump")
+}
+
+// Query implements driver.Queryer.Query for dumpConn.
+func (c *dumpConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return runQuery(c.dump, q, args)
+}
+
+// dumpStmt is a driver.Stmt that queries a dump.
+type dumpStmt struct {
+	query *query
+	dump  *Dump
+}
+
+// Close implements driver.Stmt.Close for dumpStmt.
+func (s *dumpStmt) Close() error {
+	return nil
+}
+
+// NumInput implements driver.Stmt.NumInput for dumpStmt.
+func (s *dumpStmt) NumInput() int {
+	return s.query.args
+}
+
+// Exec implements driver.Stmt.Exec for dumpStmt. Writes are not supported on
+// a dump.
+func (s *dumpStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("statement execution is not supported on a database dump")
+}
+
+// Query implements driver.Stmt.Query for dumpStmt.
+func (s *dumpStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return runQuery(s.dump, s.query, args)
+}
+
+// runQuery runs q against d with the supplied arguments.
+func runQuery(d *Dump, q *query, args []driver.Value) (driver.Rows, error) {
+	var values []*string
+	for _, v := range args {
+		var s *string
+		switch t := v.(type) {
+		case nil:
+		case []byte:
+			str := string(t)
+			s = &str
+		default:
+			str := fmt.Sprint(t)
+			s = &str
+		}
+		values = append(values, s)
+	}
+	res, err := d.run(q, values)
+	if err != nil {
+		return nil, err
+	}
+	return &dumpRows{result: res}, nil
+}
+
+// dumpRows is a driver.Rows implementation over a query result.
+type dumpRows struct {
+	result *result
+	pos    int
+}
+
+// Columns implements driver.Rows.Columns for dumpRows.
+func (r *dumpRows) Columns() []string {
+	return r.result.columns
+}
+
+// Close implements driver.Rows.Close for dumpRows.
+func (r *dumpRows) Close() error {
+	return nil
+}
+
+// Next implements driver.Rows.Next for dumpRows.
+func (r *dumpRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.result.rows) {
+		return io.EOF
+	}
+	for i, v := range r.result.rows[r.pos] {
+		if v == nil {
+			dest[i] = nil
+			continue
+		}
+		dest[i] = []byte(*v)
+	}
+	r.pos++
+	return nil
+}
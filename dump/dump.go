@@ -0,0 +1,401 @@
+// Package dump reads the tables of a legacy PHPIPAM database out of a
+// mysqldump file, so that the migration can be run from a backup without a
+// running MySQL server.
+//
+// Only the CREATE TABLE and INSERT statements in the dump are interpreted.
+// Everything else (SET statements, locks, and the versioned /*! */ comments
+// that mysqldump wraps them in) is skipped. The tables can then be queried with
+// the database/sql driver in this package, which supports the subset of SQL
+// that the migrator uses.
+package dump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Table is a table read from a dump.
+type Table struct {
+	// The name of the table.
+	Name string
+
+	// The names of the table's columns, in order.
+	Columns []string
+
+	// The types of the table's columns, in the same order as Columns, ie:
+	// int(11). The types are blank if the table was not created by a CREATE
+	// TABLE statement.
+	Types []string
+
+	// The rows of the table. NULL values are represented by nil.
+	Rows [][]*string
+}
+
+// column returns the index of the named column in the table, or -1 if the
+// table has no such column. Like MySQL, column names are case-insensitive.
+func (t *Table) column(name string) int {
+	for i, v := range t.Columns {
+		if strings.EqualFold(v, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Dump is the tables read from a dump, keyed by table name.
+type Dump struct {
+	Tables map[string]*Table
+}
+
+// Load reads the dump in the file at path.
+func Load(path string) (*Dump, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return d, nil
+}
+
+// Parse reads a dump from r.
+func Parse(r io.Reader) (*Dump, error) {
+	d := &Dump{Tables: make(map[string]*Table)}
+	sc := &scanner{r: bufio.NewReader(r), line: 1}
+	for {
+		stmt, line, err := sc.next()
+		if err == io.EOF {
+			return d, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", sc.line, err)
+		}
+		if err := d.exec(stmt); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+}
+
+// exec applies a statement from the dump.
+func (d *Dump) exec(stmt string) error {
+	toks, err := lex(stmt)
+	if err != nil {
+		return err
+	}
+	p := &parser{toks: toks}
+	switch {
+	case p.keywords("create", "table"):
+		return d.createTable(p)
+	case p.keywords("insert", "into"), p.keywords("insert", "ignore", "into"), p.keywords("replace", "into"):
+		return d.insert(p)
+	}
+	return nil
+}
+
+// createTable adds the table defined by a CREATE TABLE statement, replacing
+// any earlier table with the same name, as the DROP TABLE before it in the
+// dump would.
+func (d *Dump) createTable(p *parser) error {
+	p.keywords("if", "not", "exists")
+	name, err := p.table()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	t := &Table{Name: name}
+	for {
+		// Each definition is either a column, which starts with its name, or an
+		// index or constraint, which starts with a keyword.
+		tok := p.peek()
+		if tok.kind == tokIdent && (tok.quoted || !tableKeywords[strings.ToLower(tok.text)]) {
+			t.Columns = append(t.Columns, tok.text)
+			t.Types = append(t.Types, columnType(p.toks[p.pos+1:]))
+		}
+		depth := 0
+		for ; !p.done(); p.pos++ {
+			tok := p.peek()
+			if tok.kind != tokPunct {
+				continue
+			}
+			if depth == 0 && (tok.text == "," || tok.text == ")") {
+				break
+			}
+			switch tok.text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+		}
+		if p.done() {
+			return fmt.Errorf("unterminated definition of table %s", name)
+		}
+		if p.next().text == ")" {
+			break
+		}
+	}
+	d.Tables[name] = t
+	return nil
+}
+
+// columnType returns the type at the start of toks, which follow a column name
+// in a CREATE TABLE statement, ie: varchar(255).
+func columnType(toks []token) string {
+	if len(toks) == 0 || toks[0].kind != tokIdent {
+		return ""
+	}
+	out := strings.ToLower(toks[0].text)
+	if len(toks) < 2 || toks[1].kind != tokPunct || toks[1].text != "(" {
+		return out
+	}
+	var args []string
+	for _, tok := range toks[2:] {
+		switch {
+		case tok.kind == tokPunct && tok.text == ")":
+			return fmt.Sprintf("%s(%s)", out, strings.Join(args, ","))
+		case tok.kind == tokString:
+			args = append(args, "'"+strings.Replace(tok.text, "'", "''", -1)+"'")
+		case tok.kind != tokPunct:
+			args = append(args, tok.text)
+		}
+	}
+	return out
+}
+
+// tableKeywords are the keywords that start the definitions in a CREATE TABLE
+// statement that are not columns.
+var tableKeywords = map[string]bool{
+	"primary":    true,
+	"key":        true,
+	"index":      true,
+	"unique":     true,
+	"fulltext":   true,
+	"spatial":    true,
+	"constraint": true,
+	"foreign":    true,
+	"check":      true,
+}
+
+// insert adds the rows in an INSERT statement to their table. If the statement
+// has a column list, the values are stored under the table's columns by name,
+// otherwise they are stored in the order of the table's columns.
+func (d *Dump) insert(p *parser) error {
+	name, err := p.table()
+	if err != nil {
+		return err
+	}
+	t := d.Tables[name]
+	var cols []string
+	if p.accept("(") {
+		for {
+			col, err := p.name()
+			if err != nil {
+				return err
+			}
+			cols = append(cols, col)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+	}
+	if t == nil {
+		if cols == nil {
+			return fmt.Errorf("insert into table %s before it is created", name)
+		}
+		t = &Table{Name: name, Columns: cols}
+		d.Tables[name] = t
+	}
+	index := make([]int, len(t.Columns))
+	for i := range index {
+		index[i] = i
+	}
+	if cols != nil {
+		index = index[:len(cols)]
+		for i, col := range cols {
+			if index[i] = t.column(col); index[i] < 0 {
+				return fmt.Errorf("table %s has no column %s", name, col)
+			}
+		}
+	}
+
+	if !p.keywords("values") && !p.keywords("value") {
+		return fmt.Errorf("expected VALUES in insert into table %s", name)
+	}
+	for {
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		row := make([]*string, len(t.Columns))
+		for i := 0; ; i++ {
+			v, err := p.value()
+			if err != nil {
+				return err
+			}
+			if i >= len(index) {
+				return fmt.Errorf("too many values in insert into table %s", name)
+			}
+			row[index[i]] = v
+			if !p.accept(",") {
+				if i+1 != len(index) {
+					return fmt.Errorf("expected %d values in insert into table %s, got %d", len(index), name, i+1)
+				}
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		t.Rows = append(t.Rows, row)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if !p.done() {
+		return fmt.Errorf("unexpected %q after values in insert into table %s", p.peek().text, name)
+	}
+	return nil
+}
+
+// scanner splits a dump into statements.
+type scanner struct {
+	r    *bufio.Reader
+	line int
+}
+
+// next returns the next statement in the dump, without its terminating
+// semicolon, along with the line it started on. Comments outside of quoted
+// strings are dropped. io.EOF is returned once there are no more statements.
+func (s *scanner) next() (string, int, error) {
+	var b strings.Builder
+	var quote rune
+	start := s.line
+	for {
+		c, raw, err := s.readRune()
+		if err == io.EOF {
+			if quote != 0 {
+				return "", 0, fmt.Errorf("unterminated quoted string starting on line %d", start)
+			}
+			if stmt := strings.TrimSpace(b.String()); stmt != "" {
+				return stmt, start, nil
+			}
+			return "", 0, io.EOF
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		if c == '\n' {
+			s.line++
+		}
+		if strings.TrimSpace(b.String()) == "" {
+			start = s.line
+		}
+
+		switch {
+		case quote != 0:
+			b.WriteString(raw)
+			switch {
+			case c == '\\' && quote != '`':
+				// Keep the escaped character, which could be the quote.
+				c, raw, err := s.readRune()
+				if err != nil {
+					return "", 0, fmt.Errorf("unterminated quoted string starting on line %d", start)
+				}
+				if c == '\n' {
+					s.line++
+				}
+				b.WriteString(raw)
+			case c == quote:
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			b.WriteRune(c)
+		case c == ';':
+			if stmt := strings.TrimSpace(b.String()); stmt != "" {
+				return stmt, start, nil
+			}
+		case c == '#':
+			s.skipLine()
+		case c == '-' && s.peekIs("- ", "-\n", "-\r", "-\t"):
+			s.skipLine()
+		case c == '/' && s.peekIs("*"):
+			if err := s.skipComment(); err != nil {
+				return "", 0, err
+			}
+			b.WriteRune(' ')
+		default:
+			b.WriteString(raw)
+		}
+	}
+}
+
+// readRune reads the next rune like bufio.Reader.ReadRune, along with the
+// bytes it was read from. Bytes that are not valid UTF-8, such as the accented
+// characters of a latin1 dump, are returned as they are rather than replaced,
+// so that they can be transcoded later.
+func (s *scanner) readRune() (rune, string, error) {
+	c, size, err := s.r.ReadRune()
+	if err != nil {
+		return 0, "", err
+	}
+	if c == utf8.RuneError && size == 1 {
+		s.r.UnreadRune()
+		b, _ := s.r.ReadByte()
+		return c, string([]byte{b}), nil
+	}
+	return c, string(c), nil
+}
+
+// peekIs returns true if the upcoming input starts with any of prefixes.
+func (s *scanner) peekIs(prefixes ...string) bool {
+	for _, v := range prefixes {
+		if b, err := s.r.Peek(len(v)); err == nil && string(b) == v {
+			return true
+		}
+	}
+	// A -- comment can also run to the end of the file.
+	if _, err := s.r.Peek(2); err == io.EOF {
+		b, _ := s.r.Peek(1)
+		return string(b) == "-"
+	}
+	return false
+}
+
+// skipLine skips the rest of the current line.
+func (s *scanner) skipLine() {
+	if _, err := s.r.ReadString('\n'); err == nil {
+		s.line++
+	}
+}
+
+// skipComment skips a /* */ comment, after the opening slash.
+func (s *scanner) skipComment() error {
+	start := s.line
+	s.r.ReadRune()
+	var prev rune
+	for {
+		c, _, err := s.r.ReadRune()
+		if err != nil {
+			return fmt.Errorf("unterminated comment starting on line %d", start)
+		}
+		if c == '\n' {
+			s.line++
+		}
+		if prev == '*' && c == '/' {
+			return nil
+		}
+		prev = c
+	}
+}
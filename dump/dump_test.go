@@ -0,0 +1,238 @@
+package dump
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+)
+
+const testDump = `-- MySQL dump 10.13  Distrib 5.5.62, for debian-linux-gnu (x86_64)
+--
+-- Host: localhost    Database: phpipam
+-- ------------------------------------------------------
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET NAMES utf8 */;
+
+DROP TABLE IF EXISTS ` + "`vlans`" + `;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+CREATE TABLE ` + "`vlans`" + ` (
+  ` + "`vlanId`" + ` int(11) NOT NULL AUTO_INCREMENT,
+  ` + "`name`" + ` varchar(255) NOT NULL,
+  ` + "`number`" + ` int(4) DEFAULT NULL,
+  ` + "`description`" + ` text,
+  PRIMARY KEY (` + "`vlanId`" + `)
+) ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8;
+
+LOCK TABLES ` + "`vlans`" + ` WRITE;
+/*!40000 ALTER TABLE ` + "`vlans`" + ` DISABLE KEYS */;
+INSERT INTO ` + "`vlans`" + ` VALUES (1,'servers',100,'Servers; and \'things\''),(2,'users',200,'');
+/*!40000 ALTER TABLE ` + "`vlans`" + ` ENABLE KEYS */;
+UNLOCK TABLES;
+
+CREATE TABLE ` + "`subnets`" + ` (
+  ` + "`id`" + ` int(11) NOT NULL AUTO_INCREMENT,
+  ` + "`subnet`" + ` varchar(255) NOT NULL,
+  ` + "`mask`" + ` varchar(255) NOT NULL,
+  ` + "`sectionId`" + ` int(10) DEFAULT NULL,
+  ` + "`description`" + ` text,
+  ` + "`vlanId`" + ` int(11) DEFAULT NULL,
+  ` + "`state`" + ` enum('1','2','3') DEFAULT '1',
+  PRIMARY KEY (` + "`id`" + `),
+  KEY ` + "`sectionId`" + ` (` + "`sectionId`" + `)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO ` + "`subnets`" + ` VALUES (1,'167772160','8',1,'parent',NULL,'1'),(2,'167837696','24',1,'child',1,'1'),(3,'42540766411282592856903984951653826560','64',1,'v6',NULL,'1'),(4,'3232235520','16',2,'other section',2,'1');
+
+CREATE TABLE ` + "`ipaddresses`" + ` (
+  ` + "`id`" + ` int(11) NOT NULL AUTO_INCREMENT,
+  ` + "`subnetId`" + ` int(11) DEFAULT NULL,
+  ` + "`ip_addr`" + ` varchar(100) NOT NULL,
+  ` + "`description`" + ` varchar(64) DEFAULT NULL,
+  ` + "`dns_name`" + ` varchar(100) NOT NULL,
+  ` + "`owner`" + ` varchar(32) DEFAULT NULL,
+  ` + "`switch`" + ` varchar(32) DEFAULT NULL,
+  ` + "`note`" + ` text,
+  PRIMARY KEY (` + "`id`" + `)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- Some dumps are made with --complete-insert.
+INSERT INTO ` + "`ipaddresses`" + ` (` + "`id`" + `, ` + "`subnetId`" + `, ` + "`ip_addr`" + `, ` + "`description`" + `, ` + "`dns_name`" + `, ` + "`owner`" + `, ` + "`switch`" + `, ` + "`note`" + `) VALUES (1,2,'167837697','gateway','gw.example.com','netops','sw1','line 1\nline 2'),(2,4,'3232235521','printer','printer.example.com',NULL,'','');
+
+CREATE TABLE ` + "`users`" + ` (
+  ` + "`id`" + ` int(11) NOT NULL AUTO_INCREMENT,
+  ` + "`username`" + ` varchar(255) NOT NULL,
+  PRIMARY KEY (` + "`id`" + `)
+);
+INSERT INTO ` + "`users`" + ` VALUES (1,'bob'),(2,'admin');
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+
+-- Dump completed on 2016-10-14 10:00:00
+`
+
+func TestParse(t *testing.T) {
+	d, err := Parse(strings.NewReader(testDump))
+	if err != nil {
+		t.Fatalf("Error parsing dump: %s", err)
+	}
+	vlans := d.Tables["vlans"]
+	if vlans == nil {
+		t.Fatal("Expected vlans table")
+	}
+	if expected := []string{"vlanId", "name", "number", "description"}; !reflect.DeepEqual(expected, vlans.Columns) {
+		t.Fatalf("Expected vlans columns %#v, got %#v", expected, vlans.Columns)
+	}
+	if len(vlans.Rows) != 2 || *vlans.Rows[0][3] != "Servers; and 'things'" {
+		t.Fatalf("Unexpected vlans rows %#v", vlans.Rows)
+	}
+	if addrs := d.Tables["ipaddresses"]; len(addrs.Rows) != 2 || *addrs.Rows[0][7] != "line 1\nline 2" || addrs.Rows[1][5] != nil {
+		t.Fatalf("Unexpected ipaddresses rows %#v", addrs.Rows)
+	}
+}
+
+func TestParseLatin1(t *testing.T) {
+	d, err := Parse(strings.NewReader("CREATE TABLE `t` (`a` text);\nINSERT INTO `t` VALUES ('caf\xe9 \\'\x93x\x94');\n"))
+	if err != nil {
+		t.Fatalf("Error parsing dump: %s", err)
+	}
+	if rows := d.Tables["t"].Rows; len(rows) != 1 || *rows[0][0] != "caf\xe9 '\x93x\x94" {
+		t.Fatalf("Expected latin1 bytes to be kept, got %#v", rows)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]string{
+		"INSERT INTO `vlans` VALUES (1);":                                     "line 1: insert into table vlans before it is created",
+		"CREATE TABLE `t` (`a` int, `b` int);\n\nINSERT INTO `t` VALUES (1);": "line 3: expected 2 values in insert into table t, got 1",
+		"CREATE TABLE `t` (`a` int);\nINSERT INTO `t` VALUES ('foo);\n":       "line 3: unterminated quoted string starting on line 2",
+	}
+	for in, expected := range cases {
+		if _, err := Parse(strings.NewReader(in)); err == nil || err.Error() != expected {
+			t.Fatalf("Expected error %q parsing %q, got %v", expected, in, err)
+		}
+	}
+}
+
+func TestDriver(t *testing.T) {
+	d, err := Parse(strings.NewReader(testDump))
+	if err != nil {
+		t.Fatalf("Error parsing dump: %s", err)
+	}
+	sql.Register("dump-test", &Driver{Dump: d})
+	db, err := sql.Open("dump-test", "")
+	if err != nil {
+		t.Fatalf("Error opening dump: %s", err)
+	}
+	r := &legacydb.Reader{DB: db, SectionID: 1}
+
+	lans, err := r.VLANs()
+	if err != nil {
+		t.Fatalf("Error reading VLANs: %s", err)
+	}
+	if len(lans) != 2 || lans[0].Number != 100 || lans[1].Name != "users" {
+		t.Fatalf("Unexpected VLANs %#v", lans)
+	}
+
+	nets, skipped, err := r.Subnets()
+	if err != nil {
+		t.Fatalf("Error reading subnets: %s", err)
+	}
+	if len(nets) != 2 || skipped != 1 {
+		t.Fatalf("Expected 2 subnets and 1 skipped, got %#v and %d", nets, skipped)
+	}
+	if nets[0].VLANNumber != 0 || nets[1].SubnetAddress != "10.1.0.0" || nets[1].VLANNumber != 100 {
+		t.Fatalf("Unexpected subnets %#v", nets)
+	}
+
+	addrs, _, err := r.Addresses()
+	if err != nil {
+		t.Fatalf("Error reading addresses: %s", err)
+	}
+	if len(addrs) != 1 || addrs[0].IPAddress != "10.1.0.1" || addrs[0].SubnetCIDR != "10.1.0.0/24" || addrs[0].Switch != "sw1" {
+		t.Fatalf("Unexpected addresses %#v", addrs)
+	}
+
+	switches, err := r.Switches()
+	if err != nil {
+		t.Fatalf("Error reading switches: %s", err)
+	}
+	if len(switches) != 1 || switches["sw1"] == nil {
+		t.Fatalf("Unexpected switches %#v", switches)
+	}
+
+	users, err := r.Users()
+	if err != nil {
+		t.Fatalf("Error reading users: %s", err)
+	}
+	if expected := []string{"admin", "bob"}; !reflect.DeepEqual(expected, users) {
+		t.Fatalf("Expected users %#v, got %#v", expected, users)
+	}
+	if n, err := r.OwnedAddresses(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 owned address, got %d (%v)", n, err)
+	}
+
+	var name string
+	var checksum int64
+	if err := db.QueryRow("checksum table subnets").Scan(&name, &checksum); err != nil || checksum == 0 {
+		t.Fatalf("Error checksumming subnets: %d (%v)", checksum, err)
+	}
+	if _, err := db.Query("select name from missing"); err == nil || err.Error() != "table missing does not exist in the dump" {
+		t.Fatalf("Expected missing table error, got %v", err)
+	}
+}
+
+func TestDriverAdaptedSchema(t *testing.T) {
+	d, err := Parse(strings.NewReader(`
+CREATE TABLE subnets (id int(11), subnet varchar(255), mask varchar(255), sectionId int(10), description text, vlanId int(11));
+INSERT INTO subnets VALUES (1,'167837696','24',1,'child',NULL);
+CREATE TABLE ipaddresses (id int(11), subnetId int(11), ip_addr varchar(100), description varchar(64), hostname varchar(255), owner varchar(32), switch int(11) unsigned, note text);
+INSERT INTO ipaddresses VALUES (1,1,'167837697','gateway','gw.example.com',NULL,2,''),(2,1,'167837698','host','host.example.com',NULL,NULL,'');
+CREATE TABLE devices (id int(11), hostname varchar(32), ip_addr varchar(100), type int(2), description varchar(256));
+INSERT INTO devices VALUES (1,'sw1','10.1.0.250',NULL,NULL),(2,'sw2','10.1.0.251',1,'core');
+CREATE TABLE deviceTypes (tid int(11), tname varchar(128), tdescription varchar(128));
+INSERT INTO deviceTypes VALUES (1,'Switch','Switch'),(2,'Router','Router');
+CREATE TABLE settings (id int(11), version varchar(5));
+INSERT INTO settings VALUES (1,'1.1');
+`))
+	if err != nil {
+		t.Fatalf("Error parsing dump: %s", err)
+	}
+	sql.Register("dump-test-adapted", &Driver{Dump: d})
+	db, err := sql.Open("dump-test-adapted", "")
+	if err != nil {
+		t.Fatalf("Error opening dump: %s", err)
+	}
+	s, err := legacydb.DetectSchema(db, nil)
+	if err != nil {
+		t.Fatalf("Error detecting schema: %s", err)
+	}
+	m, _ := s.Adapt(nil)
+	if s.Version != "1.1" || m.SwitchTable != "devices" || m.DeviceTypeTable != "deviceTypes" {
+		t.Fatalf("Unexpected schema %#v and mapping %#v", s, m)
+	}
+	r := &legacydb.Reader{DB: db, Queries: m.BuildQueries()}
+
+	addrs, _, err := r.Addresses()
+	if err != nil {
+		t.Fatalf("Error reading addresses: %s", err)
+	}
+	if len(addrs) != 2 || addrs[0].Hostname != "gw.example.com" || addrs[0].Switch != "sw2" || addrs[1].Switch != "" {
+		t.Fatalf("Unexpected addresses %#v", addrs)
+	}
+	switches, err := r.Switches()
+	if err != nil {
+		t.Fatalf("Error reading switches: %s", err)
+	}
+	if len(switches) != 2 || switches["sw2"] == nil || switches["sw2"].AddressCount != 1 || switches["sw2"].Type != "Switch" || switches["sw1"].IPAddress != "10.1.0.250" {
+		t.Fatalf("Unexpected switches %#v", switches)
+	}
+	types, err := r.DeviceTypes()
+	if err != nil {
+		t.Fatalf("Error reading device types: %s", err)
+	}
+	if len(types) != 2 || types[1].Name != "Router" {
+		t.Fatalf("Unexpected device types %#v", types)
+	}
+}
@@ -0,0 +1,267 @@
+package dump
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// tokenKind is the kind of a SQL token.
+type tokenKind int
+
+const (
+	// An identifier or keyword.
+	tokIdent tokenKind = iota
+
+	// A quoted string, with its escapes decoded.
+	tokString
+
+	// A number.
+	tokNumber
+
+	// Punctuation or an operator.
+	tokPunct
+)
+
+// token is a SQL token.
+type token struct {
+	kind tokenKind
+	text string
+
+	// Whether or not an identifier was quoted with backticks, in which case it
+	// is never a keyword.
+	quoted bool
+}
+
+// lex splits a SQL statement into tokens.
+func lex(s string) (out []token, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			v, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, token{kind: tokString, text: v})
+			i += n
+		case c == '`':
+			end := strings.IndexByte(s[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated identifier %s", s[i:])
+			}
+			out = append(out, token{kind: tokIdent, text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		case c == '0' && i+1 < len(s) && (s[i+1] == 'x' || s[i+1] == 'X'):
+			// Hex literals are strings of the bytes they encode.
+			j := i + 2
+			for j < len(s) && isHexDigit(s[j]) {
+				j++
+			}
+			v, err := decodeHex(s[i+2 : j])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, token{kind: tokString, text: v})
+			i = j
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			j := i
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				((s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			out = append(out, token{kind: tokNumber, text: s[i:j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(s) && (isIdentChar(s[j]) || isDigit(s[j])) {
+				j++
+			}
+			out = append(out, token{kind: tokIdent, text: s[i:j]})
+			i = j
+		default:
+			n := 1
+			if i+1 < len(s) {
+				switch s[i : i+2] {
+				case "!=", "<>", "<=", ">=":
+					n = 2
+				}
+			}
+			out = append(out, token{kind: tokPunct, text: s[i : i+n]})
+			i += n
+		}
+	}
+	return out, nil
+}
+
+// lexString decodes the quoted string at the start of s, and returns it along
+// with the number of bytes it took up in s. The string can contain backslash
+// escapes, and doubled quotes.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte(26)
+			case '%', '_':
+				// These are only escaped in LIKE patterns, so MySQL keeps the
+				// backslash.
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte(s[i])
+			}
+		case c == quote && i+1 < len(s) && s[i+1] == quote:
+			b.WriteByte(quote)
+			i++
+		case c == quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string %s", s)
+}
+
+// decodeHex decodes the digits of a hex literal.
+func decodeHex(s string) (string, error) {
+	if len(s)%2 != 0 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid hex literal 0x%s", s)
+	}
+	return string(b), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// parser parses a list of tokens.
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// done returns true if all of the tokens have been consumed.
+func (p *parser) done() bool {
+	return p.pos >= len(p.toks)
+}
+
+// peek returns the next token without consuming it. A blank punctuation token
+// is returned if there are no more tokens.
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokPunct}
+	}
+	return p.toks[p.pos]
+}
+
+// next consumes and returns the next token.
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keywords consumes the supplied sequence of keywords, and returns true, if
+// they are the next tokens. Otherwise, nothing is consumed.
+func (p *parser) keywords(kws ...string) bool {
+	for i, kw := range kws {
+		if p.pos+i >= len(p.toks) {
+			return false
+		}
+		t := p.toks[p.pos+i]
+		if t.kind != tokIdent || t.quoted || !strings.EqualFold(t.text, kw) {
+			return false
+		}
+	}
+	p.pos += len(kws)
+	return true
+}
+
+// accept consumes the punctuation punct, and returns true, if it is the next
+// token.
+func (p *parser) accept(punct string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the punctuation punct, returning an error if it is not the
+// next token.
+func (p *parser) expect(punct string) error {
+	if !p.accept(punct) {
+		return fmt.Errorf("expected %q, got %q", punct, p.peek().text)
+	}
+	return nil
+}
+
+// name consumes an identifier.
+func (p *parser) name() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", fmt.Errorf("expected identifier, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+// table consumes a table name. The database name is dropped from qualified
+// table names.
+func (p *parser) table() (string, error) {
+	v, err := p.name()
+	if err != nil {
+		return "", err
+	}
+	if p.accept(".") {
+		return p.name()
+	}
+	return v, nil
+}
+
+// value consumes a literal value, returning nil for NULL.
+func (p *parser) value() (*string, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString || t.kind == tokNumber:
+		return &t.text, nil
+	case t.kind == tokPunct && t.text == "-" && p.peek().kind == tokNumber:
+		v := "-" + p.next().text
+		return &v, nil
+	case t.kind == tokIdent && strings.EqualFold(t.text, "null"):
+		return nil, nil
+	case t.kind == tokIdent && strings.HasPrefix(t.text, "_") && p.peek().kind == tokString:
+		// A character set introducer, ie: _binary 'foo'.
+		return p.value()
+	}
+	return nil, fmt.Errorf("expected value, got %q", t.text)
+}
@@ -0,0 +1,539 @@
+package dump
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// query is a parsed query. Only the forms of query that the migrator runs
+// against the legacy DB are supported:
+//
+//	select count(*) | col, ... from table
+//	  [left join table on col = col ...]
+//	  [where col (= | != | <>) (col | value | ?) | col is [not] null [and ...]]
+//	  [order by col, ...]
+//	checksum table table
+//	show columns from table
+type query struct {
+	// The table to checksum, for CHECKSUM TABLE queries.
+	checksum string
+
+	// The table to describe, for SHOW COLUMNS queries.
+	showColumns string
+
+	// Whether or not the query selects count(*) rather than columns.
+	count bool
+
+	// The selected columns.
+	columns []colRef
+
+	// The table selected from, and the tables left joined to it.
+	from  string
+	joins []*join
+
+	// The conditions in the where clause, all of which must be true.
+	where []cond
+
+	// The columns to order by.
+	orderBy []colRef
+
+	// The number of ? placeholders in the query.
+	args int
+}
+
+// colRef is a reference to a column, optionally qualified with its table.
+type colRef struct {
+	table  string
+	column string
+}
+
+// String implements fmt.Stringer for colRef.
+func (c colRef) String() string {
+	if c.table == "" {
+		return c.column
+	}
+	return c.table + "." + c.column
+}
+
+// join is a left join.
+type join struct {
+	table string
+	on    [2]colRef
+}
+
+// cond is a condition in a where clause.
+type cond struct {
+	col colRef
+
+	// The operator - =, !=, is null, or is not null.
+	op string
+
+	// The operand of = and !=.
+	operand operand
+}
+
+// operand is the right hand side of a comparison - a column, a literal value,
+// or a placeholder. An operand with none of these set is NULL.
+type operand struct {
+	col   *colRef
+	value *string
+
+	// The position of the placeholder in the query arguments, starting at 1.
+	arg int
+}
+
+// parseQuery parses a query.
+func parseQuery(sql string) (*query, error) {
+	toks, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	q := &query{}
+	switch {
+	case p.keywords("checksum", "table"):
+		if q.checksum, err = p.table(); err != nil {
+			return nil, err
+		}
+	case p.keywords("show", "columns", "from"):
+		if q.showColumns, err = p.table(); err != nil {
+			return nil, err
+		}
+	case p.keywords("select"):
+		if err := q.parseSelect(p); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported query %q", sql)
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unsupported query %q: unexpected %q", sql, p.peek().text)
+	}
+	return q, nil
+}
+
+// parseSelect parses the rest of a select query.
+func (q *query) parseSelect(p *parser) (err error) {
+	if p.keywords("count") {
+		for _, v := range []string{"(", "*", ")"} {
+			if err := p.expect(v); err != nil {
+				return err
+			}
+		}
+		q.count = true
+	} else {
+		if q.columns, err = p.colRefs(); err != nil {
+			return err
+		}
+	}
+
+	if !p.keywords("from") {
+		return fmt.Errorf("expected FROM, got %q", p.peek().text)
+	}
+	if q.from, err = p.table(); err != nil {
+		return err
+	}
+
+	for p.keywords("left", "join") || p.keywords("left", "outer", "join") {
+		j := &join{}
+		if j.table, err = p.table(); err != nil {
+			return err
+		}
+		if !p.keywords("on") {
+			return fmt.Errorf("expected ON, got %q", p.peek().text)
+		}
+		if j.on[0], err = p.colRef(); err != nil {
+			return err
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		if j.on[1], err = p.colRef(); err != nil {
+			return err
+		}
+		q.joins = append(q.joins, j)
+	}
+
+	if p.keywords("where") {
+		for {
+			c, err := q.parseCond(p)
+			if err != nil {
+				return err
+			}
+			q.where = append(q.where, c)
+			if !p.keywords("and") {
+				break
+			}
+		}
+	}
+
+	if p.keywords("order", "by") {
+		if q.orderBy, err = p.colRefs(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCond parses a condition in a where clause.
+func (q *query) parseCond(p *parser) (c cond, err error) {
+	if c.col, err = p.colRef(); err != nil {
+		return c, err
+	}
+	switch {
+	case p.keywords("is", "null"):
+		c.op = "is null"
+		return c, nil
+	case p.keywords("is", "not", "null"):
+		c.op = "is not null"
+		return c, nil
+	case p.accept("="):
+		c.op = "="
+	case p.accept("!="), p.accept("<>"):
+		c.op = "!="
+	default:
+		return c, fmt.Errorf("unsupported operator %q", p.peek().text)
+	}
+
+	switch t := p.peek(); {
+	case t.kind == tokPunct && t.text == "?":
+		p.pos++
+		q.args++
+		c.operand.arg = q.args
+	case t.kind == tokIdent && !strings.EqualFold(t.text, "null"):
+		col, err := p.colRef()
+		if err != nil {
+			return c, err
+		}
+		c.operand.col = &col
+	default:
+		if c.operand.value, err = p.value(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// colRef consumes a column reference.
+func (p *parser) colRef() (c colRef, err error) {
+	if c.column, err = p.name(); err != nil {
+		return c, err
+	}
+	if p.accept(".") {
+		c.table = c.column
+		if c.column, err = p.name(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// colRefs consumes a comma-separated list of column references.
+func (p *parser) colRefs() (out []colRef, err error) {
+	for {
+		c, err := p.colRef()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+		if !p.accept(",") {
+			return out, nil
+		}
+	}
+}
+
+// result is the result set of a query.
+type result struct {
+	columns []string
+	rows    [][]*string
+}
+
+// run runs a query against the dump, with the supplied placeholder arguments.
+func (d *Dump) run(q *query, args []*string) (*result, error) {
+	if len(args) != q.args {
+		return nil, fmt.Errorf("expected %d arguments, got %d", q.args, len(args))
+	}
+	if q.checksum != "" {
+		return d.checksum(q.checksum)
+	}
+	if q.showColumns != "" {
+		return d.describe(q.showColumns)
+	}
+
+	from, err := d.table(q.from)
+	if err != nil {
+		return nil, err
+	}
+	src := &source{tables: []*Table{from}}
+	rows := from.Rows
+	for _, j := range q.joins {
+		if rows, err = d.leftJoin(src, rows, j); err != nil {
+			return nil, err
+		}
+	}
+
+	var filtered [][]*string
+	for _, row := range rows {
+		ok, err := src.match(row, q.where, args)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, row)
+		}
+	}
+
+	if len(q.orderBy) > 0 {
+		var keys []int
+		for _, c := range q.orderBy {
+			i, err := src.resolve(c)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, i)
+		}
+		sort.SliceStable(filtered, func(i, j int) bool {
+			for _, k := range keys {
+				if n := compare(filtered[i][k], filtered[j][k]); n != 0 {
+					return n < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if q.count {
+		n := strconv.Itoa(len(filtered))
+		return &result{columns: []string{"count(*)"}, rows: [][]*string{{&n}}}, nil
+	}
+	out := &result{}
+	var cols []int
+	for _, c := range q.columns {
+		i, err := src.resolve(c)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, i)
+		out.columns = append(out.columns, c.column)
+	}
+	for _, row := range filtered {
+		v := make([]*string, len(cols))
+		for i, c := range cols {
+			v[i] = row[c]
+		}
+		out.rows = append(out.rows, v)
+	}
+	return out, nil
+}
+
+// table returns the named table.
+func (d *Dump) table(name string) (*Table, error) {
+	t, ok := d.Tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist in the dump", name)
+	}
+	return t, nil
+}
+
+// leftJoin left joins the table in j to rows, and adds it to src.
+func (d *Dump) leftJoin(src *source, rows [][]*string, j *join) ([][]*string, error) {
+	t, err := d.table(j.table)
+	if err != nil {
+		return nil, err
+	}
+	width := src.width()
+	src.tables = append(src.tables, t)
+	left, err := src.resolve(j.on[0])
+	if err != nil {
+		return nil, err
+	}
+	right, err := src.resolve(j.on[1])
+	if err != nil {
+		return nil, err
+	}
+	if left >= width {
+		left, right = right, left
+	}
+	if left >= width || right < width {
+		return nil, fmt.Errorf("join condition %s = %s must compare a column of each table", j.on[0], j.on[1])
+	}
+
+	index := make(map[string][][]*string)
+	for _, row := range t.Rows {
+		if v := row[right-width]; v != nil {
+			index[*v] = append(index[*v], row)
+		}
+	}
+	var out [][]*string
+	for _, row := range rows {
+		var matches [][]*string
+		if v := row[left]; v != nil {
+			matches = index[*v]
+		}
+		if len(matches) == 0 {
+			matches = [][]*string{make([]*string, len(t.Columns))}
+		}
+		for _, m := range matches {
+			out = append(out, append(append(make([]*string, 0, width+len(m)), row...), m...))
+		}
+	}
+	return out, nil
+}
+
+// checksum returns the checksum of a table, in the same form as the result of
+// CHECKSUM TABLE. The checksum is not the same as MySQL's, but it changes
+// whenever the table's rows do.
+func (d *Dump) checksum(name string) (*result, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return nil, err
+	}
+	h := crc32.NewIEEE()
+	for _, row := range t.Rows {
+		for _, v := range row {
+			if v == nil {
+				h.Write([]byte{0})
+				continue
+			}
+			h.Write([]byte(*v))
+			h.Write([]byte{1})
+		}
+	}
+	sum := strconv.FormatUint(uint64(h.Sum32()), 10)
+	return &result{columns: []string{"Table", "Checksum"}, rows: [][]*string{{&name, &sum}}}, nil
+}
+
+// describe returns the columns of a table, in the same form as the result of
+// SHOW COLUMNS. Only the name and type of each column are known.
+func (d *Dump) describe(name string) (*result, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return nil, err
+	}
+	out := &result{columns: []string{"Field", "Type", "Null", "Key", "Default", "Extra"}}
+	for i := range t.Columns {
+		var typ string
+		if i < len(t.Types) {
+			typ = t.Types[i]
+		}
+		out.rows = append(out.rows, []*string{&t.Columns[i], &typ, nil, nil, nil, nil})
+	}
+	return out, nil
+}
+
+// source is the tables that the rows of a query are made up of. Each row holds
+// the columns of every table, in order.
+type source struct {
+	tables []*Table
+}
+
+// width returns the number of columns in each row.
+func (s *source) width() (n int) {
+	for _, t := range s.tables {
+		n += len(t.Columns)
+	}
+	return n
+}
+
+// resolve returns the index of a column in the rows.
+func (s *source) resolve(c colRef) (int, error) {
+	found := -1
+	offset := 0
+	for _, t := range s.tables {
+		if c.table == "" || c.table == t.Name {
+			if i := t.column(c.column); i >= 0 {
+				if found >= 0 {
+					return 0, fmt.Errorf("column %s is ambiguous", c)
+				}
+				found = offset + i
+			}
+		}
+		offset += len(t.Columns)
+	}
+	if found < 0 {
+		return 0, fmt.Errorf("unknown column %s", c)
+	}
+	return found, nil
+}
+
+// match returns true if row satisfies all of conds.
+func (s *source) match(row []*string, conds []cond, args []*string) (bool, error) {
+	for _, c := range conds {
+		i, err := s.resolve(c.col)
+		if err != nil {
+			return false, err
+		}
+		v := row[i]
+		switch c.op {
+		case "is null":
+			if v != nil {
+				return false, nil
+			}
+			continue
+		case "is not null":
+			if v == nil {
+				return false, nil
+			}
+			continue
+		}
+
+		operand := c.operand.value
+		switch {
+		case c.operand.col != nil:
+			j, err := s.resolve(*c.operand.col)
+			if err != nil {
+				return false, err
+			}
+			operand = row[j]
+		case c.operand.arg > 0:
+			operand = args[c.operand.arg-1]
+		}
+		// As in SQL, comparisons with NULL are never true.
+		if v == nil || operand == nil || equal(*v, *operand) != (c.op == "=") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// equal returns true if two values are equal. Numeric values are compared as
+// numbers, so that numeric columns can be compared with quoted values.
+func equal(a, b string) bool {
+	if a == b {
+		return true
+	}
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.ParseFloat(b, 64)
+	return err == nil && x == y
+}
+
+// compare compares two values for sorting, returning a negative number if a
+// sorts first, and a positive one if b does. NULL sorts first, and numeric
+// values are sorted as numbers.
+func compare(a, b *string) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	x, errX := strconv.ParseFloat(*a, 64)
+	y, errY := strconv.ParseFloat(*b, 64)
+	switch {
+	case errX != nil || errY != nil:
+		return strings.Compare(*a, *b)
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
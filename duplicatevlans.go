@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// duplicateVLANs is what is done with legacy VLANs whose number is used by
+	// more than one VLAN: merge them into one VLAN, suffix the names of all
+	// but the first, or create all but the first in separate L2 domains.
+	duplicateVLANs string
+
+	// duplicateVLANNumbers holds the VLAN numbers that are used by more than
+	// one legacy VLAN, in order.
+	duplicateVLANNumbers []int
+
+	// duplicateVLANDomains maps the legacy IDs of VLANs to the names of the
+	// L2 domains that they are created in, when duplicateVLANs is domains.
+	duplicateVLANDomains = make(map[int]string)
+
+	// duplicateVLANIDs maps the legacy IDs of VLANs whose number is used more
+	// than once to their IDs in the new PHPIPAM instance.
+	duplicateVLANIDs = make(map[int]int)
+)
+
+// separatesDuplicateVLANs returns true if legacy VLANs that share a number
+// are created as separate VLANs, so that subnets must be resolved to them by
+// legacy VLAN ID rather than number.
+func separatesDuplicateVLANs() bool {
+	return duplicateVLANs == "suffix" || duplicateVLANs == "domains"
+}
+
+// duplicateVLANDomainName returns the name of the L2 domain that the k-th
+// VLAN sharing a number is created in when duplicateVLANs is domains. The
+// first VLAN is created in its usual domain, so k starts at 2.
+func duplicateVLANDomainName(k int) string {
+	return fmt.Sprintf("Duplicate VLANs %d", k)
+}
+
+// fetchDuplicateVLANs reads the legacy VLANs whose number is used more than
+// once from the legacy DB in conn, grouped by number.
+func fetchDuplicateVLANs(conn *sql.DB) ([][]legacydb.VLAN, error) {
+	r := &legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}
+	found, err := r.DuplicateVLANs()
+	if err != nil {
+		return nil, err
+	}
+	var out [][]legacydb.VLAN
+	for i, v := range found {
+		if i == 0 || found[i-1].Number != v.Number {
+			out = append(out, nil)
+		}
+		out[len(out)-1] = append(out[len(out)-1], v)
+	}
+	return out, nil
+}
+
+// applyDuplicateVLANs applies duplicateVLANs to the legacy VLANs in lans whose
+// number is used more than once, and returns the VLANs to migrate. With
+// merge, the first VLAN of each number is kept, with its blank name and
+// description filled in from the others. Otherwise, the VLANs are read again
+// from the legacy DB in conn along with their legacy IDs, so that subnets can
+// be assigned to the right one.
+func applyDuplicateVLANs(conn *sql.DB, lans []legacydb.VLAN) ([]legacydb.VLAN, error) {
+	duplicateVLANNumbers = nil
+	count := make(map[int]int)
+	for _, v := range lans {
+		count[v.Number]++
+	}
+	groups := make(map[int][]legacydb.VLAN)
+	if separatesDuplicateVLANs() {
+		found, err := fetchDuplicateVLANs(conn)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range found {
+			groups[g[0].Number] = g
+		}
+	}
+
+	var out []legacydb.VLAN
+	first := make(map[int]int)
+	for _, v := range lans {
+		if count[v.Number] < 2 {
+			out = append(out, v)
+			continue
+		}
+		if i, ok := first[v.Number]; ok {
+			if duplicateVLANs == "merge" {
+				if out[i].Name == "" {
+					out[i].Name = v.Name
+				}
+				if out[i].Description == "" {
+					out[i].Description = v.Description
+				}
+				recordsTotal.Inc("vlans", "merged")
+			}
+			continue
+		}
+		first[v.Number] = len(out)
+		duplicateVLANNumbers = append(duplicateVLANNumbers, v.Number)
+		g, ok := groups[v.Number]
+		if !ok {
+			out = append(out, v)
+			continue
+		}
+		for k, d := range g {
+			d.CustomFields = v.CustomFields
+			if k > 0 && duplicateVLANs == "suffix" {
+				d.Name = strings.TrimSpace(fmt.Sprintf("%s (%d)", d.Name, k+1))
+			}
+			if k > 0 && duplicateVLANs == "domains" {
+				duplicateVLANDomains[d.LegacyID] = duplicateVLANDomainName(k + 1)
+			}
+			out = append(out, d)
+		}
+	}
+
+	for _, n := range duplicateVLANNumbers {
+		switch duplicateVLANs {
+		case "merge":
+			stageLog.Warnf("VLAN number %d is used by %d legacy VLANs, which are merged into one", n, count[n])
+		case "suffix":
+			stageLog.Warnf("VLAN number %d is used by %d legacy VLANs, whose names are suffixed to tell them apart", n, count[n])
+		case "domains":
+			stageLog.Warnf("VLAN number %d is used by %d legacy VLANs, which are created in separate L2 domains", n, count[n])
+		}
+	}
+	return out, nil
+}
+
+// duplicateVLANDomainCount returns the number of extra L2 domains needed to
+// create the legacy VLANs whose number is used more than once, read from the
+// legacy DB in conn, when duplicateVLANs is domains.
+func duplicateVLANDomainCount(conn *sql.DB) (int, error) {
+	found, err := fetchDuplicateVLANs(conn)
+	if err != nil {
+		return 0, err
+	}
+	max := 0
+	for _, g := range found {
+		if len(g)-1 > max {
+			max = len(g) - 1
+		}
+	}
+	return max, nil
+}
+
+// preloadDuplicateVLANIDs lists the VLANs in the new PHPIPAM instance with c,
+// and adds the IDs of the legacy VLANs whose number is used more than once to
+// duplicateVLANIDs, matching them by L2 domain, number, and name, and then by
+// L2 domain and number alone.
+func preloadDuplicateVLANIDs(c ipamsink.VLANUpdater) error {
+	found, err := c.VLANs()
+	if err != nil {
+		return err
+	}
+	for _, v := range legacyVLANs {
+		if v.LegacyID == 0 {
+			continue
+		}
+		if e, ok := findVLAN(found, v.VLAN, true); ok {
+			duplicateVLANIDs[v.LegacyID] = e.ID
+		} else if e, ok := findVLAN(found, v.VLAN, false); ok {
+			duplicateVLANIDs[v.LegacyID] = e.ID
+		}
+	}
+	logrus.Infof("Preloaded %d duplicate VLAN IDs", len(duplicateVLANIDs))
+	return nil
+}
+
+// findVLAN returns the VLAN in found with the L2 domain and number of v, and
+// its name too if byName is set.
+func findVLAN(found []vlans.VLAN, v vlans.VLAN, byName bool) (vlans.VLAN, bool) {
+	for _, e := range found {
+		if vlanKey(e) == vlanKey(v) && (!byName || e.Name == v.Name) {
+			return e, true
+		}
+	}
+	return vlans.VLAN{}, false
+}
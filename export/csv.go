@@ -0,0 +1,121 @@
+package export
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CSV is the format of an export to the CSV files that PHPIPAM's own import
+// tool reads, which is written with WriteCSV.
+const CSV = "csv"
+
+// The names of the files written by WriteCSV.
+const (
+	VLANsFile     = "vlans.csv"
+	VRFsFile      = "vrfs.csv"
+	SubnetsFile   = "subnets.csv"
+	AddressesFile = "addresses.csv"
+)
+
+// WriteCSV writes the export to CSV files in dir, which is created if it does
+// not exist, with one file for each of the VLAN, VRF, subnet, and IP address
+// templates of PHPIPAM's import tool. Sections are written by ID, VLANs by
+// number, and VRFs by name, so the sections must be matched up when
+// importing. Custom fields are written as additional columns, named after the
+// fields. Devices are not written, as they cannot be
+// imported, but addresses name their devices.
+func (e *Export) WriteCSV(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	var fields []map[string]string
+	for _, v := range e.VLANs {
+		rows = append(rows, []string{v.Name, strconv.Itoa(v.Number), v.Description})
+		fields = append(fields, v.CustomFields)
+	}
+	if err := writeCSV(filepath.Join(dir, VLANsFile), []string{"Name", "Number", "Description"}, rows, fields); err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, v := range e.VRFs {
+		rows = append(rows, []string{v.Name, v.RD, v.Description})
+	}
+	if err := writeCSV(filepath.Join(dir, VRFsFile), []string{"Name", "RD", "Description"}, rows, nil); err != nil {
+		return err
+	}
+
+	rows, fields = nil, nil
+	for _, v := range e.Subnets {
+		addr, mask := splitCIDR(v.CIDR)
+		rows = append(rows, []string{strconv.Itoa(v.SectionID), addr, mask, v.Description, number(v.VLAN), v.VRF})
+		fields = append(fields, v.CustomFields)
+	}
+	if err := writeCSV(filepath.Join(dir, SubnetsFile), []string{"Section", "Subnet", "Mask", "Description", "VLAN", "VRF"}, rows, fields); err != nil {
+		return err
+	}
+
+	rows, fields = nil, nil
+	for _, v := range e.Addresses {
+		rows = append(rows, []string{strconv.Itoa(v.SectionID), v.Subnet, v.IPAddress, v.Hostname, v.Description, v.Owner, v.Device, v.Port, v.MAC, v.Note, v.Tag})
+		fields = append(fields, v.CustomFields)
+	}
+	return writeCSV(filepath.Join(dir, AddressesFile), []string{"Section", "Subnet", "IP address", "Hostname", "Description", "Owner", "Device", "Port", "MAC", "Note", "Tag"}, rows, fields)
+}
+
+// splitCIDR returns the address and mask of a CIDR.
+func splitCIDR(cidr string) (string, string) {
+	if i := strings.IndexByte(cidr, '/'); i >= 0 {
+		return cidr[:i], cidr[i+1:]
+	}
+	return cidr, ""
+}
+
+// number returns n as a string, or blank if it is 0.
+func number(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// writeCSV writes a CSV file with the supplied header and rows, followed by a
+// column for each custom field set on any row, in name order.
+func writeCSV(path string, header []string, rows [][]string, fields []map[string]string) error {
+	names := make(map[string]bool)
+	for _, v := range fields {
+		for k := range v {
+			names[k] = true
+		}
+	}
+	var extra []string
+	for k := range names {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(append(header, extra...))
+	for i, row := range rows {
+		for _, k := range extra {
+			row = append(row, fields[i][k])
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
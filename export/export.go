@@ -0,0 +1,654 @@
+// Package export collects the migrated L2 domains, VLANs, VRFs, nameserver
+// sets, subnets, devices, addresses, IP requests, users, groups, and changelog
+// entries in place of writing them to a new PHPIPAM instance, and writes them
+// out as JSON or YAML, so that the converted data can be inspected, diffed,
+// version controlled, or fed to other tooling, as Terraform configuration, or
+// as CSV files for PHPIPAM's own import tool.
+//
+// Objects are exported as they would have been written, after transformation
+// and hooks, but refer to each other by L2 domain name, VLAN number, VRF name,
+// nameserver set name, subnet CIDR, device hostname, and device type name
+// rather than by ID, as they have no IDs outside of PHPIPAM. The objects are
+// sorted, so that exports of the same data are identical.
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"gopkg.in/yaml.v2"
+)
+
+// The formats that an export can be written in.
+const (
+	JSON = "json"
+	YAML = "yaml"
+)
+
+// L2Domain is an exported L2 domain.
+type L2Domain struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Sections    string `json:"sections,omitempty" yaml:"sections,omitempty"`
+}
+
+// VLAN is an exported VLAN. VLANs in the default L2 domain have no domain.
+type VLAN struct {
+	Number       int               `json:"number" yaml:"number"`
+	Name         string            `json:"name" yaml:"name"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Domain       string            `json:"domain,omitempty" yaml:"domain,omitempty"`
+	CustomFields map[string]string `json:"custom_fields,omitempty" yaml:"custom_fields,omitempty"`
+}
+
+// VRF is an exported VRF.
+type VRF struct {
+	Name        string `json:"name" yaml:"name"`
+	RD          string `json:"rd,omitempty" yaml:"rd,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// Nameserver is an exported nameserver set.
+type Nameserver struct {
+	Name        string `json:"name" yaml:"name"`
+	Addresses   string `json:"addresses" yaml:"addresses"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Sections    string `json:"sections,omitempty" yaml:"sections,omitempty"`
+}
+
+// Subnet is an exported subnet.
+type Subnet struct {
+	SectionID    int               `json:"section_id" yaml:"section_id"`
+	CIDR         string            `json:"cidr" yaml:"cidr"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	VLAN         int               `json:"vlan,omitempty" yaml:"vlan,omitempty"`
+	VRF          string            `json:"vrf,omitempty" yaml:"vrf,omitempty"`
+	Nameserver   string            `json:"nameserver,omitempty" yaml:"nameserver,omitempty"`
+	CustomFields map[string]string `json:"custom_fields,omitempty" yaml:"custom_fields,omitempty"`
+}
+
+// Device is an exported device.
+type Device struct {
+	Hostname    string `json:"hostname" yaml:"hostname"`
+	IPAddress   string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Sections    string `json:"sections,omitempty" yaml:"sections,omitempty"`
+}
+
+// Address is an exported IP address.
+type Address struct {
+	SectionID    int               `json:"section_id" yaml:"section_id"`
+	Subnet       string            `json:"subnet" yaml:"subnet"`
+	IPAddress    string            `json:"ip" yaml:"ip"`
+	Hostname     string            `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Owner        string            `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Note         string            `json:"note,omitempty" yaml:"note,omitempty"`
+	Device       string            `json:"device,omitempty" yaml:"device,omitempty"`
+	Port         string            `json:"port,omitempty" yaml:"port,omitempty"`
+	MAC          string            `json:"mac,omitempty" yaml:"mac,omitempty"`
+	ExcludePing  bool              `json:"exclude_ping,omitempty" yaml:"exclude_ping,omitempty"`
+	PTRIgnore    bool              `json:"ptr_ignore,omitempty" yaml:"ptr_ignore,omitempty"`
+	Gateway      bool              `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	LastSeen     string            `json:"last_seen,omitempty" yaml:"last_seen,omitempty"`
+	EditDate     string            `json:"edit_date,omitempty" yaml:"edit_date,omitempty"`
+	Tag          string            `json:"tag,omitempty" yaml:"tag,omitempty"`
+	CustomFields map[string]string `json:"custom_fields,omitempty" yaml:"custom_fields,omitempty"`
+}
+
+// Request is an exported IP request.
+type Request struct {
+	SectionID   int    `json:"section_id" yaml:"section_id"`
+	Subnet      string `json:"subnet" yaml:"subnet"`
+	IPAddress   string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Hostname    string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Owner       string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Requester   string `json:"requester,omitempty" yaml:"requester,omitempty"`
+	Comment     string `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+// Change is an exported changelog entry about a subnet, or an address in it.
+type Change struct {
+	SectionID int    `json:"section_id" yaml:"section_id"`
+	Subnet    string `json:"subnet" yaml:"subnet"`
+	IPAddress string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Action    string `json:"action" yaml:"action"`
+	Result    string `json:"result,omitempty" yaml:"result,omitempty"`
+	Date      string `json:"date" yaml:"date"`
+	User      string `json:"user,omitempty" yaml:"user,omitempty"`
+	Diff      string `json:"diff,omitempty" yaml:"diff,omitempty"`
+}
+
+// Group is an exported user group.
+type Group struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// User is an exported user. Passwords are not exported.
+type User struct {
+	Username string   `json:"username" yaml:"username"`
+	RealName string   `json:"real_name,omitempty" yaml:"real_name,omitempty"`
+	Email    string   `json:"email,omitempty" yaml:"email,omitempty"`
+	Role     string   `json:"role,omitempty" yaml:"role,omitempty"`
+	Groups   []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// Export is the document written by an export. L2 domains, nameserver sets,
+// requests, users, groups, and changelog entries are only written if any were
+// collected, as they are only migrated on request.
+type Export struct {
+	L2Domains   []L2Domain   `json:"l2_domains,omitempty" yaml:"l2_domains,omitempty"`
+	VLANs       []VLAN       `json:"vlans" yaml:"vlans"`
+	VRFs        []VRF        `json:"vrfs" yaml:"vrfs"`
+	Nameservers []Nameserver `json:"nameservers,omitempty" yaml:"nameservers,omitempty"`
+	Subnets     []Subnet     `json:"subnets" yaml:"subnets"`
+	Devices     []Device     `json:"devices" yaml:"devices"`
+	Addresses   []Address    `json:"addresses" yaml:"addresses"`
+	Requests    []Request    `json:"requests,omitempty" yaml:"requests,omitempty"`
+	Groups      []Group      `json:"groups,omitempty" yaml:"groups,omitempty"`
+	Users       []User       `json:"users,omitempty" yaml:"users,omitempty"`
+	Changelog   []Change     `json:"changelog,omitempty" yaml:"changelog,omitempty"`
+}
+
+// Sink collects the objects to export. It implements the same interface as
+// ipamsink.Sink, so that it can be used in its place.
+//
+// As the objects have no IDs, the IDs that the Sink returns when objects are
+// looked up are handles, which it maps back to the objects when they are
+// referred to.
+type Sink struct {
+	mu  sync.Mutex
+	out Export
+
+	// The last handle returned.
+	handle int
+
+	// The VLAN numbers, subnet CIDRs, device hostnames, and device type,
+	// VRF, nameserver set, L2 domain, and group names of the handles
+	// returned.
+	vlans       map[int]int
+	subnets     map[int]subnetKey
+	devices     map[int]string
+	deviceTypes map[int]string
+	vrfs        map[int]string
+	nameservers map[int]string
+	l2Domains   map[int]string
+	groups      map[int]string
+
+	// The handles of the VLAN numbers and subnets (by section ID and CIDR)
+	// looked up.
+	vlanHandles   map[int]int
+	subnetHandles map[subnetKey]int
+}
+
+// subnetKey identifies a subnet by its section ID and CIDR.
+type subnetKey struct {
+	sectionID int
+	cidr      string
+}
+
+// New returns a new, empty Sink.
+func New() *Sink {
+	return &Sink{
+		vlans:         make(map[int]int),
+		subnets:       make(map[int]subnetKey),
+		devices:       make(map[int]string),
+		deviceTypes:   make(map[int]string),
+		vrfs:          make(map[int]string),
+		nameservers:   make(map[int]string),
+		l2Domains:     make(map[int]string),
+		groups:        make(map[int]string),
+		vlanHandles:   make(map[int]int),
+		subnetHandles: make(map[subnetKey]int),
+	}
+}
+
+// nextHandle returns a new handle. The caller must hold the lock.
+func (s *Sink) nextHandle() int {
+	s.handle++
+	return s.handle
+}
+
+// CreateVLAN collects a VLAN. Its L2 domain ID, if any, must be a handle
+// returned by the Sink.
+func (s *Sink) CreateVLAN(v vlans.VLAN, fields map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.VLANs = append(s.out.VLANs, VLAN{
+		Number:       v.Number,
+		Name:         v.Name,
+		Description:  v.Description,
+		Domain:       s.l2Domains[v.DomainID],
+		CustomFields: fields,
+	})
+	return nil
+}
+
+// CreateSubnet collects a subnet. Its VLAN, VRF, and nameserver set IDs must
+// be handles returned by the Sink.
+func (s *Sink) CreateSubnet(v subnets.Subnet, fields map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Subnets = append(s.out.Subnets, Subnet{
+		SectionID:    v.SectionID,
+		CIDR:         fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask),
+		Description:  v.Description,
+		VLAN:         s.vlans[v.VLANID],
+		VRF:          s.vrfs[v.VRFID],
+		Nameserver:   s.nameservers[v.NameserverID],
+		CustomFields: fields,
+	})
+	return nil
+}
+
+// CreateDevice collects a device, and records a handle for it that is listed
+// by Devices. Its type ID must be a handle returned by the Sink.
+func (s *Sink) CreateDevice(d devices.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[s.nextHandle()] = d.Hostname
+	s.out.Devices = append(s.out.Devices, Device{
+		Hostname:    d.Hostname,
+		IPAddress:   d.IPAddress,
+		Type:        s.deviceTypes[d.Type],
+		Description: d.Description,
+		Sections:    d.Sections,
+	})
+	return nil
+}
+
+// CreateDeviceType records a handle for a device type that is listed by
+// DeviceTypes. Device types are not exported themselves, but devices refer to
+// them by name.
+func (s *Sink) CreateDeviceType(t devices.DeviceType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deviceTypes[s.nextHandle()] = t.Name
+	return nil
+}
+
+// CreateVRF collects a VRF, and records a handle for it that is listed by
+// VRFs.
+func (s *Sink) CreateVRF(v vrfs.VRF) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vrfs[s.nextHandle()] = v.Name
+	s.out.VRFs = append(s.out.VRFs, VRF{
+		Name:        v.Name,
+		RD:          v.RD,
+		Description: v.Description,
+	})
+	return nil
+}
+
+// CreateL2Domain collects an L2 domain, and records a handle for it that is
+// listed by L2Domains.
+func (s *Sink) CreateL2Domain(d l2domains.Domain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.l2Domains[s.nextHandle()] = d.Name
+	s.out.L2Domains = append(s.out.L2Domains, L2Domain{
+		Name:        d.Name,
+		Description: d.Description,
+		Sections:    d.Permissions,
+	})
+	return nil
+}
+
+// CreateNameserver collects a nameserver set, and records a handle for it that
+// is listed by Nameservers.
+func (s *Sink) CreateNameserver(n nameservers.Nameserver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nameservers[s.nextHandle()] = n.Name
+	s.out.Nameservers = append(s.out.Nameservers, Nameserver{
+		Name:        n.Name,
+		Addresses:   n.Addresses,
+		Description: n.Description,
+		Sections:    n.Permissions,
+	})
+	return nil
+}
+
+// CreateAddress collects an IP address. Its subnet and device IDs must be
+// handles returned by the Sink.
+func (s *Sink) CreateAddress(a addresses.Address, fields map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subnet, ok := s.subnets[a.SubnetID]
+	if !ok {
+		return fmt.Errorf("error adding IP address %s: unknown subnet ID %d", a.IPAddress, a.SubnetID)
+	}
+	s.out.Addresses = append(s.out.Addresses, Address{
+		SectionID:    subnet.sectionID,
+		Subnet:       subnet.cidr,
+		IPAddress:    a.IPAddress,
+		Hostname:     a.Hostname,
+		Description:  a.Description,
+		Owner:        a.Owner,
+		Note:         a.Note,
+		Device:       s.devices[a.DeviceID],
+		Port:         a.Port,
+		MAC:          a.MACAddress,
+		ExcludePing:  bool(a.ExcludePing),
+		PTRIgnore:    bool(a.PTRIgnore),
+		Gateway:      bool(a.IsGateway),
+		LastSeen:     a.LastSeen,
+		EditDate:     a.EditDate,
+		Tag:          helper.AddressTagName(a.Tag),
+		CustomFields: fields,
+	})
+	return nil
+}
+
+// CreateRequest collects an IP request. Its subnet ID must be a handle
+// returned by the Sink.
+func (s *Sink) CreateRequest(v requests.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subnet, ok := s.subnets[v.SubnetID]
+	if !ok {
+		return fmt.Errorf("error adding IP request: unknown subnet ID %d", v.SubnetID)
+	}
+	s.out.Requests = append(s.out.Requests, Request{
+		SectionID:   subnet.sectionID,
+		Subnet:      subnet.cidr,
+		IPAddress:   v.IPAddress,
+		Hostname:    v.Hostname,
+		Description: v.Description,
+		Owner:       v.Owner,
+		Requester:   v.Requester,
+		Comment:     v.Comment,
+	})
+	return nil
+}
+
+// CreateChange collects a changelog entry. Its subnet ID must be a handle
+// returned by the Sink.
+func (s *Sink) CreateChange(e changelog.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subnet, ok := s.subnets[e.SubnetID]
+	if !ok {
+		return fmt.Errorf("error adding changelog entry: unknown subnet ID %d", e.SubnetID)
+	}
+	s.out.Changelog = append(s.out.Changelog, Change{
+		SectionID: subnet.sectionID,
+		Subnet:    subnet.cidr,
+		IPAddress: e.IPAddress,
+		Action:    e.Action,
+		Result:    e.Result,
+		Date:      e.Date,
+		User:      e.Username,
+		Diff:      e.Diff,
+	})
+	return nil
+}
+
+// CreateGroup collects a user group, and records a handle for it that is
+// listed by Groups.
+func (s *Sink) CreateGroup(g users.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups[s.nextHandle()] = g.Name
+	s.out.Groups = append(s.out.Groups, Group{Name: g.Name, Description: g.Description})
+	return nil
+}
+
+// CreateUser collects a user. Its group IDs must be handles returned by the
+// Sink.
+func (s *Sink) CreateUser(u users.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := User{
+		Username: u.Username,
+		RealName: u.RealName,
+		Email:    u.Email,
+		Role:     u.Role,
+	}
+	for _, id := range u.Groups {
+		name, ok := s.groups[id]
+		if !ok {
+			return fmt.Errorf("error adding user %s: unknown group ID %d", u.Username, id)
+		}
+		v.Groups = append(v.Groups, name)
+	}
+	s.out.Users = append(s.out.Users, v)
+	return nil
+}
+
+// Devices lists the devices collected, with handles for IDs.
+func (s *Sink) Devices() (out []devices.Device, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, hostname := range s.devices {
+		out = append(out, devices.Device{ID: id, Hostname: hostname})
+	}
+	return out, nil
+}
+
+// DeviceTypes lists the device types recorded, with handles for IDs.
+func (s *Sink) DeviceTypes() (out []devices.DeviceType, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, name := range s.deviceTypes {
+		out = append(out, devices.DeviceType{ID: id, Name: name})
+	}
+	return out, nil
+}
+
+// Groups lists the user groups collected, with handles for IDs.
+func (s *Sink) Groups() (out []users.Group, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, name := range s.groups {
+		out = append(out, users.Group{ID: id, Name: name})
+	}
+	return out, nil
+}
+
+// Users returns no users, as there are none to begin with.
+func (s *Sink) Users() ([]users.User, error) {
+	return nil, nil
+}
+
+// VRFs lists the VRFs collected, with handles for IDs.
+func (s *Sink) VRFs() (out []vrfs.VRF, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, name := range s.vrfs {
+		out = append(out, vrfs.VRF{ID: id, Name: name})
+	}
+	return out, nil
+}
+
+// L2Domains lists the L2 domains collected, with handles for IDs.
+func (s *Sink) L2Domains() (out []l2domains.Domain, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, name := range s.l2Domains {
+		out = append(out, l2domains.Domain{ID: id, Name: name})
+	}
+	return out, nil
+}
+
+// Nameservers lists the nameserver sets collected, with handles for IDs.
+func (s *Sink) Nameservers() (out []nameservers.Nameserver, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, name := range s.nameservers {
+		out = append(out, nameservers.Nameserver{ID: id, Name: name})
+	}
+	return out, nil
+}
+
+// VLANID returns a handle for the VLAN with number n.
+func (s *Sink) VLANID(n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.vlanHandles[n]; ok {
+		return h, nil
+	}
+	h := s.nextHandle()
+	s.vlanHandles[n], s.vlans[h] = h, n
+	return h, nil
+}
+
+// VLANIDs returns no VLANs, so that each VLAN number is looked up with
+// VLANID.
+func (s *Sink) VLANIDs() (map[int]int, error) {
+	return map[int]int{}, nil
+}
+
+// SubnetID returns a handle for the subnet with the CIDR subnet address in
+// the section with ID sectionID.
+func (s *Sink) SubnetID(sectionID int, cidr string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := subnetKey{sectionID, cidr}
+	if h, ok := s.subnetHandles[key]; ok {
+		return h, nil
+	}
+	h := s.nextHandle()
+	s.subnetHandles[key], s.subnets[h] = h, key
+	return h, nil
+}
+
+// SubnetIDs returns no subnets, so that each subnet is looked up with
+// SubnetID.
+func (s *Sink) SubnetIDs(sectionID int) (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
+// VerifyAddresses returns an error, as there is nothing to verify against.
+func (s *Sink) VerifyAddresses(addrs []addresses.Address) ([]verify.Mismatch, error) {
+	return nil, errors.New("IP addresses cannot be verified against an export")
+}
+
+// Export returns the objects collected, sorted.
+func (s *Sink) Export() *Export {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := &Export{
+		L2Domains:   append([]L2Domain(nil), s.out.L2Domains...),
+		VLANs:       append([]VLAN{}, s.out.VLANs...),
+		VRFs:        append([]VRF{}, s.out.VRFs...),
+		Nameservers: append([]Nameserver(nil), s.out.Nameservers...),
+		Subnets:     append([]Subnet{}, s.out.Subnets...),
+		Devices:     append([]Device{}, s.out.Devices...),
+		Addresses:   append([]Address{}, s.out.Addresses...),
+		Requests:    append([]Request(nil), s.out.Requests...),
+		Groups:      append([]Group(nil), s.out.Groups...),
+		Users:       append([]User(nil), s.out.Users...),
+		Changelog:   append([]Change(nil), s.out.Changelog...),
+	}
+	sort.SliceStable(out.L2Domains, func(i, j int) bool { return out.L2Domains[i].Name < out.L2Domains[j].Name })
+	sort.SliceStable(out.VLANs, func(i, j int) bool {
+		a, b := out.VLANs[i], out.VLANs[j]
+		if a.Number != b.Number {
+			return a.Number < b.Number
+		}
+		return a.Domain < b.Domain
+	})
+	sort.SliceStable(out.VRFs, func(i, j int) bool { return out.VRFs[i].Name < out.VRFs[j].Name })
+	sort.SliceStable(out.Nameservers, func(i, j int) bool { return out.Nameservers[i].Name < out.Nameservers[j].Name })
+	sort.SliceStable(out.Subnets, func(i, j int) bool {
+		a, b := out.Subnets[i], out.Subnets[j]
+		if a.SectionID != b.SectionID {
+			return a.SectionID < b.SectionID
+		}
+		return helper.SubnetLess(cidrSubnet(a.CIDR), cidrSubnet(b.CIDR))
+	})
+	sort.SliceStable(out.Devices, func(i, j int) bool { return out.Devices[i].Hostname < out.Devices[j].Hostname })
+	sort.SliceStable(out.Addresses, func(i, j int) bool {
+		a, b := out.Addresses[i], out.Addresses[j]
+		switch {
+		case a.SectionID != b.SectionID:
+			return a.SectionID < b.SectionID
+		case a.Subnet != b.Subnet:
+			return helper.SubnetLess(cidrSubnet(a.Subnet), cidrSubnet(b.Subnet))
+		}
+		return bytes.Compare(net.ParseIP(a.IPAddress).To16(), net.ParseIP(b.IPAddress).To16()) < 0
+	})
+	sort.SliceStable(out.Groups, func(i, j int) bool { return out.Groups[i].Name < out.Groups[j].Name })
+	sort.SliceStable(out.Users, func(i, j int) bool { return out.Users[i].Username < out.Users[j].Username })
+	sort.SliceStable(out.Requests, func(i, j int) bool {
+		a, b := out.Requests[i], out.Requests[j]
+		switch {
+		case a.SectionID != b.SectionID:
+			return a.SectionID < b.SectionID
+		case a.Subnet != b.Subnet:
+			return helper.SubnetLess(cidrSubnet(a.Subnet), cidrSubnet(b.Subnet))
+		}
+		return bytes.Compare(net.ParseIP(a.IPAddress).To16(), net.ParseIP(b.IPAddress).To16()) < 0
+	})
+	// Changelog entries are kept in the order that the changes were made.
+	sort.SliceStable(out.Changelog, func(i, j int) bool {
+		a, b := out.Changelog[i], out.Changelog[j]
+		switch {
+		case a.Date != b.Date:
+			return a.Date < b.Date
+		case a.SectionID != b.SectionID:
+			return a.SectionID < b.SectionID
+		case a.Subnet != b.Subnet:
+			return helper.SubnetLess(cidrSubnet(a.Subnet), cidrSubnet(b.Subnet))
+		case a.IPAddress != b.IPAddress:
+			return bytes.Compare(net.ParseIP(a.IPAddress).To16(), net.ParseIP(b.IPAddress).To16()) < 0
+		}
+		return a.Action < b.Action
+	})
+	return out
+}
+
+// cidrSubnet returns a subnet with the address and mask of a CIDR, for
+// sorting.
+func cidrSubnet(cidr string) subnets.Subnet {
+	ip, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return subnets.Subnet{SubnetAddress: cidr}
+	}
+	mask, _ := n.Mask.Size()
+	return subnets.Subnet{SubnetAddress: ip.String(), Mask: mask}
+}
+
+// Write writes the objects collected to w in the supplied format.
+func (s *Sink) Write(w io.Writer, format string) error {
+	e := s.Export()
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	case Terraform:
+		return e.WriteTerraform(w)
+	case YAML:
+		b, err := yaml.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
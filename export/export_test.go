@@ -0,0 +1,214 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+	"gopkg.in/yaml.v2"
+)
+
+// testSink returns a sink with objects collected out of order, as they are
+// by a migration with multiple sections and workers.
+func testSink(t *testing.T) *Sink {
+	s := New()
+	s.CreateL2Domain(l2domains.Domain{Name: "office", Permissions: "1"})
+	domains, _ := s.L2Domains()
+	if len(domains) != 1 || domains[0].ID == 0 {
+		t.Fatalf("Unexpected L2 domains %#v", domains)
+	}
+	s.CreateVLAN(vlans.VLAN{Number: 200, Name: "users", DomainID: domains[0].ID}, nil)
+	s.CreateVLAN(vlans.VLAN{Number: 100, Name: "servers"}, map[string]string{"custom_site": "yvr"})
+	vlanID, _ := s.VLANID(100)
+	s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.1.0.0", Mask: 24, SectionID: 1, VLANID: vlanID}, nil)
+	s.CreateVRF(vrfs.VRF{Name: "customers", RD: "65000:1"})
+	found, _ := s.VRFs()
+	if len(found) != 1 || found[0].ID == 0 {
+		t.Fatalf("Unexpected VRFs %#v", found)
+	}
+	s.CreateNameserver(nameservers.Nameserver{Name: "Public", Addresses: "8.8.8.8;8.8.4.4", Permissions: "1"})
+	sets, _ := s.Nameservers()
+	if len(sets) != 1 || sets[0].ID == 0 {
+		t.Fatalf("Unexpected nameserver sets %#v", sets)
+	}
+	s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8, SectionID: 1, VRFID: found[0].ID, NameserverID: sets[0].ID}, nil)
+	s.CreateDeviceType(devices.DeviceType{Name: "Switch"})
+	types, _ := s.DeviceTypes()
+	if len(types) != 1 || types[0].ID == 0 {
+		t.Fatalf("Unexpected device types %#v", types)
+	}
+	s.CreateDevice(devices.Device{Hostname: "sw1", IPAddress: "10.1.0.250", Type: types[0].ID})
+	devs, _ := s.Devices()
+	if len(devs) != 1 || devs[0].ID == 0 {
+		t.Fatalf("Unexpected devices %#v", devs)
+	}
+	subnetID, _ := s.SubnetID(1, "10.1.0.0/24")
+	for _, v := range []struct {
+		ip      string
+		tag     int
+		mac     string
+		exclude bool
+		edited  string
+	}{{"10.1.0.10", helper.TagReserved, "00:1a:2b:3c:4d:5e", true, "2015-11-20 08:00:00"}, {"10.1.0.9", 0, "", false, ""}} {
+		if err := s.CreateAddress(addresses.Address{SubnetID: subnetID, IPAddress: v.ip, DeviceID: devs[0].ID, Port: "Gi0/1", MACAddress: v.mac, Tag: v.tag, ExcludePing: phpipam.BoolIntString(v.exclude), EditDate: v.edited}, nil); err != nil {
+			t.Fatalf("Error adding address: %s", err)
+		}
+	}
+	if err := s.CreateRequest(requests.Request{SubnetID: subnetID, Requester: "jo@example.com"}); err != nil {
+		t.Fatalf("Error adding request: %s", err)
+	}
+	s.CreateChange(changelog.Entry{Type: changelog.AddressType, SubnetID: subnetID, IPAddress: "10.1.0.9", Username: "jo", Action: "edit", Date: "2015-02-01 09:00:00"})
+	s.CreateChange(changelog.Entry{Type: changelog.SubnetType, SubnetID: subnetID, Action: "add", Result: "success", Date: "2015-01-01 09:00:00"})
+	s.CreateGroup(users.Group{Name: "Network", Description: "Network team"})
+	groups, _ := s.Groups()
+	if len(groups) != 1 || groups[0].ID == 0 {
+		t.Fatalf("Unexpected groups %#v", groups)
+	}
+	s.CreateUser(users.User{Username: "sam", Role: "Administrator"})
+	if err := s.CreateUser(users.User{Username: "jo", Email: "jo@example.com", Groups: []int{groups[0].ID}}); err != nil {
+		t.Fatalf("Error adding user: %s", err)
+	}
+	return s
+}
+
+func TestExport(t *testing.T) {
+	expected := &Export{
+		L2Domains: []L2Domain{{Name: "office", Sections: "1"}},
+		VLANs: []VLAN{
+			{Number: 100, Name: "servers", CustomFields: map[string]string{"custom_site": "yvr"}},
+			{Number: 200, Name: "users", Domain: "office"},
+		},
+		VRFs:        []VRF{{Name: "customers", RD: "65000:1"}},
+		Nameservers: []Nameserver{{Name: "Public", Addresses: "8.8.8.8;8.8.4.4", Sections: "1"}},
+		Subnets: []Subnet{
+			{SectionID: 1, CIDR: "10.0.0.0/8", VRF: "customers", Nameserver: "Public"},
+			{SectionID: 1, CIDR: "10.1.0.0/24", VLAN: 100},
+		},
+		Devices: []Device{{Hostname: "sw1", IPAddress: "10.1.0.250", Type: "Switch"}},
+		Addresses: []Address{
+			{SectionID: 1, Subnet: "10.1.0.0/24", IPAddress: "10.1.0.9", Device: "sw1", Port: "Gi0/1"},
+			{SectionID: 1, Subnet: "10.1.0.0/24", IPAddress: "10.1.0.10", Device: "sw1", Port: "Gi0/1", MAC: "00:1a:2b:3c:4d:5e", ExcludePing: true, EditDate: "2015-11-20 08:00:00", Tag: "Reserved"},
+		},
+		Requests: []Request{{SectionID: 1, Subnet: "10.1.0.0/24", Requester: "jo@example.com"}},
+		Groups:   []Group{{Name: "Network", Description: "Network team"}},
+		Users: []User{
+			{Username: "jo", Email: "jo@example.com", Groups: []string{"Network"}},
+			{Username: "sam", Role: "Administrator"},
+		},
+		Changelog: []Change{
+			{SectionID: 1, Subnet: "10.1.0.0/24", Action: "add", Result: "success", Date: "2015-01-01 09:00:00"},
+			{SectionID: 1, Subnet: "10.1.0.0/24", IPAddress: "10.1.0.9", Action: "edit", Date: "2015-02-01 09:00:00", User: "jo"},
+		},
+	}
+	if actual := testSink(t).Export(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+
+	if err := New().CreateAddress(addresses.Address{SubnetID: 5, IPAddress: "10.0.0.1"}, nil); err == nil {
+		t.Fatal("Expected error adding address to unknown subnet, got none")
+	}
+	if err := New().CreateRequest(requests.Request{SubnetID: 5}); err == nil {
+		t.Fatal("Expected error adding request to unknown subnet, got none")
+	}
+	if err := New().CreateChange(changelog.Entry{Type: changelog.SubnetType, SubnetID: 5}); err == nil {
+		t.Fatal("Expected error adding changelog entry to unknown subnet, got none")
+	}
+	if err := New().CreateUser(users.User{Username: "jo", Groups: []int{5}}); err == nil {
+		t.Fatal("Expected error adding user to unknown group, got none")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	s := testSink(t)
+	for _, format := range []string{JSON, YAML} {
+		var buf bytes.Buffer
+		if err := s.Write(&buf, format); err != nil {
+			t.Fatalf("Error writing %s: %s", format, err)
+		}
+		actual := &Export{}
+		var err error
+		if format == JSON {
+			err = json.Unmarshal(buf.Bytes(), actual)
+		} else {
+			err = yaml.Unmarshal(buf.Bytes(), actual)
+		}
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", format, err)
+		}
+		if expected := s.Export(); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("Expected %s to read back as %#v, got %#v", format, expected, actual)
+		}
+	}
+	if err := s.Write(&bytes.Buffer{}, "xml"); err == nil || !strings.Contains(err.Error(), "xml") {
+		t.Fatalf("Expected unknown format error, got %v", err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := testSink(t).Export().WriteCSV(filepath.Join(dir, "import")); err != nil {
+		t.Fatalf("Error writing CSV: %s", err)
+	}
+
+	expected := map[string]string{
+		VLANsFile:     "Name,Number,Description,custom_site\nservers,100,,yvr\nusers,200,,\n",
+		VRFsFile:      "Name,RD,Description\ncustomers,65000:1,\n",
+		SubnetsFile:   "Section,Subnet,Mask,Description,VLAN,VRF\n1,10.0.0.0,8,,,customers\n1,10.1.0.0,24,,100,\n",
+		AddressesFile: "Section,Subnet,IP address,Hostname,Description,Owner,Device,Port,MAC,Note,Tag\n1,10.1.0.0/24,10.1.0.9,,,,sw1,Gi0/1,,,\n1,10.1.0.0/24,10.1.0.10,,,,sw1,Gi0/1,00:1a:2b:3c:4d:5e,,Reserved\n",
+	}
+	for name, content := range expected {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "import", name))
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", name, err)
+		}
+		if string(b) != content {
+			t.Fatalf("Expected %s to be %q, got %q", name, content, string(b))
+		}
+	}
+}
+
+func TestWriteTerraform(t *testing.T) {
+	e := testSink(t).Export()
+	e.Subnets[1].Description = `a "${var}"`
+	e.Subnets = append(e.Subnets, Subnet{SectionID: 1, CIDR: "10.2.0.0/24", VLAN: 300})
+	e.Addresses = append(e.Addresses, Address{SectionID: 2, Subnet: "10.3.0.0/24", IPAddress: "10.3.0.1", Gateway: true})
+	var buf bytes.Buffer
+	if err := e.WriteTerraform(&buf); err != nil {
+		t.Fatalf("Error writing Terraform: %s", err)
+	}
+	for _, expected := range []string{
+		"resource \"phpipam_vlan\" \"vlan_100\" {\n  name   = \"servers\"\n  number = 100\n\n  custom_fields = {\n    \"custom_site\" = \"yvr\"\n  }\n}\n",
+		"  description      = \"a \\\"$${var}\\\"\"\n  vlan_id          = phpipam_vlan.vlan_100.vlan_id\n  master_subnet_id = phpipam_subnet.subnet_1_10_0_0_0_8.subnet_id\n",
+		"data \"phpipam_vlan\" \"vlan_300\" {\n  number = 300\n}\n",
+		"  vlan_id          = data.phpipam_vlan.vlan_300.vlan_id\n  master_subnet_id = phpipam_subnet.subnet_1_10_0_0_0_8.subnet_id\n",
+		"resource \"phpipam_address\" \"address_1_10_1_0_9\" {\n  subnet_id  = phpipam_subnet.subnet_1_10_1_0_0_24.subnet_id\n  ip_address = \"10.1.0.9\"\n  port       = \"Gi0/1\"\n}\n",
+		"  port         = \"Gi0/1\"\n  mac_address  = \"00:1a:2b:3c:4d:5e\"\n  exclude_ping = true\n  state_tag_id = 3\n}\n",
+		"data \"phpipam_subnet\" \"subnet_2_10_3_0_0_24\" {\n  section_id     = 2\n  subnet_address = \"10.3.0.0\"\n  subnet_mask    = 24\n}\n",
+		"  subnet_id  = data.phpipam_subnet.subnet_2_10_3_0_0_24.subnet_id\n  ip_address = \"10.3.0.1\"\n  is_gateway = true\n}\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("Expected Terraform to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
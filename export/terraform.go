@@ -0,0 +1,256 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+)
+
+// Terraform is the format of an export to Terraform configuration, using the
+// resources of the PHPIPAM Terraform provider.
+const Terraform = "terraform"
+
+// terraformHeader starts a Terraform export.
+const terraformHeader = `# PHPIPAM resources generated by phpipam-legacy-migrator, for the PHPIPAM
+# Terraform provider (https://github.com/paybyphone/terraform-provider-phpipam).
+#
+# Devices and VRFs are not included, as the provider has no resources for them.`
+
+// attribute is an attribute of a Terraform resource, with its value already
+// rendered.
+type attribute struct {
+	name  string
+	value string
+}
+
+// WriteTerraform writes the export to w as Terraform configuration, with a
+// phpipam_vlan, phpipam_subnet, or phpipam_address resource for each VLAN,
+// subnet, and IP address. Resources refer to each other by reference, so that
+// Terraform creates them in order: subnets to their VLAN and to the narrowest
+// subnet in their section that contains them, and addresses to their subnet.
+// VLANs and subnets that are referred to but not exported, as they were
+// migrated by an earlier run, are looked up with data sources instead.
+func (e *Export) WriteTerraform(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, terraformHeader)
+	names := make(map[string]bool)
+
+	vlanNames := make(map[int]string)
+	for _, v := range e.VLANs {
+		name := resourceName(names, "vlan_%d", v.Number)
+		if _, ok := vlanNames[v.Number]; !ok {
+			vlanNames[v.Number] = name
+		}
+		writeResource(bw, "phpipam_vlan", name, []attribute{
+			{"name", hclString(v.Name)},
+			{"number", strconv.Itoa(v.Number)},
+			{"description", optionalString(v.Description)},
+		}, v.CustomFields)
+	}
+
+	vlanData := make(map[int]bool)
+	subnetNames := make(map[subnetKey]string)
+	for _, v := range e.Subnets {
+		subnetNames[subnetKey{v.SectionID, v.CIDR}] = resourceName(names, "subnet_%d_%s", v.SectionID, v.CIDR)
+	}
+	for _, v := range e.Subnets {
+		addr, mask := splitCIDR(v.CIDR)
+		attrs := []attribute{
+			{"section_id", strconv.Itoa(v.SectionID)},
+			{"subnet_address", hclString(addr)},
+			{"subnet_mask", mask},
+			{"description", optionalString(v.Description)},
+		}
+		if v.VLAN != 0 {
+			name, ok := vlanNames[v.VLAN]
+			if !ok {
+				name = resourceName(names, "vlan_%d", v.VLAN)
+				vlanNames[v.VLAN], vlanData[v.VLAN] = name, true
+				writeData(bw, "phpipam_vlan", name, []attribute{{"number", strconv.Itoa(v.VLAN)}})
+			}
+			attrs = append(attrs, attribute{"vlan_id", reference("phpipam_vlan", name, vlanData[v.VLAN]) + ".vlan_id"})
+		}
+		if name := parentSubnet(subnetNames, v.SectionID, v.CIDR); name != "" {
+			attrs = append(attrs, attribute{"master_subnet_id", fmt.Sprintf("phpipam_subnet.%s.subnet_id", name)})
+		}
+		writeResource(bw, "phpipam_subnet", subnetNames[subnetKey{v.SectionID, v.CIDR}], attrs, v.CustomFields)
+	}
+
+	subnetData := make(map[subnetKey]bool)
+	for _, v := range e.Addresses {
+		key := subnetKey{v.SectionID, v.Subnet}
+		name, ok := subnetNames[key]
+		if !ok {
+			addr, mask := splitCIDR(v.Subnet)
+			name = resourceName(names, "subnet_%d_%s", v.SectionID, v.Subnet)
+			subnetNames[key], subnetData[key] = name, true
+			writeData(bw, "phpipam_subnet", name, []attribute{
+				{"section_id", strconv.Itoa(v.SectionID)},
+				{"subnet_address", hclString(addr)},
+				{"subnet_mask", mask},
+			})
+		}
+		writeResource(bw, "phpipam_address", resourceName(names, "address_%d_%s", v.SectionID, v.IPAddress), []attribute{
+			{"subnet_id", reference("phpipam_subnet", name, subnetData[key]) + ".subnet_id"},
+			{"ip_address", hclString(v.IPAddress)},
+			{"hostname", optionalString(v.Hostname)},
+			{"description", optionalString(v.Description)},
+			{"owner", optionalString(v.Owner)},
+			{"port", optionalString(v.Port)},
+			{"mac_address", optionalString(v.MAC)},
+			{"exclude_ping", optionalBool(v.ExcludePing)},
+			{"skip_ptr_record", optionalBool(v.PTRIgnore)},
+			{"is_gateway", optionalBool(v.Gateway)},
+			{"note", optionalString(v.Note)},
+			{"state_tag_id", tagID(v.Tag)},
+		}, v.CustomFields)
+	}
+	return bw.Flush()
+}
+
+// resourceName returns a unique name for a resource, from format and args with
+// anything that is not valid in a name replaced by underscores, and records
+// it in names. Names already used are suffixed with a counter.
+func resourceName(names map[string]bool, format string, args ...interface{}) string {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, fmt.Sprintf(format, args...))
+	name := base
+	for i := 2; names[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	names[name] = true
+	return name
+}
+
+// parentSubnet returns the name of the narrowest subnet in names, in the
+// section with ID sectionID, that contains cidr, or blank if there is none.
+func parentSubnet(names map[subnetKey]string, sectionID int, cidr string) string {
+	ip, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ""
+	}
+	mask, bits := n.Mask.Size()
+	for m := mask - 1; m >= 0; m-- {
+		parent := &net.IPNet{IP: ip.Mask(net.CIDRMask(m, bits)), Mask: net.CIDRMask(m, bits)}
+		if name, ok := names[subnetKey{sectionID, parent.String()}]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// reference returns a reference to a resource, or to a data source if data is
+// true.
+func reference(kind, name string, data bool) string {
+	if data {
+		return fmt.Sprintf("data.%s.%s", kind, name)
+	}
+	return fmt.Sprintf("%s.%s", kind, name)
+}
+
+// writeData writes a data source block with the supplied attributes.
+func writeData(w io.Writer, kind, name string, attrs []attribute) {
+	writeBlock(w, "data", kind, name, attrs, nil)
+}
+
+// writeResource writes a resource block with the supplied attributes.
+func writeResource(w io.Writer, kind, name string, attrs []attribute, fields map[string]string) {
+	writeBlock(w, "resource", kind, name, attrs, fields)
+}
+
+// writeBlock writes a block with the supplied attributes, skipping
+// those with blank values, and custom fields, aligned as by terraform fmt.
+func writeBlock(w io.Writer, block, kind, name string, attrs []attribute, fields map[string]string) {
+	var set []attribute
+	for _, v := range attrs {
+		if v.value != "" {
+			set = append(set, v)
+		}
+	}
+	width := 0
+	for _, v := range set {
+		if len(v.name) > width {
+			width = len(v.name)
+		}
+	}
+	fmt.Fprintf(w, "\n%s %q %q {\n", block, kind, name)
+	for _, v := range set {
+		fmt.Fprintf(w, "  %-*s = %s\n", width, v.name, v.value)
+	}
+	if len(fields) == 0 {
+		fmt.Fprintln(w, "}")
+		return
+	}
+
+	var keys []string
+	width = 0
+	for k := range fields {
+		keys = append(keys, k)
+		if len(hclString(k)) > width {
+			width = len(hclString(k))
+		}
+	}
+	sort.Strings(keys)
+	if len(set) > 0 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "  custom_fields = {")
+	for _, k := range keys {
+		fmt.Fprintf(w, "    %-*s = %s\n", width, hclString(k), hclString(fields[k]))
+	}
+	fmt.Fprintln(w, "  }")
+	fmt.Fprintln(w, "}")
+}
+
+// hclReplacer escapes a string for an HCL quoted string, including template
+// sequences, which would otherwise be interpolated.
+var hclReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", "$${",
+	"%{", "%%{",
+)
+
+// hclString returns s as an HCL quoted string.
+func hclString(s string) string {
+	return `"` + hclReplacer.Replace(s) + `"`
+}
+
+// tagID returns the ID of the address tag with the exported name, or blank if
+// the address is not tagged.
+func tagID(name string) string {
+	id, _ := helper.AddressTagID(name)
+	return number(id)
+}
+
+// optionalBool returns true if b is true, or blank if it is false, so that
+// the attribute is left out.
+func optionalBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
+
+// optionalString returns s as an HCL quoted string, or blank if s is blank, so
+// that the attribute is left out.
+func optionalString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return hclString(s)
+}
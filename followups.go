@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/runbook"
+	"github.com/sirupsen/logrus"
+)
+
+// The categories of the post-migration runbook.
+const (
+	runbookSkipped     = "Skipped Data"
+	runbookFailed      = "Failed Objects"
+	runbookUsers       = "Users"
+	runbookPermissions = "Permissions"
+	runbookSettings    = "Settings"
+	runbookVLANs       = "VLANs"
+	runbookHostnames   = "Hostnames"
+)
+
+// writeRunbook writes the post-migration runbook to runbookFile. The runbook
+// lists the manual work that remains based on what the section runs could not
+// migrate, and on the parts of the legacy DB that the tool does not migrate at
+// all.
+func writeRunbook(conn *sql.DB, runs []*sectionRun) {
+	r := runbook.New()
+
+	var sections []string
+	for _, s := range runs {
+		sections = append(sections, strconv.Itoa(s.ID))
+		if s.SkippedSubnets > 0 {
+			r.Add(runbookSkipped, fmt.Sprintf("Create the %d IPv6 subnets in %s manually - only IPv4 subnets are migrated", s.SkippedSubnets, s))
+		}
+		if s.SkippedAddresses > 0 {
+			r.Add(runbookSkipped, fmt.Sprintf("Create the %d IPv6 addresses in %s manually - only IPv4 addresses are migrated", s.SkippedAddresses, s))
+		}
+		if s.SkippedNetworkBroadcast > 0 {
+			r.Add(runbookSkipped, fmt.Sprintf("Review the %d network and broadcast addresses in %s that were skipped with -skip-network-broadcast", s.SkippedNetworkBroadcast, s))
+		}
+		if len(s.Errors) > 0 {
+			var details []string
+			for _, err := range s.Errors {
+				details = append(details, err.Error())
+			}
+			r.Add(runbookFailed, fmt.Sprintf("Fix and migrate the %d objects in %s that failed", len(s.Errors), s), details...)
+		}
+		if len(s.Overlaps) > 0 {
+			var details []string
+			for _, v := range s.Overlaps {
+				details = append(details, fmt.Sprintf("%s: %s", v, v.Suggestion()))
+			}
+			r.Add(runbookFailed, fmt.Sprintf("Resolve the %d conflicts between the legacy subnets in %s, which PHPIPAM rejects", len(s.Overlaps), s), details...)
+		}
+		if len(s.InvalidHostnames) > 0 {
+			r.Add(runbookHostnames, fmt.Sprintf("Fix the %d hostnames in %s that are not valid RFC 1123 hostnames", len(s.InvalidHostnames), s), s.InvalidHostnames...)
+		}
+		if s.Err != nil {
+			r.Add(runbookFailed, fmt.Sprintf("Re-run the migration of %s, which was aborted", s), s.Err.Error())
+		}
+	}
+
+	switch {
+	case len(orphanAddresses) > 0 && orphansSubnet == "":
+		r.Add(runbookSkipped, fmt.Sprintf("Create the %d legacy addresses whose subnet does not exist manually - they are not migrated", len(orphanAddresses)))
+	case len(orphanAddresses) > 0:
+		r.Add(runbookSkipped, fmt.Sprintf("Move the %d legacy addresses whose subnet does not exist out of the catch-all subnet %s", len(orphanAddresses), orphansSubnet))
+	}
+
+	reader := &legacydb.Reader{DB: conn, Queries: legacyQueries}
+	users, err := reader.Users()
+	switch {
+	case err != nil:
+		logrus.Warnf("Error reading legacy users for runbook: %s", err)
+		r.Add(runbookUsers, "Recreate the legacy users in the new PHPIPAM instance - the legacy users could not be read", err.Error())
+	case len(users) > 0 && migrateUsers && usersDefaultPassword == "":
+		r.Add(runbookUsers, fmt.Sprintf("Set the passwords of the %d migrated users - passwords are not migrated", len(users)), users...)
+	case len(users) > 0 && migrateUsers:
+		r.Add(runbookUsers, fmt.Sprintf("Tell the %d migrated users their default password, which they must change at their next login - passwords are not migrated", len(users)), users...)
+	case len(users) > 0:
+		r.Add(runbookUsers, fmt.Sprintf("Recreate the %d legacy users in the new PHPIPAM instance - users are not migrated", len(users)), users...)
+	}
+
+	// Owners are only migrated to PHPIPAM, which has an owner field.
+	if target != "phpipam" {
+		if owned, err := reader.OwnedAddresses(); err != nil {
+			logrus.Warnf("Error counting legacy address owners for runbook: %s", err)
+		} else if owned > 0 {
+			r.Add(runbookUsers, fmt.Sprintf("Reassign the owners of the %d legacy addresses that had one - owners are not migrated to %s", owned, target))
+		}
+	}
+
+	if len(duplicateVLANNumbers) > 0 {
+		var numbers []string
+		for _, n := range duplicateVLANNumbers {
+			numbers = append(numbers, strconv.Itoa(n))
+		}
+		r.Add(runbookVLANs, fmt.Sprintf("Review the %d VLAN numbers used by more than one legacy VLAN, which were migrated with -duplicate-vlans %s", len(numbers), duplicateVLANs), numbers...)
+	}
+
+	r.Add(runbookPermissions, fmt.Sprintf("Review the group permissions of section(s) %s - legacy permissions are not migrated", strings.Join(sections, ", ")))
+	r.Add(runbookSettings, "Configure the new PHPIPAM instance's settings (site title and URL, mail, and authentication) - legacy settings are not migrated")
+
+	if err := r.Save(runbookFile); err != nil {
+		logrus.Fatalf("Error writing runbook: %s", err)
+	}
+	logrus.Infof("Wrote runbook of %d manual follow-ups to %s", len(r.Items), runbookFile)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/state"
+	"github.com/sirupsen/logrus"
+)
+
+// freezePollInterval is how often the legacy DB is checked for writes during
+// the freeze window.
+const freezePollInterval = 10 * time.Second
+
+// takeSyncSnapshot snapshots the legacy DB tables before they are fetched, to
+// be recorded as the last sync by recordSync if the run succeeds. It is taken
+// before the run rather than after it, so that writes made to the legacy DB
+// during the run are detected by later freeze checks, rather than recorded as
+// synced.
+func takeSyncSnapshot(conn *sql.DB) *state.Snapshot {
+	snap, err := state.TakeSnapshot(conn, legacyTables())
+	if err != nil {
+		logrus.Fatalf("Error taking snapshot of legacy DB: %s", err)
+	}
+	return snap
+}
+
+// recordSync saves a snapshot taken by takeSyncSnapshot in the state file as
+// the last sync, so that later freeze checks can detect writes made after it.
+// The time the run started and the highest legacy address ID it read are
+// saved too, for delta passes with -since last.
+func recordSync(snap *state.Snapshot) {
+	st, err := state.Load(stateFile)
+	if err != nil {
+		logrus.Fatalf("Error loading state file: %s", err)
+	}
+	st.LastSync = snap
+	st.LastRunStarted = runStarted
+	if maxAddressID > st.MaxAddressID {
+		st.MaxAddressID = maxAddressID
+	}
+	if err := st.Save(stateFile); err != nil {
+		logrus.Fatalf("Error saving state file: %s", err)
+	}
+	logrus.Infof("Recorded sync snapshot of legacy DB in %s", stateFile)
+}
+
+// runFreezeCheck checks the legacy DB for writes made since the last sync
+// recorded in the state file. If freezeWindow is set, the DB is monitored for
+// that long, rather than checked once.
+//
+// The program exits with an error if any writes are detected, as another
+// delta pass is needed before the migration can be declared complete.
+func runFreezeCheck(conn *sql.DB) {
+	st, err := state.Load(stateFile)
+	if err != nil {
+		logrus.Fatalf("Error loading state file: %s", err)
+	}
+	if st.LastSync == nil {
+		logrus.Fatalf("No sync recorded in state file %s - run a migration with -state-file first", stateFile)
+	}
+	logrus.Infof("Checking legacy DB for writes since last sync at %s", st.LastSync.Taken.Format(time.RFC3339))
+
+	deadline := time.Now().Add(freezeWindow)
+	for {
+		snap, err := state.TakeSnapshot(conn, legacyTables())
+		if err != nil {
+			logrus.Fatalf("Error taking snapshot of legacy DB: %s", err)
+		}
+		if changes := st.LastSync.Changes(snap); len(changes) > 0 {
+			for _, v := range changes {
+				logrus.Warnf("Legacy DB changed since last sync: %s", v)
+			}
+			logrus.Fatal("Freeze check failed: the legacy DB was written to after the last sync. Run another delta pass before declaring the migration complete.")
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(freezePollInterval)
+	}
+	logrus.Info("Freeze check passed: no writes to the legacy DB since the last sync.")
+}
@@ -0,0 +1,27 @@
+package helper
+
+import (
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+)
+
+// GroupAddressesBySubnet splits addrs into groups of addresses sharing the
+// same subnet ID. The groups are in the order each subnet first appears in
+// addrs, and the addresses keep their order within each group.
+//
+// Groups are the unit of work when adding addresses concurrently: each group
+// is added in order by a single worker, so that concurrent requests never
+// contend for the same subnet.
+func GroupAddressesBySubnet(addrs []addresses.Address) [][]addresses.Address {
+	var out [][]addresses.Address
+	index := make(map[int]int)
+	for _, v := range addrs {
+		i, ok := index[v.SubnetID]
+		if !ok {
+			i = len(out)
+			index[v.SubnetID] = i
+			out = append(out, nil)
+		}
+		out[i] = append(out[i], v)
+	}
+	return out
+}
@@ -0,0 +1,26 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+)
+
+func TestGroupAddressesBySubnet(t *testing.T) {
+	addrs := []addresses.Address{
+		{IPAddress: "10.0.1.1", SubnetID: 2},
+		{IPAddress: "10.0.0.1", SubnetID: 1},
+		{IPAddress: "10.0.1.2", SubnetID: 2},
+		{IPAddress: "10.0.0.2", SubnetID: 1},
+		{IPAddress: "10.0.2.1", SubnetID: 3},
+	}
+	expected := [][]addresses.Address{
+		{addrs[0], addrs[2]},
+		{addrs[1], addrs[3]},
+		{addrs[4]},
+	}
+	if actual := GroupAddressesBySubnet(addrs); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The IDs of the address tags built into PHPIPAM 1.2 and later, which replaced
+// the address states of earlier versions.
+const (
+	TagOffline  = 1
+	TagUsed     = 2
+	TagReserved = 3
+	TagDHCP     = 4
+)
+
+// addressTagNames are the names of the built-in address tags, keyed by ID.
+var addressTagNames = map[int]string{
+	TagOffline:  "Offline",
+	TagUsed:     "Used",
+	TagReserved: "Reserved",
+	TagDHCP:     "DHCP",
+}
+
+// AddressTagName returns the name of the address tag with ID id, or the ID
+// itself if it is not a built-in tag, as the names of other tags are not
+// known. It returns blank for an ID of 0, which is no tag.
+func AddressTagName(id int) string {
+	if id == 0 {
+		return ""
+	}
+	if name, ok := addressTagNames[id]; ok {
+		return name
+	}
+	return strconv.Itoa(id)
+}
+
+// AddressTagID returns the ID of the built-in address tag with the supplied
+// name, which is not case sensitive, or the ID that name holds if it is a
+// number.
+func AddressTagID(name string) (int, error) {
+	name = strings.TrimSpace(name)
+	if id, err := strconv.Atoi(name); err == nil && id > 0 {
+		return id, nil
+	}
+	for id, v := range addressTagNames {
+		if strings.EqualFold(v, name) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown address tag %q", name)
+}
+
+// DefaultAddressStates maps the address states of PHPIPAM 0.8 to 1.1 to the
+// tags that replaced them: 0 (offline), 1 (active), 2 (reserved), and 3
+// (DHCP).
+func DefaultAddressStates() map[string]int {
+	return map[string]int{
+		"0": TagOffline,
+		"1": TagUsed,
+		"2": TagReserved,
+		"3": TagDHCP,
+	}
+}
+
+// ParseAddressStates parses a comma-separated list of LEGACY:TAG pairs (ie:
+// 4:Reserved,5:7), where TAG is the name of a built-in address tag or the ID
+// of any tag, and returns DefaultAddressStates with the pairs added, replacing
+// the default tags of the legacy states supplied. Each legacy state can only
+// be mapped once.
+func ParseAddressStates(s string) (map[string]int, error) {
+	out := DefaultAddressStates()
+	seen := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid address state mapping %q: must be in LEGACY:TAG format", v)
+		}
+		state := strings.TrimSpace(parts[0])
+		id, err := AddressTagID(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag in address state mapping %q: %s", v, err)
+		}
+		if seen[state] {
+			return nil, fmt.Errorf("legacy address state %s mapped more than once", state)
+		}
+		seen[state] = true
+		out[state] = id
+	}
+	return out, nil
+}
@@ -0,0 +1,41 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddressTags(t *testing.T) {
+	for id, name := range map[int]string{0: "", TagOffline: "Offline", TagDHCP: "DHCP", 7: "7"} {
+		if actual := AddressTagName(id); actual != name {
+			t.Fatalf("Expected name %q for tag %d, got %q", name, id, actual)
+		}
+	}
+	for name, id := range map[string]int{"reserved": TagReserved, " Used ": TagUsed, "7": 7} {
+		if actual, err := AddressTagID(name); err != nil || actual != id {
+			t.Fatalf("Expected ID %d for tag %q, got %d, %v", id, name, actual, err)
+		}
+	}
+	for _, v := range []string{"", "0", "Gone"} {
+		if _, err := AddressTagID(v); err == nil {
+			t.Fatalf("Expected error for tag %q, got none", v)
+		}
+	}
+}
+
+func TestParseAddressStates(t *testing.T) {
+	actual, err := ParseAddressStates("3:Used, 4:reserved,x:7")
+	if err != nil {
+		t.Fatalf("Error parsing address states: %s", err)
+	}
+	expected := map[string]int{"0": TagOffline, "1": TagUsed, "2": TagReserved, "3": TagUsed, "4": TagReserved, "x": 7}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+
+	for _, v := range []string{"4", ":Used", "4:", "4:Gone", "4:Used,4:DHCP", "4:1:2"} {
+		if _, err := ParseAddressStates(v); err == nil {
+			t.Fatalf("Expected error parsing %q, got none", v)
+		}
+	}
+}
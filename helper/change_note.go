@@ -0,0 +1,33 @@
+package helper
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// changeNotePrefix marks the machine-generated part of a note.
+const changeNotePrefix = "[migration] "
+
+// ChangeNote appends a machine-generated summary of the changes made to an
+// object during migration to its existing note, so that anyone looking at the
+// object later can understand why it differs from the legacy instance. The
+// note is returned unaltered if there are no changes.
+func ChangeNote(note string, changes []string) string {
+	if len(changes) == 0 {
+		return note
+	}
+	summary := changeNotePrefix + strings.Join(changes, "; ")
+	if note == "" {
+		return summary
+	}
+	return note + "\n" + summary
+}
+
+// Truncate shortens s to at most n characters (not bytes), and reports
+// whether or not it was truncated.
+func Truncate(s string, n int) (string, bool) {
+	if utf8.RuneCountInString(s) <= n {
+		return s, false
+	}
+	return string([]rune(s)[:n]), true
+}
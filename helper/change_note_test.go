@@ -0,0 +1,32 @@
+package helper
+
+import (
+	"testing"
+)
+
+func TestChangeNote(t *testing.T) {
+	cases := []struct {
+		note     string
+		changes  []string
+		expected string
+	}{
+		{"", nil, ""},
+		{"foo", nil, "foo"},
+		{"", []string{"a"}, "[migration] a"},
+		{"foo", []string{"a", "b"}, "foo\n[migration] a; b"},
+	}
+	for _, c := range cases {
+		if actual := ChangeNote(c.note, c.changes); c.expected != actual {
+			t.Fatalf("Expected %q, got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if s, ok := Truncate("héllo", 5); s != "héllo" || ok {
+		t.Fatalf("Expected héllo to be unaltered, got %q (truncated: %t)", s, ok)
+	}
+	if s, ok := Truncate("héllo", 2); s != "hé" || !ok {
+		t.Fatalf("Expected hé, got %q (truncated: %t)", s, ok)
+	}
+}
@@ -0,0 +1,36 @@
+package helper
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// cp1252 maps the bytes 0x80 to 0x9f of MySQL's latin1 character set, which is
+// actually Windows-1252 rather than ISO-8859-1, to their runes. The bytes that
+// Windows-1252 leaves undefined map to the C1 control character of the same
+// value, as they do in MySQL.
+var cp1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// Latin1ToUTF8 returns s, read as MySQL latin1 (Windows-1252), transcoded to
+// UTF-8, and reports whether or not it was transcoded. Strings that are
+// already valid UTF-8, such as those written by UTF-8 clients into latin1
+// columns, are returned as they are, as latin1 text with accented
+// characters is almost never valid UTF-8.
+func Latin1ToUTF8(s string) (string, bool) {
+	if utf8.ValidString(s) {
+		return s, false
+	}
+	var b strings.Builder
+	b.Grow(len(s) * 2)
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 0x80 && c < 0xa0 {
+			b.WriteRune(cp1252[c-0x80])
+		} else {
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,19 @@
+package helper
+
+import "testing"
+
+func TestLatin1ToUTF8(t *testing.T) {
+	for in, expected := range map[string]string{
+		"":                          "",
+		"core switch":               "core switch",
+		"caf\xe9 r\xe9seau":         "café réseau",
+		"M\xfcnchen \x80 \x93x\x94": "München € “x”",
+		"\x81\xff":                  "\u0081ÿ",
+		"already café":              "already café",
+	} {
+		actual, transcoded := Latin1ToUTF8(in)
+		if actual != expected || transcoded != (actual != in) {
+			t.Fatalf("Expected %q for %q, got %q (%t)", expected, in, actual, transcoded)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package helper
+
+import "strings"
+
+// ValidHostname reports whether or not h is a valid RFC 1123 hostname: at
+// most 253 characters of dot-separated labels, each 1 to 63 letters, digits,
+// and hyphens that neither start nor end with a hyphen. A single trailing dot,
+// as in a fully qualified name, is allowed.
+func ValidHostname(h string) bool {
+	h = strings.TrimSuffix(h, ".")
+	if h == "" || len(h) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(h, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
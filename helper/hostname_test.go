@@ -0,0 +1,31 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidHostname(t *testing.T) {
+	for in, expected := range map[string]bool{
+		"host":                            true,
+		"Host-01.example.com":             true,
+		"host.example.com.":               true,
+		"1host.example.com":               true,
+		strings.Repeat("a", 63) + ".com":  true,
+		strings.Repeat("a", 64) + ".com":  false,
+		strings.Repeat("a.", 127) + "com": false,
+		"":                                false,
+		".":                               false,
+		"host..example.com":               false,
+		"-host.example.com":               false,
+		"host-.example.com":               false,
+		"host_name.example.com":           false,
+		"host name":                       false,
+		"hôte.example.com":                false,
+		"host.example.com..":              false,
+	} {
+		if actual := ValidHostname(in); actual != expected {
+			t.Fatalf("Expected %t for hostname %q, got %t", expected, in, actual)
+		}
+	}
+}
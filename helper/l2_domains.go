@@ -0,0 +1,117 @@
+package helper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// L2Domain is an L2 domain in an L2 domain mapping file, along with the
+// numbers of the VLANs that are created in it.
+type L2Domain struct {
+	// The name of the L2 domain, which is matched with the domains already in
+	// the new PHPIPAM instance.
+	Name string `yaml:"name"`
+
+	// A description of the L2 domain, used if it is created.
+	Description string `yaml:"description"`
+
+	// The IDs of the sections in the new PHPIPAM instance that the L2 domain
+	// can be used in, if it is created. It defaults to the migrated sections.
+	Sections []int `yaml:"sections"`
+
+	// The VLAN numbers (ie: 100) and ranges (ie: 200-299) in the L2 domain.
+	VLANs []string `yaml:"vlans"`
+
+	// The parsed ranges of VLANs, as first and last numbers.
+	ranges [][2]int
+}
+
+// L2DomainMapping maps VLAN numbers to the L2 domains that they are created
+// in.
+type L2DomainMapping []L2Domain
+
+// LoadL2DomainMapping reads and checks the YAML L2 domain mapping file at
+// path, which holds a list of L2 domains. Each domain must be named once, and
+// each VLAN number can only be in one domain.
+func LoadL2DomainMapping(path string) (L2DomainMapping, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m L2DomainMapping
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, fmt.Errorf("error parsing L2 domain mapping file %s: %s", path, err)
+	}
+	if err := m.parse(); err != nil {
+		return nil, fmt.Errorf("invalid L2 domain mapping file %s: %s", path, err)
+	}
+	return m, nil
+}
+
+// parse checks the mapping, and parses the VLAN ranges of its domains.
+func (m L2DomainMapping) parse() error {
+	names := make(map[string]bool)
+	var mapped [][2]int
+	for i, d := range m {
+		if d.Name = strings.TrimSpace(d.Name); d.Name == "" {
+			return fmt.Errorf("L2 domain %d has no name", i+1)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("L2 domain %s listed more than once", d.Name)
+		}
+		names[d.Name] = true
+		d.ranges = nil
+		for _, v := range d.VLANs {
+			r, err := parseVLANRange(v)
+			if err != nil {
+				return fmt.Errorf("invalid VLANs in L2 domain %s: %s", d.Name, err)
+			}
+			for _, o := range mapped {
+				if r[0] <= o[1] && o[0] <= r[1] {
+					return fmt.Errorf("VLANs %s of L2 domain %s are already mapped", v, d.Name)
+				}
+			}
+			mapped = append(mapped, r)
+			d.ranges = append(d.ranges, r)
+		}
+		m[i] = d
+	}
+	return nil
+}
+
+// parseVLANRange parses a VLAN number, or a range of VLAN numbers.
+func parseVLANRange(s string) ([2]int, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) > 2 {
+		return [2]int{}, fmt.Errorf("invalid VLAN range %q", s)
+	}
+	var r [2]int
+	for i := range r {
+		n, err := strconv.Atoi(strings.TrimSpace(parts[i%len(parts)]))
+		if err != nil || n < 1 || n > 4094 {
+			return [2]int{}, fmt.Errorf("invalid VLAN number in %q", s)
+		}
+		r[i] = n
+	}
+	if r[0] > r[1] {
+		return [2]int{}, fmt.Errorf("invalid VLAN range %q", s)
+	}
+	return r, nil
+}
+
+// Domain returns the name of the L2 domain that VLAN number n is mapped to,
+// or blank if it is not mapped.
+func (m L2DomainMapping) Domain(n int) string {
+	for _, d := range m {
+		for _, r := range d.ranges {
+			if r[0] <= n && n <= r[1] {
+				return d.Name
+			}
+		}
+	}
+	return ""
+}
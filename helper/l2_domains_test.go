@@ -0,0 +1,56 @@
+package helper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadL2DomainMapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l2domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "domains.yaml")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("- name: datacenter\n  description: Datacenter VLANs\n  sections: [2]\n  vlans: [100, 200-299]\n- name: office\n  vlans: ['300']\n")
+	m, err := LoadL2DomainMapping(path)
+	if err != nil {
+		t.Fatalf("Error loading L2 domain mapping: %s", err)
+	}
+	for n, expected := range map[int]string{100: "datacenter", 200: "datacenter", 250: "datacenter", 299: "datacenter", 300: "office", 101: "", 400: ""} {
+		if actual := m.Domain(n); actual != expected {
+			t.Fatalf("Expected VLAN %d in domain %q, got %q", n, expected, actual)
+		}
+	}
+	if m[0].Description != "Datacenter VLANs" || len(m[0].Sections) != 1 || m[0].Sections[0] != 2 {
+		t.Fatalf("Unexpected domain %#v", m[0])
+	}
+
+	for _, v := range []string{
+		"- name: ''\n  vlans: [100]\n",
+		"- name: a\n- name: a\n",
+		"- name: a\n  vlans: [0]\n",
+		"- name: a\n  vlans: [5000]\n",
+		"- name: a\n  vlans: [200-100]\n",
+		"- name: a\n  vlans: [1-2-3]\n",
+		"- name: a\n  vlans: [100-199]\n- name: b\n  vlans: [150]\n",
+		"- name: a\n  vlans: [100, 100]\n",
+		"- name: a\n  vlan: [100]\n",
+	} {
+		write(v)
+		if _, err := LoadL2DomainMapping(path); err == nil {
+			t.Fatalf("Expected error loading %q, got none", v)
+		}
+	}
+	if _, err := LoadL2DomainMapping(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Fatal("Expected error loading missing file, got none")
+	}
+}
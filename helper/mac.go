@@ -0,0 +1,28 @@
+package helper
+
+import (
+	"net"
+	"strings"
+)
+
+// NormalizeMAC returns the MAC address s as six colon-separated pairs of
+// lowercase hex digits (ie: 00:1a:2b:3c:4d:5e), and reports whether or not s
+// could be parsed as a MAC address. Besides the formats accepted by
+// net.ParseMAC (ie: 00-1A-2B-3C-4D-5E and 001a.2b3c.4d5e), 12 hex digits
+// with no separators are accepted, as found in some legacy databases. Only
+// 48-bit MAC addresses are accepted.
+func NormalizeMAC(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) == 12 && !strings.ContainsAny(s, ":-.") {
+		parts := make([]string, 6)
+		for i := range parts {
+			parts[i] = s[i*2 : i*2+2]
+		}
+		s = strings.Join(parts, ":")
+	}
+	hw, err := net.ParseMAC(s)
+	if err != nil || len(hw) != 6 {
+		return "", false
+	}
+	return hw.String(), true
+}
@@ -0,0 +1,22 @@
+package helper
+
+import "testing"
+
+func TestNormalizeMAC(t *testing.T) {
+	for in, expected := range map[string]string{
+		"00:1a:2b:3c:4d:5e":       "00:1a:2b:3c:4d:5e",
+		"00-1A-2B-3C-4D-5E":       "00:1a:2b:3c:4d:5e",
+		" 001a.2b3c.4d5e ":        "00:1a:2b:3c:4d:5e",
+		"001A2B3C4D5E":            "00:1a:2b:3c:4d:5e",
+		"0:1a:2b:3c:4d:5e":        "",
+		"00:1a:2b:3c:4d:5e:6":     "",
+		"02:00:00:00:00:00:00:01": "",
+		"001A2B3C4D5G":            "",
+		"unknown":                 "",
+	} {
+		actual, ok := NormalizeMAC(in)
+		if ok != (expected != "") || actual != expected {
+			t.Fatalf("Expected %q for MAC address %q, got %q (%t)", expected, in, actual, ok)
+		}
+	}
+}
@@ -15,8 +15,11 @@ import (
 // We decrement our subnet mask until we get to 8, which is the largets block
 // allocation allowed by the IANA (aka a Class A).
 //
+// Only subnets in the section with ID sectionID are considered, unless it is
+// 0, in which case the first subnet found in any section is used.
+//
 // 0 is returned if no subnet is found.
-func ParentSubnetIDForCIDR(session *session.Session, addr string, mask int) int {
+func ParentSubnetIDForCIDR(session *session.Session, sectionID int, addr string, mask int) (int, error) {
 	logrus.Debugf("Looking for parent subnet for CIDR %s/%d", addr, mask)
 
 	c := subnets.NewController(session)
@@ -27,20 +30,35 @@ func ParentSubnetIDForCIDR(session *session.Session, addr string, mask int) int
 	for n >= 8 {
 		_, net, err := net.ParseCIDR(fmt.Sprintf("%s/%d", addr, n))
 		if err != nil {
-			logrus.Fatalf("Error parsing subnet/CIDR %s/%d: %s", addr, mask, err)
+			return 0, fmt.Errorf("error parsing subnet/CIDR %s/%d: %s", addr, mask, err)
 		}
 		logrus.Debugf("Looking for subnet CIDR %s in new PHPIPAM database", net.String())
 		subnets, err := c.GetSubnetsByCIDR(net.String())
 		switch {
 		case err == nil:
-			logrus.Debugf("Parent found: subnet ID %d for CIDR %s in new PHPIPAM database", subnets[0].ID, net.String())
-			return subnets[0].ID
+			if id := SubnetIDInSection(subnets, sectionID); id != 0 {
+				logrus.Debugf("Parent found: subnet ID %d for CIDR %s in new PHPIPAM database", id, net.String())
+				return id, nil
+			}
+			logrus.Debugf("Subnet %s not found in section %d in PHPIPAM", net.String(), sectionID)
+			n--
 		case err.Error() == "Error from API (404): No subnets found":
 			logrus.Debugf("Subnet %s not found in PHPIPAM", net.String())
 			n--
-			continue
 		default:
-			logrus.Fatalf("Error searching for subnet: %s", err)
+			return 0, fmt.Errorf("error searching for subnet: %s", err)
+		}
+	}
+	return 0, nil
+}
+
+// SubnetIDInSection returns the ID of the first subnet in nets that is in the
+// section with ID sectionID, or the first subnet in nets if sectionID is 0.
+// 0 is returned if there is no such subnet.
+func SubnetIDInSection(nets []subnets.Subnet, sectionID int) int {
+	for _, v := range nets {
+		if sectionID == 0 || v.SectionID == sectionID {
+			return v.ID
 		}
 	}
 	return 0
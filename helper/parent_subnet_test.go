@@ -4,6 +4,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamtest"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
 	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
 	"github.com/paybyphone/phpipam-sdk-go/testacc"
 	"github.com/sirupsen/logrus"
@@ -15,13 +17,58 @@ func TestParentSubnetIDForCIDR(t *testing.T) {
 	sess := session.NewSession()
 
 	expected := 2
-	actual := ParentSubnetIDForCIDR(sess, "10.10.2.0", 24)
+	actual, err := ParentSubnetIDForCIDR(sess, 0, "10.10.2.0", 24)
+	if err != nil {
+		t.Fatalf("Error finding parent subnet: %s", err)
+	}
 
 	if expected != actual {
 		t.Fatalf("Expected master subnet ID to be %d, got %d", expected, actual)
 	}
 }
 
+// TestParentSubnetIDForCIDRFake finds the parent subnet ID for 10.10.2.0/24 in
+// each section of a fake PHPIPAM instance.
+func TestParentSubnetIDForCIDRFake(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	wide := ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8, SectionID: 1})
+	narrow := ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.10.0.0", Mask: 16, SectionID: 1})
+	other := ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8, SectionID: 2})
+
+	cases := map[int]int{0: narrow, 1: narrow, 2: other, 3: 0}
+	for sectionID, expected := range cases {
+		actual, err := ParentSubnetIDForCIDR(ts.Session(), sectionID, "10.10.2.0", 24)
+		if err != nil {
+			t.Fatalf("Error finding parent subnet: %s", err)
+		}
+		if expected != actual {
+			t.Fatalf("Expected master subnet ID for section %d to be %d, got %d", sectionID, expected, actual)
+		}
+	}
+
+	actual, err := ParentSubnetIDForCIDR(ts.Session(), 1, "10.10.0.0", 16)
+	if err != nil {
+		t.Fatalf("Error finding parent subnet: %s", err)
+	}
+	if actual != wide {
+		t.Fatalf("Expected master subnet ID to be %d, got %d", wide, actual)
+	}
+}
+
+func TestSubnetIDInSection(t *testing.T) {
+	nets := []subnets.Subnet{
+		{ID: 3, SectionID: 1},
+		{ID: 4, SectionID: 2},
+	}
+	cases := map[int]int{0: 3, 1: 3, 2: 4, 5: 0}
+	for sectionID, expected := range cases {
+		if actual := SubnetIDInSection(nets, sectionID); expected != actual {
+			t.Fatalf("Expected subnet ID %d for section %d, got %d", expected, sectionID, actual)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	logrus.SetLevel(logrus.DebugLevel)
 	os.Exit(m.Run())
@@ -0,0 +1,125 @@
+package helper
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+)
+
+// cryptAlphabet is the alphabet of the salts and hashes of crypt(3).
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptRounds is the default number of rounds of SHA-512 crypt, which is used
+// when the hash does not specify any.
+const cryptRounds = 5000
+
+// HashPassword returns a SHA-512 crypt(3) hash ($6$) of password with a
+// random salt, in the format that PHPIPAM stores passwords in and checks them
+// against with PHP's crypt().
+func HashPassword(password string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i, v := range b {
+		b[i] = cryptAlphabet[int(v)%len(cryptAlphabet)]
+	}
+	return CryptSHA512(password, string(b)), nil
+}
+
+// CryptSHA512 returns the SHA-512 crypt(3) hash of password with salt, which
+// is truncated to 16 characters, using the default number of rounds. This
+// follows Ulrich Drepper's specification of the algorithm.
+func CryptSHA512(password, salt string) string {
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	p, s := []byte(password), []byte(salt)
+
+	alt := sha512.New()
+	alt.Write(p)
+	alt.Write(s)
+	alt.Write(p)
+	altSum := alt.Sum(nil)
+
+	a := sha512.New()
+	a.Write(p)
+	a.Write(s)
+	n := len(p)
+	for ; n > sha512.Size; n -= sha512.Size {
+		a.Write(altSum)
+	}
+	a.Write(altSum[:n])
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(altSum)
+		} else {
+			a.Write(p)
+		}
+	}
+	sum := a.Sum(nil)
+
+	dp := sha512.New()
+	for range p {
+		dp.Write(p)
+	}
+	pBytes := repeatTo(dp.Sum(nil), len(p))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		ds.Write(s)
+	}
+	sBytes := repeatTo(ds.Sum(nil), len(s))
+
+	for i := 0; i < cryptRounds; i++ {
+		h := sha512.New()
+		if i&1 != 0 {
+			h.Write(pBytes)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(sBytes)
+		}
+		if i%7 != 0 {
+			h.Write(pBytes)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(pBytes)
+		}
+		sum = h.Sum(nil)
+	}
+
+	out := []byte("$6$" + salt + "$")
+	for _, v := range cryptOrder {
+		out = appendCrypt64(out, uint(sum[v[0]])<<16|uint(sum[v[1]])<<8|uint(sum[v[2]]), 4)
+	}
+	return string(appendCrypt64(out, uint(sum[63]), 2))
+}
+
+// cryptOrder is the order in which the bytes of the final SHA-512 crypt sum
+// are encoded, in groups of three, before the last byte.
+var cryptOrder = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+	{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+	{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+}
+
+// repeatTo returns sum repeated to n bytes.
+func repeatTo(sum []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(sum) <= n {
+		out = append(out, sum...)
+	}
+	return append(out, sum[:n-len(out)]...)
+}
+
+// appendCrypt64 appends n characters encoding the low bits of w to out.
+func appendCrypt64(out []byte, w uint, n int) []byte {
+	for ; n > 0; n-- {
+		out = append(out, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+	return out
+}
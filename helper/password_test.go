@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCryptSHA512(t *testing.T) {
+	for _, v := range []struct {
+		password, salt, expected string
+	}{
+		{"Hello world!", "saltstring", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"", "abcdefgh", "$6$abcdefgh$v7sYNA18/BerGOYQLppYLyjH4yJilp8kqe/ef3KYMK9hOIdzH1yzcmP74Ay.m51y1jP3QqxM7Jl75S4CxDhBq."},
+		{strings.Repeat("a", 200), "0123456789abcdefXYZ", "$6$0123456789abcdef$RBjxx9VcHjdfmVLoRNP7x08zbAVFOXyFy3mlyE0sWhfPSO/dqE70ouCpiWlbXYaHeO2LT4lgRz1jtlKJM0gSv/"},
+	} {
+		if actual := CryptSHA512(v.password, v.salt); actual != v.expected {
+			t.Fatalf("Expected hash %s of %q, got %s", v.expected, v.password, actual)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("Error hashing password: %s", err)
+	}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[1] != "6" || len(parts[2]) != 16 {
+		t.Fatalf("Unexpected hash %s", hash)
+	}
+	if again := CryptSHA512("secret", parts[2]); again != hash {
+		t.Fatalf("Expected hash %s to check against its salt, got %s", hash, again)
+	}
+	if other, _ := HashPassword("secret"); other == hash {
+		t.Fatal("Expected hashes of the same password to be salted differently")
+	}
+}
@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SectionMapping maps a section in the legacy DB to the section in the new
+// PHPIPAM instance that its subnets and addresses are migrated to.
+type SectionMapping struct {
+	// The ID of the section in the legacy DB.
+	LegacyID int
+
+	// The ID of the section in the new PHPIPAM instance.
+	ID int
+}
+
+// String implements fmt.Stringer for SectionMapping.
+func (m SectionMapping) String() string {
+	return fmt.Sprintf("%d:%d", m.LegacyID, m.ID)
+}
+
+// ParseSectionMappings parses a comma-separated list of LEGACY:NEW section ID
+// pairs (ie: 1:3,2:4). Each legacy section can only be mapped once.
+func ParseSectionMappings(s string) ([]SectionMapping, error) {
+	var out []SectionMapping
+	seen := make(map[int]bool)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid section mapping %q: must be in LEGACY:NEW format", v)
+		}
+		legacyID, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || legacyID < 1 {
+			return nil, fmt.Errorf("invalid legacy section ID in mapping %q", v)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid section ID in mapping %q", v)
+		}
+		if seen[legacyID] {
+			return nil, fmt.Errorf("legacy section %d mapped more than once", legacyID)
+		}
+		seen[legacyID] = true
+		out = append(out, SectionMapping{LegacyID: legacyID, ID: id})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no section mappings supplied")
+	}
+	return out, nil
+}
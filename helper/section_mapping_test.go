@@ -0,0 +1,26 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSectionMappings(t *testing.T) {
+	actual, err := ParseSectionMappings("1:3, 2:4")
+	if err != nil {
+		t.Fatalf("Error parsing section mappings: %s", err)
+	}
+	expected := []SectionMapping{
+		{LegacyID: 1, ID: 3},
+		{LegacyID: 2, ID: 4},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+
+	for _, v := range []string{"", "1", "1:a", "0:1", "1:3,1:4", "1:2:3"} {
+		if _, err := ParseSectionMappings(v); err == nil {
+			t.Fatalf("Expected error parsing %q, got none", v)
+		}
+	}
+}
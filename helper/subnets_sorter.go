@@ -1,7 +1,9 @@
 package helper
 
 import (
+	"bytes"
 	"fmt"
+	"net"
 
 	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
 )
@@ -21,8 +23,31 @@ func (s SubnetsSorter) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
-// Less implements sort.Interface.Less for SubnetsSorter. The IP/mask is
-// converted to string form and then sorted lexicograpically.
+// Less implements sort.Interface.Less for SubnetsSorter, by way of
+// SubnetLess.
 func (s SubnetsSorter) Less(i, j int) bool {
-	return fmt.Sprintf("%s/%d", s[i].SubnetAddress, s[i].Mask) < fmt.Sprintf("%s/%d", s[j].SubnetAddress, s[j].Mask)
+	return SubnetLess(s[i], s[j])
+}
+
+// SubnetLess reports whether subnet a sorts before subnet b. Subnets are sorted
+// numerically by network address, and then by mask, shortest first. As a
+// parent's network address is never greater than its children's, and shares
+// the network address of a child only when its mask is shorter, parents
+// always sort before their children.
+//
+// Subnets with unparseable addresses sort last, in lexicographic order.
+func SubnetLess(a, b subnets.Subnet) bool {
+	ipA, ipB := net.ParseIP(a.SubnetAddress), net.ParseIP(b.SubnetAddress)
+	switch {
+	case ipA == nil && ipB == nil:
+		return fmt.Sprintf("%s/%d", a.SubnetAddress, a.Mask) < fmt.Sprintf("%s/%d", b.SubnetAddress, b.Mask)
+	case ipA == nil:
+		return false
+	case ipB == nil:
+		return true
+	}
+	if c := bytes.Compare(ipA.To16(), ipB.To16()); c != 0 {
+		return c < 0
+	}
+	return a.Mask < b.Mask
 }
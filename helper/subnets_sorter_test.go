@@ -69,3 +69,28 @@ func TestSubnetsSorter(t *testing.T) {
 		t.Fatalf("Expected %s, got %s", spew.Sdump(expected), spew.Sdump(actual))
 	}
 }
+
+func TestSubnetLessNumeric(t *testing.T) {
+	in := SubnetsSorter{
+		{SubnetAddress: "10.10.0.0", Mask: 16},
+		{SubnetAddress: "10.2.0.0", Mask: 24},
+		{SubnetAddress: "bogus", Mask: 24},
+		{SubnetAddress: "10.2.0.0", Mask: 16},
+		{SubnetAddress: "10.0.0.0", Mask: 8},
+		{SubnetAddress: "9.0.0.0", Mask: 8},
+	}
+	expected := SubnetsSorter{
+		{SubnetAddress: "9.0.0.0", Mask: 8},
+		{SubnetAddress: "10.0.0.0", Mask: 8},
+		{SubnetAddress: "10.2.0.0", Mask: 16},
+		{SubnetAddress: "10.2.0.0", Mask: 24},
+		{SubnetAddress: "10.10.0.0", Mask: 16},
+		{SubnetAddress: "bogus", Mask: 24},
+	}
+
+	actual := in
+	sort.Sort(actual)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %s, got %s", spew.Sdump(expected), spew.Sdump(actual))
+	}
+}
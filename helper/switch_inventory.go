@@ -0,0 +1,122 @@
+package helper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SwitchDevice represents a device derived from the free-text switch field
+// found on legacy addresses, or read from the legacy device table.
+type SwitchDevice struct {
+	// The device hostname, as first seen in the legacy data.
+	Hostname string
+
+	// The device's IP address, description, and type name in the legacy
+	// device table, if the device is in one.
+	IPAddress   string
+	LegacyNotes string
+	Type        string
+
+	// Whether the device is in the legacy device table.
+	InTable bool
+
+	// The number of legacy addresses that reference this switch.
+	AddressCount int
+
+	// The CIDRs of the subnets that the referencing addresses belong to, in
+	// the order they were first seen.
+	Subnets []string
+}
+
+// Description returns a device description noting where the device came
+// from, and the addresses and subnets that referenced it. The description from
+// the legacy device table comes first, if there is one.
+func (d SwitchDevice) Description() string {
+	origin := "Migrated from legacy switch field"
+	if d.InTable {
+		origin = "Migrated from legacy device table"
+	}
+	if d.AddressCount == 0 {
+		origin += " - no addresses"
+	} else {
+		origin += fmt.Sprintf(" - %d address(es) in %s", d.AddressCount, strings.Join(d.Subnets, ", "))
+	}
+	if d.LegacyNotes != "" {
+		return fmt.Sprintf("%s (%s)", d.LegacyNotes, origin)
+	}
+	return origin
+}
+
+// SwitchInventory collects the distinct switch names found on legacy
+// addresses. Names are trimmed of whitespace and compared case-insensitively,
+// so that minor variations in the free-text field do not produce duplicate
+// devices.
+type SwitchInventory map[string]*SwitchDevice
+
+// SwitchKey returns the normalized form of a switch name, used to compare
+// switch names.
+func SwitchKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Add records an address referencing switch name in the subnet with the
+// supplied CIDR. Blank names are ignored.
+func (inv SwitchInventory) Add(name, cidr string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	key := SwitchKey(name)
+	d, ok := inv[key]
+	if !ok {
+		d = &SwitchDevice{Hostname: name}
+		inv[key] = d
+	}
+	d.AddressCount++
+	for _, v := range d.Subnets {
+		if v == cidr {
+			return
+		}
+	}
+	d.Subnets = append(d.Subnets, cidr)
+}
+
+// AddDevice records a device from the legacy device table, with its IP
+// address, description, and type name. Devices are recorded even if no address
+// references them. Blank hostnames are ignored.
+func (inv SwitchInventory) AddDevice(hostname, ipAddress, description, deviceType string) {
+	hostname = strings.TrimSpace(hostname)
+	if hostname == "" {
+		return
+	}
+	key := SwitchKey(hostname)
+	d, ok := inv[key]
+	if !ok {
+		d = &SwitchDevice{Hostname: hostname}
+		inv[key] = d
+	}
+	d.IPAddress = ipAddress
+	d.LegacyNotes = description
+	d.Type = deviceType
+	d.InTable = true
+}
+
+// Lookup returns the device for switch name, if it exists.
+func (inv SwitchInventory) Lookup(name string) (SwitchDevice, bool) {
+	d, ok := inv[SwitchKey(name)]
+	if !ok {
+		return SwitchDevice{}, false
+	}
+	return *d, true
+}
+
+// Devices returns all of the devices in the inventory, sorted by hostname.
+func (inv SwitchInventory) Devices() []SwitchDevice {
+	var out []SwitchDevice
+	for _, v := range inv {
+		out = append(out, *v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hostname < out[j].Hostname })
+	return out
+}
@@ -0,0 +1,67 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+func TestSwitchInventory(t *testing.T) {
+	inv := make(SwitchInventory)
+	inv.Add("sw-core-1", "10.10.1.0/24")
+	inv.Add("SW-CORE-1 ", "10.10.1.0/24")
+	inv.Add("sw-core-1", "10.10.2.0/24")
+	inv.Add("", "10.10.2.0/24")
+	inv.Add("sw-access-1", "10.10.3.0/24")
+
+	expected := []SwitchDevice{
+		{
+			Hostname:     "sw-access-1",
+			AddressCount: 1,
+			Subnets:      []string{"10.10.3.0/24"},
+		},
+		{
+			Hostname:     "sw-core-1",
+			AddressCount: 3,
+			Subnets:      []string{"10.10.1.0/24", "10.10.2.0/24"},
+		},
+	}
+	actual := inv.Devices()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %s, got %s", spew.Sdump(expected), spew.Sdump(actual))
+	}
+
+	if _, ok := inv.Lookup(" Sw-Core-1"); !ok {
+		t.Fatal("Expected to find sw-core-1 in inventory")
+	}
+}
+
+func TestSwitchInventoryAddDevice(t *testing.T) {
+	inv := make(SwitchInventory)
+	inv.Add("sw-core-1", "10.10.1.0/24")
+	inv.AddDevice("SW-CORE-1", "10.0.0.1", "core switch", "Switch")
+	inv.AddDevice("sw-spare", "", "", "")
+	inv.AddDevice(" ", "10.0.0.9", "blank", "")
+
+	devs := inv.Devices()
+	if len(devs) != 2 {
+		t.Fatalf("Expected 2 devices, got %s", spew.Sdump(devs))
+	}
+	core, _ := inv.Lookup("sw-core-1")
+	if core.Hostname != "sw-core-1" || core.IPAddress != "10.0.0.1" || core.Type != "Switch" || !core.InTable {
+		t.Fatalf("Unexpected device %s", spew.Sdump(core))
+	}
+	for _, v := range []struct {
+		device   SwitchDevice
+		expected string
+	}{
+		{core, "core switch (Migrated from legacy device table - 1 address(es) in 10.10.1.0/24)"},
+		{devs[1], "Migrated from legacy device table - no addresses"},
+		{SwitchDevice{Hostname: "sw", AddressCount: 2, Subnets: []string{"10.0.0.0/8"}}, "Migrated from legacy switch field - 2 address(es) in 10.0.0.0/8"},
+	} {
+		if actual := v.device.Description(); v.expected != actual {
+			t.Fatalf("Expected description %q, got %q", v.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// TimeoutTransport is a http.RoundTripper that limits the total time of each
+// request, including reading the response body, to Timeout. It is used where
+// the http.Client cannot be configured directly, as is the case with the
+// PHPIPAM SDK.
+type TimeoutTransport struct {
+	// The underlying transport. http.DefaultTransport is used if this is nil.
+	Transport http.RoundTripper
+
+	// The maximum duration of each request.
+	Timeout time.Duration
+}
+
+// RoundTrip implements http.RoundTripper for TimeoutTransport.
+func (t *TimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.Timeout)
+	resp, err := rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline needs to cover reading the body, so only cancel the
+	// context once it has been closed.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels a context when the wrapped body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels the context.
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,38 @@
+package helper
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Transport: &TimeoutTransport{Timeout: 100 * time.Millisecond}}
+
+	resp, err := c.Get(ts.URL + "/fast")
+	if err != nil {
+		t.Fatalf("Error making fast request: %s", err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "ok" {
+		t.Fatalf("Expected body ok, got %q (error: %v)", b, err)
+	}
+
+	if _, err := c.Get(ts.URL + "/slow"); err == nil {
+		t.Fatal("Expected slow request to time out, got no error")
+	}
+}
@@ -0,0 +1,68 @@
+package helper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and its key to dir, and
+// returns the paths to both.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %s", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+
+	cfg, err := TLSConfig(certFile, certFile, keyFile, false)
+	if err != nil {
+		t.Fatalf("Error building TLS config: %s", err)
+	}
+	if cfg.RootCAs == nil {
+		t.Fatal("Expected RootCAs to be set")
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Fatalf("Expected 1 client certificate, got %d", len(cfg.Certificates))
+	}
+
+	if _, err := TLSConfig("", certFile, "", false); err == nil {
+		t.Fatal("Expected error with certificate but no key, got none")
+	}
+	if _, err := TLSConfig(keyFile, "", "", false); err == nil {
+		t.Fatal("Expected error with invalid CA file, got none")
+	}
+}
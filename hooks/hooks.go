@@ -0,0 +1,148 @@
+// Package hooks runs user-supplied functions on each VLAN, subnet, and address
+// read from the legacy DB before it is written to the new PHPIPAM instance.
+// Hooks can alter an object (ie: rewrite its description or set custom
+// fields), drop it from the migration, or fail the migration with an error.
+//
+// Programs embedding the migration logic register hooks on a Hooks directly.
+// The phpipam-legacy-migrator command loads them from Go plugins instead - see
+// LoadPlugin.
+package hooks
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+)
+
+// VLANFunc is a hook run on a VLAN. It returns false to drop the VLAN from the
+// migration.
+type VLANFunc func(v *legacydb.VLAN) (keep bool, err error)
+
+// SubnetFunc is a hook run on a subnet. It returns false to drop the subnet
+// from the migration.
+type SubnetFunc func(s *legacydb.Subnet) (keep bool, err error)
+
+// AddressFunc is a hook run on an address. It returns false to drop the
+// address from the migration.
+type AddressFunc func(a *legacydb.Address) (keep bool, err error)
+
+// Hooks is a set of registered hooks. Hooks are run in the order they were
+// registered. The zero value has no hooks and is ready to use.
+type Hooks struct {
+	vlans     []VLANFunc
+	subnets   []SubnetFunc
+	addresses []AddressFunc
+}
+
+// OnVLAN registers a hook to run on each VLAN.
+func (h *Hooks) OnVLAN(f VLANFunc) {
+	h.vlans = append(h.vlans, f)
+}
+
+// OnSubnet registers a hook to run on each subnet.
+func (h *Hooks) OnSubnet(f SubnetFunc) {
+	h.subnets = append(h.subnets, f)
+}
+
+// OnAddress registers a hook to run on each address.
+func (h *Hooks) OnAddress(f AddressFunc) {
+	h.addresses = append(h.addresses, f)
+}
+
+// Empty returns true if no hooks are registered.
+func (h *Hooks) Empty() bool {
+	return len(h.vlans) == 0 && len(h.subnets) == 0 && len(h.addresses) == 0
+}
+
+// VLANs runs the VLAN hooks on each of in, and returns the VLANs that were
+// kept, along with the number dropped. An object dropped by a hook is not
+// passed to the hooks after it.
+func (h *Hooks) VLANs(in []legacydb.VLAN) (out []legacydb.VLAN, dropped int, err error) {
+	for _, v := range in {
+		keep := true
+		for _, f := range h.vlans {
+			if keep, err = f(&v); err != nil {
+				return nil, 0, fmt.Errorf("hook failed on VLAN number %d: %s", v.Number, err)
+			}
+			if !keep {
+				break
+			}
+		}
+		if !keep {
+			dropped++
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, dropped, nil
+}
+
+// Subnets runs the subnet hooks on each of in, and returns the subnets that
+// were kept, along with the number dropped.
+func (h *Hooks) Subnets(in []legacydb.Subnet) (out []legacydb.Subnet, dropped int, err error) {
+	for _, v := range in {
+		keep := true
+		for _, f := range h.subnets {
+			if keep, err = f(&v); err != nil {
+				return nil, 0, fmt.Errorf("hook failed on subnet %s/%d: %s", v.SubnetAddress, v.Mask, err)
+			}
+			if !keep {
+				break
+			}
+		}
+		if !keep {
+			dropped++
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, dropped, nil
+}
+
+// Addresses runs the address hooks on each of in, and returns the addresses
+// that were kept, along with the number dropped.
+func (h *Hooks) Addresses(in []legacydb.Address) (out []legacydb.Address, dropped int, err error) {
+	for _, v := range in {
+		keep := true
+		for _, f := range h.addresses {
+			if keep, err = f(&v); err != nil {
+				return nil, 0, fmt.Errorf("hook failed on IP address %s: %s", v.IPAddress, err)
+			}
+			if !keep {
+				break
+			}
+		}
+		if !keep {
+			dropped++
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, dropped, nil
+}
+
+// RegisterSymbol is the name of the function that LoadPlugin calls to register
+// a plugin's hooks.
+const RegisterSymbol = "RegisterHooks"
+
+// LoadPlugin opens the Go plugin at path and calls its RegisterHooks function,
+// which must have the signature func(*hooks.Hooks), to register its hooks on
+// h. The plugin must be built with the same version of Go and of this package
+// as the program loading it.
+func LoadPlugin(path string, h *Hooks) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening hook plugin %s: %s", path, err)
+	}
+	sym, err := p.Lookup(RegisterSymbol)
+	if err != nil {
+		return fmt.Errorf("error loading hook plugin %s: %s", path, err)
+	}
+	register, ok := sym.(func(*Hooks))
+	if !ok {
+		return fmt.Errorf("error loading hook plugin %s: %s has type %T, not func(*hooks.Hooks)", path, RegisterSymbol, sym)
+	}
+	register(h)
+	return nil
+}
@@ -0,0 +1,66 @@
+package hooks
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+)
+
+func TestAddresses(t *testing.T) {
+	var h Hooks
+	if !h.Empty() {
+		t.Fatal("Expected zero Hooks to be empty")
+	}
+	h.OnAddress(func(a *legacydb.Address) (bool, error) {
+		return !strings.HasPrefix(a.Description, "DELETE"), nil
+	})
+	h.OnAddress(func(a *legacydb.Address) (bool, error) {
+		a.Description = strings.ToUpper(a.Description)
+		a.CustomFields = map[string]string{"custom_owner": "netops"}
+		return true, nil
+	})
+
+	in := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Description: "gateway"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Description: "DELETE me"}},
+	}
+	actual, dropped, err := h.Addresses(in)
+	if err != nil {
+		t.Fatalf("Error running hooks: %s", err)
+	}
+	expected := []legacydb.Address{
+		{
+			Address:      addresses.Address{IPAddress: "10.0.0.1", Description: "GATEWAY"},
+			CustomFields: map[string]string{"custom_owner": "netops"},
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if dropped != 1 {
+		t.Fatalf("Expected 1 dropped address, got %d", dropped)
+	}
+}
+
+func TestSubnetsError(t *testing.T) {
+	var h Hooks
+	h.OnSubnet(func(s *legacydb.Subnet) (bool, error) {
+		return false, errors.New("boom")
+	})
+	_, _, err := h.Subnets([]legacydb.Subnet{{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8}}})
+	expected := "hook failed on subnet 10.0.0.0/8: boom"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected error %q, got %v", expected, err)
+	}
+}
+
+func TestLoadPluginMissing(t *testing.T) {
+	if err := LoadPlugin("/nonexistent/hooks.so", &Hooks{}); err == nil {
+		t.Fatal("Expected error loading missing plugin, got none")
+	}
+}
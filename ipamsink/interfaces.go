@@ -0,0 +1,193 @@
+package ipamsink
+
+import (
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// The interfaces below are the parts of Sink that the migration depends on,
+// so that code writing to PHPIPAM can be tested with mocks instead of an API.
+
+// VLANCreator creates VLANs.
+type VLANCreator interface {
+	CreateVLAN(v vlans.VLAN, fields map[string]string) error
+}
+
+// SubnetCreator creates subnets.
+type SubnetCreator interface {
+	CreateSubnet(v subnets.Subnet, fields map[string]string) error
+}
+
+// AddressCreator creates IP addresses.
+type AddressCreator interface {
+	CreateAddress(a addresses.Address, fields map[string]string) error
+}
+
+// SubnetFinder finds the IDs of existing subnets.
+type SubnetFinder interface {
+	SubnetID(sectionID int, cidr string) (int, error)
+	SubnetIDs(sectionID int) (map[string]int, error)
+}
+
+// DeviceCreator creates devices and device types, and lists them to find the
+// IDs of the created ones.
+type DeviceCreator interface {
+	CreateDevice(d devices.Device) error
+	Devices() ([]devices.Device, error)
+	CreateDeviceType(t devices.DeviceType) error
+	DeviceTypes() ([]devices.DeviceType, error)
+}
+
+// VRFCreator creates VRFs, and lists them to find the IDs of the created
+// VRFs.
+type VRFCreator interface {
+	CreateVRF(v vrfs.VRF) error
+	VRFs() ([]vrfs.VRF, error)
+}
+
+// NameserverCreator creates nameserver sets, and lists them to find the IDs
+// of the created ones. It is not part of Target, as not every IPAM has
+// nameserver sets.
+type NameserverCreator interface {
+	CreateNameserver(n nameservers.Nameserver) error
+	Nameservers() ([]nameservers.Nameserver, error)
+}
+
+// L2DomainCreator creates L2 domains, and lists them to find the IDs of the
+// created ones. It is not part of Target, as not every IPAM has L2 domains.
+type L2DomainCreator interface {
+	CreateL2Domain(d l2domains.Domain) error
+	L2Domains() ([]l2domains.Domain, error)
+}
+
+// SectionCreator creates sections, and lists them to find sections by name.
+// It is not part of Target, as not every IPAM has sections, and a SQL script
+// cannot look them up by name.
+type SectionCreator interface {
+	CreateSection(v sections.Section) error
+	Sections() ([]sections.Section, error)
+}
+
+// SectionUpdater updates the master sections of existing sections, so that
+// the legacy section hierarchy can be recreated. It is not part of Target,
+// for the same reasons as SectionCreator.
+type SectionUpdater interface {
+	UpdateSection(v sections.Section) error
+}
+
+// RequestCreator creates IP requests. It is not part of Target, as the API
+// cannot create requests, so only the sinks that write to the database (or an
+// export) implement it.
+type RequestCreator interface {
+	CreateRequest(r requests.Request) error
+}
+
+// UserCreator creates users and groups, and lists them to find the IDs of the
+// created groups and the users that already exist. Like RequestCreator, it is
+// not part of Target, as the API cannot create users or groups.
+type UserCreator interface {
+	CreateGroup(g users.Group) error
+	Groups() ([]users.Group, error)
+	CreateUser(u users.User) error
+	Users() ([]users.User, error)
+}
+
+// ChangelogCreator creates changelog entries, looking up the IDs of the
+// changed addresses and of the users that made the changes by themselves.
+// Like RequestCreator, it is not part of Target, as the API cannot create
+// changelog entries.
+type ChangelogCreator interface {
+	CreateChange(e changelog.Entry) error
+}
+
+// CustomFieldLister lists the custom fields of the tables of the new PHPIPAM
+// instance (ie: ipaddresses), so that the custom fields that values are
+// written to can be checked to exist.
+type CustomFieldLister interface {
+	CustomFields(table string) ([]string, error)
+}
+
+// CustomFieldCreator lists the custom fields of the tables of the new PHPIPAM
+// instance, and creates missing ones, so that custom field values can be
+// written to them. It is not part of Target, as only the sinks that write to
+// the database can change its schema: the API cannot create custom fields.
+type CustomFieldCreator interface {
+	CustomFieldLister
+	CreateCustomField(table, name string) error
+}
+
+// VLANFinder finds the IDs of existing VLANs.
+type VLANFinder interface {
+	VLANID(n int) (int, error)
+	VLANIDs() (map[int]int, error)
+}
+
+// VLANUpdater lists and updates existing VLANs, so that legacy VLANs that are
+// already in the new PHPIPAM instance can be skipped or merged into them
+// instead of being created again. It is not part of Target, as sinks that
+// cannot read the new instance (ie: dbsink.Script) do without it.
+type VLANUpdater interface {
+	VLANs() ([]vlans.VLAN, error)
+	UpdateVLAN(v vlans.VLAN) error
+}
+
+// SubnetUpdater lists and updates the existing subnets of a section, so that
+// legacy subnets that are already in the new PHPIPAM instance can be skipped
+// or merged into them instead of being created again. As with VLANUpdater, it
+// is not part of Target.
+type SubnetUpdater interface {
+	Subnets(sectionID int) ([]subnets.Subnet, error)
+	UpdateSubnet(v subnets.Subnet) error
+}
+
+// AddressUpdater finds and updates existing IP addresses, so that addresses
+// that are already in their subnet in the new PHPIPAM instance can be updated
+// instead of failing to be created. AddressID returns 0 if the address is not
+// found. As with VLANUpdater, it is not part of Target.
+type AddressUpdater interface {
+	AddressID(subnetID int, ip string) (int, error)
+	UpdateAddress(a addresses.Address) error
+}
+
+// TargetReader lists the existing sections, VLANs, subnets, and IP addresses
+// of the new PHPIPAM instance, so that they can be backed up before migrating.
+// As with VLANUpdater, it is not part of Target.
+type TargetReader interface {
+	Sections() ([]sections.Section, error)
+	VLANs() ([]vlans.VLAN, error)
+	Subnets(sectionID int) ([]subnets.Subnet, error)
+	Addresses(subnetID int) ([]addresses.Address, error)
+}
+
+// AddressVerifier verifies migrated IP addresses.
+type AddressVerifier interface {
+	VerifyAddresses(addrs []addresses.Address) ([]verify.Mismatch, error)
+}
+
+// Target is everything that the migration writes to and reads from the new
+// PHPIPAM instance, so that it can be written to by other means than the API
+// (ie: dbsink.Sink).
+type Target interface {
+	VLANCreator
+	SubnetCreator
+	AddressCreator
+	SubnetFinder
+	DeviceCreator
+	VRFCreator
+	VLANFinder
+	AddressVerifier
+}
+
+// Sink implements all of the interfaces.
+var _ Target = (*Sink)(nil)
+var _ TargetReader = (*Sink)(nil)
@@ -0,0 +1,600 @@
+// Package ipamsink writes migrated objects into a new PHPIPAM instance via the
+// API, and looks up the IDs of the objects already in it.
+//
+// Every API call is retried as per the sink's retry policy, and all errors are
+// returned to the caller, which decides whether or not a failure should abort
+// the migration.
+package ipamsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// Sink writes objects into a new PHPIPAM instance.
+type Sink struct {
+	// The session for the new PHPIPAM instance.
+	Session *session.Session
+
+	// The policy used to retry API calls that fail with transient errors.
+	Retry retry.Policy
+}
+
+// New returns a new Sink for the PHPIPAM session, retrying API calls as per
+// policy.
+func New(sess *session.Session, policy retry.Policy) *Sink {
+	return &Sink{
+		Session: sess,
+		Retry:   policy,
+	}
+}
+
+// isNotFound returns true if err is a 404 error from the PHPIPAM API, which
+// the API returns for empty lists.
+func isNotFound(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "Error from API (404)")
+}
+
+// withCustomFields returns the request body to create in with the supplied
+// custom fields, which the API takes as extra top-level keys alongside the
+// object's own fields. in is returned as-is if there are no custom fields.
+func withCustomFields(in interface{}, fields map[string]string) (interface{}, error) {
+	if len(fields) == 0 {
+		return in, nil
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{})
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// create POSTs in, with the supplied custom fields, to the controller at
+// path. op describes the operation in log and error messages. exists checks
+// whether the object was created before the create is retried (see
+// retry.Policy.DoCreate).
+func (s *Sink) create(op, path string, in interface{}, fields map[string]string, exists func() (bool, error)) error {
+	body, err := withCustomFields(in, fields)
+	if err != nil {
+		return fmt.Errorf("error %s: %s", op, err)
+	}
+	c := client.NewClient(s.Session)
+	err = s.Retry.DoCreate(op, func() error {
+		var message string
+		return c.SendRequest("POST", path, body, &message)
+	}, exists)
+	if err != nil {
+		return fmt.Errorf("error %s: %s", op, err)
+	}
+	return nil
+}
+
+// found returns the result of a check for an existing object, treating the
+// 404 response that the API returns for empty lists as not found.
+func found(ok bool, err error) (bool, error) {
+	if isNotFound(err) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// vlanDomainID returns the ID of the L2 domain of a VLAN, which is the default
+// L2 domain (ID 1) if none is set.
+func vlanDomainID(v vlans.VLAN) int {
+	if v.DomainID == 0 {
+		return 1
+	}
+	return v.DomainID
+}
+
+// CreateVLAN creates a VLAN, setting the supplied custom fields, if any. As
+// VLAN numbers can repeat, a VLAN is only taken to have been created by a
+// failed attempt if one with the same number, name, and L2 domain exists.
+func (s *Sink) CreateVLAN(v vlans.VLAN, fields map[string]string) error {
+	return s.create(fmt.Sprintf("adding VLAN number %d", v.Number), "/vlans/", &v, fields, func() (bool, error) {
+		existing, err := vlans.NewController(s.Session).GetVLANsByNumber(v.Number)
+		for _, e := range existing {
+			if e.Name == v.Name && vlanDomainID(e) == vlanDomainID(v) {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+}
+
+// CreateSubnet creates a subnet, setting the supplied custom fields, if any.
+// The subnet is nested under the narrowest existing subnet in its section that
+// contains it, so subnets should be created in the order of
+// helper.SubnetsSorter so that parents exist before their children.
+func (s *Sink) CreateSubnet(v subnets.Subnet, fields map[string]string) error {
+	var id int
+	err := s.Retry.Do(fmt.Sprintf("finding parent of subnet %s/%d", v.SubnetAddress, v.Mask), func() (err error) {
+		id, err = helper.ParentSubnetIDForCIDR(s.Session, v.SectionID, v.SubnetAddress, v.Mask)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("error finding parent of subnet %s/%d: %s", v.SubnetAddress, v.Mask, err)
+	}
+	v.MasterSubnetID = id
+	cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+	return s.create("creating subnet "+cidr, "/subnets/", &v, fields, func() (bool, error) {
+		existing, err := subnets.NewController(s.Session).GetSubnetsByCIDR(cidr)
+		return found(helper.SubnetIDInSection(existing, v.SectionID) != 0, err)
+	})
+}
+
+// CreateDevice creates a device.
+func (s *Sink) CreateDevice(d devices.Device) error {
+	c := devices.NewController(s.Session)
+	err := s.Retry.DoCreate(fmt.Sprintf("adding device %s", d.Hostname), func() (err error) {
+		_, err = c.CreateDevice(d)
+		return
+	}, func() (bool, error) {
+		existing, err := c.ListDevices()
+		for _, v := range existing {
+			if v.Hostname == d.Hostname {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+	if err != nil {
+		return fmt.Errorf("error adding device %s: %s", d.Hostname, err)
+	}
+	return nil
+}
+
+// CreateDeviceType creates a device type.
+func (s *Sink) CreateDeviceType(t devices.DeviceType) error {
+	c := devices.NewController(s.Session)
+	err := s.Retry.DoCreate(fmt.Sprintf("adding device type %s", t.Name), func() (err error) {
+		_, err = c.CreateDeviceType(t)
+		return
+	}, func() (bool, error) {
+		existing, err := c.ListDeviceTypes()
+		for _, v := range existing {
+			if v.Name == t.Name {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+	if err != nil {
+		return fmt.Errorf("error adding device type %s: %s", t.Name, err)
+	}
+	return nil
+}
+
+// CreateVRF creates a VRF.
+func (s *Sink) CreateVRF(v vrfs.VRF) error {
+	c := vrfs.NewController(s.Session)
+	err := s.Retry.DoCreate(fmt.Sprintf("adding VRF %s", v.Name), func() (err error) {
+		_, err = c.CreateVRF(v)
+		return
+	}, func() (bool, error) {
+		existing, err := c.ListVRFs()
+		for _, e := range existing {
+			if e.Name == v.Name {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+	if err != nil {
+		return fmt.Errorf("error adding VRF %s: %s", v.Name, err)
+	}
+	return nil
+}
+
+// CreateNameserver creates a nameserver set.
+func (s *Sink) CreateNameserver(n nameservers.Nameserver) error {
+	c := nameservers.NewController(s.Session)
+	err := s.Retry.DoCreate(fmt.Sprintf("adding nameserver set %s", n.Name), func() (err error) {
+		_, err = c.CreateNameserver(n)
+		return
+	}, func() (bool, error) {
+		existing, err := c.ListNameservers()
+		for _, v := range existing {
+			if v.Name == n.Name {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+	if err != nil {
+		return fmt.Errorf("error adding nameserver set %s: %s", n.Name, err)
+	}
+	return nil
+}
+
+// CreateL2Domain creates an L2 domain.
+func (s *Sink) CreateL2Domain(d l2domains.Domain) error {
+	c := l2domains.NewController(s.Session)
+	err := s.Retry.DoCreate(fmt.Sprintf("adding L2 domain %s", d.Name), func() (err error) {
+		_, err = c.CreateDomain(d)
+		return
+	}, func() (bool, error) {
+		existing, err := c.ListDomains()
+		for _, v := range existing {
+			if v.Name == d.Name {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+	if err != nil {
+		return fmt.Errorf("error adding L2 domain %s: %s", d.Name, err)
+	}
+	return nil
+}
+
+// CreateSection creates a section.
+func (s *Sink) CreateSection(v sections.Section) error {
+	c := sections.NewController(s.Session)
+	err := s.Retry.DoCreate(fmt.Sprintf("adding section %s", v.Name), func() (err error) {
+		_, err = c.CreateSection(v)
+		return
+	}, func() (bool, error) {
+		existing, err := c.ListSections()
+		for _, e := range existing {
+			if e.Name == v.Name {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+	if err != nil {
+		return fmt.Errorf("error adding section %s: %s", v.Name, err)
+	}
+	return nil
+}
+
+// UpdateSection updates the master section of an existing section.
+func (s *Sink) UpdateSection(v sections.Section) error {
+	c := sections.NewController(s.Session)
+	in := sections.Section{ID: v.ID, MasterSection: v.MasterSection}
+	err := s.Retry.Do(fmt.Sprintf("updating section %d", v.ID), func() (err error) {
+		_, err = c.UpdateSection(in)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("error updating section %d: %s", v.ID, err)
+	}
+	return nil
+}
+
+// CreateAddress creates an IP address, setting the supplied custom fields, if
+// any.
+func (s *Sink) CreateAddress(a addresses.Address, fields map[string]string) error {
+	return s.create(fmt.Sprintf("adding IP address %s", a.IPAddress), "/addresses/", &a, fields, func() (bool, error) {
+		existing, err := addresses.NewController(s.Session).GetAddressesByIP(a.IPAddress)
+		for _, v := range existing {
+			if v.SubnetID == a.SubnetID {
+				return true, nil
+			}
+		}
+		return found(false, err)
+	})
+}
+
+// customFieldControllers maps the tables that custom fields can be listed for
+// to the API controllers of their objects.
+var customFieldControllers = map[string]string{
+	"vlans":       "vlans",
+	"subnets":     "subnets",
+	"ipaddresses": "addresses",
+}
+
+// CustomFields lists the names of the custom fields of table, which the API
+// returns for the controller of the table's objects.
+func (s *Sink) CustomFields(table string) ([]string, error) {
+	controller, ok := customFieldControllers[table]
+	if !ok {
+		return nil, fmt.Errorf("error listing custom fields: unsupported table %s", table)
+	}
+	c := client.NewClient(s.Session)
+	found := make(map[string]interface{})
+	err := s.Retry.Do(fmt.Sprintf("listing custom fields of %s", table), func() error {
+		return c.SendRequest("GET", fmt.Sprintf("/%s/custom_fields/", controller), &struct{}{}, &found)
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing custom fields of %s: %s", table, err)
+	}
+	var out []string
+	for k := range found {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Devices lists all of the devices. The API does not return the IDs of
+// created devices, so this is used to look them up.
+func (s *Sink) Devices() (out []devices.Device, err error) {
+	c := devices.NewController(s.Session)
+	err = s.Retry.Do("listing devices", func() (err error) {
+		out, err = c.ListDevices()
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing devices: %s", err)
+	}
+	return out, nil
+}
+
+// DeviceTypes lists all of the device types. As with devices, the API does
+// not return the IDs of created device types, so this is used to look them up.
+func (s *Sink) DeviceTypes() (out []devices.DeviceType, err error) {
+	c := devices.NewController(s.Session)
+	err = s.Retry.Do("listing device types", func() (err error) {
+		out, err = c.ListDeviceTypes()
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing device types: %s", err)
+	}
+	return out, nil
+}
+
+// VRFs lists all of the VRFs. As with devices, the API does not return the
+// IDs of created VRFs, so this is used to look them up.
+func (s *Sink) VRFs() (out []vrfs.VRF, err error) {
+	c := vrfs.NewController(s.Session)
+	err = s.Retry.Do("listing VRFs", func() (err error) {
+		out, err = c.ListVRFs()
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing VRFs: %s", err)
+	}
+	return out, nil
+}
+
+// Nameservers lists all of the nameserver sets. As with VRFs, the API does not
+// return the IDs of created nameserver sets, so this is used to look them up.
+func (s *Sink) Nameservers() (out []nameservers.Nameserver, err error) {
+	c := nameservers.NewController(s.Session)
+	err = s.Retry.Do("listing nameserver sets", func() (err error) {
+		out, err = c.ListNameservers()
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing nameserver sets: %s", err)
+	}
+	return out, nil
+}
+
+// L2Domains lists all of the L2 domains. As with VRFs, the API does not return
+// the IDs of created L2 domains, so this is used to look them up.
+func (s *Sink) L2Domains() (out []l2domains.Domain, err error) {
+	c := l2domains.NewController(s.Session)
+	err = s.Retry.Do("listing L2 domains", func() (err error) {
+		out, err = c.ListDomains()
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing L2 domains: %s", err)
+	}
+	return out, nil
+}
+
+// Sections lists all of the sections. As with L2 domains, the API does not
+// return the IDs of created sections, so this is used to look them up.
+func (s *Sink) Sections() (out []sections.Section, err error) {
+	c := sections.NewController(s.Session)
+	err = s.Retry.Do("listing sections", func() (err error) {
+		out, err = c.ListSections()
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing sections: %s", err)
+	}
+	return out, nil
+}
+
+// VLANID returns the ID of the VLAN with number n. If the number is used by
+// more than one VLAN, the first one found is used.
+func (s *Sink) VLANID(n int) (int, error) {
+	c := vlans.NewController(s.Session)
+	var found []vlans.VLAN
+	err := s.Retry.Do(fmt.Sprintf("looking up VLAN number %d", n), func() (err error) {
+		found, err = c.GetVLANsByNumber(n)
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(found) == 0 {
+		return 0, fmt.Errorf("VLAN number %d not found", n)
+	}
+	return found[0].ID, nil
+}
+
+// VLANs lists all of the VLANs.
+func (s *Sink) VLANs() (out []vlans.VLAN, err error) {
+	c := vlans.NewController(s.Session)
+	err = s.Retry.Do("listing VLANs", func() error {
+		return c.SendRequest("GET", "/vlans/", &struct{}{}, &out)
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing VLANs: %s", err)
+	}
+	return out, nil
+}
+
+// UpdateVLAN updates the name and description of an existing VLAN.
+func (s *Sink) UpdateVLAN(v vlans.VLAN) error {
+	c := vlans.NewController(s.Session)
+	in := vlans.VLAN{ID: v.ID, Name: v.Name, Description: v.Description}
+	err := s.Retry.Do(fmt.Sprintf("updating VLAN number %d", v.Number), func() (err error) {
+		_, err = c.UpdateVLAN(in)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("error updating VLAN number %d: %s", v.Number, err)
+	}
+	return nil
+}
+
+// VLANIDs lists all of the VLANs once, and returns a map of VLAN numbers to
+// IDs. If a number is used by more than one VLAN, the first one listed is
+// used, as with VLANID.
+func (s *Sink) VLANIDs() (map[int]int, error) {
+	found, err := s.VLANs()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[int]int)
+	for _, v := range found {
+		if _, ok := out[v.Number]; !ok {
+			out[v.Number] = v.ID
+		}
+	}
+	return out, nil
+}
+
+// SubnetID returns the ID of the subnet with the CIDR subnet address in the
+// section with ID sectionID.
+func (s *Sink) SubnetID(sectionID int, cidr string) (int, error) {
+	c := subnets.NewController(s.Session)
+	var found []subnets.Subnet
+	err := s.Retry.Do(fmt.Sprintf("looking up subnet %s", cidr), func() (err error) {
+		found, err = c.GetSubnetsByCIDR(cidr)
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	id := helper.SubnetIDInSection(found, sectionID)
+	if id == 0 {
+		return 0, fmt.Errorf("subnet %s not found in section %d", cidr, sectionID)
+	}
+	return id, nil
+}
+
+// SubnetIDs lists all of the subnets in the section with ID sectionID once,
+// and returns a map of their CIDRs to IDs.
+func (s *Sink) SubnetIDs(sectionID int) (map[string]int, error) {
+	found, err := s.Subnets(sectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]int)
+	for _, v := range found {
+		out[fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)] = v.ID
+	}
+	return out, nil
+}
+
+// Subnets lists all of the subnets in the section with ID sectionID.
+func (s *Sink) Subnets(sectionID int) (out []subnets.Subnet, err error) {
+	c := subnets.NewController(s.Session)
+	err = s.Retry.Do(fmt.Sprintf("listing subnets in section %d", sectionID), func() error {
+		return c.SendRequest("GET", fmt.Sprintf("/sections/%d/subnets/", sectionID), &struct{}{}, &out)
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing subnets: %s", err)
+	}
+	return out, nil
+}
+
+// UpdateSubnet updates the description, VLAN, VRF, and nameserver set of an
+// existing subnet.
+func (s *Sink) UpdateSubnet(v subnets.Subnet) error {
+	c := subnets.NewController(s.Session)
+	in := subnets.Subnet{ID: v.ID, Description: v.Description, VLANID: v.VLANID, VRFID: v.VRFID, NameserverID: v.NameserverID}
+	cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+	err := s.Retry.Do(fmt.Sprintf("updating subnet %s", cidr), func() (err error) {
+		_, err = c.UpdateSubnet(in)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("error updating subnet %s: %s", cidr, err)
+	}
+	return nil
+}
+
+// AddressID returns the ID of the IP address ip in the subnet with ID
+// subnetID, or 0 if it is not found.
+func (s *Sink) AddressID(subnetID int, ip string) (int, error) {
+	c := addresses.NewController(s.Session)
+	var found []addresses.Address
+	err := s.Retry.Do(fmt.Sprintf("looking up IP address %s", ip), func() (err error) {
+		found, err = c.GetAddressesByIP(ip)
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return 0, fmt.Errorf("error looking up IP address %s: %s", ip, err)
+	}
+	for _, v := range found {
+		if v.SubnetID == subnetID {
+			return v.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// Addresses lists all of the IP addresses in the subnet with ID subnetID.
+func (s *Sink) Addresses(subnetID int) (out []addresses.Address, err error) {
+	c := subnets.NewController(s.Session)
+	err = s.Retry.Do(fmt.Sprintf("listing IP addresses in subnet ID %d", subnetID), func() error {
+		return c.SendRequest("GET", fmt.Sprintf("/subnets/%d/addresses/", subnetID), &struct{}{}, &out)
+	})
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error listing IP addresses: %s", err)
+	}
+	return out, nil
+}
+
+// UpdateAddress updates the description, hostname, and note of an existing IP
+// address.
+func (s *Sink) UpdateAddress(a addresses.Address) error {
+	c := addresses.NewController(s.Session)
+	in := addresses.Address{ID: a.ID, Description: a.Description, Hostname: a.Hostname, Note: a.Note}
+	err := s.Retry.Do(fmt.Sprintf("updating IP address %s", a.IPAddress), func() (err error) {
+		_, err = c.UpdateAddress(in)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("error updating IP address %s: %s", a.IPAddress, err)
+	}
+	return nil
+}
+
+// VerifyAddresses compares addrs against the addresses in the new PHPIPAM
+// instance, and returns any differences found.
+func (s *Sink) VerifyAddresses(addrs []addresses.Address) (out []verify.Mismatch, err error) {
+	err = s.Retry.Do("verifying IP addresses", func() (err error) {
+		out, err = verify.Addresses(s.Session, addrs)
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error verifying IP addresses: %s", err)
+	}
+	return out, nil
+}
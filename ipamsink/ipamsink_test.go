@@ -0,0 +1,497 @@
+package ipamsink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamtest"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/replay"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-legacy-migrator/transform"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+func TestCreateSubnet(t *testing.T) {
+	var created map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/app/user/":
+			w.Write([]byte(`{"code":200,"success":true,"data":{"token":"foo"}}`))
+		case r.URL.Path == "/app/subnets/cidr/10.0.0.0/8/":
+			w.Write([]byte(`{"code":200,"success":true,"data":[{"id":"5","subnet":"10.0.0.0","mask":"8","sectionId":"1"},{"id":"7","subnet":"10.0.0.0","mask":"8","sectionId":"2"}]}`))
+		case r.URL.Path == "/app/subnets/" && r.Method == "POST":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"code":201,"success":true,"message":"Subnet created"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"success":false,"message":"No subnets found"}`))
+		}
+	}))
+	defer ts.Close()
+
+	s := New(session.NewSession(phpipam.Config{Endpoint: ts.URL, AppID: "app"}), retry.Policy{})
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.1.0.0", Mask: 16, SectionID: 2}, map[string]string{"custom_site": "yvr"}); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	if created["masterSubnetId"] != "7" {
+		t.Fatalf("Expected master subnet ID 7, got %#v", created["masterSubnetId"])
+	}
+	if created["custom_site"] != "yvr" {
+		t.Fatalf("Expected custom field custom_site to be yvr, got %#v", created["custom_site"])
+	}
+
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "bad", Mask: 16, SectionID: 2}, nil); err == nil {
+		t.Fatal("Expected error creating invalid subnet, got none")
+	}
+}
+
+func TestCreateRetries(t *testing.T) {
+	fake := ipamtest.NewServer()
+	defer fake.Close()
+
+	// The first create of each object is answered with a 502, as from a proxy
+	// timing out on a slow PHPIPAM, after being committed if commit is set.
+	commit := true
+	failed := make(map[string]bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path != "/"+ipamtest.AppID+"/user/" && !failed[r.URL.Path] {
+			failed[r.URL.Path] = true
+			if commit {
+				fake.Config.Handler.ServeHTTP(httptest.NewRecorder(), r)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"code":502,"success":false,"message":"Bad Gateway"}`))
+			return
+		}
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	s := New(session.NewSession(phpipam.Config{Endpoint: ts.URL, AppID: ipamtest.AppID, Username: "admin", Password: "password"}), retry.Policy{Retries: 2})
+	if err := s.CreateVLAN(vlans.VLAN{Number: 100, Name: "servers"}, nil); err != nil {
+		t.Fatalf("Error creating VLAN: %s", err)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1}, nil); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	subnetID := fake.Subnets()[0].ID
+	if err := s.CreateAddress(addresses.Address{IPAddress: "10.0.0.1", SubnetID: subnetID}, nil); err != nil {
+		t.Fatalf("Error creating address: %s", err)
+	}
+	if len(fake.VLANs()) != 1 || len(fake.Subnets()) != 1 || len(fake.Addresses()) != 1 {
+		t.Fatalf("Expected 1 VLAN, subnet, and address, got %d, %d, and %d", len(fake.VLANs()), len(fake.Subnets()), len(fake.Addresses()))
+	}
+
+	// A VLAN with the same number and name in another L2 domain is not taken
+	// for the VLAN being created.
+	commit = false
+	failed = make(map[string]bool)
+	if err := s.CreateVLAN(vlans.VLAN{Number: 100, Name: "servers", DomainID: 2}, nil); err != nil {
+		t.Fatalf("Error creating VLAN in another L2 domain: %s", err)
+	}
+	if n := len(fake.VLANs()); n != 2 {
+		t.Fatalf("Expected 2 VLANs, got %d", n)
+	}
+}
+
+func TestVLANIDs(t *testing.T) {
+	found := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/app/user/":
+			w.Write([]byte(`{"code":200,"success":true,"data":{"token":"foo"}}`))
+		case r.URL.Path == "/app/vlans/" && found:
+			w.Write([]byte(`{"code":200,"success":true,"data":[{"id":"1","number":"100"},{"id":"2","number":"200"},{"id":"3","number":"100"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"success":false,"message":"No vlans configured"}`))
+		}
+	}))
+	defer ts.Close()
+
+	s := New(session.NewSession(phpipam.Config{Endpoint: ts.URL, AppID: "app"}), retry.Policy{})
+	actual, err := s.VLANIDs()
+	if err != nil {
+		t.Fatalf("Error listing VLAN IDs: %s", err)
+	}
+	expected := map[int]int{100: 1, 200: 2}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+
+	found = false
+	actual, err = s.VLANIDs()
+	if err != nil {
+		t.Fatalf("Error listing VLAN IDs with none configured: %s", err)
+	}
+	if len(actual) != 0 {
+		t.Fatalf("Expected no VLAN IDs, got %#v", actual)
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/app/user/":
+			w.Write([]byte(`{"code":200,"success":true,"data":{"token":"foo"}}`))
+		case r.URL.Path == "/app/addresses/custom_fields/":
+			w.Write([]byte(`{"code":200,"success":true,"data":{"custom_Rack":{"name":"custom_Rack","type":"varchar(255)"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"success":false,"message":"No custom fields defined"}`))
+		}
+	}))
+	defer ts.Close()
+
+	s := New(session.NewSession(phpipam.Config{Endpoint: ts.URL, AppID: "app"}), retry.Policy{})
+	found, err := s.CustomFields("ipaddresses")
+	if err != nil {
+		t.Fatalf("Error listing custom fields: %s", err)
+	}
+	if expected := []string{"custom_Rack"}; !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+	if found, err := s.CustomFields("vlans"); err != nil || len(found) != 0 {
+		t.Fatalf("Expected no custom fields, got %#v, %v", found, err)
+	}
+	if _, err := s.CustomFields("changelog"); err == nil {
+		t.Fatal("Expected error listing custom fields of unsupported table, got none")
+	}
+}
+
+func TestVRFs(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if found, err := s.VRFs(); err != nil || len(found) != 0 {
+		t.Fatalf("Expected no VRFs, got %#v, %v", found, err)
+	}
+	if err := s.CreateVRF(vrfs.VRF{Name: "customers", RD: "65000:100", Description: "Customers"}); err != nil {
+		t.Fatalf("Error creating VRF: %s", err)
+	}
+	if err := s.CreateVRF(vrfs.VRF{Name: "customers"}); err == nil {
+		t.Fatal("Expected error creating duplicate VRF, got none")
+	}
+	found, err := s.VRFs()
+	if err != nil {
+		t.Fatalf("Error listing VRFs: %s", err)
+	}
+	if expected := ts.VRFs(); len(found) != 1 || !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+}
+
+func TestNameservers(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if found, err := s.Nameservers(); err != nil || len(found) != 0 {
+		t.Fatalf("Expected no nameserver sets, got %#v, %v", found, err)
+	}
+	if err := s.CreateNameserver(nameservers.Nameserver{Name: "internal", Addresses: "10.0.0.53;10.0.1.53", Permissions: "1;2"}); err != nil {
+		t.Fatalf("Error creating nameserver set: %s", err)
+	}
+	if err := s.CreateNameserver(nameservers.Nameserver{Name: "empty"}); err == nil {
+		t.Fatal("Expected error creating nameserver set without nameservers, got none")
+	}
+	found, err := s.Nameservers()
+	if err != nil {
+		t.Fatalf("Error listing nameserver sets: %s", err)
+	}
+	if expected := ts.Nameservers(); len(found) != 1 || !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+}
+
+func TestSections(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if err := s.CreateSection(sections.Section{Name: "Datacenter", Description: "Migrated"}); err != nil {
+		t.Fatalf("Error creating section: %s", err)
+	}
+	if err := s.CreateSection(sections.Section{}); err == nil {
+		t.Fatal("Expected error creating section without a name, got none")
+	}
+	found, err := s.Sections()
+	if err != nil {
+		t.Fatalf("Error listing sections: %s", err)
+	}
+	if expected := ts.Sections(); len(found) != 2 || !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+
+	if err := s.UpdateSection(sections.Section{ID: 2, MasterSection: 1}); err != nil {
+		t.Fatalf("Error updating section: %s", err)
+	}
+	if actual := ts.Sections()[1].MasterSection; actual != 1 {
+		t.Fatalf("Expected master section 1, got %d", actual)
+	}
+	if err := s.UpdateSection(sections.Section{ID: 9, MasterSection: 1}); err == nil {
+		t.Fatal("Expected error updating missing section, got none")
+	}
+}
+
+func TestL2Domains(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if err := s.CreateL2Domain(l2domains.Domain{Name: "datacenter", Description: "Datacenter VLANs", Permissions: "2"}); err != nil {
+		t.Fatalf("Error creating L2 domain: %s", err)
+	}
+	if err := s.CreateL2Domain(l2domains.Domain{}); err == nil {
+		t.Fatal("Expected error creating L2 domain without a name, got none")
+	}
+	found, err := s.L2Domains()
+	if err != nil {
+		t.Fatalf("Error listing L2 domains: %s", err)
+	}
+	if expected := ts.L2Domains(); len(found) != 2 || !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+
+	// VLAN numbers only need to be unique within a domain.
+	for _, id := range []int{0, found[1].ID} {
+		if err := s.CreateVLAN(vlans.VLAN{Number: 100, DomainID: id}, nil); err != nil {
+			t.Fatalf("Error creating VLAN in domain %d: %s", id, err)
+		}
+	}
+	if err := s.CreateVLAN(vlans.VLAN{Number: 100, DomainID: 1}, nil); err == nil {
+		t.Fatal("Expected error creating duplicate VLAN, got none")
+	}
+}
+
+func TestUpdateVLAN(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if found, err := s.VLANs(); err != nil || len(found) != 0 {
+		t.Fatalf("Expected no VLANs, got %#v, %v", found, err)
+	}
+	if err := s.CreateVLAN(vlans.VLAN{Number: 100}, nil); err != nil {
+		t.Fatalf("Error creating VLAN: %s", err)
+	}
+	found, err := s.VLANs()
+	if err != nil || len(found) != 1 {
+		t.Fatalf("Expected one VLAN, got %#v, %v", found, err)
+	}
+	v := found[0]
+	v.Name, v.Description = "servers", "Server VLAN"
+	if err := s.UpdateVLAN(v); err != nil {
+		t.Fatalf("Error updating VLAN: %s", err)
+	}
+	if found := ts.VLANs(); found[0].Name != "servers" || found[0].Description != "Server VLAN" {
+		t.Fatalf("Expected VLAN to be updated, got %#v", found[0])
+	}
+	if err := s.UpdateVLAN(vlans.VLAN{ID: 42, Number: 200}); err == nil {
+		t.Fatal("Expected error updating missing VLAN, got none")
+	}
+}
+
+func TestUpdateSubnet(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if found, err := s.Subnets(2); err != nil || len(found) != 0 {
+		t.Fatalf("Expected no subnets, got %#v, %v", found, err)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 2}, nil); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	found, err := s.Subnets(2)
+	if err != nil || len(found) != 1 {
+		t.Fatalf("Expected one subnet, got %#v, %v", found, err)
+	}
+	v := found[0]
+	v.Description, v.VLANID = "Servers", 5
+	if err := s.UpdateSubnet(v); err != nil {
+		t.Fatalf("Error updating subnet: %s", err)
+	}
+	if found := ts.Subnets(); found[0].Description != "Servers" || found[0].VLANID != 5 {
+		t.Fatalf("Expected subnet to be updated, got %#v", found[0])
+	}
+	if err := s.UpdateSubnet(subnets.Subnet{ID: 42, SubnetAddress: "10.1.0.0", Mask: 24}); err == nil {
+		t.Fatal("Expected error updating missing subnet, got none")
+	}
+}
+
+func TestUpdateAddress(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 2}, nil); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	subnetID := ts.Subnets()[0].ID
+	if id, err := s.AddressID(subnetID, "10.0.0.1"); err != nil || id != 0 {
+		t.Fatalf("Expected no address, got %d, %v", id, err)
+	}
+	if err := s.CreateAddress(addresses.Address{SubnetID: subnetID, IPAddress: "10.0.0.1"}, nil); err != nil {
+		t.Fatalf("Error creating address: %s", err)
+	}
+	id, err := s.AddressID(subnetID, "10.0.0.1")
+	if err != nil || id != ts.Addresses()[0].ID {
+		t.Fatalf("Expected address ID %d, got %d, %v", ts.Addresses()[0].ID, id, err)
+	}
+	if id, err := s.AddressID(subnetID+1, "10.0.0.1"); err != nil || id != 0 {
+		t.Fatalf("Expected no address in another subnet, got %d, %v", id, err)
+	}
+	if err := s.UpdateAddress(addresses.Address{ID: id, IPAddress: "10.0.0.1", Hostname: "web1", Note: "migrated"}); err != nil {
+		t.Fatalf("Error updating address: %s", err)
+	}
+	if found := ts.Addresses()[0]; found.Hostname != "web1" || found.Note != "migrated" {
+		t.Fatalf("Expected address to be updated, got %#v", found)
+	}
+}
+
+func TestDeviceTypes(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	if found, err := s.DeviceTypes(); err != nil || len(found) != 0 {
+		t.Fatalf("Expected no device types, got %#v, %v", found, err)
+	}
+	if err := s.CreateDeviceType(devices.DeviceType{Name: "Switch", Description: "Switch"}); err != nil {
+		t.Fatalf("Error creating device type: %s", err)
+	}
+	if err := s.CreateDeviceType(devices.DeviceType{}); err == nil {
+		t.Fatal("Expected error creating device type without a name, got none")
+	}
+	found, err := s.DeviceTypes()
+	if err != nil {
+		t.Fatalf("Error listing device types: %s", err)
+	}
+	if expected := ts.DeviceTypes(); len(found) != 1 || !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected %#v, got %#v", expected, found)
+	}
+}
+
+// TestMigrationFlow reads objects from a replayed legacy DB, transforms them,
+// and writes them to a fake PHPIPAM instance, in the same order as the
+// migrator.
+func TestMigrationFlow(t *testing.T) {
+	str := func(v string) *string { return &v }
+	sql.Register("ipamsink-flow", &replay.Driver{Bundle: &replay.Bundle{Queries: []*replay.Query{
+		{
+			SQL:     "select name, number, description from vlans",
+			Columns: []string{"name", "number", "description"},
+			Rows:    [][]*string{{str("servers"), str("100"), str("Servers")}},
+		},
+		{
+			SQL:     "select subnets.subnet, subnets.mask, subnets.description, vlans.number from subnets left join vlans on subnets.vlanId = vlans.vlanId",
+			Columns: []string{"subnet", "mask", "description", "number"},
+			Rows: [][]*string{
+				{str("167837696"), str("24"), str("child"), str("100")},
+				{str("167772160"), str("8"), str("parent"), nil},
+			},
+		},
+		{
+			SQL:     "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.dns_name, ipaddresses.note, ipaddresses.switch, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id",
+			Columns: []string{"ip_addr", "description", "dns_name", "note", "switch", "subnet", "mask"},
+			Rows:    [][]*string{{str("167837697"), str("gateway"), str(" gw.example.com "), str(""), nil, str("167837696"), str("24")}},
+		},
+	}}})
+	db, err := sql.Open("ipamsink-flow", "")
+	if err != nil {
+		t.Fatalf("Error opening replay DB: %s", err)
+	}
+	r := &legacydb.Reader{DB: db}
+
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	lans, err := r.VLANs()
+	if err != nil {
+		t.Fatalf("Error reading VLANs: %s", err)
+	}
+	for _, v := range lans {
+		if err := s.CreateVLAN(v.VLAN, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nets, _, err := r.Subnets()
+	if err != nil {
+		t.Fatalf("Error reading subnets: %s", err)
+	}
+	transform.SortSubnets(nets)
+	for _, v := range nets {
+		v.SectionID = 1
+		if v.VLANNumber != 0 {
+			if v.VLANID, err = s.VLANID(v.VLANNumber); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreateSubnet(v.Subnet, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addrs, _, err := r.Addresses()
+	if err != nil {
+		t.Fatalf("Error reading addresses: %s", err)
+	}
+	transform.Addresses(addrs)
+	for i, v := range addrs {
+		if addrs[i].SubnetID, err = s.SubnetID(1, v.SubnetCIDR); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := transform.AddressesToWrite(addrs, true)
+	for _, v := range out {
+		if err := s.CreateAddress(v, map[string]string{"custom_source": "legacy"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mismatches, err := s.VerifyAddresses(out)
+	if err != nil {
+		t.Fatalf("Error verifying addresses: %s", err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("Expected no mismatches, got %v", mismatches)
+	}
+
+	created := ts.Subnets()
+	if len(created) != 2 {
+		t.Fatalf("Expected 2 subnets, got %#v", created)
+	}
+	parent, child := created[0], created[1]
+	if child.MasterSubnetID != parent.ID {
+		t.Fatalf("Expected subnet %s/%d to be nested under ID %d, got %d", child.SubnetAddress, child.Mask, parent.ID, child.MasterSubnetID)
+	}
+	if child.VLANID != ts.VLANs()[0].ID {
+		t.Fatalf("Expected subnet %s/%d to be in VLAN ID %d, got %d", child.SubnetAddress, child.Mask, ts.VLANs()[0].ID, child.VLANID)
+	}
+	a := ts.Addresses()[0]
+	if a.Hostname != "gw.example.com" || a.SubnetID != child.ID {
+		t.Fatalf("Unexpected address %#v", a)
+	}
+	if actual := ts.CustomFields("addresses", a.ID)["custom_source"]; actual != "legacy" {
+		t.Fatalf("Expected custom field custom_source to be legacy, got %q", actual)
+	}
+}
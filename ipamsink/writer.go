@@ -0,0 +1,548 @@
+package ipamsink
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/progress"
+	"github.com/paybyphone/phpipam-legacy-migrator/transform"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/sirupsen/logrus"
+)
+
+// Writer writes the objects read from a legacy DB with the interfaces above,
+// skipping the ones that are already in the target. It keeps no state between
+// calls, and reports what it did through its logger and callbacks, so that
+// programs other than the migrator can run the write stage.
+type Writer struct {
+	// The logger to use. The standard logger is used if this is nil.
+	Log *logrus.Entry
+
+	// The display to track the progress of each phase on, if any.
+	Progress *progress.Display
+
+	// What is being written (ie: section 3), which the progress phases of
+	// subnets, addresses, IP requests, and changelog entries are named after,
+	// if set.
+	Phase string
+
+	// The ID of the section that subnets without one are written to.
+	SectionID int
+
+	// Whether to fill in the blank description, VLAN, VRF, and nameserver set
+	// of subnets that are already in their section, rather than skip them.
+	MergeSubnets bool
+
+	// Whether to update the description, hostname, and note of IP addresses
+	// that are already in their subnet, rather than fail to create them.
+	UpsertAddresses bool
+
+	// Whether to append a summary of the changes made to each IP address
+	// during the migration to its note.
+	ChangeNotes bool
+
+	// The number of workers that add IP addresses concurrently. Addresses are
+	// added one at a time if this is less than 1.
+	Workers int
+
+	// Called with the kind of each object handled (ie: "subnets") and what
+	// was done with it ("migrated", "skipped", "merged", "updated", or
+	// "error"), if set. It is called concurrently while adding IP addresses.
+	Recorded func(kind, result string)
+
+	// Called with each error writing a subnet, IP address, IP request, or
+	// changelog entry. The write carries on with the next object if it
+	// returns nil, and is aborted with the error that it returns otherwise.
+	// The first error aborts the write if this is nil. It is called
+	// concurrently while adding IP addresses.
+	Failed func(err error) error
+}
+
+// log returns the logger for the writer.
+func (w *Writer) log() *logrus.Entry {
+	if w.Log == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return w.Log
+}
+
+// record passes the result of handling an object of kind to the Recorded
+// callback, if any.
+func (w *Writer) record(kind, result string) {
+	if w.Recorded != nil {
+		w.Recorded(kind, result)
+	}
+}
+
+// fail records err as the result of writing an object of kind, and returns
+// the error that the write should be aborted with, if any.
+func (w *Writer) fail(kind string, err error) error {
+	w.record(kind, "error")
+	if w.Failed == nil {
+		return err
+	}
+	return w.Failed(err)
+}
+
+// track starts tracking the progress of writing n objects of kind, in a
+// phase named after the writer's phase if the objects belong to it.
+func (w *Writer) track(kind string, n int, inPhase bool) *progress.Tracker {
+	if inPhase && w.Phase != "" {
+		kind = fmt.Sprintf("%s (%s)", kind, w.Phase)
+	}
+	return w.Progress.Track(kind, n)
+}
+
+// AddVRFs adds the VRFs in into the target with c. VRFs whose name is already
+// used are skipped, so that a delta pass does not try to create them again.
+func (w *Writer) AddVRFs(c VRFCreator, in []vrfs.VRF) error {
+	existing, err := c.VRFs()
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool)
+	for _, v := range existing {
+		found[v.Name] = true
+	}
+
+	w.log().Info("Adding VRFs.")
+
+	tracker := w.track("vrfs", len(in), false)
+	defer tracker.Finish()
+
+	for _, v := range in {
+		tracker.Add(1)
+		log := w.log().WithField("vrf", v.Name)
+		if found[v.Name] {
+			w.record("vrfs", "skipped")
+			log.Infof("VRF %s already exists in new PHPIPAM database, skipping", v.Name)
+			continue
+		}
+		if err := c.CreateVRF(v); err != nil {
+			return err
+		}
+		found[v.Name] = true
+		w.record("vrfs", "migrated")
+		log.Infof("VRF %s added successfully", v.Name)
+	}
+	return nil
+}
+
+// deviceTypeKey returns the normalized form of a device type name, used to
+// compare them. As with MySQL's default collation, names are compared
+// case-insensitively.
+func deviceTypeKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// addDeviceTypes adds the legacy device types that are not in the target,
+// which has its own default types (ie: Switch and Router), with c, and then
+// returns the IDs of all of the device types, keyed by deviceTypeKey.
+func (w *Writer) addDeviceTypes(c DeviceCreator, types []devices.DeviceType) (map[string]int, error) {
+	ids := make(map[string]int)
+	if len(types) == 0 {
+		return ids, nil
+	}
+	list := func() error {
+		found, err := c.DeviceTypes()
+		if err != nil {
+			return err
+		}
+		for _, v := range found {
+			if _, ok := ids[deviceTypeKey(v.Name)]; !ok {
+				ids[deviceTypeKey(v.Name)] = v.ID
+			}
+		}
+		return nil
+	}
+	if err := list(); err != nil {
+		return nil, err
+	}
+
+	w.log().Info("Adding device types.")
+	created := make(map[string]bool)
+	for _, v := range types {
+		key := deviceTypeKey(v.Name)
+		if _, ok := ids[key]; ok {
+			w.log().Debugf("Device type %s already exists in new PHPIPAM database", v.Name)
+			continue
+		}
+		if created[key] {
+			continue
+		}
+		if err := c.CreateDeviceType(v); err != nil {
+			return nil, err
+		}
+		created[key] = true
+		w.record("device_types", "migrated")
+		w.log().WithField("device_type", v.Name).Infof("Device type %s added successfully", v.Name)
+	}
+	if len(created) == 0 {
+		return ids, nil
+	}
+	// The API does not return the IDs of created device types, so look them
+	// up.
+	if err := list(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// AddDevices adds the legacy device types that are not in the target with c,
+// and then creates a device for each switch in the inventory, with its type
+// if it has one, in the sections listed in sections (ie: 3;4). Switches whose
+// hostname is already used by a device are skipped, so that a delta pass does
+// not create them again.
+//
+// The IDs of the devices of the switches are returned keyed by
+// helper.SwitchKey, so that addresses can be linked to them.
+func (w *Writer) AddDevices(c DeviceCreator, inv helper.SwitchInventory, types []devices.DeviceType, sections string) (map[string]int, error) {
+	typeIDs, err := w.addDeviceTypes(c, types)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	for _, v := range existing {
+		found[helper.SwitchKey(v.Hostname)] = true
+	}
+
+	w.log().Info("Adding devices.")
+
+	switches := inv.Devices()
+	tracker := w.track("devices", len(switches), false)
+	defer tracker.Finish()
+
+	for _, v := range switches {
+		tracker.Add(1)
+		log := w.log().WithField("device", v.Hostname)
+		if found[helper.SwitchKey(v.Hostname)] {
+			w.record("devices", "skipped")
+			log.Infof("Device %s already exists in new PHPIPAM database, skipping", v.Hostname)
+			continue
+		}
+		d := devices.Device{
+			Hostname:    v.Hostname,
+			IPAddress:   v.IPAddress,
+			Type:        typeIDs[deviceTypeKey(v.Type)],
+			Description: v.Description(),
+			Sections:    sections,
+		}
+		if err := c.CreateDevice(d); err != nil {
+			return nil, err
+		}
+		found[helper.SwitchKey(v.Hostname)] = true
+		w.record("devices", "migrated")
+		log.Infof("Device %s added successfully", v.Hostname)
+	}
+
+	// The API does not return the IDs of created devices, so look them up.
+	devs, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]int)
+	for _, v := range devs {
+		if _, ok := inv.Lookup(v.Hostname); ok {
+			ids[helper.SwitchKey(v.Hostname)] = v.ID
+			w.log().Debugf("Found device ID %d for switch %s in new PHPIPAM database", v.ID, v.Hostname)
+		}
+	}
+	return ids, nil
+}
+
+// sectionOf returns the ID of the section that v is written to, which is the
+// writer's section if v has none.
+func (w *Writer) sectionOf(v legacydb.Subnet) int {
+	if v.SectionID == 0 {
+		return w.SectionID
+	}
+	return v.SectionID
+}
+
+// AddSubnets adds the subnets in nets into the target with c, and returns the
+// number added.
+//
+// As the subnets are being added, we also check to see if we can find a parent
+// subnet. In order for this to work, the subnets need to be sorted first by
+// way of transform.SortSubnets.
+//
+// If c can list the existing subnets of the sections that the subnets are
+// written to, the subnets that are already in their section are skipped, or
+// merged into the existing subnet with MergeSubnets, instead of being created
+// again.
+func (w *Writer) AddSubnets(c SubnetCreator, nets []legacydb.Subnet) (int, error) {
+	existing := make(map[int]map[string]subnets.Subnet)
+	u, ok := c.(SubnetUpdater)
+	if ok {
+		for _, v := range append([]legacydb.Subnet{{}}, nets...) {
+			section := w.sectionOf(v)
+			if _, ok := existing[section]; ok {
+				continue
+			}
+			found, err := u.Subnets(section)
+			if err != nil {
+				return 0, err
+			}
+			existing[section] = make(map[string]subnets.Subnet)
+			for _, v := range found {
+				existing[section][fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)] = v
+			}
+		}
+	} else {
+		w.log().Debugf("Existing subnets cannot be read from %T, so all subnets are created", c)
+	}
+
+	w.log().Info("Adding subnets.")
+
+	tracker := w.track("subnets", len(nets), true)
+	defer tracker.Finish()
+
+	added := 0
+	for _, v := range nets {
+		tracker.Add(1)
+		tracker.SetCurrent(fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask))
+		if v.Aggregate && coveredSubnet(existing[w.sectionOf(v)], v.Subnet) {
+			w.record("subnets", "skipped")
+			w.log().WithField("cidr", fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)).Infof("Aggregate subnet %s/%d is already covered in new PHPIPAM database, skipping", v.SubnetAddress, v.Mask)
+			continue
+		}
+		if e, ok := existing[w.sectionOf(v)][fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)]; ok {
+			if err := w.mergeSubnet(u, e, v.Subnet); err != nil {
+				if err := w.fail("subnets", err); err != nil {
+					return added, err
+				}
+			}
+			continue
+		}
+		if err := c.CreateSubnet(v.Subnet, v.CustomFields); err != nil {
+			if err := w.fail("subnets", err); err != nil {
+				return added, err
+			}
+			continue
+		}
+		added++
+		w.record("subnets", "migrated")
+		w.log().WithField("cidr", fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)).Infof("Subnet address %s/%d added successfully", v.SubnetAddress, v.Mask)
+	}
+	return added, nil
+}
+
+// coveredSubnet returns true if a subnet in existing contains v, or is v.
+func coveredSubnet(existing map[string]subnets.Subnet, v subnets.Subnet) bool {
+	ip := net.ParseIP(v.SubnetAddress)
+	for _, e := range existing {
+		_, n, err := net.ParseCIDR(fmt.Sprintf("%s/%d", e.SubnetAddress, e.Mask))
+		if err == nil && e.Mask <= v.Mask && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSubnet handles the legacy subnet v, which is already in the section as
+// the subnet e in the target. It is skipped, unless MergeSubnets is set, in
+// which case the blank description, VLAN, VRF, and nameserver set of e are
+// filled in from v with c.
+func (w *Writer) mergeSubnet(c SubnetUpdater, e, v subnets.Subnet) error {
+	cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+	log := w.log().WithField("cidr", cidr)
+	merged := e
+	if merged.Description == "" {
+		merged.Description = v.Description
+	}
+	if merged.VLANID == 0 {
+		merged.VLANID = v.VLANID
+	}
+	if merged.VRFID == 0 {
+		merged.VRFID = v.VRFID
+	}
+	if merged.NameserverID == 0 {
+		merged.NameserverID = v.NameserverID
+	}
+	if !w.MergeSubnets || merged == e {
+		w.record("subnets", "skipped")
+		log.Infof("Subnet address %s already exists in new PHPIPAM database, skipping", cidr)
+		return nil
+	}
+	if err := c.UpdateSubnet(merged); err != nil {
+		return err
+	}
+	w.record("subnets", "merged")
+	log.Infof("Subnet address %s merged into existing subnet", cidr)
+	return nil
+}
+
+// AddAddresses adds the IP addresses in legacy into the target with c, and
+// returns the number added.
+//
+// The addresses are added by Workers concurrent workers. Each worker takes
+// all of the addresses in a subnet at a time and adds them in order, so that
+// addresses in the same subnet are never added concurrently.
+func (w *Writer) AddAddresses(c AddressCreator, legacy []legacydb.Address) (int, error) {
+	workers := w.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	w.log().Infof("Adding IP addresses (%d workers).", workers)
+	addrs := transform.AddressesToWrite(legacy, w.ChangeNotes)
+	fields := make(map[string]map[string]string)
+	for _, v := range legacy {
+		if len(v.CustomFields) > 0 {
+			fields[addressKey(v.Address)] = v.CustomFields
+		}
+	}
+	tracker := w.track("addresses", len(addrs), true)
+	defer tracker.Finish()
+
+	groups := make(chan []addresses.Address)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var abortErr error
+	var mu sync.Mutex
+	added := 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groups {
+				for _, v := range group {
+					tracker.SetCurrent(v.IPAddress)
+					ok, err := w.addAddress(c, v, fields[addressKey(v)])
+					tracker.Add(1)
+					if ok {
+						mu.Lock()
+						added++
+						mu.Unlock()
+					}
+					if err != nil {
+						stopOnce.Do(func() {
+							abortErr = err
+							close(stop)
+						})
+						return
+					}
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, group := range helper.GroupAddressesBySubnet(addrs) {
+		select {
+		case groups <- group:
+		case <-stop:
+			break feed
+		}
+	}
+	close(groups)
+	wg.Wait()
+	return added, abortErr
+}
+
+// addressKey returns a key that uniquely identifies an address by its subnet
+// ID and IP address.
+func addressKey(a addresses.Address) string {
+	return fmt.Sprintf("%d/%s", a.SubnetID, a.IPAddress)
+}
+
+// addAddress adds a single IP address, with the supplied custom fields, into
+// the target with c, and returns true if it was created. With
+// UpsertAddresses, an address that is already in its subnet is updated
+// instead. An error is only returned if the address failed to be added and
+// the write should be aborted.
+func (w *Writer) addAddress(c AddressCreator, v addresses.Address, fields map[string]string) (bool, error) {
+	if err := c.CreateAddress(v, fields); err != nil {
+		updated := false
+		if w.UpsertAddresses {
+			updated, err = updateAddress(c, v, err)
+		}
+		if !updated {
+			return false, w.fail("addresses", err)
+		}
+		w.record("addresses", "updated")
+		w.log().WithField("ip", v.IPAddress).Infof("IP address %s already exists in new PHPIPAM database, updated", v.IPAddress)
+		return false, nil
+	}
+	w.record("addresses", "migrated")
+	w.log().WithField("ip", v.IPAddress).Infof("IP address %s added successfully", v.IPAddress)
+	return true, nil
+}
+
+// updateAddress updates the description, hostname, and note of the IP address
+// v in the target with c, after it failed to be created with err, if it is
+// already in its subnet. Otherwise, it returns false and err.
+func updateAddress(c AddressCreator, v addresses.Address, err error) (bool, error) {
+	u, ok := c.(AddressUpdater)
+	if !ok {
+		return false, err
+	}
+	id, lookupErr := u.AddressID(v.SubnetID, v.IPAddress)
+	if lookupErr != nil {
+		return false, lookupErr
+	}
+	if id == 0 {
+		return false, err
+	}
+	v.ID = id
+	if err := u.UpdateAddress(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AddRequests adds the IP requests in reqs into the target with c, and
+// returns the number added.
+func (w *Writer) AddRequests(c RequestCreator, reqs []legacydb.Request) (int, error) {
+	w.log().Info("Adding IP requests.")
+
+	tracker := w.track("requests", len(reqs), true)
+	defer tracker.Finish()
+
+	added := 0
+	for _, v := range reqs {
+		tracker.Add(1)
+		if err := c.CreateRequest(v.Request); err != nil {
+			if err := w.fail("requests", err); err != nil {
+				return added, err
+			}
+			continue
+		}
+		added++
+		w.record("requests", "migrated")
+		w.log().WithFields(logrus.Fields{"ip": v.IPAddress, "cidr": v.SubnetCIDR}).Infof("IP request by %s in subnet %s added successfully", v.Requester, v.SubnetCIDR)
+	}
+	return added, nil
+}
+
+// AddChangelog adds the changelog entries in changes into the target with c.
+func (w *Writer) AddChangelog(c ChangelogCreator, changes []legacydb.Change) error {
+	w.log().Info("Adding changelog entries.")
+
+	tracker := w.track("changelog", len(changes), true)
+	defer tracker.Finish()
+
+	for _, v := range changes {
+		tracker.Add(1)
+		if err := c.CreateChange(v.Entry); err != nil {
+			if err := w.fail("changelog", err); err != nil {
+				return err
+			}
+			continue
+		}
+		w.record("changelog", "migrated")
+		w.log().WithFields(logrus.Fields{"ip": v.IPAddress, "cidr": v.SubnetCIDR}).Debugf("Changelog entry (%s by %s at %s) added successfully", v.Action, v.Username, v.Date)
+	}
+	return nil
+}
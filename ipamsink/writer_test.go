@@ -0,0 +1,109 @@
+package ipamsink
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamtest"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+)
+
+func TestWriterTwice(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+
+	inv := helper.SwitchInventory{}
+	inv.AddDevice("sw1", "10.0.0.2", "", "")
+	inv.AddDevice("sw2", "10.0.0.3", "", "")
+	in := []vrfs.VRF{{Name: "customers"}, {Name: "management"}}
+	nets := []legacydb.Subnet{{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1, Description: "Servers"}}}
+
+	// A delta pass writes the same objects again, which must be skipped
+	// rather than created twice.
+	results := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		w := &Writer{SectionID: 1, UpsertAddresses: true, Workers: 2, Recorded: func(kind, result string) { results[kind+" "+result]++ }}
+		if err := w.AddVRFs(s, in); err != nil {
+			t.Fatalf("Error adding VRFs (pass %d): %s", i+1, err)
+		}
+		ids, err := w.AddDevices(s, inv, []devices.DeviceType{{Name: "Firewall"}}, "1")
+		if err != nil {
+			t.Fatalf("Error adding devices (pass %d): %s", i+1, err)
+		}
+		if len(ids) != 2 {
+			t.Fatalf("Expected the IDs of both switches (pass %d), got %v", i+1, ids)
+		}
+		if _, err := w.AddSubnets(s, nets); err != nil {
+			t.Fatalf("Error adding subnets (pass %d): %s", i+1, err)
+		}
+		subnetID, err := s.SubnetID(1, "10.0.0.0/24")
+		if err != nil {
+			t.Fatalf("Error finding subnet (pass %d): %s", i+1, err)
+		}
+		addrs := []legacydb.Address{{Address: addresses.Address{SubnetID: subnetID, IPAddress: "10.0.0.10", Hostname: "web1"}}}
+		if _, err := w.AddAddresses(s, addrs); err != nil {
+			t.Fatalf("Error adding addresses (pass %d): %s", i+1, err)
+		}
+	}
+	if len(ts.VRFs()) != 2 || len(ts.Devices()) != 2 || len(ts.Subnets()) != 1 || len(ts.Addresses()) != 1 {
+		t.Fatalf("Expected 2 VRFs, 2 devices, 1 subnet, and 1 address, got %d, %d, %d, and %d", len(ts.VRFs()), len(ts.Devices()), len(ts.Subnets()), len(ts.Addresses()))
+	}
+	expected := map[string]int{
+		"vrfs migrated": 2, "vrfs skipped": 2,
+		"device_types migrated": 1,
+		"devices migrated":      2, "devices skipped": 2,
+		"subnets migrated": 1, "subnets skipped": 1,
+		"addresses migrated": 1, "addresses updated": 1,
+	}
+	if !reflect.DeepEqual(expected, results) {
+		t.Fatalf("Expected results %v, got %v", expected, results)
+	}
+}
+
+func TestWriterFailed(t *testing.T) {
+	ts := ipamtest.NewServer()
+	defer ts.Close()
+	s := New(ts.Session(), retry.Policy{})
+	subnetID := ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1})
+
+	// The addresses in the missing subnet 99 fail to be created. Addresses
+	// in the same subnet are added in order.
+	var addrs []legacydb.Address
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		addrs = append(addrs, legacydb.Address{Address: addresses.Address{SubnetID: 99, IPAddress: ip}})
+	}
+	good := legacydb.Address{Address: addresses.Address{SubnetID: subnetID, IPAddress: "10.0.0.5"}}
+
+	// Without a Failed callback, the first error aborts the write.
+	w := &Writer{}
+	if added, err := w.AddAddresses(s, addrs[:1]); err == nil || added != 0 {
+		t.Fatalf("Expected no addresses added and an error, got %d and %v", added, err)
+	}
+	if added, err := w.AddAddresses(s, []legacydb.Address{good}); err != nil || added != 1 {
+		t.Fatalf("Expected 1 address added, got %d and %v", added, err)
+	}
+
+	var failed []error
+	w.Failed = func(err error) error {
+		failed = append(failed, err)
+		if len(failed) > 1 {
+			return errors.New("error budget exceeded")
+		}
+		return nil
+	}
+	added, err := w.AddAddresses(s, addrs[1:])
+	if err == nil || err.Error() != "error budget exceeded" {
+		t.Fatalf("Expected error budget to be exceeded, got %v", err)
+	}
+	if added != 0 || len(failed) != 2 {
+		t.Fatalf("Expected no addresses added and 2 errors, got %d and %d", added, len(failed))
+	}
+}
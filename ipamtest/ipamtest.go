@@ -0,0 +1,754 @@
+// Package ipamtest provides an in-memory fake of the PHPIPAM API, served with
+// net/http/httptest, for testing the migration without a real PHPIPAM
+// instance.
+//
+// The fake implements the parts of the API that the migrator uses: logging in,
+// and the VLAN, subnet, address, device, device type, VRF, nameserver,
+// section, and L2 domain endpoints. It answers in the same format as PHPIPAM
+// 1.2, including its 404 responses for empty lists and its token expiry errors,
+// so that the PHPIPAM SDK can be used against it unaltered.
+package ipamtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// AppID is the application ID that the server is served under.
+const AppID = "test"
+
+// Server is a fake PHPIPAM API server. Objects can be seeded with the Add
+// methods, and inspected after a test with the accessor methods.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	token       string
+	logins      int
+	lastID      int
+	vlans       []vlans.VLAN
+	subnets     []subnets.Subnet
+	addresses   []addresses.Address
+	devices     []devices.Device
+	deviceTypes []devices.DeviceType
+	vrfs        []vrfs.VRF
+	nameservers []nameservers.Nameserver
+	l2Domains   []l2domains.Domain
+	sections    []sections.Section
+	custom      map[string]map[string]string
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		custom:    make(map[string]map[string]string),
+		l2Domains: []l2domains.Domain{{ID: 1, Name: "default", Description: "Default L2 domain"}},
+		sections:  []sections.Section{{ID: 1, Name: "Customers", Description: "Section for customers"}},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Session returns a new PHPIPAM session for the server.
+func (s *Server) Session() *session.Session {
+	return session.NewSession(phpipam.Config{
+		AppID:    AppID,
+		Endpoint: s.URL,
+		Username: "admin",
+		Password: "password",
+	})
+}
+
+// ExpireToken expires the current session token, so that the next request
+// made with it fails with a token expired error.
+func (s *Server) ExpireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// Logins returns the number of times a session has logged in.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// AddVLAN seeds a VLAN, and returns its ID.
+func (s *Server) AddVLAN(v vlans.VLAN) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v.ID = s.nextID()
+	s.vlans = append(s.vlans, v)
+	return v.ID
+}
+
+// AddSubnet seeds a subnet, and returns its ID.
+func (s *Server) AddSubnet(v subnets.Subnet) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v.ID = s.nextID()
+	s.subnets = append(s.subnets, v)
+	return v.ID
+}
+
+// AddAddress seeds an address, and returns its ID.
+func (s *Server) AddAddress(v addresses.Address) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v.ID = s.nextID()
+	s.addresses = append(s.addresses, v)
+	return v.ID
+}
+
+// VLANs returns the VLANs on the server.
+func (s *Server) VLANs() []vlans.VLAN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]vlans.VLAN(nil), s.vlans...)
+}
+
+// Subnets returns the subnets on the server.
+func (s *Server) Subnets() []subnets.Subnet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]subnets.Subnet(nil), s.subnets...)
+}
+
+// Addresses returns the addresses on the server.
+func (s *Server) Addresses() []addresses.Address {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]addresses.Address(nil), s.addresses...)
+}
+
+// Devices returns the devices on the server.
+func (s *Server) Devices() []devices.Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]devices.Device(nil), s.devices...)
+}
+
+// DeviceTypes returns the device types on the server.
+func (s *Server) DeviceTypes() []devices.DeviceType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]devices.DeviceType(nil), s.deviceTypes...)
+}
+
+// VRFs returns the VRFs on the server.
+func (s *Server) VRFs() []vrfs.VRF {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]vrfs.VRF(nil), s.vrfs...)
+}
+
+// Nameservers returns the nameserver sets on the server.
+func (s *Server) Nameservers() []nameservers.Nameserver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]nameservers.Nameserver(nil), s.nameservers...)
+}
+
+// Sections returns the sections on the server, starting with the Customers
+// section that PHPIPAM is installed with.
+func (s *Server) Sections() []sections.Section {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sections.Section(nil), s.sections...)
+}
+
+// L2Domains returns the L2 domains on the server, starting with the default
+// domain that PHPIPAM is installed with.
+func (s *Server) L2Domains() []l2domains.Domain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]l2domains.Domain(nil), s.l2Domains...)
+}
+
+// CustomFields returns the custom fields set when the object with ID id was
+// created through the controller (ie: subnets).
+func (s *Server) CustomFields(controller string, id int) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.custom[fmt.Sprintf("%s/%d", controller, id)]
+}
+
+// nextID returns the next object ID. IDs are unique across all objects.
+func (s *Server) nextID() int {
+	s.lastID++
+	return s.lastID
+}
+
+// response is a PHPIPAM API response.
+type response struct {
+	Code    int         `json:"code"`
+	Success bool        `json:"success"`
+	Message string      `json:"message,omitempty"`
+	ID      string      `json:"id,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// reply writes a successful response with data.
+func reply(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response{Code: code, Success: true, Data: data})
+}
+
+// created writes a successful response to a create request.
+func created(w http.ResponseWriter, message string, id int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response{Code: http.StatusCreated, Success: true, Message: message, ID: strconv.Itoa(id)})
+}
+
+// fail writes an error response.
+func fail(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response{Code: code, Message: message})
+}
+
+// serveHTTP authenticates and routes a request.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/" + AppID
+	if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		fail(w, http.StatusBadRequest, "Invalid application id")
+		return
+	}
+	// A blank element is appended so that the controllers can always index the
+	// element after their own name, which is blank for the controller root.
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	parts := append(strings.Split(path, "/"), "")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if parts[0] == "user" {
+		if _, _, ok := r.BasicAuth(); !ok || r.Method != "POST" {
+			fail(w, http.StatusBadRequest, "Please provide username and password")
+			return
+		}
+		s.logins++
+		s.token = fmt.Sprintf("token-%d", s.logins)
+		reply(w, http.StatusOK, map[string]string{"token": s.token, "expires": "2099-01-01 00:00:00"})
+		return
+	}
+	switch token := r.Header.Get("phpipam-token"); {
+	case token == "":
+		fail(w, http.StatusUnauthorized, "Please provide token")
+		return
+	case token != s.token:
+		fail(w, http.StatusForbidden, "Token expired")
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Method == "POST" || r.Method == "PATCH" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			fail(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	switch parts[0] {
+	case "vlans":
+		s.serveVLANs(w, r.Method, parts[1:], body)
+	case "subnets":
+		s.serveSubnets(w, r.Method, parts[1:], body)
+	case "sections":
+		s.serveSections(w, r.Method, parts[1:], body)
+	case "addresses":
+		s.serveAddresses(w, r.Method, parts[1:], body)
+	case "tools":
+		switch parts[1] {
+		case "devices":
+			s.serveDevices(w, r.Method, parts[2:], body)
+			return
+		case "device_types":
+			s.serveDeviceTypes(w, r.Method, parts[2:], body)
+			return
+		case "nameservers":
+			s.serveNameservers(w, r.Method, parts[2:], body)
+			return
+		}
+		fail(w, http.StatusBadRequest, "Invalid controller")
+	case "vrf":
+		s.serveVRFs(w, r.Method, parts[1:], body)
+	case "l2domains":
+		s.serveL2Domains(w, r.Method, parts[1:], body)
+	default:
+		fail(w, http.StatusBadRequest, "Invalid controller")
+	}
+}
+
+// decode decodes body into v, and records any custom fields in it against
+// the object once it has been given an ID by the returned function.
+func (s *Server) decode(controller string, body map[string]interface{}, v interface{}) (func(id int), error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string)
+	for k, v := range body {
+		if strings.HasPrefix(k, "custom_") {
+			fields[k] = fmt.Sprint(v)
+		}
+	}
+	return func(id int) {
+		if len(fields) > 0 {
+			s.custom[fmt.Sprintf("%s/%d", controller, id)] = fields
+		}
+	}, nil
+}
+
+// serveVLANs serves the vlans controller.
+func (s *Server) serveVLANs(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v vlans.VLAN
+		record, err := s.decode("vlans", body, &v)
+		if err != nil || v.Number == 0 {
+			fail(w, http.StatusBadRequest, "Invalid VLAN number")
+			return
+		}
+		for _, e := range s.vlans {
+			if e.Number == v.Number && domainID(e) == domainID(v) {
+				fail(w, http.StatusConflict, "VLAN already exists")
+				return
+			}
+		}
+		v.ID = s.nextID()
+		s.vlans = append(s.vlans, v)
+		record(v.ID)
+		created(w, "Vlan created", v.ID)
+	case method == "GET" && parts[0] == "":
+		if len(s.vlans) == 0 {
+			fail(w, http.StatusNotFound, "No vlans configured")
+			return
+		}
+		reply(w, http.StatusOK, s.vlans)
+	case method == "GET" && parts[0] == "search" && len(parts) > 1:
+		var out []vlans.VLAN
+		for _, v := range s.vlans {
+			if strconv.Itoa(v.Number) == parts[1] {
+				out = append(out, v)
+			}
+		}
+		if len(out) == 0 {
+			fail(w, http.StatusNotFound, "Vlans not found")
+			return
+		}
+		reply(w, http.StatusOK, out)
+	case method == "PATCH" && parts[0] == "":
+		var v vlans.VLAN
+		if _, err := s.decode("vlans", body, &v); err != nil {
+			fail(w, http.StatusBadRequest, "Invalid VLAN")
+			return
+		}
+		for i, e := range s.vlans {
+			if e.ID == v.ID {
+				if v.Name != "" {
+					s.vlans[i].Name = v.Name
+				}
+				if v.Description != "" {
+					s.vlans[i].Description = v.Description
+				}
+				reply(w, http.StatusOK, nil)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Vlan not found")
+	case method == "GET":
+		for _, v := range s.vlans {
+			if strconv.Itoa(v.ID) == parts[0] {
+				reply(w, http.StatusOK, v)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Vlan not found")
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveSubnets serves the subnets controller.
+func (s *Server) serveSubnets(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v subnets.Subnet
+		record, err := s.decode("subnets", body, &v)
+		if err != nil || v.SubnetAddress == "" || v.Mask == 0 {
+			fail(w, http.StatusBadRequest, "Invalid subnet")
+			return
+		}
+		if v.SectionID == 0 {
+			fail(w, http.StatusBadRequest, "Invalid section ID")
+			return
+		}
+		for _, e := range s.subnets {
+			if e.SectionID == v.SectionID && e.SubnetAddress == v.SubnetAddress && e.Mask == v.Mask {
+				fail(w, http.StatusConflict, fmt.Sprintf("Subnet %s/%d already exists", v.SubnetAddress, v.Mask))
+				return
+			}
+		}
+		v.ID = s.nextID()
+		s.subnets = append(s.subnets, v)
+		record(v.ID)
+		created(w, "Subnet created", v.ID)
+	case method == "GET" && parts[0] == "cidr" && len(parts) > 2:
+		var out []subnets.Subnet
+		for _, v := range s.subnets {
+			if v.SubnetAddress == parts[1] && strconv.Itoa(v.Mask) == parts[2] {
+				out = append(out, v)
+			}
+		}
+		if len(out) == 0 {
+			fail(w, http.StatusNotFound, "No subnets found")
+			return
+		}
+		reply(w, http.StatusOK, out)
+	case method == "PATCH" && parts[0] == "":
+		var v subnets.Subnet
+		if _, err := s.decode("subnets", body, &v); err != nil {
+			fail(w, http.StatusBadRequest, "Invalid subnet")
+			return
+		}
+		for i, e := range s.subnets {
+			if e.ID == v.ID {
+				if v.Description != "" {
+					s.subnets[i].Description = v.Description
+				}
+				if v.VLANID != 0 {
+					s.subnets[i].VLANID = v.VLANID
+				}
+				if v.VRFID != 0 {
+					s.subnets[i].VRFID = v.VRFID
+				}
+				if v.NameserverID != 0 {
+					s.subnets[i].NameserverID = v.NameserverID
+				}
+				reply(w, http.StatusOK, nil)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Subnet does not exist")
+	case method == "GET" && parts[0] == "custom_fields":
+		reply(w, http.StatusOK, map[string]interface{}{})
+	case method == "GET" && len(parts) > 1 && parts[1] == "addresses":
+		var out []addresses.Address
+		for _, v := range s.addresses {
+			if strconv.Itoa(v.SubnetID) == parts[0] {
+				out = append(out, v)
+			}
+		}
+		if len(out) == 0 {
+			fail(w, http.StatusNotFound, "No addresses found")
+			return
+		}
+		reply(w, http.StatusOK, out)
+	case method == "GET":
+		for _, v := range s.subnets {
+			if strconv.Itoa(v.ID) == parts[0] {
+				reply(w, http.StatusOK, v)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Subnet does not exist")
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveSections serves the sections controller, and its subnets endpoint.
+// Sections are numbered separately from other objects, as L2 domains are.
+func (s *Server) serveSections(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v sections.Section
+		if _, err := s.decode("sections", body, &v); err != nil || v.Name == "" {
+			fail(w, http.StatusBadRequest, "Section name is mandatory")
+			return
+		}
+		v.ID = len(s.sections) + 1
+		s.sections = append(s.sections, v)
+		created(w, "Section created", v.ID)
+		return
+	case method == "GET" && parts[0] == "":
+		reply(w, http.StatusOK, s.sections)
+		return
+	case method == "PATCH" && parts[0] == "":
+		var v sections.Section
+		if _, err := s.decode("sections", body, &v); err != nil {
+			fail(w, http.StatusBadRequest, "Invalid section")
+			return
+		}
+		for i, e := range s.sections {
+			if e.ID == v.ID {
+				s.sections[i].MasterSection = v.MasterSection
+				reply(w, http.StatusOK, nil)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Section not found")
+		return
+	}
+	if method != "GET" || len(parts) < 2 || parts[1] != "subnets" {
+		fail(w, http.StatusBadRequest, "Invalid section request")
+		return
+	}
+	var out []subnets.Subnet
+	for _, v := range s.subnets {
+		if strconv.Itoa(v.SectionID) == parts[0] {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		fail(w, http.StatusNotFound, "No subnets found")
+		return
+	}
+	reply(w, http.StatusOK, out)
+}
+
+// serveAddresses serves the addresses controller.
+func (s *Server) serveAddresses(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v addresses.Address
+		record, err := s.decode("addresses", body, &v)
+		if err != nil || v.IPAddress == "" {
+			fail(w, http.StatusBadRequest, "Invalid IP address")
+			return
+		}
+		found := false
+		for _, e := range s.subnets {
+			found = found || e.ID == v.SubnetID
+		}
+		if !found {
+			fail(w, http.StatusBadRequest, "Invalid subnet ID")
+			return
+		}
+		for _, e := range s.addresses {
+			if e.SubnetID == v.SubnetID && e.IPAddress == v.IPAddress {
+				fail(w, http.StatusConflict, "IP address already exists")
+				return
+			}
+		}
+		v.ID = s.nextID()
+		s.addresses = append(s.addresses, v)
+		record(v.ID)
+		created(w, "Address created", v.ID)
+	case method == "GET" && parts[0] == "search" && len(parts) > 1:
+		var out []addresses.Address
+		for _, v := range s.addresses {
+			if v.IPAddress == parts[1] {
+				out = append(out, v)
+			}
+		}
+		if len(out) == 0 {
+			fail(w, http.StatusNotFound, "Address not found")
+			return
+		}
+		reply(w, http.StatusOK, out)
+	case method == "PATCH" && parts[0] == "":
+		var v addresses.Address
+		if _, err := s.decode("addresses", body, &v); err != nil {
+			fail(w, http.StatusBadRequest, "Invalid IP address")
+			return
+		}
+		for i, e := range s.addresses {
+			if e.ID == v.ID {
+				if v.Description != "" {
+					s.addresses[i].Description = v.Description
+				}
+				if v.Hostname != "" {
+					s.addresses[i].Hostname = v.Hostname
+				}
+				if v.Note != "" {
+					s.addresses[i].Note = v.Note
+				}
+				reply(w, http.StatusOK, nil)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Address not found")
+	case method == "GET":
+		for _, v := range s.addresses {
+			if strconv.Itoa(v.ID) == parts[0] {
+				reply(w, http.StatusOK, v)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Address not found")
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveDevices serves the devices endpoint of the tools controller.
+func (s *Server) serveDevices(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v devices.Device
+		record, err := s.decode("devices", body, &v)
+		if err != nil || v.Hostname == "" {
+			fail(w, http.StatusBadRequest, "Hostname is mandatory")
+			return
+		}
+		v.ID = s.nextID()
+		s.devices = append(s.devices, v)
+		record(v.ID)
+		created(w, "Device created", v.ID)
+	case method == "GET" && parts[0] == "":
+		if len(s.devices) == 0 {
+			fail(w, http.StatusNotFound, "No devices configured")
+			return
+		}
+		reply(w, http.StatusOK, s.devices)
+	case method == "GET":
+		for _, v := range s.devices {
+			if strconv.Itoa(v.ID) == parts[0] {
+				reply(w, http.StatusOK, v)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Device not found")
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveDeviceTypes serves the device types endpoint of the tools controller.
+func (s *Server) serveDeviceTypes(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v devices.DeviceType
+		if _, err := s.decode("device_types", body, &v); err != nil || v.Name == "" {
+			fail(w, http.StatusBadRequest, "Name is mandatory")
+			return
+		}
+		v.ID = s.nextID()
+		s.deviceTypes = append(s.deviceTypes, v)
+		created(w, "Device type created", v.ID)
+	case method == "GET" && parts[0] == "":
+		if len(s.deviceTypes) == 0 {
+			fail(w, http.StatusNotFound, "No device types configured")
+			return
+		}
+		reply(w, http.StatusOK, s.deviceTypes)
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveVRFs serves the VRF controller.
+func (s *Server) serveVRFs(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v vrfs.VRF
+		record, err := s.decode("vrf", body, &v)
+		if err != nil || v.Name == "" {
+			fail(w, http.StatusBadRequest, "Name is mandatory")
+			return
+		}
+		for _, e := range s.vrfs {
+			if e.Name == v.Name {
+				fail(w, http.StatusConflict, "VRF already exists")
+				return
+			}
+		}
+		v.ID = s.nextID()
+		s.vrfs = append(s.vrfs, v)
+		record(v.ID)
+		created(w, "Vrf created", v.ID)
+	case method == "GET" && parts[0] == "":
+		if len(s.vrfs) == 0 {
+			fail(w, http.StatusNotFound, "No vrfs configured")
+			return
+		}
+		reply(w, http.StatusOK, s.vrfs)
+	case method == "GET":
+		for _, v := range s.vrfs {
+			if strconv.Itoa(v.ID) == parts[0] {
+				reply(w, http.StatusOK, v)
+				return
+			}
+		}
+		fail(w, http.StatusNotFound, "Vrf not found")
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// domainID returns the ID of the L2 domain of a VLAN, which is the default
+// domain unless it is supplied.
+func domainID(v vlans.VLAN) int {
+	if v.DomainID == 0 {
+		return 1
+	}
+	return v.DomainID
+}
+
+// serveL2Domains serves the L2 domains controller. Domains are numbered
+// separately from other objects, after the default domain.
+func (s *Server) serveL2Domains(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v l2domains.Domain
+		if _, err := s.decode("l2domains", body, &v); err != nil || v.Name == "" {
+			fail(w, http.StatusBadRequest, "Name is mandatory")
+			return
+		}
+		v.ID = len(s.l2Domains) + 1
+		s.l2Domains = append(s.l2Domains, v)
+		created(w, "L2 domain created", v.ID)
+	case method == "GET" && parts[0] == "":
+		reply(w, http.StatusOK, s.l2Domains)
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveNameservers serves the nameservers endpoint of the tools controller.
+func (s *Server) serveNameservers(w http.ResponseWriter, method string, parts []string, body map[string]interface{}) {
+	switch {
+	case method == "POST" && parts[0] == "":
+		var v nameservers.Nameserver
+		if _, err := s.decode("nameservers", body, &v); err != nil || v.Name == "" || v.Addresses == "" {
+			fail(w, http.StatusBadRequest, "Name and nameservers are mandatory")
+			return
+		}
+		v.ID = s.nextID()
+		s.nameservers = append(s.nameservers, v)
+		created(w, "Nameserver created", v.ID)
+	case method == "GET" && parts[0] == "":
+		if len(s.nameservers) == 0 {
+			fail(w, http.StatusNotFound, "No nameservers configured")
+			return
+		}
+		reply(w, http.StatusOK, s.nameservers)
+	default:
+		fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
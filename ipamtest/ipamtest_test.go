@@ -0,0 +1,81 @@
+package ipamtest
+
+import (
+	"testing"
+
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+func TestServer(t *testing.T) {
+	ts := NewServer()
+	defer ts.Close()
+	sess := ts.Session()
+
+	vc := vlans.NewController(sess)
+	if _, err := vc.GetVLANsByNumber(100); err == nil || err.Error() != "Error from API (404): Vlans not found" {
+		t.Fatalf("Expected VLAN not found error, got %v", err)
+	}
+	if _, err := vc.CreateVLAN(vlans.VLAN{Name: "vlan100", Number: 100}); err != nil {
+		t.Fatalf("Error creating VLAN: %s", err)
+	}
+	if _, err := vc.CreateVLAN(vlans.VLAN{Name: "vlan100", Number: 100}); err == nil {
+		t.Fatal("Expected error creating duplicate VLAN, got none")
+	}
+	found, err := vc.GetVLANsByNumber(100)
+	if err != nil {
+		t.Fatalf("Error finding VLAN: %s", err)
+	}
+	if len(found) != 1 || found[0].Name != "vlan100" {
+		t.Fatalf("Expected VLAN vlan100, got %#v", found)
+	}
+
+	sc := subnets.NewController(sess)
+	if _, err := sc.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1, VLANID: found[0].ID}); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	nets, err := sc.GetSubnetsByCIDR("10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("Error finding subnet: %s", err)
+	}
+	if len(nets) != 1 || nets[0].VLANID != found[0].ID {
+		t.Fatalf("Expected subnet in VLAN ID %d, got %#v", found[0].ID, nets)
+	}
+
+	ac := addresses.NewController(sess)
+	if _, err := ac.CreateAddress(addresses.Address{IPAddress: "10.0.0.1", SubnetID: 999}); err == nil {
+		t.Fatal("Expected error creating address in missing subnet, got none")
+	}
+	if _, err := ac.CreateAddress(addresses.Address{IPAddress: "10.0.0.1", SubnetID: nets[0].ID}); err != nil {
+		t.Fatalf("Error creating address: %s", err)
+	}
+	if actual := ts.Addresses(); len(actual) != 1 || actual[0].IPAddress != "10.0.0.1" {
+		t.Fatalf("Expected address 10.0.0.1, got %#v", actual)
+	}
+}
+
+func TestServerTokenExpiry(t *testing.T) {
+	ts := NewServer()
+	defer ts.Close()
+	ts.AddSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8, SectionID: 1})
+
+	c := subnets.NewController(ts.Session())
+	if _, err := c.GetSubnetsByCIDR("10.0.0.0/8"); err != nil {
+		t.Fatalf("Error finding subnet: %s", err)
+	}
+	ts.ExpireToken()
+	// As with PHPIPAM, logging in again with the expired token instead of
+	// credentials fails, which is why the token package refreshes tokens
+	// instead of the SDK.
+	expected := "Error refreshing expired PHPIPAM session token: Error from API (400): Please provide username and password"
+	if _, err := c.GetSubnetsByCIDR("10.0.0.0/8"); err == nil || err.Error() != expected {
+		t.Fatalf("Expected error %q after token expiry, got %v", expected, err)
+	}
+	if _, err := subnets.NewController(ts.Session()).GetSubnetsByCIDR("10.0.0.0/8"); err != nil {
+		t.Fatalf("Error finding subnet with new session: %s", err)
+	}
+	if ts.Logins() != 2 {
+		t.Fatalf("Expected 2 logins, got %d", ts.Logins())
+	}
+}
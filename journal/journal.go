@@ -0,0 +1,193 @@
+// Package journal provides an append-only journal of the mutations made
+// through an IPAM API. Every POST, PATCH, PUT, and DELETE request is written
+// to the journal with its response and the ID of the object it returned, so
+// that the journal documents exactly what a migration did.
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces the values of password fields in journaled payloads.
+const redacted = "[redacted]"
+
+// Entry is a single mutation in the journal.
+type Entry struct {
+	// The time the request was sent.
+	Time time.Time `json:"time"`
+
+	// The method and URL path of the request.
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// The request body, with any password fields redacted.
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// The status code and body of the response. The status code is 0 if no
+	// response was received, in which case Error is set.
+	StatusCode int             `json:"status_code"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+
+	// The ID of the object returned in the response, if any (ie: the ID of a
+	// created object).
+	ID int `json:"id,omitempty"`
+}
+
+// Journal is an append-only journal file. It is safe for concurrent use.
+type Journal struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the journal file at path for appending, creating it if needed.
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{f: f}, nil
+}
+
+// Append writes e to the journal as a single JSON line.
+func (j *Journal) Append(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.f.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// Transport is a http.RoundTripper that wraps another RoundTripper, writing
+// every mutating request and its response to a Journal. Logins, which POST
+// credentials to the user controller, are not journaled.
+type Transport struct {
+	// The transport to wrap. http.DefaultTransport is used if this is nil.
+	Transport http.RoundTripper
+
+	// The journal to write to.
+	Journal *Journal
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip for Transport. The request
+// fails if it cannot be journaled, so that the journal is never missing a
+// mutation.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if !mutates(req) {
+		return rt.RoundTrip(req)
+	}
+
+	e := &Entry{Time: time.Now(), Method: req.Method, Path: req.URL.Path}
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		e.Payload = redact(b)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		e.Error = err.Error()
+		if jerr := t.Journal.Append(e); jerr != nil {
+			return nil, fmt.Errorf("error writing journal: %s", jerr)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	e.StatusCode = resp.StatusCode
+	e.Response = rawJSON(b)
+	e.ID = responseID(b)
+	if err := t.Journal.Append(e); err != nil {
+		return nil, fmt.Errorf("error writing journal: %s", err)
+	}
+	return resp, nil
+}
+
+// mutates reports whether or not req changes the IPAM, and is not a login.
+func mutates(req *http.Request) bool {
+	switch req.Method {
+	case "POST", "PATCH", "PUT", "DELETE":
+		return !strings.HasSuffix(req.URL.Path, "/user/")
+	}
+	return false
+}
+
+// rawJSON returns b as raw JSON, or as a JSON string if it is not valid JSON.
+func rawJSON(b []byte) json.RawMessage {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	if json.Valid(b) {
+		return json.RawMessage(b)
+	}
+	s, _ := json.Marshal(string(b))
+	return s
+}
+
+// redact returns the request body b as raw JSON, with the values of any
+// top-level password fields replaced.
+func redact(b []byte) json.RawMessage {
+	var fields map[string]interface{}
+	if json.Unmarshal(b, &fields) != nil {
+		return rawJSON(b)
+	}
+	found := false
+	for k := range fields {
+		if strings.Contains(strings.ToLower(k), "password") {
+			fields[k] = redacted
+			found = true
+		}
+	}
+	if !found {
+		return rawJSON(b)
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return rawJSON(b)
+	}
+	return out
+}
+
+// responseID returns the ID in the response body b, which the PHPIPAM API
+// returns as a string and NetBox as a number, or 0 if there is no numeric ID.
+func responseID(b []byte) int {
+	var resp struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(b, &resp) != nil || resp.ID == nil {
+		return 0
+	}
+	id, _ := strconv.Atoi(strings.Trim(string(resp.ID), `"`))
+	return id
+}
@@ -0,0 +1,101 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// readEntries reads the entries of the journal file at path, in the order
+// they were written.
+func readEntries(t *testing.T, path string) []Entry {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading journal: %s", err)
+	}
+	var out []Entry
+	for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("Error parsing journal line %s: %s", line, err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/app/user/":
+			w.Write([]byte(`{"code":200,"success":true,"data":{"token":"foo"}}`))
+		case r.Method == "POST" && string(body) == "":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":400,"success":false,"message":"No body"}`))
+		case r.Method == "POST":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"code":201,"success":true,"message":"Subnet created","id":"12"}`))
+		default:
+			w.Write([]byte(`{"code":200,"success":true,"data":[]}`))
+		}
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("Error opening journal: %s", err)
+	}
+	c := &http.Client{Transport: &Transport{Journal: j}}
+	for _, v := range []struct {
+		method, path, body string
+	}{
+		{"POST", "/app/user/", ""},
+		{"GET", "/app/subnets/", ""},
+		{"POST", "/app/subnets/", `{"subnet":"10.0.0.0","mask":"24"}`},
+		{"POST", "/app/users/", `{"username":"bob","password":"secret"}`},
+		{"POST", "/app/vlans/", ""},
+	} {
+		req, _ := http.NewRequest(v.method, ts.URL+v.path, bytes.NewBufferString(v.body))
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending %s %s: %s", v.method, v.path, err)
+		}
+		if b, _ := ioutil.ReadAll(resp.Body); len(b) == 0 {
+			t.Fatalf("Expected response body for %s %s to be passed on, got none", v.method, v.path)
+		}
+		resp.Body.Close()
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Error closing journal: %s", err)
+	}
+
+	entries := readEntries(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %#v", entries)
+	}
+	if e := entries[0]; e.Path != "/app/subnets/" || e.StatusCode != http.StatusCreated || e.ID != 12 || string(e.Payload) != `{"subnet":"10.0.0.0","mask":"24"}` {
+		t.Fatalf("Unexpected subnet entry %#v", e)
+	}
+	if e := entries[1]; string(e.Payload) != `{"password":"[redacted]","username":"bob"}` {
+		t.Fatalf("Expected password to be redacted, got %s", e.Payload)
+	}
+	if e := entries[2]; e.StatusCode != http.StatusBadRequest || e.ID != 0 || e.Payload != nil {
+		t.Fatalf("Unexpected failed VLAN entry %#v", e)
+	}
+
+	// Reopening appends to the existing entries.
+	if j, err = Open(path); err != nil {
+		t.Fatalf("Error reopening journal: %s", err)
+	}
+	j.Append(&Entry{Method: "DELETE", Path: "/app/subnets/12/"})
+	j.Close()
+	if entries = readEntries(t, path); len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(entries))
+	}
+}
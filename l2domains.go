@@ -0,0 +1,225 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+)
+
+// defaultL2DomainID is the ID of the L2 domain that PHPIPAM creates VLANs in
+// when none is given.
+const defaultL2DomainID = 1
+
+var (
+	// l2DomainPerSection enables the creation of an L2 domain for each
+	// migrated section, which the VLANs used by the section's subnets are
+	// created in.
+	l2DomainPerSection bool
+
+	// l2DomainsFile is the path of a YAML file mapping VLAN numbers to the L2
+	// domains that they are created in. It takes precedence over
+	// l2DomainPerSection.
+	l2DomainsFile string
+
+	// l2DomainMapping is the mapping loaded from l2DomainsFile.
+	l2DomainMapping helper.L2DomainMapping
+
+	// legacyL2Domains holds the L2 domains that VLANs are created in.
+	legacyL2Domains []l2domains.Domain
+
+	// sectionVLANDomains maps VLAN numbers to the per-section L2 domains
+	// that they are created in, when l2DomainPerSection is set.
+	sectionVLANDomains = make(map[int]string)
+
+	// l2DomainIDs maps the names of L2 domains to their IDs in the new
+	// PHPIPAM instance.
+	l2DomainIDs = make(map[string]int)
+)
+
+// migratesL2Domains returns true if VLANs are created in L2 domains other
+// than the default one.
+func migratesL2Domains() bool {
+	return l2DomainPerSection || l2DomainsFile != "" || duplicateVLANs == "domains"
+}
+
+// sectionL2DomainName returns the name of the L2 domain created for the
+// section with l2DomainPerSection.
+func sectionL2DomainName(s *sectionRun) string {
+	return fmt.Sprintf("Section %d", s.ID)
+}
+
+// fetchL2Domains works out the L2 domains that VLANs are created in: those in
+// l2DomainMapping, and, with l2DomainPerSection, one for each section whose
+// subnets use VLANs that are not mapped, read from the legacy DB in conn. A
+// VLAN that is used by more than one section is created in the domain of the
+// first, which the other sections are given access to. With duplicateVLANs set
+// to domains, the domains that the VLANs sharing a number are spread across
+// are added too.
+func fetchL2Domains(conn *sql.DB) error {
+	legacyL2Domains = nil
+	for _, d := range l2DomainMapping {
+		sections := migratedSections()
+		if len(d.Sections) > 0 {
+			ids := make([]string, len(d.Sections))
+			for i, v := range d.Sections {
+				ids[i] = strconv.Itoa(v)
+			}
+			sections = strings.Join(ids, ";")
+		}
+		legacyL2Domains = append(legacyL2Domains, l2domains.Domain{Name: d.Name, Description: d.Description, Permissions: sections})
+	}
+	if duplicateVLANs == "domains" {
+		n, err := duplicateVLANDomainCount(conn)
+		if err != nil {
+			return err
+		}
+		for k := 2; k <= n+1; k++ {
+			legacyL2Domains = append(legacyL2Domains, l2domains.Domain{
+				Name:        duplicateVLANDomainName(k),
+				Description: "Legacy VLANs whose number is used by more than one VLAN",
+				Permissions: migratedSections(),
+			})
+		}
+	}
+	if !l2DomainPerSection {
+		stageLog.Infof("Found %d L2 domains to migrate", len(legacyL2Domains))
+		return nil
+	}
+
+	stageLog.Info("Fetching the VLANs used by each section from legacy DB")
+	var names []string
+	sections := make(map[string][]int)
+	for _, s := range sectionRuns() {
+		numbers, err := s.reader(conn).SectionVLANs()
+		if err != nil {
+			return err
+		}
+		name := sectionL2DomainName(s)
+		for _, n := range numbers {
+			if l2DomainMapping.Domain(n) != "" {
+				continue
+			}
+			owner, ok := sectionVLANDomains[n]
+			if !ok {
+				sectionVLANDomains[n], owner = name, name
+			}
+			if _, ok := sections[owner]; !ok {
+				names = append(names, owner)
+			}
+			if !containsInt(sections[owner], s.ID) {
+				sections[owner] = append(sections[owner], s.ID)
+			}
+			if owner != name {
+				stageLog.Warnf("VLAN number %d is used by more than one section, so it is created in L2 domain %s, which section %d is given access to", n, owner, s.ID)
+			}
+		}
+	}
+	for _, name := range names {
+		ids := sections[name]
+		sort.Ints(ids)
+		perms := make([]string, len(ids))
+		for i, v := range ids {
+			perms[i] = strconv.Itoa(v)
+		}
+		legacyL2Domains = append(legacyL2Domains, l2domains.Domain{
+			Name:        name,
+			Description: "VLANs migrated from the legacy PHPIPAM",
+			Permissions: strings.Join(perms, ";"),
+		})
+	}
+	stageLog.Infof("Found %d L2 domains to migrate", len(legacyL2Domains))
+	return nil
+}
+
+// containsInt returns true if list contains v.
+func containsInt(list []int, v int) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+// vlanL2Domain returns the name of the L2 domain that VLAN number n is created
+// in, or blank for the default domain.
+func vlanL2Domain(n int) string {
+	if name := l2DomainMapping.Domain(n); name != "" {
+		return name
+	}
+	return sectionVLANDomains[n]
+}
+
+// loadL2DomainIDs lists the L2 domains in the new PHPIPAM instance with c, and
+// adds their IDs to l2DomainIDs.
+func loadL2DomainIDs(c ipamsink.L2DomainCreator) error {
+	found, err := c.L2Domains()
+	if err != nil {
+		return err
+	}
+	for _, v := range found {
+		l2DomainIDs[v.Name] = v.ID
+	}
+	return nil
+}
+
+// addL2Domains adds the L2 domains that are not in the new PHPIPAM instance
+// with c, and then records the IDs of all of them in l2DomainIDs.
+func addL2Domains(c ipamsink.L2DomainCreator, in []l2domains.Domain) error {
+	if err := loadL2DomainIDs(c); err != nil {
+		return err
+	}
+
+	stageLog.Info("Adding L2 domains.")
+
+	tracker := progressDisplay.Track("l2 domains", len(in))
+	defer tracker.Finish()
+
+	created := false
+	for _, v := range in {
+		tracker.Add(1)
+		log := stageLog.WithField("l2domain", v.Name)
+		if _, ok := l2DomainIDs[v.Name]; ok {
+			recordsTotal.Inc("l2domains", "skipped")
+			log.Infof("L2 domain %s already exists in new PHPIPAM database, skipping", v.Name)
+			continue
+		}
+		if err := c.CreateL2Domain(v); err != nil {
+			return err
+		}
+		created = true
+		recordsTotal.Inc("l2domains", "migrated")
+		log.Infof("L2 domain %s added successfully", v.Name)
+	}
+	// The IDs of the created L2 domains are not returned, so look them up.
+	if created {
+		return loadL2DomainIDs(c)
+	}
+	return nil
+}
+
+// resolveVLANs resolves the L2 domains of the legacy VLANs to L2 domain IDs
+// in the new PHPIPAM instance.
+func resolveVLANs() error {
+	for i, v := range legacyVLANs {
+		name, ok := duplicateVLANDomains[v.LegacyID]
+		if !ok {
+			name = vlanL2Domain(v.Number)
+		}
+		if name == "" {
+			continue
+		}
+		id, ok := l2DomainIDs[name]
+		if !ok {
+			return fmt.Errorf("L2 domain %s not found in new PHPIPAM database", name)
+		}
+		legacyVLANs[i].DomainID = id
+	}
+	return nil
+}
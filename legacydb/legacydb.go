@@ -0,0 +1,1309 @@
+// Package legacydb reads the objects to migrate out of a legacy (0.8) PHPIPAM
+// MySQL database. Databases from 0.9 to 1.1 can also be read, with queries
+// adapted to their schema by DetectSchema.
+//
+// Only IPv4 subnets and addresses are read. Rows with addresses that cannot be
+// converted to IPv4 are skipped and counted, so that the caller can report
+// them, and can be passed to a callback to record them individually.
+package legacydb
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/sirupsen/logrus"
+)
+
+// VLAN is a VLAN read from the legacy DB.
+type VLAN struct {
+	vlans.VLAN
+
+	// The ID of the VLAN in the legacy DB. This is only read for VLANs whose
+	// number is used by more than one VLAN.
+	LegacyID int
+
+	// Custom fields to set on the VLAN when it is written, keyed by field
+	// name. These are read from the custom columns mapped to custom fields,
+	// and can be set by hooks.
+	CustomFields map[string]string
+}
+
+// Subnet is a subnet read from the legacy DB, along with the number of the
+// VLAN it belongs to. The VLAN number (and the name of its VRF, if read)
+// needs to be resolved to the ID of the VLAN in the new PHPIPAM instance
+// before the subnet is written.
+type Subnet struct {
+	subnets.Subnet
+
+	// The legacy VLAN number, or 0 if the subnet has no VLAN.
+	VLANNumber int
+
+	// The ID of the legacy VLAN, or 0 if the subnet has no VLAN. This is only
+	// set if VLANs whose numbers are used more than once are migrated
+	// separately.
+	VLANLegacyID int
+
+	// The name of the legacy VRF, or blank if the subnet has no VRF. This is
+	// only set if VRFs are migrated.
+	VRFName string
+
+	// The name of the legacy nameserver set, or blank if the subnet has none.
+	// This is only set if nameservers are migrated.
+	NameserverName string
+
+	// Aggregate is set on the parent subnets that the migration synthesizes
+	// to group subnets that have none, which are not in the legacy DB.
+	Aggregate bool
+
+	// Custom fields to set on the subnet when it is written, keyed by field
+	// name.
+	CustomFields map[string]string
+}
+
+// Address is an IP address read from the legacy DB, along with the CIDR of the
+// subnet and the name of the switch it belongs to. These need to be resolved
+// to the IDs of the subnet and device in the new PHPIPAM instance before the
+// address is written.
+type Address struct {
+	addresses.Address
+
+	// The CIDR of the subnet the address belongs to.
+	SubnetCIDR string
+
+	// The free-text switch name the address references, if any.
+	Switch string
+
+	// Descriptions of the changes the migration made to the address.
+	Changes []string
+
+	// Custom fields to set on the address when it is written, keyed by field
+	// name. These are read from the custom columns mapped to custom fields,
+	// and can be set by hooks.
+	CustomFields map[string]string
+}
+
+// RecordChange records a change that the migration made to the address.
+func (a *Address) RecordChange(format string, args ...interface{}) {
+	a.Changes = append(a.Changes, fmt.Sprintf(format, args...))
+	logrus.WithField("ip", a.IPAddress).Debugf("IP address %s altered during migration: %s", a.IPAddress, a.Changes[len(a.Changes)-1])
+}
+
+// AddressKey identifies an address by its IP address and the CIDR of its
+// subnet, as addresses are identified in the new PHPIPAM instance.
+type AddressKey struct {
+	IPAddress  string
+	SubnetCIDR string
+}
+
+// Request is a pending IP request fetched from the legacy DB.
+type Request struct {
+	requests.Request
+
+	// The CIDR of the subnet the address is requested in.
+	SubnetCIDR string
+}
+
+// Change is a changelog entry about a subnet or an address fetched from the
+// legacy DB.
+type Change struct {
+	changelog.Entry
+
+	// The CIDR of the subnet changed, or of the subnet of the address changed.
+	SubnetCIDR string
+}
+
+// Skip is a row of the legacy DB that was skipped rather than read.
+type Skip struct {
+	// The kind of object in the row: subnet, address, request, changelog
+	// entry, or vlan.
+	Kind string
+
+	// The decimal address of the object, as stored in the legacy DB, or the
+	// number of a VLAN.
+	Address string
+
+	// The subnet of the object: the mask of a subnet, or the decimal address
+	// and mask (or the legacy subnet ID, if the subnet does not exist) of an
+	// address's subnet.
+	Subnet string
+
+	// The description of the object, and the hostname of an address, to help
+	// identify it.
+	Description string
+	Hostname    string
+
+	// Why the row was skipped.
+	Reason string
+}
+
+// Reader reads objects from a legacy DB.
+type Reader struct {
+	// The legacy DB.
+	DB *sql.DB
+
+	// The ID of the legacy section to read subnets and addresses from. 0 reads
+	// the subnets and addresses in all sections.
+	SectionID int
+
+	// The logger to use. The standard logger is used if this is nil.
+	Log *logrus.Entry
+
+	// The queries to run. The queries for the standard 0.8 schema are run if
+	// this is nil.
+	Queries *Queries
+
+	// Called with each row that is skipped, if set.
+	Skipped func(Skip)
+}
+
+// skip logs a skipped row as a debug message, and passes it to the Skipped
+// callback, if any.
+func (r *Reader) skip(v Skip) {
+	r.log().Debugf("Ignoring %s %s: %s", v.Kind, v.Address, v.Reason)
+	if r.Skipped != nil {
+		r.Skipped(v)
+	}
+}
+
+// standardQueries are the queries for the standard 0.8 schema.
+var standardQueries = (*Mapping)(nil).BuildQueries()
+
+// queries returns the queries for the reader to run.
+func (r *Reader) queries() *Queries {
+	if r.Queries == nil {
+		return standardQueries
+	}
+	return r.Queries
+}
+
+// log returns the logger for the reader.
+func (r *Reader) log() *logrus.Entry {
+	if r.Log == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return r.Log
+}
+
+// query runs SQL with the supplied arguments, logging the query as a debug
+// message.
+func (r *Reader) query(query string, args ...interface{}) (*sql.Rows, error) {
+	r.log().Debugf("Running SQL query: %s %v", query, args)
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error running SQL query: %s", err)
+	}
+	return rows, nil
+}
+
+// querySection runs a query on subnets, restricted to the reader's section
+// unless all sections are being read.
+func (r *Reader) querySection(query string) (*sql.Rows, error) {
+	if r.SectionID == 0 {
+		return r.query(query)
+	}
+	return r.query(r.queries().restrict(query), r.SectionID)
+}
+
+// VLANs reads all of the VLANs in the legacy DB.
+func (r *Reader) VLANs() (out []VLAN, err error) {
+	rows, err := r.query(r.queries().VLANs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, description sql.NullString
+		var number int
+		if err := rows.Scan(&name, &number, &description); err != nil {
+			return nil, fmt.Errorf("error reading VLAN rows: %s", err)
+		}
+		out = append(out, VLAN{
+			VLAN: vlans.VLAN{
+				Name:        name.String,
+				Number:      number,
+				Description: description.String,
+			},
+		})
+		r.log().WithField("vlan", number).Debugf("Found VLAN - Name: %s, Number: %d, Description: %s", name.String, number, description.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading VLAN rows: %s", err)
+	}
+	return out, nil
+}
+
+// Subnets reads the IPv4 subnets in the reader's section, and returns them
+// along with the number of subnets skipped as they are not IPv4. The section
+// ID of the subnets is left unset.
+//
+// The standard query joins 2 tables - subnets and vlans, to ensure that VLAN ID
+// entries in the table are translated to their numbers, so that the subnets
+// can be added to the VLANs in the new PHPIPAM instance by number.
+func (r *Reader) Subnets() (out []Subnet, skipped int, err error) {
+	rows, err := r.querySection(r.queries().Subnets)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mask int
+		var vlanNumber sql.NullInt64
+		var addr string
+		var description sql.NullString
+		if err := rows.Scan(&addr, &mask, &description, &vlanNumber); err != nil {
+			return nil, 0, fmt.Errorf("error reading subnet rows: %s", err)
+		}
+
+		// Our IP address is in decimal format, and needs converting to IPv4. If
+		// this is an IPv6 address, we ignore the row.
+		strAddr, err := DecimalToIPv4(addr)
+		if err != nil {
+			skipped++
+			r.skip(Skip{
+				Kind:        "subnet",
+				Address:     addr,
+				Subnet:      strconv.Itoa(mask),
+				Description: description.String,
+				Reason:      fmt.Sprintf("inconvertible decimal address - possibly not an IPv4 address (%s)", err),
+			})
+			continue
+		}
+
+		out = append(out, Subnet{
+			Subnet: subnets.Subnet{
+				SubnetAddress: strAddr,
+				Mask:          mask,
+				Description:   description.String,
+			},
+			VLANNumber: int(vlanNumber.Int64),
+		})
+		r.log().WithFields(logrus.Fields{"cidr": fmt.Sprintf("%s/%d", strAddr, mask), "vlan": vlanNumber.Int64}).Debugf("Found subnet - Name: %s, Mask: %d, Description: %s, VLAN: %d", strAddr, mask, description.String, vlanNumber.Int64)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading subnet rows: %s", err)
+	}
+	return out, skipped, nil
+}
+
+// VLANCustomFields reads the values of the custom columns of the VLANs, keyed
+// by VLAN number and then by the names of the custom fields they are mapped
+// to, in the order of the columns in the query. NULL and blank values are left
+// out, as are VLANs without any values.
+func (r *Reader) VLANCustomFields(fields []string) (map[int]map[string]string, error) {
+	rows, err := r.query(r.queries().VLANCustomFields)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int]map[string]string)
+	for rows.Next() {
+		var number int
+		values := make([]sql.NullString, len(fields))
+		dest := []interface{}{&number}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error reading VLAN custom field rows: %s", err)
+		}
+		set := make(map[string]string)
+		for i, v := range values {
+			if v.String != "" {
+				set[fields[i]] = v.String
+			}
+		}
+		if len(set) > 0 {
+			out[number] = set
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading VLAN custom field rows: %s", err)
+	}
+	return out, nil
+}
+
+// VRFs reads all of the VRFs in the legacy DB.
+func (r *Reader) VRFs() (out []vrfs.VRF, err error) {
+	rows, err := r.query(r.queries().VRFs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var rd, description sql.NullString
+		if err := rows.Scan(&name, &rd, &description); err != nil {
+			return nil, fmt.Errorf("error reading VRF rows: %s", err)
+		}
+		out = append(out, vrfs.VRF{
+			Name:        name,
+			RD:          rd.String,
+			Description: description.String,
+		})
+		r.log().WithField("vrf", name).Debugf("Found VRF - Name: %s, RD: %s, Description: %s", name, rd.String, description.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading VRF rows: %s", err)
+	}
+	return out, nil
+}
+
+// SubnetVRFs reads the names of the VRFs of the IPv4 subnets in the reader's
+// section, keyed by subnet CIDR. Subnets without a VRF are left out.
+func (r *Reader) SubnetVRFs() (map[string]string, error) {
+	return r.subnetNames(r.queries().SubnetVRFs, "VRF")
+}
+
+// SectionVLANs reads the distinct numbers of the VLANs used by subnets in the
+// reader's section, in order.
+func (r *Reader) SectionVLANs() ([]int, error) {
+	rows, err := r.querySection(r.queries().SectionVLANs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := make(map[int]bool)
+	var out []int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			return nil, fmt.Errorf("error reading section VLAN rows: %s", err)
+		}
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading section VLAN rows: %s", err)
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// SectionIDs reads the IDs of all of the legacy sections, in order. It runs
+// the SectionMasters query, which reads every section.
+func (r *Reader) SectionIDs() (out []int, err error) {
+	rows, err := r.query(r.queries().SectionMasters)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var master sql.NullInt64
+		if err := rows.Scan(&id, &master); err != nil {
+			return nil, fmt.Errorf("error reading section rows: %s", err)
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading section rows: %s", err)
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// SectionMasters reads the master section ID of each legacy section that is a
+// subsection of another, keyed by section ID.
+func (r *Reader) SectionMasters() (map[int]int, error) {
+	rows, err := r.query(r.queries().SectionMasters)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int]int)
+	for rows.Next() {
+		var id int
+		var master sql.NullInt64
+		if err := rows.Scan(&id, &master); err != nil {
+			return nil, fmt.Errorf("error reading section rows: %s", err)
+		}
+		if master.Int64 != 0 {
+			out[id] = int(master.Int64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading section rows: %s", err)
+	}
+	return out, nil
+}
+
+// Nameservers reads all of the nameserver sets in the legacy DB.
+func (r *Reader) Nameservers() (out []nameservers.Nameserver, err error) {
+	rows, err := r.query(r.queries().Nameservers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var addrs, description sql.NullString
+		if err := rows.Scan(&name, &addrs, &description); err != nil {
+			return nil, fmt.Errorf("error reading nameserver rows: %s", err)
+		}
+		out = append(out, nameservers.Nameserver{
+			Name:        name,
+			Addresses:   addrs.String,
+			Description: description.String,
+		})
+		r.log().WithField("nameserver", name).Debugf("Found nameserver set - Name: %s, Nameservers: %s, Description: %s", name, addrs.String, description.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading nameserver rows: %s", err)
+	}
+	return out, nil
+}
+
+// DuplicateVLANs reads the VLANs whose numbers are used by more than one VLAN
+// in the legacy DB, along with their legacy IDs, ordered by number and then
+// by ID.
+func (r *Reader) DuplicateVLANs() (out []VLAN, err error) {
+	rows, err := r.query(r.queries().DuplicateVLANs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []VLAN
+	count := make(map[int]int)
+	for rows.Next() {
+		var v VLAN
+		var name, description sql.NullString
+		if err := rows.Scan(&v.LegacyID, &name, &v.Number, &description); err != nil {
+			return nil, fmt.Errorf("error reading duplicate VLAN rows: %s", err)
+		}
+		v.Name, v.Description = name.String, description.String
+		all = append(all, v)
+		count[v.Number]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading duplicate VLAN rows: %s", err)
+	}
+	for _, v := range all {
+		if count[v.Number] > 1 {
+			out = append(out, v)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+	return out, nil
+}
+
+// SubnetVLANIDs reads the legacy VLAN IDs of the IPv4 subnets in the
+// reader's section that have a VLAN, keyed by subnet CIDR.
+func (r *Reader) SubnetVLANIDs() (map[string]int, error) {
+	ids, err := r.subnetNames(r.queries().SubnetVLANIDs, "VLAN ID")
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int)
+	for cidr, v := range ids {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VLAN ID %q of subnet %s", v, cidr)
+		}
+		out[cidr] = id
+	}
+	return out, nil
+}
+
+// VLANIDs reads the legacy IDs of the VLANs, keyed by VLAN number. The IDs of
+// each number are in ascending order, and there is more than one if the
+// number is used by more than one VLAN.
+func (r *Reader) VLANIDs() (map[int][]int, error) {
+	rows, err := r.query(r.queries().VLANIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int][]int)
+	for rows.Next() {
+		var number, id int
+		if err := rows.Scan(&number, &id); err != nil {
+			return nil, fmt.Errorf("error reading VLAN ID rows: %s", err)
+		}
+		out[number] = append(out[number], id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading VLAN ID rows: %s", err)
+	}
+	return out, nil
+}
+
+// SubnetIDs reads the legacy IDs of the IPv4 subnets in the reader's section,
+// keyed by subnet CIDR.
+func (r *Reader) SubnetIDs() (map[string]string, error) {
+	return r.subnetNames(r.queries().SubnetIDs, "ID")
+}
+
+// SubnetNameservers reads the names of the nameserver sets of the IPv4
+// subnets in the reader's section, keyed by subnet CIDR. Subnets without a
+// nameserver set are left out.
+func (r *Reader) SubnetNameservers() (map[string]string, error) {
+	return r.subnetNames(r.queries().SubnetNameservers, "nameserver")
+}
+
+// subnetNames runs query in the reader's section, which returns the decimal
+// address and mask, and the name of an object, of each subnet that references
+// one, and returns the names keyed by subnet CIDR. what describes the object
+// in error messages.
+func (r *Reader) subnetNames(query, what string) (map[string]string, error) {
+	rows, err := r.querySection(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]string)
+	for rows.Next() {
+		var addr string
+		var name sql.NullString
+		var mask int
+		if err := rows.Scan(&addr, &mask, &name); err != nil {
+			return nil, fmt.Errorf("error reading subnet %s rows: %s", what, err)
+		}
+		// Subnets that are not IPv4 are skipped by Subnets.
+		strAddr, err := DecimalToIPv4(addr)
+		if err != nil || name.String == "" {
+			continue
+		}
+		out[fmt.Sprintf("%s/%d", strAddr, mask)] = name.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading subnet %s rows: %s", what, err)
+	}
+	return out, nil
+}
+
+// Switches reads the distinct switch names referenced by IPv4 addresses in the
+// legacy DB, along with the number of addresses and the subnets that reference
+// each one. Switches are read from all sections, as devices are shared.
+//
+// If the switch names are read from a switch table, all of the devices in it
+// are included, with their IP addresses and descriptions, whether or not any
+// address references them.
+func (r *Reader) Switches() (helper.SwitchInventory, error) {
+	out := make(helper.SwitchInventory)
+	rows, err := r.query(r.queries().Switches)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var subnetAddr sql.NullString
+		var subnetMask sql.NullInt64
+		if err := rows.Scan(&name, &subnetAddr, &subnetMask); err != nil {
+			return nil, fmt.Errorf("error reading switch rows: %s", err)
+		}
+		if !subnetAddr.Valid {
+			r.log().Debugf("Ignoring switch %s on an address whose subnet does not exist", name)
+			continue
+		}
+		subnetString, err := DecimalToIPv4(subnetAddr.String)
+		if err != nil {
+			r.log().Debugf("Ignoring switch %s on inconvertible decimal subnet address %s - possibly not an IPv4 address (%s)", name, subnetAddr.String, err)
+			continue
+		}
+		out.Add(name, fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading switch rows: %s", err)
+	}
+	if r.queries().Devices != "" {
+		if err := r.devices(out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// devices adds the devices in the switch table to inv. The devices have types
+// if the query returns a fourth column.
+func (r *Reader) devices(inv helper.SwitchInventory) error {
+	rows, err := r.query(r.queries().Devices)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("error reading device rows: %s", err)
+	}
+	for rows.Next() {
+		var hostname, ipAddr, description, deviceType sql.NullString
+		dest := []interface{}{&hostname, &ipAddr, &description}
+		if len(columns) > 3 {
+			dest = append(dest, &deviceType)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("error reading device rows: %s", err)
+		}
+		inv.AddDevice(hostname.String, ipAddr.String, description.String, deviceType.String)
+		r.log().WithField("device", hostname.String).Debugf("Found device - Hostname: %s, IP address: %s, Description: %s, Type: %s", hostname.String, ipAddr.String, description.String, deviceType.String)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading device rows: %s", err)
+	}
+	return nil
+}
+
+// DeviceTypes reads the device types in the legacy DB, if they are read from a
+// device type table.
+func (r *Reader) DeviceTypes() (out []devices.DeviceType, err error) {
+	if r.queries().DeviceTypes == "" {
+		return nil, nil
+	}
+	rows, err := r.query(r.queries().DeviceTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, description sql.NullString
+		if err := rows.Scan(&name, &description); err != nil {
+			return nil, fmt.Errorf("error reading device type rows: %s", err)
+		}
+		if name.String == "" {
+			continue
+		}
+		out = append(out, devices.DeviceType{Name: name.String, Description: description.String})
+		r.log().Debugf("Found device type - Name: %s, Description: %s", name.String, description.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading device type rows: %s", err)
+	}
+	return out, nil
+}
+
+// Addresses reads the IPv4 addresses in the reader's section, and returns them
+// along with the number of addresses skipped as they are not IPv4.
+//
+// The standard query joins 2 tables - addresses and subnets, to ensure that we
+// know what subnet that the IP address belongs to, without knowing its specific
+// ID in the database.
+func (r *Reader) Addresses() (out []Address, skipped int, err error) {
+	rows, err := r.querySection(r.queries().Addresses)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ipAddr string
+		var nullDescription, nullDNSName, nullNote, switchName, subnetAddr sql.NullString
+		var subnetMask sql.NullInt64
+
+		if err := rows.Scan(&ipAddr, &nullDescription, &nullDNSName, &nullNote, &switchName, &subnetAddr, &subnetMask); err != nil {
+			return nil, 0, fmt.Errorf("error reading address rows: %s", err)
+		}
+		description, dnsName, note := nullDescription.String, nullDNSName.String, nullNote.String
+		if !subnetAddr.Valid {
+			// Only returned when reading all sections, as the join to the
+			// section fails otherwise. These are passed to the Skipped
+			// callback by OrphanAddresses instead, so are only counted here.
+			skipped++
+			r.log().Debugf("Ignoring address %s: its subnet does not exist", ipAddr)
+			continue
+		}
+
+		// We have addresses that need converting to string format. Do this now.
+		skip := Skip{
+			Kind:        "address",
+			Address:     ipAddr,
+			Subnet:      fmt.Sprintf("%s/%d", subnetAddr.String, subnetMask.Int64),
+			Description: description,
+			Hostname:    dnsName,
+		}
+		ipString, err := DecimalToIPv4(ipAddr)
+		if err != nil {
+			skipped++
+			skip.Reason = fmt.Sprintf("inconvertible decimal IP address - possibly not an IPv4 address (%s)", err)
+			r.skip(skip)
+			continue
+		}
+		subnetString, err := DecimalToIPv4(subnetAddr.String)
+		if err != nil {
+			skipped++
+			skip.Reason = fmt.Sprintf("inconvertible decimal subnet address - possibly not an IPv4 address (%s)", err)
+			r.skip(skip)
+			continue
+		}
+
+		out = append(out, Address{
+			Address: addresses.Address{
+				IPAddress:   ipString,
+				Description: description,
+				Hostname:    dnsName,
+				Note:        note,
+			},
+			SubnetCIDR: fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64),
+			Switch:     switchName.String,
+		})
+		r.log().WithFields(logrus.Fields{"ip": ipString, "cidr": fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64)}).Debugf("Found IP address - Address: %s, Description: %s, Hostname: %s, Note: %s, Subnet: %s/%d", ipString, description, dnsName, note, subnetString, subnetMask.Int64)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading address rows: %s", err)
+	}
+	return out, skipped, nil
+}
+
+// AddressPorts reads the switch ports of the IPv4 addresses in the reader's
+// section that have one. Addresses that are not IPv4 are left out, as they are
+// by Addresses.
+func (r *Reader) AddressPorts() (map[AddressKey]string, error) {
+	return r.addressValues(r.queries().AddressPorts, "port")
+}
+
+// AddressStates reads the states of the IPv4 addresses in the reader's section
+// that have one, as stored in the legacy DB (ie: 2 for reserved). Addresses
+// that are not IPv4 are left out, as they are by Addresses.
+func (r *Reader) AddressStates() (map[AddressKey]string, error) {
+	out, err := r.addressValues(r.queries().AddressStates, "state")
+	for k, v := range out {
+		out[k] = strings.TrimSpace(v)
+	}
+	return out, err
+}
+
+// AddressMACs reads the MAC addresses of the IPv4 addresses in the reader's
+// section that have one, as stored in the legacy DB. Addresses that are not
+// IPv4 are left out, as they are by Addresses.
+func (r *Reader) AddressMACs() (map[AddressKey]string, error) {
+	return r.addressValues(r.queries().AddressMACs, "MAC address")
+}
+
+// AddressOwners reads the owners of the IPv4 addresses in the reader's
+// section that have one. Addresses that are not IPv4 are left out, as they are
+// by Addresses.
+func (r *Reader) AddressOwners() (map[AddressKey]string, error) {
+	return r.addressValues(r.queries().AddressOwners, "owner")
+}
+
+// PingExcludedAddresses reads the IPv4 addresses in the reader's section that
+// are excluded from ping checks. Addresses that are not IPv4 are left out, as
+// they are by Addresses.
+func (r *Reader) PingExcludedAddresses() (map[AddressKey]bool, error) {
+	return r.addressFlags(r.queries().AddressExcludePing, "exclude ping")
+}
+
+// PTRIgnoredAddresses reads the IPv4 addresses in the reader's section that
+// have no PTR records created for them. Addresses that are not IPv4 are left
+// out, as they are by Addresses.
+func (r *Reader) PTRIgnoredAddresses() (map[AddressKey]bool, error) {
+	return r.addressFlags(r.queries().AddressPTRIgnore, "PTR ignore")
+}
+
+// AddressLastSeen reads the times that the IPv4 addresses in the reader's
+// section were last seen alive, as YYYY-MM-DD HH:MM:SS. Addresses that have
+// never been seen, and those that are not IPv4, are left out.
+func (r *Reader) AddressLastSeen() (map[AddressKey]string, error) {
+	return r.addressTimes(r.queries().AddressLastSeen, "last seen")
+}
+
+// AddressEditDates reads the times that the IPv4 addresses in the reader's
+// section were last edited, as YYYY-MM-DD HH:MM:SS. Addresses without an edit
+// time, and those that are not IPv4, are left out.
+func (r *Reader) AddressEditDates() (map[AddressKey]string, error) {
+	return r.addressTimes(r.queries().AddressEditDates, "edit date")
+}
+
+// addressTimes runs query as with addressValues, and returns the times that
+// are set. MySQL's zero datetime, which legacy DBs use for unset times, is
+// left out.
+func (r *Reader) addressTimes(query, what string) (map[AddressKey]string, error) {
+	values, err := r.addressValues(query, what)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		if v = strings.TrimSpace(v); v == "" || strings.HasPrefix(v, "0000-00-00") {
+			delete(values, k)
+		} else {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// addressFlags runs query as with addressValues, and returns the addresses
+// whose flag is set to 1.
+func (r *Reader) addressFlags(query, what string) (map[AddressKey]bool, error) {
+	values, err := r.addressValues(query, what)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[AddressKey]bool)
+	for k, v := range values {
+		if strings.TrimSpace(v) == "1" {
+			out[k] = true
+		}
+	}
+	return out, nil
+}
+
+// addressValues runs query in the reader's section, which returns the decimal
+// address, the decimal address and mask of the subnet, and a value of each
+// address, and returns the values of the IPv4 addresses. what describes the
+// value in error messages.
+func (r *Reader) addressValues(query, what string) (map[AddressKey]string, error) {
+	rows, err := r.querySection(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[AddressKey]string)
+	for rows.Next() {
+		var ipAddr string
+		var subnetAddr, value sql.NullString
+		var subnetMask sql.NullInt64
+		if err := rows.Scan(&ipAddr, &subnetAddr, &subnetMask, &value); err != nil {
+			return nil, fmt.Errorf("error reading address %s rows: %s", what, err)
+		}
+		ipString, err := DecimalToIPv4(ipAddr)
+		if err != nil || !subnetAddr.Valid || !value.Valid {
+			continue
+		}
+		subnetString, err := DecimalToIPv4(subnetAddr.String)
+		if err != nil {
+			continue
+		}
+		out[AddressKey{ipString, fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64)}] = value.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading address %s rows: %s", what, err)
+	}
+	return out, nil
+}
+
+// AddressIDs reads the legacy IDs of the IPv4 addresses in the reader's
+// section.
+func (r *Reader) AddressIDs() (map[AddressKey]string, error) {
+	return r.addressValues(r.queries().AddressIDs, "ID")
+}
+
+// AddressCustomFields reads the values of the custom columns of the IPv4
+// addresses in the reader's section, keyed by the names of the custom fields
+// they are mapped to, in the order of the columns in the query. NULL and blank
+// values are left out, as are addresses without any values, and addresses
+// that are not IPv4, as they are by Addresses.
+func (r *Reader) AddressCustomFields(fields []string) (map[AddressKey]map[string]string, error) {
+	rows, err := r.querySection(r.queries().AddressCustomFields)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[AddressKey]map[string]string)
+	for rows.Next() {
+		var ipAddr string
+		var subnetAddr sql.NullString
+		var subnetMask sql.NullInt64
+		values := make([]sql.NullString, len(fields))
+		dest := []interface{}{&ipAddr, &subnetAddr, &subnetMask}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error reading address custom field rows: %s", err)
+		}
+		ipString, err := DecimalToIPv4(ipAddr)
+		if err != nil || !subnetAddr.Valid {
+			continue
+		}
+		subnetString, err := DecimalToIPv4(subnetAddr.String)
+		if err != nil {
+			continue
+		}
+		set := make(map[string]string)
+		for i, v := range values {
+			if v.String != "" {
+				set[fields[i]] = v.String
+			}
+		}
+		if len(set) > 0 {
+			out[AddressKey{ipString, fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64)}] = set
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading address custom field rows: %s", err)
+	}
+	return out, nil
+}
+
+// Requests reads the IP requests in the reader's section that have not been
+// processed. Requests that are not for IPv4 addresses, or whose subnet does
+// not exist, are skipped, and the number skipped is returned.
+func (r *Reader) Requests() (out []Request, skipped int, err error) {
+	rows, err := r.querySection(r.queries().Requests)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ipAddr, subnetAddr, description, dnsName, owner, requester, comment sql.NullString
+		var subnetMask sql.NullInt64
+		if err := rows.Scan(&ipAddr, &subnetAddr, &subnetMask, &description, &dnsName, &owner, &requester, &comment); err != nil {
+			return nil, 0, fmt.Errorf("error reading request rows: %s", err)
+		}
+		skip := Skip{
+			Kind:        "request",
+			Address:     ipAddr.String,
+			Subnet:      fmt.Sprintf("%s/%d", subnetAddr.String, subnetMask.Int64),
+			Description: description.String,
+			Hostname:    dnsName.String,
+		}
+		if !subnetAddr.Valid {
+			skipped++
+			skip.Subnet = ""
+			skip.Reason = "subnet does not exist"
+			r.skip(skip)
+			continue
+		}
+		subnetString, err := DecimalToIPv4(subnetAddr.String)
+		if err != nil {
+			skipped++
+			skip.Reason = fmt.Sprintf("inconvertible decimal subnet address - possibly not an IPv4 address (%s)", err)
+			r.skip(skip)
+			continue
+		}
+		var ipString string
+		if ipAddr.String != "" {
+			if ipString, err = DecimalToIPv4(ipAddr.String); err != nil {
+				skipped++
+				skip.Reason = fmt.Sprintf("inconvertible decimal IP address - possibly not an IPv4 address (%s)", err)
+				r.skip(skip)
+				continue
+			}
+		}
+
+		out = append(out, Request{
+			Request: requests.Request{
+				IPAddress:   ipString,
+				Description: description.String,
+				Hostname:    dnsName.String,
+				Owner:       owner.String,
+				Requester:   requester.String,
+				Comment:     comment.String,
+			},
+			SubnetCIDR: fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64),
+		})
+		r.log().WithFields(logrus.Fields{"ip": ipString, "cidr": fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64)}).Debugf("Found IP request - Address: %s, Hostname: %s, Requester: %s", ipString, dnsName.String, requester.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading request rows: %s", err)
+	}
+	return out, skipped, nil
+}
+
+// OrphanAddresses reads the addresses in all sections whose subnet does not
+// exist, which the Addresses query cannot join to a subnet or section, and so
+// never returns. Each one is passed to the Skipped callback, and the number
+// found is returned.
+func (r *Reader) OrphanAddresses() (int, error) {
+	rows, err := r.query(r.queries().OrphanAddresses)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var ipAddr string
+		var description, dnsName, subnetID sql.NullString
+		if err := rows.Scan(&ipAddr, &description, &dnsName, &subnetID); err != nil {
+			return 0, fmt.Errorf("error reading orphan address rows: %s", err)
+		}
+		n++
+		skip := Skip{
+			Kind:        "address",
+			Address:     ipAddr,
+			Description: description.String,
+			Hostname:    dnsName.String,
+			Reason:      "address has no subnet",
+		}
+		if subnetID.Valid {
+			skip.Subnet = "ID " + subnetID.String
+			skip.Reason = fmt.Sprintf("subnet ID %s does not exist", subnetID.String)
+		}
+		r.skip(skip)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error reading orphan address rows: %s", err)
+	}
+	return n, nil
+}
+
+// OrphanSubnets reads the subnets in all sections whose section does not
+// exist, which are never migrated, as the legacy sections are read by ID.
+// They are returned as skipped rows, rather than passed to the Skipped
+// callback, as they are only audited.
+func (r *Reader) OrphanSubnets() (out []Skip, err error) {
+	rows, err := r.query(r.queries().OrphanSubnets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addr string
+		var mask int
+		var description, sectionID sql.NullString
+		if err := rows.Scan(&addr, &mask, &description, &sectionID); err != nil {
+			return nil, fmt.Errorf("error reading orphan subnet rows: %s", err)
+		}
+		skip := Skip{
+			Kind:        "subnet",
+			Address:     addr,
+			Subnet:      strconv.Itoa(mask),
+			Description: description.String,
+			Reason:      "subnet has no section",
+		}
+		if sectionID.Valid {
+			skip.Reason = fmt.Sprintf("section ID %s does not exist", sectionID.String)
+		}
+		out = append(out, skip)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading orphan subnet rows: %s", err)
+	}
+	return out, nil
+}
+
+// Changelog reads the changelog entries about the subnets and addresses in
+// the reader's section, in the order that the changes were made. Entries
+// about subnets and addresses that no longer exist, or that are not IPv4, are
+// skipped and counted. Entries about sections are not read, as sections are
+// not migrated.
+func (r *Reader) Changelog() (out []Change, skipped int, err error) {
+	for _, v := range []struct{ typ, query string }{
+		{changelog.SubnetType, r.queries().SubnetChangelog},
+		{changelog.AddressType, r.queries().AddressChangelog},
+	} {
+		changes, n, err := r.changes(v.typ, v.query)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, changes...)
+		skipped += n
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Date < out[j].Date })
+	return out, skipped, nil
+}
+
+// changes reads the changelog entries about objects of type typ with query,
+// which returns the address of the object after the entry's own columns for
+// addresses.
+func (r *Reader) changes(typ, query string) (out []Change, skipped int, err error) {
+	rows, err := r.querySection(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var action, result, date, diff, username, ipAddr, subnetAddr sql.NullString
+		var subnetMask sql.NullInt64
+		dest := []interface{}{&action, &result, &date, &diff, &username}
+		if typ == changelog.AddressType {
+			dest = append(dest, &ipAddr)
+		}
+		if err := rows.Scan(append(dest, &subnetAddr, &subnetMask)...); err != nil {
+			return nil, 0, fmt.Errorf("error reading changelog rows: %s", err)
+		}
+		skip := Skip{
+			Kind:        "changelog entry",
+			Address:     ipAddr.String,
+			Subnet:      fmt.Sprintf("%s/%d", subnetAddr.String, subnetMask.Int64),
+			Description: fmt.Sprintf("%s at %s", action.String, date.String),
+		}
+		switch {
+		case typ == changelog.AddressType && !ipAddr.Valid:
+			skipped++
+			skip.Subnet = ""
+			skip.Reason = "address does not exist"
+			r.skip(skip)
+			continue
+		case !subnetAddr.Valid:
+			skipped++
+			skip.Subnet = ""
+			skip.Reason = "subnet does not exist"
+			r.skip(skip)
+			continue
+		}
+		subnetString, err := DecimalToIPv4(subnetAddr.String)
+		if err != nil {
+			skipped++
+			skip.Reason = fmt.Sprintf("inconvertible decimal subnet address - possibly not an IPv4 address (%s)", err)
+			r.skip(skip)
+			continue
+		}
+		var ipString string
+		if typ == changelog.AddressType {
+			if ipString, err = DecimalToIPv4(ipAddr.String); err != nil {
+				skipped++
+				skip.Reason = fmt.Sprintf("inconvertible decimal IP address - possibly not an IPv4 address (%s)", err)
+				r.skip(skip)
+				continue
+			}
+		}
+
+		cidr := fmt.Sprintf("%s/%d", subnetString, subnetMask.Int64)
+		out = append(out, Change{
+			Entry: changelog.Entry{
+				Type:      typ,
+				IPAddress: ipString,
+				Username:  username.String,
+				Action:    action.String,
+				Result:    result.String,
+				Date:      date.String,
+				Diff:      diff.String,
+			},
+			SubnetCIDR: cidr,
+		})
+		r.log().WithFields(logrus.Fields{"ip": ipString, "cidr": cidr}).Debugf("Found changelog entry - Type: %s, Action: %s, Date: %s, User: %s", typ, action.String, date.String, username.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading changelog rows: %s", err)
+	}
+	return out, skipped, nil
+}
+
+// Users reads the usernames of the legacy users, which are only migrated on
+// request (see UserAccounts).
+func (r *Reader) Users() (out []string, err error) {
+	rows, err := r.query(r.queries().Users)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("error reading user rows: %s", err)
+		}
+		if v.String != "" {
+			out = append(out, v.String)
+		}
+	}
+	return out, rows.Err()
+}
+
+// UserAccounts reads the legacy users, with the legacy IDs of the groups they
+// belong to. Users whose groups cannot be parsed are read without any, with a
+// warning.
+func (r *Reader) UserAccounts() (out []users.User, err error) {
+	rows, err := r.query(r.queries().UserAccounts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username string
+		var realName, email, role, groups sql.NullString
+		if err := rows.Scan(&username, &realName, &email, &role, &groups); err != nil {
+			return nil, fmt.Errorf("error reading user rows: %s", err)
+		}
+		ids, err := parseGroups(groups.String)
+		if err != nil {
+			r.log().WithField("user", username).Warnf("Ignoring the groups of user %s: %s", username, err)
+		}
+		out = append(out, users.User{
+			Username: username,
+			RealName: realName.String,
+			Email:    email.String,
+			Role:     role.String,
+			Groups:   ids,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading user rows: %s", err)
+	}
+	return out, nil
+}
+
+// parseGroups parses the group IDs out of the JSON object keyed by group ID
+// that PHPIPAM stores the groups of a user in, sorted.
+func parseGroups(v string) ([]int, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var groups map[string]interface{}
+	if err := json.Unmarshal([]byte(v), &groups); err != nil {
+		return nil, fmt.Errorf("invalid groups %q: %s", v, err)
+	}
+	var out []int
+	for k := range groups {
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group ID %q", k)
+		}
+		out = append(out, id)
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// Groups reads the legacy user groups, with their legacy IDs.
+func (r *Reader) Groups() (out []users.Group, err error) {
+	rows, err := r.query(r.queries().Groups)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g users.Group
+		var description sql.NullString
+		if err := rows.Scan(&g.ID, &g.Name, &description); err != nil {
+			return nil, fmt.Errorf("error reading group rows: %s", err)
+		}
+		g.Description = description.String
+		out = append(out, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading group rows: %s", err)
+	}
+	return out, nil
+}
+
+// OwnedAddresses returns the number of legacy addresses that have an owner,
+// which is not migrated to targets other than PHPIPAM.
+func (r *Reader) OwnedAddresses() (n int, err error) {
+	query := r.queries().OwnedAddresses
+	r.log().Debugf("Running SQL query: %s []", query)
+	if err := r.DB.QueryRow(query).Scan(&n); err != nil {
+		return 0, fmt.Errorf("error running SQL query: %s", err)
+	}
+	return n, nil
+}
+
+// DecimalToIPv4 converts a decimal IPv4 address, as stored in the legacy DB,
+// to a dotted-quad string, ie: 1.2.3.4.
+func DecimalToIPv4(addr string) (string, error) {
+	d, err := strconv.ParseUint(addr, 10, 32)
+	if err != nil {
+		return "", err
+	}
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, uint32(d))
+	return ip.String(), nil
+}
+
+// IPv4ToDecimal converts a dotted-quad IPv4 address to a decimal one, as
+// stored in the legacy DB. It is the reverse of DecimalToIPv4.
+func IPv4ToDecimal(addr string) (string, error) {
+	ip := net.ParseIP(addr).To4()
+	if ip == nil {
+		return "", fmt.Errorf("%q is not an IPv4 address", addr)
+	}
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip)), 10), nil
+}
@@ -0,0 +1,743 @@
+package legacydb
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/changelog"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/requests"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/replay"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// strs returns pointers to each of the supplied strings, with a blank string
+// standing in for NULL.
+func strs(vs ...string) (out []*string) {
+	for _, v := range vs {
+		if v == "" {
+			out = append(out, nil)
+			continue
+		}
+		v := v
+		out = append(out, &v)
+	}
+	return
+}
+
+func testReader(t *testing.T, name string, queries ...*replay.Query) *Reader {
+	sql.Register(name, &replay.Driver{Bundle: &replay.Bundle{Queries: queries}})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Error opening replay DB: %s", err)
+	}
+	return &Reader{DB: db}
+}
+
+func TestReaderSubnets(t *testing.T) {
+	r := testReader(t, "legacydb-subnets", &replay.Query{
+		SQL:     "select subnets.subnet, subnets.mask, subnets.description, vlans.number from subnets left join vlans on subnets.vlanId = vlans.vlanId where subnets.sectionId = ?",
+		Args:    []string{"2"},
+		Columns: []string{"subnet", "mask", "description", "number"},
+		Rows: [][]*string{
+			strs("167772160", "8", "ten", "100"),
+			strs("42540766411282592856903984951653826560", "64", "v6", ""),
+			strs("3232235776", "24", "lan", ""),
+			strs("3232236032", "24", "", ""),
+		},
+	})
+	r.SectionID = 2
+
+	actual, skipped, err := r.Subnets()
+	if err != nil {
+		t.Fatalf("Error reading subnets: %s", err)
+	}
+	expected := []Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8, Description: "ten"}, VLANNumber: 100},
+		{Subnet: subnets.Subnet{SubnetAddress: "192.168.1.0", Mask: 24, Description: "lan"}},
+		{Subnet: subnets.Subnet{SubnetAddress: "192.168.2.0", Mask: 24}},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if skipped != 1 {
+		t.Fatalf("Expected 1 skipped subnet, got %d", skipped)
+	}
+}
+
+func TestReaderAddresses(t *testing.T) {
+	r := testReader(t, "legacydb-addresses", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.dns_name, ipaddresses.note, ipaddresses.switch, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id",
+		Columns: []string{"ip_addr", "description", "dns_name", "note", "switch", "subnet", "mask"},
+		Rows: [][]*string{
+			strs("3232235777", "gw", "gw.example.com", "n", "sw1", "3232235776", "24"),
+			strs("3232235778", "host", "host.example.com", "n", "", "3232235776", "24"),
+			strs("bad", "x", "x", "x", "", "3232235776", "24"),
+			strs("3232235779", "", "", "", "", "3232235776", "24"),
+		},
+	})
+	var skips []Skip
+	r.Skipped = func(v Skip) { skips = append(skips, v) }
+
+	actual, skipped, err := r.Addresses()
+	if err != nil {
+		t.Fatalf("Error reading addresses: %s", err)
+	}
+	expected := []Address{
+		{
+			Address:    addresses.Address{IPAddress: "192.168.1.1", Description: "gw", Hostname: "gw.example.com", Note: "n"},
+			SubnetCIDR: "192.168.1.0/24",
+			Switch:     "sw1",
+		},
+		{
+			Address:    addresses.Address{IPAddress: "192.168.1.2", Description: "host", Hostname: "host.example.com", Note: "n"},
+			SubnetCIDR: "192.168.1.0/24",
+		},
+		{
+			// NULL columns are read as blank.
+			Address:    addresses.Address{IPAddress: "192.168.1.3"},
+			SubnetCIDR: "192.168.1.0/24",
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if skipped != 1 {
+		t.Fatalf("Expected 1 skipped address, got %d", skipped)
+	}
+	if len(skips) != 1 || skips[0].Address != "bad" || skips[0].Subnet != "3232235776/24" || skips[0].Hostname != "x" {
+		t.Fatalf("Expected the bad address to be passed to Skipped, got %#v", skips)
+	}
+}
+
+func TestReaderAddressPorts(t *testing.T) {
+	r := testReader(t, "legacydb-ports", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.port from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.port is not null and ipaddresses.port != ''",
+		Columns: []string{"ip_addr", "subnet", "mask", "port"},
+		Rows: [][]*string{
+			strs("3232235777", "3232235776", "24", "Gi0/1"),
+			strs("3232235778", "", "", "Gi0/2"),
+			strs("bad", "3232235776", "24", "Gi0/3"),
+			strs("3232235779", "3232235776", "24", ""),
+		},
+	})
+
+	actual, err := r.AddressPorts()
+	if err != nil {
+		t.Fatalf("Error reading address ports: %s", err)
+	}
+	expected := map[AddressKey]string{{IPAddress: "192.168.1.1", SubnetCIDR: "192.168.1.0/24"}: "Gi0/1"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderAddressStates(t *testing.T) {
+	r := testReader(t, "legacydb-states", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.state from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.state is not null and ipaddresses.state != ''",
+		Columns: []string{"ip_addr", "subnet", "mask", "state"},
+		Rows: [][]*string{
+			strs("3232235777", "3232235776", "24", "2"),
+			strs("3232235778", "3232235776", "24", " 0 "),
+			strs("3232235779", "", "", "1"),
+			strs("bad", "3232235776", "24", "3"),
+		},
+	})
+
+	actual, err := r.AddressStates()
+	if err != nil {
+		t.Fatalf("Error reading address states: %s", err)
+	}
+	expected := map[AddressKey]string{
+		{IPAddress: "192.168.1.1", SubnetCIDR: "192.168.1.0/24"}: "2",
+		{IPAddress: "192.168.1.2", SubnetCIDR: "192.168.1.0/24"}: "0",
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderAddressMACs(t *testing.T) {
+	r := testReader(t, "legacydb-macs", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.mac from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.mac is not null and ipaddresses.mac != ''",
+		Columns: []string{"ip_addr", "subnet", "mask", "mac"},
+		Rows: [][]*string{
+			strs("3232235777", "3232235776", "24", "00-1A-2B-3C-4D-5E"),
+			strs("3232235778", "", "", "00:1a:2b:3c:4d:5f"),
+		},
+	})
+
+	actual, err := r.AddressMACs()
+	if err != nil {
+		t.Fatalf("Error reading address MACs: %s", err)
+	}
+	expected := map[AddressKey]string{{IPAddress: "192.168.1.1", SubnetCIDR: "192.168.1.0/24"}: "00-1A-2B-3C-4D-5E"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderAddressOwners(t *testing.T) {
+	r := testReader(t, "legacydb-owners", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.owner from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.owner is not null and ipaddresses.owner != ''",
+		Columns: []string{"ip_addr", "subnet", "mask", "owner"},
+		Rows: [][]*string{
+			strs("3232235777", "3232235776", "24", "netops"),
+			strs("3232235778", "", "", "orphaned"),
+		},
+	})
+
+	actual, err := r.AddressOwners()
+	if err != nil {
+		t.Fatalf("Error reading address owners: %s", err)
+	}
+	expected := map[AddressKey]string{{IPAddress: "192.168.1.1", SubnetCIDR: "192.168.1.0/24"}: "netops"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderAddressFlags(t *testing.T) {
+	r := testReader(t, "legacydb-flags", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.excludePing from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.excludePing is not null and ipaddresses.excludePing != ''",
+		Columns: []string{"ip_addr", "subnet", "mask", "excludePing"},
+		Rows: [][]*string{
+			strs("3232235777", "3232235776", "24", "1"),
+			strs("3232235778", "3232235776", "24", "0"),
+		},
+	}, &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.PTRignore from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.PTRignore is not null and ipaddresses.PTRignore != ''",
+		Columns: []string{"ip_addr", "subnet", "mask", "PTRignore"},
+		Rows: [][]*string{
+			strs("3232235778", "3232235776", "24", "1"),
+		},
+	})
+
+	excluded, err := r.PingExcludedAddresses()
+	if err != nil {
+		t.Fatalf("Error reading ping excluded addresses: %s", err)
+	}
+	if expected := map[AddressKey]bool{{IPAddress: "192.168.1.1", SubnetCIDR: "192.168.1.0/24"}: true}; !reflect.DeepEqual(expected, excluded) {
+		t.Fatalf("Expected %v, got %v", expected, excluded)
+	}
+	ignored, err := r.PTRIgnoredAddresses()
+	if err != nil {
+		t.Fatalf("Error reading PTR ignored addresses: %s", err)
+	}
+	if expected := map[AddressKey]bool{{IPAddress: "192.168.1.2", SubnetCIDR: "192.168.1.0/24"}: true}; !reflect.DeepEqual(expected, ignored) {
+		t.Fatalf("Expected %v, got %v", expected, ignored)
+	}
+}
+
+func TestReaderAddressTimes(t *testing.T) {
+	r := testReader(t, "legacydb-times", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.lastSeen from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.lastSeen is not null",
+		Columns: []string{"ip_addr", "subnet", "mask", "lastSeen"},
+		Rows: [][]*string{
+			strs("3232235777", "3232235776", "24", "2016-03-01 12:30:00"),
+			strs("3232235778", "3232235776", "24", "0000-00-00 00:00:00"),
+		},
+	}, &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.editDate from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.editDate is not null",
+		Columns: []string{"ip_addr", "subnet", "mask", "editDate"},
+		Rows: [][]*string{
+			strs("3232235778", "3232235776", "24", "2015-11-20 08:00:00"),
+		},
+	})
+
+	seen, err := r.AddressLastSeen()
+	if err != nil {
+		t.Fatalf("Error reading address last seen times: %s", err)
+	}
+	if expected := map[AddressKey]string{{IPAddress: "192.168.1.1", SubnetCIDR: "192.168.1.0/24"}: "2016-03-01 12:30:00"}; !reflect.DeepEqual(expected, seen) {
+		t.Fatalf("Expected %v, got %v", expected, seen)
+	}
+	edited, err := r.AddressEditDates()
+	if err != nil {
+		t.Fatalf("Error reading address edit dates: %s", err)
+	}
+	if expected := map[AddressKey]string{{IPAddress: "192.168.1.2", SubnetCIDR: "192.168.1.0/24"}: "2015-11-20 08:00:00"}; !reflect.DeepEqual(expected, edited) {
+		t.Fatalf("Expected %v, got %v", expected, edited)
+	}
+}
+
+func TestReaderVLANCustomFields(t *testing.T) {
+	r := testReader(t, "legacydb-vlan-custom-fields", &replay.Query{
+		SQL:     "select number, site from vlans",
+		Columns: []string{"number", "site"},
+		Rows:    [][]*string{strs("100", "yvr"), strs("200", "")},
+	})
+	m := &Mapping{CustomFields: map[string]map[string]string{"vlans": {"site": "custom_Site"}}}
+	r.Queries = m.BuildQueries()
+	_, fields := m.CustomFieldColumns("vlans")
+
+	actual, err := r.VLANCustomFields(fields)
+	if err != nil {
+		t.Fatalf("Error reading VLAN custom fields: %s", err)
+	}
+	if expected := map[int]map[string]string{100: {"custom_Site": "yvr"}}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderAddressCustomFields(t *testing.T) {
+	r := testReader(t, "legacydb-custom-fields", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.cust_ref, ipaddresses.rack from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id",
+		Columns: []string{"ip_addr", "subnet", "mask", "cust_ref", "rack"},
+		Rows: [][]*string{
+			strs("3232235777", "3232235776", "24", "C-9", "R1"),
+			strs("3232235778", "3232235776", "24", "", "R2"),
+			strs("3232235779", "3232235776", "24", "", ""),
+			strs("3232235780", "", "", "C-1", "R3"),
+		},
+	})
+	m := &Mapping{CustomFields: map[string]map[string]string{"ipaddresses": {"rack": "custom_Rack", "cust_ref": "custom_Customer"}}}
+	r.Queries = m.BuildQueries()
+	_, fields := m.CustomFieldColumns("ipaddresses")
+
+	actual, err := r.AddressCustomFields(fields)
+	if err != nil {
+		t.Fatalf("Error reading address custom fields: %s", err)
+	}
+	expected := map[AddressKey]map[string]string{
+		{IPAddress: "192.168.1.1", SubnetCIDR: "192.168.1.0/24"}: {"custom_Customer": "C-9", "custom_Rack": "R1"},
+		{IPAddress: "192.168.1.2", SubnetCIDR: "192.168.1.0/24"}: {"custom_Rack": "R2"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderRequests(t *testing.T) {
+	r := testReader(t, "legacydb-requests", &replay.Query{
+		SQL:     "select requests.ip_addr, subnets.subnet, subnets.mask, requests.description, requests.dns_name, requests.owner, requests.requester, requests.comment from requests left join subnets on requests.subnetId=subnets.id where requests.processed = 0",
+		Columns: []string{"ip_addr", "subnet", "mask", "description", "dns_name", "owner", "requester", "comment"},
+		Rows: [][]*string{
+			strs("3232235786", "3232235776", "24", "web", "web.example.com", "ops", "jo@example.com", "asap"),
+			strs("", "3232235776", "24", "", "", "", "sam@example.com", ""),
+			strs("3232235787", "", "", "gone", "", "", "", ""),
+		},
+	})
+	var skips []Skip
+	r.Skipped = func(v Skip) { skips = append(skips, v) }
+
+	actual, skipped, err := r.Requests()
+	if err != nil {
+		t.Fatalf("Error reading requests: %s", err)
+	}
+	expected := []Request{
+		{Request: requests.Request{IPAddress: "192.168.1.10", Description: "web", Hostname: "web.example.com", Owner: "ops", Requester: "jo@example.com", Comment: "asap"}, SubnetCIDR: "192.168.1.0/24"},
+		{Request: requests.Request{Requester: "sam@example.com"}, SubnetCIDR: "192.168.1.0/24"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if skipped != 1 || len(skips) != 1 || skips[0].Kind != "request" || skips[0].Reason != "subnet does not exist" {
+		t.Fatalf("Expected the request without a subnet to be skipped, got %d %#v", skipped, skips)
+	}
+}
+
+func TestReaderChangelog(t *testing.T) {
+	r := testReader(t, "legacydb-changelog", &replay.Query{
+		SQL:     "select changelog.caction, changelog.cresult, changelog.cdate, changelog.cdiff, users.username, subnets.subnet, subnets.mask from changelog left join subnets on changelog.coid=subnets.id left join users on changelog.cuser=users.id where changelog.ctype = 'subnet'",
+		Columns: []string{"caction", "cresult", "cdate", "cdiff", "username", "subnet", "mask"},
+		Rows: [][]*string{
+			strs("edit", "success", "2015-03-02 10:00:00", "[description] old => new", "jo", "3232235776", "24"),
+			strs("delete", "success", "2015-01-01 09:00:00", "", "jo", "", ""),
+		},
+	}, &replay.Query{
+		SQL:     "select changelog.caction, changelog.cresult, changelog.cdate, changelog.cdiff, users.username, ipaddresses.ip_addr, subnets.subnet, subnets.mask from changelog left join ipaddresses on changelog.coid=ipaddresses.id left join subnets on ipaddresses.subnetId=subnets.id left join users on changelog.cuser=users.id where changelog.ctype = 'ip_addr'",
+		Columns: []string{"caction", "cresult", "cdate", "cdiff", "username", "ip_addr", "subnet", "mask"},
+		Rows: [][]*string{
+			strs("add", "success", "2015-02-01 08:30:00", "", "", "3232235786", "3232235776", "24"),
+			strs("edit", "success", "2015-02-03 08:30:00", "", "jo", "", "", ""),
+		},
+	})
+	var skips []Skip
+	r.Skipped = func(v Skip) { skips = append(skips, v) }
+
+	actual, skipped, err := r.Changelog()
+	if err != nil {
+		t.Fatalf("Error reading changelog: %s", err)
+	}
+	expected := []Change{
+		{Entry: changelog.Entry{Type: "ip_addr", IPAddress: "192.168.1.10", Action: "add", Result: "success", Date: "2015-02-01 08:30:00"}, SubnetCIDR: "192.168.1.0/24"},
+		{Entry: changelog.Entry{Type: "subnet", Username: "jo", Action: "edit", Result: "success", Date: "2015-03-02 10:00:00", Diff: "[description] old => new"}, SubnetCIDR: "192.168.1.0/24"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if skipped != 2 || len(skips) != 2 || skips[0].Reason != "subnet does not exist" || skips[1].Reason != "address does not exist" {
+		t.Fatalf("Expected the entries about deleted objects to be skipped, got %d %#v", skipped, skips)
+	}
+}
+
+func TestReaderUserAccounts(t *testing.T) {
+	r := testReader(t, "legacydb-users", &replay.Query{
+		SQL:     "select users.username, users.real_name, users.email, users.role, users.groups from users order by users.username",
+		Columns: []string{"username", "real_name", "email", "role", "groups"},
+		Rows: [][]*string{
+			strs("admin", "Admin", "admin@example.com", "Administrator", ""),
+			strs("jo", "Jo", "", "User", `{"3":"3","2":"2"}`),
+			strs("sam", "", "", "User", "not json"),
+		},
+	}, &replay.Query{
+		SQL:     "select userGroups.g_id, userGroups.g_name, userGroups.g_desc from userGroups order by userGroups.g_id",
+		Columns: []string{"g_id", "g_name", "g_desc"},
+		Rows:    [][]*string{strs("2", "Operators", "Ops"), strs("3", "Guests", "")},
+	})
+
+	actual, err := r.UserAccounts()
+	if err != nil {
+		t.Fatalf("Error reading users: %s", err)
+	}
+	expected := []users.User{
+		{Username: "admin", RealName: "Admin", Email: "admin@example.com", Role: "Administrator"},
+		{Username: "jo", RealName: "Jo", Role: "User", Groups: []int{2, 3}},
+		{Username: "sam", Role: "User"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+
+	groups, err := r.Groups()
+	if err != nil {
+		t.Fatalf("Error reading groups: %s", err)
+	}
+	if expected := []users.Group{{ID: 2, Name: "Operators", Description: "Ops"}, {ID: 3, Name: "Guests"}}; !reflect.DeepEqual(expected, groups) {
+		t.Fatalf("Expected %#v, got %#v", expected, groups)
+	}
+}
+
+func TestReaderVLANs(t *testing.T) {
+	r := testReader(t, "legacydb-vlans", &replay.Query{
+		SQL:     "select name, number, description from vlans",
+		Columns: []string{"name", "number", "description"},
+		Rows:    [][]*string{strs("servers", "100", "Servers"), strs("", "200", "")},
+	})
+
+	actual, err := r.VLANs()
+	if err != nil {
+		t.Fatalf("Error reading VLANs: %s", err)
+	}
+	expected := []VLAN{
+		{VLAN: vlans.VLAN{Name: "servers", Number: 100, Description: "Servers"}},
+		{VLAN: vlans.VLAN{Number: 200}},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestReaderSwitches(t *testing.T) {
+	r := testReader(t, "legacydb-switches", &replay.Query{
+		SQL:     "select ipaddresses.switch, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.switch is not null and ipaddresses.switch != ''",
+		Columns: []string{"switch", "subnet", "mask"},
+		Rows:    [][]*string{strs("sw1", "3232235776", "24"), strs("sw2", "", "")},
+	})
+
+	actual, err := r.Switches()
+	if err != nil {
+		t.Fatalf("Error reading switches: %s", err)
+	}
+	if len(actual) != 1 {
+		t.Fatalf("Expected only the switch on an existing subnet, got %#v", actual)
+	}
+}
+
+func TestReaderOrphanSubnets(t *testing.T) {
+	r := testReader(t, "legacydb-orphan-subnets", &replay.Query{
+		SQL:     "select subnets.subnet, subnets.mask, subnets.description, subnets.sectionId from subnets left join sections on subnets.sectionId=sections.id where sections.id is null",
+		Columns: []string{"subnet", "mask", "description", "sectionId"},
+		Rows: [][]*string{
+			strs("3232235776", "24", "lost", "9"),
+			strs("167772160", "8", "", ""),
+		},
+	})
+
+	actual, err := r.OrphanSubnets()
+	if err != nil {
+		t.Fatalf("Error reading orphan subnets: %s", err)
+	}
+	expected := []Skip{
+		{Kind: "subnet", Address: "3232235776", Subnet: "24", Description: "lost", Reason: "section ID 9 does not exist"},
+		{Kind: "subnet", Address: "167772160", Subnet: "8", Reason: "subnet has no section"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestReaderOrphanAddresses(t *testing.T) {
+	r := testReader(t, "legacydb-orphans", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.dns_name, ipaddresses.subnetId from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where subnets.id is null",
+		Columns: []string{"ip_addr", "description", "dns_name", "subnetId"},
+		Rows: [][]*string{
+			strs("3232235777", "gw", "gw.example.com", "7"),
+			strs("3232235778", "", "", ""),
+		},
+	})
+	var actual []Skip
+	r.Skipped = func(v Skip) { actual = append(actual, v) }
+
+	n, err := r.OrphanAddresses()
+	if err != nil {
+		t.Fatalf("Error reading orphan addresses: %s", err)
+	}
+	expected := []Skip{
+		{Kind: "address", Address: "3232235777", Subnet: "ID 7", Description: "gw", Hostname: "gw.example.com", Reason: "subnet ID 7 does not exist"},
+		{Kind: "address", Address: "3232235778", Reason: "address has no subnet"},
+	}
+	if n != 2 || !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected 2 orphans %#v, got %d %#v", expected, n, actual)
+	}
+}
+
+func TestReaderVRFs(t *testing.T) {
+	r := testReader(t, "legacydb-vrfs", &replay.Query{
+		SQL:     "select name, rd, description from vrf",
+		Columns: []string{"name", "rd", "description"},
+		Rows:    [][]*string{strs("customers", "65000:1", ""), strs("mgmt", "", "management")},
+	}, &replay.Query{
+		SQL:     "select subnets.subnet, subnets.mask, vrf.name from subnets left join vrf on subnets.vrfId = vrf.vrfId where vrf.name is not null and subnets.sectionId = ?",
+		Args:    []string{"2"},
+		Columns: []string{"subnet", "mask", "name"},
+		Rows: [][]*string{
+			strs("167772160", "8", "customers"),
+			strs("42540766411282592856903984951653826560", "64", "customers"),
+		},
+	})
+	r.SectionID = 2
+
+	actual, err := r.VRFs()
+	if err != nil {
+		t.Fatalf("Error reading VRFs: %s", err)
+	}
+	expected := []vrfs.VRF{{Name: "customers", RD: "65000:1"}, {Name: "mgmt", Description: "management"}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	names, err := r.SubnetVRFs()
+	if err != nil {
+		t.Fatalf("Error reading subnet VRFs: %s", err)
+	}
+	if expected := map[string]string{"10.0.0.0/8": "customers"}; !reflect.DeepEqual(expected, names) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+}
+
+func TestReaderNameservers(t *testing.T) {
+	r := testReader(t, "legacydb-nameservers", &replay.Query{
+		SQL:     "select name, namesrv1, description from nameservers",
+		Columns: []string{"name", "namesrv1", "description"},
+		Rows:    [][]*string{strs("Google NS", "8.8.8.8;8.8.4.4", "Google public nameservers"), strs("internal", "10.0.0.53", "")},
+	}, &replay.Query{
+		SQL:     "select subnets.subnet, subnets.mask, nameservers.name from subnets left join nameservers on subnets.nameserverId = nameservers.id where nameservers.name is not null and subnets.sectionId = ?",
+		Args:    []string{"2"},
+		Columns: []string{"subnet", "mask", "name"},
+		Rows: [][]*string{
+			strs("167772160", "8", "internal"),
+			strs("42540766411282592856903984951653826560", "64", "internal"),
+		},
+	})
+	r.SectionID = 2
+
+	actual, err := r.Nameservers()
+	if err != nil {
+		t.Fatalf("Error reading nameservers: %s", err)
+	}
+	expected := []nameservers.Nameserver{
+		{Name: "Google NS", Addresses: "8.8.8.8;8.8.4.4", Description: "Google public nameservers"},
+		{Name: "internal", Addresses: "10.0.0.53"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	names, err := r.SubnetNameservers()
+	if err != nil {
+		t.Fatalf("Error reading subnet nameservers: %s", err)
+	}
+	if expected := map[string]string{"10.0.0.0/8": "internal"}; !reflect.DeepEqual(expected, names) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+}
+
+func TestReaderSectionVLANs(t *testing.T) {
+	r := testReader(t, "legacydb-section-vlans", &replay.Query{
+		SQL:     "select vlans.number from subnets left join vlans on subnets.vlanId = vlans.vlanId where vlans.number is not null and subnets.sectionId = ?",
+		Args:    []string{"3"},
+		Columns: []string{"number"},
+		Rows:    [][]*string{strs("200"), strs("100"), strs("200")},
+	})
+	r.SectionID = 3
+
+	actual, err := r.SectionVLANs()
+	if err != nil {
+		t.Fatalf("Error reading section VLANs: %s", err)
+	}
+	if expected := []int{100, 200}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderSectionMasters(t *testing.T) {
+	r := testReader(t, "legacydb-section-masters", &replay.Query{
+		SQL:     "select id, masterSection from sections",
+		Columns: []string{"id", "masterSection"},
+		Rows:    [][]*string{strs("1", "0"), strs("2", ""), strs("3", "1")},
+	})
+
+	actual, err := r.SectionMasters()
+	if err != nil {
+		t.Fatalf("Error reading section masters: %s", err)
+	}
+	if expected := map[int]int{3: 1}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderSectionIDs(t *testing.T) {
+	r := testReader(t, "legacydb-section-ids", &replay.Query{
+		SQL:     "select id, masterSection from sections",
+		Columns: []string{"id", "masterSection"},
+		Rows:    [][]*string{strs("3", "1"), strs("1", "0"), strs("2", "")},
+	})
+
+	actual, err := r.SectionIDs()
+	if err != nil {
+		t.Fatalf("Error reading section IDs: %s", err)
+	}
+	if expected := []int{1, 2, 3}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderDuplicateVLANs(t *testing.T) {
+	r := testReader(t, "legacydb-duplicate-vlans", &replay.Query{
+		SQL:     "select vlans.vlanId, vlans.name, vlans.number, vlans.description from vlans order by vlans.vlanId",
+		Columns: []string{"vlanId", "name", "number", "description"},
+		Rows: [][]*string{
+			strs("1", "servers", "200", "Servers"),
+			strs("2", "office", "100", ""),
+			strs("3", "lab", "300", ""),
+			strs("4", "storage", "100", "Storage"),
+			strs("5", "dmz", "200", ""),
+		},
+	})
+
+	actual, err := r.DuplicateVLANs()
+	if err != nil {
+		t.Fatalf("Error reading duplicate VLANs: %s", err)
+	}
+	expected := []VLAN{
+		{VLAN: vlans.VLAN{Name: "office", Number: 100}, LegacyID: 2},
+		{VLAN: vlans.VLAN{Name: "storage", Number: 100, Description: "Storage"}, LegacyID: 4},
+		{VLAN: vlans.VLAN{Name: "servers", Number: 200, Description: "Servers"}, LegacyID: 1},
+		{VLAN: vlans.VLAN{Name: "dmz", Number: 200}, LegacyID: 5},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestReaderSubnetVLANIDs(t *testing.T) {
+	r := testReader(t, "legacydb-subnet-vlan-ids", &replay.Query{
+		SQL:     "select subnets.subnet, subnets.mask, subnets.vlanId from subnets where subnets.vlanId is not null and subnets.vlanId != 0 and subnets.sectionId = ?",
+		Args:    []string{"3"},
+		Columns: []string{"subnet", "mask", "vlanId"},
+		Rows:    [][]*string{strs("167772160", "24", "4"), strs("42540766411282592856903984951653826560", "64", "2")},
+	})
+	r.SectionID = 3
+
+	actual, err := r.SubnetVLANIDs()
+	if err != nil {
+		t.Fatalf("Error reading subnet VLAN IDs: %s", err)
+	}
+	if expected := map[string]int{"10.0.0.0/24": 4}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReaderLegacyIDs(t *testing.T) {
+	r := testReader(t, "legacydb-vlan-ids", &replay.Query{
+		SQL:     "select number, vlanId from vlans order by vlanId",
+		Columns: []string{"number", "vlanId"},
+		Rows:    [][]*string{strs("200", "1"), strs("100", "2"), strs("200", "5")},
+	})
+	vlanIDs, err := r.VLANIDs()
+	if err != nil {
+		t.Fatalf("Error reading VLAN IDs: %s", err)
+	}
+	if expected := map[int][]int{100: {2}, 200: {1, 5}}; !reflect.DeepEqual(expected, vlanIDs) {
+		t.Fatalf("Expected %v, got %v", expected, vlanIDs)
+	}
+
+	r = testReader(t, "legacydb-subnet-ids", &replay.Query{
+		SQL:     "select subnets.subnet, subnets.mask, subnets.id from subnets where subnets.sectionId = ?",
+		Args:    []string{"3"},
+		Columns: []string{"subnet", "mask", "id"},
+		Rows:    [][]*string{strs("167772160", "24", "7"), strs("42540766411282592856903984951653826560", "64", "8")},
+	})
+	r.SectionID = 3
+	subnetIDs, err := r.SubnetIDs()
+	if err != nil {
+		t.Fatalf("Error reading subnet IDs: %s", err)
+	}
+	if expected := map[string]string{"10.0.0.0/24": "7"}; !reflect.DeepEqual(expected, subnetIDs) {
+		t.Fatalf("Expected %v, got %v", expected, subnetIDs)
+	}
+
+	r = testReader(t, "legacydb-address-ids", &replay.Query{
+		SQL:     "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.id from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.id is not null",
+		Columns: []string{"ip_addr", "subnet", "mask", "id"},
+		Rows:    [][]*string{strs("167772161", "167772160", "24", "12"), strs("167772162", "", "", "13")},
+	})
+	addressIDs, err := r.AddressIDs()
+	if err != nil {
+		t.Fatalf("Error reading address IDs: %s", err)
+	}
+	if expected := map[AddressKey]string{{IPAddress: "10.0.0.1", SubnetCIDR: "10.0.0.0/24"}: "12"}; !reflect.DeepEqual(expected, addressIDs) {
+		t.Fatalf("Expected %v, got %v", expected, addressIDs)
+	}
+}
+
+func TestReaderQueryError(t *testing.T) {
+	r := testReader(t, "legacydb-error", &replay.Query{
+		SQL:   "select name, number, description from vlans",
+		Error: "table vlans does not exist",
+	})
+	if _, err := r.VLANs(); err == nil {
+		t.Fatal("Expected error reading VLANs, got none")
+	}
+}
+
+func TestDecimalToIPv4(t *testing.T) {
+	actual, err := DecimalToIPv4("3232235777")
+	if err != nil {
+		t.Fatalf("Error converting address: %s", err)
+	}
+	if actual != "192.168.1.1" {
+		t.Fatalf("Expected 192.168.1.1, got %s", actual)
+	}
+	if _, err := DecimalToIPv4("42540766411282592856903984951653826560"); err == nil {
+		t.Fatal("Expected error converting IPv6 address, got none")
+	}
+}
+
+func TestIPv4ToDecimal(t *testing.T) {
+	actual, err := IPv4ToDecimal("192.168.1.1")
+	if err != nil {
+		t.Fatalf("Error converting address: %s", err)
+	}
+	if actual != "3232235777" {
+		t.Fatalf("Expected 3232235777, got %s", actual)
+	}
+	if _, err := IPv4ToDecimal("2001:db8::1"); err == nil {
+		t.Fatal("Expected error converting IPv6 address, got none")
+	}
+}
@@ -0,0 +1,558 @@
+package legacydb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Queries are the SQL queries that a Reader runs against the legacy DB. Each
+// query must return the columns described, in order.
+type Queries struct {
+	// VLANs returns the name, number, and description of each VLAN.
+	VLANs string `yaml:"vlans"`
+
+	// Subnets returns the decimal address, mask, description, and VLAN number
+	// (or NULL) of each subnet.
+	Subnets string `yaml:"subnets"`
+
+	// Switches returns the switch name, and the decimal address and mask of the
+	// subnet, of each address that references a switch.
+	Switches string `yaml:"switches"`
+
+	// Devices returns the hostname, IP address, and description of each
+	// device in the switch table, followed by the name of its type if device
+	// types are read. It is blank, and not run, unless the switch names are
+	// read from a switch table.
+	Devices string `yaml:"devices"`
+
+	// DeviceTypes returns the name and description of each device type. It is
+	// blank, and not run, unless device types are read from a device type
+	// table.
+	DeviceTypes string `yaml:"device_types"`
+
+	// Addresses returns the decimal address, description, hostname, note,
+	// switch name (or NULL), and decimal subnet address and mask of each
+	// address.
+	Addresses string `yaml:"addresses"`
+
+	// SectionColumn is the column holding the section ID of the subnets in the
+	// Subnets and Addresses queries. A condition on it is added to the queries
+	// to read a single section.
+	SectionColumn string `yaml:"section_column"`
+
+	// Users returns the username of each user.
+	Users string `yaml:"users"`
+
+	// OwnedAddresses returns the number of addresses that have an owner.
+	OwnedAddresses string `yaml:"owned_addresses"`
+
+	// OrphanAddresses returns the decimal address, description, hostname,
+	// and subnet ID of each address whose subnet does not exist.
+	OrphanAddresses string `yaml:"orphan_addresses"`
+
+	// OrphanSubnets returns the decimal address, mask, description, and
+	// section ID of each subnet whose section does not exist.
+	OrphanSubnets string `yaml:"orphan_subnets"`
+
+	// AddressPorts returns the decimal address, the decimal address and mask
+	// of the subnet, and the switch port of each address with a port. The
+	// section condition is added to it as with Addresses.
+	AddressPorts string `yaml:"address_ports"`
+
+	// AddressStates returns the decimal address, the decimal address and mask
+	// of the subnet, and the state (ie: 1 for active) of each address with a
+	// state. The section condition is added to it as with Addresses.
+	AddressStates string `yaml:"address_states"`
+
+	// AddressMACs returns the decimal address, the decimal address and mask
+	// of the subnet, and the MAC address of each address with one. The
+	// section condition is added to it as with Addresses.
+	AddressMACs string `yaml:"address_macs"`
+
+	// AddressOwners returns the decimal address, the decimal address and
+	// mask of the subnet, and the owner of each address with one. The section
+	// condition is added to it as with Addresses.
+	AddressOwners string `yaml:"address_owners"`
+
+	// AddressExcludePing returns the decimal address, the decimal address
+	// and mask of the subnet, and the exclude from ping flag of each address
+	// with one. The section condition is added to it as with Addresses.
+	AddressExcludePing string `yaml:"address_exclude_ping"`
+
+	// AddressPTRIgnore returns the decimal address, the decimal address and
+	// mask of the subnet, and the PTR ignore flag of each address with one.
+	// The section condition is added to it as with Addresses.
+	AddressPTRIgnore string `yaml:"address_ptr_ignore"`
+
+	// AddressLastSeen returns the decimal address, the decimal address and
+	// mask of the subnet, and the time that each address with one was last
+	// seen alive. The section condition is added to it as with Addresses.
+	AddressLastSeen string `yaml:"address_last_seen"`
+
+	// AddressEditDates returns the decimal address, the decimal address and
+	// mask of the subnet, and the time that each address with one was last
+	// edited. The section condition is added to it as with Addresses.
+	AddressEditDates string `yaml:"address_edit_dates"`
+
+	// VRFs returns the name, route distinguisher, and description of each
+	// VRF.
+	VRFs string `yaml:"vrfs"`
+
+	// SubnetVRFs returns the decimal address and mask, and VRF name, of each
+	// subnet that belongs to a VRF. The section condition is added to it as
+	// with Subnets.
+	SubnetVRFs string `yaml:"subnet_vrfs"`
+
+	// SectionVLANs returns the number of the VLAN of each subnet that has one.
+	// The section condition is added to it as with Subnets.
+	SectionVLANs string `yaml:"section_vlans"`
+
+	// SectionMasters returns the ID and master section ID of each section.
+	SectionMasters string `yaml:"section_masters"`
+
+	// DuplicateVLANs returns the ID, name, number, and description of each
+	// VLAN, ordered by ID, from which the VLANs whose numbers are used more
+	// than once are picked.
+	DuplicateVLANs string `yaml:"duplicate_vlans"`
+
+	// SubnetVLANIDs returns the decimal address and mask, and legacy VLAN ID,
+	// of each subnet that has a VLAN. The section condition is added to it as
+	// with Subnets.
+	SubnetVLANIDs string `yaml:"subnet_vlan_ids"`
+
+	// VLANIDs returns the number and ID of each VLAN, ordered by ID.
+	VLANIDs string `yaml:"vlan_ids"`
+
+	// SubnetIDs returns the decimal address and mask, and ID, of each subnet.
+	// The section condition is added to it as with Subnets.
+	SubnetIDs string `yaml:"subnet_ids"`
+
+	// AddressIDs returns the decimal address, the decimal address and mask of
+	// the subnet, and the ID of each address. The section condition is added
+	// to it as with Addresses.
+	AddressIDs string `yaml:"address_ids"`
+
+	// Nameservers returns the name, semicolon-separated nameserver addresses,
+	// and description of each nameserver set.
+	Nameservers string `yaml:"nameservers"`
+
+	// SubnetNameservers returns the decimal address and mask, and nameserver
+	// set name, of each subnet that uses a nameserver set. The section
+	// condition is added to it as with Subnets.
+	SubnetNameservers string `yaml:"subnet_nameservers"`
+
+	// Requests returns the decimal address (or NULL), the decimal address and
+	// mask of the subnet, and the description, hostname, owner, requester,
+	// and comment of each IP request that has not been processed. The section
+	// condition is added to it as with Addresses.
+	Requests string `yaml:"requests"`
+
+	// UserAccounts returns the username, full name, email address, role, and
+	// groups (a JSON object keyed by group ID) of each user.
+	UserAccounts string `yaml:"user_accounts"`
+
+	// Groups returns the ID, name, and description of each user group.
+	Groups string `yaml:"groups"`
+
+	// SubnetChangelog returns the action, result, date, differences, and
+	// username of each changelog entry about a subnet, and the decimal address
+	// and mask of the subnet. The section condition is added to it as with
+	// Subnets.
+	SubnetChangelog string `yaml:"subnet_changelog"`
+
+	// AddressChangelog returns the action, result, date, differences, and
+	// username of each changelog entry about an address, and the decimal
+	// address, and the decimal address and mask of its subnet. The section
+	// condition is added to it as with Addresses.
+	AddressChangelog string `yaml:"address_changelog"`
+
+	// AddressCustomFields returns the decimal address, the decimal address
+	// and mask of the subnet, and the values of the custom columns mapped to
+	// custom fields, ordered by column name, of each address. The section
+	// condition is added to it as with Addresses. It is blank if no custom
+	// columns of the ipaddresses table are mapped.
+	AddressCustomFields string `yaml:"address_custom_fields"`
+
+	// VLANCustomFields returns the number and the values of the custom
+	// columns mapped to custom fields, ordered by column name, of each VLAN.
+	// It is blank if no custom columns of the vlans table are mapped.
+	VLANCustomFields string `yaml:"vlan_custom_fields"`
+}
+
+// Mapping maps the tables and columns of the legacy DB that are read to their
+// names in a customized legacy schema. The queries built from the names can
+// also be replaced outright, for schemas that differ too much to be renamed.
+type Mapping struct {
+	// The names of renamed tables, keyed by their standard name.
+	Tables map[string]string `yaml:"tables"`
+
+	// The names of renamed columns, keyed by their standard name qualified
+	// with their table's standard name (ie: ipaddresses.dns_name).
+	Columns map[string]string `yaml:"columns"`
+
+	// The prefix of the names of the tables that are not renamed (ie: ipam_).
+	TablePrefix string `yaml:"table_prefix"`
+
+	// The table of switches that the ipaddresses switch column references by
+	// ID, as in the schemas of PHPIPAM 0.9 and later, where the switch names
+	// are read from the table's hostname column. Blank if the switch column
+	// holds the switch name, as in 0.8.
+	SwitchTable string `yaml:"switch_table"`
+
+	// The table of device types that the switch table's type column
+	// references by ID, as in the schemas of PHPIPAM 1.0 and later, where the
+	// type names are read from the table's tname column. Blank if device types
+	// are not read. This is only used along with SwitchTable.
+	DeviceTypeTable string `yaml:"device_type_table"`
+
+	// The custom columns to read, keyed by the standard name of their table
+	// and then by column name, with the names of the custom fields in the new
+	// PHPIPAM instance that their values are written to. Only the custom
+	// columns of the tables in customFieldTables can be read.
+	CustomFields map[string]map[string]string `yaml:"custom_fields"`
+
+	// The queries to run instead of those built from the names. Blank queries
+	// are built as usual.
+	Queries Queries `yaml:"queries"`
+}
+
+// customFieldTables are the standard tables whose custom columns can be
+// mapped to custom fields.
+var customFieldTables = map[string]bool{"ipaddresses": true, "vlans": true}
+
+// identifierRegexp matches the names of custom columns and fields, which are
+// written into queries and statements unquoted.
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// standardColumns are the columns that are read from each standard table.
+var standardColumns = map[string][]string{
+	"vlans":       {"vlanId", "name", "number", "description"},
+	"subnets":     {"id", "subnet", "mask", "sectionId", "description", "vlanId", "vrfId", "nameserverId"},
+	"ipaddresses": {"id", "subnetId", "ip_addr", "description", "dns_name", "owner", "switch", "port", "note", "state", "mac", "excludePing", "PTRignore", "lastSeen", "editDate"},
+	"users":       {"id", "username", "real_name", "email", "role", "groups"},
+	"userGroups":  {"g_id", "g_name", "g_desc"},
+	"vrf":         {"vrfId", "name", "rd", "description"},
+	"nameservers": {"id", "name", "namesrv1", "description"},
+	"requests":    {"subnetId", "ip_addr", "description", "dns_name", "owner", "requester", "comment", "processed"},
+	"changelog":   {"ctype", "coid", "cuser", "caction", "cresult", "cdate", "cdiff"},
+}
+
+// LoadMapping reads and checks the YAML mapping file at path. Unknown keys,
+// tables, and columns are treated as errors, so that typos do not go
+// unnoticed.
+func LoadMapping(path string) (*Mapping, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Mapping
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, fmt.Errorf("error parsing mapping file %s: %s", path, err)
+	}
+	if err := m.check(); err != nil {
+		return nil, fmt.Errorf("invalid mapping file %s: %s", path, err)
+	}
+	return &m, nil
+}
+
+// check returns an error if the mapping renames unknown tables or columns.
+func (m *Mapping) check() error {
+	for _, k := range sortedKeys(m.Tables) {
+		if _, ok := standardColumns[k]; !ok {
+			return fmt.Errorf("unknown table %s", k)
+		}
+	}
+	for _, k := range sortedKeys(m.Columns) {
+		parts := strings.SplitN(k, ".", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("column %s must be qualified with its table (ie: ipaddresses.%s)", k, k)
+		}
+		found := false
+		for _, v := range standardColumns[parts[0]] {
+			found = found || v == parts[1]
+		}
+		if !found {
+			return fmt.Errorf("unknown column %s", k)
+		}
+	}
+	for _, table := range sortedCustomFieldTables(m.CustomFields) {
+		if !customFieldTables[table] {
+			return fmt.Errorf("custom fields cannot be read from table %s", table)
+		}
+		for _, k := range sortedKeys(m.CustomFields[table]) {
+			if !identifierRegexp.MatchString(k) {
+				return fmt.Errorf("invalid custom column name %s.%s", table, k)
+			}
+			if v := m.CustomFields[table][k]; !identifierRegexp.MatchString(v) {
+				return fmt.Errorf("invalid custom field name %q for column %s.%s", v, table, k)
+			}
+		}
+	}
+	return nil
+}
+
+// CustomFieldColumns returns the custom columns of a standard table that are
+// mapped to custom fields, sorted, along with the names of their custom
+// fields. A nil Mapping maps no custom columns.
+func (m *Mapping) CustomFieldColumns(table string) (columns, fields []string) {
+	if m == nil {
+		return nil, nil
+	}
+	columns = sortedKeys(m.CustomFields[table])
+	for _, v := range columns {
+		fields = append(fields, m.CustomFields[table][v])
+	}
+	return columns, fields
+}
+
+// sortedCustomFieldTables returns the tables of custom fields, sorted.
+func sortedCustomFieldTables(fields map[string]map[string]string) []string {
+	var out []string
+	for k := range fields {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Table returns the name of a standard table in the mapped schema. Tables that
+// are not renamed are prefixed with TablePrefix. A nil Mapping returns the
+// standard name.
+func (m *Mapping) Table(name string) string {
+	if m == nil {
+		return name
+	}
+	if m.Tables[name] != "" {
+		return m.Tables[name]
+	}
+	return m.TablePrefix + name
+}
+
+// column returns the name of a standard column in the mapped schema,
+// qualified with its mapped table name.
+func (m *Mapping) column(table, column string) string {
+	return m.Table(table) + "." + m.name(table, column)
+}
+
+// name returns the unqualified name of a standard column in the mapped
+// schema.
+func (m *Mapping) name(table, column string) string {
+	if m != nil && m.Columns[table+"."+column] != "" {
+		return m.Columns[table+"."+column]
+	}
+	return column
+}
+
+// BuildQueries returns the queries to run against the mapped schema. A nil
+// Mapping returns the queries for the standard 0.8 schema.
+func (m *Mapping) BuildQueries() *Queries {
+	c := m.column
+	// The switch name is either read from the ipaddresses table, or joined in
+	// from the switch table, which the devices (and their types, if there is a
+	// device type table) are then read from too.
+	switchName, switchJoin, devices, deviceTypes := c("ipaddresses", "switch"), "", "", ""
+	if m != nil && m.SwitchTable != "" {
+		switchName = m.SwitchTable + ".hostname"
+		switchJoin = fmt.Sprintf(" left join %s on %s = %s.id", m.SwitchTable, c("ipaddresses", "switch"), m.SwitchTable)
+		devices = fmt.Sprintf("select %[1]s.hostname, %[1]s.ip_addr, %[1]s.description from %[1]s", m.SwitchTable)
+		if m.DeviceTypeTable != "" {
+			devices = fmt.Sprintf("select %[1]s.hostname, %[1]s.ip_addr, %[1]s.description, %[2]s.tname from %[1]s left join %[2]s on %[1]s.type = %[2]s.tid",
+				m.SwitchTable, m.DeviceTypeTable)
+			deviceTypes = fmt.Sprintf("select tname, tdescription from %s", m.DeviceTypeTable)
+		}
+	}
+	q := &Queries{
+		VLANs: fmt.Sprintf("select %s, %s, %s from %s",
+			m.name("vlans", "name"), m.name("vlans", "number"), m.name("vlans", "description"), m.Table("vlans")),
+		Subnets: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s = %s",
+			c("subnets", "subnet"), c("subnets", "mask"), c("subnets", "description"), c("vlans", "number"),
+			m.Table("subnets"), m.Table("vlans"), c("subnets", "vlanId"), c("vlans", "vlanId")),
+		Switches: fmt.Sprintf("select %s, %s, %s from %s left join %s on %s=%s%s where %s is not null and %s != ''",
+			switchName, c("subnets", "subnet"), c("subnets", "mask"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"), switchJoin,
+			switchName, switchName),
+		Devices:     devices,
+		DeviceTypes: deviceTypes,
+		Addresses: fmt.Sprintf("select %s, %s, %s, %s, %s, %s, %s from %s left join %s on %s=%s%s",
+			c("ipaddresses", "ip_addr"), c("ipaddresses", "description"), c("ipaddresses", "dns_name"),
+			c("ipaddresses", "note"), switchName, c("subnets", "subnet"), c("subnets", "mask"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"), switchJoin),
+		SectionColumn: c("subnets", "sectionId"),
+		Users: fmt.Sprintf("select %s from %s order by %s",
+			m.name("users", "username"), m.Table("users"), m.name("users", "username")),
+		OwnedAddresses: fmt.Sprintf("select count(*) from %s where %s is not null and %s != ''",
+			m.Table("ipaddresses"), m.name("ipaddresses", "owner"), m.name("ipaddresses", "owner")),
+		OrphanAddresses: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is null",
+			c("ipaddresses", "ip_addr"), c("ipaddresses", "description"), c("ipaddresses", "dns_name"), c("ipaddresses", "subnetId"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"), c("subnets", "id")),
+		OrphanSubnets: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is null",
+			c("subnets", "subnet"), c("subnets", "mask"), c("subnets", "description"), c("subnets", "sectionId"),
+			m.Table("subnets"), m.Table("sections"), c("subnets", "sectionId"), c("sections", "id"), c("sections", "id")),
+		AddressPorts: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null and %s != ''",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "port"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "port"), c("ipaddresses", "port")),
+		AddressStates: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null and %s != ''",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "state"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "state"), c("ipaddresses", "state")),
+		AddressMACs: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null and %s != ''",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "mac"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "mac"), c("ipaddresses", "mac")),
+		AddressOwners: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null and %s != ''",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "owner"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "owner"), c("ipaddresses", "owner")),
+		AddressExcludePing: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null and %s != ''",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "excludePing"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "excludePing"), c("ipaddresses", "excludePing")),
+		AddressPTRIgnore: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null and %s != ''",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "PTRignore"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "PTRignore"), c("ipaddresses", "PTRignore")),
+		AddressLastSeen: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "lastSeen"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "lastSeen")),
+		AddressEditDates: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "editDate"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "editDate")),
+		VRFs: fmt.Sprintf("select %s, %s, %s from %s",
+			m.name("vrf", "name"), m.name("vrf", "rd"), m.name("vrf", "description"), m.Table("vrf")),
+		SubnetVRFs: fmt.Sprintf("select %s, %s, %s from %s left join %s on %s = %s where %s is not null",
+			c("subnets", "subnet"), c("subnets", "mask"), c("vrf", "name"),
+			m.Table("subnets"), m.Table("vrf"), c("subnets", "vrfId"), c("vrf", "vrfId"), c("vrf", "name")),
+		SectionVLANs: fmt.Sprintf("select %s from %s left join %s on %s = %s where %s is not null",
+			c("vlans", "number"), m.Table("subnets"), m.Table("vlans"), c("subnets", "vlanId"), c("vlans", "vlanId"), c("vlans", "number")),
+		SectionMasters: fmt.Sprintf("select %s, %s from %s",
+			m.name("sections", "id"), m.name("sections", "masterSection"), m.Table("sections")),
+		DuplicateVLANs: fmt.Sprintf("select %s, %s, %s, %s from %s order by %s",
+			c("vlans", "vlanId"), c("vlans", "name"), c("vlans", "number"), c("vlans", "description"), m.Table("vlans"), c("vlans", "vlanId")),
+		SubnetVLANIDs: fmt.Sprintf("select %s, %s, %s from %s where %s is not null and %s != 0",
+			c("subnets", "subnet"), c("subnets", "mask"), c("subnets", "vlanId"), m.Table("subnets"), c("subnets", "vlanId"), c("subnets", "vlanId")),
+		VLANIDs: fmt.Sprintf("select %s, %s from %s order by %s",
+			m.name("vlans", "number"), m.name("vlans", "vlanId"), m.Table("vlans"), m.name("vlans", "vlanId")),
+		SubnetIDs: fmt.Sprintf("select %s, %s, %s from %s",
+			c("subnets", "subnet"), c("subnets", "mask"), c("subnets", "id"), m.Table("subnets")),
+		AddressIDs: fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s where %s is not null",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("ipaddresses", "id"),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			c("ipaddresses", "id")),
+		Nameservers: fmt.Sprintf("select %s, %s, %s from %s",
+			m.name("nameservers", "name"), m.name("nameservers", "namesrv1"), m.name("nameservers", "description"), m.Table("nameservers")),
+		SubnetNameservers: fmt.Sprintf("select %s, %s, %s from %s left join %s on %s = %s where %s is not null",
+			c("subnets", "subnet"), c("subnets", "mask"), c("nameservers", "name"),
+			m.Table("subnets"), m.Table("nameservers"), c("subnets", "nameserverId"), c("nameservers", "id"), c("nameservers", "name")),
+		Requests: fmt.Sprintf("select %s, %s, %s, %s, %s, %s, %s, %s from %s left join %s on %s=%s where %s = 0",
+			c("requests", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), c("requests", "description"),
+			c("requests", "dns_name"), c("requests", "owner"), c("requests", "requester"), c("requests", "comment"),
+			m.Table("requests"), m.Table("subnets"), c("requests", "subnetId"), c("subnets", "id"), c("requests", "processed")),
+		// The columns are qualified, as groups is a reserved word in newer
+		// versions of MySQL.
+		UserAccounts: fmt.Sprintf("select %s, %s, %s, %s, %s from %s order by %s",
+			c("users", "username"), c("users", "real_name"), c("users", "email"), c("users", "role"), c("users", "groups"),
+			m.Table("users"), c("users", "username")),
+		Groups: fmt.Sprintf("select %s, %s, %s from %s order by %s",
+			c("userGroups", "g_id"), c("userGroups", "g_name"), c("userGroups", "g_desc"), m.Table("userGroups"), c("userGroups", "g_id")),
+		SubnetChangelog: fmt.Sprintf("select %s, %s, %s, %s, %s, %s, %s from %s left join %s on %s=%s left join %s on %s=%s where %s = 'subnet'",
+			c("changelog", "caction"), c("changelog", "cresult"), c("changelog", "cdate"), c("changelog", "cdiff"), c("users", "username"),
+			c("subnets", "subnet"), c("subnets", "mask"),
+			m.Table("changelog"), m.Table("subnets"), c("changelog", "coid"), c("subnets", "id"),
+			m.Table("users"), c("changelog", "cuser"), c("users", "id"), c("changelog", "ctype")),
+		AddressChangelog: fmt.Sprintf("select %s, %s, %s, %s, %s, %s, %s, %s from %s left join %s on %s=%s left join %s on %s=%s left join %s on %s=%s where %s = 'ip_addr'",
+			c("changelog", "caction"), c("changelog", "cresult"), c("changelog", "cdate"), c("changelog", "cdiff"), c("users", "username"),
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"),
+			m.Table("changelog"), m.Table("ipaddresses"), c("changelog", "coid"), c("ipaddresses", "id"),
+			m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"),
+			m.Table("users"), c("changelog", "cuser"), c("users", "id"), c("changelog", "ctype")),
+	}
+	if columns, _ := m.CustomFieldColumns("ipaddresses"); len(columns) > 0 {
+		values := make([]string, len(columns))
+		for i, v := range columns {
+			values[i] = m.Table("ipaddresses") + "." + v
+		}
+		q.AddressCustomFields = fmt.Sprintf("select %s, %s, %s, %s from %s left join %s on %s=%s",
+			c("ipaddresses", "ip_addr"), c("subnets", "subnet"), c("subnets", "mask"), strings.Join(values, ", "),
+			m.Table("ipaddresses"), m.Table("subnets"), c("ipaddresses", "subnetId"), c("subnets", "id"))
+	}
+	if columns, _ := m.CustomFieldColumns("vlans"); len(columns) > 0 {
+		q.VLANCustomFields = fmt.Sprintf("select %s, %s from %s",
+			m.name("vlans", "number"), strings.Join(columns, ", "), m.Table("vlans"))
+	}
+	if m == nil {
+		return q
+	}
+	for _, v := range []struct{ override, query *string }{
+		{&m.Queries.VLANs, &q.VLANs},
+		{&m.Queries.Subnets, &q.Subnets},
+		{&m.Queries.Switches, &q.Switches},
+		{&m.Queries.Devices, &q.Devices},
+		{&m.Queries.DeviceTypes, &q.DeviceTypes},
+		{&m.Queries.Addresses, &q.Addresses},
+		{&m.Queries.SectionColumn, &q.SectionColumn},
+		{&m.Queries.Users, &q.Users},
+		{&m.Queries.OwnedAddresses, &q.OwnedAddresses},
+		{&m.Queries.OrphanAddresses, &q.OrphanAddresses},
+		{&m.Queries.OrphanSubnets, &q.OrphanSubnets},
+		{&m.Queries.AddressPorts, &q.AddressPorts},
+		{&m.Queries.AddressStates, &q.AddressStates},
+		{&m.Queries.AddressMACs, &q.AddressMACs},
+		{&m.Queries.AddressOwners, &q.AddressOwners},
+		{&m.Queries.AddressExcludePing, &q.AddressExcludePing},
+		{&m.Queries.AddressPTRIgnore, &q.AddressPTRIgnore},
+		{&m.Queries.AddressLastSeen, &q.AddressLastSeen},
+		{&m.Queries.AddressEditDates, &q.AddressEditDates},
+		{&m.Queries.VRFs, &q.VRFs},
+		{&m.Queries.SubnetVRFs, &q.SubnetVRFs},
+		{&m.Queries.SectionVLANs, &q.SectionVLANs},
+		{&m.Queries.SectionMasters, &q.SectionMasters},
+		{&m.Queries.DuplicateVLANs, &q.DuplicateVLANs},
+		{&m.Queries.SubnetVLANIDs, &q.SubnetVLANIDs},
+		{&m.Queries.VLANIDs, &q.VLANIDs},
+		{&m.Queries.SubnetIDs, &q.SubnetIDs},
+		{&m.Queries.AddressIDs, &q.AddressIDs},
+		{&m.Queries.Nameservers, &q.Nameservers},
+		{&m.Queries.SubnetNameservers, &q.SubnetNameservers},
+		{&m.Queries.Requests, &q.Requests},
+		{&m.Queries.UserAccounts, &q.UserAccounts},
+		{&m.Queries.Groups, &q.Groups},
+		{&m.Queries.SubnetChangelog, &q.SubnetChangelog},
+		{&m.Queries.AddressChangelog, &q.AddressChangelog},
+		{&m.Queries.AddressCustomFields, &q.AddressCustomFields},
+		{&m.Queries.VLANCustomFields, &q.VLANCustomFields},
+	} {
+		if s := strings.TrimSpace(*v.override); s != "" {
+			*v.query = s
+		}
+	}
+	return q
+}
+
+// whereRegexp matches a where clause in a query.
+var whereRegexp = regexp.MustCompile(`(?i)\swhere\s`)
+
+// restrict returns query with a condition that its section column equals a
+// placeholder, added to its where clause if it has one.
+func (q *Queries) restrict(query string) string {
+	if whereRegexp.MatchString(query) {
+		return fmt.Sprintf("%s and %s = ?", query, q.SectionColumn)
+	}
+	return fmt.Sprintf("%s where %s = ?", query, q.SectionColumn)
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]string) (out []string) {
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return
+}
@@ -0,0 +1,140 @@
+package legacydb
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStandardQueries(t *testing.T) {
+	// The standard queries are matched by recorded replay bundles, so must not
+	// change.
+	q := (*Mapping)(nil).BuildQueries()
+	expected := &Queries{
+		VLANs:              "select name, number, description from vlans",
+		Subnets:            "select subnets.subnet, subnets.mask, subnets.description, vlans.number from subnets left join vlans on subnets.vlanId = vlans.vlanId",
+		Switches:           "select ipaddresses.switch, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.switch is not null and ipaddresses.switch != ''",
+		Addresses:          "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.dns_name, ipaddresses.note, ipaddresses.switch, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id",
+		SectionColumn:      "subnets.sectionId",
+		Users:              "select username from users order by username",
+		OwnedAddresses:     "select count(*) from ipaddresses where owner is not null and owner != ''",
+		OrphanAddresses:    "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.dns_name, ipaddresses.subnetId from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where subnets.id is null",
+		OrphanSubnets:      "select subnets.subnet, subnets.mask, subnets.description, subnets.sectionId from subnets left join sections on subnets.sectionId=sections.id where sections.id is null",
+		AddressPorts:       "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.port from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.port is not null and ipaddresses.port != ''",
+		AddressStates:      "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.state from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.state is not null and ipaddresses.state != ''",
+		AddressMACs:        "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.mac from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.mac is not null and ipaddresses.mac != ''",
+		AddressOwners:      "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.owner from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.owner is not null and ipaddresses.owner != ''",
+		AddressExcludePing: "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.excludePing from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.excludePing is not null and ipaddresses.excludePing != ''",
+		AddressPTRIgnore:   "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.PTRignore from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.PTRignore is not null and ipaddresses.PTRignore != ''",
+		AddressLastSeen:    "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.lastSeen from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.lastSeen is not null",
+		AddressEditDates:   "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.editDate from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.editDate is not null",
+		VRFs:               "select name, rd, description from vrf",
+		SubnetVRFs:         "select subnets.subnet, subnets.mask, vrf.name from subnets left join vrf on subnets.vrfId = vrf.vrfId where vrf.name is not null",
+		Nameservers:        "select name, namesrv1, description from nameservers",
+		SectionVLANs:       "select vlans.number from subnets left join vlans on subnets.vlanId = vlans.vlanId where vlans.number is not null",
+		SectionMasters:     "select id, masterSection from sections",
+		DuplicateVLANs:     "select vlans.vlanId, vlans.name, vlans.number, vlans.description from vlans order by vlans.vlanId",
+		SubnetVLANIDs:      "select subnets.subnet, subnets.mask, subnets.vlanId from subnets where subnets.vlanId is not null and subnets.vlanId != 0",
+		VLANIDs:            "select number, vlanId from vlans order by vlanId",
+		SubnetIDs:          "select subnets.subnet, subnets.mask, subnets.id from subnets",
+		AddressIDs:         "select ipaddresses.ip_addr, subnets.subnet, subnets.mask, ipaddresses.id from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id where ipaddresses.id is not null",
+		SubnetNameservers:  "select subnets.subnet, subnets.mask, nameservers.name from subnets left join nameservers on subnets.nameserverId = nameservers.id where nameservers.name is not null",
+		Requests:           "select requests.ip_addr, subnets.subnet, subnets.mask, requests.description, requests.dns_name, requests.owner, requests.requester, requests.comment from requests left join subnets on requests.subnetId=subnets.id where requests.processed = 0",
+		UserAccounts:       "select users.username, users.real_name, users.email, users.role, users.groups from users order by users.username",
+		Groups:             "select userGroups.g_id, userGroups.g_name, userGroups.g_desc from userGroups order by userGroups.g_id",
+		SubnetChangelog:    "select changelog.caction, changelog.cresult, changelog.cdate, changelog.cdiff, users.username, subnets.subnet, subnets.mask from changelog left join subnets on changelog.coid=subnets.id left join users on changelog.cuser=users.id where changelog.ctype = 'subnet'",
+		AddressChangelog:   "select changelog.caction, changelog.cresult, changelog.cdate, changelog.cdiff, users.username, ipaddresses.ip_addr, subnets.subnet, subnets.mask from changelog left join ipaddresses on changelog.coid=ipaddresses.id left join subnets on ipaddresses.subnetId=subnets.id left join users on changelog.cuser=users.id where changelog.ctype = 'ip_addr'",
+	}
+	if *q != *expected {
+		t.Fatalf("Expected %#v, got %#v", expected, q)
+	}
+}
+
+func TestLoadMapping(t *testing.T) {
+	f, err := ioutil.TempFile("", "mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+tables:
+  subnets: nets
+columns:
+  subnets.sectionId: section
+  ipaddresses.dns_name: hostname
+custom_fields:
+  ipaddresses:
+    rack: custom_Rack
+  vlans:
+    site: custom_Site
+queries:
+  vlans: select vlan_name, vlan_num, '' from my_vlans where deleted = 0
+`)
+	f.Close()
+
+	m, err := LoadMapping(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading mapping: %s", err)
+	}
+	q := m.BuildQueries()
+	if expected := "select vlan_name, vlan_num, '' from my_vlans where deleted = 0"; q.VLANs != expected {
+		t.Fatalf("Expected VLANs query %q, got %q", expected, q.VLANs)
+	}
+	if expected := "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.hostname, ipaddresses.note, ipaddresses.switch, nets.subnet, nets.mask from ipaddresses left join nets on ipaddresses.subnetId=nets.id"; q.Addresses != expected {
+		t.Fatalf("Expected addresses query %q, got %q", expected, q.Addresses)
+	}
+	if expected := "select ipaddresses.ip_addr, nets.subnet, nets.mask, ipaddresses.rack from ipaddresses left join nets on ipaddresses.subnetId=nets.id"; q.AddressCustomFields != expected {
+		t.Fatalf("Expected address custom fields query %q, got %q", expected, q.AddressCustomFields)
+	}
+	if expected := "select number, site from vlans"; q.VLANCustomFields != expected {
+		t.Fatalf("Expected VLAN custom fields query %q, got %q", expected, q.VLANCustomFields)
+	}
+	if expected := q.Subnets + " where nets.section = ?"; q.restrict(q.Subnets) != expected {
+		t.Fatalf("Expected restricted subnets query %q, got %q", expected, q.restrict(q.Subnets))
+	}
+	if expected := q.VLANs + " and nets.section = ?"; q.restrict(q.VLANs) != expected {
+		t.Fatalf("Expected restricted query %q, got %q", expected, q.restrict(q.VLANs))
+	}
+	if m.Table("subnets") != "nets" || m.Table("vlans") != "vlans" {
+		t.Fatalf("Unexpected table names %s and %s", m.Table("subnets"), m.Table("vlans"))
+	}
+}
+
+func TestTablePrefix(t *testing.T) {
+	m := &Mapping{TablePrefix: "ipam_", Tables: map[string]string{"users": "accounts"}}
+	q := m.BuildQueries()
+	if expected := "select ipam_subnets.subnet, ipam_subnets.mask, ipam_subnets.description, ipam_vlans.number from ipam_subnets left join ipam_vlans on ipam_subnets.vlanId = ipam_vlans.vlanId"; q.Subnets != expected {
+		t.Fatalf("Expected subnets query %q, got %q", expected, q.Subnets)
+	}
+	if expected := "select username from accounts order by username"; q.Users != expected {
+		t.Fatalf("Expected users query %q, got %q", expected, q.Users)
+	}
+	if expected := "ipam_subnets.sectionId"; q.SectionColumn != expected {
+		t.Fatalf("Expected section column %q, got %q", expected, q.SectionColumn)
+	}
+}
+
+func TestLoadMappingErrors(t *testing.T) {
+	cases := map[string]string{
+		"tables:\n  subnet: nets\n":                               "unknown table subnet",
+		"columns:\n  dns_name: hostname\n":                        "column dns_name must be qualified with its table (ie: ipaddresses.dns_name)",
+		"columns:\n  ipaddresses.location: l\n":                   "unknown column ipaddresses.location",
+		"queries:\n  subnet: select 1\n":                          "field subnet not found",
+		"custom_fields:\n  users:\n    x: custom_X\n":             "custom fields cannot be read from table users",
+		"custom_fields:\n  ipaddresses:\n    rack: \"my rack\"\n": `invalid custom field name "my rack" for column ipaddresses.rack`,
+	}
+	for in, expected := range cases {
+		f, err := ioutil.TempFile("", "mapping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(in)
+		f.Close()
+		_, err = LoadMapping(f.Name())
+		os.Remove(f.Name())
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+}
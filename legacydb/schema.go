@@ -0,0 +1,172 @@
+package legacydb
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Schema describes the schema of a legacy DB, as detected from the columns of
+// its tables.
+type Schema struct {
+	// The PHPIPAM version from the settings table, or blank if it could not be
+	// read.
+	Version string
+
+	// The types of the columns of the probed tables, keyed by table and
+	// column name.
+	columns map[string]map[string]string
+}
+
+// DetectSchema probes the schema of the legacy DB, reading table names through
+// m, which can be nil. Only the ipaddresses table is required; the tables of
+// subnets, VLANs, switches, device types, requests, nameservers, and sections
+// and the settings table are optional, since they are missing from older
+// schemas (or are only read on request, or for their custom columns).
+func DetectSchema(db *sql.DB, m *Mapping) (*Schema, error) {
+	s := &Schema{columns: make(map[string]map[string]string)}
+	if err := s.probe(db, m.Table("ipaddresses")); err != nil {
+		return nil, err
+	}
+	for _, t := range []string{"subnets", "vlans", "devices", "switches", "deviceTypes", "requests", "nameservers", "sections"} {
+		// Errors just mean the table does not exist.
+		s.probe(db, m.Table(t))
+	}
+	var version sql.NullString
+	if err := db.QueryRow(fmt.Sprintf("select version from %s", m.Table("settings"))).Scan(&version); err == nil {
+		s.Version = version.String
+	}
+	return s, nil
+}
+
+// probe reads the columns of a table. SHOW COLUMNS returns the column name and
+// type first, followed by a number of other columns that vary between MySQL
+// versions.
+func (s *Schema) probe(db *sql.DB, table string) error {
+	rows, err := db.Query(fmt.Sprintf("show columns from %s", table))
+	if err != nil {
+		return fmt.Errorf("error reading the columns of table %s: %s", table, err)
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]string)
+	for rows.Next() {
+		values := make([]sql.RawBytes, len(names))
+		dest := make([]interface{}, len(names))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("error reading the columns of table %s: %s", table, err)
+		}
+		columns[string(values[0])] = strings.ToLower(string(values[1]))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading the columns of table %s: %s", table, err)
+	}
+	s.columns[table] = columns
+	return nil
+}
+
+// builtinColumns are the columns of the standard tables in the schemas of
+// PHPIPAM 0.8 to 1.1, whether they are read or not. Any other columns are
+// custom fields added to the legacy instance.
+var builtinColumns = map[string][]string{
+	"ipaddresses": {"id", "subnetId", "ip_addr", "description", "dns_name", "hostname", "mac", "owner", "state",
+		"switch", "port", "note", "lastSeen", "excludePing", "PTRignore", "PTR", "firewallAddressObject",
+		"editDate", "is_gateway", "location", "customer_id"},
+	"vlans": {"vlanId", "domainId", "name", "number", "description", "editDate", "customer_id"},
+}
+
+// CustomColumns returns the columns of a standard table, read through m, which
+// can be nil, that are not built into any schema, and so hold the values of
+// custom fields. Columns that m maps a standard column to are not custom.
+// Only the tables in customFieldTables are probed.
+func (s *Schema) CustomColumns(m *Mapping, table string) (out []string) {
+	known := make(map[string]bool)
+	for _, v := range builtinColumns[table] {
+		known[v] = true
+		known[m.name(table, v)] = true
+	}
+	for v := range s.columns[m.Table(table)] {
+		if !known[v] {
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// HasColumn returns true if a standard table has a standard column, both read
+// through m, which can be nil.
+func (s *Schema) HasColumn(m *Mapping, table, column string) bool {
+	return s.has(m.Table(table), m.name(table, column))
+}
+
+// has returns true if the table has the column.
+func (s *Schema) has(table, column string) bool {
+	_, ok := s.columns[table][column]
+	return ok
+}
+
+// Adapt returns a copy of m, which can be nil, adapted to the detected schema,
+// along with descriptions of the adaptations made. Tables and columns that are
+// already mapped in m are left as they are.
+//
+// The following differences from the 0.8 schema are adapted to:
+//
+//   - The dns_name columns of ipaddresses and requests were renamed to
+//     hostname in 1.0.
+//   - The switch column of ipaddresses references the switches table by ID in
+//     0.9, and the devices table by ID from 1.0, rather than holding the
+//     switch name.
+//   - The type column of the switch table references the deviceTypes table by
+//     ID.
+func (s *Schema) Adapt(m *Mapping) (*Mapping, []string) {
+	out := &Mapping{Tables: make(map[string]string), Columns: make(map[string]string)}
+	if m != nil {
+		for k, v := range m.Tables {
+			out.Tables[k] = v
+		}
+		for k, v := range m.Columns {
+			out.Columns[k] = v
+		}
+		out.TablePrefix = m.TablePrefix
+		out.SwitchTable = m.SwitchTable
+		out.DeviceTypeTable = m.DeviceTypeTable
+		out.CustomFields = m.CustomFields
+		out.Queries = m.Queries
+	}
+	var changes []string
+	table := out.Table("ipaddresses")
+
+	if out.Columns["ipaddresses.dns_name"] == "" && !s.has(table, "dns_name") && s.has(table, "hostname") {
+		out.Columns["ipaddresses.dns_name"] = "hostname"
+		changes = append(changes, fmt.Sprintf("reading address hostnames from %s.hostname", table))
+	}
+
+	if v := out.Table("requests"); out.Columns["requests.dns_name"] == "" && !s.has(v, "dns_name") && s.has(v, "hostname") {
+		out.Columns["requests.dns_name"] = "hostname"
+		changes = append(changes, fmt.Sprintf("reading request hostnames from %s.hostname", v))
+	}
+
+	if typ := s.columns[table][out.name("ipaddresses", "switch")]; out.SwitchTable == "" && strings.Contains(typ, "int") {
+		for _, v := range []string{out.Table("devices"), out.Table("switches")} {
+			if s.has(v, "id") && s.has(v, "hostname") {
+				out.SwitchTable = v
+				changes = append(changes, fmt.Sprintf("reading switch names from %s.hostname", v))
+				break
+			}
+		}
+	}
+
+	if v := out.Table("deviceTypes"); out.SwitchTable != "" && out.DeviceTypeTable == "" && s.has(out.SwitchTable, "type") && s.has(v, "tid") && s.has(v, "tname") {
+		out.DeviceTypeTable = v
+		changes = append(changes, fmt.Sprintf("reading device types from %s.tname", v))
+	}
+	return out, changes
+}
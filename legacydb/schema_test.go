@@ -0,0 +1,102 @@
+package legacydb
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/replay"
+)
+
+// showColumns returns a replayed SHOW COLUMNS query for a table with the
+// supplied column names and types.
+func showColumns(table string, columns ...string) *replay.Query {
+	q := &replay.Query{
+		SQL:     "show columns from " + table,
+		Columns: []string{"Field", "Type", "Null", "Key", "Default", "Extra"},
+	}
+	for i := 0; i < len(columns); i += 2 {
+		q.Rows = append(q.Rows, strs(columns[i], columns[i+1], "YES", "", "", ""))
+	}
+	return q
+}
+
+func testSchema(t *testing.T, name string, queries ...*replay.Query) *Schema {
+	sql.Register(name, &replay.Driver{Bundle: &replay.Bundle{Queries: queries}})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Error opening replay DB: %s", err)
+	}
+	s, err := DetectSchema(db, nil)
+	if err != nil {
+		t.Fatalf("Error detecting schema: %s", err)
+	}
+	return s
+}
+
+func TestDetectSchema08(t *testing.T) {
+	s := testSchema(t, "legacydb-schema-08",
+		showColumns("ipaddresses", "id", "int(11)", "dns_name", "varchar(100)", "switch", "varchar(32)", "rack", "varchar(32)", "mac", "varchar(20)", "state", "varchar(1)"),
+		showColumns("vlans", "vlanId", "int(11)", "number", "int(4)", "site", "varchar(32)"),
+	)
+	if s.Version != "" {
+		t.Fatalf("Expected no version, got %q", s.Version)
+	}
+	if expected, actual := []string{"rack"}, s.CustomColumns(nil, "ipaddresses"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected custom columns %v, got %v", expected, actual)
+	}
+	if expected, actual := []string{"site"}, s.CustomColumns(nil, "vlans"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected VLAN custom columns %v, got %v", expected, actual)
+	}
+	if !s.HasColumn(nil, "ipaddresses", "state") || s.HasColumn(&Mapping{Columns: map[string]string{"ipaddresses.state": "status"}}, "ipaddresses", "state") {
+		t.Fatal("Expected the state column to be found only under its own name")
+	}
+	m, changes := s.Adapt(nil)
+	if len(changes) != 0 {
+		t.Fatalf("Expected no changes, got %#v", changes)
+	}
+	if expected, actual := (*Mapping)(nil).BuildQueries(), m.BuildQueries(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected queries %#v, got %#v", expected, actual)
+	}
+}
+
+func TestDetectSchema11(t *testing.T) {
+	s := testSchema(t, "legacydb-schema-11",
+		showColumns("ipaddresses", "id", "int(11)", "hostname", "varchar(255)", "switch", "INT(11) UNSIGNED"),
+		showColumns("devices", "id", "int(11)", "hostname", "varchar(32)"),
+		&replay.Query{SQL: "show columns from switches", Error: "table switches does not exist"},
+		showColumns("requests", "id", "int(11)", "hostname", "varchar(255)"),
+		&replay.Query{SQL: "select version from settings", Columns: []string{"version"}, Rows: [][]*string{strs("1.1")}},
+	)
+	if s.Version != "1.1" {
+		t.Fatalf("Expected version 1.1, got %q", s.Version)
+	}
+	m, changes := s.Adapt(&Mapping{Columns: map[string]string{"ipaddresses.note": "comments"}})
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %#v", changes)
+	}
+	if m.SwitchTable != "devices" || m.Columns["ipaddresses.dns_name"] != "hostname" || m.Columns["requests.dns_name"] != "hostname" || m.Columns["ipaddresses.note"] != "comments" {
+		t.Fatalf("Unexpected mapping %#v", m)
+	}
+	q := m.BuildQueries()
+	if expected := "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.hostname, ipaddresses.comments, devices.hostname, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id left join devices on ipaddresses.switch = devices.id"; q.Addresses != expected {
+		t.Fatalf("Expected addresses query %q, got %q", expected, q.Addresses)
+	}
+	if expected := "select devices.hostname, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id left join devices on ipaddresses.switch = devices.id where devices.hostname is not null and devices.hostname != ''"; q.Switches != expected {
+		t.Fatalf("Expected switches query %q, got %q", expected, q.Switches)
+	}
+	if expected := "select devices.hostname, devices.ip_addr, devices.description from devices"; q.Devices != expected {
+		t.Fatalf("Expected devices query %q, got %q", expected, q.Devices)
+	}
+}
+
+func TestAdaptKeepsMapping(t *testing.T) {
+	s := testSchema(t, "legacydb-schema-mapped",
+		showColumns("ipaddresses", "hostname", "varchar(255)", "switch", "int(11)"),
+		showColumns("devices", "id", "int(11)", "hostname", "varchar(32)"),
+	)
+	m, changes := s.Adapt(&Mapping{SwitchTable: "switches", Columns: map[string]string{"ipaddresses.dns_name": "fqdn"}})
+	if len(changes) != 0 || m.SwitchTable != "switches" || m.Columns["ipaddresses.dns_name"] != "fqdn" {
+		t.Fatalf("Expected mapping to be kept, got %#v and %#v", m, changes)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+// livenessWorkers is the number of liveness checks run concurrently in each
+// section with -liveness-check.
+const livenessWorkers = 16
+
+var (
+	// livenessCheck is how the migrated addresses are checked for liveness:
+	// tcp, icmp, or blank to not check them. Addresses found alive are
+	// written with the time of the check as the time they were last seen,
+	// and those not found alive as never seen and offline.
+	livenessCheck string
+
+	// livenessPortsFlag is the comma-separated list of the TCP ports tried by
+	// the tcp check, parsed into livenessPorts.
+	livenessPortsFlag string
+	livenessPorts     []int
+
+	// livenessTimeout is the maximum duration of each ping, or of each TCP
+	// connection attempt.
+	livenessTimeout time.Duration
+
+	// livenessRate is the maximum number of addresses checked per second,
+	// across all sections.
+	livenessRate float64
+
+	// livenessLimiter throttles the liveness checks to livenessRate.
+	livenessLimiter *ratelimit.Limiter
+
+	// probeAddress reports whether or not the IP address is alive. It is
+	// replaced in tests.
+	probeAddress = func(ip string) bool {
+		if livenessCheck == "icmp" {
+			return pingAlive(ip)
+		}
+		return tcpAlive(ip)
+	}
+)
+
+// setupLiveness checks the liveness check options, and sets up the limiter.
+func setupLiveness() {
+	if livenessCheck == "" {
+		return
+	}
+	if livenessCheck != "tcp" && livenessCheck != "icmp" {
+		logrus.Fatalf("Invalid -liveness-check %q: must be tcp or icmp", livenessCheck)
+	}
+	livenessPorts = nil
+	for _, v := range strings.Split(livenessPortsFlag, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || port < 1 || port > 65535 {
+			logrus.Fatalf("Invalid -liveness-ports %q: must be a comma-separated list of TCP ports", livenessPortsFlag)
+		}
+		livenessPorts = append(livenessPorts, port)
+	}
+	if livenessRate <= 0 {
+		logrus.Fatalf("Invalid -liveness-rate %g: must be more than 0", livenessRate)
+	}
+	livenessLimiter = ratelimit.New(livenessRate, livenessWorkers)
+}
+
+// tcpAlive reports whether or not ip accepts or refuses a TCP connection to
+// any of livenessPorts. A refused connection means the host is up.
+func tcpAlive(ip string) bool {
+	for _, port := range livenessPorts {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), livenessTimeout)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return true
+		}
+	}
+	return false
+}
+
+// pingAlive reports whether or not ip answers a single ping, sent with the
+// system ping command, as ICMP needs privileges that the migrator should not
+// run with.
+func pingAlive(ip string) bool {
+	secs := int((livenessTimeout + time.Second - 1) / time.Second)
+	return exec.Command("ping", "-c", "1", "-W", strconv.Itoa(secs), ip).Run() == nil
+}
+
+// checkLiveness checks the section's addresses for liveness, throttled by
+// livenessLimiter, and returns the number found alive. The addresses found
+// alive are set as last seen now, and tagged as Used if they were tagged as
+// Offline. The stale legacy time that the others were last seen is cleared,
+// and they are tagged as Offline, unless they are tagged otherwise (ie: as
+// Reserved or DHCP), as those tags are not about liveness.
+func (s *sectionRun) checkLiveness() int {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var alive int
+	var wg sync.WaitGroup
+	for w := 0; w < livenessWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				a := &s.addresses[i]
+				livenessLimiter.Wait()
+				if !probeAddress(a.IPAddress) {
+					s.log.WithField("ip", a.IPAddress).Debugf("IP address %s not found alive", a.IPAddress)
+					a.LastSeen = ""
+					if a.Tag == 0 || a.Tag == helper.TagUsed {
+						a.Tag = helper.TagOffline
+					}
+					continue
+				}
+				a.LastSeen = now
+				if a.Tag == helper.TagOffline {
+					a.Tag = helper.TagUsed
+				}
+				mu.Lock()
+				alive++
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range s.addresses {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return alive
+}
@@ -9,22 +9,50 @@ package main
 
 import (
 	"database/sql"
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 
+	"github.com/paybyphone/phpipam-legacy-migrator/backup"
+	"github.com/paybyphone/phpipam-legacy-migrator/cache"
+	"github.com/paybyphone/phpipam-legacy-migrator/config"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/csvsource"
+	"github.com/paybyphone/phpipam-legacy-migrator/dbsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/dump"
+	"github.com/paybyphone/phpipam-legacy-migrator/export"
 	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/hooks"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/journal"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/nautobotsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/netboxsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/notify"
+	"github.com/paybyphone/phpipam-legacy-migrator/pipeline"
+	"github.com/paybyphone/phpipam-legacy-migrator/probe"
+	"github.com/paybyphone/phpipam-legacy-migrator/progress"
+	"github.com/paybyphone/phpipam-legacy-migrator/replay"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-legacy-migrator/state"
+	"github.com/paybyphone/phpipam-legacy-migrator/transform"
+	"github.com/paybyphone/phpipam-legacy-migrator/tunnel"
+	"github.com/paybyphone/phpipam-legacy-migrator/vault"
 	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
-	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
 	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
 	"github.com/paybyphone/phpipam-sdk-go/phpipam"
 	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
@@ -36,6 +64,12 @@ var (
 	// the same session token can be re-used across queries.
 	ipamSession *session.Session
 
+	// sink writes the migrated objects into the new PHPIPAM instance, via
+	// ipamSession, straight into its database if targetDSN is set, or into
+	// NetBox or Nautobot as per target. It is set up once the session or
+	// database is ready to use.
+	sink ipamsink.Target
+
 	// dbHost is the hostname housing the legacy DB. This deafults to blank,
 	// which will use localhost.
 	dbHost string
@@ -43,12 +77,115 @@ var (
 	// dbUser is the username to use when connecting to the legacy DB.
 	dbUser string
 
-	// dbPassword is the password to use when connecting to the legacy DB.
+	// dbPassword is the password to use when connecting to the legacy DB. It
+	// can also be supplied with the LEGACY_DB_PASSWORD environment variable.
 	dbPassword string
 
 	// dbName is the database name to use when connecting to the legacy DB.
 	dbName string
 
+	// dbPort is the TCP port to use when connecting to the legacy DB. This is
+	// only used when dbHost is set.
+	dbPort int
+
+	// dbSocket is the path to a UNIX socket to use when connecting to the
+	// legacy DB. This is useful when running on the legacy PHPIPAM host itself,
+	// where TCP access to the DB may be disabled.
+	dbSocket string
+
+	// sshHost is an SSH server to tunnel the legacy DB connection through.
+	// When set, the DB host (which defaults to 127.0.0.1 in this case) and port
+	// are reached from the SSH server.
+	sshHost string
+
+	// sshUser is the user to log in to sshHost as.
+	sshUser string
+
+	// sshKey is the path to the private key to log in to sshHost with.
+	sshKey string
+
+	// sshTunnel is the open SSH tunnel, if sshHost is set.
+	sshTunnel *tunnel.Tunnel
+
+	// dbDSN is a complete go-sql-driver/mysql DSN to use when connecting to the
+	// legacy DB. When set, it overrides all of the other database connection
+	// options.
+	dbDSN string
+
+	// targetDSN is a go-sql-driver/mysql DSN for the database of the new
+	// PHPIPAM instance. When set, the migrated objects are written straight
+	// into the database instead of through the API.
+	targetDSN string
+
+	// target is the kind of IPAM that the migrated objects are written to:
+	// phpipam, netbox to write them to the NetBox instance at netboxURL
+	// instead, or nautobot to write them to the Nautobot instance at
+	// nautobotURL.
+	target string
+
+	// netboxURL is the base URL of the NetBox instance written to when target
+	// is netbox.
+	netboxURL string
+
+	// netboxToken is the API token for the NetBox instance. It can also be
+	// supplied with the NETBOX_TOKEN environment variable.
+	netboxToken string
+
+	// nautobotURL is the base URL of the Nautobot instance written to when
+	// target is nautobot.
+	nautobotURL string
+
+	// nautobotToken is the API token for the Nautobot instance. It can also
+	// be supplied with the NAUTOBOT_TOKEN environment variable.
+	nautobotToken string
+
+	// nautobotStatus and nautobotNamespace are the names of the status that
+	// objects are created with in Nautobot, and of the namespace that
+	// prefixes and IP addresses are created in.
+	nautobotStatus    string
+	nautobotNamespace string
+
+	// output is where the migrated objects are written: api, sql to write a
+	// SQL script to outputFile instead, json, yaml, or terraform to export them
+	// to outputFile, or csv to export them as PHPIPAM import files in the
+	// outputFile directory.
+	output string
+
+	// outputFile is the path of the file that the migrated objects are
+	// written to, when they are not written to the API.
+	outputFile string
+
+	// sqlScript is the SQL script being written when output is sql.
+	sqlScript *dbsink.Script
+
+	// exportSink collects the objects to export when output is json, yaml,
+	// terraform, or csv.
+	exportSink *export.Sink
+
+	// sourceDump is the path to a mysqldump of the legacy DB. When set, the
+	// legacy DB is read from the dump instead of a MySQL server.
+	sourceDump string
+
+	// sourceCSV is the path to a directory of CSV files holding the VLANs,
+	// subnets, and addresses to migrate. When set, these are read instead of
+	// the legacy DB.
+	sourceCSV string
+
+	// schemaMapping is the path to a YAML file mapping the tables, columns, and
+	// queries of a customized legacy schema.
+	schemaMapping string
+
+	// dbTablePrefix is the prefix of the names of the legacy tables.
+	dbTablePrefix string
+
+	// legacyMapping is the mapping loaded from schemaMapping, or nil for the
+	// standard schema.
+	legacyMapping *legacydb.Mapping
+
+	// legacyQueries are the queries run against the legacy DB, built from
+	// legacyMapping.
+	legacyQueries = legacyMapping.BuildQueries()
+
 	// iapmAppID is the application ID for the new PHPIPAM API endpoint the tool
 	// contacts. This is set up in the console. It can also be specified via the
 	// PHPIPAM_APP_ID environment variable, and defaults to "default".
@@ -72,36 +209,791 @@ var (
 
 	// The section ID to add the found subnets to. On a current default PHPIPAM
 	// installation, the "Customers" section seems to be 1, so this is the
-	// default.
+	// default. Ignored when -sections is supplied.
 	sectionID int
 
+	// sectionName is the name of the section to add the found subnets to,
+	// which is looked up in the new PHPIPAM instance, and created if it is
+	// missing. It overrides sectionID.
+	sectionName string
+
+	// sectionsFlag is the comma-separated list of LEGACY:NEW section mappings
+	// supplied with -sections, parsed into sectionMappings.
+	sectionsFlag string
+
+	// sectionMappings maps legacy sections to the sections in the new PHPIPAM
+	// instance that they are migrated to. Each mapping is migrated
+	// concurrently, in isolation from the others.
+	sectionMappings []helper.SectionMapping
+
+	// sectionErrorBudget is the number of subnets and addresses that can fail
+	// to migrate in a section before that section is aborted.
+	sectionErrorBudget int
+
+	// runbookFile is the path that the post-migration runbook of manual
+	// follow-ups is written to at the end of the run.
+	runbookFile string
+
+	// hookPlugins is the comma-separated list of Go plugins that hooks are
+	// loaded from, supplied with -hook-plugin.
+	hookPlugins string
+
+	// migrationHooks holds the hooks that are run on each VLAN, subnet, and
+	// address in the transform stage.
+	migrationHooks = &hooks.Hooks{}
+
+	// addressWorkers is the number of workers that add addresses concurrently
+	// in each section.
+	addressWorkers int
+
+	// metricsAddr is the address to serve Prometheus metrics on, if any.
+	metricsAddr string
+
+	// pprofAddr is the address to serve the pprof profiling endpoints on, if
+	// any.
+	pprofAddr string
+
+	// notifyURL is the URL that the final report of the run is POSTed to, if
+	// any.
+	notifyURL string
+
+	// showProgress enables the progress display, which is written to
+	// progressDisplay. progressDisplay is nil if the display is disabled.
+	showProgress    bool
+	progressDisplay *progress.Display
+
 	// debug enables debug logging.
 	debug bool
+
+	// logLevel is the minimum level of messages logged, and quiet is a shortcut
+	// for only logging warnings and errors.
+	logLevel string
+	quiet    bool
+
+	// logFormat is the format of log output, either text or json.
+	logFormat string
+
+	// recordFile is the path to a bundle file that all legacy DB rows and
+	// PHPIPAM API responses are recorded to during the run. The bundle is
+	// written even if the run fails, so that it can be replayed later.
+	recordFile string
+
+	// replayFile is the path to a previously recorded bundle. When set, the
+	// migration is run against the bundle instead of the legacy DB and the
+	// PHPIPAM API.
+	replayFile string
+
+	// replayStrict fails a replayed run if any of its API requests differ
+	// from the recording.
+	replayStrict bool
+
+	// recording is the bundle being recorded to when recordFile is set.
+	recording *replay.Bundle
+
+	// replaying is the bundle being replayed from when replayFile is set.
+	replaying *replay.Bundle
+
+	// journalFile is the path to the append-only journal that every mutating
+	// API request and its response are written to.
+	journalFile string
+
+	// apiJournal is the journal being written to when journalFile is set.
+	apiJournal *journal.Journal
+
+	// vaultAddr is the address of a HashiCorp Vault server to read credentials
+	// from. It defaults to the VAULT_ADDR environment variable. The Vault token
+	// is always read from VAULT_TOKEN.
+	vaultAddr string
+
+	// vaultSecretPath is the path of the Vault secret holding the credentials.
+	// When set, the legacy DB password is read from its db_password key, and
+	// the PHPIPAM password from its phpipam_password key, unless they are
+	// supplied some other way.
+	vaultSecretPath string
+
+	// migrateDevices enables device migration. Devices are created from the
+	// distinct switch names found on legacy addresses, and addresses are linked
+	// to them.
+	migrateDevices bool
+
+	// switchDeviceIDs maps the normalized legacy switch names to the IDs of
+	// the devices created for them in the new PHPIPAM instance.
+	switchDeviceIDs = make(map[string]int)
+
+	// existingVLANs is what is done with legacy VLANs whose number is already
+	// used in their L2 domain in the new PHPIPAM instance: skip them, or merge
+	// them into the existing VLAN, filling in its blank name and description.
+	existingVLANs string
+
+	// missingVLANs is what is done with subnets whose legacy VLAN is not in
+	// the new PHPIPAM instance, such as one dropped by a hook: fail the
+	// subnet's section, create the VLAN, or clear the subnet's VLAN.
+	missingVLANs string
+
+	// fetchedVLANs holds the VLANs fetched from the legacy DB before the hooks
+	// ran, from which missing VLANs are created.
+	fetchedVLANs []legacydb.VLAN
+
+	// missingVLANsMu serializes the creation of missing VLANs by concurrent
+	// sections.
+	missingVLANsMu sync.Mutex
+
+	// existingSubnets is what is done with legacy subnets that are already in
+	// their section in the new PHPIPAM instance: skip them, or merge them into
+	// the existing subnet, filling in its blank description, VLAN, VRF, and
+	// nameserver set.
+	existingSubnets string
+
+	// overlappingSubnets is what is done when legacy subnets in the same
+	// section are duplicated, or overlap without nesting: abort the section
+	// before any of its subnets are created, or warn and carry on.
+	overlappingSubnets string
+
+	// aggregateParents is the prefix length of the parent subnets synthesized
+	// for groups of migrated subnets that have no parent, or 0 to synthesize
+	// none. aggregateAs is whether they are created as subnets or folders.
+	aggregateParents int
+	aggregateAs      string
+
+	// addressesUpsert enables updating the description, hostname, and note of
+	// IP addresses that are already in their subnet in the new PHPIPAM
+	// instance, instead of counting them as errors.
+	addressesUpsert bool
+
+	// migrateVRFs enables VRF migration. The legacy VRFs are created in the
+	// new PHPIPAM instance, and subnets are assigned to them.
+	migrateVRFs bool
+
+	// vrfIDs maps the legacy VRF names to the IDs of the VRFs in the new
+	// PHPIPAM instance.
+	vrfIDs = make(map[string]int)
+
+	// migrateRequests enables IP request migration. The legacy requests that
+	// have not been processed are recreated in their subnets in the new
+	// PHPIPAM instance. As the API cannot create requests, this needs a sink
+	// that implements ipamsink.RequestCreator.
+	migrateRequests bool
+
+	// addressStatesFlag is the comma-separated list of LEGACY:TAG address
+	// state mappings supplied with -address-states, parsed into
+	// addressStates.
+	addressStatesFlag string
+
+	// addressStates maps the legacy address states to the IDs of the tags
+	// that the addresses are given in the new PHPIPAM instance. Addresses
+	// with states that are not mapped are left untagged, so PHPIPAM's
+	// default (Used) applies.
+	addressStates = helper.DefaultAddressStates()
+
+	// readAddressStates is false if the legacy DB was found to have no
+	// address state column, as in some dumps, so that the addresses are left
+	// untagged rather than failing to be read.
+	readAddressStates = true
+
+	// readAddressMACs is false if the legacy DB was found to have no MAC
+	// address column, so that the addresses are migrated without MAC
+	// addresses rather than failing to be read.
+	readAddressMACs = true
+
+	// readExcludePing is false if the legacy DB was found to have no exclude
+	// ping column, and readPTRIgnore if it has no PTR ignore column, which
+	// was added in PHPIPAM 1.0, so that the addresses are migrated without
+	// the flags rather than failing to be read.
+	readExcludePing = true
+	readPTRIgnore   = true
+
+	// preserveTimestamps enables the migration of the times that addresses
+	// were last seen alive and last edited, which are otherwise left for the
+	// new PHPIPAM instance to set.
+	preserveTimestamps bool
+
+	// readLastSeen and readEditDate are false if the legacy DB was found to
+	// have no last seen or edit date column, so that the addresses are
+	// migrated without those times rather than failing to be read.
+	readLastSeen = true
+	readEditDate = true
+
+	// normalizeMACs enables MAC address normalization. The MAC addresses of
+	// the migrated addresses are rewritten as colon-separated lowercase, and
+	// those that cannot be parsed are left as they are.
+	normalizeMACs bool
+
+	// lowercaseHostnames and stripHostnameDots normalize the hostnames of the
+	// migrated addresses to lowercase and without trailing dots.
+	lowercaseHostnames bool
+	stripHostnameDots  bool
+
+	// validateHostnames reports the migrated addresses whose hostname is not
+	// a valid RFC 1123 hostname. They are still migrated.
+	validateHostnames bool
+
+	// stamp is the marker that the migrated VLANs, subnets, and addresses are
+	// stamped with, with {date} replaced by the date of the run, or blank to
+	// leave them unmarked.
+	stamp string
+
+	// stampField is the custom field that stamp is written to. If blank, stamp
+	// is appended to descriptions instead.
+	stampField string
+
+	// legacyIDField is the custom field that the legacy IDs of the migrated
+	// VLANs, subnets, and addresses are written to, or blank to not write
+	// them.
+	legacyIDField string
+
+	// decodeHTMLEntities decodes the HTML entities (ie: &amp;) that legacy
+	// PHPIPAM stored in descriptions, hostnames, and notes.
+	decodeHTMLEntities bool
+
+	// skipNetworkBroadcast skips the legacy addresses that are the network or
+	// broadcast address of their subnet.
+	skipNetworkBroadcast bool
+
+	// gatewayPosition is the position of the addresses marked as their
+	// subnet's gateway: the first or last usable address, or blank to mark
+	// none by position.
+	gatewayPosition string
+
+	// gatewayPatternFlag is the regular expression that the description or
+	// hostname of the addresses marked as gateways match, compiled into
+	// gatewayPattern.
+	gatewayPatternFlag string
+	gatewayPattern     *regexp.Regexp
+
+	// withChangelog enables changelog migration. The legacy changelog entries
+	// about the migrated subnets and addresses are copied into the changelog
+	// of the new PHPIPAM instance, keeping their users and dates. As the API
+	// cannot create changelog entries, this needs a sink that implements
+	// ipamsink.ChangelogCreator.
+	withChangelog bool
+
+	// verifyOnly switches the tool into verification mode. Instead of
+	// migrating, the legacy addresses are compared against the ones already in
+	// the new PHPIPAM instance, and any differences are reported. This is a
+	// shortcut for running all stages except for write, plus verify.
+	verifyOnly bool
+
+	// configFile is the path to the YAML configuration file.
+	configFile string
+
+	// stagesFlag is a comma-separated list of the pipeline stages to run,
+	// overriding the stages in the configuration file.
+	stagesFlag string
+
+	// cfg is the loaded configuration. This is an empty configuration if no
+	// configuration file was supplied.
+	cfg = &config.Config{}
+
+	// stages holds the pipeline stages to run, in order.
+	stages = pipeline.DefaultStages
+
+	// dbTLS controls TLS for the legacy DB connection. It can be "true" to
+	// require TLS with server certificate verification, or "skip-verify" to
+	// require TLS without verification. It is set to the name of the registered
+	// custom TLS config when dbCA or dbCert are supplied.
+	dbTLS string
+
+	// sourceCharset is the character set of the text in the legacy DB: utf8,
+	// or latin1 to read it over a latin1 connection and transcode the
+	// descriptions, hostnames, and notes to UTF-8.
+	sourceCharset string
+
+	// dbCA is the path to a PEM CA bundle used to verify the legacy DB server
+	// certificate.
+	dbCA string
+
+	// dbCert is the path to a PEM client certificate for the legacy DB
+	// connection.
+	dbCert string
+
+	// dbKey is the path to the PEM key for dbCert.
+	dbKey string
+
+	// cacheTTL is the time after which cached VLAN and subnet ID lookups expire
+	// and are looked up in the new PHPIPAM instance again. Zero means entries
+	// never expire.
+	cacheTTL time.Duration
+
+	// vlanIDCache caches VLAN number to VLAN ID lookups.
+	vlanIDCache *cache.Cache
+
+	// subnetIDCache caches subnet CIDR to subnet ID lookups.
+	subnetIDCache *cache.Cache
+
+	// apiProxy is the URL of a HTTP proxy to send PHPIPAM API requests through.
+	// When not set, the HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment
+	// variables are honoured.
+	apiProxy string
+
+	// apiCAFile is the path to a PEM CA bundle used to verify the PHPIPAM API's
+	// TLS certificate, for instances using a private CA.
+	apiCAFile string
+
+	// apiInsecure disables verification of the PHPIPAM API's TLS certificate.
+	apiInsecure bool
+
+	// apiClientCert and apiClientKey are the paths to a PEM client certificate
+	// and key, presented to PHPIPAM instances that require mutual TLS.
+	apiClientCert string
+	apiClientKey  string
+
+	// apiTimeout is the maximum duration of each PHPIPAM API request, and
+	// apiConnectTimeout the maximum time to establish its connection.
+	apiTimeout        time.Duration
+	apiConnectTimeout time.Duration
+
+	// apiRate is the maximum number of PHPIPAM API requests sent per second,
+	// with bursts of up to apiBurst requests. 0 disables rate limiting.
+	apiRate  float64
+	apiBurst int
+
+	// apiRetry is the policy used to retry PHPIPAM API calls that fail with
+	// transient errors.
+	apiRetry = retry.Policy{MaxDelay: 30 * time.Second}
+
+	// stateFile is the path to the JSON state file that is carried between
+	// runs. When set, a snapshot of the legacy DB is recorded in it at the end of
+	// each successful migration run.
+	stateFile string
+
+	// skipPreflight skips the preflight check of the PHPIPAM API's required
+	// controllers, the API app's write permissions, and the sections being
+	// migrated into.
+	skipPreflight bool
+
+	// requireEmptySection refuses to migrate into sections that already have
+	// subnets in the new PHPIPAM instance, unless force is set.
+	requireEmptySection bool
+
+	// force runs the migration even if the -require-empty-section check
+	// fails.
+	force bool
+
+	// backupTarget is the path to back up the existing sections, VLANs,
+	// subnets, and IP addresses of the new PHPIPAM instance to, through the
+	// API, before anything is written to it.
+	backupTarget string
+
+	// freezeCheck switches the tool into freeze check mode. Instead of
+	// migrating, the legacy DB is checked for writes made since the last sync
+	// recorded in the state file.
+	freezeCheck bool
+
+	// auditLegacy switches the tool into audit mode. Instead of migrating,
+	// the legacy DB is checked for data-quality problems that would cause
+	// rows to be skipped or mangled by a migration.
+	auditLegacy bool
+
+	// freezeWindow is how long to monitor the legacy DB for writes in freeze
+	// check mode. Zero checks once.
+	freezeWindow time.Duration
+
+	// changeNotes controls whether or not a summary of the changes made to an
+	// address during migration is appended to its note.
+	changeNotes bool
+
+	// capabilities holds the optional features found to be supported by the
+	// new PHPIPAM instance's API at startup.
+	capabilities probe.Capabilities
+
+	// dbDriver is the database/sql driver used to connect to the legacy DB.
+	// This is switched out when recording, replaying, or reading a dump or CSV
+	// files.
+	dbDriver = "mysql"
 )
 
 func init() {
 	flag.StringVar(&dbHost, "dbhost", "", "The database host to connect to")
 	flag.StringVar(&dbUser, "dbuser", "phpipam", "The database user to use")
-	flag.StringVar(&dbPassword, "dbpassword", "", "The password for the database user")
+	flag.StringVar(&dbPassword, "dbpassword", "", "The password for the database user (or set LEGACY_DB_PASSWORD)")
 	flag.StringVar(&dbName, "dbname", "phpipam", "The name of the database to import data from")
+	flag.IntVar(&dbPort, "dbport", 3306, "The TCP port of the database host")
+	flag.StringVar(&dbSocket, "db-socket", "", "The path to the database UNIX socket (ie: /var/run/mysqld/mysqld.sock)")
+	flag.StringVar(&sshHost, "ssh-host", "", "An SSH server (host[:port]) to tunnel the database connection through")
+	flag.StringVar(&sshUser, "ssh-user", "", "The user to log in to the SSH server as")
+	flag.StringVar(&sshKey, "ssh-key", "", "The private key to log in to the SSH server with")
+	flag.StringVar(&dbTLS, "db-tls", "", "Use TLS for the database connection (true or skip-verify)")
+	flag.StringVar(&dbCA, "db-ca", "", "A PEM CA bundle to verify the database server certificate with (implies -db-tls=true)")
+	flag.StringVar(&dbCert, "db-cert", "", "A PEM client certificate for the database connection")
+	flag.StringVar(&dbKey, "db-key", "", "The PEM key for the database client certificate")
+	flag.StringVar(&sourceCharset, "source-charset", "utf8", "The character set of the legacy DB's text: utf8, or latin1 to transcode descriptions, hostnames, and notes to UTF-8")
+	flag.StringVar(&dbDSN, "dsn", "", "A complete MySQL DSN to connect with, overriding all other database options")
+	flag.StringVar(&targetDSN, "target-dsn", "", "A MySQL DSN for the new PHPIPAM database, to write into directly instead of through the API")
+	flag.StringVar(&target, "target", "phpipam", "The IPAM to migrate to: phpipam, netbox to write the migrated objects to the NetBox instance at -netbox-url, or nautobot to write them to the Nautobot instance at -nautobot-url")
+	flag.StringVar(&netboxURL, "netbox-url", "", "The base URL of the NetBox instance to migrate to with -target netbox (ie: https://netbox.example.com)")
+	flag.StringVar(&netboxToken, "netbox-token", "", "The NetBox API token (or set NETBOX_TOKEN)")
+	flag.StringVar(&nautobotURL, "nautobot-url", "", "The base URL of the Nautobot instance to migrate to with -target nautobot (ie: https://nautobot.example.com)")
+	flag.StringVar(&nautobotToken, "nautobot-token", "", "The Nautobot API token (or set NAUTOBOT_TOKEN)")
+	flag.StringVar(&nautobotStatus, "nautobot-status", nautobotsink.DefaultStatus, "The name of the Nautobot status to create objects with")
+	flag.StringVar(&nautobotNamespace, "nautobot-namespace", nautobotsink.DefaultNamespace, "The name of the Nautobot namespace to create prefixes and IP addresses in")
+	flag.StringVar(&output, "output", "api", "Where to write the migrated objects: api, sql to write a SQL script of INSERT statements for the new PHPIPAM database to -output-file, json or yaml to export them to -output-file, terraform to write them to -output-file as Terraform configuration for the PHPIPAM provider, or csv to export them as files for PHPIPAM's import tool in the -output-file directory")
+	flag.StringVar(&outputFile, "output-file", "", "The file (or directory, with -output csv) to write the migrated objects to with -output")
+	flag.StringVar(&sourceDump, "source-dump", "", "Read the legacy DB from this mysqldump file instead of connecting to MySQL")
+	flag.StringVar(&schemaMapping, "schema-mapping", "", "A YAML file mapping the tables, columns, and queries of a customized legacy schema")
+	flag.StringVar(&dbTablePrefix, "db-table-prefix", "", "The prefix of the legacy table names (ie: ipam_ for ipam_subnets)")
+	flag.StringVar(&sourceCSV, "source-csv", "", "Read the VLANs, subnets, and addresses to migrate from the CSV files in this directory instead of the legacy DB")
 	flag.StringVar(&ipamAppID, "appid", "", "The PHPIPAM application ID to use")
 	flag.StringVar(&ipamEndpoint, "endpoint", "", "The PHPIPAM endpoint to connect to")
 	flag.StringVar(&ipamPassword, "password", "", "The password for the PHPIPAM user")
 	flag.StringVar(&ipamUser, "user", "", "The user to use when connecting to PHPIPAM")
-	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
+	flag.BoolVar(&debug, "debug", false, "Enable debug logging (same as -log-level debug)")
+	flag.StringVar(&logLevel, "log-level", "info", "The minimum level of messages to log (debug, info, warn, or error)")
+	flag.BoolVar(&quiet, "quiet", false, "Only log warnings and errors (same as -log-level warn)")
+	flag.StringVar(&logFormat, "log-format", "text", "The format of log output (text or json)")
 	flag.IntVar(&sectionID, "sectionid", 1, "The section ID to add addresses to")
+	flag.StringVar(&sectionName, "section-name", "", "The name of the section to add addresses to, overriding -sectionid. The section is created if it does not exist")
+	flag.StringVar(&sectionsFlag, "sections", "", "A comma-separated list of LEGACY:NEW section ID pairs to migrate in parallel, overriding -sectionid (ie: 1:3,2:4)")
+	flag.BoolVar(&sectionHierarchy, "section-hierarchy", false, "Make the sections in -sections subsections of the sections that the masters of their legacy sections are migrated to")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on /metrics at this address during the run (ie: :9100)")
+	flag.StringVar(&webUIAddr, "web-ui", "", "Serve a web dashboard of the run's progress, errors, and records processed, with a downloadable report, at this address, until interrupted once the run has finished (ie: :8080)")
+	flag.StringVar(&pprofAddr, "pprof-addr", "", "Serve the pprof profiling endpoints on /debug/pprof/ at this address during the run (ie: localhost:6060)")
+	flag.StringVar(&notifyURL, "notify-url", "", "POST a JSON report of the run (status, counts, and duration) to this URL when it completes or fails")
+	flag.BoolVar(&tui, "tui", false, "Take over the terminal with a full-screen display of a progress bar for each phase, the record it is on, the error count, and the API latency, instead of streaming log lines")
+	flag.BoolVar(&showProgress, "progress", false, "Display the progress and estimated time remaining of each phase of the migration")
+	flag.IntVar(&addressWorkers, "workers", 1, "The number of workers adding IP addresses concurrently in each section")
+	flag.IntVar(&sectionErrorBudget, "section-error-budget", 0, "The number of subnets and addresses that can fail to migrate in a section before the section is aborted")
+	flag.StringVar(&journalFile, "journal", "", "Append every POST, PATCH, PUT, and DELETE request made to the target API, with its response and the ID it returned, to this JSON Lines journal file")
+	flag.StringVar(&recordFile, "record", "", "Record all database rows and API responses to this bundle file")
+	flag.StringVar(&replayFile, "replay", "", "Replay the migration offline from this previously recorded bundle file")
+	flag.BoolVar(&replayStrict, "replay-strict", false, "Fail a run replayed with -replay if any API request differs from the recording, as after a change to the transformation logic")
+	flag.BoolVar(&migrateDevices, "migrate-devices", false, "Create devices from legacy address switch names and link addresses to them and their switch ports")
+	flag.BoolVar(&migrateVRFs, "migrate-vrfs", false, "Create the legacy VRFs and assign subnets to them")
+	flag.BoolVar(&migrateNameservers, "migrate-nameservers", false, "Create the legacy nameserver sets and link subnets to them")
+	flag.BoolVar(&l2DomainPerSection, "l2-domain-per-section", false, "Create an L2 domain for each migrated section, and create the VLANs used by its subnets in it instead of the default domain")
+	flag.StringVar(&l2DomainsFile, "l2-domains-file", "", "A YAML file listing L2 domains and the VLAN numbers to create in each, which takes precedence over -l2-domain-per-section")
+	flag.StringVar(&duplicateVLANs, "duplicate-vlans", "merge", "What to do with legacy VLANs whose number is used by more than one VLAN: merge them into one, suffix the names of all but the first, or create all but the first in separate L2 domains (suffix and domains require the PHPIPAM API)")
+	flag.StringVar(&missingVLANs, "missing-vlans", "error", "What to do with subnets whose VLAN is not in the new PHPIPAM instance, such as one dropped by a hook: fail with an error, create the VLAN, or clear the subnet's VLAN with a warning")
+	flag.StringVar(&existingVLANs, "existing-vlans", "skip", "What to do with legacy VLANs whose number is already used in their L2 domain in the new PHPIPAM instance: skip them, or merge them to fill in the existing VLAN's blank name and description")
+	flag.IntVar(&aggregateParents, "aggregate-parents", 0, "Synthesize a parent subnet of this prefix length (ie: 16) for each group of migrated subnets that it would contain and that have no parent in the legacy DB or the new PHPIPAM instance")
+	flag.StringVar(&aggregateAs, "aggregate-as", "subnet", "Create the parents synthesized with -aggregate-parents as a subnet or a folder")
+	flag.StringVar(&overlappingSubnets, "overlapping-subnets", "abort", "What to do when legacy subnets in the same section are duplicated or overlap without nesting, which PHPIPAM rejects: abort the section before creating anything, or warn and carry on")
+	flag.StringVar(&existingSubnets, "existing-subnets", "skip", "What to do with legacy subnets that are already in their section in the new PHPIPAM instance: skip them, or merge them to fill in the existing subnet's blank description, VLAN, VRF, and nameserver set")
+	flag.BoolVar(&addressesUpsert, "addresses-upsert", false, "Update the description, hostname, and note of IP addresses that are already in their subnet in the new PHPIPAM instance, instead of failing to add them")
+	flag.BoolVar(&migrateRequests, "migrate-requests", false, "Recreate the legacy IP requests that have not been processed (requires -target-dsn, or -output sql, json, or yaml)")
+	flag.BoolVar(&preserveTimestamps, "preserve-timestamps", false, "Carry the times that addresses were last seen alive and last edited over from the legacy DB")
+	flag.BoolVar(&normalizeMACs, "normalize-macs", false, "Normalize MAC addresses to colon-separated lowercase (ie: 00:1a:2b:3c:4d:5e)")
+	flag.BoolVar(&reverseDNS, "reverse-dns", false, "Fill in the blank hostnames of migrated addresses from their PTR records")
+	flag.StringVar(&reverseDNSServer, "reverse-dns-server", "", "The DNS server (host:port) to look up PTR records with -reverse-dns (default the system resolver)")
+	flag.DurationVar(&reverseDNSTimeout, "reverse-dns-timeout", 2*time.Second, "The maximum duration of each PTR lookup with -reverse-dns")
+	flag.StringVar(&livenessCheck, "liveness-check", "", "Check each migrated address for liveness with tcp connections or icmp pings (with the system ping command), and write those found alive as last seen now, and those not found alive as never seen and offline")
+	flag.StringVar(&livenessPortsFlag, "liveness-ports", "22,80,443", "A comma-separated list of the TCP ports tried by -liveness-check tcp; a refused connection counts as alive")
+	flag.DurationVar(&livenessTimeout, "liveness-timeout", time.Second, "The maximum duration of each ping or TCP connection attempt with -liveness-check")
+	flag.Float64Var(&livenessRate, "liveness-rate", 50, "The maximum number of addresses checked per second with -liveness-check")
+	flag.BoolVar(&lowercaseHostnames, "lowercase-hostnames", false, "Lowercase the hostnames of migrated addresses")
+	flag.BoolVar(&stripHostnameDots, "strip-hostname-dots", false, "Remove trailing dots from the hostnames of migrated addresses (ie: host.example.com. to host.example.com)")
+	flag.BoolVar(&validateHostnames, "validate-hostnames", false, "Report the migrated addresses whose hostname is not a valid RFC 1123 hostname, in the log and the runbook")
+	flag.StringVar(&stamp, "stamp", "", "Mark the migrated VLANs, subnets, and addresses with this text, appended to their descriptions or written to -stamp-field, with {date} replaced by the date of the run (ie: [migrated {date}])")
+	flag.StringVar(&stampField, "stamp-field", "", "The custom field to write -stamp to, which is created if needed with -target-dsn or -output sql, and must otherwise exist, instead of appending it to descriptions (ie: custom_Migrated)")
+	flag.StringVar(&legacyIDField, "legacy-id-field", "", "The custom field to write the legacy IDs of the migrated VLANs, subnets, and addresses to, which is created if needed with -target-dsn or -output sql, and must otherwise exist (ie: legacy_id)")
+	flag.BoolVar(&decodeHTMLEntities, "decode-html-entities", false, "Decode the HTML entities (ie: &amp; and &quot;) in legacy descriptions, hostnames, and notes")
+	flag.BoolVar(&skipNetworkBroadcast, "skip-network-broadcast", false, "Skip the legacy addresses that are the network or broadcast address of their subnet, writing them to -skipped-file")
+	flag.StringVar(&gatewayPosition, "gateway-position", "", "Mark the first or last usable address of each subnet as its gateway")
+	flag.StringVar(&gatewayPatternFlag, "gateway-pattern", "", "Mark the addresses whose description or hostname matches this regular expression as gateways (ie: (?i)gateway|router)")
+	flag.StringVar(&addressStatesFlag, "address-states", "", "A comma-separated list of LEGACY:TAG pairs mapping legacy address states to the names or IDs of address tags, in addition to the standard states 0 (Offline), 1 (Used), 2 (Reserved), and 3 (DHCP) (ie: 4:Reserved,5:7)")
+	flag.BoolVar(&withChangelog, "with-changelog", false, "Copy the legacy changelog entries about the migrated subnets and addresses (requires -target-dsn, or -output sql, json, or yaml)")
+	flag.BoolVar(&migrateUsers, "migrate-users", false, "Create the legacy users and groups, and add users to their groups (requires -target-dsn, or -output sql, json, or yaml)")
+	flag.StringVar(&usersDefaultPassword, "users-default-password", "", "The password to give migrated users, who must change it at their next login (or set USERS_DEFAULT_PASSWORD; default none, so an administrator must set one)")
+	flag.BoolVar(&verifyOnly, "verify", false, "Verify a previous migration against the legacy DB instead of migrating")
+	flag.StringVar(&configFile, "config", "", "The path to a YAML configuration file")
+	flag.StringVar(&stagesFlag, "stages", "", "A comma-separated list of pipeline stages to run, in order (default \"fetch,validate,transform,resolve,write\")")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 0, "How long VLAN and subnet ID lookups are cached before being looked up again (0 caches forever)")
+	flag.StringVar(&apiProxy, "api-proxy", "", "The URL of a proxy to send PHPIPAM API requests through (default $HTTPS_PROXY)")
+	flag.StringVar(&apiCAFile, "api-ca-file", "", "The PEM CA bundle to verify the PHPIPAM API's TLS certificate with")
+	flag.BoolVar(&apiInsecure, "api-insecure", false, "Skip verification of the PHPIPAM API's TLS certificate (insecure)")
+	flag.StringVar(&apiClientCert, "api-client-cert", "", "The PEM client certificate to present to the PHPIPAM API")
+	flag.StringVar(&apiClientKey, "api-client-key", "", "The PEM private key for -api-client-cert")
+	flag.DurationVar(&apiTimeout, "api-timeout", 60*time.Second, "The maximum duration of each PHPIPAM API request, including reading the response (0 for no limit)")
+	flag.Float64Var(&apiRate, "api-rate", 0, "The maximum number of PHPIPAM API requests to send per second (0 for no limit)")
+	flag.IntVar(&apiBurst, "api-burst", 1, "The number of PHPIPAM API requests that can be sent in a burst above -api-rate")
+	flag.IntVar(&apiRetry.Retries, "api-retries", 3, "The number of times to retry PHPIPAM API calls that fail with transient errors (0 disables retries)")
+	flag.DurationVar(&apiRetry.BaseDelay, "api-retry-delay", time.Second, "The delay before the first retry of a PHPIPAM API call, which doubles with each retry")
+	flag.DurationVar(&apiConnectTimeout, "api-connect-timeout", 30*time.Second, "The maximum time to connect to the PHPIPAM API (0 for no limit)")
+	flag.StringVar(&hookPlugins, "hook-plugin", "", "A comma-separated list of Go plugins to load hooks from, run on each VLAN, subnet, and address in the transform stage")
+	flag.StringVar(&orphansSubnet, "orphans-subnet", "", "Create this catch-all subnet (ie: 10.255.0.0/16) in the first migrated section, and place the legacy addresses whose subnet does not exist in it, instead of skipping them")
+	flag.StringVar(&skippedFile, "skipped-file", "", "Write the legacy rows that are skipped rather than migrated (ie: non-IPv4 addresses, or addresses in missing subnets) to this CSV file, with the reason for each")
+	flag.StringVar(&runbookFile, "runbook", "", "Write a checklist of manual follow-ups to this file at the end of the run (Markdown, or JSON with a .json extension)")
+	flag.StringVar(&stateFile, "state-file", "", "The path to a state file used to carry state between runs")
+	flag.StringVar(&sinceFlag, "since", "", "Only migrate the legacy addresses and changelog entries added or edited after this time (ie: 2024-05-01 12:00:00), or after the last sync recorded in -state-file with last, for a catch-up pass before cutover")
+	flag.BoolVar(&skipPreflight, "skip-preflight", false, "Skip checking that the PHPIPAM API's required controllers respond, that the API app can write, and that the sections being migrated into exist before migrating")
+	flag.BoolVar(&requireEmptySection, "require-empty-section", false, "Refuse to migrate if any section being migrated into already has subnets in the new PHPIPAM instance, to prevent migrating twice into a populated section")
+	flag.BoolVar(&force, "force", false, "Migrate even if the -require-empty-section check fails")
+	flag.StringVar(&backupTarget, "backup-target", "", "Back up the existing sections, VLANs, subnets, and IP addresses of the new PHPIPAM instance to this JSON file through the API before migrating, as a restore point")
+	flag.BoolVar(&freezeCheck, "freeze-check", false, "Check the legacy DB for writes since the last sync in the state file instead of migrating")
+	flag.BoolVar(&interactive, "interactive", false, "Walk through the connection details, the section to migrate into, and the entities to migrate in a wizard, and confirm before migrating")
+	flag.BoolVar(&auditLegacy, "audit", false, "Audit the legacy DB for data-quality problems instead of migrating")
+	flag.BoolVar(&finalRun, "final", false, "Run the final pass of a two-phase cutover: record a write freeze marker in -state-file, migrate the delta since the bulk migration (as -since last), and write a cutover report")
+	flag.StringVar(&cutoverReportFile, "cutover-report", "cutover-report.md", "The path to write the cutover report to with -final")
+	flag.DurationVar(&freezeWindow, "freeze-window", 0, "How long to monitor the legacy DB for writes with -freeze-check (0 checks once)")
+	flag.BoolVar(&changeNotes, "change-notes", true, "Append a note to addresses summarizing any changes made to them during migration")
+	flag.StringVar(&vaultAddr, "vault-addr", "", "The address of the Vault server to read credentials from (default $VAULT_ADDR)")
+	flag.StringVar(&vaultSecretPath, "vault-secret-path", "", "The Vault secret path to read the db_password and phpipam_password keys from")
+
+}
 
+// setup parses the command line, and configures the migration from it. This
+// is done at the start of main rather than in init, so that the package can be
+// tested.
+func setup() {
 	flag.Parse()
 
-	if debug {
-		logrus.SetLevel(logrus.DebugLevel)
+	setupLogLevel()
+	if notifyURL != "" {
+		setupNotify()
 	}
-	if dbPassword == "" {
-		if dbHost != "" {
-			// we only use TCP, and port 3306, so update the hostname so that it
-			// works with the DSN.
-			dbHost = fmt.Sprintf("tcp(%s:3306)", dbHost)
+	switch logFormat {
+	case "text":
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		logrus.Fatalf("Invalid -log-format %q: must be text or json", logFormat)
+	}
+	loadConfig()
+	if schemaMapping != "" {
+		var err error
+		if legacyMapping, err = legacydb.LoadMapping(schemaMapping); err != nil {
+			logrus.Fatalf("Error loading schema mapping: %s", err)
+		}
+	}
+	if dbTablePrefix != "" {
+		if legacyMapping == nil {
+			legacyMapping = &legacydb.Mapping{}
+		}
+		legacyMapping.TablePrefix = dbTablePrefix
+	}
+	legacyQueries = legacyMapping.BuildQueries()
+	if sectionName != "" && sectionsFlag != "" {
+		logrus.Fatal("-section-name cannot be used with -sections")
+	}
+	if sectionName != "" && (output != "api" || target != "phpipam") {
+		logrus.Fatal("-section-name can only be used with the PHPIPAM API or -target-dsn, as sections cannot be looked up by name otherwise")
+	}
+	if sectionHierarchy && sectionsFlag == "" {
+		logrus.Fatal("-section-hierarchy can only be used with -sections")
+	}
+	if sectionHierarchy && (output != "api" || target != "phpipam") {
+		logrus.Fatal("-section-hierarchy can only be used with the PHPIPAM API or -target-dsn, as sections cannot be updated otherwise")
+	}
+	if sectionsFlag != "" {
+		var err error
+		if sectionMappings, err = helper.ParseSectionMappings(sectionsFlag); err != nil {
+			logrus.Fatalf("Invalid -sections: %s", err)
+		}
+	}
+	if addressStatesFlag != "" {
+		var err error
+		if addressStates, err = helper.ParseAddressStates(addressStatesFlag); err != nil {
+			logrus.Fatalf("Invalid -address-states: %s", err)
+		}
+	}
+	if hookPlugins != "" {
+		loadHookPlugins()
+	}
+	vlanIDCache = cache.New(cacheTTL, lookupVLANID)
+	subnetIDCache = cache.New(cacheTTL, func(key string) (int, error) { return lookupSubnetID(sink, key) })
+	switch output {
+	case "api":
+	case "sql", export.JSON, export.YAML, export.Terraform, export.CSV:
+		if outputFile == "" {
+			logrus.Fatalf("-output %s requires -output-file", output)
+		}
+		if targetDSN != "" || verifyOnly {
+			logrus.Fatalf("-output %s cannot be used with -target-dsn or -verify", output)
 		}
+	default:
+		logrus.Fatalf("Invalid -output %q: must be api, sql, json, yaml, terraform, or csv", output)
+	}
+	if migrateRequests && (output == "api" && targetDSN == "" || output == export.Terraform || output == export.CSV) {
+		logrus.Fatal("-migrate-requests requires -target-dsn, or -output sql, json, or yaml, as IP requests cannot be created through the PHPIPAM API")
+	}
+	if withChangelog && (output == "api" && targetDSN == "" || output == export.Terraform || output == export.CSV) {
+		logrus.Fatal("-with-changelog requires -target-dsn, or -output sql, json, or yaml, as changelog entries cannot be created through the PHPIPAM API")
+	}
+	if migrateUsers && (output == "api" && targetDSN == "" || output == export.Terraform || output == export.CSV) {
+		logrus.Fatal("-migrate-users requires -target-dsn, or -output sql, json, or yaml, as users cannot be created through the PHPIPAM API")
+	}
+	if duplicateVLANs != "merge" && duplicateVLANs != "suffix" && duplicateVLANs != "domains" {
+		logrus.Fatalf("Invalid -duplicate-vlans %q: must be merge, suffix, or domains", duplicateVLANs)
+	}
+	if separatesDuplicateVLANs() && (output != "api" || target != "phpipam" || sourceCSV != "") {
+		logrus.Fatalf("-duplicate-vlans %s can only be used with the PHPIPAM API and a legacy DB, as the legacy VLAN IDs are needed to assign subnets to the right VLAN", duplicateVLANs)
+	}
+	if duplicateVLANs == "suffix" && targetDSN != "" {
+		logrus.Fatal("-duplicate-vlans suffix cannot be used with -target-dsn, which does not create VLANs whose number is already used in their L2 domain")
+	}
+	if migratesL2Domains() && (output == export.Terraform || output == export.CSV) {
+		logrus.Fatalf("-l2-domain-per-section and -l2-domains-file cannot be used with -output %s, which has no L2 domains", output)
+	}
+	if orphansSubnet != "" {
+		ip, n, err := net.ParseCIDR(orphansSubnet)
+		if err != nil || ip.To4() == nil {
+			logrus.Fatalf("Invalid -orphans-subnet %q: must be an IPv4 CIDR", orphansSubnet)
+		}
+		orphansSubnet = n.String()
+	}
+	if missingVLANs != "error" && missingVLANs != "create" && missingVLANs != "clear" {
+		logrus.Fatalf("Invalid -missing-vlans %q: must be error, create, or clear", missingVLANs)
+	}
+	if existingVLANs != "skip" && existingVLANs != "merge" {
+		logrus.Fatalf("Invalid -existing-vlans %q: must be skip or merge", existingVLANs)
+	}
+	if aggregateParents != 0 && (aggregateParents < 8 || aggregateParents > 30) {
+		logrus.Fatalf("Invalid -aggregate-parents %d: must be between 8 and 30", aggregateParents)
+	}
+	if aggregateAs != "subnet" && aggregateAs != "folder" {
+		logrus.Fatalf("Invalid -aggregate-as %q: must be subnet or folder", aggregateAs)
+	}
+	if stampField != "" && stamp == "" {
+		logrus.Fatal("-stamp-field requires -stamp")
+	}
+	if stampField != "" && !regexp.MustCompile(`^\w+$`).MatchString(stampField) {
+		logrus.Fatalf("Invalid -stamp-field %q: must be a column name", stampField)
+	}
+	if legacyIDField != "" && !regexp.MustCompile(`^\w+$`).MatchString(legacyIDField) {
+		logrus.Fatalf("Invalid -legacy-id-field %q: must be a column name", legacyIDField)
+	}
+	if legacyIDField != "" && legacyIDField == stampField {
+		logrus.Fatal("-legacy-id-field and -stamp-field must be different custom fields")
+	}
+	if stampField == "" && len([]rune(stamp)) >= transform.MaxAddressDescription {
+		logrus.Fatalf("Invalid -stamp %q: must be shorter than %d characters to fit address descriptions, or be written to -stamp-field", stamp, transform.MaxAddressDescription)
+	}
+	stamp = strings.Replace(stamp, "{date}", time.Now().Format("2006-01-02"), -1)
+	if reverseDNSServer != "" && !reverseDNS {
+		logrus.Fatal("-reverse-dns-server requires -reverse-dns")
+	}
+	if _, _, err := net.SplitHostPort(reverseDNSServer); reverseDNSServer != "" && err != nil {
+		reverseDNSServer = net.JoinHostPort(reverseDNSServer, "53")
+	}
+	setupReverseDNS()
+	setupLiveness()
+	if sourceCharset != "utf8" && sourceCharset != "latin1" {
+		logrus.Fatalf("Invalid -source-charset %q: must be utf8 or latin1", sourceCharset)
+	}
+	if gatewayPosition != "" && gatewayPosition != "first" && gatewayPosition != "last" {
+		logrus.Fatalf("Invalid -gateway-position %q: must be first or last", gatewayPosition)
+	}
+	if gatewayPatternFlag != "" {
+		var err error
+		if gatewayPattern, err = regexp.Compile(gatewayPatternFlag); err != nil {
+			logrus.Fatalf("Invalid -gateway-pattern: %s", err)
+		}
+	}
+	if overlappingSubnets != "abort" && overlappingSubnets != "warn" {
+		logrus.Fatalf("Invalid -overlapping-subnets %q: must be abort or warn", overlappingSubnets)
+	}
+	if existingSubnets != "skip" && existingSubnets != "merge" {
+		logrus.Fatalf("Invalid -existing-subnets %q: must be skip or merge", existingSubnets)
+	}
+	if journalFile != "" && (output != "api" || targetDSN != "") {
+		logrus.Fatal("-journal can only be used when writing through an API")
+	}
+	if requireEmptySection && (output != "api" || target != "phpipam") {
+		logrus.Fatal("-require-empty-section can only be used with the PHPIPAM API or -target-dsn, as existing subnets cannot be read otherwise")
+	}
+	if requireEmptySection && (sinceFlag != "" || finalRun) {
+		logrus.Fatal("-require-empty-section cannot be used with -since or -final, as delta passes migrate into populated sections")
+	}
+	if force && !requireEmptySection {
+		logrus.Fatal("-force can only be used with -require-empty-section")
+	}
+	if backupTarget != "" && (output != "api" || target != "phpipam" || targetDSN != "") {
+		logrus.Fatal("-backup-target can only be used with the PHPIPAM API")
+	}
+	if addressesUpsert && (output != "api" || target != "phpipam") {
+		logrus.Fatal("-addresses-upsert can only be used with the PHPIPAM API or -target-dsn, as existing IP addresses cannot be updated otherwise")
+	}
+	if l2DomainsFile != "" {
+		var err error
+		if l2DomainMapping, err = helper.LoadL2DomainMapping(l2DomainsFile); err != nil {
+			logrus.Fatalf("Error loading L2 domain mapping: %s", err)
+		}
+	}
+	if usersDefaultPassword == "" {
+		usersDefaultPassword = os.Getenv("USERS_DEFAULT_PASSWORD")
+	}
+	if usersDefaultPassword != "" && !migrateUsers {
+		logrus.Fatal("-users-default-password requires -migrate-users")
+	}
+	switch target {
+	case "phpipam":
+	case "netbox":
+		if netboxToken == "" {
+			netboxToken = os.Getenv("NETBOX_TOKEN")
+		}
+		if netboxURL == "" || netboxToken == "" {
+			logrus.Fatal("-target netbox requires -netbox-url and -netbox-token")
+		}
+	case "nautobot":
+		if nautobotToken == "" {
+			nautobotToken = os.Getenv("NAUTOBOT_TOKEN")
+		}
+		if nautobotURL == "" || nautobotToken == "" {
+			logrus.Fatal("-target nautobot requires -nautobot-url and -nautobot-token")
+		}
+	default:
+		logrus.Fatalf("Invalid -target %q: must be phpipam, netbox, or nautobot", target)
+	}
+	if target != "phpipam" {
+		if output != "api" || targetDSN != "" {
+			logrus.Fatalf("-target %s cannot be used with -output or -target-dsn", target)
+		}
+		if replayFile != "" || recordFile != "" {
+			logrus.Fatalf("-target %s cannot be used with -record or -replay, which only cover the PHPIPAM API", target)
+		}
+		if migrateDevices {
+			logrus.Fatalf("-migrate-devices cannot be used with -target %s, as its devices need a device type, role, and site", target)
+		}
+		if migrateNameservers {
+			logrus.Fatalf("-migrate-nameservers cannot be used with -target %s, as it has no nameserver sets", target)
+		}
+		if migratesL2Domains() {
+			logrus.Fatalf("-l2-domain-per-section and -l2-domains-file cannot be used with -target %s, as it has no L2 domains", target)
+		}
+	}
+	if tui && !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		logrus.Fatal("-tui requires a terminal")
+	}
+	if replayStrict && replayFile == "" {
+		logrus.Fatal("-replay-strict can only be used with -replay")
+	}
+	if targetDSN != "" && replayFile != "" {
+		logrus.Fatal("-target-dsn cannot be used with -replay, as a replayed run is offline")
+	}
+	if interactive {
+		setupInteractive()
+	}
+	if replayFile != "" {
+		setupReplay()
+		return
+	}
+	if vaultSecretPath != "" {
+		readVaultCredentials()
+	}
+	setupDBTLS()
+	if addressWorkers < 1 {
+		logrus.Fatal("-workers must be at least 1")
+	}
+	setupFinal()
+	setupDelta()
+	if freezeCheck && stateFile == "" {
+		logrus.Fatal("-freeze-check requires -state-file")
+	}
+	if auditLegacy && (freezeCheck || finalRun || verifyOnly) {
+		logrus.Fatal("-audit cannot be combined with -freeze-check, -final, or -verify")
+	}
+	if dbHost != "" && dbSocket != "" {
+		logrus.Fatal("Only one of -dbhost and -db-socket can be supplied")
+	}
+	if sshHost != "" && (dbSocket != "" || dbDSN != "") {
+		logrus.Fatal("-ssh-host cannot be used with -db-socket or -dsn")
+	}
+	if sourceDump != "" || sourceCSV != "" {
+		setupSource()
+	}
+	if dbPassword == "" {
+		dbPassword = os.Getenv("LEGACY_DB_PASSWORD")
+	}
+	if dbPassword == "" && dbDSN == "" && sourceDump == "" && sourceCSV == "" {
 		fmt.Printf("Enter the database password for %s@%s/%s: ", dbUser, dbHost, dbName)
 		b, err := terminal.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
@@ -111,7 +1003,7 @@ func init() {
 		dbPassword = string(b)
 	}
 
-	if ipamPassword == "" && os.Getenv("PHPIPAM_PASSWORD") == "" {
+	if ipamPassword == "" && os.Getenv("PHPIPAM_PASSWORD") == "" && targetDSN == "" && output == "api" && target == "phpipam" && !auditLegacy {
 		fmt.Print("Enter the PHPIPAM password:")
 		b, err := terminal.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
@@ -130,247 +1022,1666 @@ func init() {
 			Username: ipamUser,
 		},
 	)
-}
+	setupAPITransport()
 
-// runSQL is a helper function that runs SQL. It logs the query as a debug
-// message, and exits the program if the SQL query fails.
-func runSQL(conn *sql.DB, query string) *sql.Rows {
-	logrus.Debugf("Running SQL query: %s", query)
-	rows, err := conn.Query(query)
-	if err != nil {
-		logrus.Fatalf("Fatal: error running SQL query: %s", err)
+	if recordFile != "" {
+		setupRecording()
 	}
-	return rows
-}
-
-// decimalIPAddrToString converts a decimal IPv4 address to a dotted-quad
-// string, ie: 1.2.3.4.
-func decimalIPAddrToString(addr string) (string, error) {
-	d, err := strconv.ParseUint(addr, 10, 32)
-	if err != nil {
-		return "", err
+	if interactive {
+		wiz.chooseSection(ipamsink.New(ipamSession, apiRetry))
+		wiz.chooseEntities()
 	}
-	ip := make(net.IP, 4)
-	binary.BigEndian.PutUint32(ip, uint32(d))
-	return ip.String(), nil
 }
 
-// vlanIDForNumber fetches the VLAN ID for a specific VLAN number.
-func vlanIDForNumber(n int) int {
-	c := vlans.NewController(ipamSession)
-	vlans, err := c.GetVLANsByNumber(n)
-	if err != nil {
-		logrus.Fatalf("Error getting VLAN ID for number %d: %s", n, err)
+// setupLogLevel sets the log level from -log-level, or its -debug and -quiet
+// shortcuts. Only one of these can be supplied.
+func setupLogLevel() {
+	var set []string
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "debug", "log-level", "quiet":
+			set = append(set, "-"+f.Name)
+		}
+	})
+	if len(set) > 1 {
+		logrus.Fatalf("Only one of %s can be supplied", strings.Join(set, ", "))
 	}
 
-	logrus.Debugf("Found VLAN ID %d for VLAN number %d in new PHPIPAM database", vlans[0].ID, n)
-	return vlans[0].ID
-}
-
-// subnetIDForCIDR fetches a subnet ID via its CIDR subnet address
-func subnetIDForCIDR(cidr string) int {
-	c := subnets.NewController(ipamSession)
-	subnets, err := c.GetSubnetsByCIDR(cidr)
+	switch {
+	case debug:
+		logLevel = "debug"
+	case quiet:
+		logLevel = "warn"
+	}
+	level, err := logrus.ParseLevel(logLevel)
 	if err != nil {
-		logrus.Fatalf("Error getting subnet ID for CIDR %s: %s", cidr, err)
+		logrus.Fatalf("Invalid -log-level: %s", err)
 	}
-
-	logrus.Debugf("Found subnet ID %d for CIDR %s in new PHPIPAM database", subnets[0].ID, cidr)
-	return subnets[0].ID
+	logrus.SetLevel(level)
 }
 
-// fetchVLANs gets all the VLANs from the legacy DB and returns a []vlans.VLAN.
-func fetchVLANs(conn *sql.DB) (out []vlans.VLAN) {
-	logrus.Info("Fetching VLANs from legacy DB")
-
-	rows := runSQL(conn, "select name, number, description from vlans")
-	defer rows.Close()
-	for rows.Next() {
-		var name, description string
-		var number int
-		if err := rows.Scan(&name, &number, &description); err != nil {
-			logrus.Fatalf("Error reading VLAN rows: %s", err)
+// loadConfig loads the configuration file, if supplied, and works out the
+// pipeline stages to run from it and the command line.
+func loadConfig() {
+	if configFile != "" {
+		var err error
+		if cfg, err = config.Load(configFile); err != nil {
+			logrus.Fatalf("Error loading configuration: %s", err)
 		}
-		out = append(out, vlans.VLAN{
-			Name:        name,
-			Number:      number,
-			Description: description,
-		})
-		logrus.Debugf("Found VLAN - Name: %s, Number: %d, Description: %s", name, number, description)
 	}
-	if err := rows.Err(); err != nil {
-		logrus.Fatalf("Error reading VLAN rows: %s", err)
-	}
-	logrus.Infof("Found %d VLANs to migrate", len(out))
-	return
-}
 
-// fetchSubnets gets all of the IPv4 subnets from the legacy DB and returns a
-// []subnets.Subnet.
-//
-// The SQL query joins 2 tables - subnets and vlans, to ensure that VLAN ID
-// entries in the table are translated to their names, so that we can add the
-// subnets to the VLANs in the new PHPIPAM instance by name.
-func fetchSubnets(conn *sql.DB) (out []subnets.Subnet) {
-	logrus.Info("Fetching subnets from legacy DB")
-
-	rows := runSQL(conn, "select subnets.subnet, subnets.mask, subnets.description, vlans.number from subnets left join vlans on subnets.vlanId = vlans.vlanId")
-	defer rows.Close()
-	for rows.Next() {
-		var mask int
-		var vlanNumber sql.NullInt64
-		var addr, description string
-		if err := rows.Scan(&addr, &mask, &description, &vlanNumber); err != nil {
-			logrus.Fatalf("Error reading subnet rows: %s", err)
-		}
-
-		// Our IP address is in decimal format, and needs converting to IPv4. If
-		// this is an IPv6 address, we ignore the row.
-		strAddr, err := decimalIPAddrToString(addr)
-		if err != nil {
-			logrus.Debugf("Ignoring inconvertible decimal address %s - possibly not an IPv4 address (%s)", addr, err)
-			continue
+	switch {
+	case stagesFlag != "":
+		var err error
+		if stages, err = pipeline.ParseStages(stagesFlag); err != nil {
+			logrus.Fatalf("Invalid -stages: %s", err)
 		}
+	case len(cfg.Stages) > 0:
+		if err := pipeline.ValidateStages(cfg.Stages); err != nil {
+			logrus.Fatalf("Invalid stages in configuration file: %s", err)
+		}
+		stages = cfg.Stages
+	case verifyOnly:
+		stages = []string{pipeline.Fetch, pipeline.Validate, pipeline.Transform, pipeline.Resolve, pipeline.Verify}
+	}
+}
 
-		var vlanID int
-		if vlanNumber.Int64 != 0 {
-			vlanID = vlanIDForNumber(int(vlanNumber.Int64))
+// loadHookPlugins registers the hooks from each plugin in hookPlugins on
+// migrationHooks.
+func loadHookPlugins() {
+	for _, path := range strings.Split(hookPlugins, ",") {
+		if err := hooks.LoadPlugin(strings.TrimSpace(path), migrationHooks); err != nil {
+			logrus.Fatal(err)
 		}
+		logrus.Infof("Loaded hooks from %s", path)
+	}
+}
 
-		out = append(out, subnets.Subnet{
-			SubnetAddress: strAddr,
-			Mask:          mask,
-			Description:   description,
-			VLANID:        vlanID,
-			SectionID:     sectionID,
-		})
-		logrus.Debugf("Found subnet - Name: %s, Mask: %d, Description: %s, VLAN: %d", strAddr, mask, description, vlanNumber.Int64)
+// readVaultCredentials reads the legacy DB and PHPIPAM passwords from the
+// Vault secret at vaultSecretPath. Passwords that have already been supplied
+// via flags or the environment are left alone.
+func readVaultCredentials() {
+	logrus.Debugf("Reading credentials from Vault secret %s", vaultSecretPath)
+	secret, err := vault.NewClient(vaultAddr, "").ReadSecret(vaultSecretPath)
+	if err != nil {
+		logrus.Fatalf("Error reading Vault secret %s: %s", vaultSecretPath, err)
 	}
-	if err := rows.Err(); err != nil {
-		logrus.Fatalf("Error reading subnet rows: %s", err)
+	if v, ok := secret["db_password"]; ok && dbPassword == "" {
+		dbPassword = v
+	}
+	if v, ok := secret["phpipam_password"]; ok && ipamPassword == "" && os.Getenv("PHPIPAM_PASSWORD") == "" {
+		ipamPassword = v
 	}
-
-	logrus.Infof("Found %d subnets to migrate", len(out))
-	return
 }
 
-// fetchAddresses gets all of the IPv4 addresses from the legacy DB and returns
-// an []addresses.Address.
-//
-// The SQL query joins 2 tables - addresses and subnets, to ensure that we know
-// what subnet that the IP address belongs to, without knowing its specific ID
-// in the database.
-func fetchAddresses(conn *sql.DB) (out []addresses.Address) {
-	logrus.Info("Fetching addresses from legacy DB")
+// dbTLSConfigName is the name the custom legacy DB TLS config is registered
+// with in the MySQL driver. It can be referenced in a DSN supplied with -dsn
+// as tls=migrator.
+const dbTLSConfigName = "migrator"
 
-	rows := runSQL(conn, "select ipaddresses.ip_addr, ipaddresses.description, ipaddresses.dns_name, ipaddresses.note, subnets.subnet, subnets.mask from ipaddresses left join subnets on ipaddresses.subnetId=subnets.id")
-	defer rows.Close()
-	for rows.Next() {
-		var ipAddr, description, dnsName, note, subnetAddr string
-		var subnetMask int
+// setupDBTLS validates the legacy DB TLS options, and registers a custom TLS
+// config with the MySQL driver if a CA or client certificate was supplied, or
+// if the server certificate is verified through an SSH tunnel.
+func setupDBTLS() {
+	if dbTLS == "" && dbCA == "" && dbCert == "" && dbKey == "" {
+		return
+	}
+	if dbTLS == "" {
+		dbTLS = "true"
+	}
+	if dbTLS != "true" && dbTLS != "skip-verify" {
+		logrus.Fatalf("Invalid -db-tls value %q: must be true or skip-verify", dbTLS)
+	}
+	tunnelled := sshHost != "" && dbTLS != "skip-verify"
+	if dbCA == "" && dbCert == "" && dbKey == "" && !tunnelled {
+		return
+	}
 
-		if err := rows.Scan(&ipAddr, &description, &dnsName, &note, &subnetAddr, &subnetMask); err != nil {
-			logrus.Fatalf("Error reading address rows: %s", err)
-		}
+	cfg, err := helper.TLSConfig(dbCA, dbCert, dbKey, dbTLS == "skip-verify")
+	if err != nil {
+		logrus.Fatalf("Error configuring database TLS: %s", err)
+	}
+	// Through an SSH tunnel, the DB address is the local end of the tunnel,
+	// which the driver would otherwise verify the server certificate against.
+	if tunnelled {
+		cfg.ServerName = tunnelDBHost()
+	}
+	if err := mysql.RegisterTLSConfig(dbTLSConfigName, cfg); err != nil {
+		logrus.Fatalf("Error registering database TLS config: %s", err)
+	}
+	dbTLS = dbTLSConfigName
+}
 
-		// We have addresses that need converting to string format. Do this now.
-		ipString, err := decimalIPAddrToString(ipAddr)
-		if err != nil {
-			logrus.Debugf("Ignoring inconvertible decimal IP address %s - possibly not an IPv4 address (%s)", ipAddr, err)
-			continue
-		}
-		subnetString, err := decimalIPAddrToString(subnetAddr)
-		if err != nil {
-			logrus.Debugf("Ignoring inconvertible decimal subnet address %s - possibly not an IPv4 address (%s)", subnetAddr, err)
-			continue
-		}
+// setupRecording wraps the database driver and the HTTP transport used by the
+// PHPIPAM SDK so that all legacy DB rows and API responses are recorded to
+// recordFile. The bundle is saved on exit, including fatal exits.
+func setupRecording() {
+	logrus.Infof("Recording database rows and API responses to %s", recordFile)
+	recording = replay.NewBundle(ipamSession.Config.Endpoint, ipamSession.Config.AppID)
+	sql.Register("mysql-record", &replay.RecordingDriver{Driver: &mysql.MySQLDriver{}, Bundle: recording})
+	dbDriver = "mysql-record"
+	http.DefaultTransport = &replay.RecordingTransport{Transport: http.DefaultTransport, Bundle: recording}
+	logrus.RegisterExitHandler(saveRecording)
+}
 
-		out = append(out, addresses.Address{
-			SubnetID:    subnetIDForCIDR(fmt.Sprintf("%s/%d", subnetString, subnetMask)),
-			IPAddress:   ipString,
-			Description: description,
-			Hostname:    dnsName,
-			Note:        note,
-		})
-		logrus.Debugf("Found IP address - Address: %s, Description: %s, Hostname: %s, Note: %s, Subnet: %s/%d", ipString, description, dnsName, note, subnetString, subnetMask)
+// saveRecording writes the recorded bundle to recordFile.
+func saveRecording() {
+	if recording == nil {
+		return
 	}
-	if err := rows.Err(); err != nil {
-		logrus.Fatalf("Error reading address rows: %s", err)
+	if err := recording.Save(recordFile); err != nil {
+		logrus.Errorf("Error saving recording to %s: %s", recordFile, err)
+		return
 	}
-	logrus.Infof("Found %d addresses to migrate", len(out))
-	return
+	logrus.Infof("Recording saved to %s", recordFile)
 }
 
-// addVLANs adds the VLANs found into the new PHPIPAM instance.
-func addVLANs(lans []vlans.VLAN) {
-	logrus.Info("Adding VLANs.")
-
-	c := vlans.NewController(ipamSession)
-	for _, v := range lans {
-		if _, err := c.CreateVLAN(v); err != nil {
-			logrus.Fatalf("Error adding VLAN number %d: %s", v.Number, err)
-		}
-		logrus.Infof("VLAN number %d added successfully", v.Number)
+// setupReplay loads the bundle in replayFile and sets up the database driver
+// and HTTP transport to serve from it, so that the migration runs completely
+// offline.
+func setupReplay() {
+	logrus.Infof("Replaying migration from %s", replayFile)
+	var err error
+	if replaying, err = replay.LoadBundle(replayFile); err != nil {
+		logrus.Fatalf("Error loading replay bundle: %s", err)
 	}
+	// A replayed run fails identically every time, so retrying is pointless.
+	apiRetry.Retries = 0
+	sql.Register("replay", &replay.Driver{Bundle: replaying})
+	dbDriver = "replay"
+	http.DefaultTransport = &replay.Transport{Bundle: replaying}
+	ipamSession = session.NewSession(
+		phpipam.Config{
+			AppID:    replaying.AppID,
+			Endpoint: replaying.Endpoint,
+		},
+	)
 }
 
-// addSubnets adds the subnets found into the new PHPIPAM instance.
-//
-// As the subnets are being added, we also check to see if we can find a parent
-// subnet. In order to do this, the subnets are sorted first by way of
-// SubnetsSorter, after which the list is iterated on.
-func addSubnets(nets []subnets.Subnet) {
-	data := helper.SubnetsSorter(nets)
-	sort.Sort(data)
+// reportReplayDiffs logs the API requests of a replayed run that differed
+// from the recording, which shows the effect of any changes made to the
+// transformation logic since the recording. With -replay-strict, the run
+// fails if there are any.
+func reportReplayDiffs() {
+	if replaying == nil {
+		return
+	}
+	diffs := replaying.Diffs()
+	for _, v := range diffs {
+		logrus.Warnf("Replayed API request differs from the recording: %s", v)
+	}
+	switch {
+	case len(diffs) == 0:
+		logrus.Info("All replayed API requests matched the recording")
+	case replayStrict:
+		logrus.Fatalf("Replay failed: %d API requests differ from the recording", len(diffs))
+	default:
+		logrus.Infof("%d replayed API requests differ from the recording", len(diffs))
+	}
+}
 
-	logrus.Info("Adding subnets.")
+// vlanIDForNumber fetches the VLAN ID for a specific VLAN number. Lookups
+// are cached in vlanIDCache.
+func vlanIDForNumber(n int) (int, error) {
+	return vlanIDCache.Get(strconv.Itoa(n))
+}
 
-	c := subnets.NewController(ipamSession)
+// createMissingVLAN creates VLAN number n with c, which a subnet is assigned to
+// but which is not in the new PHPIPAM instance, and returns its ID. The VLAN is
+// created as it was fetched from the legacy DB, or with a placeholder name if
+// it was not. Sections that need the same VLAN at once create it only once.
+func createMissingVLAN(c ipamsink.VLANCreator, n int) (int, error) {
+	missingVLANsMu.Lock()
+	defer missingVLANsMu.Unlock()
+	if id, err := vlanIDForNumber(n); err == nil {
+		return id, nil
+	}
 
-	for _, v := range data {
-		if id := helper.ParentSubnetIDForCIDR(ipamSession, v.SubnetAddress, v.Mask); id != 0 {
-			v.MasterSubnetID = id
+	v := legacydb.VLAN{VLAN: vlans.VLAN{Number: n, Name: fmt.Sprintf("VLAN %d", n)}}
+	for _, f := range fetchedVLANs {
+		if f.Number == n {
+			v = f
+			break
 		}
-		if _, err := c.CreateSubnet(v); err != nil {
-			logrus.Fatalf("Error creating subnet %s/%d: %s", v.SubnetAddress, v.Mask, err)
-		}
-		logrus.Infof("Subnet address %s/%d added successfully", v.SubnetAddress, v.Mask)
 	}
-}
-
-// addAddresses adds the IP addresses found into the new PHPIPAM instance.
-func addAddresses(addrs []addresses.Address) {
-	logrus.Info("Adding IP addresses.")
-
-	c := addresses.NewController(ipamSession)
-	for _, v := range addrs {
-		if _, err := c.CreateAddress(v); err != nil {
-			logrus.Fatalf("Error adding IP address %s: %s", v.IPAddress, err)
+	if name := vlanL2Domain(n); name != "" {
+		id, ok := l2DomainIDs[name]
+		if !ok {
+			return 0, fmt.Errorf("L2 domain %s not found in new PHPIPAM database", name)
 		}
-		logrus.Infof("IP address %s added successfully", v.IPAddress)
+		v.DomainID = id
 	}
+	if err := c.CreateVLAN(v.VLAN, v.CustomFields); err != nil {
+		return 0, err
+	}
+	recordsTotal.Inc("vlans", "migrated")
+	stageLog.WithField("vlan", n).Warnf("VLAN number %d was missing from new PHPIPAM database, so it was created", n)
+	return vlanIDForNumber(n)
 }
 
-// connectDB sets up the database connection.
+// lookupVLANID searches the new PHPIPAM instance for the ID of the VLAN
+// number in key. This is the fetch function for vlanIDCache.
+func lookupVLANID(key string) (int, error) {
+	n, err := strconv.Atoi(key)
+	if err != nil {
+		return 0, err
+	}
+	id, err := sink.VLANID(n)
+	if err != nil {
+		return 0, err
+	}
+
+	logrus.Debugf("Found VLAN ID %d for VLAN number %d in new PHPIPAM database", id, n)
+	return id, nil
+}
+
+// preloadVLANIDs lists all of the VLANs in the new PHPIPAM instance once, and
+// adds their IDs to vlanIDCache, so that resolving subnets does not need to
+// look up each VLAN number individually.
+func preloadVLANIDs() {
+	logrus.Info("Preloading VLAN IDs from new PHPIPAM database")
+
+	ids, err := sink.VLANIDs()
+	if err != nil {
+		logrus.Fatalf("Error preloading VLAN IDs: %s", err)
+	}
+	for n, id := range ids {
+		vlanIDCache.Set(strconv.Itoa(n), id)
+	}
+	logrus.Infof("Preloaded %d VLAN IDs", len(ids))
+}
+
+// preloadVRFIDs lists all of the VRFs in the new PHPIPAM instance, and adds
+// their IDs to vrfIDs, so that subnets can be assigned to them.
+func preloadVRFIDs() {
+	logrus.Info("Preloading VRF IDs from new PHPIPAM database")
+
+	found, err := sink.VRFs()
+	if err != nil {
+		logrus.Fatalf("Error preloading VRF IDs: %s", err)
+	}
+	for _, v := range found {
+		vrfIDs[v.Name] = v.ID
+	}
+	logrus.Infof("Preloaded %d VRF IDs", len(found))
+}
+
+// subnetIDForCIDR fetches the ID of the subnet with a CIDR subnet address in
+// a section. Lookups are cached in subnetIDCache.
+func subnetIDForCIDR(sectionID int, cidr string) (int, error) {
+	return subnetIDCache.Get(fmt.Sprintf("%d/%s", sectionID, cidr))
+}
+
+// preloadSubnetIDs lists all of the subnets in the sections that the section's
+// subnets are migrated to with f once, and adds their IDs to subnetIDCache, so
+// that resolving addresses does not need to look up each subnet CIDR
+// individually.
+func (s *sectionRun) preloadSubnetIDs(f ipamsink.SubnetFinder) error {
+	s.log.Info("Preloading subnet IDs from new PHPIPAM database")
+
+	count := 0
+	for _, section := range s.targetSections(s.subnets) {
+		ids, err := f.SubnetIDs(section)
+		if err != nil {
+			return err
+		}
+		for cidr, id := range ids {
+			subnetIDCache.Set(fmt.Sprintf("%d/%s", section, cidr), id)
+		}
+		count += len(ids)
+	}
+	s.log.Infof("Preloaded %d subnet IDs", count)
+	return nil
+}
+
+// lookupSubnetID finds the ID of the subnet in key, which is in SECTION/CIDR
+// format, with f. This is the fetch function for subnetIDCache.
+func lookupSubnetID(f ipamsink.SubnetFinder, key string) (int, error) {
+	parts := strings.SplitN(key, "/", 2)
+	sectionID, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) != 2 {
+		return 0, fmt.Errorf("invalid subnet key %q", key)
+	}
+	cidr := parts[1]
+	id, err := f.SubnetID(sectionID, cidr)
+	if err != nil {
+		return 0, err
+	}
+
+	logrus.Debugf("Found subnet ID %d for CIDR %s in section %d in new PHPIPAM database", id, cidr, sectionID)
+	return id, nil
+}
+
+// fetchVLANs gets all the VLANs from the legacy DB.
+func fetchVLANs(conn *sql.DB) ([]legacydb.VLAN, error) {
+	stageLog.Info("Fetching VLANs from legacy DB")
+
+	r := &legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}
+	out, err := r.VLANs()
+	if err != nil {
+		return nil, err
+	}
+	if _, fields := legacyMapping.CustomFieldColumns("vlans"); len(fields) > 0 {
+		values, err := r.VLANCustomFields(fields)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range out {
+			out[i].CustomFields = values[v.Number]
+		}
+	}
+	if out, err = applyDuplicateVLANs(conn, out); err != nil {
+		return nil, err
+	}
+	if legacyIDField != "" {
+		ids, err := r.VLANIDs()
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range out {
+			// VLANs merged with -duplicate-vlans merge get all of their IDs.
+			id := joinInts(ids[v.Number])
+			if v.LegacyID != 0 {
+				id = strconv.Itoa(v.LegacyID)
+			}
+			setLegacyID(&out[i].CustomFields, id)
+		}
+	}
+	stageLog.Infof("Found %d VLANs to migrate", len(out))
+	return out, nil
+}
+
+// setLegacyID sets the custom field legacyIDField of *fields to the legacy ID
+// id, unless id is blank. The fields are copied first, as the custom fields
+// read from the legacy DB can be shared between objects.
+func setLegacyID(fields *map[string]string, id string) {
+	if id == "" {
+		return
+	}
+	out := map[string]string{legacyIDField: id}
+	for k, v := range *fields {
+		if k != legacyIDField {
+			out[k] = v
+		}
+	}
+	*fields = out
+}
+
+// joinInts returns ints as a comma-separated list.
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, v := range ints {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
+
+// fetchVRFs gets all of the VRFs from the legacy DB.
+func fetchVRFs(conn *sql.DB) ([]vrfs.VRF, error) {
+	stageLog.Info("Fetching VRFs from legacy DB")
+
+	out, err := (&legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}).VRFs()
+	if err != nil {
+		return nil, err
+	}
+	stageLog.Infof("Found %d VRFs to migrate", len(out))
+	return out, nil
+}
+
+// reader returns a reader for the section's subnets and addresses in the
+// legacy DB in conn.
+func (s *sectionRun) reader(conn *sql.DB) *legacydb.Reader {
+	return &legacydb.Reader{
+		DB:        conn,
+		SectionID: s.LegacyID,
+		Log:       s.log,
+		Queries:   legacyQueries,
+		Skipped:   recordSkipped(s.LegacyID),
+	}
+}
+
+// newWriter returns a writer that logs to log, and writes with the options
+// set on the command line.
+func newWriter(log *logrus.Entry) *ipamsink.Writer {
+	return &ipamsink.Writer{
+		Log:             log,
+		Progress:        progressDisplay,
+		MergeSubnets:    existingSubnets == "merge",
+		UpsertAddresses: addressesUpsert,
+		ChangeNotes:     changeNotes,
+		Workers:         addressWorkers,
+		Recorded:        func(kind, result string) { recordsTotal.Inc(kind, result) },
+	}
+}
+
+// writer returns a writer for the section's objects, which counts their
+// errors against the section's error budget.
+func (s *sectionRun) writer() *ipamsink.Writer {
+	w := newWriter(s.log)
+	w.Phase = s.String()
+	w.SectionID = s.ID
+	w.Failed = s.recordError
+	return w
+}
+
+// fetchSubnets gets all of the IPv4 subnets in the section from the legacy DB,
+// and assigns them to the section in the new PHPIPAM instance, or to the
+// section that a section route sends them to. The names of
+// their VRFs are fetched too if VRFs are being migrated.
+func (s *sectionRun) fetchSubnets(conn *sql.DB) error {
+	s.log.Info("Fetching subnets from legacy DB")
+
+	nets, skipped, err := s.reader(conn).Subnets()
+	if err != nil {
+		return err
+	}
+	var vrfNames, nameserverNames map[string]string
+	var vlanIDs map[string]int
+	if migrateVRFs {
+		if vrfNames, err = s.reader(conn).SubnetVRFs(); err != nil {
+			return err
+		}
+	}
+	if migrateNameservers {
+		if nameserverNames, err = s.reader(conn).SubnetNameservers(); err != nil {
+			return err
+		}
+	}
+	if separatesDuplicateVLANs() && len(duplicateVLANNumbers) > 0 {
+		if vlanIDs, err = s.reader(conn).SubnetVLANIDs(); err != nil {
+			return err
+		}
+	}
+	var legacyIDs map[string]string
+	if legacyIDField != "" {
+		if legacyIDs, err = s.reader(conn).SubnetIDs(); err != nil {
+			return err
+		}
+	}
+	if s.placesOrphans() {
+		nets = append(nets, s.orphansSubnetFor())
+	}
+	for i, v := range nets {
+		cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+		nets[i].SectionID = s.subnetSection(cidr)
+		if containsInt(duplicateVLANNumbers, v.VLANNumber) {
+			nets[i].VLANLegacyID = vlanIDs[cidr]
+		}
+		nets[i].VRFName = vrfNames[cidr]
+		nets[i].NameserverName = nameserverNames[cidr]
+		setLegacyID(&nets[i].CustomFields, legacyIDs[cidr])
+	}
+	s.subnets = nets
+	s.SkippedSubnets += skipped
+	recordsTotal.Add(float64(skipped), "subnets", "skipped")
+
+	s.log.Infof("Found %d subnets to migrate", len(nets))
+	return nil
+}
+
+// fetchSwitches collects the distinct switch names referenced by IPv4
+// addresses in the legacy DB, along with the number of addresses and the
+// subnets that reference each one.
+func fetchSwitches(conn *sql.DB) (helper.SwitchInventory, error) {
+	stageLog.Info("Fetching switch names from legacy DB")
+
+	out, err := (&legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}).Switches()
+	if err != nil {
+		return nil, err
+	}
+	stageLog.Infof("Found %d distinct switches to migrate as devices", len(out))
+	return out, nil
+}
+
+// fetchDeviceTypes gets the device types from the legacy DB, if it has a
+// device type table.
+func fetchDeviceTypes(conn *sql.DB) ([]devices.DeviceType, error) {
+	out, err := (&legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}).DeviceTypes()
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > 0 {
+		stageLog.Infof("Found %d device types to migrate", len(out))
+	}
+	return out, nil
+}
+
+// fetchAddresses gets all of the IPv4 addresses in the section from the legacy
+// DB, tagged as per their legacy states and with their owners, MAC
+// addresses, and exclude ping and PTR ignore flags, as well as their last seen
+// and edit times if timestamps are preserved. The switch ports of the addresses
+// are fetched too if devices are being migrated.
+func (s *sectionRun) fetchAddresses(conn *sql.DB) error {
+	s.log.Info("Fetching addresses from legacy DB")
+
+	addrs, skipped, err := s.reader(conn).Addresses()
+	if err != nil {
+		return err
+	}
+	if migrateDevices {
+		ports, err := s.reader(conn).AddressPorts()
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			addrs[i].Port = ports[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}]
+		}
+	}
+	owners, err := s.reader(conn).AddressOwners()
+	if err != nil {
+		return err
+	}
+	for i, v := range addrs {
+		addrs[i].Owner = owners[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}]
+	}
+	if readAddressStates {
+		states, err := s.reader(conn).AddressStates()
+		if err != nil {
+			return err
+		}
+		s.tagAddresses(addrs, states)
+	}
+	if readAddressMACs {
+		macs, err := s.reader(conn).AddressMACs()
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			addrs[i].MACAddress = macs[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}]
+		}
+	}
+	if _, fields := legacyMapping.CustomFieldColumns("ipaddresses"); len(fields) > 0 {
+		values, err := s.reader(conn).AddressCustomFields(fields)
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			addrs[i].CustomFields = values[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}]
+		}
+	}
+	if legacyIDField != "" {
+		ids, err := s.reader(conn).AddressIDs()
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			setLegacyID(&addrs[i].CustomFields, ids[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}])
+		}
+	}
+	if readExcludePing {
+		excluded, err := s.reader(conn).PingExcludedAddresses()
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			addrs[i].ExcludePing = phpipam.BoolIntString(excluded[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}])
+		}
+	}
+	if readPTRIgnore {
+		ignored, err := s.reader(conn).PTRIgnoredAddresses()
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			addrs[i].PTRIgnore = phpipam.BoolIntString(ignored[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}])
+		}
+	}
+	if preserveTimestamps && readLastSeen {
+		seen, err := s.reader(conn).AddressLastSeen()
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			addrs[i].LastSeen = seen[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}]
+		}
+	}
+	if preserveTimestamps && readEditDate {
+		edited, err := s.reader(conn).AddressEditDates()
+		if err != nil {
+			return err
+		}
+		for i, v := range addrs {
+			addrs[i].EditDate = edited[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}]
+		}
+	}
+	if !deltaSince.IsZero() || stateFile != "" {
+		ids, err := s.reader(conn).AddressIDs()
+		if err != nil {
+			return err
+		}
+		noteAddressIDs(ids)
+		if !deltaSince.IsZero() {
+			edited, err := s.reader(conn).AddressEditDates()
+			if err != nil {
+				return err
+			}
+			all := len(addrs)
+			addrs = deltaAddresses(addrs, edited, ids)
+			s.log.Infof("Skipping %d addresses not added or edited since %s", all-len(addrs), deltaSince.Format(legacyTimeLayout))
+		}
+	}
+	// Orphans cannot be told apart by ID, so are only placed by full runs.
+	if s.placesOrphans() && deltaSince.IsZero() {
+		addrs = append(addrs, s.placeOrphanAddresses()...)
+	}
+	s.addresses = addrs
+	s.SkippedAddresses += skipped
+	recordsTotal.Add(float64(skipped), "addresses", "skipped")
+
+	s.log.Infof("Found %d addresses to migrate", len(addrs))
+	return nil
+}
+
+// tagAddresses tags the addresses as per their legacy states and
+// addressStates. The addresses with each state that is not mapped are
+// counted, and a warning is logged for each such state.
+func (s *sectionRun) tagAddresses(addrs []legacydb.Address, states map[legacydb.AddressKey]string) {
+	unmapped := make(map[string]int)
+	for i, v := range addrs {
+		state, ok := states[legacydb.AddressKey{IPAddress: v.IPAddress, SubnetCIDR: v.SubnetCIDR}]
+		if !ok {
+			continue
+		}
+		if tag, ok := addressStates[state]; ok {
+			addrs[i].Tag = tag
+		} else {
+			unmapped[state]++
+		}
+	}
+	var names []string
+	for k := range unmapped {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, state := range names {
+		s.log.Warnf("%d addresses have legacy state %s, which is not mapped to a tag with -address-states, so are left untagged", unmapped[state], state)
+	}
+}
+
+// fetchRequests gets the IPv4 requests in the section that have not been
+// processed from the legacy DB.
+func (s *sectionRun) fetchRequests(conn *sql.DB) error {
+	s.log.Info("Fetching IP requests from legacy DB")
+
+	reqs, skipped, err := s.reader(conn).Requests()
+	if err != nil {
+		return err
+	}
+	s.requests = reqs
+	recordsTotal.Add(float64(skipped), "requests", "skipped")
+
+	s.log.Infof("Found %d IP requests to migrate", len(reqs))
+	return nil
+}
+
+// fetchChangelog gets the changelog entries about the section's IPv4 subnets
+// and addresses from the legacy DB.
+func (s *sectionRun) fetchChangelog(conn *sql.DB) error {
+	s.log.Info("Fetching changelog entries from legacy DB")
+
+	changes, skipped, err := s.reader(conn).Changelog()
+	if err != nil {
+		return err
+	}
+	if !deltaSince.IsZero() {
+		changes = deltaChanges(changes)
+	}
+	s.changes = changes
+	recordsTotal.Add(float64(skipped), "changelog", "skipped")
+
+	s.log.Infof("Found %d changelog entries to migrate", len(changes))
+	return nil
+}
+
+// customFieldTables are the tables whose legacy custom columns can be mapped
+// to custom fields, in the order their objects are migrated.
+var customFieldTables = []string{"vlans", "ipaddresses"}
+
+// ownFieldTables are the tables that the custom fields the migration writes
+// itself, stampField and legacyIDField, are created in, in the order their
+// objects are migrated.
+var ownFieldTables = []string{"vlans", "subnets", "ipaddresses"}
+
+// mapsCustomFields returns true if any legacy custom columns are mapped to
+// custom fields, or if stamp or the legacy IDs are written to custom fields.
+func mapsCustomFields() bool {
+	if stampField != "" || legacyIDField != "" {
+		return true
+	}
+	for _, table := range customFieldTables {
+		if _, fields := legacyMapping.CustomFieldColumns(table); len(fields) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// customField is a custom field of a table.
+type customField struct {
+	table, name string
+}
+
+// missingCustomFields returns the custom fields that the legacy custom columns
+// are mapped to, stampField, and legacyIDField, that do not exist yet in the
+// new PHPIPAM instance, as listed with l, in the order of ownFieldTables.
+func missingCustomFields(l ipamsink.CustomFieldLister) ([]customField, error) {
+	var out []customField
+	for _, table := range ownFieldTables {
+		_, fields := legacyMapping.CustomFieldColumns(table)
+		for _, v := range []string{stampField, legacyIDField} {
+			if v != "" {
+				fields = append(fields, v)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		existing, err := l.CustomFields(table)
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[string]bool)
+		for _, v := range existing {
+			found[strings.ToLower(v)] = true
+		}
+		for _, v := range fields {
+			if found[strings.ToLower(v)] {
+				stageLog.WithField("custom_field", v).Debugf("Custom field %s of %s already exists in new PHPIPAM instance", v, table)
+				continue
+			}
+			found[strings.ToLower(v)] = true
+			out = append(out, customField{table: table, name: v})
+		}
+	}
+	return out, nil
+}
+
+// addCustomFields creates the custom fields that the legacy custom columns
+// are mapped to, stampField, and legacyIDField, with c, unless they already
+// exist in the new PHPIPAM instance, so that their values can be written.
+func addCustomFields(c ipamsink.CustomFieldCreator) error {
+	stageLog.Info("Adding custom fields.")
+
+	missing, err := missingCustomFields(c)
+	if err != nil {
+		return err
+	}
+	for _, v := range missing {
+		if err := c.CreateCustomField(v.table, v.name); err != nil {
+			return err
+		}
+		stageLog.WithField("custom_field", v.name).Infof("Custom field %s of %s added successfully", v.name, v.table)
+	}
+	return nil
+}
+
+// checkCustomFields returns an error listing the custom fields that the
+// legacy custom columns are mapped to, stampField, and legacyIDField, that do
+// not exist in the new PHPIPAM instance, as listed with l, for sinks that
+// cannot create them.
+func checkCustomFields(l ipamsink.CustomFieldLister) error {
+	missing, err := missingCustomFields(l)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	var names []string
+	for _, v := range missing {
+		names = append(names, v.table+"."+v.name)
+	}
+	return fmt.Errorf("custom fields %s do not exist in the new PHPIPAM instance, and cannot be created through the API - add them as varchar(255) fields in Administration > Custom fields, or write with -target-dsn or -output sql, which create them", strings.Join(names, ", "))
+}
+
+// addVLANs adds the VLANs found into the new PHPIPAM instance with c. If c
+// can list the existing VLANs, the VLANs whose number is already used in
+// their L2 domain are skipped or merged into the existing VLAN, as set by
+// existingVLANs, instead of being created again.
+func addVLANs(c ipamsink.VLANCreator, lans []legacydb.VLAN) error {
+	existing := make(map[[2]int]vlans.VLAN)
+	u, ok := c.(ipamsink.VLANUpdater)
+	if ok {
+		found, err := u.VLANs()
+		if err != nil {
+			return err
+		}
+		for _, v := range found {
+			if _, ok := existing[vlanKey(v)]; !ok {
+				existing[vlanKey(v)] = v
+			}
+		}
+	} else {
+		stageLog.Debugf("Existing VLANs cannot be read from %T, so all VLANs are created", c)
+	}
+
+	stageLog.Info("Adding VLANs.")
+
+	tracker := progressDisplay.Track("vlans", len(lans))
+	defer tracker.Finish()
+
+	for _, v := range lans {
+		tracker.Add(1)
+		tracker.SetCurrent(fmt.Sprintf("VLAN %d (%s)", v.Number, v.Name))
+		log := stageLog.WithField("vlan", v.Number)
+		if e, ok := existing[vlanKey(v.VLAN)]; ok {
+			if err := mergeVLAN(u, e, v.VLAN); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.CreateVLAN(v.VLAN, v.CustomFields); err != nil {
+			return err
+		}
+		recordsTotal.Inc("vlans", "migrated")
+		log.Infof("VLAN number %d added successfully", v.Number)
+	}
+	return nil
+}
+
+// vlanKey returns the L2 domain ID and number of v, which identify it in the
+// new PHPIPAM instance.
+func vlanKey(v vlans.VLAN) [2]int {
+	if v.DomainID == 0 {
+		return [2]int{defaultL2DomainID, v.Number}
+	}
+	return [2]int{v.DomainID, v.Number}
+}
+
+// mergeVLAN handles the legacy VLAN v, whose number is already used by the
+// VLAN e in its L2 domain in the new PHPIPAM instance. It is skipped, unless
+// existingVLANs is merge, in which case the blank name and description of e
+// are filled in from v with c.
+func mergeVLAN(c ipamsink.VLANUpdater, e, v vlans.VLAN) error {
+	log := stageLog.WithField("vlan", v.Number)
+	merged := e
+	if merged.Name == "" {
+		merged.Name = v.Name
+	}
+	if merged.Description == "" {
+		merged.Description = v.Description
+	}
+	if existingVLANs != "merge" || merged == e {
+		recordsTotal.Inc("vlans", "skipped")
+		log.Infof("VLAN number %d already exists in new PHPIPAM database, skipping", v.Number)
+		return nil
+	}
+	if err := c.UpdateVLAN(merged); err != nil {
+		return err
+	}
+	recordsTotal.Inc("vlans", "merged")
+	log.Infof("VLAN number %d merged into existing VLAN", v.Number)
+	return nil
+}
+
+// addVRFs adds the VRFs found into the new PHPIPAM instance with c, skipping
+// the ones that already exist.
+func addVRFs(c ipamsink.VRFCreator, in []vrfs.VRF) error {
+	return newWriter(stageLog).AddVRFs(c, in)
+}
+
+// addSubnets adds the subnets found into the new PHPIPAM instance with c,
+// skipping or merging the ones that are already in their section as set by
+// existingSubnets. The subnets need to be sorted first, which is done in the
+// transform stage, so that parents are created before their children.
+//
+// Subnets that fail to be added are counted against the section's error
+// budget.
+func (s *sectionRun) addSubnets(c ipamsink.SubnetCreator, nets []legacydb.Subnet) error {
+	added, err := s.writer().AddSubnets(c, nets)
+	s.SubnetsAdded += added
+	return err
+}
+
+// addDevices creates a device in the new PHPIPAM instance for each switch in
+// the inventory, skipping the ones that already exist, and then records the
+// IDs of their devices in switchDeviceIDs so that addresses can be linked to
+// them.
+func addDevices(inv helper.SwitchInventory, types []devices.DeviceType) error {
+	ids, err := newWriter(stageLog).AddDevices(sink, inv, types, migratedSections())
+	if err != nil {
+		return err
+	}
+	for k, v := range ids {
+		switchDeviceIDs[k] = v
+	}
+	return nil
+}
+
+// addAddresses adds the IP addresses found into the new PHPIPAM instance with
+// c, with addressWorkers concurrent workers. Addresses that fail to be added
+// are counted against the section's error budget.
+func (s *sectionRun) addAddresses(c ipamsink.AddressCreator, legacy []legacydb.Address) error {
+	section := strconv.Itoa(s.ID)
+	queueDepth.Set(float64(len(legacy)), section)
+	defer queueDepth.Set(0, section)
+
+	w := s.writer()
+	w.Recorded = func(kind, result string) {
+		recordsTotal.Inc(kind, result)
+		queueDepth.Add(-1, section)
+	}
+	added, err := w.AddAddresses(c, legacy)
+	s.mu.Lock()
+	s.AddressesAdded += added
+	s.mu.Unlock()
+	return err
+}
+
+// addRequests adds the section's IP requests into the new PHPIPAM instance
+// with c. Requests that fail to be added are counted against the section's
+// error budget.
+func (s *sectionRun) addRequests(c ipamsink.RequestCreator, reqs []legacydb.Request) error {
+	added, err := s.writer().AddRequests(c, reqs)
+	s.RequestsAdded += added
+	return err
+}
+
+// addChangelog adds the changelog entries about the section's subnets and
+// addresses into the new PHPIPAM instance with c. Entries that fail to be
+// added are counted against the section's error budget.
+func (s *sectionRun) addChangelog(c ipamsink.ChangelogCreator, changes []legacydb.Change) error {
+	return s.writer().AddChangelog(c, changes)
+}
+
+// verifyAddresses compares the legacy addresses against the addresses in the
+// new PHPIPAM instance, logging any differences. An error is returned if any
+// differences are found.
+func (s *sectionRun) verifyAddresses(addrs []addresses.Address) error {
+	s.log.Info("Verifying IP addresses.")
+
+	mismatches, err := sink.VerifyAddresses(addrs)
+	if err != nil {
+		return err
+	}
+	for _, v := range mismatches {
+		s.log.Warnf("Verification mismatch: %s", v)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("verification failed: %d mismatches found across %d addresses", len(mismatches), len(addrs))
+	}
+	s.log.Infof("Verification succeeded: %d addresses match", len(addrs))
+	return nil
+}
+
+// openTunnel opens an SSH tunnel to the legacy DB through sshHost, and points
+// the DB host and port at the local end of it. The DB host defaults to
+// 127.0.0.1 - that is, the SSH server itself.
+func openTunnel() {
+	remoteAddr := net.JoinHostPort(tunnelDBHost(), strconv.Itoa(dbPort))
+	logrus.Infof("Opening SSH tunnel to %s via %s", remoteAddr, sshHost)
+
+	var err error
+	sshTunnel, err = tunnel.Open(tunnel.Config{
+		Host:       sshHost,
+		User:       sshUser,
+		KeyFile:    sshKey,
+		RemoteAddr: remoteAddr,
+	})
+	if err != nil {
+		logrus.Fatalf("Error opening SSH tunnel: %s", err)
+	}
+	logrus.RegisterExitHandler(closeTunnel)
+
+	host, port, _ := net.SplitHostPort(sshTunnel.LocalAddr)
+	dbHost = host
+	dbPort, _ = strconv.Atoi(port)
+}
+
+// tunnelDBHost returns the host that the SSH tunnel connects to the legacy DB
+// on, from the SSH server.
+func tunnelDBHost() string {
+	if dbHost == "" {
+		return "127.0.0.1"
+	}
+	return dbHost
+}
+
+// closeTunnel closes the SSH tunnel, if open.
+func closeTunnel() {
+	if sshTunnel != nil {
+		sshTunnel.Close()
+	}
+}
+
+// setupSource reads the mysqldump in sourceDump, or the CSV files in
+// sourceCSV, and sets up the database driver to query them in place of the
+// legacy DB.
+func setupSource() {
+	switch {
+	case sourceDump != "" && sourceCSV != "":
+		logrus.Fatal("Only one of -source-dump and -source-csv can be supplied")
+	case dbHost != "" || dbSocket != "" || dbDSN != "" || sshHost != "":
+		logrus.Fatal("-source-dump and -source-csv cannot be used with -dbhost, -db-socket, -dsn, or -ssh-host")
+	case recordFile != "":
+		logrus.Fatal("-source-dump and -source-csv cannot be used with -record")
+	case freezeCheck:
+		logrus.Fatal("-source-dump and -source-csv cannot be used with -freeze-check, as the source cannot change")
+	case sourceCSV != "" && auditLegacy:
+		logrus.Fatal("-source-csv cannot be used with -audit, as CSV files have no sections to audit")
+	case sourceCSV != "" && legacyMapping != nil:
+		logrus.Fatal("-source-csv cannot be used with -schema-mapping or -db-table-prefix, as CSV files are read into the standard schema")
+	}
+	var d *dump.Dump
+	var err error
+	source := sourceDump
+	if sourceDump != "" {
+		logrus.Infof("Reading legacy DB from dump %s", sourceDump)
+		d, err = dump.Load(sourceDump)
+	} else {
+		source = sourceCSV
+		logrus.Infof("Reading VLANs, subnets, and addresses from CSV files in %s", sourceCSV)
+		d, err = csvsource.Load(sourceCSV)
+	}
+	if err != nil {
+		logrus.Fatalf("Error loading %s: %s", source, err)
+	}
+	for _, t := range legacyTables() {
+		if d.Tables[t] == nil {
+			logrus.Fatalf("Legacy DB dump %s has no %s table", source, t)
+		}
+		logrus.Debugf("Read %d rows from the %s table in %s", len(d.Tables[t].Rows), t, source)
+	}
+	sql.Register("dump", &dump.Driver{Dump: d})
+	dbDriver = "dump"
+}
+
+// legacyTables returns the names of the legacy DB tables that are read, as
+// mapped by legacyMapping.
+func legacyTables() (out []string) {
+	for _, t := range state.LegacyTables {
+		out = append(out, legacyMapping.Table(t))
+	}
+	return
+}
+
+// legacyDSN returns the DSN for the legacy DB. A DSN supplied with -dsn is
+// returned as is. Otherwise, the DSN is built from the individual database
+// options, using TCP if a host is supplied, the UNIX socket if one is
+// supplied, and the default connection otherwise. With -source-charset latin1,
+// the connection uses latin1 so that the legacy text is read as stored, which
+// overrides any charset in -dsn.
+func legacyDSN() string {
+	if dbDSN != "" {
+		if sourceCharset != "latin1" {
+			return dbDSN
+		}
+		cfg, err := mysql.ParseDSN(dbDSN)
+		if err != nil {
+			logrus.Fatalf("Invalid -dsn: %s", err)
+		}
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		cfg.Params["charset"] = "latin1"
+		return cfg.FormatDSN()
+	}
+	cfg := mysql.Config{
+		User:      dbUser,
+		Passwd:    dbPassword,
+		DBName:    dbName,
+		TLSConfig: dbTLS,
+	}
+	switch {
+	case dbHost != "":
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(dbHost, strconv.Itoa(dbPort))
+	case dbSocket != "":
+		cfg.Net = "unix"
+		cfg.Addr = dbSocket
+	}
+	if sourceCharset == "latin1" {
+		cfg.Params = map[string]string{"charset": "latin1"}
+	}
+	return cfg.FormatDSN()
+}
+
+// redactDSN hides the password in a DSN so that it can be logged.
+func redactDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "[unparseable DSN]"
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = "[hidden]"
+	}
+	return cfg.FormatDSN()
+}
+
+// connectDB sets up the database connection.
 func connectDB() *sql.DB {
-	logrus.Debugf("Connecting to DB: %s:[hidden]@%s/%s", dbUser, dbHost, dbName)
-	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@%s/%s", dbUser, dbPassword, dbHost, dbName))
+	if sshHost != "" && replayFile == "" {
+		openTunnel()
+	}
+	dsn := legacyDSN()
+	logrus.Debugf("Connecting to DB: %s", redactDSN(dsn))
+	db, err := sql.Open(dbDriver, dsn)
 	if err != nil {
-		logrus.Fatalf("Error configuring DB handle for %s:[hidden]@%s/%s: %s", dbUser, dbHost, dbName, err)
+		logrus.Fatalf("Error configuring DB handle for %s: %s", redactDSN(dsn), err)
 	}
 	if err := db.Ping(); err != nil {
-		logrus.Fatalf("Error connecting to DB %s:[hidden]@%s/%s: %s", dbUser, dbHost, dbName, err)
+		logrus.Fatalf("Error connecting to DB %s: %s", redactDSN(dsn), err)
 	}
 	return db
 }
 
+// connectTarget connects to the database of the new PHPIPAM instance in
+// targetDSN, and returns a sink that writes into it.
+func connectTarget() *dbsink.Sink {
+	logrus.Infof("Writing straight into the PHPIPAM database %s instead of through the API", redactDSN(targetDSN))
+	db, err := sql.Open("mysql", targetDSN)
+	if err != nil {
+		logrus.Fatalf("Error configuring DB handle for %s: %s", redactDSN(targetDSN), err)
+	}
+	if err := db.Ping(); err != nil {
+		logrus.Fatalf("Error connecting to DB %s: %s", redactDSN(targetDSN), err)
+	}
+	return dbsink.New(db)
+}
+
+// connectNetBox checks the NetBox instance in netboxURL, and returns a sink
+// that writes into it.
+func connectNetBox() *netboxsink.Sink {
+	logrus.Infof("Migrating to the NetBox instance at %s instead of PHPIPAM", netboxURL)
+	s := netboxsink.New(netboxURL, netboxToken, apiRetry)
+	s.AnyVRF = migrateVRFs
+	if err := s.Check(); err != nil {
+		logrus.Fatalf("Error connecting to NetBox at %s: %s", netboxURL, err)
+	}
+	return s
+}
+
+// connectNautobot checks the Nautobot instance in nautobotURL, including the
+// status and namespace to use, and returns a sink that writes into it.
+func connectNautobot() *nautobotsink.Sink {
+	logrus.Infof("Migrating to the Nautobot instance at %s (namespace %s) instead of PHPIPAM", nautobotURL, nautobotNamespace)
+	s := nautobotsink.New(nautobotURL, nautobotToken, nautobotStatus, nautobotNamespace, apiRetry)
+	if err := s.Check(); err != nil {
+		logrus.Fatalf("Error connecting to Nautobot at %s: %s", nautobotURL, err)
+	}
+	return s
+}
+
+// createSQLScript creates outputFile, and returns a sink that writes a SQL
+// script to it. If the migration exits with an error, the script is finished
+// with a rollback.
+func createSQLScript() *dbsink.Script {
+	logrus.Infof("Writing a SQL script to %s instead of writing through the API", outputFile)
+	f, err := os.Create(outputFile)
+	if err != nil {
+		logrus.Fatalf("Error creating SQL script: %s", err)
+	}
+	sqlScript = dbsink.NewScript(f)
+	logrus.RegisterExitHandler(func() { closeSQLScript(false) })
+	return sqlScript
+}
+
+// closeSQLScript finishes the SQL script, if one is being written, committing
+// the migration if commit is true.
+func closeSQLScript(commit bool) {
+	if sqlScript == nil {
+		return
+	}
+	script := sqlScript
+	sqlScript = nil
+	if err := script.Close(commit); err != nil {
+		logrus.Errorf("Error writing SQL script to %s: %s", outputFile, err)
+		return
+	}
+	logrus.Infof("SQL script written to %s", outputFile)
+}
+
+// writeExport writes the objects collected by exportSink to outputFile, or to
+// CSV files in the outputFile directory.
+func writeExport() {
+	if output == export.CSV {
+		if err := exportSink.Export().WriteCSV(outputFile); err != nil {
+			logrus.Fatalf("Error writing export to %s: %s", outputFile, err)
+		}
+		logrus.Infof("Export written to %s, %s, and %s in %s", export.VLANsFile, export.SubnetsFile, export.AddressesFile, outputFile)
+		return
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		logrus.Fatalf("Error creating export: %s", err)
+	}
+	if err := exportSink.Write(f, output); err != nil {
+		f.Close()
+		logrus.Fatalf("Error writing export to %s: %s", outputFile, err)
+	}
+	if err := f.Close(); err != nil {
+		logrus.Fatalf("Error writing export to %s: %s", outputFile, err)
+	}
+	logrus.Infof("Export written to %s", outputFile)
+}
+
+// setupProgress creates the progress display, which tracks the progress of
+// each phase for the display on stderr and the web dashboard. It is created
+// before the web dashboard is served, so that it is never replaced while the
+// dashboard reads it.
+func setupProgress() {
+	progressDisplay = &progress.Display{
+		Out:      os.Stderr,
+		Interval: 30 * time.Second,
+		Terminal: terminal.IsTerminal(int(os.Stderr.Fd())),
+	}
+	if progressDisplay.Terminal {
+		progressDisplay.Interval = time.Second
+	}
+}
+
+// startProgress starts the progress display on stderr. On a terminal, the
+// display is redrawn every second, otherwise a line is logged for each phase
+// every 30 seconds. With -tui, the display takes over the terminal.
+func startProgress() {
+	if tui {
+		setupTUI()
+	}
+	progressDisplay.Start()
+}
+
+// hasStage returns true if the named stage is configured to run.
+func hasStage(name string) bool {
+	for _, v := range stages {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// preflights returns whether or not the PHPIPAM API is checked before
+// migrating. Replayed runs are not checked, so that bundles recorded without
+// the checks can be replayed.
+func preflights() bool {
+	return !skipPreflight && replayFile == "" && output == "api" && target == "phpipam" && targetDSN == ""
+}
+
+// preflightAPI checks that the controllers that every migration depends on
+// respond, and that the API app has write permissions if the write stage is
+// run, so that problems are found before migrating rather than part way
+// through. The run fails if any check does.
+func preflightAPI() {
+	caps := probe.Probe(ipamSession, probe.RequiredFeatures...)
+	var problems []string
+	for _, f := range probe.RequiredFeatures {
+		if !caps.Available(f) {
+			problems = append(problems, fmt.Sprintf("the %s controller does not respond: %s", f.Name, caps.Reason(f)))
+		}
+	}
+	access := "read-only"
+	if hasStage(pipeline.Write) {
+		access = "read/write"
+		if err := probe.Writable(ipamSession); err != nil {
+			problems = append(problems, fmt.Sprintf("the API app %s does not have write permissions: %s", ipamSession.Config.AppID, err))
+		}
+	}
+	for _, v := range problems {
+		logrus.Errorf("PHPIPAM API preflight check failed: %s", v)
+	}
+	if len(problems) > 0 {
+		logrus.Fatal("PHPIPAM API preflight checks failed - fix the API app's settings and permissions in the new PHPIPAM instance, or supply -skip-preflight")
+	}
+	logrus.Infof("PHPIPAM API preflight checks passed (%s access, %s)", access, caps.Summary())
+}
+
+// preflightCustomFields checks that the custom fields that values are written
+// to exist in the new PHPIPAM instance before anything is written, if the
+// sink can list them but not create them. The run fails if any do not.
+func preflightCustomFields() {
+	if !hasStage(pipeline.Write) || !mapsCustomFields() {
+		return
+	}
+	if _, ok := sink.(ipamsink.CustomFieldCreator); ok {
+		return
+	}
+	l, ok := sink.(ipamsink.CustomFieldLister)
+	if !ok {
+		return
+	}
+	if err := checkCustomFields(l); err != nil {
+		logrus.Fatalf("Error checking custom fields: %s", err)
+	}
+}
+
+// preflightSections checks that the sections being migrated into exist in
+// the new PHPIPAM instance, as listed with c. The run fails if any do not.
+func preflightSections(c ipamsink.SectionCreator) {
+	missing, err := missingSections(c)
+	if err != nil {
+		logrus.Fatalf("Error checking the sections being migrated into: %s", err)
+	}
+	if len(missing) > 0 {
+		logrus.Fatalf("PHPIPAM API preflight check failed: sections %s do not exist in the new PHPIPAM instance", joinInts(missing))
+	}
+}
+
+// checkSectionsEmpty fails the run if any section being migrated into already
+// has subnets in the new PHPIPAM instance, or only warns about them with
+// -force.
+func checkSectionsEmpty() {
+	populated, err := populatedSections(sink)
+	if err != nil {
+		logrus.Fatalf("Error checking that the sections are empty: %s", err)
+	}
+	if len(populated) == 0 {
+		logrus.Info("The sections being migrated into have no subnets")
+		return
+	}
+	for _, v := range populated {
+		logrus.Warnf("Section not empty: %s", v)
+	}
+	if !force {
+		logrus.Fatal("Refusing to migrate into populated sections with -require-empty-section - check that they have not already been migrated into, or supply -force")
+	}
+	logrus.Warn("Migrating into populated sections, as -force was supplied")
+}
+
+// backupTargetInstance backs up the existing content of the new PHPIPAM
+// instance, read with r, to backupTarget.
+func backupTargetInstance(r ipamsink.TargetReader) {
+	logrus.Infof("Backing up the new PHPIPAM instance to %s", backupTarget)
+	b, err := backup.Take(r)
+	if err != nil {
+		logrus.Fatalf("Error backing up the new PHPIPAM instance: %s", err)
+	}
+	if err := b.Save(backupTarget); err != nil {
+		logrus.Fatalf("Error backing up the new PHPIPAM instance: %s", err)
+	}
+	logrus.Infof("Backed up %s from the new PHPIPAM instance to %s", b.Summary(), backupTarget)
+}
+
+// probeCapabilities probes the new PHPIPAM instance for optional features,
+// and disables any enabled features that depend on ones that are missing.
+func probeCapabilities() {
+	logrus.Debug("Probing PHPIPAM API for optional features")
+	capabilities = probe.Probe(ipamSession, probe.AllFeatures...)
+	logrus.Infof("PHPIPAM API capabilities: %s", capabilities.Summary())
+	gateCapabilities()
+}
+
+// gateCapabilities disables any enabled features that depend on ones missing
+// from capabilities, with a warning, along with the options that depend on
+// them.
+func gateCapabilities() {
+	opts := probe.Options{
+		Devices:      migrateDevices,
+		VRFs:         migrateVRFs,
+		L2Domains:    migratesL2Domains(),
+		Nameservers:  migrateNameservers,
+		CustomFields: mapsCustomFields(),
+	}
+	for _, v := range capabilities.Gate(&opts) {
+		logrus.Warn(v)
+	}
+	migrateDevices, migrateVRFs, migrateNameservers = opts.Devices, opts.VRFs, opts.Nameservers
+	if migratesL2Domains() && !opts.L2Domains {
+		l2DomainPerSection, l2DomainsFile, l2DomainMapping = false, "", nil
+		if duplicateVLANs == "domains" {
+			logrus.Warn("Duplicate VLAN numbers are merged instead of created in separate L2 domains, as L2 domain migration is disabled")
+			duplicateVLANs = "merge"
+		}
+	}
+	if mapsCustomFields() && !opts.CustomFields {
+		if legacyMapping != nil {
+			legacyMapping.CustomFields = nil
+			legacyQueries = legacyMapping.BuildQueries()
+		}
+		legacyIDField = ""
+		if stampField != "" {
+			stampField = ""
+			if len([]rune(stamp)) >= transform.MaxAddressDescription {
+				logrus.Warn("-stamp disabled too, as it is too long to be appended to descriptions instead of written to -stamp-field")
+				stamp = ""
+			} else {
+				logrus.Warn("-stamp is appended to descriptions instead of written to -stamp-field")
+			}
+		}
+	}
+}
+
+// detectLegacySchema detects the schema version of the legacy DB, and adapts
+// the queries run against it to schemas newer than 0.8. Detection failures
+// only warn, and the queries built from the mapping are used as they are. An
+// error is returned if the legacy DB cannot be migrated with the options set.
+func detectLegacySchema(db *sql.DB) error {
+	schema, err := legacydb.DetectSchema(db, legacyMapping)
+	if err != nil {
+		logrus.Warnf("Could not detect the legacy DB schema, assuming PHPIPAM 0.8: %s", err)
+		return nil
+	}
+	version := schema.Version
+	if version == "" {
+		version = "unknown"
+	}
+	mapping, changes := schema.Adapt(legacyMapping)
+	logrus.Infof("Legacy DB schema version: %s", version)
+	for _, v := range changes {
+		logrus.Infof("Adapting to the legacy DB schema: %s", v)
+	}
+	legacyMapping = mapping
+	legacyQueries = legacyMapping.BuildQueries()
+
+	// Address columns missing from some dumps are skipped, unless their
+	// queries are overridden. Columns that are not migrated are not checked.
+	for _, v := range []struct {
+		column, query, without string
+		read                   *bool
+		migrated               bool
+	}{
+		{"state", legacyMapping.Queries.AddressStates, "tags", &readAddressStates, true},
+		{"mac", legacyMapping.Queries.AddressMACs, "MAC addresses", &readAddressMACs, true},
+		{"excludePing", legacyMapping.Queries.AddressExcludePing, "exclude ping flags", &readExcludePing, true},
+		{"PTRignore", legacyMapping.Queries.AddressPTRIgnore, "PTR ignore flags", &readPTRIgnore, true},
+		{"lastSeen", legacyMapping.Queries.AddressLastSeen, "last seen times", &readLastSeen, preserveTimestamps},
+		{"editDate", legacyMapping.Queries.AddressEditDates, "edit dates", &readEditDate, preserveTimestamps || !deltaSince.IsZero()},
+	} {
+		if v.migrated && v.query == "" && !schema.HasColumn(legacyMapping, "ipaddresses", v.column) {
+			*v.read = false
+			logrus.Infof("Legacy table %s has no %s column, so addresses are migrated without %s", legacyMapping.Table("ipaddresses"), v.column, v.without)
+		}
+	}
+
+	if !deltaSince.IsZero() && !readEditDate {
+		return fmt.Errorf("-since requires the %s.editDate column, which the legacy DB does not have", legacyMapping.Table("ipaddresses"))
+	}
+
+	// Nameserver sets were added in PHPIPAM 1.0, so older dumps have neither
+	// the table nor the subnets column referencing it.
+	if migrateNameservers && (legacyMapping.Queries.Nameservers == "" && !schema.HasColumn(legacyMapping, "nameservers", "name") ||
+		legacyMapping.Queries.SubnetNameservers == "" && !schema.HasColumn(legacyMapping, "subnets", "nameserverId")) {
+		logrus.Warnf("Nameserver set migration disabled: the legacy DB has no %s table or %s.nameserverId column", legacyMapping.Table("nameservers"), legacyMapping.Table("subnets"))
+		migrateNameservers = false
+	}
+
+	// Subsections are missing from older schemas.
+	if sectionHierarchy && legacyMapping.Queries.SectionMasters == "" && !schema.HasColumn(legacyMapping, "sections", "masterSection") {
+		logrus.Warnf("Section hierarchy disabled: the legacy DB has no %s.masterSection column", legacyMapping.Table("sections"))
+		sectionHierarchy = false
+	}
+
+	// Custom columns are only read once they are mapped to custom fields, as
+	// the custom fields they are mapped to are created in the new PHPIPAM
+	// instance.
+	for _, table := range customFieldTables {
+		found := make(map[string]bool)
+		for _, v := range schema.CustomColumns(legacyMapping, table) {
+			found[v] = true
+			if legacyMapping.CustomFields[table][v] == "" {
+				logrus.Infof("Legacy column %s.%s holds a custom field, which is not migrated unless it is mapped under custom_fields in -schema-mapping", table, v)
+			}
+		}
+		columns, _ := legacyMapping.CustomFieldColumns(table)
+		for _, v := range columns {
+			if !found[v] {
+				return fmt.Errorf("custom column %s.%s is mapped to a custom field, but was not found in the legacy DB", table, v)
+			}
+		}
+	}
+	return nil
+}
+
 func main() {
-	logrus.Info("Migration starting.")
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
+	setup()
+	if metricsAddr != "" {
+		startMetricsServer()
+	}
+	if pprofAddr != "" {
+		startPprofServer()
+	}
+	if showProgress || tui || webUIAddr != "" {
+		setupProgress()
+	}
+	if webUIAddr != "" {
+		startWebUI()
+		defer finishWebUI(notify.StatusSucceeded, "")
+	}
+	if freezeCheck {
+		runFreezeCheck(connectDB())
+		saveRecording()
+		closeTunnel()
+		sendReport(notify.StatusSucceeded, "")
+		return
+	}
+	if auditLegacy {
+		db := connectDB()
+		if err := detectLegacySchema(db); err != nil {
+			logrus.Fatal(err)
+		}
+		runAudit(db)
+		saveRecording()
+		closeTunnel()
+		sendReport(notify.StatusSucceeded, "")
+		return
+	}
+
+	logrus.Infof("Migration starting (stages: %s).", strings.Join(stages, ", "))
 
+	switch {
+	case output == "sql":
+		sink = createSQLScript()
+	case output == export.JSON || output == export.YAML || output == export.Terraform || output == export.CSV:
+		logrus.Infof("Exporting the migrated objects to %s instead of writing through the API", outputFile)
+		exportSink = export.New()
+		sink = exportSink
+	case targetDSN != "":
+		sink = connectTarget()
+	case target == "netbox":
+		sink = connectNetBox()
+	case target == "nautobot":
+		sink = connectNautobot()
+	default:
+		sink = ipamsink.New(ipamSession, apiRetry)
+		probeCapabilities()
+		if preflights() {
+			preflightAPI()
+		}
+		if backupTarget != "" {
+			backupTargetInstance(sink.(ipamsink.TargetReader))
+		}
+	}
+	if sectionName != "" {
+		c, ok := sink.(ipamsink.SectionCreator)
+		if !ok {
+			logrus.Fatalf("Sections cannot be looked up by name in %T", sink)
+		}
+		if err := resolveSectionName(c); err != nil {
+			logrus.Fatalf("Error resolving section %s: %s", sectionName, err)
+		}
+	}
+	if len(cfg.SectionRoutes) > 0 {
+		c, _ := sink.(ipamsink.SectionCreator)
+		if err := resolveSectionRoutes(c); err != nil {
+			logrus.Fatalf("Error resolving section routes: %s", err)
+		}
+	}
+	if preflights() {
+		preflightSections(sink.(ipamsink.SectionCreator))
+	}
+	if requireEmptySection {
+		checkSectionsEmpty()
+	}
 	db := connectDB()
-	addVLANs(fetchVLANs(db))
-	addSubnets(fetchSubnets(db))
-	addAddresses(fetchAddresses(db))
+	if err := detectLegacySchema(db); err != nil {
+		logrus.Fatal(err)
+	}
+	preflightCustomFields()
+	if interactive && !wiz.confirmMigration(db) {
+		logrus.Info("Migration cancelled.")
+		saveRecording()
+		closeTunnel()
+		return
+	}
+	var bulk *state.State
+	var syncSnapshot *state.Snapshot
+	if finalRun {
+		bulk = takeFreeze(db)
+		syncSnapshot = bulk.Freeze
+	} else if stateFile != "" && hasStage(pipeline.Write) {
+		syncSnapshot = takeSyncSnapshot(db)
+	}
+	if skippedFile != "" {
+		createSkippedFile()
+	}
+	fetchOrphanAddresses(db)
+	if showProgress || tui {
+		startProgress()
+	}
+	if err := migrationPipeline(db, stages).Run(); err != nil {
+		logrus.Fatalf("Error running migration pipeline: %s", err)
+	}
+	if hasStage(pipeline.Resolve) {
+		preloadVLANIDs()
+		if separatesDuplicateVLANs() && len(duplicateVLANNumbers) > 0 {
+			c, ok := sink.(ipamsink.VLANUpdater)
+			if !ok {
+				logrus.Fatalf("Error preloading duplicate VLAN IDs: VLANs cannot be read from %T", sink)
+			}
+			if err := preloadDuplicateVLANIDs(c); err != nil {
+				logrus.Fatalf("Error preloading duplicate VLAN IDs: %s", err)
+			}
+		}
+		if migrateVRFs {
+			preloadVRFIDs()
+		}
+		if migrateNameservers {
+			preloadNameserverIDs()
+		}
+	}
+	runs := migrateSections(db, stages)
+	completedRuns = runs
+	stopProgress()
+	failed := summarizeSections(runs)
+	if runbookFile != "" {
+		writeRunbook(db, runs)
+	}
+	ready := true
+	if finalRun {
+		ready = finishCutover(db, bulk, runs)
+	}
+	if syncSnapshot != nil && hasStage(pipeline.Write) && failed == 0 {
+		recordSync(syncSnapshot)
+	}
+	closeSQLScript(failed == 0)
+	closeSkippedFile()
+	if exportSink != nil {
+		writeExport()
+	}
 
+	saveRecording()
+	closeJournal()
+	closeTunnel()
+	reportReplayDiffs()
+	if failed > 0 {
+		logrus.Fatalf("Migration failed: %d of %d sections failed.", failed, len(runs))
+	}
+	if !ready {
+		logrus.Fatalf("Cutover not ready: the legacy DB was written to during the final pass - see %s", cutoverReportFile)
+	}
 	logrus.Info("Migration completed.")
+	sendReport(notify.StatusSucceeded, "")
 }
@@ -0,0 +1,1279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/cache"
+	"github.com/paybyphone/phpipam-legacy-migrator/config"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/l2domains"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/dump"
+	"github.com/paybyphone/phpipam-legacy-migrator/export"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/notify"
+	"github.com/paybyphone/phpipam-legacy-migrator/pipeline"
+	"github.com/paybyphone/phpipam-legacy-migrator/probe"
+	"github.com/paybyphone/phpipam-legacy-migrator/progress"
+	"github.com/paybyphone/phpipam-legacy-migrator/ratelimit"
+	"github.com/paybyphone/phpipam-legacy-migrator/state"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+	"github.com/sirupsen/logrus"
+)
+
+// mockIPAM is a mock of the new PHPIPAM instance. It records the names of the
+// objects created, and fails to create the objects named in fail.
+type mockIPAM struct {
+	mu      sync.Mutex
+	fail    map[string]bool
+	created []string
+	subnets map[string]int
+	fields  map[string][]string
+
+	// The names of the nameserver sets, L2 domains, and sections that already
+	// exist.
+	nameservers []string
+	l2Domains   []string
+	sections    []string
+
+	// The VLANs, subnets, and IDs of the IP addresses that already exist.
+	vlans          []vlans.VLAN
+	sectionSubnets []subnets.Subnet
+	addressIDs     map[string]int
+}
+
+func (m *mockIPAM) create(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail[name] {
+		return fmt.Errorf("error creating %s", name)
+	}
+	m.created = append(m.created, name)
+	return nil
+}
+
+func (m *mockIPAM) CreateVLAN(v vlans.VLAN, fields map[string]string) error {
+	return m.create(strconv.Itoa(v.Number))
+}
+
+func (m *mockIPAM) CreateSubnet(v subnets.Subnet, fields map[string]string) error {
+	return m.create(fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask))
+}
+
+func (m *mockIPAM) CreateAddress(a addresses.Address, fields map[string]string) error {
+	return m.create(a.IPAddress)
+}
+
+func (m *mockIPAM) SubnetID(sectionID int, cidr string) (int, error) {
+	if id, ok := m.subnets[cidr]; ok {
+		return id, nil
+	}
+	return 0, errors.New("Error from API (404): No subnets found")
+}
+
+func (m *mockIPAM) SubnetIDs(sectionID int) (map[string]int, error) {
+	return m.subnets, nil
+}
+
+func (m *mockIPAM) CustomFields(table string) ([]string, error) {
+	return m.fields[table], nil
+}
+
+func (m *mockIPAM) CreateCustomField(table, name string) error {
+	return m.create(table + "." + name)
+}
+
+func (m *mockIPAM) CreateNameserver(n nameservers.Nameserver) error {
+	return m.create(n.Name)
+}
+
+func (m *mockIPAM) Nameservers() (out []nameservers.Nameserver, err error) {
+	for i, v := range m.nameservers {
+		out = append(out, nameservers.Nameserver{ID: i + 1, Name: v})
+	}
+	return out, nil
+}
+
+func (m *mockIPAM) CreateL2Domain(d l2domains.Domain) error {
+	if err := m.create(d.Name); err != nil {
+		return err
+	}
+	m.l2Domains = append(m.l2Domains, d.Name)
+	return nil
+}
+
+func (m *mockIPAM) L2Domains() (out []l2domains.Domain, err error) {
+	for i, v := range m.l2Domains {
+		out = append(out, l2domains.Domain{ID: i + 1, Name: v})
+	}
+	return out, nil
+}
+
+func (m *mockIPAM) CreateSection(v sections.Section) error {
+	if err := m.create(v.Name); err != nil {
+		return err
+	}
+	m.sections = append(m.sections, v.Name)
+	return nil
+}
+
+func (m *mockIPAM) Sections() (out []sections.Section, err error) {
+	for i, v := range m.sections {
+		out = append(out, sections.Section{ID: i + 1, Name: v})
+	}
+	return out, nil
+}
+
+func (m *mockIPAM) UpdateSection(v sections.Section) error {
+	return m.create(fmt.Sprintf("%d:%d", v.ID, v.MasterSection))
+}
+
+func (m *mockIPAM) VLANs() ([]vlans.VLAN, error) {
+	return m.vlans, nil
+}
+
+func (m *mockIPAM) UpdateVLAN(v vlans.VLAN) error {
+	return m.create(fmt.Sprintf("%d:%s:%s", v.ID, v.Name, v.Description))
+}
+
+func (m *mockIPAM) Subnets(sectionID int) (out []subnets.Subnet, err error) {
+	for _, v := range m.sectionSubnets {
+		if v.SectionID == 0 || v.SectionID == sectionID {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockIPAM) UpdateSubnet(v subnets.Subnet) error {
+	return m.create(fmt.Sprintf("%d:%s:%d", v.ID, v.Description, v.VLANID))
+}
+
+func (m *mockIPAM) AddressID(subnetID int, ip string) (int, error) {
+	return m.addressIDs[ip], nil
+}
+
+func (m *mockIPAM) UpdateAddress(a addresses.Address) error {
+	return m.create(fmt.Sprintf("%d:%s", a.ID, a.Hostname))
+}
+
+func TestAddCustomFields(t *testing.T) {
+	defer func(m *legacydb.Mapping) { legacyMapping = m }(legacyMapping)
+	legacyMapping = &legacydb.Mapping{CustomFields: map[string]map[string]string{
+		"ipaddresses": {"rack": "custom_Rack", "cust_ref": "custom_Customer"},
+		"vlans":       {"site": "custom_Site"},
+	}}
+	if !mapsCustomFields() {
+		t.Fatal("Expected custom fields to be mapped")
+	}
+
+	m := &mockIPAM{fields: map[string][]string{"ipaddresses": {"custom_rack"}}}
+	if err := addCustomFields(m); err != nil {
+		t.Fatalf("Error adding custom fields: %s", err)
+	}
+	if expected := []string{"vlans.custom_Site", "ipaddresses.custom_Customer"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+
+	legacyMapping = nil
+	if mapsCustomFields() {
+		t.Fatal("Expected no custom fields to be mapped")
+	}
+}
+
+func TestGateCustomFields(t *testing.T) {
+	defer func(m *legacydb.Mapping, q *legacydb.Queries, stampV, stampF, idF string, c probe.Capabilities) {
+		legacyMapping, legacyQueries, stamp, stampField, legacyIDField, capabilities = m, q, stampV, stampF, idF, c
+	}(legacyMapping, legacyQueries, stamp, stampField, legacyIDField, capabilities)
+	legacyMapping = &legacydb.Mapping{CustomFields: map[string]map[string]string{"ipaddresses": {"rack": "custom_Rack"}}}
+	stamp, stampField, legacyIDField = "[migrated]", "custom_Migrated", "legacy_id"
+
+	capabilities = probe.Capabilities{probe.CustomFields.Name: {Available: true}}
+	gateCapabilities()
+	if !mapsCustomFields() || stampField == "" || legacyIDField == "" {
+		t.Fatal("Expected custom fields to stay enabled")
+	}
+
+	capabilities = probe.Capabilities{probe.CustomFields.Name: {Reason: "Error from API (500): boom"}}
+	gateCapabilities()
+	if mapsCustomFields() || stampField != "" || legacyIDField != "" || stamp != "[migrated]" {
+		t.Fatalf("Expected custom fields to be disabled, and the stamp appended to descriptions, got stamp %q in %q", stamp, stampField)
+	}
+}
+
+func TestCheckCustomFields(t *testing.T) {
+	defer func(f string) { legacyIDField = f }(legacyIDField)
+	legacyIDField = "legacy_id"
+
+	m := &mockIPAM{fields: map[string][]string{"vlans": {"legacy_id"}, "subnets": {"Legacy_ID"}}}
+	err := checkCustomFields(m)
+	if expected := "custom fields ipaddresses.legacy_id do not exist in the new PHPIPAM instance"; err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("Expected error %q, got %v", expected, err)
+	}
+	m.fields["ipaddresses"] = []string{"legacy_id"}
+	if err := checkCustomFields(m); err != nil {
+		t.Fatalf("Expected no missing custom fields, got %s", err)
+	}
+	if len(m.created) != 0 {
+		t.Fatalf("Expected no custom fields to be created, got %#v", m.created)
+	}
+}
+
+func TestAddStampField(t *testing.T) {
+	defer func(f string) { stampField = f }(stampField)
+	stampField = "custom_Migrated"
+	if !mapsCustomFields() {
+		t.Fatal("Expected the stamp custom field to be created")
+	}
+
+	m := &mockIPAM{fields: map[string][]string{"vlans": {"custom_migrated"}}}
+	if err := addCustomFields(m); err != nil {
+		t.Fatalf("Error adding custom fields: %s", err)
+	}
+	if expected := []string{"subnets.custom_Migrated", "ipaddresses.custom_Migrated"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+}
+
+func TestSetLegacyID(t *testing.T) {
+	defer func(f string) { legacyIDField = f }(legacyIDField)
+	legacyIDField = "legacy_id"
+
+	shared := map[string]string{"custom_Site": "HQ"}
+	a, b := shared, shared
+	setLegacyID(&a, "3")
+	setLegacyID(&b, "")
+	if expected := map[string]string{"custom_Site": "HQ", "legacy_id": "3"}; !reflect.DeepEqual(expected, a) {
+		t.Fatalf("Expected %v, got %v", expected, a)
+	}
+	if len(shared) != 1 || len(b) != 1 {
+		t.Fatalf("Expected the shared custom fields to be left as they are, got %v", shared)
+	}
+
+	var fields map[string]string
+	setLegacyID(&fields, joinInts([]int{2, 7}))
+	if fields["legacy_id"] != "2,7" {
+		t.Fatalf("Expected legacy ID 2,7, got %v", fields)
+	}
+}
+
+func TestAddVLANs(t *testing.T) {
+	m := &mockIPAM{fail: map[string]bool{"200": true}}
+	lans := []legacydb.VLAN{
+		{VLAN: vlans.VLAN{Number: 100}},
+		{VLAN: vlans.VLAN{Number: 200}},
+		{VLAN: vlans.VLAN{Number: 300}},
+	}
+	if err := addVLANs(m, lans); err == nil {
+		t.Fatal("Expected error adding VLANs, got none")
+	}
+	if expected := []string{"100"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+}
+
+func TestAddExistingVLANs(t *testing.T) {
+	defer func(v string) { existingVLANs = v }(existingVLANs)
+	existing := []vlans.VLAN{
+		{ID: 1, DomainID: 1, Number: 100},
+		{ID: 2, DomainID: 2, Number: 200, Name: "storage"},
+	}
+	lans := []legacydb.VLAN{
+		{VLAN: vlans.VLAN{Number: 100, Name: "servers", Description: "Servers"}},
+		{VLAN: vlans.VLAN{Number: 200, Name: "backup"}},
+		{VLAN: vlans.VLAN{Number: 200, Name: "storage", DomainID: 2}},
+	}
+	for _, tc := range []struct {
+		policy   string
+		expected []string
+	}{
+		{"skip", []string{"200"}},
+		{"merge", []string{"1:servers:Servers", "200"}},
+	} {
+		existingVLANs = tc.policy
+		m := &mockIPAM{vlans: existing}
+		if err := addVLANs(m, lans); err != nil {
+			t.Fatalf("Error adding VLANs with -existing-vlans %s: %s", tc.policy, err)
+		}
+		if !reflect.DeepEqual(tc.expected, m.created) {
+			t.Fatalf("Expected %#v with -existing-vlans %s, got %#v", tc.expected, tc.policy, m.created)
+		}
+	}
+}
+
+func TestApplyDuplicateVLANs(t *testing.T) {
+	d, err := dump.Parse(strings.NewReader("CREATE TABLE `vlans` (`vlanId` int, `name` varchar(255), `number` int, `description` text);\n" +
+		"INSERT INTO `vlans` VALUES (1,'servers',100,''),(2,'lab',200,''),(3,'',100,'Old servers'),(4,'dmz',100,'');\n"))
+	if err != nil {
+		t.Fatalf("Error parsing dump: %s", err)
+	}
+	sql.Register("main-duplicate-vlans", &dump.Driver{Dump: d})
+	conn, err := sql.Open("main-duplicate-vlans", "")
+	if err != nil {
+		t.Fatalf("Error opening dump: %s", err)
+	}
+	defer func(v string, q *legacydb.Queries) {
+		duplicateVLANs, legacyQueries, duplicateVLANNumbers, duplicateVLANDomains = v, q, nil, make(map[int]string)
+	}(duplicateVLANs, legacyQueries)
+	legacyQueries = nil
+
+	lans := []legacydb.VLAN{
+		{VLAN: vlans.VLAN{Number: 100, Name: "servers"}},
+		{VLAN: vlans.VLAN{Number: 200, Name: "lab"}},
+		{VLAN: vlans.VLAN{Number: 100, Description: "Old servers"}},
+		{VLAN: vlans.VLAN{Number: 100, Name: "dmz"}},
+	}
+	for _, tc := range []struct {
+		policy   string
+		expected []string
+	}{
+		{"merge", []string{"100:servers:Old servers", "200:lab:"}},
+		{"suffix", []string{"100:servers:", "100:(2):Old servers", "100:dmz (3):", "200:lab:"}},
+		{"domains", []string{"100:servers:", "100::Old servers", "100:dmz:", "200:lab:"}},
+	} {
+		duplicateVLANs = tc.policy
+		out, err := applyDuplicateVLANs(conn, lans)
+		if err != nil {
+			t.Fatalf("Error applying -duplicate-vlans %s: %s", tc.policy, err)
+		}
+		var actual []string
+		for _, v := range out {
+			actual = append(actual, fmt.Sprintf("%d:%s:%s", v.Number, v.Name, v.Description))
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Fatalf("Expected %#v with -duplicate-vlans %s, got %#v", tc.expected, tc.policy, actual)
+		}
+		if expected := []int{100}; !reflect.DeepEqual(expected, duplicateVLANNumbers) {
+			t.Fatalf("Expected duplicate VLAN numbers %v with -duplicate-vlans %s, got %v", expected, tc.policy, duplicateVLANNumbers)
+		}
+	}
+	if expected := map[int]string{3: "Duplicate VLANs 2", 4: "Duplicate VLANs 3"}; !reflect.DeepEqual(expected, duplicateVLANDomains) {
+		t.Fatalf("Expected duplicate VLAN domains %v, got %v", expected, duplicateVLANDomains)
+	}
+}
+
+func TestResolveSectionName(t *testing.T) {
+	defer func(name string, id int, s []string) { sectionName, sectionID, stages = name, id, s }(sectionName, sectionID, stages)
+	m := &mockIPAM{sections: []string{"Customers", "IPv6"}}
+
+	sectionName, stages = "IPv6", []string{pipeline.Fetch}
+	if err := resolveSectionName(m); err != nil || sectionID != 2 {
+		t.Fatalf("Expected section ID 2, got %d (%v)", sectionID, err)
+	}
+	sectionName = "Datacenter"
+	if err := resolveSectionName(m); err == nil {
+		t.Fatal("Expected error resolving missing section without the write stage, got none")
+	}
+	stages = pipeline.DefaultStages
+	if err := resolveSectionName(m); err != nil || sectionID != 3 {
+		t.Fatalf("Expected section ID 3, got %d (%v)", sectionID, err)
+	}
+	if expected := []string{"Datacenter"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+}
+
+func TestAddSectionHierarchy(t *testing.T) {
+	defer func(m []helper.SectionMapping) { sectionMappings = m }(sectionMappings)
+	sectionMappings = []helper.SectionMapping{{LegacyID: 1, ID: 3}, {LegacyID: 2, ID: 4}, {LegacyID: 5, ID: 6}, {LegacyID: 7, ID: 3}}
+	m := &mockIPAM{}
+
+	// Legacy section 5 has a master that is not migrated, and 7 is migrated
+	// to the same section as its master.
+	if err := addSectionHierarchy(m, map[int]int{2: 1, 5: 9, 7: 1}); err != nil {
+		t.Fatalf("Error adding section hierarchy: %s", err)
+	}
+	if expected := []string{"4:3"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be updated, got %#v", expected, m.created)
+	}
+	m.fail = map[string]bool{"4:3": true}
+	if err := addSectionHierarchy(m, map[int]int{2: 1}); err == nil {
+		t.Fatal("Expected error updating section, got none")
+	}
+}
+
+// sectionSubnets is a SubnetFinder for TestPopulatedSections, holding the
+// subnet IDs of each section.
+type sectionSubnets map[int]map[string]int
+
+func (s sectionSubnets) SubnetID(sectionID int, cidr string) (int, error) {
+	return s[sectionID][cidr], nil
+}
+
+func (s sectionSubnets) SubnetIDs(sectionID int) (map[string]int, error) {
+	if sectionID == 9 {
+		return nil, errors.New("Error from API (500): broken")
+	}
+	return s[sectionID], nil
+}
+
+func TestPopulatedSections(t *testing.T) {
+	defer func(m []helper.SectionMapping) { sectionMappings, sectionRoutes = m, nil }(sectionMappings)
+	sectionMappings = []helper.SectionMapping{{LegacyID: 1, ID: 3}, {LegacyID: 2, ID: 4}, {LegacyID: 7, ID: 3}}
+	sectionRoutes = []sectionRoute{{id: 5}}
+	c := sectionSubnets{4: {"10.0.0.0/24": 1, "10.1.0.0/24": 2}, 5: {}}
+
+	actual, err := populatedSections(c)
+	if err != nil {
+		t.Fatalf("Error checking sections: %s", err)
+	}
+	if expected := []string{"section 4 has 2 subnets"}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if expected := "3;4;5"; migratedSections() != expected {
+		t.Fatalf("Expected migrated sections %s, got %s", expected, migratedSections())
+	}
+
+	sectionRoutes = append(sectionRoutes, sectionRoute{id: 9})
+	if _, err := populatedSections(c); err == nil {
+		t.Fatal("Expected error listing subnets, got none")
+	}
+}
+
+func TestMissingSections(t *testing.T) {
+	defer func(m []helper.SectionMapping) { sectionMappings, sectionRoutes = m, nil }(sectionMappings)
+	sectionMappings = []helper.SectionMapping{{LegacyID: 1, ID: 1}, {LegacyID: 2, ID: 4}}
+	sectionRoutes = []sectionRoute{{id: 2}, {id: 3}}
+	m := &mockIPAM{sections: []string{"Customers", "IPv6"}}
+
+	actual, err := missingSections(m)
+	if err != nil {
+		t.Fatalf("Error checking sections: %s", err)
+	}
+	if expected := []int{4, 3}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected missing sections %v, got %v", expected, actual)
+	}
+}
+
+func TestWizard(t *testing.T) {
+	defer func(host, user, endpoint, appID, ipamUserName string, id int, vrfs, devices bool) {
+		dbHost, dbUser, ipamEndpoint, ipamAppID, ipamUser, sectionID, migrateVRFs, migrateDevices = host, user, endpoint, appID, ipamUserName, id, vrfs, devices
+		wizardIn, wizardOut = os.Stdin, os.Stdout
+	}(dbHost, dbUser, ipamEndpoint, ipamAppID, ipamUser, sectionID, migrateVRFs, migrateDevices)
+	// Blank answers take the defaults, and invalid ones are asked again.
+	wizardIn = strings.NewReader(strings.Join([]string{
+		"db.example.com", "x", "", "migrator", "",
+		"", "https://ipam.example.com/api", "migration", "admin",
+		"9", "2",
+		"y", "", "maybe", "yes",
+	}, "\n") + "\n")
+	wizardOut = ioutil.Discard
+	dbHost, dbUser, ipamEndpoint, ipamAppID, ipamUser, sectionID = "", "phpipam", "", "", "", 1
+
+	w := newWizard()
+	w.connection()
+	w.chooseSection(&mockIPAM{sections: []string{"Customers", "IPv6"}})
+	w.chooseEntities()
+	if dbHost != "db.example.com" || dbPort != 3306 || dbUser != "migrator" || dbName != "phpipam" {
+		t.Fatalf("Unexpected legacy DB %s@%s:%d/%s", dbUser, dbHost, dbPort, dbName)
+	}
+	if ipamEndpoint != "https://ipam.example.com/api" || ipamAppID != "migration" || ipamUser != "admin" {
+		t.Fatalf("Unexpected PHPIPAM instance %s@%s (%s)", ipamUser, ipamEndpoint, ipamAppID)
+	}
+	if sectionID != 2 || !migrateVRFs || migrateNameservers || !migrateDevices {
+		t.Fatalf("Unexpected section %d, VRFs %t, nameservers %t, devices %t", sectionID, migrateVRFs, migrateNameservers, migrateDevices)
+	}
+	expected := []string{"-dbhost=db.example.com", "-dbuser=migrator", "-endpoint=https://ipam.example.com/api", "-appid=migration", "-user=admin", "-sectionid=2", "-migrate-vrfs=true", "-migrate-devices=true"}
+	if !reflect.DeepEqual(expected, w.args) {
+		t.Fatalf("Expected arguments %v, got %v", expected, w.args)
+	}
+}
+
+func TestTUIHook(t *testing.T) {
+	h := &tuiHook{}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, v := range []struct {
+		level logrus.Level
+		msg   string
+	}{
+		{logrus.InfoLevel, "Adding subnets."},
+		{logrus.WarnLevel, "VLAN 100 was missing"},
+		{logrus.ErrorLevel, "Error adding address"},
+	} {
+		h.Fire(&logrus.Entry{Time: at, Level: v.level, Message: v.msg})
+	}
+	if errors, warnings := h.counts(); errors != 1 || warnings != 1 {
+		t.Fatalf("Expected 1 error and 1 warning, got %d and %d", errors, warnings)
+	}
+	expected := []string{"12:00:00 WARNING VLAN 100 was missing", "12:00:00 ERROR Error adding address"}
+	if actual := h.stop(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected kept lines %#v, got %#v", expected, actual)
+	}
+	h.Fire(&logrus.Entry{Time: at, Level: logrus.ErrorLevel, Message: "After stopping"})
+	if errors, _ := h.counts(); errors != 1 {
+		t.Fatalf("Expected messages logged after stopping to be ignored, got %d errors", errors)
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	defer func(d *progress.Display) { progressDisplay = d }(progressDisplay)
+	progressDisplay = &progress.Display{}
+	tracker := progressDisplay.Track("addresses (section 1)", 4)
+	tracker.Add(1)
+	tracker.SetCurrent("10.0.0.1")
+	webUILog.Fire(&logrus.Entry{Time: time.Now(), Level: logrus.WarnLevel, Message: "VLAN 100 was missing"})
+
+	w := httptest.NewRecorder()
+	serveStatus(w, httptest.NewRequest("GET", "/status.json", nil))
+	var status webUIStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Error decoding status: %s", err)
+	}
+	if status.Status != notify.StatusRunning || len(status.Phases) != 1 || status.Phases[0].Current != "10.0.0.1" || status.Warnings == 0 {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	w = httptest.NewRecorder()
+	serveDashboard(w, httptest.NewRequest("GET", "/", nil))
+	for _, v := range []string{"addresses (section 1)", `<progress max="4" value="1">`, "VLAN 100 was missing", `<a href="report.json">`} {
+		if !strings.Contains(w.Body.String(), v) {
+			t.Fatalf("Expected dashboard to contain %q, got:\n%s", v, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	serveReport(w, httptest.NewRequest("GET", "/report.json", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment; filename=migration-report-") {
+		t.Fatalf("Expected report to be served as a download, got Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+	var report notify.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Status != notify.StatusRunning {
+		t.Fatalf("Expected running report, got %+v (%v)", report, err)
+	}
+
+	w = httptest.NewRecorder()
+	serveDashboard(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for unknown page, got %d", w.Code)
+	}
+
+	// Once the run has finished, its final status is served.
+	defer func() { webUIFinal = nil }()
+	webUIFinal = runReport(notify.StatusFailed, "Migration failed: 1 of 1 sections failed.")
+	w = httptest.NewRecorder()
+	serveStatus(w, httptest.NewRequest("GET", "/status.json", nil))
+	status = webUIStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.Status != notify.StatusFailed || status.Error != webUIFinal.Error {
+		t.Fatalf("Expected failed status, got %+v (%v)", status, err)
+	}
+	w = httptest.NewRecorder()
+	serveDashboard(w, httptest.NewRequest("GET", "/", nil))
+	for _, v := range []string{`<span class="failed">failed</span>`, "Migration failed: 1 of 1 sections failed.", "and finished after"} {
+		if !strings.Contains(w.Body.String(), v) {
+			t.Fatalf("Expected dashboard to contain %q, got:\n%s", v, w.Body.String())
+		}
+	}
+	w = httptest.NewRecorder()
+	serveReport(w, httptest.NewRequest("GET", "/report.json", nil))
+	report = notify.Report{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Status != notify.StatusFailed {
+		t.Fatalf("Expected failed report, got %+v (%v)", report, err)
+	}
+}
+
+func TestAddNameservers(t *testing.T) {
+	m := &mockIPAM{nameservers: []string{"Public"}, fail: map[string]bool{"Broken": true}}
+	sets := []nameservers.Nameserver{{Name: "Public"}, {Name: "Internal"}, {Name: "Internal"}}
+	if err := addNameservers(m, sets); err != nil {
+		t.Fatalf("Error adding nameserver sets: %s", err)
+	}
+	if expected := []string{"Internal"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+	if err := addNameservers(m, []nameservers.Nameserver{{Name: "Broken"}}); err == nil {
+		t.Fatal("Expected error adding nameserver set, got none")
+	}
+}
+
+func TestAddVRFsAndDevicesTwice(t *testing.T) {
+	defer func(s ipamsink.Target, ids map[string]int) { sink, switchDeviceIDs = s, ids }(sink, switchDeviceIDs)
+	e := export.New()
+	sink, switchDeviceIDs = e, make(map[string]int)
+
+	inv := helper.SwitchInventory{}
+	inv.AddDevice("sw1", "10.0.0.2", "", "")
+	inv.AddDevice("sw2", "10.0.0.3", "", "")
+	in := []vrfs.VRF{{Name: "customers"}, {Name: "management"}}
+	// A delta pass adds the same VRFs and switches again, which must be
+	// skipped rather than created twice.
+	for i := 0; i < 2; i++ {
+		if err := addVRFs(e, in); err != nil {
+			t.Fatalf("Error adding VRFs (pass %d): %s", i+1, err)
+		}
+		if err := addDevices(inv, nil); err != nil {
+			t.Fatalf("Error adding devices (pass %d): %s", i+1, err)
+		}
+	}
+	if found, _ := e.VRFs(); len(found) != 2 {
+		t.Fatalf("Expected 2 VRFs, got %#v", found)
+	}
+	if found, _ := e.Devices(); len(found) != 2 {
+		t.Fatalf("Expected 2 devices, got %#v", found)
+	}
+	if len(switchDeviceIDs) != 2 {
+		t.Fatalf("Expected the IDs of both switches, got %v", switchDeviceIDs)
+	}
+}
+
+func TestAddL2Domains(t *testing.T) {
+	defer func(domains map[int]string, lans []legacydb.VLAN) {
+		sectionVLANDomains, legacyVLANs, l2DomainIDs = domains, lans, make(map[string]int)
+	}(sectionVLANDomains, legacyVLANs)
+	sectionVLANDomains = map[int]string{100: "Section 2", 200: "Section 3"}
+	legacyVLANs = []legacydb.VLAN{{VLAN: vlans.VLAN{Number: 100}}, {VLAN: vlans.VLAN{Number: 200}}, {VLAN: vlans.VLAN{Number: 300}}}
+
+	m := &mockIPAM{l2Domains: []string{"default", "Section 2"}}
+	if err := addL2Domains(m, []l2domains.Domain{{Name: "Section 2"}, {Name: "Section 3"}}); err != nil {
+		t.Fatalf("Error adding L2 domains: %s", err)
+	}
+	if expected := []string{"Section 3"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+	if err := resolveVLANs(); err != nil {
+		t.Fatalf("Error resolving VLANs: %s", err)
+	}
+	for i, expected := range []int{2, 3, 0} {
+		if actual := legacyVLANs[i].DomainID; actual != expected {
+			t.Fatalf("Expected VLAN %d in domain %d, got %d", legacyVLANs[i].Number, expected, actual)
+		}
+	}
+
+	sectionVLANDomains[300] = "Section 4"
+	if err := resolveVLANs(); err == nil {
+		t.Fatal("Expected error resolving VLAN in missing L2 domain, got none")
+	}
+}
+
+func TestAddSubnetsErrorBudget(t *testing.T) {
+	defer func(n int) { sectionErrorBudget = n }(sectionErrorBudget)
+	sectionErrorBudget = 1
+
+	m := &mockIPAM{fail: map[string]bool{"10.1.0.0/16": true, "10.3.0.0/16": true}}
+	var nets []legacydb.Subnet
+	for i := 0; i < 4; i++ {
+		nets = append(nets, legacydb.Subnet{Subnet: subnets.Subnet{SubnetAddress: fmt.Sprintf("10.%d.0.0", i), Mask: 16}})
+	}
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	if err := s.addSubnets(m, nets); err == nil {
+		t.Fatal("Expected error budget to be exceeded, got no error")
+	}
+	if s.SubnetsAdded != 2 || len(s.Errors) != 2 {
+		t.Fatalf("Expected 2 subnets added and 2 errors, got %d and %d", s.SubnetsAdded, len(s.Errors))
+	}
+}
+
+func TestAddExistingSubnets(t *testing.T) {
+	defer func(v string) { existingSubnets = v }(existingSubnets)
+	existing := []subnets.Subnet{
+		{ID: 1, SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1},
+		{ID: 2, SubnetAddress: "10.1.0.0", Mask: 24, SectionID: 1, Description: "Office", VLANID: 3},
+	}
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, Description: "Servers", VLANID: 4}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.1.0.0", Mask: 24, Description: "Legacy office", VLANID: 4}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.2.0.0", Mask: 24}},
+	}
+	for _, tc := range []struct {
+		policy   string
+		expected []string
+	}{
+		{"skip", []string{"10.2.0.0/24"}},
+		{"merge", []string{"1:Servers:4", "10.2.0.0/24"}},
+	} {
+		existingSubnets = tc.policy
+		m := &mockIPAM{sectionSubnets: existing}
+		s := newSectionRun(helper.SectionMapping{ID: 1})
+		if err := s.addSubnets(m, nets); err != nil {
+			t.Fatalf("Error adding subnets with -existing-subnets %s: %s", tc.policy, err)
+		}
+		if !reflect.DeepEqual(tc.expected, m.created) {
+			t.Fatalf("Expected %#v with -existing-subnets %s, got %#v", tc.expected, tc.policy, m.created)
+		}
+		if s.SubnetsAdded != 1 {
+			t.Fatalf("Expected 1 subnet added with -existing-subnets %s, got %d", tc.policy, s.SubnetsAdded)
+		}
+	}
+}
+
+func TestAddAggregateSubnets(t *testing.T) {
+	m := &mockIPAM{sectionSubnets: []subnets.Subnet{{ID: 1, SubnetAddress: "10.0.0.0", Mask: 8, SectionID: 1}}}
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.1.0.0", Mask: 16}, Aggregate: true},
+		{Subnet: subnets.Subnet{SubnetAddress: "192.168.0.0", Mask: 16}, Aggregate: true},
+	}
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	if err := s.addSubnets(m, nets); err != nil {
+		t.Fatalf("Error adding aggregate subnets: %s", err)
+	}
+	if expected := []string{"192.168.0.0/16"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+}
+
+func TestSectionRoutes(t *testing.T) {
+	defer func(c *config.Config, s []string) { cfg, stages, sectionRoutes = c, s, nil }(cfg, stages)
+	cfg = &config.Config{SectionRoutes: []config.SectionRoute{
+		{Subnet: "10.0.0.0/8", Section: "Ops"},
+		{Subnet: "10.1.0.0/16", SectionID: 5},
+		{Subnet: "192.168.0.0/16", Section: "Lab"},
+	}}
+	stages = pipeline.DefaultStages
+	m := &mockIPAM{sections: []string{"Customers", "Lab"}}
+	if err := resolveSectionRoutes(nil); err == nil {
+		t.Fatal("Expected error resolving named sections without a section creator, got none")
+	}
+	if err := resolveSectionRoutes(m); err != nil {
+		t.Fatalf("Error resolving section routes: %s", err)
+	}
+	if expected := []string{"Ops"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	for cidr, expected := range map[string]int{
+		"10.0.0.0/24":    3,
+		"10.1.2.0/24":    5,
+		"10.0.0.0/7":     1,
+		"192.168.1.0/24": 2,
+		"172.16.0.0/12":  1,
+	} {
+		if actual := s.subnetSection(cidr); actual != expected {
+			t.Fatalf("Expected subnet %s in section %d, got %d", cidr, expected, actual)
+		}
+	}
+
+	// Subnets are only skipped if they already exist in their own section.
+	m = &mockIPAM{sectionSubnets: []subnets.Subnet{{ID: 1, SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1}}}
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 3}},
+		{Subnet: subnets.Subnet{SubnetAddress: "172.16.0.0", Mask: 24, SectionID: 1}},
+	}
+	if expected := []int{1, 3}; !reflect.DeepEqual(expected, s.targetSections(nets)) {
+		t.Fatalf("Expected target sections %v, got %v", expected, s.targetSections(nets))
+	}
+	if err := s.addSubnets(m, nets); err != nil {
+		t.Fatalf("Error adding subnets: %s", err)
+	}
+	if expected := []string{"10.0.0.0/24", "172.16.0.0/24"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+}
+
+func TestAddAddressUpsert(t *testing.T) {
+	defer func(upsert bool, n, budget int) {
+		addressesUpsert, addressWorkers, sectionErrorBudget = upsert, n, budget
+	}(addressesUpsert, addressWorkers, sectionErrorBudget)
+	addressesUpsert = true
+	addressWorkers = 1
+	sectionErrorBudget = 5
+
+	m := &mockIPAM{fail: map[string]bool{"10.0.0.1": true, "10.0.0.2": true}, addressIDs: map[string]int{"10.0.0.1": 5}}
+	var addrs []legacydb.Address
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		addrs = append(addrs, legacydb.Address{Address: addresses.Address{IPAddress: ip, SubnetID: 1, Hostname: "web1"}})
+	}
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	if err := s.addAddresses(m, addrs); err != nil {
+		t.Fatalf("Error adding addresses: %s", err)
+	}
+	if expected := []string{"5:web1", "10.0.0.3"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v, got %#v", expected, m.created)
+	}
+	if s.AddressesAdded != 1 || len(s.Errors) != 1 {
+		t.Fatalf("Expected 1 address added and 1 error, got %d and %d", s.AddressesAdded, len(s.Errors))
+	}
+}
+
+func TestValidateSubnets(t *testing.T) {
+	defer func(policy string) { overlappingSubnets = policy }(overlappingSubnets)
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	s.subnets = []legacydb.Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 16, SectionID: 1}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1}},
+	}
+	overlappingSubnets = "abort"
+	if err := s.validateSubnets(); err != nil || len(s.Overlaps) != 0 {
+		t.Fatalf("Expected nested subnets to be valid, got %v (%v)", s.Overlaps, err)
+	}
+
+	s.subnets = append(s.subnets, legacydb.Subnet{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 24, SectionID: 1}})
+	if err := s.validateSubnets(); err == nil || len(s.Overlaps) != 1 {
+		t.Fatalf("Expected duplicate subnets to abort the section, got %v (%v)", s.Overlaps, err)
+	}
+	overlappingSubnets = "warn"
+	if err := s.validateSubnets(); err != nil || len(s.Overlaps) != 1 {
+		t.Fatalf("Expected duplicate subnets to only be reported, got %v (%v)", s.Overlaps, err)
+	}
+}
+
+func TestValidateAddresses(t *testing.T) {
+	defer func(skip bool) { skipNetworkBroadcast = skip }(skipNetworkBroadcast)
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.0"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.1"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.255"}, SubnetCIDR: "10.0.0.0/24"},
+	}
+	s.addresses = addrs
+	if err := s.validateAddresses(); err != nil || len(s.addresses) != 3 {
+		t.Fatalf("Expected addresses to be kept without -skip-network-broadcast, got %#v (%v)", s.addresses, err)
+	}
+
+	skipNetworkBroadcast = true
+	if err := s.validateAddresses(); err != nil {
+		t.Fatalf("Error validating addresses: %s", err)
+	}
+	if len(s.addresses) != 1 || s.addresses[0].IPAddress != "10.0.0.1" || s.SkippedNetworkBroadcast != 2 {
+		t.Fatalf("Expected the network and broadcast addresses to be skipped, got %#v", s.addresses)
+	}
+}
+
+func TestTransformAddressesHostnames(t *testing.T) {
+	defer func(lower, strip, validate bool) {
+		lowercaseHostnames, stripHostnameDots, validateHostnames = lower, strip, validate
+	}(lowercaseHostnames, stripHostnameDots, validateHostnames)
+	lowercaseHostnames, stripHostnameDots, validateHostnames = true, true, true
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	s.addresses = []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Hostname: "GW.Example.com."}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Hostname: "File_Server"}, SubnetCIDR: "10.0.0.0/24"},
+	}
+	if err := s.transformAddresses(); err != nil {
+		t.Fatalf("Error transforming addresses: %s", err)
+	}
+	if s.addresses[0].Hostname != "gw.example.com" || s.addresses[1].Hostname != "file_server" {
+		t.Fatalf("Expected normalized hostnames, got %#v", s.addresses)
+	}
+	if expected := []string{"10.0.0.2 (file_server)"}; !reflect.DeepEqual(s.InvalidHostnames, expected) {
+		t.Fatalf("Expected invalid hostnames %q, got %q", expected, s.InvalidHostnames)
+	}
+}
+
+func TestFillHostnamesFromDNS(t *testing.T) {
+	defer func(f func(context.Context, string) ([]string, error)) { lookupAddr = f }(lookupAddr)
+	lookupAddr = func(_ context.Context, addr string) ([]string, error) {
+		if addr == "10.0.0.1" {
+			return []string{"gw.example.com.", "router.example.com."}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	s.addresses = []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.3", Hostname: "kept.example.com"}},
+	}
+	if n := s.fillHostnamesFromDNS(); n != 1 {
+		t.Fatalf("Expected 1 hostname filled in, got %d", n)
+	}
+	if a := s.addresses[0]; a.Hostname != "gw.example.com" || len(a.Changes) != 1 {
+		t.Fatalf("Expected hostname from PTR record and 1 change, got %#v", a)
+	}
+	if s.addresses[1].Hostname != "" || s.addresses[2].Hostname != "kept.example.com" {
+		t.Fatalf("Expected the other hostnames to be left as they are, got %#v", s.addresses[1:])
+	}
+}
+
+func TestCheckLiveness(t *testing.T) {
+	defer func(f func(string) bool, l *ratelimit.Limiter) { probeAddress, livenessLimiter = f, l }(probeAddress, livenessLimiter)
+	probeAddress = func(ip string) bool { return ip == "10.0.0.1" }
+	livenessLimiter = ratelimit.New(1000, livenessWorkers)
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	s.addresses = []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", LastSeen: "2015-01-01 00:00:00", Tag: helper.TagOffline}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", LastSeen: "2015-01-01 00:00:00", Tag: helper.TagUsed}},
+		{Address: addresses.Address{IPAddress: "10.0.0.3"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.4", LastSeen: "2015-01-01 00:00:00", Tag: helper.TagReserved}},
+	}
+	if n := s.checkLiveness(); n != 1 {
+		t.Fatalf("Expected 1 address alive, got %d", n)
+	}
+	if a := s.addresses[0]; a.LastSeen <= "2015-01-01 00:00:00" || a.Tag != helper.TagUsed {
+		t.Fatalf("Expected the live address to be seen now and used, got %#v", a)
+	}
+	for i, tag := range []int{helper.TagOffline, helper.TagOffline, helper.TagReserved} {
+		if a := s.addresses[i+1]; a.LastSeen != "" || a.Tag != tag {
+			t.Fatalf("Expected %s to be never seen and tagged %d, got %#v", a.IPAddress, tag, a)
+		}
+	}
+}
+
+func TestTCPAlive(t *testing.T) {
+	defer func(ports []int, timeout time.Duration) { livenessPorts, livenessTimeout = ports, timeout }(livenessPorts, livenessTimeout)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	livenessPorts, livenessTimeout = []int{port}, time.Second
+	if !tcpAlive("127.0.0.1") {
+		t.Fatal("Expected an accepted connection to count as alive")
+	}
+	l.Close()
+	if !tcpAlive("127.0.0.1") {
+		t.Fatal("Expected a refused connection to count as alive")
+	}
+}
+
+func TestDeltaAddresses(t *testing.T) {
+	defer func(since time.Time, id int) { deltaSince, deltaAfterID = since, id }(deltaSince, deltaAfterID)
+	deltaSince = time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	deltaAfterID = 10
+
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.2"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.3"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.4"}, SubnetCIDR: "10.0.0.0/24"},
+	}
+	key := func(ip string) legacydb.AddressKey {
+		return legacydb.AddressKey{IPAddress: ip, SubnetCIDR: "10.0.0.0/24"}
+	}
+	edited := map[legacydb.AddressKey]string{
+		key("10.0.0.1"): "2024-05-02 08:00:00",
+		key("10.0.0.2"): "2024-04-30 08:00:00",
+	}
+	ids := map[legacydb.AddressKey]string{key("10.0.0.1"): "1", key("10.0.0.2"): "2", key("10.0.0.3"): "11", key("10.0.0.4"): "4"}
+
+	var actual []string
+	for _, v := range deltaAddresses(addrs, edited, ids) {
+		actual = append(actual, v.IPAddress)
+	}
+	if expected := []string{"10.0.0.1", "10.0.0.3"}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected addresses %v, got %v", expected, actual)
+	}
+
+	changes := []legacydb.Change{{}, {}}
+	changes[0].Date, changes[1].Date = "2024-05-01 12:00:01", "2024-05-01 11:59:59"
+	if out := deltaChanges(changes); len(out) != 1 || out[0].Date != "2024-05-01 12:00:01" {
+		t.Fatalf("Expected only the later changelog entry, got %#v", out)
+	}
+}
+
+func TestCutoverReport(t *testing.T) {
+	defer func(since time.Time, id int) { deltaSince, deltaAfterID = since, id }(deltaSince, deltaAfterID)
+	deltaSince = time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	deltaAfterID = 10
+
+	snap := func(rows int64) *state.Snapshot {
+		return &state.Snapshot{Tables: map[string]state.TableState{"ipaddresses": {Rows: rows, Checksum: rows}}}
+	}
+	bulk := &state.State{LastSync: snap(30), Freeze: snap(31)}
+	s := newSectionRun(helper.SectionMapping{ID: 2})
+	s.AddressesAdded = 1
+
+	report, ready := cutoverReport(bulk, snap(31), []*sectionRun{s}, time.Now())
+	if !ready {
+		t.Fatalf("Expected cutover to be ready, got report:\n%s", report)
+	}
+	for _, v := range []string{
+		"- Delta migrated: addresses and changelog entries edited since 2024-05-01 12:00:00, and addresses with legacy IDs above 10\n",
+		"- section 2: 0 subnets and 1 addresses added, 0 errors\n",
+		"Since the last sync:\n\n- ipaddresses: row count changed from 30 to 31\n",
+		"During the final pass:\n\n- None\n",
+		"Ready for cutover",
+	} {
+		if !strings.Contains(report, v) {
+			t.Fatalf("Expected report to contain %q, got:\n%s", v, report)
+		}
+	}
+
+	report, ready = cutoverReport(bulk, snap(32), []*sectionRun{s}, time.Now())
+	if ready || !strings.Contains(report, "During the final pass:\n\n- ipaddresses: row count changed from 31 to 32\n") {
+		t.Fatalf("Expected cutover not to be ready after writes during the final pass, got report:\n%s", report)
+	}
+}
+
+func TestPlaceOrphanAddresses(t *testing.T) {
+	defer func(cidr string, orphans []legacydb.Skip, id int) {
+		orphansSubnet, orphanAddresses, sectionID = cidr, orphans, id
+	}(orphansSubnet, orphanAddresses, sectionID)
+	orphansSubnet, sectionID = "10.255.0.0/16", 2
+	orphanAddresses = []legacydb.Skip{
+		{Kind: "address", Address: "184483841", Description: "lost", Subnet: "ID 9", Reason: "subnet ID 9 does not exist"},
+		{Kind: "address", Address: "3232235777", Reason: "address has no subnet"},
+	}
+
+	s := newSectionRun(helper.SectionMapping{ID: 2})
+	if !s.placesOrphans() || newSectionRun(helper.SectionMapping{ID: 3}).placesOrphans() {
+		t.Fatal("Expected the orphans to be placed in the first section only")
+	}
+	if v := s.orphansSubnetFor(); v.SubnetAddress != "10.255.0.0" || v.Mask != 16 || v.SectionID != 2 {
+		t.Fatalf("Unexpected orphans subnet %#v", v)
+	}
+	actual := s.placeOrphanAddresses()
+	if len(actual) != 1 || actual[0].IPAddress != "10.255.0.1" || actual[0].SubnetCIDR != "10.255.0.0/16" || actual[0].Description != "lost" {
+		t.Fatalf("Unexpected orphan addresses %#v", actual)
+	}
+	if expected := []string{"placed in subnet 10.255.0.0/16, as subnet ID 9 does not exist"}; !reflect.DeepEqual(expected, actual[0].Changes) {
+		t.Fatalf("Expected changes %#v, got %#v", expected, actual[0].Changes)
+	}
+}
+
+func TestTagAddresses(t *testing.T) {
+	defer func(m map[string]int) { addressStates = m }(addressStates)
+	addressStates = map[string]int{"0": helper.TagOffline, "2": helper.TagReserved}
+
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.2"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.3"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.4"}, SubnetCIDR: "10.0.0.0/24"},
+	}
+	states := map[legacydb.AddressKey]string{
+		{IPAddress: "10.0.0.1", SubnetCIDR: "10.0.0.0/24"}: "2",
+		{IPAddress: "10.0.0.2", SubnetCIDR: "10.0.0.0/24"}: "0",
+		{IPAddress: "10.0.0.3", SubnetCIDR: "10.0.0.0/24"}: "9",
+	}
+	newSectionRun(helper.SectionMapping{ID: 1}).tagAddresses(addrs, states)
+	var tags []int
+	for _, v := range addrs {
+		tags = append(tags, v.Tag)
+	}
+	if expected := []int{helper.TagReserved, helper.TagOffline, 0, 0}; !reflect.DeepEqual(expected, tags) {
+		t.Fatalf("Expected tags %v, got %v", expected, tags)
+	}
+}
+
+func TestAddAddresses(t *testing.T) {
+	defer func(n, budget int) { addressWorkers, sectionErrorBudget = n, budget }(addressWorkers, sectionErrorBudget)
+	addressWorkers = 3
+	sectionErrorBudget = 1
+
+	m := &mockIPAM{fail: map[string]bool{"10.0.1.2": true}}
+	var addrs []legacydb.Address
+	var expected []string
+	for subnet := 0; subnet < 3; subnet++ {
+		for host := 1; host <= 3; host++ {
+			ip := fmt.Sprintf("10.0.%d.%d", subnet, host)
+			addrs = append(addrs, legacydb.Address{Address: addresses.Address{IPAddress: ip, SubnetID: subnet + 1}})
+			if !m.fail[ip] {
+				expected = append(expected, ip)
+			}
+		}
+	}
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	if err := s.addAddresses(m, addrs); err != nil {
+		t.Fatalf("Error adding addresses: %s", err)
+	}
+	sort.Strings(m.created)
+	if !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+	if s.AddressesAdded != len(expected) || len(s.Errors) != 1 {
+		t.Fatalf("Expected %d addresses added and 1 error, got %d and %d", len(expected), s.AddressesAdded, len(s.Errors))
+	}
+}
+
+func TestValidateVLANs(t *testing.T) {
+	defer func(lans []legacydb.VLAN) { legacyVLANs = lans }(legacyVLANs)
+	legacyVLANs = []legacydb.VLAN{
+		{VLAN: vlans.VLAN{Number: 0, Name: "none"}},
+		{VLAN: vlans.VLAN{Number: 1, Name: "default"}},
+		{VLAN: vlans.VLAN{Number: 4094, Name: "last"}},
+		{VLAN: vlans.VLAN{Number: 4095, Name: "reserved"}},
+		{VLAN: vlans.VLAN{Number: 99999, Name: "garbage"}},
+	}
+	if err := validateVLANs(); err != nil {
+		t.Fatalf("Error validating VLANs: %s", err)
+	}
+	var names []string
+	for _, v := range legacyVLANs {
+		names = append(names, v.Name)
+	}
+	if expected := []string{"default", "last"}; !reflect.DeepEqual(expected, names) {
+		t.Fatalf("Expected VLANs %v, got %v", expected, names)
+	}
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	s.subnets = []legacydb.Subnet{{VLANNumber: 99999}}
+	if err := s.resolveSubnets(); err != nil || s.subnets[0].VLANNumber != 0 {
+		t.Fatalf("Expected the invalid VLAN to be cleared, got %#v (%v)", s.subnets, err)
+	}
+}
+
+func TestResolveSubnetsMissingVLANs(t *testing.T) {
+	defer func(v string, lans []legacydb.VLAN) { missingVLANs, fetchedVLANs = v, lans }(missingVLANs, fetchedVLANs)
+	m := &mockIPAM{}
+	ids := map[string]int{"100": 7}
+	vlanIDCache = cache.New(0, func(key string) (int, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if id, ok := ids[key]; ok {
+			return id, nil
+		}
+		for _, v := range m.created {
+			if v == key {
+				return 8, nil
+			}
+		}
+		return 0, fmt.Errorf("VLAN number %s not found", key)
+	})
+	fetchedVLANs = []legacydb.VLAN{{VLAN: vlans.VLAN{Number: 200, Name: "lab"}}}
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	s.subnets = []legacydb.Subnet{{VLANNumber: 100}, {VLANNumber: 200}}
+	missingVLANs = "error"
+	if err := s.resolveSubnets(); err == nil {
+		t.Fatal("Expected error resolving subnet with missing VLAN, got none")
+	}
+
+	missingVLANs = "clear"
+	if err := s.resolveSubnets(); err != nil {
+		t.Fatalf("Error resolving subnets with -missing-vlans clear: %s", err)
+	}
+	if s.subnets[0].VLANID != 7 || s.subnets[1].VLANID != 0 || s.subnets[1].VLANNumber != 0 {
+		t.Fatalf("Expected the missing VLAN to be cleared, got %#v", s.subnets)
+	}
+
+	for i := 0; i < 2; i++ {
+		id, err := createMissingVLAN(m, 200)
+		if err != nil {
+			t.Fatalf("Error creating missing VLAN: %s", err)
+		}
+		if id != 8 {
+			t.Fatalf("Expected VLAN ID 8, got %d", id)
+		}
+	}
+	if expected := []string{"200"}; !reflect.DeepEqual(expected, m.created) {
+		t.Fatalf("Expected %#v to be created, got %#v", expected, m.created)
+	}
+}
+
+func TestResolveAddresses(t *testing.T) {
+	m := &mockIPAM{subnets: map[string]int{"10.0.0.0/24": 5}}
+	subnetIDCache = cache.New(0, func(key string) (int, error) { return lookupSubnetID(m, key) })
+
+	s := newSectionRun(helper.SectionMapping{ID: 1})
+	s.addresses = []legacydb.Address{{Address: addresses.Address{IPAddress: "10.0.0.1"}, SubnetCIDR: "10.0.0.0/24"}}
+	if err := s.resolveAddresses(m); err != nil {
+		t.Fatalf("Error resolving addresses: %s", err)
+	}
+	if s.addresses[0].SubnetID != 5 {
+		t.Fatalf("Expected subnet ID 5, got %d", s.addresses[0].SubnetID)
+	}
+
+	s.addresses = []legacydb.Address{{Address: addresses.Address{IPAddress: "10.9.0.1"}, SubnetCIDR: "10.9.0.0/24"}}
+	if err := s.resolveAddresses(m); err == nil {
+		t.Fatal("Expected error resolving address in missing subnet, got none")
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	for in, expected := range map[string]string{
+		":8090":          "127.0.0.1:8090",
+		"0.0.0.0:8090":   "127.0.0.1:8090",
+		"[::]:8090":      "127.0.0.1:8090",
+		"10.0.0.1:8090":  "10.0.0.1:8090",
+		"127.0.0.1:8090": "127.0.0.1:8090",
+	} {
+		addr, err := net.ResolveTCPAddr("tcp", in)
+		if err != nil {
+			t.Fatalf("Error resolving %s: %s", in, err)
+		}
+		if actual := loopbackAddr(addr); actual != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, in, actual)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for in, expected := range map[string]bool{
+		":8090":          false,
+		"0.0.0.0:8090":   false,
+		"[::]:8090":      false,
+		"10.0.0.1:8090":  false,
+		"127.0.0.1:8090": true,
+		"[::1]:8090":     true,
+	} {
+		addr, err := net.ResolveTCPAddr("tcp", in)
+		if err != nil {
+			t.Fatalf("Error resolving %s: %s", in, err)
+		}
+		if actual := isLoopback(addr); actual != expected {
+			t.Errorf("Expected %t for %s, got %t", expected, in, actual)
+		}
+	}
+}
+
+func TestServeOptions(t *testing.T) {
+	allowed := make(map[string]bool)
+	for _, v := range serveOptions {
+		if flag.Lookup(v) == nil {
+			t.Errorf("Option %q allowed through the API is not defined", v)
+		}
+		allowed[v] = true
+	}
+	for k := range serveSecrets {
+		if !allowed[k] {
+			t.Errorf("Secret %q is not allowed through the API", k)
+		}
+	}
+}
+
+func TestSetupDBTLSTunnel(t *testing.T) {
+	defer func(tlsMode, ssh, host string) { dbTLS, sshHost, dbHost = tlsMode, ssh, host }(dbTLS, sshHost, dbHost)
+	dbHost = "db.example.com"
+
+	// Without a tunnel, the driver verifies the server certificate against
+	// the DB host by itself.
+	dbTLS, sshHost = "true", ""
+	setupDBTLS()
+	if dbTLS != "true" {
+		t.Fatalf("Expected the driver's TLS config, got %q", dbTLS)
+	}
+	dbTLS, sshHost = "true", "bastion.example.com"
+	setupDBTLS()
+	if dbTLS != dbTLSConfigName {
+		t.Fatalf("Expected a custom TLS config through a tunnel, got %q", dbTLS)
+	}
+	if actual := tunnelDBHost(); actual != "db.example.com" {
+		t.Fatalf("Expected the certificate to be verified against db.example.com, got %s", actual)
+	}
+	dbTLS = "skip-verify"
+	setupDBTLS()
+	if dbTLS != "skip-verify" {
+		t.Fatalf("Expected no custom TLS config when not verifying, got %q", dbTLS)
+	}
+}
+
+func TestLegacyDSNCharset(t *testing.T) {
+	defer func(dsn, charset string) { dbDSN, sourceCharset = dsn, charset }(dbDSN, sourceCharset)
+	dbDSN = "phpipam:secret@tcp(db:3306)/phpipam?charset=utf8"
+
+	sourceCharset = ""
+	if actual := legacyDSN(); actual != dbDSN {
+		t.Fatalf("Expected -dsn to be used as is, got %s", actual)
+	}
+	sourceCharset = "latin1"
+	if expected, actual := "phpipam:secret@tcp(db:3306)/phpipam?charset=latin1", legacyDSN(); actual != expected {
+		t.Fatalf("Expected %s, got %s", expected, actual)
+	}
+}
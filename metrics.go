@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// The migration's metrics, served on -metrics-addr if supplied.
+var (
+	// metricsRegistry holds all of the metrics.
+	metricsRegistry = metrics.NewRegistry()
+
+	// recordsTotal counts the records processed, by entity and result
+	// (migrated, error, or skipped).
+	recordsTotal = metricsRegistry.NewCounterVec("phpipam_migrator_records_total", "Records processed by the migration, by entity and result.", "entity", "result")
+
+	// queueDepth is the number of addresses waiting to be added in each
+	// section.
+	queueDepth = metricsRegistry.NewGaugeVec("phpipam_migrator_queue_depth", "Addresses waiting to be added, by section.", "section")
+
+	// apiDuration records the latency of PHPIPAM API requests.
+	apiDuration = metricsRegistry.NewHistogramVec("phpipam_migrator_api_request_duration_seconds", "Latency of PHPIPAM API requests, by method and response code.", metrics.DefaultBuckets, "method", "code")
+)
+
+// startMetricsServer starts serving the metrics on /metrics at metricsAddr,
+// in the background.
+func startMetricsServer() {
+	l, err := net.Listen("tcp", metricsAddr)
+	if err != nil {
+		logrus.Fatalf("Error starting metrics listener: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsRegistry.Handler())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			logrus.Errorf("Error serving metrics: %s", err)
+		}
+	}()
+	logrus.Infof("Serving metrics on http://%s/metrics", l.Addr())
+}
@@ -0,0 +1,312 @@
+// Package metrics provides a minimal set of Prometheus metric types, and a
+// handler serving them in the Prometheus text exposition format, so that long
+// running migrations can be monitored.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is implemented by all of the metric types.
+type metric interface {
+	// write writes the metric in the text exposition format.
+	write(w io.Writer)
+}
+
+// Registry is a collection of metrics to be served.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds m to the registry.
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all of the registered metrics to w in the text exposition
+// format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b bytes.Buffer
+	for _, m := range r.metrics {
+		m.write(&b)
+	}
+	return b.WriteTo(w)
+}
+
+// Handler returns a http.Handler serving the registered metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+// vec holds the values of a metric, keyed by label values.
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// newVec returns a new vec.
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+// add adds v to the value for the supplied label values.
+func (v *vec) add(n float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	v.values[key] += n
+	v.mu.Unlock()
+}
+
+// set sets the value for the supplied label values.
+func (v *vec) set(n float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	v.values[key] = n
+	v.mu.Unlock()
+}
+
+// get returns the value for the supplied label values.
+func (v *vec) get(labelValues []string) float64 {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[key]
+}
+
+// key renders label values as the label set in the exposition format, which
+// is also used as the key for the values.
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labels), len(labelValues)))
+	}
+	return labelSet(v.labels, labelValues)
+}
+
+// write implements metric for vec.
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.typ)
+	for _, k := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, k, formatFloat(v.values[k]))
+	}
+}
+
+// CounterVec is a counter, partitioned by labels.
+type CounterVec struct {
+	v *vec
+}
+
+// NewCounterVec creates a CounterVec and registers it with r.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", labels)}
+	r.register(c.v)
+	return c
+}
+
+// Inc increments the counter for the supplied label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.v.add(1, labelValues)
+}
+
+// Add adds n, which must not be negative, to the counter for the supplied
+// label values.
+func (c *CounterVec) Add(n float64, labelValues ...string) {
+	c.v.add(n, labelValues)
+}
+
+// Value returns the value of the counter for the supplied label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.v.get(labelValues)
+}
+
+// GaugeVec is a gauge, partitioned by labels.
+type GaugeVec struct {
+	v *vec
+}
+
+// NewGaugeVec creates a GaugeVec and registers it with r.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec(name, help, "gauge", labels)}
+	r.register(g.v)
+	return g
+}
+
+// Set sets the gauge for the supplied label values.
+func (g *GaugeVec) Set(n float64, labelValues ...string) {
+	g.v.set(n, labelValues)
+}
+
+// Add adds n to the gauge for the supplied label values.
+func (g *GaugeVec) Add(n float64, labelValues ...string) {
+	g.v.add(n, labelValues)
+}
+
+// Value returns the value of the gauge for the supplied label values.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.v.get(labelValues)
+}
+
+// HistogramVec is a histogram, partitioned by labels.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram holds the observations of one series of a HistogramVec.
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// DefaultBuckets are the default histogram buckets, in seconds, suitable for
+// API request latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogramVec creates a HistogramVec with the supplied bucket upper
+// bounds, in increasing order, and registers it with r.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records an observation for the supplied label values.
+func (h *HistogramVec) Observe(n float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", h.name, len(h.labels), len(labelValues)))
+	}
+	key := labelSet(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if n <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += n
+	s.count++
+}
+
+// ObserveDuration records d, in seconds, for the supplied label values.
+func (h *HistogramVec) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+// Totals returns the sum and number of the observations in all series.
+func (h *HistogramVec) Totals() (sum float64, count uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.series {
+		sum += s.sum
+		count += s.count
+	}
+	return
+}
+
+// write implements metric for HistogramVec.
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		bucketLabels := append(append([]string{}, h.labels...), "le")
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelSet(bucketLabels, append(append([]string{}, s.labelValues...), formatFloat(b))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelSet(bucketLabels, append(append([]string{}, s.labelValues...), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, k, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, k, s.count)
+	}
+}
+
+// labelEscaper escapes label values as required by the exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelSet renders labels and their values in the exposition format (ie:
+// {entity="vlans"}). A blank string is returned if there are no labels.
+func labelSet(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = fmt.Sprintf("%s=\"%s\"", l, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formatFloat formats a value in the exposition format.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
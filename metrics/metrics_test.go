@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("records_total", "Records processed.", "entity", "result")
+	g := r.NewGaugeVec("queue_depth", "Records queued.", "section")
+	h := r.NewHistogramVec("latency_seconds", "Request latency.", []float64{0.1, 1}, "method")
+
+	c.Inc("vlans", "migrated")
+	c.Inc("vlans", "migrated")
+	c.Inc("addresses", "error")
+	g.Set(10, "1")
+	g.Add(-3, "1")
+	h.Observe(0.05, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(5, "GET")
+
+	expected := `# HELP records_total Records processed.
+# TYPE records_total counter
+records_total{entity="addresses",result="error"} 1
+records_total{entity="vlans",result="migrated"} 2
+# HELP queue_depth Records queued.
+# TYPE queue_depth gauge
+queue_depth{section="1"} 7
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GET",le="0.1"} 1
+latency_seconds_bucket{method="GET",le="1"} 2
+latency_seconds_bucket{method="GET",le="+Inf"} 3
+latency_seconds_sum{method="GET"} 5.55
+latency_seconds_count{method="GET"} 3
+`
+	var b bytes.Buffer
+	r.WriteTo(&b)
+	if actual := b.String(); expected != actual {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	if v := c.Value("vlans", "migrated"); v != 2 {
+		t.Fatalf("Expected counter value 2, got %v", v)
+	}
+	h.Observe(0.45, "POST")
+	if sum, count := h.Totals(); sum != 6 || count != 4 {
+		t.Fatalf("Expected histogram totals 6 and 4, got %v and %d", sum, count)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	h := r.NewHistogramVec("latency_seconds", "Request latency.", DefaultBuckets, "method", "code")
+	c := &http.Client{Transport: &Transport{Histogram: h}}
+	if _, err := c.Get(ts.URL); err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Error fetching metrics: %s", err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(b), `latency_seconds_count{method="GET",code="418"} 1`) {
+		t.Fatalf("Expected request to be recorded, got:\n%s", b)
+	}
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Transport is a http.RoundTripper that records the latency of each request
+// in a histogram, labelled by method and response code. Requests that fail
+// without a response are recorded with a code of "error".
+type Transport struct {
+	// The underlying transport. http.DefaultTransport is used if this is nil.
+	Transport http.RoundTripper
+
+	// The histogram to record latencies in. It must have method and code
+	// labels, in that order.
+	Histogram *HistogramVec
+}
+
+// RoundTrip implements http.RoundTripper for Transport.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := rt.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.Histogram.ObserveDuration(time.Since(start), req.Method, code)
+	return resp, err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// migrateNameservers enables nameserver set migration. The legacy
+	// nameserver sets are created in the new PHPIPAM instance, and subnets are
+	// linked to them. This needs a sink that implements
+	// ipamsink.NameserverCreator.
+	migrateNameservers bool
+
+	// nameserverIDs maps the legacy nameserver set names to the IDs of the
+	// nameserver sets in the new PHPIPAM instance.
+	nameserverIDs = make(map[string]int)
+)
+
+// fetchNameservers gets the nameserver sets from the legacy DB.
+func fetchNameservers(conn *sql.DB) ([]nameservers.Nameserver, error) {
+	stageLog.Info("Fetching nameserver sets from legacy DB")
+
+	out, err := (&legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}).Nameservers()
+	if err != nil {
+		return nil, err
+	}
+	stageLog.Infof("Found %d nameserver sets to migrate", len(out))
+	return out, nil
+}
+
+// addNameservers adds the legacy nameserver sets that are not in the new
+// PHPIPAM instance with c, making them available in the migrated sections.
+func addNameservers(c ipamsink.NameserverCreator, in []nameservers.Nameserver) error {
+	existing, err := c.Nameservers()
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool)
+	for _, v := range existing {
+		found[v.Name] = true
+	}
+
+	stageLog.Info("Adding nameserver sets.")
+
+	tracker := progressDisplay.Track("nameservers", len(in))
+	defer tracker.Finish()
+
+	for _, v := range in {
+		tracker.Add(1)
+		log := stageLog.WithField("nameserver", v.Name)
+		if found[v.Name] {
+			recordsTotal.Inc("nameservers", "skipped")
+			log.Infof("Nameserver set %s already exists in new PHPIPAM database, skipping", v.Name)
+			continue
+		}
+		n := v
+		n.Permissions = migratedSections()
+		if err := c.CreateNameserver(n); err != nil {
+			return err
+		}
+		found[v.Name] = true
+		recordsTotal.Inc("nameservers", "migrated")
+		log.Infof("Nameserver set %s added successfully", v.Name)
+	}
+	return nil
+}
+
+// preloadNameserverIDs lists all of the nameserver sets in the new PHPIPAM
+// instance, and adds their IDs to nameserverIDs, so that subnets can be linked
+// to them.
+func preloadNameserverIDs() {
+	logrus.Info("Preloading nameserver set IDs from new PHPIPAM database")
+
+	c, ok := sink.(ipamsink.NameserverCreator)
+	if !ok {
+		logrus.Fatalf("Nameserver sets cannot be read from %T", sink)
+	}
+	found, err := c.Nameservers()
+	if err != nil {
+		logrus.Fatalf("Error preloading nameserver set IDs: %s", err)
+	}
+	for _, v := range found {
+		nameserverIDs[v.Name] = v.ID
+	}
+	logrus.Infof("Preloaded %d nameserver set IDs", len(found))
+}
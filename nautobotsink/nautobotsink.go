@@ -0,0 +1,505 @@
+// Package nautobotsink writes migrated objects into a Nautobot instance via
+// its REST API, in place of a new PHPIPAM instance, in the same way as
+// netboxsink does for NetBox.
+//
+// Unlike NetBox, Nautobot requires a status on every object, which is a
+// reference to a status object that must be enabled for the object's type,
+// and places every prefix and IP address in a namespace. The status and
+// namespace are supplied by name, and looked up (and the status checked) once,
+// before the first object is written. Prefixes are nested by Nautobot, and IP
+// addresses are placed under the narrowest prefix in the namespace that
+// contains them.
+//
+// Nautobot identifies objects by UUID, so the IDs that the Sink returns are
+// handles, which it maps back to the UUIDs when objects are referred to.
+// Address notes are written as Nautobot notes on the address, as IP addresses
+// have no comments field. VRFs are created in the namespace, and prefixes are
+// assigned to their VRF once created, as Nautobot prefixes can belong to more
+// than one VRF.
+package nautobotsink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/rest"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// The defaults for the status and namespace of migrated objects, which exist
+// on a new Nautobot instance.
+const (
+	DefaultStatus    = "Active"
+	DefaultNamespace = "Global"
+)
+
+// contentTypes are the content types of the objects written, which the status
+// must be enabled for.
+var contentTypes = []string{"ipam.vlan", "ipam.prefix", "ipam.ipaddress"}
+
+// Sink writes objects into a Nautobot instance.
+type Sink struct {
+	// The client for the Nautobot API.
+	API *rest.Client
+
+	// The name of the status that objects are created with.
+	Status string
+
+	// The name of the namespace that prefixes and IP addresses are created in.
+	Namespace string
+
+	mu sync.Mutex
+
+	// The UUIDs of the status and namespace, once looked up.
+	statusID    string
+	namespaceID string
+
+	// The UUIDs of the handles returned, indexed by handle - 1, and the
+	// handles of the UUIDs.
+	uuids   []string
+	handles map[string]int
+
+	// The prefixes of the prefix handles returned, so that addresses can be
+	// given the prefix length of their subnet.
+	prefixes map[int]string
+}
+
+// New returns a new Sink for the Nautobot instance at baseURL, authenticating
+// with token, and retrying API calls as per policy. Objects are created with
+// the status named status, and prefixes and IP addresses in the namespace
+// named namespace.
+func New(baseURL, token, status, namespace string, policy retry.Policy) *Sink {
+	return &Sink{
+		API:       rest.New(baseURL, token, policy),
+		Status:    status,
+		Namespace: namespace,
+		handles:   make(map[string]int),
+		prefixes:  make(map[int]string),
+	}
+}
+
+// object is the part of a Nautobot object that the Sink reads back.
+type object struct {
+	ID           string   `json:"id"`
+	VID          int      `json:"vid"`
+	Name         string   `json:"name"`
+	RD           string   `json:"rd"`
+	Prefix       string   `json:"prefix"`
+	Address      string   `json:"address"`
+	DNSName      string   `json:"dns_name"`
+	Description  string   `json:"description"`
+	Note         string   `json:"note"`
+	ContentTypes []string `json:"content_types"`
+}
+
+// list GETs all of the objects at path matching query. op describes the
+// operation in log messages.
+func (s *Sink) list(op, path string, query url.Values) ([]object, error) {
+	var out []object
+	err := s.API.List(op, path, query, func(raw json.RawMessage) error {
+		var v object
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		out = append(out, v)
+		return nil
+	})
+	return out, err
+}
+
+// handle returns the handle of a UUID, recording a new one if it has not been
+// seen yet. The caller must hold the lock.
+func (s *Sink) handle(uuid string) int {
+	if h, ok := s.handles[uuid]; ok {
+		return h
+	}
+	s.uuids = append(s.uuids, uuid)
+	s.handles[uuid] = len(s.uuids)
+	return len(s.uuids)
+}
+
+// uuid returns the UUID of a handle. kind describes the object in errors.
+func (s *Sink) uuid(kind string, h int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h < 1 || h > len(s.uuids) {
+		return "", fmt.Errorf("unknown %s ID %d", kind, h)
+	}
+	return s.uuids[h-1], nil
+}
+
+// references returns the UUIDs of the status and namespace, looking them up
+// and checking that the status can be used for all of the objects written if
+// this has not been done yet.
+func (s *Sink) references() (status, namespace string, err error) {
+	s.mu.Lock()
+	status, namespace = s.statusID, s.namespaceID
+	s.mu.Unlock()
+	if status != "" && namespace != "" {
+		return status, namespace, nil
+	}
+
+	found, err := s.list(fmt.Sprintf("looking up status %s", s.Status), "/api/extras/statuses/", url.Values{"name": {s.Status}})
+	if err != nil {
+		return "", "", fmt.Errorf("error looking up status %s: %s", s.Status, err)
+	}
+	if len(found) == 0 {
+		return "", "", fmt.Errorf("status %s not found", s.Status)
+	}
+	enabled := make(map[string]bool)
+	for _, v := range found[0].ContentTypes {
+		enabled[v] = true
+	}
+	for _, v := range contentTypes {
+		if !enabled[v] {
+			return "", "", fmt.Errorf("status %s is not enabled for %s objects", s.Status, v)
+		}
+	}
+	status = found[0].ID
+
+	found, err = s.list(fmt.Sprintf("looking up namespace %s", s.Namespace), "/api/ipam/namespaces/", url.Values{"name": {s.Namespace}})
+	if err != nil {
+		return "", "", fmt.Errorf("error looking up namespace %s: %s", s.Namespace, err)
+	}
+	if len(found) == 0 {
+		return "", "", fmt.Errorf("namespace %s not found", s.Namespace)
+	}
+	namespace = found[0].ID
+
+	s.mu.Lock()
+	s.statusID, s.namespaceID = status, namespace
+	s.mu.Unlock()
+	return status, namespace, nil
+}
+
+// create POSTs in, with the status, the namespace if namespaced is true, and
+// the supplied custom fields, to the list at path, and returns the created
+// object. query selects the object in the list (in the namespace, if
+// namespaced is true), to check whether it was created before retrying. op
+// describes the operation in log and error messages.
+func (s *Sink) create(op, path string, in map[string]interface{}, namespaced bool, fields map[string]string, query url.Values) (object, error) {
+	status, namespace, err := s.references()
+	if err != nil {
+		return object{}, fmt.Errorf("error %s: %s", op, err)
+	}
+	in["status"] = status
+	if namespaced {
+		in["namespace"] = namespace
+		query.Set("namespace", namespace)
+	}
+	if len(fields) > 0 {
+		in["custom_fields"] = fields
+	}
+	var out object
+	if err := s.API.Create(op, path, in, query, &out); err != nil {
+		return object{}, fmt.Errorf("error %s: %s", op, err)
+	}
+	return out, nil
+}
+
+// setString sets key in m to v, unless v is blank.
+func setString(m map[string]interface{}, key, v string) {
+	if v != "" {
+		m[key] = v
+	}
+}
+
+// CreateVLAN creates a VLAN, setting the supplied custom fields, if any.
+func (s *Sink) CreateVLAN(v vlans.VLAN, fields map[string]string) error {
+	in := map[string]interface{}{"vid": v.Number, "name": v.Name}
+	setString(in, "description", v.Description)
+	query := url.Values{"vid": {strconv.Itoa(v.Number)}, "name": {v.Name}}
+	_, err := s.create(fmt.Sprintf("adding VLAN number %d", v.Number), "/api/ipam/vlans/", in, false, fields, query)
+	return err
+}
+
+// CreateSubnet creates a subnet as a prefix in the namespace, setting the
+// supplied custom fields, if any, and assigns it to its VRF, if any. Its VLAN
+// and VRF IDs must be handles returned by the Sink.
+func (s *Sink) CreateSubnet(v subnets.Subnet, fields map[string]string) error {
+	cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+	op := fmt.Sprintf("creating subnet %s", cidr)
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return fmt.Errorf("error %s: %s", op, err)
+	}
+	in := map[string]interface{}{"prefix": cidr, "type": "network"}
+	setString(in, "description", v.Description)
+	if v.VLANID != 0 {
+		vlan, err := s.uuid("VLAN", v.VLANID)
+		if err != nil {
+			return fmt.Errorf("error %s: %s", op, err)
+		}
+		in["vlan"] = vlan
+	}
+	var vrf string
+	if v.VRFID != 0 {
+		var err error
+		if vrf, err = s.uuid("VRF", v.VRFID); err != nil {
+			return fmt.Errorf("error %s: %s", op, err)
+		}
+	}
+	created, err := s.create(op, "/api/ipam/prefixes/", in, true, fields, url.Values{"prefix": {cidr}})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.prefixes[s.handle(created.ID)] = cidr
+	s.mu.Unlock()
+	if vrf == "" {
+		return nil
+	}
+	assignment := map[string]string{"vrf": vrf, "prefix": created.ID}
+	if err := s.API.Create(op, "/api/ipam/vrf-prefix-assignments/", assignment, url.Values{"vrf": {vrf}, "prefix": {created.ID}}, nil); err != nil {
+		return fmt.Errorf("error assigning subnet %s to its VRF: %s", cidr, err)
+	}
+	return nil
+}
+
+// CreateVRF creates a VRF in the namespace. VRFs have no status.
+func (s *Sink) CreateVRF(v vrfs.VRF) error {
+	op := fmt.Sprintf("adding VRF %s", v.Name)
+	_, namespace, err := s.references()
+	if err != nil {
+		return fmt.Errorf("error %s: %s", op, err)
+	}
+	in := map[string]interface{}{"name": v.Name, "namespace": namespace}
+	setString(in, "rd", v.RD)
+	setString(in, "description", v.Description)
+	if err := s.API.Create(op, "/api/ipam/vrfs/", in, url.Values{"name": {v.Name}, "namespace": {namespace}}, nil); err != nil {
+		return fmt.Errorf("error %s: %s", op, err)
+	}
+	return nil
+}
+
+// VRFs lists all of the VRFs in the namespace, with handles for IDs.
+func (s *Sink) VRFs() ([]vrfs.VRF, error) {
+	_, namespace, err := s.references()
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.list("listing VRFs", "/api/ipam/vrfs/", url.Values{"namespace": {namespace}, "limit": {"1000"}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing VRFs: %s", err)
+	}
+	var out []vrfs.VRF
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range found {
+		out = append(out, vrfs.VRF{ID: s.handle(v.ID), Name: v.Name, RD: v.RD, Description: v.Description})
+	}
+	return out, nil
+}
+
+// CreateDevice returns an error, as Nautobot devices need a device type,
+// role, location, and status, which legacy PHPIPAM has no equivalent of.
+func (s *Sink) CreateDevice(d devices.Device) error {
+	return fmt.Errorf("error adding device %s: devices cannot be migrated to Nautobot", d.Hostname)
+}
+
+// Devices returns no devices.
+func (s *Sink) Devices() ([]devices.Device, error) {
+	return nil, nil
+}
+
+// CreateDeviceType returns an error, as devices cannot be migrated to Nautobot.
+func (s *Sink) CreateDeviceType(t devices.DeviceType) error {
+	return fmt.Errorf("error adding device type %s: devices cannot be migrated to Nautobot", t.Name)
+}
+
+// DeviceTypes returns no device types.
+func (s *Sink) DeviceTypes() ([]devices.DeviceType, error) {
+	return nil, nil
+}
+
+// CreateAddress creates an IP address in the namespace, with the prefix
+// length of its subnet, setting the supplied custom fields, if any, and adds
+// its note as a Nautobot note. Its subnet ID must be a handle returned by the
+// Sink.
+func (s *Sink) CreateAddress(a addresses.Address, fields map[string]string) error {
+	op := fmt.Sprintf("adding IP address %s", a.IPAddress)
+	s.mu.Lock()
+	prefix, ok := s.prefixes[a.SubnetID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("error %s: unknown subnet ID %d", op, a.SubnetID)
+	}
+	in := map[string]interface{}{"address": a.IPAddress + prefix[strings.IndexByte(prefix, '/'):]}
+	setString(in, "dns_name", a.Hostname)
+	setString(in, "description", a.Description)
+	created, err := s.create(op, "/api/ipam/ip-addresses/", in, true, fields, url.Values{"address": {a.IPAddress}})
+	if err != nil || a.Note == "" {
+		return err
+	}
+	// The address was just created, so any note on it was added here.
+	path := fmt.Sprintf("/api/ipam/ip-addresses/%s/notes/", created.ID)
+	if err := s.API.Create(op, path, map[string]string{"note": a.Note}, nil, nil); err != nil {
+		return fmt.Errorf("error adding note to IP address %s: %s", a.IPAddress, err)
+	}
+	return nil
+}
+
+// VLANID returns a handle for the VLAN with number n. If the number is used
+// by more than one VLAN, the first one listed is used.
+func (s *Sink) VLANID(n int) (int, error) {
+	found, err := s.list(fmt.Sprintf("looking up VLAN number %d", n), "/api/ipam/vlans/", url.Values{"vid": {strconv.Itoa(n)}})
+	if err != nil {
+		return 0, err
+	}
+	if len(found) == 0 {
+		return 0, fmt.Errorf("VLAN number %d not found", n)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handle(found[0].ID), nil
+}
+
+// VLANIDs lists all of the VLANs once, and returns a map of VLAN numbers to
+// handles, choosing between VLANs with the same number as VLANID does.
+func (s *Sink) VLANIDs() (map[int]int, error) {
+	found, err := s.list("listing VLANs", "/api/ipam/vlans/", url.Values{"limit": {"1000"}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing VLANs: %s", err)
+	}
+	out := make(map[int]int)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range found {
+		if _, ok := out[v.VID]; !ok {
+			out[v.VID] = s.handle(v.ID)
+		}
+	}
+	return out, nil
+}
+
+// SubnetID returns a handle for the prefix with the CIDR subnet address in
+// the namespace. sectionID is ignored, as Nautobot has no sections.
+func (s *Sink) SubnetID(sectionID int, cidr string) (int, error) {
+	_, namespace, err := s.references()
+	if err != nil {
+		return 0, err
+	}
+	found, err := s.list(fmt.Sprintf("looking up subnet %s", cidr), "/api/ipam/prefixes/", url.Values{"prefix": {cidr}, "namespace": {namespace}})
+	if err != nil {
+		return 0, err
+	}
+	if len(found) == 0 {
+		return 0, fmt.Errorf("subnet %s not found in namespace %s", cidr, s.Namespace)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.handle(found[0].ID)
+	s.prefixes[h] = found[0].Prefix
+	return h, nil
+}
+
+// SubnetIDs lists all of the prefixes in the namespace once, and returns a
+// map of their CIDRs to handles. sectionID is ignored, as Nautobot has no
+// sections.
+func (s *Sink) SubnetIDs(sectionID int) (map[string]int, error) {
+	_, namespace, err := s.references()
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.list("listing subnets", "/api/ipam/prefixes/", url.Values{"namespace": {namespace}, "limit": {"1000"}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing subnets: %s", err)
+	}
+	out := make(map[string]int)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range found {
+		h := s.handle(v.ID)
+		out[v.Prefix] = h
+		s.prefixes[h] = v.Prefix
+	}
+	return out, nil
+}
+
+// VerifyAddresses compares addrs against the IP addresses in Nautobot, and
+// returns any differences found. As with verify.Addresses, the addresses of
+// each prefix are listed once, but the notes of each address are read
+// separately.
+func (s *Sink) VerifyAddresses(addrs []addresses.Address) ([]verify.Mismatch, error) {
+	bySubnet := make(map[int][]addresses.Address)
+	for _, v := range addrs {
+		bySubnet[v.SubnetID] = append(bySubnet[v.SubnetID], v)
+	}
+	var ids []int
+	for id := range bySubnet {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var out []verify.Mismatch
+	for _, id := range ids {
+		actual, err := s.prefixAddresses(id)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying IP addresses: %s", err)
+		}
+		out = append(out, verify.CompareAddresses(id, bySubnet[id], actual)...)
+	}
+	return out, nil
+}
+
+// prefixAddresses lists the IP addresses directly under the prefix with
+// handle h, with their notes.
+func (s *Sink) prefixAddresses(h int) ([]addresses.Address, error) {
+	prefix, err := s.uuid("subnet", h)
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.list("listing IP addresses", "/api/ipam/ip-addresses/", url.Values{"parent": {prefix}, "limit": {"1000"}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing IP addresses in subnet ID %d: %s", h, err)
+	}
+	var out []addresses.Address
+	for _, v := range found {
+		notes, err := s.list(fmt.Sprintf("listing notes of IP address %s", v.Address), fmt.Sprintf("/api/ipam/ip-addresses/%s/notes/", v.ID), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error listing notes of IP address %s: %s", v.Address, err)
+		}
+		var text []string
+		for _, n := range notes {
+			text = append(text, n.Note)
+		}
+		ip := v.Address
+		if i := strings.IndexByte(ip, '/'); i >= 0 {
+			ip = ip[:i]
+		}
+		out = append(out, addresses.Address{
+			SubnetID:    h,
+			IPAddress:   ip,
+			Hostname:    v.DNSName,
+			Description: v.Description,
+			Note:        strings.Join(text, "\n"),
+		})
+	}
+	return out, nil
+}
+
+// Check checks that the Nautobot API can be reached, that the token is
+// accepted, and that the status and namespace can be used, so that a
+// misconfigured target fails before the migration starts.
+func (s *Sink) Check() error {
+	if s.API.Token == "" {
+		return errors.New("no Nautobot API token supplied")
+	}
+	if err := s.API.Do("checking the Nautobot API", "GET", "/api/status/", nil, nil); err != nil {
+		return err
+	}
+	_, _, err := s.references()
+	return err
+}
@@ -0,0 +1,163 @@
+package nautobotsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// testServer returns a fake Nautobot API, which records the objects POSTed to
+// it by path, and gives them UUIDs of the form uuid-N.
+func testServer(created map[string][]map[string]interface{}) *httptest.Server {
+	n := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.Method == "POST":
+			var in map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&in)
+			created[r.URL.Path] = append(created[r.URL.Path], in)
+			n++
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":"uuid-%d"}`, n)
+		case r.URL.Path == "/api/status/":
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/api/extras/statuses/" && q.Get("name") == "Active":
+			w.Write([]byte(`{"results":[{"id":"status-active","content_types":["dcim.device","ipam.vlan","ipam.prefix","ipam.ipaddress"]}]}`))
+		case r.URL.Path == "/api/extras/statuses/" && q.Get("name") == "Planned":
+			w.Write([]byte(`{"results":[{"id":"status-planned","content_types":["ipam.prefix"]}]}`))
+		case r.URL.Path == "/api/extras/statuses/":
+			w.Write([]byte(`{"results":[]}`))
+		case r.URL.Path == "/api/ipam/namespaces/" && q.Get("name") == "Global":
+			w.Write([]byte(`{"results":[{"id":"ns-global"}]}`))
+		case r.URL.Path == "/api/ipam/vrfs/" && q.Get("namespace") == "ns-global":
+			w.Write([]byte(`{"results":[{"id":"vrf-a","name":"customers","rd":"65000:1"}]}`))
+		case r.URL.Path == "/api/ipam/vlans/":
+			w.Write([]byte(`{"results":[{"id":"vlan-a","vid":100},{"id":"vlan-b","vid":100}]}`))
+		case r.URL.Path == "/api/ipam/prefixes/" && q.Get("prefix") == "10.1.0.0/24" && q.Get("namespace") == "ns-global":
+			w.Write([]byte(`{"results":[{"id":"prefix-a","prefix":"10.1.0.0/24"}]}`))
+		case r.URL.Path == "/api/ipam/ip-addresses/" && q.Get("parent") == "prefix-a":
+			w.Write([]byte(`{"results":[{"id":"ip-1","address":"10.1.0.1/24","dns_name":"gw.example.com"},{"id":"ip-2","address":"10.1.0.2/24"}]}`))
+		case r.URL.Path == "/api/ipam/ip-addresses/ip-1/notes/":
+			w.Write([]byte(`{"results":[{"note":"core"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/notes/"):
+			w.Write([]byte(`{"results":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCreate(t *testing.T) {
+	created := make(map[string][]map[string]interface{})
+	ts := testServer(created)
+	defer ts.Close()
+	s := New(ts.URL, "secret", DefaultStatus, DefaultNamespace, retry.Policy{})
+
+	if err := s.Check(); err != nil {
+		t.Fatalf("Error checking API: %s", err)
+	}
+	if err := s.CreateVLAN(vlans.VLAN{Number: 100, Name: "servers"}, nil); err != nil {
+		t.Fatalf("Error creating VLAN: %s", err)
+	}
+	vlanID, err := s.VLANID(100)
+	if err != nil {
+		t.Fatalf("Error looking up VLAN: %s", err)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8, VLANID: vlanID}, map[string]string{"site": "yvr"}); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	subnetID, err := s.SubnetID(1, "10.1.0.0/24")
+	if err != nil {
+		t.Fatalf("Error looking up subnet: %s", err)
+	}
+	if err := s.CreateAddress(addresses.Address{SubnetID: subnetID, IPAddress: "10.1.0.1", Hostname: "gw", Note: "core"}, nil); err != nil {
+		t.Fatalf("Error creating address: %s", err)
+	}
+	if err := s.CreateVRF(vrfs.VRF{Name: "customers", RD: "65000:1"}); err != nil {
+		t.Fatalf("Error creating VRF: %s", err)
+	}
+	found, err := s.VRFs()
+	if err != nil || len(found) != 1 || found[0].Name != "customers" {
+		t.Fatalf("Unexpected VRFs %#v (%v)", found, err)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.2.0.0", Mask: 16, VRFID: found[0].ID}, nil); err != nil {
+		t.Fatalf("Error creating subnet in VRF: %s", err)
+	}
+
+	expected := map[string][]map[string]interface{}{
+		"/api/ipam/vlans/": {
+			{"vid": 100.0, "name": "servers", "status": "status-active"},
+		},
+		"/api/ipam/prefixes/": {
+			{"prefix": "10.0.0.0/8", "type": "network", "vlan": "vlan-a", "status": "status-active", "namespace": "ns-global", "custom_fields": map[string]interface{}{"site": "yvr"}},
+			{"prefix": "10.2.0.0/16", "type": "network", "status": "status-active", "namespace": "ns-global"},
+		},
+		"/api/ipam/vrfs/": {
+			{"name": "customers", "rd": "65000:1", "namespace": "ns-global"},
+		},
+		"/api/ipam/vrf-prefix-assignments/": {
+			{"vrf": "vrf-a", "prefix": "uuid-6"},
+		},
+		"/api/ipam/ip-addresses/": {
+			{"address": "10.1.0.1/24", "dns_name": "gw", "status": "status-active", "namespace": "ns-global"},
+		},
+		"/api/ipam/ip-addresses/uuid-3/notes/": {
+			{"note": "core"},
+		},
+	}
+	if !reflect.DeepEqual(expected, created) {
+		t.Fatalf("Expected %#v, got %#v", expected, created)
+	}
+
+	if err := s.CreateAddress(addresses.Address{SubnetID: 99, IPAddress: "10.0.0.1"}, nil); err == nil {
+		t.Fatal("Expected error adding address to unknown subnet, got none")
+	}
+	for status, expected := range map[string]string{
+		"Planned": "status Planned is not enabled for ipam.vlan objects",
+		"Missing": "status Missing not found",
+	} {
+		err := New(ts.URL, "secret", status, DefaultNamespace, retry.Policy{}).Check()
+		if err == nil || err.Error() != expected {
+			t.Fatalf("Expected error %q for status %s, got %v", expected, status, err)
+		}
+	}
+	if err := New(ts.URL, "secret", DefaultStatus, "Missing", retry.Policy{}).Check(); err == nil {
+		t.Fatal("Expected error for missing namespace, got none")
+	}
+}
+
+func TestVerifyAddresses(t *testing.T) {
+	ts := testServer(make(map[string][]map[string]interface{}))
+	defer ts.Close()
+	s := New(ts.URL, "secret", DefaultStatus, DefaultNamespace, retry.Policy{})
+	id, err := s.SubnetID(1, "10.1.0.0/24")
+	if err != nil {
+		t.Fatalf("Error looking up subnet: %s", err)
+	}
+
+	mismatches, err := s.VerifyAddresses([]addresses.Address{
+		{SubnetID: id, IPAddress: "10.1.0.1", Hostname: "gw.example.com", Note: "edge"},
+		{SubnetID: id, IPAddress: "10.1.0.2"},
+	})
+	if err != nil {
+		t.Fatalf("Error verifying addresses: %s", err)
+	}
+	expected := []verify.Mismatch{
+		{IPAddress: "10.1.0.1", SubnetID: id, Field: "note", Expected: "edge", Actual: "core"},
+	}
+	if !reflect.DeepEqual(expected, mismatches) {
+		t.Fatalf("Expected %v, got %v", expected, mismatches)
+	}
+}
@@ -0,0 +1,405 @@
+// Package netboxsink writes migrated objects into a NetBox instance via its
+// REST API, in place of a new PHPIPAM instance, for users of legacy PHPIPAM
+// who are moving to NetBox instead.
+//
+// VLANs are written as NetBox VLANs, VRFs as VRFs, subnets as prefixes, and IP
+// addresses as IP addresses with the prefix length of their subnet, all with
+// the active status (which VRFs do not have). NetBox has no sections, so the
+// subnets of every section are written to the global table, or to their VRF,
+// and NetBox nests prefixes by itself. Address hostnames are written as DNS
+// names, and notes as comments. Owners and devices have no equivalent, and are
+// not written.
+//
+// As with ipamsink, every API call is retried as per the sink's retry policy,
+// and all errors are returned to the caller. Creates are only retried once
+// the object has been looked up and not found, so that a create that failed
+// but was committed anyway is not duplicated. Requests are sent with the rest
+// package.
+package netboxsink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/rest"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// statusActive is the status that objects are created with.
+const statusActive = "active"
+
+// Sink writes objects into a NetBox instance.
+type Sink struct {
+	// The client for the NetBox API.
+	API *rest.Client
+
+	// Whether existing prefixes are looked up in any VRF, rather than only in
+	// the global table. This is set when VRFs are migrated, as prefixes are
+	// then written to their VRF.
+	AnyVRF bool
+
+	mu sync.Mutex
+
+	// The prefix IDs looked up, so that addresses can be given the prefix
+	// length of their subnet, and listed in the VRF of their subnet.
+	prefixes map[int]object
+}
+
+// New returns a new Sink for the NetBox instance at baseURL, authenticating
+// with token, and retrying API calls as per policy.
+func New(baseURL, token string, policy retry.Policy) *Sink {
+	return &Sink{
+		API:      rest.New(baseURL, token, policy),
+		prefixes: make(map[int]object),
+	}
+}
+
+// object is the part of a NetBox object that the Sink reads back.
+type object struct {
+	ID       int     `json:"id"`
+	VID      int     `json:"vid"`
+	Name     string  `json:"name"`
+	RD       string  `json:"rd"`
+	Prefix   string  `json:"prefix"`
+	VRF      *object `json:"vrf"`
+	Address  string  `json:"address"`
+	DNSName  string  `json:"dns_name"`
+	Comments string  `json:"comments"`
+
+	Description string `json:"description"`
+}
+
+// list GETs all of the objects at path matching query. op describes the
+// operation in log messages.
+func (s *Sink) list(op, path string, query url.Values) ([]object, error) {
+	var out []object
+	err := s.API.List(op, path, query, func(raw json.RawMessage) error {
+		var v object
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		out = append(out, v)
+		return nil
+	})
+	return out, err
+}
+
+// vrfID returns the ID of the VRF of a prefix, for filtering on, which is
+// null for the global table.
+func (o object) vrfID() string {
+	if o.VRF == nil {
+		return "null"
+	}
+	return strconv.Itoa(o.VRF.ID)
+}
+
+// create POSTs in, with the active status and the supplied custom fields, to
+// the list at path, and returns the created object. query selects the object
+// in the list, to check whether it was created before retrying. op describes
+// the operation in log and error messages.
+func (s *Sink) create(op, path string, in map[string]interface{}, fields map[string]string, query url.Values) (object, error) {
+	in["status"] = statusActive
+	return s.post(op, path, in, fields, query)
+}
+
+// post POSTs in, with the supplied custom fields, to the list at path, and
+// returns the created object. query selects the object in the list, to check
+// whether it was created before retrying. op describes the operation in log
+// and error messages.
+func (s *Sink) post(op, path string, in map[string]interface{}, fields map[string]string, query url.Values) (object, error) {
+	if len(fields) > 0 {
+		in["custom_fields"] = fields
+	}
+	var out object
+	if err := s.API.Create(op, path, in, query, &out); err != nil {
+		return object{}, fmt.Errorf("error %s: %s", op, err)
+	}
+	return out, nil
+}
+
+// setString sets key in m to v, unless v is blank.
+func setString(m map[string]interface{}, key, v string) {
+	if v != "" {
+		m[key] = v
+	}
+}
+
+// CreateVLAN creates a VLAN, setting the supplied custom fields, if any.
+func (s *Sink) CreateVLAN(v vlans.VLAN, fields map[string]string) error {
+	in := map[string]interface{}{"vid": v.Number, "name": v.Name}
+	setString(in, "description", v.Description)
+	query := url.Values{"vid": {strconv.Itoa(v.Number)}, "name": {v.Name}}
+	_, err := s.create(fmt.Sprintf("adding VLAN number %d", v.Number), "/api/ipam/vlans/", in, fields, query)
+	return err
+}
+
+// CreateSubnet creates a subnet as a prefix, setting the supplied custom
+// fields, if any. Its VLAN and VRF IDs are the IDs of a NetBox VLAN and VRF.
+func (s *Sink) CreateSubnet(v subnets.Subnet, fields map[string]string) error {
+	cidr := fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return fmt.Errorf("error creating subnet %s: %s", cidr, err)
+	}
+	in := map[string]interface{}{"prefix": cidr}
+	setString(in, "description", v.Description)
+	if v.VLANID != 0 {
+		in["vlan"] = v.VLANID
+	}
+	prefix := object{Prefix: cidr}
+	if v.VRFID != 0 {
+		in["vrf"] = v.VRFID
+		prefix.VRF = &object{ID: v.VRFID}
+	}
+	query := url.Values{"prefix": {cidr}, "vrf_id": {prefix.vrfID()}}
+	created, err := s.create(fmt.Sprintf("creating subnet %s", cidr), "/api/ipam/prefixes/", in, fields, query)
+	if err != nil {
+		return err
+	}
+	prefix.ID = created.ID
+	s.mu.Lock()
+	s.prefixes[created.ID] = prefix
+	s.mu.Unlock()
+	return nil
+}
+
+// CreateDevice returns an error, as NetBox devices need a device type, role,
+// and site, which legacy PHPIPAM has no equivalent of.
+func (s *Sink) CreateDevice(d devices.Device) error {
+	return fmt.Errorf("error adding device %s: devices cannot be migrated to NetBox", d.Hostname)
+}
+
+// Devices returns no devices.
+func (s *Sink) Devices() ([]devices.Device, error) {
+	return nil, nil
+}
+
+// CreateDeviceType returns an error, as devices cannot be migrated to NetBox.
+func (s *Sink) CreateDeviceType(t devices.DeviceType) error {
+	return fmt.Errorf("error adding device type %s: devices cannot be migrated to NetBox", t.Name)
+}
+
+// DeviceTypes returns no device types.
+func (s *Sink) DeviceTypes() ([]devices.DeviceType, error) {
+	return nil, nil
+}
+
+// CreateVRF creates a VRF.
+func (s *Sink) CreateVRF(v vrfs.VRF) error {
+	in := map[string]interface{}{"name": v.Name}
+	setString(in, "rd", v.RD)
+	setString(in, "description", v.Description)
+	_, err := s.post(fmt.Sprintf("adding VRF %s", v.Name), "/api/ipam/vrfs/", in, nil, url.Values{"name": {v.Name}})
+	return err
+}
+
+// VRFs lists all of the VRFs.
+func (s *Sink) VRFs() ([]vrfs.VRF, error) {
+	found, err := s.list("listing VRFs", "/api/ipam/vrfs/", url.Values{"limit": {"1000"}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing VRFs: %s", err)
+	}
+	var out []vrfs.VRF
+	for _, v := range found {
+		out = append(out, vrfs.VRF{ID: v.ID, Name: v.Name, RD: v.RD, Description: v.Description})
+	}
+	return out, nil
+}
+
+// CreateAddress creates an IP address, with the prefix length of its subnet,
+// and in the VRF of its subnet, setting the supplied custom fields, if any.
+// Its subnet ID is the ID of a NetBox prefix.
+func (s *Sink) CreateAddress(a addresses.Address, fields map[string]string) error {
+	op := fmt.Sprintf("adding IP address %s", a.IPAddress)
+	prefix, err := s.prefix(a.SubnetID)
+	if err != nil {
+		return fmt.Errorf("error %s: %s", op, err)
+	}
+	in := map[string]interface{}{"address": fmt.Sprintf("%s/%s", a.IPAddress, prefix.Prefix[strings.IndexByte(prefix.Prefix, '/')+1:])}
+	if prefix.VRF != nil {
+		in["vrf"] = prefix.VRF.ID
+	}
+	setString(in, "dns_name", a.Hostname)
+	setString(in, "description", a.Description)
+	setString(in, "comments", a.Note)
+	query := url.Values{"address": {a.IPAddress}, "vrf_id": {prefix.vrfID()}}
+	_, err = s.create(op, "/api/ipam/ip-addresses/", in, fields, query)
+	return err
+}
+
+// prefix returns the prefix with ID id, looking it up if it has not been seen
+// yet.
+func (s *Sink) prefix(id int) (object, error) {
+	s.mu.Lock()
+	prefix, ok := s.prefixes[id]
+	s.mu.Unlock()
+	if ok {
+		return prefix, nil
+	}
+	var found object
+	if err := s.API.Do(fmt.Sprintf("looking up prefix ID %d", id), "GET", fmt.Sprintf("/api/ipam/prefixes/%d/", id), nil, &found); err != nil {
+		return object{}, fmt.Errorf("error looking up prefix ID %d: %s", id, err)
+	}
+	if !strings.Contains(found.Prefix, "/") {
+		return object{}, fmt.Errorf("prefix ID %d has invalid prefix %q", id, found.Prefix)
+	}
+	s.mu.Lock()
+	s.prefixes[id] = found
+	s.mu.Unlock()
+	return found, nil
+}
+
+// prefixQuery returns the query for listing the prefixes that subnets are
+// looked up in, with the supplied values.
+func (s *Sink) prefixQuery(values url.Values) url.Values {
+	if !s.AnyVRF {
+		values.Set("vrf_id", "null")
+	}
+	return values
+}
+
+// VLANID returns the ID of the VLAN with number n. If the number is used by
+// more than one VLAN, the one with the lowest ID is used.
+func (s *Sink) VLANID(n int) (int, error) {
+	found, err := s.list(fmt.Sprintf("looking up VLAN number %d", n), "/api/ipam/vlans/", url.Values{"vid": {strconv.Itoa(n)}})
+	if err != nil {
+		return 0, err
+	}
+	if len(found) == 0 {
+		return 0, fmt.Errorf("VLAN number %d not found", n)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	return found[0].ID, nil
+}
+
+// VLANIDs lists all of the VLANs once, and returns a map of VLAN numbers to
+// IDs, choosing between VLANs with the same number as VLANID does.
+func (s *Sink) VLANIDs() (map[int]int, error) {
+	found, err := s.list("listing VLANs", "/api/ipam/vlans/", url.Values{"limit": {"1000"}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing VLANs: %s", err)
+	}
+	out := make(map[int]int)
+	for _, v := range found {
+		if id, ok := out[v.VID]; !ok || v.ID < id {
+			out[v.VID] = v.ID
+		}
+	}
+	return out, nil
+}
+
+// SubnetID returns the ID of the prefix with the CIDR subnet address in the
+// global table, or in any VRF if AnyVRF is set, choosing the one with the
+// lowest ID. sectionID is ignored, as NetBox has no sections.
+func (s *Sink) SubnetID(sectionID int, cidr string) (int, error) {
+	found, err := s.list(fmt.Sprintf("looking up subnet %s", cidr), "/api/ipam/prefixes/", s.prefixQuery(url.Values{"prefix": {cidr}}))
+	if err != nil {
+		return 0, err
+	}
+	if len(found) == 0 {
+		return 0, fmt.Errorf("subnet %s not found", cidr)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	s.mu.Lock()
+	s.prefixes[found[0].ID] = found[0]
+	s.mu.Unlock()
+	return found[0].ID, nil
+}
+
+// SubnetIDs lists all of the prefixes in the global table (or in any VRF if
+// AnyVRF is set) once, and returns a map of their CIDRs to IDs, choosing
+// between prefixes with the same CIDR as SubnetID does. sectionID is ignored,
+// as NetBox has no sections.
+func (s *Sink) SubnetIDs(sectionID int) (map[string]int, error) {
+	found, err := s.list("listing subnets", "/api/ipam/prefixes/", s.prefixQuery(url.Values{"limit": {"1000"}}))
+	if err != nil {
+		return nil, fmt.Errorf("error listing subnets: %s", err)
+	}
+	out := make(map[string]int)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range found {
+		if id, ok := out[v.Prefix]; !ok || v.ID < id {
+			out[v.Prefix] = v.ID
+		}
+		s.prefixes[v.ID] = v
+	}
+	return out, nil
+}
+
+// VerifyAddresses compares addrs against the IP addresses in NetBox, and
+// returns any differences found. As with verify.Addresses, the addresses of
+// each prefix are listed once. Addresses in child prefixes, which have a
+// longer prefix length, are not counted as part of the prefix.
+func (s *Sink) VerifyAddresses(addrs []addresses.Address) ([]verify.Mismatch, error) {
+	bySubnet := make(map[int][]addresses.Address)
+	for _, v := range addrs {
+		bySubnet[v.SubnetID] = append(bySubnet[v.SubnetID], v)
+	}
+	var ids []int
+	for id := range bySubnet {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var out []verify.Mismatch
+	for _, id := range ids {
+		actual, err := s.prefixAddresses(id)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying IP addresses: %s", err)
+		}
+		out = append(out, verify.CompareAddresses(id, bySubnet[id], actual)...)
+	}
+	return out, nil
+}
+
+// prefixAddresses lists the IP addresses in the prefix with ID id, in the
+// prefix's VRF.
+func (s *Sink) prefixAddresses(id int) ([]addresses.Address, error) {
+	found, err := s.prefix(id)
+	if err != nil {
+		return nil, err
+	}
+	prefix := found.Prefix
+	addrs, err := s.list(fmt.Sprintf("listing IP addresses in %s", prefix), "/api/ipam/ip-addresses/",
+		url.Values{"parent": {prefix}, "vrf_id": {found.vrfID()}, "limit": {"1000"}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing IP addresses in %s: %s", prefix, err)
+	}
+	length := prefix[strings.IndexByte(prefix, '/'):]
+	var out []addresses.Address
+	for _, v := range addrs {
+		if !strings.HasSuffix(v.Address, length) {
+			continue
+		}
+		out = append(out, addresses.Address{
+			SubnetID:    id,
+			IPAddress:   strings.TrimSuffix(v.Address, length),
+			Hostname:    v.DNSName,
+			Description: v.Description,
+			Note:        v.Comments,
+		})
+	}
+	return out, nil
+}
+
+// Check checks that the NetBox API can be reached and that the token is
+// accepted, so that a misconfigured target fails before the migration starts.
+func (s *Sink) Check() error {
+	if s.API.Token == "" {
+		return errors.New("no NetBox API token supplied")
+	}
+	return s.API.Do("checking the NetBox API", "GET", "/api/status/", nil, nil)
+}
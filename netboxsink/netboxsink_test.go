@@ -0,0 +1,170 @@
+package netboxsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+	"github.com/paybyphone/phpipam-legacy-migrator/verify"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+// testServer returns a fake NetBox API, which records the objects POSTed to
+// it by path.
+func testServer(created map[string][]map[string]interface{}) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"detail":"Invalid token"}`))
+			return
+		}
+		q := r.URL.Query()
+		switch {
+		case r.Method == "POST":
+			var in map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&in)
+			created[r.URL.Path] = append(created[r.URL.Path], in)
+			w.WriteHeader(http.StatusCreated)
+			prefix, _ := in["prefix"].(string)
+			fmt.Fprintf(w, `{"id":%d,"prefix":%q}`, 40+len(created[r.URL.Path]), prefix)
+		case r.URL.Path == "/api/ipam/vlans/" && q.Get("offset") == "":
+			fmt.Fprintf(w, `{"next":"%s/api/ipam/vlans/?offset=2","results":[{"id":3,"vid":100},{"id":2,"vid":100}]}`, ts.URL)
+		case r.URL.Path == "/api/ipam/vlans/":
+			w.Write([]byte(`{"next":null,"results":[{"id":4,"vid":200}]}`))
+		case r.URL.Path == "/api/ipam/prefixes/" && q.Get("prefix") == "10.1.0.0/24" && q.Get("vrf_id") == "null":
+			w.Write([]byte(`{"results":[{"id":7,"prefix":"10.1.0.0/24"}]}`))
+		case r.URL.Path == "/api/ipam/prefixes/" && q.Get("prefix") == "10.3.0.0/24" && q["vrf_id"] == nil:
+			w.Write([]byte(`{"results":[{"id":8,"prefix":"10.3.0.0/24","vrf":{"id":5}}]}`))
+		case r.URL.Path == "/api/ipam/prefixes/9/":
+			w.Write([]byte(`{"id":9,"prefix":"10.2.0.0/16"}`))
+		case r.URL.Path == "/api/ipam/ip-addresses/" && q.Get("parent") == "10.3.0.0/24" && q.Get("vrf_id") == "5":
+			w.Write([]byte(`{"results":[{"address":"10.3.0.1/24"}]}`))
+		case r.URL.Path == "/api/ipam/vrfs/":
+			w.Write([]byte(`{"results":[{"id":5,"name":"customers","rd":"65000:1"}]}`))
+		case r.URL.Path == "/api/ipam/ip-addresses/" && q.Get("parent") == "10.1.0.0/24" && q.Get("vrf_id") == "null":
+			w.Write([]byte(`{"results":[{"address":"10.1.0.1/24","dns_name":"gw.example.com"},{"address":"10.1.0.2/24"},{"address":"10.1.0.130/25"}]}`))
+		case r.URL.Path == "/api/status/":
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	return ts
+}
+
+func TestCreate(t *testing.T) {
+	created := make(map[string][]map[string]interface{})
+	ts := testServer(created)
+	defer ts.Close()
+	s := New(ts.URL+"/", "secret", retry.Policy{})
+
+	if err := s.Check(); err != nil {
+		t.Fatalf("Error checking API: %s", err)
+	}
+	if err := s.CreateVLAN(vlans.VLAN{Number: 100, Name: "servers"}, map[string]string{"site": "yvr"}); err != nil {
+		t.Fatalf("Error creating VLAN: %s", err)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8, SectionID: 2, VLANID: 2, Description: "parent"}, nil); err != nil {
+		t.Fatalf("Error creating subnet: %s", err)
+	}
+	if err := s.CreateAddress(addresses.Address{SubnetID: 41, IPAddress: "10.0.0.1", Hostname: "gw", Note: "n"}, nil); err != nil {
+		t.Fatalf("Error creating address: %s", err)
+	}
+	if err := s.CreateAddress(addresses.Address{SubnetID: 9, IPAddress: "10.2.0.1"}, nil); err != nil {
+		t.Fatalf("Error creating address in looked up prefix: %s", err)
+	}
+	if err := s.CreateVRF(vrfs.VRF{Name: "customers", RD: "65000:1"}); err != nil {
+		t.Fatalf("Error creating VRF: %s", err)
+	}
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "10.3.0.0", Mask: 24, VRFID: 41}, nil); err != nil {
+		t.Fatalf("Error creating subnet in VRF: %s", err)
+	}
+	if err := s.CreateAddress(addresses.Address{SubnetID: 42, IPAddress: "10.3.0.1"}, nil); err != nil {
+		t.Fatalf("Error creating address in VRF: %s", err)
+	}
+
+	expected := map[string][]map[string]interface{}{
+		"/api/ipam/vlans/": {
+			{"vid": 100.0, "name": "servers", "status": "active", "custom_fields": map[string]interface{}{"site": "yvr"}},
+		},
+		"/api/ipam/prefixes/": {
+			{"prefix": "10.0.0.0/8", "description": "parent", "vlan": 2.0, "status": "active"},
+			{"prefix": "10.3.0.0/24", "vrf": 41.0, "status": "active"},
+		},
+		"/api/ipam/ip-addresses/": {
+			{"address": "10.0.0.1/8", "dns_name": "gw", "comments": "n", "status": "active"},
+			{"address": "10.2.0.1/16", "status": "active"},
+			{"address": "10.3.0.1/24", "vrf": 41.0, "status": "active"},
+		},
+		"/api/ipam/vrfs/": {
+			{"name": "customers", "rd": "65000:1"},
+		},
+	}
+	if !reflect.DeepEqual(expected, created) {
+		t.Fatalf("Expected %#v, got %#v", expected, created)
+	}
+
+	if err := s.CreateSubnet(subnets.Subnet{SubnetAddress: "bad", Mask: 8}, nil); err == nil {
+		t.Fatal("Expected error creating invalid subnet, got none")
+	}
+	if err := New(ts.URL, "wrong", retry.Policy{}).Check(); err == nil {
+		t.Fatal("Expected error checking API with wrong token, got none")
+	}
+}
+
+func TestLookups(t *testing.T) {
+	ts := testServer(make(map[string][]map[string]interface{}))
+	defer ts.Close()
+	s := New(ts.URL, "secret", retry.Policy{})
+
+	ids, err := s.VLANIDs()
+	if err != nil {
+		t.Fatalf("Error listing VLANs: %s", err)
+	}
+	if expected := map[int]int{100: 2, 200: 4}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("Expected VLAN IDs %v, got %v", expected, ids)
+	}
+	id, err := s.SubnetID(3, "10.1.0.0/24")
+	if err != nil || id != 7 {
+		t.Fatalf("Expected subnet ID 7, got %d (%v)", id, err)
+	}
+	if _, err := s.SubnetID(3, "10.9.0.0/24"); err == nil || !retry.IsTransient(err) {
+		t.Fatalf("Expected transient error, got %v", err)
+	}
+
+	mismatches, err := s.VerifyAddresses([]addresses.Address{
+		{SubnetID: 7, IPAddress: "10.1.0.1", Hostname: "gw.example.com"},
+		{SubnetID: 7, IPAddress: "10.1.0.3"},
+	})
+	if err != nil {
+		t.Fatalf("Error verifying addresses: %s", err)
+	}
+	expected := []verify.Mismatch{
+		{IPAddress: "10.1.0.3", SubnetID: 7, Field: "address", Expected: "present", Actual: "missing"},
+		{IPAddress: "10.1.0.2", SubnetID: 7, Field: "address", Expected: "missing", Actual: "present"},
+	}
+	if !reflect.DeepEqual(expected, mismatches) {
+		t.Fatalf("Expected %v, got %v", expected, mismatches)
+	}
+
+	found, err := s.VRFs()
+	if expected := []vrfs.VRF{{ID: 5, Name: "customers", RD: "65000:1"}}; err != nil || !reflect.DeepEqual(expected, found) {
+		t.Fatalf("Expected VRFs %#v, got %#v (%v)", expected, found, err)
+	}
+	s.AnyVRF = true
+	if id, err = s.SubnetID(3, "10.3.0.0/24"); err != nil || id != 8 {
+		t.Fatalf("Expected subnet ID 8 in any VRF, got %d (%v)", id, err)
+	}
+	mismatches, err = s.VerifyAddresses([]addresses.Address{{SubnetID: 8, IPAddress: "10.3.0.1"}})
+	if err != nil || len(mismatches) != 0 {
+		t.Fatalf("Expected no mismatches in VRF, got %v (%v)", mismatches, err)
+	}
+}
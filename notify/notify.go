@@ -0,0 +1,100 @@
+// Package notify sends the final report of a migration run to a webhook, so
+// that automation can alert downstream systems when a run completes or fails.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// The statuses of a run.
+const (
+	// StatusSucceeded is the status of a run that completed successfully.
+	StatusSucceeded = "succeeded"
+
+	// StatusFailed is the status of a run that failed.
+	StatusFailed = "failed"
+
+	// StatusRunning is the status of a run that has not finished yet. It is
+	// never sent, but is shown in reports taken during the run.
+	StatusRunning = "running"
+)
+
+// Report is the final report of a migration run.
+type Report struct {
+	// The status of the run, either succeeded or failed.
+	Status string `json:"status"`
+
+	// The error that failed the run, if any.
+	Error string `json:"error,omitempty"`
+
+	// The times the run started and finished, and its duration in seconds.
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Duration float64   `json:"duration_seconds"`
+
+	// The number of records processed, keyed by entity and then by result
+	// (ie: counts["addresses"]["migrated"]).
+	Counts map[string]map[string]int `json:"counts"`
+
+	// The reports of each section migrated.
+	Sections []SectionReport `json:"sections,omitempty"`
+}
+
+// SectionReport is the report of the migration of a single section.
+type SectionReport struct {
+	// The IDs of the legacy section (0 if all sections were migrated) and of
+	// the section in the new PHPIPAM instance.
+	LegacyID int `json:"legacy_id"`
+	ID       int `json:"id"`
+
+	// The status of the section, either succeeded or failed.
+	Status string `json:"status"`
+
+	// The error that aborted the section, if any.
+	Error string `json:"error,omitempty"`
+
+	// The number of subnets and addresses added, and the number of records
+	// that failed.
+	SubnetsAdded   int `json:"subnets_added"`
+	AddressesAdded int `json:"addresses_added"`
+	Errors         int `json:"errors"`
+}
+
+// Client sends reports to a webhook.
+type Client struct {
+	// The URL that reports are POSTed to.
+	URL string
+
+	// The HTTP client to use. A client with a 30 second timeout is used if
+	// this is nil.
+	HTTPClient *http.Client
+}
+
+// Send POSTs the report to the webhook as JSON. Any non-2xx response is an
+// error.
+func (c *Client) Send(r *Report) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Post(c.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, body)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	var actual Report
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&actual); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	started := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	expected := Report{
+		Status:   StatusFailed,
+		Error:    "boom",
+		Started:  started,
+		Finished: started.Add(time.Minute),
+		Duration: 60,
+		Counts:   map[string]map[string]int{"addresses": {"migrated": 10, "error": 1}},
+		Sections: []SectionReport{{ID: 1, Status: StatusFailed, Error: "boom", AddressesAdded: 10, Errors: 1}},
+	}
+	if err := (&Client{URL: ts.URL}).Send(&expected); err != nil {
+		t.Fatalf("Error sending report: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+
+	if err := (&Client{URL: ts.URL + "/fail"}).Send(&expected); err == nil {
+		t.Fatal("Expected error from failing webhook, got none")
+	}
+}
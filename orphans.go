@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// orphansSubnet is the CIDR of the catch-all subnet that the legacy
+	// addresses whose subnet does not exist are placed in. The subnet is
+	// created in the first migrated section. If blank, the addresses are not
+	// migrated.
+	orphansSubnet string
+
+	// orphanAddresses holds the legacy addresses whose subnet does not exist.
+	orphanAddresses []legacydb.Skip
+)
+
+// fetchOrphanAddresses reads the legacy addresses whose subnet does not exist
+// from db into orphanAddresses, and warns about them. Unless they are placed
+// in orphansSubnet, they are written to skippedFile. Failures only warn, as
+// the rest of the migration does not depend on the orphans.
+func fetchOrphanAddresses(db *sql.DB) {
+	orphanAddresses = nil
+	r := &legacydb.Reader{DB: db, Log: stageLog, Queries: legacyQueries}
+	r.Skipped = func(v legacydb.Skip) { orphanAddresses = append(orphanAddresses, v) }
+	if _, err := r.OrphanAddresses(); err != nil {
+		orphanAddresses = nil
+		logrus.Warnf("Error reading addresses with missing subnets from legacy DB: %s", err)
+		return
+	}
+	if len(orphanAddresses) == 0 {
+		return
+	}
+	if orphansSubnet != "" {
+		logrus.Warnf("%d addresses in the legacy DB belong to subnets that do not exist, and are placed in subnet %s", len(orphanAddresses), orphansSubnet)
+		return
+	}
+	logrus.Warnf("%d addresses in the legacy DB belong to subnets that do not exist, and were not migrated", len(orphanAddresses))
+	if record := recordSkipped(0); record != nil {
+		for _, v := range orphanAddresses {
+			record(v)
+		}
+	}
+}
+
+// placesOrphans returns true if the orphan addresses are placed in
+// orphansSubnet in the section of s, which is the first migrated section.
+func (s *sectionRun) placesOrphans() bool {
+	if orphansSubnet == "" || len(orphanAddresses) == 0 {
+		return false
+	}
+	first := sectionRuns()[0]
+	return s.ID == first.ID && s.LegacyID == first.LegacyID
+}
+
+// orphansSubnetFor returns the catch-all subnet for the orphan addresses, in
+// the section of s.
+func (s *sectionRun) orphansSubnetFor() legacydb.Subnet {
+	_, n, _ := net.ParseCIDR(orphansSubnet)
+	size, _ := n.Mask.Size()
+	return legacydb.Subnet{Subnet: subnets.Subnet{
+		SubnetAddress: n.IP.String(),
+		Mask:          size,
+		Description:   "Legacy addresses whose subnet does not exist",
+		SectionID:     s.ID,
+	}}
+}
+
+// placeOrphanAddresses returns the orphan addresses that fit in
+// orphansSubnet, assigned to it. The rest, which are not IPv4 or are outside
+// of it, are written to skippedFile.
+func (s *sectionRun) placeOrphanAddresses() (out []legacydb.Address) {
+	_, n, _ := net.ParseCIDR(orphansSubnet)
+	record := recordSkipped(0)
+	for _, v := range orphanAddresses {
+		ip, err := legacydb.DecimalToIPv4(v.Address)
+		if err != nil || !n.Contains(net.ParseIP(ip)) {
+			s.log.WithField("ip", v.Address).Warnf("Orphan address %s does not fit in subnet %s, skipping", v.Address, orphansSubnet)
+			if record != nil {
+				v.Reason = fmt.Sprintf("%s, and the address is not in subnet %s", v.Reason, orphansSubnet)
+				record(v)
+			}
+			continue
+		}
+		a := legacydb.Address{SubnetCIDR: orphansSubnet}
+		a.IPAddress, a.Description, a.Hostname = ip, v.Description, v.Hostname
+		a.RecordChange("placed in subnet %s, as %s", orphansSubnet, v.Reason)
+		out = append(out, a)
+	}
+	return out
+}
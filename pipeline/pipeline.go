@@ -0,0 +1,136 @@
+// Package pipeline provides a simple staged pipeline for the migrator.
+//
+// A migration is made up of entities (ie: VLANs, subnets, and addresses),
+// each of which are processed through a series of named stages. The stages
+// that are run, and the order they are run in, are configurable, allowing
+// stages to be disabled or reordered. Entities are processed one at a time,
+// in order, so that later entities can depend on earlier ones having been
+// fully processed (ie: subnets need their VLANs written before they can be
+// resolved).
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The names of the built-in stages.
+const (
+	// Fetch reads data from the source.
+	Fetch = "fetch"
+
+	// Validate checks fetched data for problems.
+	Validate = "validate"
+
+	// Transform alters fetched data to suit the destination.
+	Transform = "transform"
+
+	// Resolve looks up references to other objects in the destination.
+	Resolve = "resolve"
+
+	// Write creates the data in the destination.
+	Write = "write"
+
+	// Verify compares the written data against the source.
+	Verify = "verify"
+)
+
+// DefaultStages are the stages run by default, in order.
+var DefaultStages = []string{Fetch, Validate, Transform, Resolve, Write}
+
+// knownStages is the list of all valid stage names.
+var knownStages = []string{Fetch, Validate, Transform, Resolve, Write, Verify}
+
+// StageFunc is a function implementing a stage for an entity. Returning an
+// error stops the pipeline.
+type StageFunc func() error
+
+// Entity is a kind of object that is migrated, along with the functions that
+// implement its stages. Stages that an entity does not implement are skipped
+// for that entity.
+type Entity struct {
+	// The name of the entity, used for logging.
+	Name string
+
+	// The stage functions, keyed by stage name.
+	Stages map[string]StageFunc
+}
+
+// Pipeline is an ordered list of entities and the stages to run for them.
+type Pipeline struct {
+	// The entities to process, in order.
+	Entities []Entity
+
+	// The stages to run for each entity, in order.
+	Stages []string
+
+	// A function called before each stage is run for an entity, usually used
+	// for logging. Can be nil.
+	OnStage func(entity, stage string)
+}
+
+// ValidateStages checks that stages only contains known stage names, and
+// that no stage is listed more than once.
+func ValidateStages(stages []string) error {
+	if len(stages) == 0 {
+		return fmt.Errorf("no stages configured")
+	}
+	seen := make(map[string]bool)
+	for _, v := range stages {
+		if !isKnown(v) {
+			return fmt.Errorf("unknown stage %q (valid stages are: %s)", v, strings.Join(knownStages, ", "))
+		}
+		if seen[v] {
+			return fmt.Errorf("stage %q listed more than once", v)
+		}
+		seen[v] = true
+	}
+	return nil
+}
+
+// ParseStages parses a comma-separated list of stage names and validates it.
+func ParseStages(s string) ([]string, error) {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	if err := ValidateStages(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Run runs the configured stages for each entity in turn. The pipeline stops at
+// the first stage that returns an error, which is returned.
+func (p *Pipeline) Run() error {
+	if err := ValidateStages(p.Stages); err != nil {
+		return err
+	}
+	for _, e := range p.Entities {
+		for _, s := range p.Stages {
+			f, ok := e.Stages[s]
+			if !ok {
+				continue
+			}
+			if p.OnStage != nil {
+				p.OnStage(e.Name, s)
+			}
+			if err := f(); err != nil {
+				return fmt.Errorf("%s stage for %s failed: %s", s, e.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isKnown returns true if name is a known stage.
+func isKnown(name string) bool {
+	for _, v := range knownStages {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPipelineRun(t *testing.T) {
+	var calls []string
+	stage := func(name string) StageFunc {
+		return func() error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	p := &Pipeline{
+		Entities: []Entity{
+			{
+				Name: "vlans",
+				Stages: map[string]StageFunc{
+					Fetch: stage("vlans.fetch"),
+					Write: stage("vlans.write"),
+				},
+			},
+			{
+				Name: "subnets",
+				Stages: map[string]StageFunc{
+					Fetch:   stage("subnets.fetch"),
+					Resolve: stage("subnets.resolve"),
+					Write:   stage("subnets.write"),
+				},
+			},
+		},
+		Stages: []string{Fetch, Write, Resolve},
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	expected := []string{"vlans.fetch", "vlans.write", "subnets.fetch", "subnets.write", "subnets.resolve"}
+	if !reflect.DeepEqual(expected, calls) {
+		t.Fatalf("Expected %v, got %v", expected, calls)
+	}
+}
+
+func TestPipelineRunError(t *testing.T) {
+	var calls int
+	p := &Pipeline{
+		Entities: []Entity{
+			{
+				Name: "subnets",
+				Stages: map[string]StageFunc{
+					Fetch: func() error { return errors.New("boom") },
+					Write: func() error {
+						calls++
+						return nil
+					},
+				},
+			},
+		},
+		Stages: []string{Fetch, Write},
+	}
+	err := p.Run()
+	if err == nil {
+		t.Fatal("Expected error running pipeline, got none")
+	}
+	if expected := "fetch stage for subnets failed: boom"; err.Error() != expected {
+		t.Fatalf("Expected error %q, got %q", expected, err)
+	}
+	if calls != 0 {
+		t.Fatal("Expected write stage not to run after fetch failure")
+	}
+}
+
+func TestParseStages(t *testing.T) {
+	actual, err := ParseStages("fetch, validate,transform")
+	if err != nil {
+		t.Fatalf("Error parsing stages: %s", err)
+	}
+	expected := []string{Fetch, Validate, Transform}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+
+	for _, v := range []string{"", "fetch,bogus", "fetch,fetch"} {
+		if _, err := ParseStages(v); err == nil {
+			t.Fatalf("Expected error parsing %q, got none", v)
+		}
+	}
+}
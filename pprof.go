@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startPprofServer starts serving the net/http/pprof profiling endpoints on
+// /debug/pprof/ at pprofAddr, in the background.
+func startPprofServer() {
+	l, err := net.Listen("tcp", pprofAddr)
+	if err != nil {
+		logrus.Fatalf("Error starting pprof listener: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			logrus.Errorf("Error serving pprof: %s", err)
+		}
+	}()
+	logrus.Infof("Serving pprof on http://%s/debug/pprof/", l.Addr())
+}
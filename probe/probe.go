@@ -0,0 +1,183 @@
+// Package probe detects which optional features the new PHPIPAM instance's
+// API supports, so that features depending on them can be gated up front,
+// rather than failing part way through a migration. It also checks the
+// required controllers and the API app's permissions before migrating.
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// Feature represents an optional API feature, along with the URI used to
+// probe it.
+type Feature struct {
+	// The name of the feature, used in messages.
+	Name string
+
+	// The URI that is sent a GET request to probe the feature.
+	URI string
+}
+
+// The optional features that can be probed.
+var (
+	// L2Domains is the L2 domains controller.
+	L2Domains = Feature{Name: "L2 domains", URI: "/l2domains/"}
+
+	// CustomFields is the custom fields endpoint of the subnets controller.
+	CustomFields = Feature{Name: "custom fields", URI: "/subnets/custom_fields/"}
+
+	// Devices is the devices subcontroller of the tools controller.
+	Devices = Feature{Name: "devices", URI: "/tools/devices/"}
+
+	// VRFs is the VRF controller.
+	VRFs = Feature{Name: "VRFs", URI: "/vrf/"}
+
+	// Nameservers is the nameservers subcontroller of the tools controller.
+	Nameservers = Feature{Name: "nameservers", URI: "/tools/nameservers/"}
+)
+
+// AllFeatures is the list of all features that can be probed.
+var AllFeatures = []Feature{L2Domains, CustomFields, Devices, VRFs, Nameservers}
+
+// The controllers that every migration depends on.
+var (
+	// Sections is the sections controller.
+	Sections = Feature{Name: "sections", URI: "/sections/"}
+
+	// VLANs is the VLAN controller.
+	VLANs = Feature{Name: "VLANs", URI: "/vlans/"}
+
+	// Subnets is the subnets controller, probed with a CIDR search that
+	// matches nothing.
+	Subnets = Feature{Name: "subnets", URI: "/subnets/cidr/0.0.0.0/32/"}
+
+	// Addresses is the addresses controller, probed through its list of
+	// address tags.
+	Addresses = Feature{Name: "addresses", URI: "/addresses/tags/"}
+)
+
+// RequiredFeatures is the list of the controllers that every migration
+// depends on.
+var RequiredFeatures = []Feature{Sections, VLANs, Subnets, Addresses}
+
+// Result is the result of probing a single feature.
+type Result struct {
+	// Whether or not the feature is available.
+	Available bool
+
+	// The reason the feature is unavailable, if it is.
+	Reason string
+}
+
+// Capabilities holds the results of a probe, keyed by feature name.
+type Capabilities map[string]Result
+
+// Probe sends a GET request for each of the supplied features, and records
+// whether or not each is available.
+//
+// A feature is considered available if the request succeeds, or if it fails
+// with a 404 "No ... found" error, which the API returns for controllers that
+// exist but have no data. Any other error marks the feature as unavailable.
+func Probe(sess *session.Session, features ...Feature) Capabilities {
+	c := client.NewClient(sess)
+	out := make(Capabilities)
+	for _, f := range features {
+		var data interface{}
+		err := c.SendRequest("GET", f.URI, &struct{}{}, &data)
+		switch {
+		case err == nil:
+			out[f.Name] = Result{Available: true}
+		case strings.HasPrefix(err.Error(), "Error from API (404): No "):
+			out[f.Name] = Result{Available: true}
+		default:
+			out[f.Name] = Result{Reason: err.Error()}
+		}
+	}
+	return out
+}
+
+// Writable checks that the API app has write permissions, which PHPIPAM only
+// enforces when objects are written. This is done by sending a PATCH request
+// without an ID to the sections controller, which cannot change anything: it
+// fails due to permissions if the app is read-only, and due to the missing ID
+// otherwise.
+func Writable(sess *session.Session) error {
+	c := client.NewClient(sess)
+	var data interface{}
+	err := c.SendRequest("PATCH", "/sections/", &struct{}{}, &data)
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "Error from API (401)") || strings.HasPrefix(msg, "Error from API (403)") || strings.Contains(strings.ToLower(msg), "permission") {
+		return err
+	}
+	return nil
+}
+
+// Available returns true if the feature was probed and found to be
+// available.
+func (c Capabilities) Available(f Feature) bool {
+	return c[f.Name].Available
+}
+
+// Reason returns the reason that a feature is unavailable.
+func (c Capabilities) Reason(f Feature) string {
+	return c[f.Name].Reason
+}
+
+// Options are the optional parts of a migration, which depend on the features
+// of the same names.
+type Options struct {
+	Devices      bool
+	VRFs         bool
+	L2Domains    bool
+	Nameservers  bool
+	CustomFields bool
+}
+
+// Gate disables the options in opts that depend on features missing from c,
+// and returns a warning for each option that it disabled.
+func (c Capabilities) Gate(opts *Options) (warnings []string) {
+	for _, v := range []struct {
+		enabled *bool
+		feature Feature
+		what    string
+	}{
+		{&opts.Devices, Devices, "Device migration disabled"},
+		{&opts.VRFs, VRFs, "VRF migration disabled"},
+		{&opts.L2Domains, L2Domains, "L2 domain migration disabled, so VLANs are created in the default domain"},
+		{&opts.Nameservers, Nameservers, "Nameserver set migration disabled"},
+		{&opts.CustomFields, CustomFields, "Custom field migration disabled, so mapped legacy custom columns and legacy IDs are not written"},
+	} {
+		if *v.enabled && !c.Available(v.feature) {
+			*v.enabled = false
+			warnings = append(warnings, fmt.Sprintf("%s: the PHPIPAM API does not support %s (%s)", v.what, v.feature.Name, c.Reason(v.feature)))
+		}
+	}
+	return warnings
+}
+
+// Summary returns a one-line summary of the probe results, sorted by feature
+// name.
+func (c Capabilities) Summary() string {
+	var names []string
+	for k := range c {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, k := range names {
+		state := "available"
+		if !c[k].Available {
+			state = "unavailable"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", k, state))
+	}
+	return strings.Join(parts, ", ")
+}
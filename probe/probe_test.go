@@ -0,0 +1,119 @@
+package probe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+func TestProbe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app/user/":
+			w.Write([]byte(`{"code":200,"success":true,"data":{"token":"foo"}}`))
+		case "/app/l2domains/":
+			w.Write([]byte(`{"code":200,"success":true,"data":[{"id":"1","name":"default"}]}`))
+		case "/app/tools/devices/":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"success":false,"message":"No devices configured"}`))
+		case "/app/vrf/":
+			w.Write([]byte(`{"code":200,"success":true,"data":[{"vrfId":"1","name":"customers"}]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":400,"success":false,"message":"Invalid controller"}`))
+		}
+	}))
+	defer ts.Close()
+
+	sess := session.NewSession(phpipam.Config{Endpoint: ts.URL, AppID: "app"})
+	caps := Probe(sess, AllFeatures...)
+
+	if !caps.Available(L2Domains) {
+		t.Fatalf("Expected L2 domains to be available: %s", caps.Reason(L2Domains))
+	}
+	if !caps.Available(Devices) {
+		t.Fatalf("Expected devices to be available: %s", caps.Reason(Devices))
+	}
+	if !caps.Available(VRFs) {
+		t.Fatalf("Expected VRFs to be available: %s", caps.Reason(VRFs))
+	}
+	if caps.Available(CustomFields) {
+		t.Fatal("Expected custom fields to be unavailable")
+	}
+
+	if caps.Available(Nameservers) {
+		t.Fatal("Expected nameservers to be unavailable")
+	}
+
+	expected := "L2 domains: available, VRFs: available, custom fields: unavailable, devices: available, nameservers: unavailable"
+	if actual := caps.Summary(); expected != actual {
+		t.Fatalf("Expected summary %q, got %q", expected, actual)
+	}
+}
+
+func TestRequiredFeatures(t *testing.T) {
+	readOnly := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/app/user/":
+			w.Write([]byte(`{"code":200,"success":true,"data":{"token":"foo"}}`))
+		case r.Method == "PATCH" && readOnly:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":401,"success":false,"message":"Invalid permissions"}`))
+		case r.Method == "PATCH":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":400,"success":false,"message":"Section Id required"}`))
+		case r.URL.Path == "/app/sections/" || r.URL.Path == "/app/vlans/":
+			w.Write([]byte(`{"code":200,"success":true,"data":[]}`))
+		case r.URL.Path == "/app/subnets/cidr/0.0.0.0/32/":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"success":false,"message":"No subnets found"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":401,"success":false,"message":"Unauthorized controller"}`))
+		}
+	}))
+	defer ts.Close()
+
+	sess := session.NewSession(phpipam.Config{Endpoint: ts.URL, AppID: "app"})
+	caps := Probe(sess, RequiredFeatures...)
+	for _, f := range []Feature{Sections, VLANs, Subnets} {
+		if !caps.Available(f) {
+			t.Fatalf("Expected %s to be available: %s", f.Name, caps.Reason(f))
+		}
+	}
+	if caps.Available(Addresses) {
+		t.Fatal("Expected addresses to be unavailable")
+	}
+
+	if err := Writable(sess); err != nil {
+		t.Fatalf("Expected app to be writable, got %s", err)
+	}
+	readOnly = true
+	if err := Writable(sess); err == nil {
+		t.Fatal("Expected read-only app not to be writable")
+	}
+}
+
+func TestGate(t *testing.T) {
+	caps := Capabilities{
+		Devices.Name: {Available: true},
+		VRFs.Name:    {Reason: "Error from API (401): Unauthorized controller"},
+	}
+	opts := Options{Devices: true, VRFs: true, CustomFields: true}
+	warnings := caps.Gate(&opts)
+	if expected := (Options{Devices: true}); opts != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, opts)
+	}
+	expected := []string{
+		"VRF migration disabled: the PHPIPAM API does not support VRFs (Error from API (401): Unauthorized controller)",
+		"Custom field migration disabled, so mapped legacy custom columns and legacy IDs are not written: the PHPIPAM API does not support custom fields ()",
+	}
+	if !reflect.DeepEqual(expected, warnings) {
+		t.Fatalf("Expected warnings %#v, got %#v", expected, warnings)
+	}
+}
@@ -0,0 +1,368 @@
+// Package progress provides a periodic progress display for the phases of a
+// migration, showing the number of records done out of the total, the rate,
+// and the estimated time remaining. On a terminal, the display can take over
+// the screen, with a progress bar for each phase.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxFinished is the number of finished phases kept on the full-screen
+// display, below which the active phases are drawn.
+const maxFinished = 5
+
+// Tracker tracks the progress of a single phase (ie: adding the addresses of
+// a section). It is safe for concurrent use.
+type Tracker struct {
+	// The name of the phase.
+	Name string
+
+	// The total number of records in the phase.
+	Total int
+
+	done     int64
+	finished int32
+	started  time.Time
+	current  atomic.Value
+}
+
+// Add records n more records as done.
+func (t *Tracker) Add(n int) {
+	atomic.AddInt64(&t.done, int64(n))
+}
+
+// SetCurrent records the record that the phase is working on (ie: an IP
+// address), which is shown on the full-screen display.
+func (t *Tracker) SetCurrent(record string) {
+	t.current.Store(record)
+}
+
+// Current returns the record that the phase is working on, if any.
+func (t *Tracker) Current() string {
+	v, _ := t.current.Load().(string)
+	return v
+}
+
+// Finish marks the phase as finished.
+func (t *Tracker) Finish() {
+	atomic.StoreInt32(&t.finished, 1)
+}
+
+// Finished returns true if the phase has been marked as finished.
+func (t *Tracker) Finished() bool {
+	return atomic.LoadInt32(&t.finished) == 1
+}
+
+// Status returns a line describing the progress of the phase at time now.
+func (t *Tracker) Status(now time.Time) string {
+	done, pct, rate, eta := t.progress(now)
+	return fmt.Sprintf("%s: %d/%d (%.1f%%), %.1f/s, %s", t.Name, done, t.Total, pct, rate, eta)
+}
+
+// progress returns the number of records done at time now, the percentage
+// and rate done, and the estimated time remaining, or the time taken if the
+// phase has finished.
+func (t *Tracker) progress(now time.Time) (done int, pct, rate float64, eta string) {
+	done = int(atomic.LoadInt64(&t.done))
+	elapsed := now.Sub(t.started)
+	if elapsed > 0 {
+		rate = float64(done) / elapsed.Seconds()
+	}
+	if t.Total > 0 {
+		pct = float64(done) / float64(t.Total) * 100
+	}
+
+	switch {
+	case t.Finished():
+		eta = fmt.Sprintf("done in %s", elapsed.Round(time.Second))
+	case rate > 0:
+		d := time.Duration(float64(t.Total-done) / rate * float64(time.Second))
+		eta = fmt.Sprintf("ETA %s", d.Round(time.Second))
+	default:
+		eta = "ETA unknown"
+	}
+	return
+}
+
+// bar returns a progress bar of the phase at time now, width columns wide,
+// followed by its progress.
+func (t *Tracker) bar(now time.Time, width int) string {
+	done, pct, rate, eta := t.progress(now)
+	fill := 0
+	if t.Total > 0 {
+		fill = done * width / t.Total
+	}
+	if fill > width {
+		fill = width
+	}
+	return fmt.Sprintf("[%s%s] %5.1f%%  %d/%d  %.1f/s  %s", strings.Repeat("#", fill), strings.Repeat("-", width-fill), pct, done, t.Total, rate, eta)
+}
+
+// Display periodically writes the status of the active phases. A nil
+// *Display is valid, and tracks progress without displaying it.
+type Display struct {
+	// The writer the display is written to.
+	Out io.Writer
+
+	// How often the display is updated.
+	Interval time.Duration
+
+	// Whether Out is a terminal. On a terminal, the display is redrawn in
+	// place on a single line, otherwise a line is written for each phase at
+	// each update.
+	Terminal bool
+
+	// Whether to take over the terminal with a full-screen display instead
+	// of a single line. The screen shows a progress bar for each phase with
+	// the record it is working on, the lines returned by Stats, and the most
+	// recent lines passed to Log. Implies Terminal.
+	Screen bool
+
+	// The width of the full-screen display, in columns.
+	Width int
+
+	// Called at each update of the full-screen display for extra lines of
+	// status (ie: the error count), if set.
+	Stats func() []string
+
+	// The number of lines passed to Log that are kept for the full-screen
+	// display.
+	LogLines int
+
+	mu       sync.Mutex
+	all      []*Tracker
+	trackers []*Tracker
+	finished []*Tracker
+	logs     []string
+	stop     chan struct{}
+	stopped  chan struct{}
+	now      func() time.Time
+}
+
+// Track starts tracking a new phase with the supplied total number of
+// records.
+func (d *Display) Track(name string, total int) *Tracker {
+	t := &Tracker{
+		Name:    name,
+		Total:   total,
+		started: time.Now(),
+	}
+	if d == nil {
+		return t
+	}
+	d.mu.Lock()
+	d.all = append(d.all, t)
+	d.trackers = append(d.trackers, t)
+	d.mu.Unlock()
+	return t
+}
+
+// Phase is a snapshot of the progress of a phase.
+type Phase struct {
+	Name     string  `json:"name"`
+	Done     int     `json:"done"`
+	Total    int     `json:"total"`
+	Percent  float64 `json:"percent"`
+	Rate     float64 `json:"rate"`
+	ETA      string  `json:"eta"`
+	Current  string  `json:"current,omitempty"`
+	Finished bool    `json:"finished"`
+}
+
+// Phases returns a snapshot of the progress of every phase tracked so far,
+// finished or not, in the order they started.
+func (d *Display) Phases() (out []Phase) {
+	if d == nil {
+		return nil
+	}
+	now := d.time()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.all {
+		done, pct, rate, eta := t.progress(now)
+		p := Phase{Name: t.Name, Done: done, Total: t.Total, Percent: pct, Rate: rate, ETA: eta, Finished: t.Finished()}
+		if !p.Finished {
+			p.Current = t.Current()
+		}
+		out = append(out, p)
+	}
+	return
+}
+
+// Log adds a line to the log shown on the full-screen display, dropping the
+// oldest line if LogLines are already kept.
+func (d *Display) Log(line string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logs = append(d.logs, line)
+	if len(d.logs) > d.LogLines {
+		d.logs = d.logs[len(d.logs)-d.LogLines:]
+	}
+}
+
+// Start starts updating the display every Interval, until Stop is called.
+// The full-screen display switches the terminal to its alternate screen, and
+// hides the cursor.
+func (d *Display) Start() {
+	if d == nil {
+		return
+	}
+	if d.Screen {
+		fmt.Fprint(d.Out, "\033[?1049h\033[?25l")
+	}
+	d.stop = make(chan struct{})
+	d.stopped = make(chan struct{})
+	go func() {
+		defer close(d.stopped)
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.render()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops updating the display, after a final update. The full-screen
+// display restores the terminal's screen, and writes the final status of each
+// phase to it.
+func (d *Display) Stop() {
+	if d == nil || d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.stopped
+	d.stop = nil
+	d.render()
+	switch {
+	case d.Screen:
+		fmt.Fprint(d.Out, "\033[?25h\033[?1049l")
+		now := d.time()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for _, t := range append(d.finished, d.trackers...) {
+			fmt.Fprintf(d.Out, "progress: %s\n", t.Status(now))
+		}
+	case d.Terminal:
+		fmt.Fprintln(d.Out)
+	}
+}
+
+// time returns the current time.
+func (d *Display) time() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
+
+// render writes the status of the active phases. Phases that have finished
+// are written one last time, and then removed.
+func (d *Display) render() {
+	now := d.time()
+	var stats []string
+	if d.Screen && d.Stats != nil {
+		stats = d.Stats()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.Screen {
+		// Move to the top left, clear the screen, and redraw it.
+		fmt.Fprintf(d.Out, "\033[H\033[J%s", d.screen(now, stats))
+		var active []*Tracker
+		for _, t := range d.trackers {
+			if t.Finished() {
+				d.finished = append(d.finished, t)
+			} else {
+				active = append(active, t)
+			}
+		}
+		d.trackers = active
+		return
+	}
+	var lines []string
+	var active []*Tracker
+	for _, t := range d.trackers {
+		lines = append(lines, t.Status(now))
+		if !t.Finished() {
+			active = append(active, t)
+		}
+	}
+	d.trackers = active
+	if len(lines) == 0 {
+		return
+	}
+
+	if d.Terminal {
+		// Clear the line and redraw it in place.
+		fmt.Fprintf(d.Out, "\r\033[K%s", strings.Join(lines, " | "))
+		return
+	}
+	for _, v := range lines {
+		fmt.Fprintf(d.Out, "progress: %s\n", v)
+	}
+}
+
+// screen returns the full-screen display at time now, with the extra status
+// lines in stats. Each line is cut to Width, so that it does not wrap.
+func (d *Display) screen(now time.Time, stats []string) string {
+	shown := d.finished
+	if len(shown) > maxFinished {
+		shown = shown[len(shown)-maxFinished:]
+	}
+	shown = append(shown[:len(shown):len(shown)], d.trackers...)
+	nameWidth := 0
+	for _, t := range shown {
+		if len(t.Name) > nameWidth {
+			nameWidth = len(t.Name)
+		}
+	}
+	barWidth := d.Width / 3
+	if barWidth < 10 {
+		barWidth = 10
+	}
+	if barWidth > 50 {
+		barWidth = 50
+	}
+
+	lines := []string{"Migration progress", ""}
+	if len(shown) == 0 {
+		lines = append(lines, "Waiting for the first phase to start...")
+	}
+	for _, t := range shown {
+		lines = append(lines, fmt.Sprintf("%-*s %s", nameWidth, t.Name, t.bar(now, barWidth)))
+		if c := t.Current(); c != "" && !t.Finished() {
+			lines = append(lines, fmt.Sprintf("%-*s   at %s", nameWidth, "", c))
+		}
+	}
+	if len(stats) > 0 {
+		lines = append(append(lines, ""), stats...)
+	}
+	if len(d.logs) > 0 {
+		lines = append(append(lines, "", "Recent log messages:"), d.logs...)
+	}
+
+	var b strings.Builder
+	for _, v := range lines {
+		if r := []rune(v); d.Width > 0 && len(r) > d.Width {
+			v = string(r[:d.Width])
+		}
+		b.WriteString(v)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
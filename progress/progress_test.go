@@ -0,0 +1,124 @@
+package progress
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackerStatus(t *testing.T) {
+	start := time.Unix(0, 0)
+	tr := &Tracker{Name: "addresses", Total: 100, started: start}
+	if expected, actual := "addresses: 0/100 (0.0%), 0.0/s, ETA unknown", tr.Status(start); expected != actual {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+	tr.Add(25)
+	if expected, actual := "addresses: 25/100 (25.0%), 2.5/s, ETA 30s", tr.Status(start.Add(10*time.Second)); expected != actual {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+	tr.Add(75)
+	tr.Finish()
+	if expected, actual := "addresses: 100/100 (100.0%), 2.5/s, done in 40s", tr.Status(start.Add(40*time.Second)); expected != actual {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+}
+
+func TestDisplayRender(t *testing.T) {
+	var b bytes.Buffer
+	d := &Display{Out: &b, now: time.Now}
+	vlans := d.Track("vlans", 2)
+	d.Track("subnets", 4)
+	vlans.Add(2)
+	vlans.Finish()
+
+	d.render()
+	d.render()
+	if n := bytes.Count(b.Bytes(), []byte("progress: vlans")); n != 1 {
+		t.Fatalf("Expected finished phase to be rendered once, got %d times:\n%s", n, b.String())
+	}
+	if n := bytes.Count(b.Bytes(), []byte("progress: subnets")); n != 2 {
+		t.Fatalf("Expected active phase to be rendered twice, got %d times:\n%s", n, b.String())
+	}
+
+	var nilDisplay *Display
+	tr := nilDisplay.Track("addresses", 1)
+	tr.Add(1)
+	nilDisplay.Start()
+	nilDisplay.Stop()
+}
+
+func TestDisplayScreen(t *testing.T) {
+	start := time.Unix(0, 0)
+	now := start.Add(10 * time.Second)
+	var b bytes.Buffer
+	d := &Display{Out: &b, Screen: true, Width: 80, LogLines: 2, now: func() time.Time { return now }}
+	d.Stats = func() []string { return []string{"Errors: 1"} }
+	vlans := d.Track("vlans", 2)
+	vlans.started = start
+	vlans.Add(2)
+	vlans.Finish()
+	addrs := d.Track("addresses", 100)
+	addrs.started = start
+	addrs.Add(25)
+	addrs.SetCurrent("10.0.0.25")
+	d.Log("first")
+	d.Log("second")
+	d.Log("third")
+
+	d.render()
+	expected := "\033[H\033[J" + `Migration progress
+
+vlans     [##########################] 100.0%  2/2  0.2/s  done in 10s
+addresses [######--------------------]  25.0%  25/100  2.5/s  ETA 30s
+            at 10.0.0.25
+
+Errors: 1
+
+Recent log messages:
+second
+third
+`
+	if b.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, b.String())
+	}
+	if len(d.trackers) != 1 || len(d.finished) != 1 {
+		t.Fatalf("Expected the finished phase to be kept apart from the active one, got %d active and %d finished", len(d.trackers), len(d.finished))
+	}
+
+	// Lines are cut to the width of the screen.
+	d.Width = 20
+	if line := strings.Split(d.screen(now, nil), "\n")[2]; line != "vlans     [#########" {
+		t.Fatalf("Expected line cut to 20 columns, got %q", line)
+	}
+}
+
+func TestDisplayPhases(t *testing.T) {
+	start := time.Unix(0, 0)
+	d := &Display{Out: &bytes.Buffer{}, now: func() time.Time { return start.Add(10 * time.Second) }}
+	vlans := d.Track("vlans", 2)
+	vlans.started = start
+	vlans.Add(2)
+	vlans.SetCurrent("VLAN 200")
+	vlans.Finish()
+	addrs := d.Track("addresses", 100)
+	addrs.started = start
+	addrs.Add(25)
+	addrs.SetCurrent("10.0.0.25")
+
+	// Finished phases are still returned after they are rendered for the
+	// last time.
+	d.render()
+	d.render()
+	expected := []Phase{
+		{Name: "vlans", Done: 2, Total: 2, Percent: 100, Rate: 0.2, ETA: "done in 10s", Finished: true},
+		{Name: "addresses", Done: 25, Total: 100, Percent: 25, Rate: 2.5, ETA: "ETA 30s", Current: "10.0.0.25"},
+	}
+	if actual := d.Phases(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if phases := (*Display)(nil).Phases(); phases != nil {
+		t.Fatalf("Expected no phases from a nil display, got %#v", phases)
+	}
+}
@@ -0,0 +1,85 @@
+// Package ratelimit provides a token bucket rate limiter, used to throttle
+// requests to the PHPIPAM API so that small instances are not overwhelmed.
+package ratelimit
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter. The bucket holds up to Burst tokens,
+// and is refilled at Rate tokens per second. It is safe for concurrent use.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// now and sleep are replaced in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// New returns a Limiter allowing rate events per second, with bursts of up to
+// burst events. The bucket starts full. A burst of less than 1 is treated as 1.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Wait blocks until a token is available, and takes it.
+func (l *Limiter) Wait() {
+	l.mu.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	// Take the token now, even if the bucket is empty. Going into debt
+	// reserves the next token for this caller, so that concurrent callers
+	// queue up behind each other rather than all waking at once.
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		l.sleep(wait)
+	}
+}
+
+// Transport is a http.RoundTripper that waits on Limiter before sending each
+// request.
+type Transport struct {
+	// The underlying transport. http.DefaultTransport is used if this is nil.
+	Transport http.RoundTripper
+
+	// The limiter to wait on.
+	Limiter *Limiter
+}
+
+// RoundTrip implements http.RoundTripper for Transport.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t.Limiter.Wait()
+	return rt.RoundTrip(req)
+}
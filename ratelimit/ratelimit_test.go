@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimiterWait(t *testing.T) {
+	now := time.Unix(0, 0)
+	var sleeps []time.Duration
+	l := New(2, 2)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+	}
+
+	// The first 2 events use the burst, and the next 2 wait for a token each.
+	for i := 0; i < 4; i++ {
+		l.Wait()
+	}
+	expected := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+	if !reflect.DeepEqual(expected, sleeps) {
+		t.Fatalf("Expected sleeps %v, got %v", expected, sleeps)
+	}
+
+	// After idling, the bucket refills up to the burst, but no further.
+	sleeps = nil
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		l.Wait()
+	}
+	expected = []time.Duration{500 * time.Millisecond}
+	if !reflect.DeepEqual(expected, sleeps) {
+		t.Fatalf("Expected sleeps %v after idling, got %v", expected, sleeps)
+	}
+}
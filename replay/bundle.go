@@ -0,0 +1,227 @@
+// Package replay provides record and replay functionality for the migrator.
+//
+// In record mode, every legacy database query result and every PHPIPAM API
+// exchange made during a run is captured into a bundle that is written to disk
+// when the run finishes (successfully or not). In replay mode, the same bundle
+// is used to serve both the database and the API, allowing the migration
+// pipeline to be re-executed offline against the exact data that a user
+// encountered.
+//
+// Replay tolerates API requests whose bodies differ from the recording, so
+// that changes to the transformation logic can be validated offline: each is
+// served the response recorded for the same method and URL, and reported as a
+// Diff.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Query represents a single recorded legacy database query and its result
+// set.
+type Query struct {
+	// The SQL query text.
+	SQL string `json:"sql"`
+
+	// The query arguments, in string form.
+	Args []string `json:"args,omitempty"`
+
+	// The column names of the result set.
+	Columns []string `json:"columns"`
+
+	// The rows of the result set. NULL values are represented by nil.
+	Rows [][]*string `json:"rows"`
+
+	// The error message, if the query failed.
+	Error string `json:"error,omitempty"`
+
+	// Whether or not this query has been consumed during replay.
+	used bool
+}
+
+// Exchange represents a single recorded HTTP request/response pair with the
+// PHPIPAM API.
+type Exchange struct {
+	// The HTTP request method.
+	Method string `json:"method"`
+
+	// The full request URL.
+	URL string `json:"url"`
+
+	// The request body.
+	RequestBody string `json:"request_body,omitempty"`
+
+	// The HTTP response status code.
+	StatusCode int `json:"status_code"`
+
+	// The HTTP response status line.
+	Status string `json:"status"`
+
+	// The response body.
+	ResponseBody string `json:"response_body"`
+
+	// Whether or not this exchange has been consumed during replay.
+	used bool
+}
+
+// Bundle represents a full recording of a migration run.
+type Bundle struct {
+	// The PHPIPAM API endpoint the recording was made against.
+	Endpoint string `json:"endpoint"`
+
+	// The PHPIPAM application ID the recording was made with.
+	AppID string `json:"app_id"`
+
+	// The recorded database queries, in the order they were run.
+	Queries []*Query `json:"queries"`
+
+	// The recorded API exchanges, in the order they were made.
+	Exchanges []*Exchange `json:"exchanges"`
+
+	// The API requests whose bodies differed from the recording during
+	// replay.
+	diffs []Diff
+
+	mu sync.Mutex
+}
+
+// Diff is an API request made during replay whose body differs from the
+// recorded one, such as a subnet created with a different description after
+// a change to the transformation logic.
+type Diff struct {
+	// The HTTP request method and full request URL.
+	Method string
+	URL    string
+
+	// The recorded and replayed request bodies.
+	Recorded string
+	Replayed string
+}
+
+// String implements fmt.Stringer for Diff.
+func (d Diff) String() string {
+	return fmt.Sprintf("%s %s: recorded %s, replayed %s", d.Method, d.URL, oneLine(d.Recorded), oneLine(d.Replayed))
+}
+
+// oneLine returns s with its newlines replaced by spaces, or (empty) if it is
+// blank.
+func oneLine(s string) string {
+	if s == "" {
+		return "(empty)"
+	}
+	return strings.Replace(s, "\n", " ", -1)
+}
+
+// NewBundle creates a new, empty bundle for recording.
+func NewBundle(endpoint, appID string) *Bundle {
+	return &Bundle{
+		Endpoint: endpoint,
+		AppID:    appID,
+	}
+}
+
+// LoadBundle loads a previously recorded bundle from path.
+func LoadBundle(path string) (*Bundle, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out Bundle
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("error parsing replay bundle %s: %s", path, err)
+	}
+	return &out, nil
+}
+
+// Save writes the bundle to path as JSON.
+func (b *Bundle) Save(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// addQuery appends a recorded query to the bundle.
+func (b *Bundle) addQuery(q *Query) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Queries = append(b.Queries, q)
+}
+
+// addExchange appends a recorded API exchange to the bundle.
+func (b *Bundle) addExchange(e *Exchange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Exchanges = append(b.Exchanges, e)
+}
+
+// nextQuery finds the first unconsumed query in the bundle matching the SQL
+// text and arguments, and marks it as consumed.
+func (b *Bundle) nextQuery(sql string, args []string) (*Query, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range b.Queries {
+		if q.used || q.SQL != sql || !equalStrings(q.Args, args) {
+			continue
+		}
+		q.used = true
+		return q, nil
+	}
+	return nil, fmt.Errorf("no recorded result for query: %s", sql)
+}
+
+// nextExchange finds the first unconsumed API exchange in the bundle matching
+// the method, URL, and request body, and marks it as consumed. If there is
+// none, the first unconsumed exchange matching the method and URL is used
+// instead, and the difference in the request bodies is recorded as a Diff.
+func (b *Bundle) nextExchange(method, url, body string) (*Exchange, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var fallback *Exchange
+	for _, e := range b.Exchanges {
+		if e.used || e.Method != method || e.URL != url {
+			continue
+		}
+		if e.RequestBody == body {
+			e.used = true
+			return e, nil
+		}
+		if fallback == nil {
+			fallback = e
+		}
+	}
+	if fallback == nil {
+		return nil, fmt.Errorf("no recorded response for %s %s", method, url)
+	}
+	fallback.used = true
+	b.diffs = append(b.diffs, Diff{Method: method, URL: url, Recorded: fallback.RequestBody, Replayed: body})
+	return fallback, nil
+}
+
+// Diffs returns the API requests whose bodies differed from the recording
+// during replay, in the order they were made.
+func (b *Bundle) Diffs() []Diff {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Diff(nil), b.diffs...)
+}
+
+// equalStrings compares two string slices for equality.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
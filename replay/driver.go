@@ -0,0 +1,235 @@
+package replay
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// RecordingDriver is a database/sql driver that wraps another driver,
+// recording the results of all queries into a Bundle.
+type RecordingDriver struct {
+	// The driver to wrap.
+	Driver driver.Driver
+
+	// The bundle to record to.
+	Bundle *Bundle
+}
+
+// Open implements driver.Driver.Open for RecordingDriver.
+func (d *RecordingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, bundle: d.Bundle}, nil
+}
+
+// recordingConn wraps a driver.Conn, recording query results.
+type recordingConn struct {
+	driver.Conn
+	bundle *Bundle
+}
+
+// Prepare implements driver.Conn.Prepare for recordingConn.
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingStmt{Stmt: stmt, query: query, bundle: c.bundle}, nil
+}
+
+// Query implements driver.Queryer.Query for recordingConn.
+func (c *recordingConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := q.Query(query, args)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	return record(c.bundle, query, args, rows, err)
+}
+
+// recordingStmt wraps a driver.Stmt, recording query results.
+type recordingStmt struct {
+	driver.Stmt
+	query  string
+	bundle *Bundle
+}
+
+// Query implements driver.Stmt.Query for recordingStmt.
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.Stmt.Query(args)
+	return record(s.bundle, s.query, args, rows, err)
+}
+
+// record reads rows in full, saves them to the bundle, and returns an
+// in-memory copy of the result set for the caller to consume.
+func record(b *Bundle, query string, args []driver.Value, rows driver.Rows, err error) (driver.Rows, error) {
+	q := &Query{
+		SQL:  query,
+		Args: valuesToStrings(args),
+	}
+	if err != nil {
+		q.Error = err.Error()
+		b.addQuery(q)
+		return nil, err
+	}
+	defer rows.Close()
+
+	q.Columns = rows.Columns()
+	for {
+		dest := make([]driver.Value, len(q.Columns))
+		if err := rows.Next(dest); err != nil {
+			if err == io.EOF {
+				break
+			}
+			q.Error = err.Error()
+			b.addQuery(q)
+			return nil, err
+		}
+		row := make([]*string, len(dest))
+		for i, v := range dest {
+			if v != nil {
+				s := valueToString(v)
+				row[i] = &s
+			}
+		}
+		q.Rows = append(q.Rows, row)
+	}
+	b.addQuery(q)
+	return &memRows{query: q}, nil
+}
+
+// Driver is a database/sql driver that serves query results out of a
+// previously recorded Bundle. The data source name is ignored.
+type Driver struct {
+	// The bundle to replay from.
+	Bundle *Bundle
+}
+
+// Open implements driver.Driver.Open for Driver.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	return &replayConn{bundle: d.Bundle}, nil
+}
+
+// replayConn is a driver.Conn that serves recorded query results.
+type replayConn struct {
+	bundle *Bundle
+}
+
+// Prepare implements driver.Conn.Prepare for replayConn.
+func (c *replayConn) Prepare(query string) (driver.Stmt, error) {
+	return &replayStmt{query: query, bundle: c.bundle}, nil
+}
+
+// Close implements driver.Conn.Close for replayConn.
+func (c *replayConn) Close() error {
+	return nil
+}
+
+// Begin implements driver.Conn.Begin for replayConn. Transactions are not
+// supported in replay mode.
+func (c *replayConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported in replay mode")
+}
+
+// Query implements driver.Queryer.Query for replayConn.
+func (c *replayConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return replayQuery(c.bundle, query, args)
+}
+
+// replayStmt is a driver.Stmt that serves recorded query results.
+type replayStmt struct {
+	query  string
+	bundle *Bundle
+}
+
+// Close implements driver.Stmt.Close for replayStmt.
+func (s *replayStmt) Close() error {
+	return nil
+}
+
+// NumInput implements driver.Stmt.NumInput for replayStmt.
+func (s *replayStmt) NumInput() int {
+	return -1
+}
+
+// Exec implements driver.Stmt.Exec for replayStmt. Writes are not supported
+// in replay mode.
+func (s *replayStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("statement execution is not supported in replay mode")
+}
+
+// Query implements driver.Stmt.Query for replayStmt.
+func (s *replayStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return replayQuery(s.bundle, s.query, args)
+}
+
+// replayQuery looks up the next recorded result for query and args.
+func replayQuery(b *Bundle, query string, args []driver.Value) (driver.Rows, error) {
+	q, err := b.nextQuery(query, valuesToStrings(args))
+	if err != nil {
+		return nil, err
+	}
+	if q.Error != "" {
+		return nil, errors.New(q.Error)
+	}
+	return &memRows{query: q}, nil
+}
+
+// memRows is a driver.Rows implementation over a recorded result set.
+type memRows struct {
+	query *Query
+	pos   int
+}
+
+// Columns implements driver.Rows.Columns for memRows.
+func (r *memRows) Columns() []string {
+	return r.query.Columns
+}
+
+// Close implements driver.Rows.Close for memRows.
+func (r *memRows) Close() error {
+	return nil
+}
+
+// Next implements driver.Rows.Next for memRows.
+func (r *memRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.query.Rows) {
+		return io.EOF
+	}
+	for i, v := range r.query.Rows[r.pos] {
+		if v == nil {
+			dest[i] = nil
+			continue
+		}
+		dest[i] = []byte(*v)
+	}
+	r.pos++
+	return nil
+}
+
+// valueToString converts a driver.Value to its string form.
+func valueToString(v driver.Value) string {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+// valuesToStrings converts a slice of driver.Value to strings.
+func valuesToStrings(vs []driver.Value) (out []string) {
+	for _, v := range vs {
+		out = append(out, valueToString(v))
+	}
+	return
+}
@@ -0,0 +1,123 @@
+package replay
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransportRecordReplay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"code":200,"success":true,"data":"` + r.URL.Path + string(b) + `"}`))
+	}))
+	defer ts.Close()
+
+	b := NewBundle(ts.URL, "test")
+	rec := &http.Client{Transport: &RecordingTransport{Bundle: b}}
+	resp, err := rec.Post(ts.URL+"/test/vlans/", "application/json", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatalf("Error making recorded request: %s", err)
+	}
+	expected, _ := ioutil.ReadAll(resp.Body)
+
+	path := filepath.Join(t.TempDir(), "bundle.json")
+	if err := b.Save(path); err != nil {
+		t.Fatalf("Error saving bundle: %s", err)
+	}
+	loaded, err := LoadBundle(path)
+	if err != nil {
+		t.Fatalf("Error loading bundle: %s", err)
+	}
+	ts.Close()
+
+	rep := &http.Client{Transport: &Transport{Bundle: loaded}}
+	resp, err = rep.Post(ts.URL+"/test/vlans/", "application/json", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatalf("Error making replayed request: %s", err)
+	}
+	actual, _ := ioutil.ReadAll(resp.Body)
+	if string(expected) != string(actual) {
+		t.Fatalf("Expected %s, got %s", expected, actual)
+	}
+
+	if _, err := rep.Post(ts.URL+"/test/vlans/", "application/json", strings.NewReader("foo")); err == nil {
+		t.Fatal("Expected error replaying already consumed exchange, got none")
+	}
+}
+
+func TestTransportReplayDiffs(t *testing.T) {
+	b := &Bundle{
+		Exchanges: []*Exchange{
+			{Method: "POST", URL: "http://ipam/test/subnets/", RequestBody: `{"description":"one"}`, StatusCode: 201, ResponseBody: "first"},
+			{Method: "POST", URL: "http://ipam/test/subnets/", RequestBody: `{"description":"two"}`, StatusCode: 201, ResponseBody: "second"},
+		},
+	}
+	rep := &http.Client{Transport: &Transport{Bundle: b}}
+	for _, v := range []struct {
+		body, expected string
+	}{
+		{`{"description":"two"}`, "second"},
+		{`{"description":"changed"}`, "first"},
+	} {
+		resp, err := rep.Post("http://ipam/test/subnets/", "application/json", strings.NewReader(v.body))
+		if err != nil {
+			t.Fatalf("Error making replayed request: %s", err)
+		}
+		if actual, _ := ioutil.ReadAll(resp.Body); string(actual) != v.expected {
+			t.Fatalf("Expected %s for %s, got %s", v.expected, v.body, actual)
+		}
+	}
+
+	expected := []Diff{{Method: "POST", URL: "http://ipam/test/subnets/", Recorded: `{"description":"one"}`, Replayed: `{"description":"changed"}`}}
+	if actual := b.Diffs(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+	if _, err := rep.Post("http://ipam/test/subnets/", "application/json", strings.NewReader("{}")); err == nil {
+		t.Fatal("Expected error replaying request with no recorded exchanges left, got none")
+	}
+}
+
+func TestDriverReplay(t *testing.T) {
+	one, two := "1", "foo"
+	b := &Bundle{
+		Queries: []*Query{
+			{
+				SQL:     "select number, name from vlans",
+				Columns: []string{"number", "name"},
+				Rows:    [][]*string{{&one, &two}, {&one, nil}},
+			},
+		},
+	}
+	sql.Register("replay-test", &Driver{Bundle: b})
+	db, err := sql.Open("replay-test", "")
+	if err != nil {
+		t.Fatalf("Error opening replay DB: %s", err)
+	}
+
+	rows, err := db.Query("select number, name from vlans")
+	if err != nil {
+		t.Fatalf("Error running query: %s", err)
+	}
+	defer rows.Close()
+	var count int
+	for rows.Next() {
+		var number int
+		var name sql.NullString
+		if err := rows.Scan(&number, &name); err != nil {
+			t.Fatalf("Error scanning row: %s", err)
+		}
+		if number != 1 {
+			t.Fatalf("Expected number to be 1, got %d", number)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 rows, got %d", count)
+	}
+}
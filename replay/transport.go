@@ -0,0 +1,100 @@
+package replay
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// RecordingTransport is a http.RoundTripper that wraps another
+// RoundTripper, recording all requests and responses into a Bundle.
+type RecordingTransport struct {
+	// The transport to wrap. http.DefaultTransport is used if this is nil.
+	Transport http.RoundTripper
+
+	// The bundle to record to.
+	Bundle *Bundle
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip for RecordingTransport.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	t.Bundle.addExchange(&Exchange{
+		Method:       req.Method,
+		URL:          req.URL.String(),
+		RequestBody:  reqBody,
+		StatusCode:   resp.StatusCode,
+		Status:       resp.Status,
+		ResponseBody: string(respBody),
+	})
+	return resp, nil
+}
+
+// Transport is a http.RoundTripper that serves responses out of a previously
+// recorded Bundle. No network connections are made.
+type Transport struct {
+	// The bundle to replay from.
+	Bundle *Bundle
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip for Transport.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	e, err := t.Bundle.nextExchange(req.Method, req.URL.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	status := e.Status
+	if status == "" {
+		status = strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+	}
+	return &http.Response{
+		Status:        status,
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(bytes.NewBufferString(e.ResponseBody)),
+		ContentLength: int64(len(e.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// readRequestBody reads the body of req, restoring it so that it can be sent
+// afterwards.
+func readRequestBody(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return string(b), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/notify"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// runStarted is the time the run started.
+	runStarted = time.Now()
+
+	// completedRuns holds the section runs once they have completed, for the
+	// final report.
+	completedRuns []*sectionRun
+
+	// fatalMessage holds the message that the run failed with, captured by
+	// fatalHook.
+	fatalMessage string
+
+	// reportOnce ensures that only one report is sent, even if the run fails
+	// while sending it.
+	reportOnce sync.Once
+)
+
+// fatalHook is a logrus hook that captures the message of a fatal error, so
+// that it can be included in the report sent when the run fails.
+type fatalHook struct{}
+
+// Levels implements logrus.Hook for fatalHook.
+func (fatalHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.FatalLevel}
+}
+
+// Fire implements logrus.Hook for fatalHook.
+func (fatalHook) Fire(e *logrus.Entry) error {
+	fatalMessage = e.Message
+	return nil
+}
+
+// setupNotify arranges for the final report to be sent to notifyURL if the
+// run fails. Reports for successful runs are sent with sendReport.
+func setupNotify() {
+	logrus.AddHook(fatalHook{})
+	logrus.RegisterExitHandler(func() {
+		sendReport(notify.StatusFailed, fatalMessage)
+	})
+}
+
+// runReport returns the report of the run so far, with the supplied status
+// and error message. Sections are only reported once they have completed.
+func runReport(status, errMsg string) *notify.Report {
+	finished := time.Now()
+	r := &notify.Report{
+		Status:   status,
+		Error:    errMsg,
+		Started:  runStarted,
+		Finished: finished,
+		Duration: finished.Sub(runStarted).Seconds(),
+		Counts:   make(map[string]map[string]int),
+	}
+	for _, entity := range []string{"vlans", "vrfs", "device_types", "devices", "subnets", "addresses", "requests", "changelog", "groups", "users"} {
+		for _, result := range []string{"migrated", "error", "skipped", "merged", "updated"} {
+			if n := int(recordsTotal.Value(entity, result)); n > 0 {
+				if r.Counts[entity] == nil {
+					r.Counts[entity] = make(map[string]int)
+				}
+				r.Counts[entity][result] = n
+			}
+		}
+	}
+	for _, s := range completedRuns {
+		sr := notify.SectionReport{
+			LegacyID:       s.LegacyID,
+			ID:             s.ID,
+			Status:         notify.StatusSucceeded,
+			SubnetsAdded:   s.SubnetsAdded,
+			AddressesAdded: s.AddressesAdded,
+			Errors:         len(s.Errors),
+		}
+		if s.Err != nil {
+			sr.Status = notify.StatusFailed
+			sr.Error = s.Err.Error()
+		}
+		r.Sections = append(r.Sections, sr)
+	}
+	return r
+}
+
+// sendReport sends the final report of the run to notifyURL, with the supplied
+// status and error message. Failures to send the report are logged, but do
+// not fail the run.
+func sendReport(status, errMsg string) {
+	if notifyURL == "" {
+		return
+	}
+	reportOnce.Do(func() {
+		r := runReport(status, errMsg)
+
+		// http.DefaultTransport is set up for the PHPIPAM API, so use a
+		// transport of our own.
+		c := &notify.Client{
+			URL: notifyURL,
+			HTTPClient: &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+			},
+		}
+		if err := c.Send(r); err != nil {
+			logrus.Errorf("Error sending report to -notify-url: %s", err)
+			return
+		}
+		logrus.Infof("Sent %s report to -notify-url", status)
+	})
+}
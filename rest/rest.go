@@ -0,0 +1,164 @@
+// Package rest is a minimal client for the REST APIs of NetBox and Nautobot,
+// which share the same conventions: token authentication, JSON bodies, and
+// lists paginated with a link to the next page.
+//
+// Unsuccessful responses are returned as errors in the same form as the
+// PHPIPAM SDK's, so that retry.IsTransient recognizes them, and the same retry
+// policy applies to every API the migrator talks to.
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+)
+
+// Client sends requests to a REST API.
+type Client struct {
+	// The base URL of the API's instance (ie: https://netbox.example.com).
+	URL string
+
+	// The API token to authenticate with.
+	Token string
+
+	// The HTTP client that requests are sent with.
+	HTTPClient *http.Client
+
+	// The policy used to retry requests that fail with transient errors.
+	Retry retry.Policy
+}
+
+// New returns a new Client for the instance at baseURL, authenticating with
+// token, and retrying requests as per policy. Requests are sent with
+// http.DefaultClient, so that they go through http.DefaultTransport.
+func New(baseURL, token string, policy retry.Policy) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+		Retry:      policy,
+	}
+}
+
+// Do sends a request to path, which is relative to the base URL unless it is
+// a full URL, retrying it as per the policy. body is encoded as JSON if it is
+// not nil, and the response is decoded into out if it is not nil. op
+// describes the operation in log messages.
+//
+// POSTs are not retried, as they are not idempotent: one that failed with a
+// timeout or a server error may have been committed anyway. Use Create to
+// create objects with retries.
+func (c *Client) Do(op, method, path string, body, out interface{}) error {
+	if method == "POST" {
+		return c.send(method, path, body, out)
+	}
+	return c.Retry.Do(op, func() error {
+		return c.send(method, path, body, out)
+	})
+}
+
+// Create POSTs body to the list at path, and decodes the created object into
+// out if it is not nil, retrying it as per the policy with retry.DoCreate.
+// query selects the object in the list (ie: {"name": {"customers"}}): before
+// each retry, the list is checked for it, and if it was created despite the
+// error, it is decoded into out rather than created again.
+func (c *Client) Create(op, path string, body interface{}, query url.Values, out interface{}) error {
+	return c.Retry.DoCreate(op, func() error {
+		return c.send("POST", path, body, out)
+	}, func() (bool, error) {
+		var p page
+		if err := c.Do(op, "GET", withQuery(path, query), nil, &p); err != nil {
+			return false, err
+		}
+		if len(p.Results) == 0 {
+			return false, nil
+		}
+		if out == nil {
+			return true, nil
+		}
+		return true, json.Unmarshal(p.Results[0], out)
+	})
+}
+
+// withQuery returns path with query appended, if it is not empty.
+func withQuery(path string, query url.Values) string {
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// send sends a request once.
+func (c *Client) send(method, path string, body, out interface{}) error {
+	u := path
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		u = c.URL + path
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+c.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP protocol error: %s", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("HTTP protocol error: %s", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Error from API (%d): %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// page is a page of a list.
+type page struct {
+	Next    string            `json:"next"`
+	Results []json.RawMessage `json:"results"`
+}
+
+// List GETs all of the objects at path matching query, following the pages
+// of the list, and calls f with each one. op describes the operation in log
+// messages.
+func (c *Client) List(op, path string, query url.Values, f func(json.RawMessage) error) error {
+	next := withQuery(path, query)
+	for next != "" {
+		var p page
+		if err := c.Do(op, "GET", next, nil, &p); err != nil {
+			return err
+		}
+		for _, v := range p.Results {
+			if err := f(v); err != nil {
+				return err
+			}
+		}
+		next = p.Next
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/retry"
+)
+
+func TestList(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "":
+			fmt.Fprintf(w, `{"next":"%s/api/things/?page=2","results":[{"id":1},{"id":2}]}`, ts.URL)
+		case "2":
+			w.Write([]byte(`{"next":null,"results":[{"id":3}]}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("down\n"))
+		}
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL+"/", "secret", retry.Policy{})
+	var ids []int
+	err := c.List("listing things", "/api/things/", nil, func(raw json.RawMessage) error {
+		var v struct{ ID int }
+		err := json.Unmarshal(raw, &v)
+		ids = append(ids, v.ID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error listing: %s", err)
+	}
+	if expected := []int{1, 2, 3}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("Expected IDs %v, got %v", expected, ids)
+	}
+
+	err = c.List("listing things", "/api/things/", url.Values{"page": {"3"}}, func(json.RawMessage) error { return nil })
+	if expected := "Error from API (503): down"; err == nil || err.Error() != expected || !retry.IsTransient(err) {
+		t.Fatalf("Expected transient error %q, got %v", expected, err)
+	}
+	if err := New(ts.URL, "wrong", retry.Policy{}).Do("getting", "GET", "/api/things/", nil, nil); err == nil || retry.IsTransient(err) {
+		t.Fatalf("Expected permanent error with wrong token, got %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	// The first POST of each thing is committed but fails, as if it timed
+	// out, and POSTs of broken ones fail without being committed.
+	var created []string
+	posts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if r.Method == "POST" {
+			posts++
+			var in struct{ Name string }
+			json.NewDecoder(r.Body).Decode(&in)
+			if in.Name != "broken" {
+				created = append(created, in.Name)
+			}
+			if posts == 1 || in.Name == "broken" {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprintf(w, `{"id":%d}`, len(created))
+			return
+		}
+		for i, v := range created {
+			if v == name {
+				fmt.Fprintf(w, `{"results":[{"id":%d}]}`, i+1)
+				return
+			}
+		}
+		w.Write([]byte(`{"results":[]}`))
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL, "secret", retry.Policy{Retries: 2, Sleep: func(time.Duration) {}})
+	var out struct{ ID int }
+	if err := c.Create("creating a", "/api/things/", map[string]string{"name": "a"}, url.Values{"name": {"a"}}, &out); err != nil {
+		t.Fatalf("Error creating a: %s", err)
+	}
+	if err := c.Create("creating b", "/api/things/", map[string]string{"name": "b"}, url.Values{"name": {"b"}}, nil); err != nil {
+		t.Fatalf("Error creating b: %s", err)
+	}
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(expected, created) || out.ID != 1 {
+		t.Fatalf("Expected %v to be created once each, and a to have ID 1, got %v and %d", expected, created, out.ID)
+	}
+	if err := c.Create("creating broken", "/api/things/", map[string]string{"name": "broken"}, url.Values{"name": {"broken"}}, nil); err == nil || posts != 5 {
+		t.Fatalf("Expected broken to be retried twice and fail, got %d POSTs and %v", posts, err)
+	}
+	posts = 0
+	if err := c.Do("posting broken", "POST", "/api/things/", map[string]string{"name": "broken"}, nil); err == nil || posts != 1 {
+		t.Fatalf("Expected a POST not to be retried by Do, got %d POSTs and %v", posts, err)
+	}
+}
@@ -0,0 +1,120 @@
+// Package retry retries operations against the PHPIPAM API that fail with
+// transient errors, backing off exponentially with jitter between attempts.
+package retry
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Policy controls how operations are retried.
+type Policy struct {
+	// The number of times an operation is retried after its first attempt
+	// fails. 0 disables retries.
+	Retries int
+
+	// The delay before the first retry. The delay doubles with each retry, up
+	// to MaxDelay, and a random jitter of up to half the delay is added.
+	BaseDelay time.Duration
+
+	// The maximum delay between retries.
+	MaxDelay time.Duration
+
+	// The function used to sleep between attempts. time.Sleep is used if this
+	// is nil.
+	Sleep func(time.Duration)
+}
+
+// Do runs f, retrying it as per the policy if it fails with a transient
+// error. The error from the last attempt is returned. op describes the
+// operation in log messages.
+func (p Policy) Do(op string, f func() error) error {
+	sleep := p.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = f(); err == nil || attempt >= p.Retries || !IsTransient(err) {
+			return err
+		}
+		d := p.delay(attempt)
+		logrus.Warnf("Transient error %s (attempt %d of %d), retrying in %s: %s", op, attempt+1, p.Retries+1, d, err)
+		sleep(d)
+	}
+}
+
+// DoCreate runs create, retrying it as per the policy if it fails with a
+// transient error, as Do does. As creates are not idempotent, a create that
+// failed with a timeout or a server error may have been committed anyway, so
+// before each retry, exists is called to check whether the object was
+// created, and the create succeeds without being retried if it was. If the
+// check fails, the create is not retried, so that no duplicate is created.
+func (p Policy) DoCreate(op string, create func() error, exists func() (bool, error)) error {
+	sleep := p.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = create(); err == nil || attempt >= p.Retries || !IsTransient(err) {
+			return err
+		}
+		d := p.delay(attempt)
+		logrus.Warnf("Transient error %s (attempt %d of %d), checking whether it was created before retrying in %s: %s", op, attempt+1, p.Retries+1, d, err)
+		sleep(d)
+		found, cerr := exists()
+		if cerr != nil {
+			return fmt.Errorf("%s (not retried, as checking whether it was created failed: %s)", err, cerr)
+		}
+		if found {
+			logrus.Infof("Found the object despite the error %s, so it is not created again", op)
+			return nil
+		}
+	}
+}
+
+// delay returns the delay before the retry following the supplied attempt,
+// with jitter applied.
+func (p Policy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d > 0 {
+		d += time.Duration(rand.Int63n(int64(d)/2 + 1))
+	}
+	return d
+}
+
+// apiErrorRegexp matches the status code in errors returned by the PHPIPAM
+// SDK for unsuccessful responses.
+var apiErrorRegexp = regexp.MustCompile(`^Error from API \((\d+)\)`)
+
+// IsTransient returns true if err is an error from the PHPIPAM SDK that is
+// likely to be transient: a protocol error (ie: a timeout or connection
+// reset), a 5xx server error, or a 429 rate limiting response.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "HTTP protocol error") {
+		return true
+	}
+	m := apiErrorRegexp.FindStringSubmatch(msg)
+	if m == nil {
+		return false
+	}
+	code, _ := strconv.Atoi(m[1])
+	return code >= 500 || code == 429
+}
@@ -0,0 +1,131 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	cases := map[string]bool{
+		"HTTP protocol error: net/http: timeout awaiting response headers": true,
+		"Error from API (500): Internal Server Error":                      true,
+		"Error from API (503): Service Unavailable":                        true,
+		"Error from API (429): Too Many Requests":                          true,
+		"Error from API (404): No subnets found":                           false,
+		"Error from API (409): Address already exists":                     false,
+		"Error preparing request data: bad":                                false,
+	}
+	for msg, expected := range cases {
+		if actual := IsTransient(errors.New(msg)); expected != actual {
+			t.Fatalf("Expected IsTransient(%q) to be %t, got %t", msg, expected, actual)
+		}
+	}
+	if IsTransient(nil) {
+		t.Fatal("Expected nil error not to be transient")
+	}
+}
+
+func TestDo(t *testing.T) {
+	var sleeps []time.Duration
+	p := Policy{
+		Retries:   3,
+		BaseDelay: time.Second,
+		MaxDelay:  3 * time.Second,
+		Sleep:     func(d time.Duration) { sleeps = append(sleeps, d) },
+	}
+
+	var calls int
+	err := p.Do("testing", func() error {
+		calls++
+		if calls < 4 {
+			return errors.New("Error from API (502): Bad Gateway")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success after retries, got %s", err)
+	}
+	if calls != 4 {
+		t.Fatalf("Expected 4 calls, got %d", calls)
+	}
+	bounds := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	for i, d := range sleeps {
+		if d < bounds[i] || d > bounds[i]+bounds[i]/2 {
+			t.Fatalf("Expected delay %d to be between %s and %s, got %s", i, bounds[i], bounds[i]+bounds[i]/2, d)
+		}
+	}
+
+	calls = 0
+	if err := p.Do("testing", func() error {
+		calls++
+		return errors.New("Error from API (500): boom")
+	}); err == nil || calls != 4 {
+		t.Fatalf("Expected failure after 4 calls, got %d calls (error: %v)", calls, err)
+	}
+
+	calls = 0
+	if err := p.Do("testing", func() error {
+		calls++
+		return errors.New("Error from API (400): bad request")
+	}); err == nil || calls != 1 {
+		t.Fatalf("Expected permanent error not to be retried, got %d calls (error: %v)", calls, err)
+	}
+}
+
+func TestDoCreate(t *testing.T) {
+	p := Policy{Retries: 3, Sleep: func(time.Duration) {}}
+
+	// The create is committed, but fails with a server error.
+	var calls, checks int
+	err := p.DoCreate("testing", func() error {
+		calls++
+		return errors.New("Error from API (502): Bad Gateway")
+	}, func() (bool, error) {
+		checks++
+		return true, nil
+	})
+	if err != nil || calls != 1 || checks != 1 {
+		t.Fatalf("Expected success after 1 call and 1 check, got %d calls and %d checks (error: %v)", calls, checks, err)
+	}
+
+	// The create is not committed, and succeeds on retry.
+	calls, checks = 0, 0
+	err = p.DoCreate("testing", func() error {
+		if calls++; calls < 3 {
+			return errors.New("HTTP protocol error: timeout")
+		}
+		return nil
+	}, func() (bool, error) {
+		checks++
+		return false, nil
+	})
+	if err != nil || calls != 3 || checks != 2 {
+		t.Fatalf("Expected success after 3 calls and 2 checks, got %d calls and %d checks (error: %v)", calls, checks, err)
+	}
+
+	// The check fails, so the create is not retried.
+	calls = 0
+	err = p.DoCreate("testing", func() error {
+		calls++
+		return errors.New("Error from API (500): boom")
+	}, func() (bool, error) {
+		return false, errors.New("Error from API (500): boom")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("Expected failure after 1 call, got %d calls (error: %v)", calls, err)
+	}
+
+	// Permanent errors are not checked or retried.
+	calls, checks = 0, 0
+	err = p.DoCreate("testing", func() error {
+		calls++
+		return errors.New("Error from API (409): exists")
+	}, func() (bool, error) {
+		checks++
+		return true, nil
+	})
+	if err == nil || calls != 1 || checks != 0 {
+		t.Fatalf("Expected permanent error after 1 call and no checks, got %d calls and %d checks (error: %v)", calls, checks, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reverseDNSWorkers is the number of PTR lookups run concurrently in each
+// section with -reverse-dns.
+const reverseDNSWorkers = 16
+
+var (
+	// reverseDNS fills in the blank hostnames of the migrated addresses from
+	// their PTR records.
+	reverseDNS bool
+
+	// reverseDNSServer is the DNS server (host:port) to look PTR records up
+	// with, or blank to use the system resolver.
+	reverseDNSServer string
+
+	// reverseDNSTimeout is the maximum duration of each PTR lookup.
+	reverseDNSTimeout time.Duration
+
+	// lookupAddr looks up the PTR records of an IP address. It is replaced in
+	// tests.
+	lookupAddr = net.DefaultResolver.LookupAddr
+)
+
+// setupReverseDNS points lookupAddr at reverseDNSServer, if one is set.
+func setupReverseDNS() {
+	if reverseDNSServer == "" {
+		return
+	}
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, reverseDNSServer)
+		},
+	}
+	lookupAddr = r.LookupAddr
+}
+
+// fillHostnamesFromDNS fills in the blank hostnames of the section's addresses
+// from the first PTR record of each, without its trailing dot, recording the
+// change made on the address, and returns the number filled in. Addresses
+// without a PTR record, or whose lookup fails, are left as they are.
+func (s *sectionRun) fillHostnamesFromDNS() int {
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var filled int
+	var wg sync.WaitGroup
+	for w := 0; w < reverseDNSWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				a := &s.addresses[i]
+				ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+				names, err := lookupAddr(ctx, a.IPAddress)
+				cancel()
+				if err != nil || len(names) == 0 {
+					s.log.WithField("ip", a.IPAddress).Debugf("No PTR record found for %s: %v", a.IPAddress, err)
+					continue
+				}
+				a.Hostname = strings.TrimSuffix(names[0], ".")
+				a.RecordChange("hostname filled in from reverse DNS")
+				mu.Lock()
+				filled++
+				mu.Unlock()
+			}
+		}()
+	}
+	for i, v := range s.addresses {
+		if v.Hostname == "" {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return filled
+}
@@ -0,0 +1,111 @@
+// Package runbook provides the post-migration runbook: a checklist of the
+// manual follow-up work that remains after a migration, covering everything
+// that the migrator could not migrate automatically.
+package runbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Item is a single follow-up task in the runbook.
+type Item struct {
+	// The category the item is grouped under (ie: Users).
+	Category string `json:"category"`
+
+	// A one-line summary of the work to do.
+	Summary string `json:"summary"`
+
+	// Any supporting details, such as the objects affected.
+	Details []string `json:"details,omitempty"`
+}
+
+// Runbook is a list of follow-up items. It is safe for concurrent use.
+type Runbook struct {
+	// The time the runbook was generated.
+	Generated time.Time `json:"generated"`
+
+	// The follow-up items, in the order they were added.
+	Items []Item `json:"items"`
+
+	mu sync.Mutex
+}
+
+// New returns a new, empty Runbook.
+func New() *Runbook {
+	return &Runbook{Items: []Item{}}
+}
+
+// Add adds an item to the runbook.
+func (r *Runbook) Add(category, summary string, details ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Items = append(r.Items, Item{
+		Category: category,
+		Summary:  summary,
+		Details:  details,
+	})
+}
+
+// Markdown renders the runbook as a Markdown checklist, with the items grouped
+// by category in the order each category was first used.
+func (r *Runbook) Markdown() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b bytes.Buffer
+	b.WriteString("# Post-Migration Runbook\n\n")
+	fmt.Fprintf(&b, "Generated at %s. Work through each item to complete the migration.\n", r.Generated.Format(time.RFC3339))
+	if len(r.Items) == 0 {
+		b.WriteString("\nNo manual follow-up work is required.\n")
+		return b.String()
+	}
+
+	var categories []string
+	byCategory := make(map[string][]Item)
+	for _, v := range r.Items {
+		if _, ok := byCategory[v.Category]; !ok {
+			categories = append(categories, v.Category)
+		}
+		byCategory[v.Category] = append(byCategory[v.Category], v)
+	}
+	for _, c := range categories {
+		fmt.Fprintf(&b, "\n## %s\n\n", c)
+		for _, v := range byCategory[c] {
+			fmt.Fprintf(&b, "- [ ] %s\n", v.Summary)
+			for _, d := range v.Details {
+				fmt.Fprintf(&b, "  - %s\n", strings.Replace(d, "\n", " ", -1))
+			}
+		}
+	}
+	return b.String()
+}
+
+// Save stamps the runbook with the current time, and writes it to path. The
+// runbook is written as JSON if path has a .json extension, and as a Markdown
+// checklist otherwise.
+func (r *Runbook) Save(path string) error {
+	r.mu.Lock()
+	r.Generated = time.Now()
+	r.mu.Unlock()
+
+	var b []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		r.mu.Lock()
+		var err error
+		b, err = json.MarshalIndent(r, "", "  ")
+		r.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	} else {
+		b = []byte(r.Markdown())
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
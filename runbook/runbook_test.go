@@ -0,0 +1,63 @@
+package runbook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdown(t *testing.T) {
+	r := New()
+	r.Generated = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Add("Users", "Recreate 2 legacy users", "alice", "bob")
+	r.Add("Skipped Data", "Migrate 3 IPv6 addresses manually")
+	r.Add("Users", "Review user permissions")
+
+	expected := `# Post-Migration Runbook
+
+Generated at 2017-01-02T03:04:05Z. Work through each item to complete the migration.
+
+## Users
+
+- [ ] Recreate 2 legacy users
+  - alice
+  - bob
+- [ ] Review user permissions
+
+## Skipped Data
+
+- [ ] Migrate 3 IPv6 addresses manually
+`
+	if actual := r.Markdown(); expected != actual {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestMarkdownEmpty(t *testing.T) {
+	if actual := New().Markdown(); !strings.Contains(actual, "No manual follow-up work is required.") {
+		t.Fatalf("Expected empty runbook message, got:\n%s", actual)
+	}
+}
+
+func TestSaveJSON(t *testing.T) {
+	r := New()
+	r.Add("Users", "Recreate 1 legacy user", "alice")
+	path := filepath.Join(t.TempDir(), "runbook.json")
+	if err := r.Save(path); err != nil {
+		t.Fatalf("Error saving runbook: %s", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading runbook: %s", err)
+	}
+	var actual Runbook
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatalf("Error parsing runbook: %s", err)
+	}
+	if len(actual.Items) != 1 || actual.Items[0].Details[0] != "alice" || actual.Generated.IsZero() {
+		t.Fatalf("Unexpected runbook contents: %s", b)
+	}
+}
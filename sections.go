@@ -0,0 +1,418 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/sections"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/pipeline"
+	"github.com/paybyphone/phpipam-legacy-migrator/transform"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// sectionHierarchy is true if the master sections of the legacy sections
+	// are recreated between the sections they are migrated to.
+	sectionHierarchy bool
+
+	// legacySectionMasters maps the IDs of legacy subsections to the IDs of
+	// their master sections.
+	legacySectionMasters map[int]int
+
+	// sectionRoutes are the section routes in the configuration file,
+	// resolved to section IDs.
+	sectionRoutes []sectionRoute
+)
+
+// sectionRoute routes the legacy subnets within a network to a section in the
+// new PHPIPAM instance.
+type sectionRoute struct {
+	net *net.IPNet
+	id  int
+}
+
+// sectionRun is the migration of the subnets and addresses of one legacy
+// section into a section in the new PHPIPAM instance.
+//
+// Each section is migrated as an isolated unit, with its own data, error
+// budget, and summary, so that a failure in one section does not abort or
+// affect the others.
+type sectionRun struct {
+	helper.SectionMapping
+
+	// The subnets, addresses, IP requests, and changelog entries fetched
+	// from the legacy section.
+	subnets   []legacydb.Subnet
+	addresses []legacydb.Address
+	requests  []legacydb.Request
+	changes   []legacydb.Change
+
+	// The number of subnets, addresses, and IP requests added to the new
+	// PHPIPAM instance.
+	SubnetsAdded   int
+	AddressesAdded int
+	RequestsAdded  int
+
+	// The number of subnets and addresses skipped as they are not IPv4.
+	SkippedSubnets   int
+	SkippedAddresses int
+
+	// The number of addresses skipped as they are the network or broadcast
+	// address of their subnet.
+	SkippedNetworkBroadcast int
+
+	// The addresses whose hostname is not a valid RFC 1123 hostname, as
+	// "IP (hostname)", if hostnames are validated.
+	InvalidHostnames []string
+
+	// The conflicts between the section's subnets found when validating
+	// them.
+	Overlaps []transform.Overlap
+
+	// The errors counted against the section's error budget.
+	Errors []error
+
+	// mu protects AddressesAdded and Errors, which are updated by concurrent
+	// workers.
+	mu sync.Mutex
+
+	// The error that aborted the section, if any.
+	Err error
+
+	// The logger for the section, which tags each message with the section,
+	// and the entity and phase of the running stage. baseLog is the logger
+	// with the section only.
+	log     *logrus.Entry
+	baseLog *logrus.Entry
+}
+
+// newSectionRun returns a sectionRun for the supplied mapping. A LegacyID of 0
+// migrates all of the subnets and addresses in the legacy DB.
+func newSectionRun(m helper.SectionMapping) *sectionRun {
+	fields := logrus.Fields{"section": m.ID}
+	if m.LegacyID != 0 {
+		fields["legacy_section"] = m.LegacyID
+	}
+	log := logrus.WithFields(fields)
+	return &sectionRun{
+		SectionMapping: m,
+		log:            log,
+		baseLog:        log,
+	}
+}
+
+// String implements fmt.Stringer for sectionRun.
+func (s *sectionRun) String() string {
+	if s.LegacyID == 0 {
+		return fmt.Sprintf("section %d", s.ID)
+	}
+	return fmt.Sprintf("legacy section %d to section %d", s.LegacyID, s.ID)
+}
+
+// recordError logs err and counts it against the section's error budget. An
+// error is returned if the budget has been exceeded, which should abort the
+// section.
+func (s *sectionRun) recordError(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Errors = append(s.Errors, err)
+	s.log.Error(err)
+	if len(s.Errors) > sectionErrorBudget {
+		return fmt.Errorf("error budget of %d exceeded", sectionErrorBudget)
+	}
+	return nil
+}
+
+// resolveSectionName looks up the section named sectionName in the new PHPIPAM
+// instance with c, and sets sectionID to its ID. The section is created if it
+// is missing, provided that the write stage is run.
+func resolveSectionName(c ipamsink.SectionCreator) error {
+	id, err := findOrCreateSection(c, sectionName)
+	if err != nil {
+		return err
+	}
+	sectionID = id
+	return nil
+}
+
+// findOrCreateSection returns the ID of the section named name in the new
+// PHPIPAM instance, looked up with c. The section is created if it is missing,
+// provided that the write stage is run.
+func findOrCreateSection(c ipamsink.SectionCreator, name string) (int, error) {
+	find := func() (int, error) {
+		found, err := c.Sections()
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range found {
+			if v.Name == name {
+				return v.ID, nil
+			}
+		}
+		return 0, nil
+	}
+	id, err := find()
+	if err != nil {
+		return 0, err
+	}
+	if id != 0 {
+		logrus.Infof("Found section %s with ID %d", name, id)
+		return id, nil
+	}
+	if !hasStage(pipeline.Write) {
+		return 0, fmt.Errorf("section %s does not exist, and is only created when the %s stage runs", name, pipeline.Write)
+	}
+	if err := c.CreateSection(sections.Section{Name: name, Description: "Migrated from the legacy PHPIPAM"}); err != nil {
+		return 0, err
+	}
+	// The ID of the created section is not returned, so look it up.
+	if id, err = find(); err != nil {
+		return 0, err
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("section %s not found after creating it", name)
+	}
+	logrus.Infof("Created section %s with ID %d", name, id)
+	return id, nil
+}
+
+// resolveSectionRoutes resolves the section routes in the configuration file
+// to sectionRoutes. Named sections are looked up, or created, with c, which is
+// nil if sections cannot be looked up by name.
+func resolveSectionRoutes(c ipamsink.SectionCreator) error {
+	sectionRoutes = nil
+	ids := make(map[string]int)
+	for _, v := range cfg.SectionRoutes {
+		_, n, err := net.ParseCIDR(v.Subnet)
+		if err != nil {
+			return err
+		}
+		id := v.SectionID
+		if v.Section != "" {
+			if c == nil {
+				return fmt.Errorf("section %s can only be named with the PHPIPAM API or -target-dsn, so use section_id instead", v.Section)
+			}
+			if id = ids[v.Section]; id == 0 {
+				if id, err = findOrCreateSection(c, v.Section); err != nil {
+					return err
+				}
+				ids[v.Section] = id
+			}
+		}
+		logrus.Infof("Subnets in %s are migrated to section %d", n, id)
+		sectionRoutes = append(sectionRoutes, sectionRoute{net: n, id: id})
+	}
+	return nil
+}
+
+// subnetSection returns the ID of the section that the legacy subnet with
+// cidr is migrated to: that of the most specific section route containing it,
+// or the section of s if there is none.
+func (s *sectionRun) subnetSection(cidr string) int {
+	ip, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return s.ID
+	}
+	size, _ := n.Mask.Size()
+	id, best := s.ID, -1
+	for _, v := range sectionRoutes {
+		if prefix, _ := v.net.Mask.Size(); v.net.Contains(ip) && prefix <= size && prefix > best {
+			id, best = v.id, prefix
+		}
+	}
+	return id
+}
+
+// targetSections returns the IDs of the sections that nets, subnets of s, are
+// migrated to: its own, followed by any that section routes send them to.
+func (s *sectionRun) targetSections(nets []legacydb.Subnet) []int {
+	out := []int{s.ID}
+	for _, v := range nets {
+		if id := s.sectionOf(v); !containsInt(out, id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// sectionOf returns the ID of the section that v, a subnet of s, is migrated
+// to, which is the section of s if v has none.
+func (s *sectionRun) sectionOf(v legacydb.Subnet) int {
+	if v.SectionID == 0 {
+		return s.ID
+	}
+	return v.SectionID
+}
+
+// fetchSectionMasters reads the master sections of the legacy subsections from
+// the legacy DB in conn.
+func fetchSectionMasters(conn *sql.DB) (map[int]int, error) {
+	r := &legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}
+	return r.SectionMasters()
+}
+
+// addSectionHierarchy sets the master section of each section migrated to with
+// c, to the section that the master of its legacy section was migrated to.
+// Sections whose legacy master is not migrated are left at the top level.
+func addSectionHierarchy(c ipamsink.SectionUpdater, masters map[int]int) error {
+	ids := make(map[int]int)
+	for _, m := range sectionMappings {
+		if _, ok := ids[m.LegacyID]; !ok {
+			ids[m.LegacyID] = m.ID
+		}
+	}
+
+	stageLog.Info("Recreating section hierarchy.")
+
+	for _, m := range sectionMappings {
+		master, ok := masters[m.LegacyID]
+		if !ok {
+			continue
+		}
+		log := stageLog.WithFields(logrus.Fields{"section": m.ID, "legacy_section": m.LegacyID})
+		id, ok := ids[master]
+		if !ok {
+			recordsTotal.Inc("sections", "skipped")
+			log.Warnf("Master section %d of legacy section %d is not migrated, so section %d is left at the top level", master, m.LegacyID, m.ID)
+			continue
+		}
+		if id == m.ID {
+			recordsTotal.Inc("sections", "skipped")
+			log.Warnf("Legacy section %d and its master section %d are both migrated to section %d, which cannot be its own master", m.LegacyID, master, m.ID)
+			continue
+		}
+		if err := c.UpdateSection(sections.Section{ID: m.ID, MasterSection: id}); err != nil {
+			return err
+		}
+		recordsTotal.Inc("sections", "updated")
+		log.Infof("Section %d made a subsection of section %d", m.ID, id)
+	}
+	return nil
+}
+
+// sectionRuns returns the section runs for the configured section mappings,
+// or a single run migrating everything to sectionID if there are none.
+func sectionRuns() []*sectionRun {
+	if len(sectionMappings) == 0 {
+		return []*sectionRun{newSectionRun(helper.SectionMapping{ID: sectionID})}
+	}
+	var out []*sectionRun
+	for _, m := range sectionMappings {
+		out = append(out, newSectionRun(m))
+	}
+	return out
+}
+
+// migrateSections migrates the subnets and addresses of each section
+// concurrently, running the supplied stages. The pipeline for each section is
+// run to completion or failure independently of the others.
+func migrateSections(conn *sql.DB, stages []string) []*sectionRun {
+	runs := sectionRuns()
+	var wg sync.WaitGroup
+	for _, s := range runs {
+		wg.Add(1)
+		go func(s *sectionRun) {
+			defer wg.Done()
+			s.log.Infof("Migrating %s", s)
+			s.Err = s.pipeline(conn, stages).Run()
+			s.log = s.baseLog
+		}(s)
+	}
+	wg.Wait()
+	return runs
+}
+
+// summary returns a summary of the objects added by the section run.
+func (s *sectionRun) summary() string {
+	if migrateRequests {
+		return fmt.Sprintf("%d subnets, %d addresses, and %d IP requests added, %d errors", s.SubnetsAdded, s.AddressesAdded, s.RequestsAdded, len(s.Errors))
+	}
+	return fmt.Sprintf("%d subnets and %d addresses added, %d errors", s.SubnetsAdded, s.AddressesAdded, len(s.Errors))
+}
+
+// summarizeSections logs a summary of each section run, and returns the
+// number of sections that failed.
+func summarizeSections(runs []*sectionRun) (failed int) {
+	for _, s := range runs {
+		summary := s.summary()
+		if s.Err != nil {
+			failed++
+			s.log.Errorf("Migration of %s failed (%s): %s", s, summary, s.Err)
+			continue
+		}
+		s.log.Infof("Migration of %s succeeded (%s)", s, summary)
+	}
+	return failed
+}
+
+// destinationSections returns the IDs of the sections being migrated into,
+// by the section runs and by the section routes.
+func destinationSections() (ids []int) {
+	seen := make(map[int]bool)
+	add := func(id int) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, s := range sectionRuns() {
+		add(s.ID)
+	}
+	for _, v := range sectionRoutes {
+		add(v.id)
+	}
+	return ids
+}
+
+// migratedSections returns the IDs of the sections being migrated into, which
+// migrated devices and nameserver sets belong to, in the semicolon-separated
+// format used by the PHPIPAM API.
+func migratedSections() string {
+	var ids []string
+	for _, id := range destinationSections() {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return strings.Join(ids, ";")
+}
+
+// missingSections returns the IDs of the sections being migrated into that do
+// not exist in the new PHPIPAM instance, as listed with c.
+func missingSections(c ipamsink.SectionCreator) (out []int, err error) {
+	found, err := c.Sections()
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[int]bool)
+	for _, v := range found {
+		exists[v.ID] = true
+	}
+	for _, id := range destinationSections() {
+		if !exists[id] {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// populatedSections returns a description of each section being migrated
+// into that already has subnets in the new PHPIPAM instance, as found with c,
+// for -require-empty-section.
+func populatedSections(c ipamsink.SubnetFinder) (out []string, err error) {
+	for _, id := range destinationSections() {
+		found, err := c.SubnetIDs(id)
+		if err != nil {
+			return nil, fmt.Errorf("error listing subnets in section %d: %s", id, err)
+		}
+		if len(found) > 0 {
+			out = append(out, fmt.Sprintf("section %d has %d subnets", id, len(found)))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/server"
+	"github.com/sirupsen/logrus"
+)
+
+// serveOptions are the options that can be set through the API. They
+// exclude those that read or write files, run code, or listen on ports on the
+// server (ie: -output-file, -hook-plugin, -ssh-host, or -metrics-addr), those
+// that need a terminal, and those that fetch secrets with the credentials of
+// the server (ie: -vault-addr), as the API must not grant more access to the
+// server than starting migrations. -final and -freeze-check are excluded too,
+// as they need the -state-file of the bulk migration, which is a file on the
+// server.
+var serveOptions = []string{
+	"address-states", "addresses-upsert", "aggregate-as",
+	"aggregate-parents", "api-burst", "api-connect-timeout", "api-insecure",
+	"api-rate", "api-timeout", "appid", "audit", "cache-ttl",
+	"change-notes", "db-tls", "dbhost", "dbname", "dbpassword", "dbport",
+	"dbuser", "debug", "decode-html-entities", "duplicate-vlans",
+	"endpoint", "existing-subnets", "existing-vlans", "force",
+	"gateway-pattern", "gateway-position", "legacy-id-field",
+	"liveness-check", "liveness-ports", "liveness-rate", "liveness-timeout",
+	"log-format", "log-level", "lowercase-hostnames", "migrate-devices",
+	"migrate-nameservers", "migrate-requests", "migrate-users",
+	"migrate-vrfs", "missing-vlans", "nautobot-namespace",
+	"nautobot-status", "nautobot-token", "nautobot-url", "netbox-token",
+	"netbox-url", "normalize-macs", "orphans-subnet", "overlapping-subnets",
+	"password", "preserve-timestamps", "quiet", "require-empty-section",
+	"reverse-dns", "reverse-dns-server", "reverse-dns-timeout",
+	"section-error-budget", "section-hierarchy", "section-name",
+	"sectionid", "sections", "since", "skip-network-broadcast",
+	"skip-preflight", "source-charset", "stages", "stamp", "stamp-field",
+	"strip-hostname-dots", "target", "user", "users-default-password",
+	"validate-hostnames", "verify", "with-changelog", "workers",
+}
+
+// serveSecrets are the options that are passed to migrations started through
+// the API in their environment, mapped to the environment variable that each
+// is read from.
+var serveSecrets = map[string]string{
+	"dbpassword":             "LEGACY_DB_PASSWORD",
+	"password":               "PHPIPAM_PASSWORD",
+	"netbox-token":           "NETBOX_TOKEN",
+	"nautobot-token":         "NAUTOBOT_TOKEN",
+	"users-default-password": "USERS_DEFAULT_PASSWORD",
+}
+
+// runServe runs the migrator as a server, which starts migrations on request
+// through a REST API until it is stopped, rather than running one migration.
+// args are the arguments after the serve subcommand. Migrations are started
+// with the options posted to the API, as described in the server package.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8090", "The address to serve the API on")
+	dir := fs.String("runs-dir", "runs", "The directory to create the directory of each migration in, which holds its log, configuration file, and report, and is its working directory")
+	token := fs.String("token", "", "The bearer token that API requests must supply (or set MIGRATOR_API_TOKEN; required unless -addr is a loopback address)")
+	fs.Parse(args)
+	if *token == "" {
+		*token = os.Getenv("MIGRATOR_API_TOKEN")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		logrus.Fatalf("Error finding the migrator executable: %s", err)
+	}
+	runsDir, err := filepath.Abs(*dir)
+	if err != nil {
+		logrus.Fatalf("Invalid -runs-dir: %s", err)
+	}
+	if err := os.MkdirAll(runsDir, 0755); err != nil {
+		logrus.Fatalf("Error creating -runs-dir: %s", err)
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logrus.Fatalf("Error starting API listener: %s", err)
+	}
+	if *token == "" && !isLoopback(l.Addr()) {
+		logrus.Fatalf("-token is required to serve the API on %s, which is not a loopback address", l.Addr())
+	}
+	s := &server.Server{
+		Command: exe,
+		Flags:   flag.CommandLine,
+		Allowed: serveOptions,
+		Secrets: serveSecrets,
+		Dir:     runsDir,
+		URL:     "http://" + loopbackAddr(l.Addr()),
+		Token:   *token,
+	}
+	if s.Token == "" {
+		logrus.Warn("No -token supplied, so API requests are not authenticated. Anyone able to connect to this host can start migrations.")
+	}
+	logrus.Infof("Serving the migration API on http://%s/runs, keeping runs in %s", l.Addr(), runsDir)
+	logrus.Fatalf("Error serving the migration API: %s", http.Serve(l, s))
+}
+
+// isLoopback returns whether a listener address only accepts connections from
+// the local host. Unspecified addresses (ie: :8090) accept them from anywhere.
+func isLoopback(a net.Addr) bool {
+	host, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackAddr returns a listener address with the IP address replaced with
+// the loopback address if it is unspecified (ie: :8090), so that it can be
+// connected to locally.
+func loopbackAddr(a net.Addr) string {
+	host, port, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return a.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,524 @@
+// Package server starts migrations on request through a REST API, so that they
+// can be orchestrated remotely from automation.
+//
+// Each migration (a run) is a separate migrator process, started with the
+// options posted to the API, in a directory of its own. The output of the
+// process is kept in a log file in that directory, and the process sends its
+// final report back to the server through -notify-url. Only one run is
+// started at a time, as concurrent runs would compete for the same PHPIPAM
+// instance.
+//
+// The API is:
+//
+//	POST   /runs             Start a run, with a Request as the body.
+//	GET    /runs             List the runs started since the server started.
+//	GET    /runs/ID          Get the status of a run.
+//	DELETE /runs/ID          Cancel a run, as if interrupted with Ctrl-C.
+//	GET    /runs/ID/report   Get the final report of a run.
+//	GET    /runs/ID/log      Get the log of a run, as plain text.
+//
+// Responses are JSON, apart from logs. Errors are returned as an object with
+// an error member.
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/config"
+	"github.com/paybyphone/phpipam-legacy-migrator/notify"
+)
+
+// The files kept in the directory of each run.
+const (
+	// LogFile holds the output of the migrator process.
+	LogFile = "migration.log"
+
+	// ConfigFile holds the configuration file posted with the run, if any.
+	ConfigFile = "config.yaml"
+
+	// ReportFile holds the final report sent by the migrator process.
+	ReportFile = "report.json"
+)
+
+// maxRequestSize is the largest body accepted by POST /runs.
+const maxRequestSize = 1 << 20
+
+// Request is the body POSTed to /runs to start a run.
+type Request struct {
+	// The command line options of the run, keyed by name without the leading
+	// dash (ie: {"dbhost": "db.example.com", "sectionid": 3}). Values are
+	// strings, numbers, or booleans. Only the options in Server.Allowed are
+	// accepted.
+	Options map[string]interface{} `json:"options"`
+
+	// The configuration file of the run (see -config), as a JSON object (ie:
+	// {"stages": ["fetch", "validate"]}).
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// Run is a migration started through the API.
+type Run struct {
+	// The ID of the run, which is also the name of its directory.
+	ID string `json:"id"`
+
+	// The status of the run: running, succeeded, or failed.
+	Status string `json:"status"`
+
+	// The error that failed the run, if any.
+	Error string `json:"error,omitempty"`
+
+	// The options that the run was started with. The values of passwords and
+	// tokens are redacted.
+	Options map[string]string `json:"options"`
+
+	// The times the run started and finished.
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	dir       string
+	key       string
+	cmd       *exec.Cmd
+	report    *notify.Report
+	cancelled bool
+}
+
+// Server serves the API. The exported fields must be set before it serves any
+// requests.
+type Server struct {
+	// The path to the migrator executable that runs are started with.
+	Command string
+
+	// The options of the migrator. Options not defined in the set are
+	// rejected as unknown.
+	Flags *flag.FlagSet
+
+	// The options defined in Flags that are accepted in requests. The others
+	// are rejected, as the API must not be able to make the server read or
+	// write files, run code, or listen on ports (ie: -output-file or
+	// -hook-plugin), and -config and -notify-url are set by the server.
+	Allowed []string
+
+	// The options in Allowed that are secrets, mapped to the environment
+	// variable that the migrator reads each from. Secrets are passed to runs
+	// in their environment rather than on their command line, where they
+	// would be visible to other users of the host, and are not inherited
+	// from the environment of the server.
+	Secrets map[string]string
+
+	// The directory that the directories of the runs are created in.
+	Dir string
+
+	// The base URL of the server (ie: http://127.0.0.1:8090), as reachable
+	// from the runs, which send their final reports to it.
+	URL string
+
+	// The bearer token that requests must supply in their Authorization
+	// header. Requests are not authenticated if this is empty.
+	Token string
+
+	mu     sync.Mutex
+	runs   []*Run
+	active *Run
+}
+
+// apiError is an error with the HTTP status to respond with.
+type apiError struct {
+	code int
+	msg  string
+}
+
+// Error implements error for apiError.
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// errorf returns an apiError with the supplied status and formatted message.
+func errorf(code int, format string, a ...interface{}) error {
+	return &apiError{code: code, msg: fmt.Sprintf(format, a...)}
+}
+
+// ServeHTTP implements http.Handler for Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "runs" || len(parts) > 3 {
+		writeError(w, errorf(http.StatusNotFound, "%s not found", r.URL.Path))
+		return
+	}
+
+	// Runs authenticate their reports with the key in the URL that they were
+	// given, rather than the token.
+	if len(parts) == 3 && parts[2] == "report" && r.Method == "POST" {
+		if err := s.receiveReport(parts[1], r); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if s.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.Token)) != 1 {
+		writeError(w, errorf(http.StatusUnauthorized, "missing or invalid bearer token"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, s.list())
+	case len(parts) == 1 && r.Method == "POST":
+		var req Request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			writeError(w, errorf(http.StatusBadRequest, "invalid request: %s", err))
+			return
+		}
+		run, err := s.start(&req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/runs/"+run.ID)
+		writeJSON(w, http.StatusCreated, run)
+	case len(parts) == 2 && r.Method == "GET":
+		run, err := s.get(parts[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case len(parts) == 2 && r.Method == "DELETE":
+		run, err := s.cancel(parts[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, run)
+	case len(parts) == 3 && parts[2] == "report" && r.Method == "GET":
+		report, err := s.report(parts[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	case len(parts) == 3 && parts[2] == "log" && r.Method == "GET":
+		dir, err := s.dir(parts[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, filepath.Join(dir, LogFile))
+	default:
+		writeError(w, errorf(http.StatusMethodNotAllowed, "%s not allowed on %s", r.Method, r.URL.Path))
+	}
+}
+
+// args returns the command line arguments for the options of a request, in
+// the order of their names, the environment variables for its secrets, and
+// the options with secrets redacted.
+func (s *Server) args(options map[string]interface{}) ([]string, []string, map[string]string, error) {
+	var names []string
+	for k := range options {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var args, env []string
+	shown := make(map[string]string)
+	for _, name := range names {
+		if s.Flags.Lookup(name) == nil {
+			return nil, nil, nil, fmt.Errorf("unknown option %q", name)
+		}
+		if !s.allowed(name) {
+			return nil, nil, nil, fmt.Errorf("option %q cannot be set through the API", name)
+		}
+		var value string
+		switch v := options[name].(type) {
+		case string:
+			value = v
+		case bool:
+			value = strconv.FormatBool(v)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, nil, nil, fmt.Errorf("option %q must be a string, number, or boolean", name)
+		}
+		if v, ok := s.Secrets[name]; ok {
+			env = append(env, v+"="+value)
+			value = "(redacted)"
+		} else {
+			args = append(args, fmt.Sprintf("-%s=%s", name, value))
+		}
+		shown[name] = value
+	}
+	return args, env, shown, nil
+}
+
+// allowed returns whether an option can be set through the API.
+func (s *Server) allowed(name string) bool {
+	for _, v := range s.Allowed {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
+
+// environ returns the environment of a run: the environment of the server,
+// without the environment variables of secrets, and the supplied variables.
+func (s *Server) environ(env []string) []string {
+	var out []string
+	for _, v := range os.Environ() {
+		inherit := true
+		for _, name := range s.Secrets {
+			if strings.HasPrefix(v, name+"=") {
+				inherit = false
+			}
+		}
+		if inherit {
+			out = append(out, v)
+		}
+	}
+	return append(out, env...)
+}
+
+// start starts a run for a request, unless another run is still running.
+func (s *Server) start(req *Request) (*Run, error) {
+	args, env, shown, err := s.args(req.Options)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "%s", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		return nil, errorf(http.StatusConflict, "run %s is still running", s.active.ID)
+	}
+	run := &Run{
+		ID:      s.newID(),
+		Status:  notify.StatusRunning,
+		Options: shown,
+		Started: time.Now(),
+	}
+	run.dir = filepath.Join(s.Dir, run.ID)
+	if err := os.MkdirAll(run.dir, 0755); err != nil {
+		return nil, errorf(http.StatusInternalServerError, "error creating run directory: %s", err)
+	}
+	started := false
+	defer func() {
+		if !started {
+			os.RemoveAll(run.dir)
+		}
+	}()
+	if len(req.Config) > 0 {
+		path := filepath.Join(run.dir, ConfigFile)
+		if err := ioutil.WriteFile(path, req.Config, 0644); err != nil {
+			return nil, errorf(http.StatusInternalServerError, "error writing configuration file: %s", err)
+		}
+		// JSON is YAML, so the configuration file is checked as it would be
+		// by the run.
+		if _, err := config.Load(path); err != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid configuration file: %s", err)
+		}
+		args = append(args, "-config="+path)
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errorf(http.StatusInternalServerError, "error generating report key: %s", err)
+	}
+	run.key = hex.EncodeToString(b)
+	args = append(args, fmt.Sprintf("-notify-url=%s/runs/%s/report?key=%s", s.URL, run.ID, run.key))
+
+	f, err := os.Create(filepath.Join(run.dir, LogFile))
+	if err != nil {
+		return nil, errorf(http.StatusInternalServerError, "error creating log file: %s", err)
+	}
+	run.cmd = exec.Command(s.Command, args...)
+	run.cmd.Dir = run.dir
+	run.cmd.Env = s.environ(env)
+	run.cmd.Stdout = f
+	run.cmd.Stderr = f
+	if err := run.cmd.Start(); err != nil {
+		f.Close()
+		return nil, errorf(http.StatusInternalServerError, "error starting migrator: %s", err)
+	}
+	started = true
+	s.runs = append(s.runs, run)
+	s.active = run
+	go s.wait(run, f)
+	v := *run
+	return &v, nil
+}
+
+// newID returns the ID of a new run, from the time it started. Runs started
+// in the same second are told apart by a suffix.
+func (s *Server) newID() string {
+	base := time.Now().UTC().Format("20060102-150405")
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(s.Dir, id)); os.IsNotExist(err) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// wait waits for the process of a run to exit, and records its status.
+func (s *Server) wait(run *Run, log *os.File) {
+	err := run.cmd.Wait()
+	log.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now()
+	run.Finished = &finished
+	s.active = nil
+	switch {
+	case err == nil:
+		run.Status = notify.StatusSucceeded
+	case run.cancelled:
+		run.Status = notify.StatusFailed
+		run.Error = "cancelled"
+	case run.report != nil && run.report.Error != "":
+		run.Status = notify.StatusFailed
+		run.Error = run.report.Error
+	default:
+		run.Status = notify.StatusFailed
+		run.Error = fmt.Sprintf("migrator %s - see the log", err)
+	}
+}
+
+// find returns the run with the supplied ID. The lock must be held.
+func (s *Server) find(id string) (*Run, error) {
+	for _, v := range s.runs {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return nil, errorf(http.StatusNotFound, "run %s not found", id)
+}
+
+// list returns copies of the runs, in the order they started.
+func (s *Server) list() []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Run, 0, len(s.runs))
+	for _, v := range s.runs {
+		out = append(out, *v)
+	}
+	return out
+}
+
+// get returns a copy of the run with the supplied ID.
+func (s *Server) get(id string) (*Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	v := *run
+	return &v, nil
+}
+
+// dir returns the directory of the run with the supplied ID.
+func (s *Server) dir(id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.find(id)
+	if err != nil {
+		return "", err
+	}
+	return run.dir, nil
+}
+
+// cancel interrupts the process of a running run.
+func (s *Server) cancel(id string) (*Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if run != s.active {
+		return nil, errorf(http.StatusConflict, "run %s has finished", id)
+	}
+	if err := run.cmd.Process.Signal(os.Interrupt); err != nil {
+		return nil, errorf(http.StatusInternalServerError, "error cancelling run %s: %s", id, err)
+	}
+	run.cancelled = true
+	v := *run
+	return &v, nil
+}
+
+// report returns the final report of a run.
+func (s *Server) report(id string) (*notify.Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if run.report == nil {
+		if run == s.active {
+			return nil, errorf(http.StatusNotFound, "run %s has not finished", id)
+		}
+		return nil, errorf(http.StatusNotFound, "run %s did not send a report - see the log", id)
+	}
+	return run.report, nil
+}
+
+// receiveReport records the final report sent by a run, and writes it to the
+// run's directory.
+func (s *Server) receiveReport(id string, r *http.Request) error {
+	var report notify.Report
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		return errorf(http.StatusBadRequest, "invalid report: %s", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.find(id)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("key")), []byte(run.key)) != 1 {
+		return errorf(http.StatusUnauthorized, "invalid report key")
+	}
+	run.report = &report
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errorf(http.StatusInternalServerError, "error encoding report: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(run.dir, ReportFile), b, 0644); err != nil {
+		return errorf(http.StatusInternalServerError, "error writing report: %s", err)
+	}
+	return nil
+}
+
+// writeJSON writes v as a JSON response with the supplied status.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response, with the status of an
+// apiError, or 500 for other errors.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if e, ok := err.(*apiError); ok {
+		code = e.code
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
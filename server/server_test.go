@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/notify"
+)
+
+// TestMain runs the test binary as a fake migrator when it is started as a
+// run, which prints its arguments and password, sends a report to
+// -notify-url, and fails if -fail is supplied.
+func TestMain(m *testing.M) {
+	if os.Getenv("SERVER_TEST_MIGRATOR") != "" {
+		fakeMigrator()
+	}
+	os.Exit(m.Run())
+}
+
+func fakeMigrator() {
+	fmt.Println(strings.Join(os.Args[1:], " "))
+	fmt.Println("password=" + os.Getenv("TEST_PASSWORD"))
+	report := &notify.Report{Status: notify.StatusSucceeded}
+	for _, v := range os.Args[1:] {
+		if v == "-fail=true" {
+			report.Status, report.Error = notify.StatusFailed, "boom"
+		}
+		if v == "-wait=true" {
+			time.Sleep(time.Minute)
+		}
+	}
+	for _, v := range os.Args[1:] {
+		if strings.HasPrefix(v, "-notify-url=") {
+			if err := (&notify.Client{URL: strings.TrimPrefix(v, "-notify-url=")}).Send(report); err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+		}
+	}
+	if report.Error != "" {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// testServer returns a Server running the fake migrator, serving on a test
+// HTTP server.
+func testServer(t *testing.T) (*Server, *httptest.Server) {
+	os.Setenv("SERVER_TEST_MIGRATOR", "1")
+	os.Setenv("TEST_PASSWORD", "inherited")
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("dbhost", "", "")
+	flags.String("password", "", "")
+	flags.Int("sectionid", 1, "")
+	flags.Bool("fail", false, "")
+	flags.Bool("wait", false, "")
+	flags.Bool("interactive", false, "")
+	flags.String("notify-url", "", "")
+	flags.String("output-file", "", "")
+	s := &Server{
+		Command: os.Args[0],
+		Flags:   flags,
+		Allowed: []string{"dbhost", "password", "sectionid", "fail", "wait"},
+		Secrets: map[string]string{"password": "TEST_PASSWORD"},
+		Dir:     t.TempDir(),
+		Token:   "secret",
+	}
+	ts := httptest.NewServer(s)
+	s.URL = ts.URL
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// call sends a request to the test server with the token, and decodes the
+// JSON response into out, if supplied. It returns the status of the response.
+func call(t *testing.T, ts *httptest.Server, method, path, body string, out interface{}) int {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Error decoding response to %s %s: %s", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitFor polls a run until it finishes.
+func waitFor(t *testing.T, ts *httptest.Server, id string) Run {
+	for i := 0; i < 100; i++ {
+		var run Run
+		call(t, ts, "GET", "/runs/"+id, "", &run)
+		if run.Status != notify.StatusRunning {
+			return run
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Run %s did not finish", id)
+	return Run{}
+}
+
+func TestServerRun(t *testing.T) {
+	s, ts := testServer(t)
+
+	var run Run
+	code := call(t, ts, "POST", "/runs", `{"options": {"dbhost": "db", "sectionid": 3, "password": "hunter2"}, "config": {"stages": ["fetch", "validate"]}}`, &run)
+	if code != http.StatusCreated || run.Status != notify.StatusRunning {
+		t.Fatalf("Expected a running run, got %d: %#v", code, run)
+	}
+	expected := map[string]string{"dbhost": "db", "sectionid": "3", "password": "(redacted)"}
+	if !reflect.DeepEqual(expected, run.Options) {
+		t.Fatalf("Expected options %v, got %v", expected, run.Options)
+	}
+
+	run = waitFor(t, ts, run.ID)
+	if run.Status != notify.StatusSucceeded || run.Finished == nil {
+		t.Fatalf("Expected a succeeded run, got %#v", run)
+	}
+	var report notify.Report
+	if code := call(t, ts, "GET", "/runs/"+run.ID+"/report", "", &report); code != http.StatusOK || report.Status != notify.StatusSucceeded {
+		t.Fatalf("Expected a succeeded report, got %d: %#v", code, report)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, run.ID, ReportFile)); err != nil {
+		t.Fatalf("Expected the report to be written: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, run.ID, LogFile))
+	if err != nil {
+		t.Fatalf("Error reading log: %s", err)
+	}
+	prefix := fmt.Sprintf("-dbhost=db -sectionid=3 -config=%s -notify-url=%s/runs/%s/report?key=", filepath.Join(s.Dir, run.ID, ConfigFile), ts.URL, run.ID)
+	if !strings.HasPrefix(string(b), prefix) {
+		t.Fatalf("Expected arguments %q, got %q", prefix, b)
+	}
+	if !strings.Contains(string(b), "\npassword=hunter2\n") {
+		t.Fatalf("Expected the password to be passed in the environment, got %q", b)
+	}
+
+	var runs []Run
+	if call(t, ts, "GET", "/runs", "", &runs); len(runs) != 1 || runs[0].ID != run.ID {
+		t.Fatalf("Expected run %s to be listed, got %#v", run.ID, runs)
+	}
+}
+
+func TestServerRunFailed(t *testing.T) {
+	s, ts := testServer(t)
+
+	var run Run
+	call(t, ts, "POST", "/runs", `{"options": {"fail": true}}`, &run)
+	run = waitFor(t, ts, run.ID)
+	if run.Status != notify.StatusFailed || run.Error != "boom" {
+		t.Fatalf("Expected a run failed with boom, got %#v", run)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, run.ID, LogFile))
+	if err != nil {
+		t.Fatalf("Error reading log: %s", err)
+	}
+	if !strings.Contains(string(b), "\npassword=\n") {
+		t.Fatalf("Expected the password not to be inherited from the server, got %q", b)
+	}
+}
+
+func TestServerCancel(t *testing.T) {
+	_, ts := testServer(t)
+
+	var run Run
+	call(t, ts, "POST", "/runs", `{"options": {"wait": true}}`, &run)
+	var e map[string]string
+	if code := call(t, ts, "POST", "/runs", `{"options": {}}`, &e); code != http.StatusConflict {
+		t.Fatalf("Expected a conflict starting a second run, got %d: %v", code, e)
+	}
+	if code := call(t, ts, "GET", "/runs/"+run.ID+"/report", "", &e); code != http.StatusNotFound || e["error"] != "run "+run.ID+" has not finished" {
+		t.Fatalf("Expected no report for a running run, got %d: %v", code, e)
+	}
+	if code := call(t, ts, "DELETE", "/runs/"+run.ID, "", nil); code != http.StatusAccepted {
+		t.Fatalf("Expected the run to be cancelled, got %d", code)
+	}
+	run = waitFor(t, ts, run.ID)
+	if run.Status != notify.StatusFailed || run.Error != "cancelled" {
+		t.Fatalf("Expected a cancelled run, got %#v", run)
+	}
+	if code := call(t, ts, "DELETE", "/runs/"+run.ID, "", nil); code != http.StatusConflict {
+		t.Fatalf("Expected a conflict cancelling a finished run, got %d", code)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	_, ts := testServer(t)
+
+	// Errors are expected to start with err, as some end with the path of the
+	// run directory.
+	for _, v := range []struct {
+		method, path, body string
+		code               int
+		err                string
+	}{
+		{"POST", "/runs", `{"options": {"bogus": "1"}}`, http.StatusBadRequest, `unknown option "bogus"`},
+		{"POST", "/runs", `{"options": {"interactive": true}}`, http.StatusBadRequest, `option "interactive" cannot be set through the API`},
+		{"POST", "/runs", `{"options": {"notify-url": "http://example.com"}}`, http.StatusBadRequest, `option "notify-url" cannot be set through the API`},
+		{"POST", "/runs", `{"options": {"output-file": "/etc/passwd"}}`, http.StatusBadRequest, `option "output-file" cannot be set through the API`},
+		{"POST", "/runs", `{"options": {"dbhost": ["a"]}}`, http.StatusBadRequest, `option "dbhost" must be a string, number, or boolean`},
+		{"POST", "/runs", `{"config": {"bogus": 1}}`, http.StatusBadRequest, "invalid configuration file: error parsing config file "},
+		{"POST", "/runs", `{"config": {"stamp": "` + strings.Repeat("a", 1<<20) + `"}}`, http.StatusBadRequest, "invalid request: http: request body too large"},
+		{"GET", "/runs/missing", "", http.StatusNotFound, "run missing not found"},
+		{"PUT", "/runs", "", http.StatusMethodNotAllowed, "PUT not allowed on /runs"},
+		{"GET", "/other", "", http.StatusNotFound, "/other not found"},
+	} {
+		var e map[string]string
+		if code := call(t, ts, v.method, v.path, v.body, &e); code != v.code || !strings.HasPrefix(e["error"], v.err) {
+			t.Errorf("%s %s: expected %d %q, got %d %q", v.method, v.path, v.code, v.err, code, e["error"])
+		}
+	}
+}
+
+func TestServerAuthentication(t *testing.T) {
+	_, ts := testServer(t)
+
+	resp, err := http.Get(ts.URL + "/runs")
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a request without the token to be unauthorized, got %s", resp.Status)
+	}
+
+	var run Run
+	call(t, ts, "POST", "/runs", `{"options": {"wait": true}}`, &run)
+	defer call(t, ts, "DELETE", "/runs/"+run.ID, "", nil)
+	resp, err = http.Post(ts.URL+"/runs/"+run.ID+"/report?key=wrong", "application/json", bytes.NewBufferString(`{"status": "succeeded"}`))
+	if err != nil {
+		t.Fatalf("Error sending report: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a report with the wrong key to be unauthorized, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// skippedFile is the path of the CSV file that the legacy rows skipped
+	// by the migration are written to, if any.
+	skippedFile string
+
+	// skippedRecords writes to skippedFile when it is set.
+	skippedRecords *skippedWriter
+)
+
+// skippedHeader is the header row of skippedFile.
+var skippedHeader = []string{"section", "kind", "address", "subnet", "description", "hostname", "reason"}
+
+// skippedWriter writes skipped legacy rows to a CSV file. It is shared by the
+// sections, which are migrated concurrently.
+type skippedWriter struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *csv.Writer
+	count int
+}
+
+// createSkippedFile creates skippedFile and writes its header. The file is
+// closed on exit, including fatal exits, so that the rows skipped before a
+// failure are kept.
+func createSkippedFile() {
+	f, err := os.Create(skippedFile)
+	if err != nil {
+		logrus.Fatalf("Error creating skipped records file: %s", err)
+	}
+	skippedRecords = &skippedWriter{f: f, w: csv.NewWriter(f)}
+	skippedRecords.w.Write(skippedHeader)
+	logrus.RegisterExitHandler(closeSkippedFile)
+}
+
+// recordSkipped returns a callback for legacydb.Reader that writes the rows
+// skipped in the legacy section with ID section to skippedFile, or nil if it
+// is not set. Rows that belong to no section are written with section 0.
+func recordSkipped(section int) func(legacydb.Skip) {
+	if skippedRecords == nil {
+		return nil
+	}
+	s := skippedRecords
+	return func(v legacydb.Skip) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.count++
+		s.w.Write([]string{strconv.Itoa(section), v.Kind, v.Address, v.Subnet, v.Description, v.Hostname, v.Reason})
+	}
+}
+
+// closeSkippedFile flushes and closes skippedFile.
+func closeSkippedFile() {
+	if skippedRecords == nil {
+		return
+	}
+	skippedRecords.mu.Lock()
+	defer skippedRecords.mu.Unlock()
+	skippedRecords.w.Flush()
+	err := skippedRecords.w.Error()
+	if cerr := skippedRecords.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logrus.Errorf("Error writing skipped records to %s: %s", skippedFile, err)
+	} else {
+		logrus.Infof("%d skipped records written to %s", skippedRecords.count, skippedFile)
+	}
+	skippedRecords = nil
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/devices"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/nameservers"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/vrfs"
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-legacy-migrator/pipeline"
+	"github.com/paybyphone/phpipam-legacy-migrator/transform"
+	"github.com/sirupsen/logrus"
+)
+
+// The data shared by all sections. This is populated by the fetch stage of
+// each entity, and then operated on by the later stages. The data specific to
+// each section is held in its sectionRun.
+var (
+	// legacyVLANs holds the VLANs fetched from the legacy DB.
+	legacyVLANs []legacydb.VLAN
+
+	// legacyVRFs holds the VRFs fetched from the legacy DB.
+	legacyVRFs []vrfs.VRF
+
+	// legacyNameservers holds the nameserver sets fetched from the legacy DB.
+	legacyNameservers []nameservers.Nameserver
+
+	// legacySwitches holds the switch inventory fetched from the legacy DB.
+	legacySwitches helper.SwitchInventory
+
+	// legacyDeviceTypes holds the device types fetched from the legacy DB.
+	legacyDeviceTypes []devices.DeviceType
+
+	// legacyUsers and legacyGroups hold the users and groups fetched from the
+	// legacy DB.
+	legacyUsers  []users.User
+	legacyGroups []users.Group
+
+	// stageLog is the logger for the shared stages, tagged with the entity and
+	// phase of the stage running.
+	stageLog = logrus.NewEntry(logrus.StandardLogger())
+)
+
+// migrationPipeline builds the migration pipeline for the objects in the
+// legacy DB in conn that are shared by all sections, running the supplied
+// stages.
+//
+// The entities are processed in dependency order: the custom fields that
+// legacy custom columns are mapped to (if any) first, as the values of the
+// other entities are written to them, then L2 domains (if enabled), which
+// VLANs are created in, then VLANs, VRFs, and nameserver sets (if enabled), as
+// subnets reference them, then devices (if enabled), which addresses
+// reference, and users (if enabled), which nothing references. The section
+// pipelines are run once this pipeline has completed.
+func migrationPipeline(conn *sql.DB, stages []string) *pipeline.Pipeline {
+	p := &pipeline.Pipeline{
+		Stages: stages,
+		OnStage: func(entity, stage string) {
+			stageLog = logrus.WithFields(logrus.Fields{"entity": entity, "phase": stage})
+			stageLog.Debugf("Running %s stage for %s", stage, entity)
+		},
+	}
+
+	if sectionHierarchy {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "sections",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch: func() (err error) {
+					legacySectionMasters, err = fetchSectionMasters(conn)
+					return
+				},
+				pipeline.Write: func() error {
+					c, ok := sink.(ipamsink.SectionUpdater)
+					if !ok {
+						return fmt.Errorf("sections cannot be updated in %T", sink)
+					}
+					return addSectionHierarchy(c, legacySectionMasters)
+				},
+			},
+		})
+	}
+
+	if mapsCustomFields() {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "custom_fields",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Write: func() error {
+					c, ok := sink.(ipamsink.CustomFieldCreator)
+					if !ok {
+						stageLog.Debugf("Custom fields are not created in %T", sink)
+						return nil
+					}
+					return addCustomFields(c)
+				},
+			},
+		})
+	}
+
+	if migratesL2Domains() {
+		c, ok := sink.(ipamsink.L2DomainCreator)
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "l2domains",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch: func() error { return fetchL2Domains(conn) },
+				pipeline.Resolve: func() error {
+					if !ok {
+						return fmt.Errorf("L2 domains cannot be read from %T", sink)
+					}
+					return loadL2DomainIDs(c)
+				},
+				pipeline.Write: func() error {
+					if !ok {
+						return fmt.Errorf("L2 domains cannot be written to %T", sink)
+					}
+					return addL2Domains(c, legacyL2Domains)
+				},
+			},
+		})
+	}
+
+	vlanStages := map[string]pipeline.StageFunc{
+		pipeline.Fetch: func() (err error) {
+			legacyVLANs, err = fetchVLANs(conn)
+			fetchedVLANs = append([]legacydb.VLAN(nil), legacyVLANs...)
+			return
+		},
+		pipeline.Validate:  validateVLANs,
+		pipeline.Transform: transformVLANs,
+		pipeline.Write:     func() error { return addVLANs(sink, legacyVLANs) },
+	}
+	if migratesL2Domains() {
+		vlanStages[pipeline.Resolve] = resolveVLANs
+	}
+	p.Entities = append(p.Entities, pipeline.Entity{Name: "vlans", Stages: vlanStages})
+
+	if migrateVRFs {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "vrfs",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch: func() (err error) {
+					legacyVRFs, err = fetchVRFs(conn)
+					return
+				},
+				pipeline.Write: func() error { return addVRFs(sink, legacyVRFs) },
+			},
+		})
+	}
+
+	if migrateNameservers {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "nameservers",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch: func() (err error) {
+					legacyNameservers, err = fetchNameservers(conn)
+					return
+				},
+				pipeline.Write: func() error {
+					c, ok := sink.(ipamsink.NameserverCreator)
+					if !ok {
+						return fmt.Errorf("nameserver sets cannot be written to %T", sink)
+					}
+					return addNameservers(c, legacyNameservers)
+				},
+			},
+		})
+	}
+
+	if migrateDevices {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "devices",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch: func() (err error) {
+					if legacySwitches, err = fetchSwitches(conn); err != nil {
+						return
+					}
+					legacyDeviceTypes, err = fetchDeviceTypes(conn)
+					return
+				},
+				pipeline.Write: func() error { return addDevices(legacySwitches, legacyDeviceTypes) },
+			},
+		})
+	}
+
+	if migrateUsers {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "users",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch: func() (err error) {
+					legacyUsers, legacyGroups, err = fetchUsers(conn)
+					return
+				},
+				pipeline.Write: func() error {
+					c, ok := sink.(ipamsink.UserCreator)
+					if !ok {
+						return fmt.Errorf("users cannot be written to %T", sink)
+					}
+					return addUsers(c, legacyUsers, legacyGroups)
+				},
+			},
+		})
+	}
+
+	return p
+}
+
+// pipeline builds the migration pipeline for the section, running the
+// supplied stages. Subnets are processed before addresses, and then IP
+// requests and changelog entries (if enabled), which reference them.
+func (s *sectionRun) pipeline(conn *sql.DB, stages []string) *pipeline.Pipeline {
+	p := &pipeline.Pipeline{
+		Stages: stages,
+		OnStage: func(entity, stage string) {
+			s.log = s.baseLog.WithFields(logrus.Fields{"entity": entity, "phase": stage})
+			s.log.Debugf("Running %s stage for %s", stage, entity)
+		},
+	}
+
+	p.Entities = append(p.Entities, pipeline.Entity{
+		Name: "subnets",
+		Stages: map[string]pipeline.StageFunc{
+			pipeline.Fetch:     func() error { return s.fetchSubnets(conn) },
+			pipeline.Validate:  s.validateSubnets,
+			pipeline.Transform: s.transformSubnets,
+			pipeline.Resolve:   s.resolveSubnets,
+			pipeline.Write:     func() error { return s.addSubnets(sink, s.subnets) },
+		},
+	})
+
+	p.Entities = append(p.Entities, pipeline.Entity{
+		Name: "addresses",
+		Stages: map[string]pipeline.StageFunc{
+			pipeline.Fetch:     func() error { return s.fetchAddresses(conn) },
+			pipeline.Validate:  s.validateAddresses,
+			pipeline.Transform: s.transformAddresses,
+			pipeline.Resolve:   func() error { return s.resolveAddresses(sink) },
+			pipeline.Write:     func() error { return s.addAddresses(sink, s.addresses) },
+			pipeline.Verify:    func() error { return s.verifyAddresses(transform.AddressesToWrite(s.addresses, changeNotes)) },
+		},
+	})
+
+	if migrateRequests {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "requests",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch:   func() error { return s.fetchRequests(conn) },
+				pipeline.Resolve: s.resolveRequests,
+				pipeline.Write: func() error {
+					c, ok := sink.(ipamsink.RequestCreator)
+					if !ok {
+						return fmt.Errorf("IP requests cannot be written to %T", sink)
+					}
+					return s.addRequests(c, s.requests)
+				},
+			},
+		})
+	}
+
+	if withChangelog {
+		p.Entities = append(p.Entities, pipeline.Entity{
+			Name: "changelog",
+			Stages: map[string]pipeline.StageFunc{
+				pipeline.Fetch:   func() error { return s.fetchChangelog(conn) },
+				pipeline.Resolve: s.resolveChangelog,
+				pipeline.Write: func() error {
+					c, ok := sink.(ipamsink.ChangelogCreator)
+					if !ok {
+						return fmt.Errorf("changelog entries cannot be written to %T", sink)
+					}
+					return s.addChangelog(c, s.changes)
+				},
+			},
+		})
+	}
+
+	return p
+}
+
+// validVLANNumber returns true if n is a VLAN number that can be created in the
+// new PHPIPAM instance. Legacy PHPIPAM 0.8 accepted any number.
+func validVLANNumber(n int) bool {
+	return n >= 1 && n <= 4094
+}
+
+// validateVLANs skips the VLANs whose number is outside 1-4094, which the new
+// PHPIPAM instance rejects, warning about them and writing them to
+// skippedFile. The subnets that use them are migrated without a VLAN.
+func validateVLANs() error {
+	record := recordSkipped(0)
+	var out []legacydb.VLAN
+	for _, v := range legacyVLANs {
+		if validVLANNumber(v.Number) {
+			out = append(out, v)
+			continue
+		}
+		recordsTotal.Inc("vlans", "skipped")
+		stageLog.WithField("vlan", v.Number).Warnf("VLAN %q has number %d, which is outside 1-4094, skipping", v.Name, v.Number)
+		if record != nil {
+			record(legacydb.Skip{
+				Kind:        "vlan",
+				Address:     strconv.Itoa(v.Number),
+				Description: v.Name,
+				Reason:      "VLAN number outside 1-4094",
+			})
+		}
+	}
+	legacyVLANs = out
+	return nil
+}
+
+// transformVLANs transcodes the VLANs from latin1 with -source-charset
+// latin1, decodes their HTML entities with -decode-html-entities, and then
+// runs the VLAN hooks on them.
+func transformVLANs() error {
+	if sourceCharset == "latin1" {
+		stageLog.Debugf("Transcoded %d VLANs from latin1", transform.Latin1VLANs(legacyVLANs))
+	}
+	if decodeHTMLEntities {
+		stageLog.Debugf("Decoded HTML entities in %d VLANs", transform.UnescapeVLANs(legacyVLANs))
+	}
+	out, dropped, err := migrationHooks.VLANs(legacyVLANs)
+	if err != nil {
+		return err
+	}
+	legacyVLANs = out
+	if dropped > 0 {
+		recordsTotal.Add(float64(dropped), "vlans", "dropped")
+		stageLog.Infof("Hooks dropped %d VLANs", dropped)
+	}
+	if stamp != "" {
+		transform.StampVLANs(legacyVLANs, stamp, stampField)
+	}
+	return nil
+}
+
+// validateSubnets reports the conflicts between the section's subnets that
+// PHPIPAM would reject, with suggested resolutions. Unless overlappingSubnets
+// is warn, the section is aborted before any of its subnets are created.
+func (s *sectionRun) validateSubnets() error {
+	s.Overlaps = transform.SubnetOverlaps(s.subnets)
+	for _, v := range s.Overlaps {
+		s.log.WithField("cidr", fmt.Sprintf("%s/%d", v.Second.SubnetAddress, v.Second.Mask)).Warnf("Conflicting subnets: %s (suggested resolution: %s)", v, v.Suggestion())
+	}
+	if len(s.Overlaps) > 0 && overlappingSubnets == "abort" {
+		return fmt.Errorf("%d subnet conflicts found, which PHPIPAM would reject; resolve them in the legacy DB, or run with -overlapping-subnets warn", len(s.Overlaps))
+	}
+	return nil
+}
+
+// transformSubnets transcodes the section's subnets from latin1 with
+// -source-charset latin1, decodes their HTML entities with
+// -decode-html-entities, runs the subnet hooks on them, adds the
+// parents synthesized with aggregateParents, and then sorts them so that
+// parent subnets are created before their children, even if a hook changed
+// them.
+func (s *sectionRun) transformSubnets() error {
+	if sourceCharset == "latin1" {
+		s.log.Debugf("Transcoded %d subnets from latin1", transform.Latin1Subnets(s.subnets))
+	}
+	if decodeHTMLEntities {
+		s.log.Debugf("Decoded HTML entities in %d subnets", transform.UnescapeSubnets(s.subnets))
+	}
+	out, dropped, err := migrationHooks.Subnets(s.subnets)
+	if err != nil {
+		return err
+	}
+	s.subnets = out
+	if dropped > 0 {
+		recordsTotal.Add(float64(dropped), "subnets", "dropped")
+		s.log.Infof("Hooks dropped %d subnets", dropped)
+	}
+	if aggregateParents > 0 {
+		// Parents are only synthesized for subnets in the same section.
+		var parents []legacydb.Subnet
+		for _, section := range s.targetSections(s.subnets) {
+			var nets []legacydb.Subnet
+			for _, v := range s.subnets {
+				if s.sectionOf(v) == section {
+					nets = append(nets, v)
+				}
+			}
+			parents = append(parents, transform.AggregateParents(nets, aggregateParents, aggregateAs == "folder")...)
+		}
+		for _, v := range parents {
+			s.log.Infof("Synthesizing parent %s %s/%d: %s", aggregateAs, v.SubnetAddress, v.Mask, v.Description)
+		}
+		s.subnets = append(s.subnets, parents...)
+	}
+	if stamp != "" {
+		transform.StampSubnets(s.subnets, stamp, stampField)
+	}
+	transform.SortSubnets(s.subnets)
+	return nil
+}
+
+// validateAddresses skips the section's addresses that are the network or
+// broadcast address of their subnet if skipNetworkBroadcast is set, warning
+// about them and writing them to skippedFile.
+func (s *sectionRun) validateAddresses() error {
+	if !skipNetworkBroadcast {
+		return nil
+	}
+	record := recordSkipped(s.LegacyID)
+	var out []legacydb.Address
+	for _, v := range s.addresses {
+		kind := transform.NetworkOrBroadcast(v.IPAddress, v.SubnetCIDR)
+		if kind == "" {
+			out = append(out, v)
+			continue
+		}
+		s.SkippedNetworkBroadcast++
+		recordsTotal.Inc("addresses", "skipped")
+		s.log.WithField("ip", v.IPAddress).Warnf("IP address %s is the %s address of subnet %s, skipping", v.IPAddress, kind, v.SubnetCIDR)
+		if record != nil {
+			skip := legacydb.Skip{Kind: "address", Subnet: v.SubnetCIDR, Description: v.Description, Hostname: v.Hostname}
+			skip.Address, _ = legacydb.IPv4ToDecimal(v.IPAddress)
+			if ip, n, err := net.ParseCIDR(v.SubnetCIDR); err == nil {
+				size, _ := n.Mask.Size()
+				addr, _ := legacydb.IPv4ToDecimal(ip.String())
+				skip.Subnet = fmt.Sprintf("%s/%d", addr, size)
+			}
+			skip.Reason = fmt.Sprintf("%s address of its subnet", kind)
+			record(skip)
+		}
+	}
+	s.addresses = out
+	return nil
+}
+
+// transformAddresses alters the section's addresses to fit the new PHPIPAM
+// instance, and then runs the address hooks on them, so that hooks see (and
+// can override) the altered values. With -validate-hostnames, the hostnames
+// the hooks leave are then checked.
+func (s *sectionRun) transformAddresses() error {
+	if sourceCharset == "latin1" {
+		s.log.Debugf("Transcoded %d addresses from latin1", transform.Latin1Addresses(s.addresses))
+	}
+	if decodeHTMLEntities {
+		s.log.Debugf("Decoded HTML entities in %d addresses", transform.UnescapeAddresses(s.addresses))
+	}
+	transform.Addresses(s.addresses)
+	if reverseDNS {
+		s.log.Infof("Filled in %d blank hostnames from reverse DNS", s.fillHostnamesFromDNS())
+	}
+	if livenessCheck != "" {
+		s.log.Infof("Found %d of %d addresses alive", s.checkLiveness(), len(s.addresses))
+	}
+	if lowercaseHostnames || stripHostnameDots {
+		transform.NormalizeHostnames(s.addresses, lowercaseHostnames, stripHostnameDots)
+	}
+	if normalizeMACs {
+		for _, v := range transform.NormalizeMACs(s.addresses) {
+			s.log.Warnf("Could not parse MAC address %q, leaving it as it is", v)
+		}
+	}
+	if gatewayPosition != "" || gatewayPattern != nil {
+		if marked := transform.MarkGateways(s.addresses, gatewayPosition, gatewayPattern); marked > 0 {
+			s.log.Infof("Marked %d addresses as gateways", marked)
+		}
+	}
+	out, dropped, err := migrationHooks.Addresses(s.addresses)
+	if err != nil {
+		return err
+	}
+	s.addresses = out
+	if dropped > 0 {
+		recordsTotal.Add(float64(dropped), "addresses", "dropped")
+		s.log.Infof("Hooks dropped %d addresses", dropped)
+	}
+	if stamp != "" {
+		transform.StampAddresses(s.addresses, stamp, stampField)
+	}
+	if validateHostnames {
+		s.InvalidHostnames = transform.InvalidHostnames(s.addresses)
+		for _, v := range s.InvalidHostnames {
+			s.log.Warnf("Invalid RFC 1123 hostname: %s", v)
+		}
+	}
+	return nil
+}
+
+// resolveSubnets resolves the legacy VLAN numbers, VRF names, and nameserver
+// set names of the section's subnets to VLAN, VRF, and nameserver set IDs in
+// the new PHPIPAM instance.
+func (s *sectionRun) resolveSubnets() error {
+	for i, v := range s.subnets {
+		if v.VRFName != "" {
+			id, ok := vrfIDs[v.VRFName]
+			if !ok {
+				return fmt.Errorf("VRF %s not found in new PHPIPAM database", v.VRFName)
+			}
+			s.subnets[i].VRFID = id
+		}
+		if v.NameserverName != "" {
+			id, ok := nameserverIDs[v.NameserverName]
+			if !ok {
+				return fmt.Errorf("nameserver set %s not found in new PHPIPAM database", v.NameserverName)
+			}
+			s.subnets[i].NameserverID = id
+		}
+		if v.VLANNumber == 0 {
+			continue
+		}
+		if !validVLANNumber(v.VLANNumber) {
+			s.log.Warnf("Subnet %s/%d is not assigned to a VLAN, as VLAN number %d is outside 1-4094", v.SubnetAddress, v.Mask, v.VLANNumber)
+			s.subnets[i].VLANNumber = 0
+			continue
+		}
+		if id, ok := duplicateVLANIDs[v.VLANLegacyID]; ok {
+			s.subnets[i].VLANID = id
+			continue
+		}
+		id, err := vlanIDForNumber(v.VLANNumber)
+		switch {
+		case err != nil && missingVLANs == "create":
+			if id, err = createMissingVLAN(sink, v.VLANNumber); err != nil {
+				return fmt.Errorf("error creating missing VLAN number %d: %s", v.VLANNumber, err)
+			}
+		case err != nil && missingVLANs == "clear":
+			s.log.Warnf("Subnet %s/%d is not assigned to a VLAN, as VLAN number %d could not be found: %s", v.SubnetAddress, v.Mask, v.VLANNumber, err)
+			s.subnets[i].VLANNumber = 0
+			continue
+		case err != nil:
+			return fmt.Errorf("error getting VLAN ID for number %d: %s", v.VLANNumber, err)
+		}
+		s.subnets[i].VLANID = id
+	}
+	return nil
+}
+
+// resolveRequests resolves the legacy subnet CIDRs of the section's IP
+// requests to subnet IDs in the new PHPIPAM instance. The subnet IDs have
+// already been preloaded when the section's addresses were resolved.
+func (s *sectionRun) resolveRequests() error {
+	for i, v := range s.requests {
+		id, err := subnetIDForCIDR(s.subnetSection(v.SubnetCIDR), v.SubnetCIDR)
+		if err != nil {
+			return fmt.Errorf("error getting subnet ID for CIDR %s: %s", v.SubnetCIDR, err)
+		}
+		s.requests[i].SubnetID = id
+	}
+	return nil
+}
+
+// resolveChangelog resolves the legacy subnet CIDRs of the section's changelog
+// entries to subnet IDs in the new PHPIPAM instance, as resolveRequests does.
+func (s *sectionRun) resolveChangelog() error {
+	for i, v := range s.changes {
+		id, err := subnetIDForCIDR(s.subnetSection(v.SubnetCIDR), v.SubnetCIDR)
+		if err != nil {
+			return fmt.Errorf("error getting subnet ID for CIDR %s: %s", v.SubnetCIDR, err)
+		}
+		s.changes[i].SubnetID = id
+	}
+	return nil
+}
+
+// resolveAddresses resolves the legacy subnet CIDRs and switch names of the
+// section's addresses to subnet and device IDs in the new PHPIPAM instance.
+// The section's subnets are preloaded with f first, so that the subnet IDs can
+// be resolved locally.
+func (s *sectionRun) resolveAddresses(f ipamsink.SubnetFinder) error {
+	if err := s.preloadSubnetIDs(f); err != nil {
+		return err
+	}
+	for i, v := range s.addresses {
+		id, err := subnetIDForCIDR(s.subnetSection(v.SubnetCIDR), v.SubnetCIDR)
+		if err != nil {
+			return fmt.Errorf("error getting subnet ID for CIDR %s: %s", v.SubnetCIDR, err)
+		}
+		s.addresses[i].SubnetID = id
+		s.addresses[i].DeviceID = switchDeviceIDs[helper.SwitchKey(v.Switch)]
+	}
+	return nil
+}
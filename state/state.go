@@ -0,0 +1,127 @@
+// Package state provides the migrator's persistent state, which is carried
+// between runs in a JSON state file.
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// LegacyTables are the legacy DB tables that the migrator reads from, and that
+// are snapshotted to detect writes.
+var LegacyTables = []string{"vlans", "subnets", "ipaddresses"}
+
+// State is the persistent state of the migrator.
+type State struct {
+	// A snapshot of the legacy DB taken at the end of the last successful
+	// migration run.
+	LastSync *Snapshot `json:"last_sync,omitempty"`
+
+	// The time that the last successful migration run started, from which
+	// delta passes pick up the legacy rows edited since.
+	LastRunStarted time.Time `json:"last_run_started,omitempty"`
+
+	// The highest legacy address ID read by a successful migration run, so
+	// that delta passes can pick up the addresses added since, which have no
+	// edit date until they are first edited.
+	MaxAddressID int `json:"max_address_id,omitempty"`
+
+	// A snapshot of the legacy DB taken when the final cutover pass started,
+	// marking the start of the write freeze.
+	Freeze *Snapshot `json:"freeze,omitempty"`
+}
+
+// Load reads the state file at path. A missing file is not an error, and
+// results in an empty state.
+func Load(path string) (*State, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out State
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %s", path, err)
+	}
+	return &out, nil
+}
+
+// Save writes the state to path.
+func (s *State) Save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// TableState is the state of a single legacy DB table.
+type TableState struct {
+	// The number of rows in the table.
+	Rows int64 `json:"rows"`
+
+	// The table checksum, as reported by CHECKSUM TABLE. This changes on any
+	// write to the table, including edits that do not alter the row count.
+	Checksum int64 `json:"checksum"`
+}
+
+// Snapshot is the state of the legacy DB tables at a point in time.
+type Snapshot struct {
+	// The time the snapshot was taken.
+	Taken time.Time `json:"taken"`
+
+	// The state of each table, keyed by table name.
+	Tables map[string]TableState `json:"tables"`
+}
+
+// TakeSnapshot records the row counts and checksums of the supplied tables.
+func TakeSnapshot(conn *sql.DB, tables []string) (*Snapshot, error) {
+	out := &Snapshot{
+		Taken:  time.Now(),
+		Tables: make(map[string]TableState),
+	}
+	for _, t := range tables {
+		var ts TableState
+		if err := conn.QueryRow(fmt.Sprintf("select count(*) from %s", t)).Scan(&ts.Rows); err != nil {
+			return nil, fmt.Errorf("error counting rows in %s: %s", t, err)
+		}
+		var name string
+		var checksum sql.NullInt64
+		if err := conn.QueryRow(fmt.Sprintf("checksum table %s", t)).Scan(&name, &checksum); err != nil {
+			return nil, fmt.Errorf("error checksumming %s: %s", t, err)
+		}
+		ts.Checksum = checksum.Int64
+		out.Tables[t] = ts
+	}
+	return out, nil
+}
+
+// Changes compares the snapshot against a later one, and returns a
+// description of each table that changed, sorted by table name.
+func (s *Snapshot) Changes(later *Snapshot) (out []string) {
+	var names []string
+	for k := range later.Tables {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		before, ok := s.Tables[k]
+		after := later.Tables[k]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("%s: not present in earlier snapshot", k))
+		case before.Rows != after.Rows:
+			out = append(out, fmt.Sprintf("%s: row count changed from %d to %d", k, before.Rows, after.Rows))
+		case before.Checksum != after.Checksum:
+			out = append(out, fmt.Sprintf("%s: rows edited (checksum changed)", k))
+		}
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotChanges(t *testing.T) {
+	before := &Snapshot{
+		Tables: map[string]TableState{
+			"vlans":       {Rows: 10, Checksum: 1},
+			"subnets":     {Rows: 20, Checksum: 2},
+			"ipaddresses": {Rows: 30, Checksum: 3},
+		},
+	}
+	after := &Snapshot{
+		Tables: map[string]TableState{
+			"vlans":       {Rows: 10, Checksum: 1},
+			"subnets":     {Rows: 20, Checksum: 5},
+			"ipaddresses": {Rows: 31, Checksum: 4},
+		},
+	}
+
+	expected := []string{
+		"ipaddresses: row count changed from 30 to 31",
+		"subnets: rows edited (checksum changed)",
+	}
+	if actual := before.Changes(after); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+	if actual := before.Changes(before); len(actual) != 0 {
+		t.Fatalf("Expected no changes, got %v", actual)
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error loading missing state file: %s", err)
+	}
+	if s.LastSync != nil {
+		t.Fatal("Expected empty state from missing state file")
+	}
+
+	s.LastSync = &Snapshot{Tables: map[string]TableState{"vlans": {Rows: 1, Checksum: 2}}}
+	s.MaxAddressID = 42
+	s.Freeze = &Snapshot{Tables: map[string]TableState{"vlans": {Rows: 1, Checksum: 3}}}
+	s.LastRunStarted = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := s.Save(path); err != nil {
+		t.Fatalf("Error saving state: %s", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error loading state: %s", err)
+	}
+	if !reflect.DeepEqual(s.LastSync.Tables, loaded.LastSync.Tables) {
+		t.Fatalf("Expected %v, got %v", s.LastSync.Tables, loaded.LastSync.Tables)
+	}
+	if loaded.Freeze == nil || !reflect.DeepEqual(s.Freeze.Tables, loaded.Freeze.Tables) {
+		t.Fatalf("Expected freeze %v, got %v", s.Freeze, loaded.Freeze)
+	}
+	if loaded.MaxAddressID != 42 || !loaded.LastRunStarted.Equal(s.LastRunStarted) {
+		t.Fatalf("Expected max address ID 42 and last run start %s, got %#v", s.LastRunStarted, loaded)
+	}
+}
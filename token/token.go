@@ -0,0 +1,195 @@
+// Package token keeps the PHPIPAM session token fresh during long migrations.
+//
+// PHPIPAM session tokens expire after a period of time (6 hours by default),
+// and long migrations outlive them. The PHPIPAM SDK only recovers from one
+// specific expiry response, and is not safe to re-login from concurrently, so
+// token management is instead done at the HTTP transport level: requests are
+// sent with the latest token, and requests rejected due to an expired or
+// invalid token are retried once after logging in again.
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+	"github.com/sirupsen/logrus"
+)
+
+// tokenHeader is the header the PHPIPAM API expects the session token in.
+const tokenHeader = "phpipam-token"
+
+// Transport is a http.RoundTripper that refreshes the PHPIPAM session token
+// as needed. It is safe for concurrent use.
+type Transport struct {
+	// The underlying transport. http.DefaultTransport is used if this is nil.
+	Transport http.RoundTripper
+
+	// The PHPIPAM configuration, used to log in again.
+	Config phpipam.Config
+
+	mu    sync.Mutex
+	token string
+}
+
+// loginResponse is the response to a login request.
+type loginResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Token string `json:"token"`
+	} `json:"data"`
+}
+
+// RoundTrip implements http.RoundTripper for Transport.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isLogin(req) {
+		return t.roundTripLogin(req)
+	}
+
+	token := t.current()
+	resp, err := t.send(req, token)
+	if err != nil || !isTokenError(resp) {
+		return resp, err
+	}
+	resp.Body.Close()
+	if req.Body != nil && req.GetBody == nil {
+		return nil, fmt.Errorf("PHPIPAM session token rejected, and request body cannot be resent")
+	}
+
+	if err := t.refresh(token); err != nil {
+		return nil, fmt.Errorf("error refreshing PHPIPAM session token: %s", err)
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return t.send(req, t.current())
+}
+
+// roundTripLogin passes a login request made by the SDK through, recording
+// the token it returns.
+func (t *Transport) roundTripLogin(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport().RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var out loginResponse
+	if err := json.Unmarshal(body, &out); err == nil && out.Data.Token != "" {
+		t.mu.Lock()
+		t.token = out.Data.Token
+		t.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// send sends req with the supplied token, if any.
+func (t *Transport) send(req *http.Request, token string) (*http.Response, error) {
+	if token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(tokenHeader, token)
+	}
+	return t.transport().RoundTrip(req)
+}
+
+// current returns the current token.
+func (t *Transport) current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token
+}
+
+// refresh logs in again, replacing the rejected token old. If another request
+// has already replaced it, the new token is used instead of logging in again.
+func (t *Transport) refresh(old string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != old {
+		return nil
+	}
+	logrus.Info("PHPIPAM session token expired, logging in again")
+	token, err := t.login()
+	if err != nil {
+		return err
+	}
+	t.token = token
+	return nil
+}
+
+// login logs in to the PHPIPAM API, and returns the new token.
+func (t *Transport) login() (string, error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s/user/", t.Config.Endpoint, t.Config.AppID), strings.NewReader("{}"))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(t.Config.Username, t.Config.Password)
+	resp, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var out loginResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", fmt.Errorf("error parsing login response (%s): %s", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || out.Data.Token == "" {
+		return "", fmt.Errorf("login failed (%s): %s", resp.Status, out.Message)
+	}
+	return out.Data.Token, nil
+}
+
+// transport returns the underlying transport.
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+// isLogin returns true if req is a login request.
+func isLogin(req *http.Request) bool {
+	return req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/user/")
+}
+
+// isTokenError returns true if resp rejects the request due to an expired or
+// invalid token. The body of resp is preserved.
+func isTokenError(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	var out struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return false
+	}
+	msg := strings.ToLower(out.Message)
+	return strings.Contains(msg, "token") && (strings.Contains(msg, "expired") || strings.Contains(msg, "invalid"))
+}
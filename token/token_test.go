@@ -0,0 +1,111 @@
+package token
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+)
+
+func TestTransportRefresh(t *testing.T) {
+	var mu sync.Mutex
+	valid := "one"
+	var logins int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == "POST" && r.URL.Path == "/test/user/" {
+			if u, p, _ := r.BasicAuth(); u != "admin" || p != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"code":401,"success":false,"message":"Invalid username or password"}`))
+				return
+			}
+			logins++
+			if logins > 1 {
+				valid = "two"
+			}
+			w.Write([]byte(`{"code":200,"success":true,"data":{"token":"` + valid + `","expires":"2017-01-01 00:00:00"}}`))
+			return
+		}
+		if r.Header.Get("phpipam-token") != valid {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"code":403,"success":false,"message":"Token expired"}`))
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"code":200,"success":true,"data":"` + string(b) + `"}`))
+	}))
+	defer ts.Close()
+
+	tr := &Transport{Config: phpipam.Config{Endpoint: ts.URL, AppID: "test", Username: "admin", Password: "secret"}}
+	c := &http.Client{Transport: tr}
+
+	// The initial login is made by the SDK, and its token recorded.
+	req, _ := http.NewRequest("POST", ts.URL+"/test/user/", strings.NewReader("{}"))
+	req.SetBasicAuth("admin", "secret")
+	resp, err := c.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	resp.Body.Close()
+	if tr.current() != "one" {
+		t.Fatalf("Expected token one to be recorded, got %q", tr.current())
+	}
+
+	// Expire the token, and ensure that the request is retried with a new one.
+	mu.Lock()
+	valid = "expired"
+	mu.Unlock()
+	resp, err = c.Post(ts.URL+"/test/vlans/", "application/json", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "foo") {
+		t.Fatalf("Expected successful retry with body, got %s: %s", resp.Status, b)
+	}
+	if tr.current() != "two" || logins != 2 {
+		t.Fatalf("Expected token two after 2 logins, got %q after %d logins", tr.current(), logins)
+	}
+}
+
+func TestTransportRefreshFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":403,"success":false,"message":"Token expired"}`))
+	}))
+	defer ts.Close()
+
+	tr := &Transport{Config: phpipam.Config{Endpoint: ts.URL, AppID: "test"}}
+	if _, err := (&http.Client{Transport: tr}).Get(ts.URL + "/test/vlans/"); err == nil {
+		t.Fatal("Expected error when login fails, got none")
+	}
+}
+
+func TestIsTokenError(t *testing.T) {
+	cases := []struct {
+		code     int
+		body     string
+		expected bool
+	}{
+		{403, `{"code":403,"message":"Token expired"}`, true},
+		{401, `{"code":401,"message":"Invalid token"}`, true},
+		{403, `{"code":403,"message":"Permission denied"}`, false},
+		{404, `{"code":404,"message":"Token expired"}`, false},
+		{403, `not json`, false},
+	}
+	for _, c := range cases {
+		resp := &http.Response{StatusCode: c.code, Body: ioutil.NopCloser(strings.NewReader(c.body))}
+		if actual := isTokenError(resp); c.expected != actual {
+			t.Fatalf("Expected isTokenError for %d %s to be %t, got %t", c.code, c.body, c.expected, actual)
+		}
+		if b, _ := ioutil.ReadAll(resp.Body); string(b) != c.body && c.code != 404 {
+			t.Fatalf("Expected body to be preserved, got %q", b)
+		}
+	}
+}
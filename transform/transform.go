@@ -0,0 +1,544 @@
+// Package transform alters the objects read from the legacy DB to fit the new
+// PHPIPAM instance, and converts them to the objects written to it.
+package transform
+
+import (
+	"fmt"
+	"html"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam"
+)
+
+// MaxAddressDescription is the maximum length of an address description in
+// the new PHPIPAM database.
+const MaxAddressDescription = 64
+
+// MaxAddressPort is the maximum length of an address's switch port in the new
+// PHPIPAM database.
+const MaxAddressPort = 32
+
+// SortSubnets sorts subnets in the same order as helper.SubnetsSorter, so that
+// parent subnets are created before their children.
+func SortSubnets(nets []legacydb.Subnet) {
+	sort.SliceStable(nets, func(i, j int) bool {
+		return helper.SubnetLess(nets[i].Subnet, nets[j].Subnet)
+	})
+}
+
+// Addresses alters addresses in place to fit the new PHPIPAM instance,
+// recording each change made on the address.
+func Addresses(addrs []legacydb.Address) {
+	for i := range addrs {
+		a := &addrs[i]
+		if h := strings.TrimSpace(a.Hostname); h != a.Hostname {
+			a.Hostname = h
+			a.RecordChange("hostname sanitized (surrounding whitespace removed)")
+		}
+		if d, ok := helper.Truncate(a.Description, MaxAddressDescription); ok {
+			a.RecordChange("description truncated from %d to %d characters (full text: %q)", len([]rune(a.Description)), MaxAddressDescription, a.Description)
+			a.Description = d
+		}
+		if p, ok := helper.Truncate(a.Port, MaxAddressPort); ok {
+			a.RecordChange("port truncated from %d to %d characters (full text: %q)", len([]rune(a.Port)), MaxAddressPort, a.Port)
+			a.Port = p
+		}
+	}
+}
+
+// NormalizeMACs rewrites the MAC addresses of addrs in place as
+// colon-separated lowercase, recording each change made on the address. The
+// MAC addresses that cannot be parsed are left as they are, and returned.
+func NormalizeMACs(addrs []legacydb.Address) []string {
+	var invalid []string
+	for i := range addrs {
+		a := &addrs[i]
+		if a.MACAddress == "" {
+			continue
+		}
+		mac, ok := helper.NormalizeMAC(a.MACAddress)
+		if !ok {
+			invalid = append(invalid, a.MACAddress)
+			continue
+		}
+		if mac != a.MACAddress {
+			a.RecordChange("MAC address normalized from %q", a.MACAddress)
+			a.MACAddress = mac
+		}
+	}
+	return invalid
+}
+
+// NormalizeHostnames rewrites the hostnames of addrs in place in lowercase if
+// lowercase is set, and without their trailing dots if stripDots is set,
+// recording each change made on the address.
+func NormalizeHostnames(addrs []legacydb.Address, lowercase, stripDots bool) {
+	for i := range addrs {
+		a := &addrs[i]
+		h := a.Hostname
+		if lowercase {
+			h = strings.ToLower(h)
+		}
+		if stripDots {
+			h = strings.TrimRight(h, ".")
+		}
+		if h != a.Hostname {
+			a.RecordChange("hostname normalized from %q", a.Hostname)
+			a.Hostname = h
+		}
+	}
+}
+
+// InvalidHostnames returns the addresses of addrs whose hostname is set but is
+// not a valid RFC 1123 hostname, as "IP (hostname)".
+func InvalidHostnames(addrs []legacydb.Address) (out []string) {
+	for _, a := range addrs {
+		if a.Hostname != "" && !helper.ValidHostname(a.Hostname) {
+			out = append(out, fmt.Sprintf("%s (%s)", a.IPAddress, a.Hostname))
+		}
+	}
+	return out
+}
+
+// StampVLANs marks vlans in place as migrated with stamp, written to the
+// custom field named field, or appended to their descriptions if field is
+// blank.
+func StampVLANs(vlans []legacydb.VLAN, stamp, field string) {
+	for i := range vlans {
+		v := &vlans[i]
+		if field != "" {
+			v.CustomFields = withField(v.CustomFields, field, stamp)
+			continue
+		}
+		v.Description = appendStamp(v.Description, stamp)
+	}
+}
+
+// StampSubnets marks nets in place as migrated with stamp, like StampVLANs.
+func StampSubnets(nets []legacydb.Subnet, stamp, field string) {
+	for i := range nets {
+		v := &nets[i]
+		if field != "" {
+			v.CustomFields = withField(v.CustomFields, field, stamp)
+			continue
+		}
+		v.Description = appendStamp(v.Description, stamp)
+	}
+}
+
+// StampAddresses marks addrs in place as migrated with stamp, like
+// StampVLANs. Descriptions that would be too long with the stamp appended are
+// truncated to fit it, recording the change made on the address.
+func StampAddresses(addrs []legacydb.Address, stamp, field string) {
+	for i := range addrs {
+		a := &addrs[i]
+		if field != "" {
+			a.CustomFields = withField(a.CustomFields, field, stamp)
+			continue
+		}
+		if d, ok := helper.Truncate(a.Description, MaxAddressDescription-len([]rune(stamp))-1); ok {
+			a.RecordChange("description truncated from %d to %d characters to fit the migration stamp (full text: %q)", len([]rune(a.Description)), len([]rune(d)), a.Description)
+			a.Description = d
+		}
+		a.Description = appendStamp(a.Description, stamp)
+	}
+}
+
+// appendStamp returns s with stamp appended, separated by a space unless s is
+// blank.
+func appendStamp(s, stamp string) string {
+	if s == "" {
+		return stamp
+	}
+	return s + " " + stamp
+}
+
+// withField returns fields with the custom field name set to value, creating
+// the map if fields is nil.
+func withField(fields map[string]string, name, value string) map[string]string {
+	if fields == nil {
+		fields = make(map[string]string)
+	}
+	fields[name] = value
+	return fields
+}
+
+// Latin1VLANs transcodes the names and descriptions of vlans in place from
+// latin1 to UTF-8, and returns the number of VLANs transcoded.
+func Latin1VLANs(vlans []legacydb.VLAN) (n int) {
+	for i := range vlans {
+		v := &vlans[i]
+		if fromLatin1(&v.Name, &v.Description) {
+			n++
+		}
+	}
+	return n
+}
+
+// Latin1Subnets transcodes the descriptions of nets in place from latin1 to
+// UTF-8, and returns the number of subnets transcoded.
+func Latin1Subnets(nets []legacydb.Subnet) (n int) {
+	for i := range nets {
+		if fromLatin1(&nets[i].Description) {
+			n++
+		}
+	}
+	return n
+}
+
+// Latin1Addresses transcodes the descriptions, hostnames, and notes of addrs
+// in place from latin1 to UTF-8, and returns the number of addresses
+// transcoded.
+func Latin1Addresses(addrs []legacydb.Address) (n int) {
+	for i := range addrs {
+		a := &addrs[i]
+		if fromLatin1(&a.Description, &a.Hostname, &a.Note) {
+			n++
+		}
+	}
+	return n
+}
+
+// fromLatin1 transcodes each of fields from latin1 to UTF-8 with
+// helper.Latin1ToUTF8, and reports whether or not any was transcoded.
+func fromLatin1(fields ...*string) bool {
+	var transcoded bool
+	for _, f := range fields {
+		if s, ok := helper.Latin1ToUTF8(*f); ok {
+			*f = s
+			transcoded = true
+		}
+	}
+	return transcoded
+}
+
+// UnescapeVLANs decodes the HTML entities (ie: &amp;) in the names and
+// descriptions of vlans in place, and returns the number of VLANs changed.
+func UnescapeVLANs(vlans []legacydb.VLAN) (n int) {
+	for i := range vlans {
+		v := &vlans[i]
+		name, desc := unescape(&v.Name), unescape(&v.Description)
+		if name || desc {
+			n++
+		}
+	}
+	return n
+}
+
+// UnescapeSubnets decodes the HTML entities in the descriptions of nets in
+// place, and returns the number of subnets changed.
+func UnescapeSubnets(nets []legacydb.Subnet) (n int) {
+	for i := range nets {
+		if unescape(&nets[i].Description) {
+			n++
+		}
+	}
+	return n
+}
+
+// UnescapeAddresses decodes the HTML entities in the descriptions, hostnames,
+// and notes of addrs in place, recording each change made on the address, and
+// returns the number of addresses changed.
+func UnescapeAddresses(addrs []legacydb.Address) (n int) {
+	for i := range addrs {
+		a := &addrs[i]
+		var changed bool
+		for _, f := range []struct {
+			name  string
+			value *string
+		}{{"description", &a.Description}, {"hostname", &a.Hostname}, {"note", &a.Note}} {
+			if old := *f.value; unescape(f.value) {
+				a.RecordChange("%s HTML entities decoded (was %q)", f.name, old)
+				changed = true
+			}
+		}
+		if changed {
+			n++
+		}
+	}
+	return n
+}
+
+// unescape decodes the HTML entities in *s, and reports whether or not there
+// were any.
+func unescape(s *string) bool {
+	if !strings.Contains(*s, "&") {
+		return false
+	}
+	u := html.UnescapeString(*s)
+	if u == *s {
+		return false
+	}
+	*s = u
+	return true
+}
+
+// MarkGateways marks the addresses in addrs that look like their subnet's
+// gateway as gateways, recording the change on each, and returns the number
+// marked. An address looks like a gateway if position is first or last and it
+// is the first or last usable address of its subnet, or if its description or
+// hostname matches pattern, which can be nil. Subnets narrower than /30 have
+// no first or last usable address.
+func MarkGateways(addrs []legacydb.Address, position string, pattern *regexp.Regexp) (marked int) {
+	for i := range addrs {
+		a := &addrs[i]
+		if a.IsGateway {
+			continue
+		}
+		var reason string
+		switch {
+		case position != "" && usableAddress(a.SubnetCIDR, position) == a.IPAddress:
+			reason = fmt.Sprintf("the %s usable address of subnet %s", position, a.SubnetCIDR)
+		case pattern != nil && (pattern.MatchString(a.Description) || pattern.MatchString(a.Hostname)):
+			reason = fmt.Sprintf("its description or hostname matches %q", pattern)
+		default:
+			continue
+		}
+		a.IsGateway = true
+		a.RecordChange("marked as gateway, as %s", reason)
+		marked++
+	}
+	return marked
+}
+
+// NetworkOrBroadcast returns "network" or "broadcast" if ip is the network or
+// broadcast address of the IPv4 subnet cidr, or an empty string otherwise.
+// Subnets narrower than /30 have neither.
+func NetworkOrBroadcast(ip, cidr string) string {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil || n.IP.To4() == nil {
+		return ""
+	}
+	if size, _ := n.Mask.Size(); size > 30 {
+		return ""
+	}
+	broadcast := make(net.IP, 4)
+	for i, b := range n.IP.To4() {
+		broadcast[i] = b | ^n.Mask[i]
+	}
+	switch ip {
+	case n.IP.String():
+		return "network"
+	case broadcast.String():
+		return "broadcast"
+	}
+	return ""
+}
+
+// usableAddress returns the first or last usable address of the IPv4 subnet
+// cidr, as set by position, or an empty string if it has none.
+func usableAddress(cidr, position string) string {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil || n.IP.To4() == nil {
+		return ""
+	}
+	if size, _ := n.Mask.Size(); size > 30 {
+		return ""
+	}
+	ip := make(net.IP, 4)
+	copy(ip, n.IP.To4())
+	if position == "last" {
+		for i := range ip {
+			ip[i] |= ^n.Mask[i]
+		}
+		ip[3]--
+	} else {
+		ip[3]++
+	}
+	return ip.String()
+}
+
+// AggregateParents returns the parent subnets to synthesize for the IPv4
+// subnets in nets that no other subnet in nets contains. Such subnets that
+// are narrower than prefix are grouped by the subnet of length prefix that
+// contains them, and each group of at least 2 is given that subnet as a
+// parent, marked as a folder if folder is set.
+func AggregateParents(nets []legacydb.Subnet, prefix int, folder bool) (out []legacydb.Subnet) {
+	var parsed []*net.IPNet
+	for _, v := range nets {
+		if _, n, err := net.ParseCIDR(fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)); err == nil && n.IP.To4() != nil {
+			parsed = append(parsed, n)
+		} else {
+			parsed = append(parsed, nil)
+		}
+	}
+	var keys []string
+	groups := make(map[string][]legacydb.Subnet)
+	for i, v := range nets {
+		if parsed[i] == nil || v.Mask <= prefix || contained(parsed[i], parsed) {
+			continue
+		}
+		parent := &net.IPNet{IP: parsed[i].IP.Mask(net.CIDRMask(prefix, 32)), Mask: net.CIDRMask(prefix, 32)}
+		key := parent.String()
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], v)
+	}
+	for _, key := range keys {
+		children := groups[key]
+		if len(children) < 2 {
+			continue
+		}
+		ip, _, _ := net.ParseCIDR(key)
+		out = append(out, legacydb.Subnet{
+			Subnet: subnets.Subnet{
+				SubnetAddress: ip.String(),
+				Mask:          prefix,
+				Description:   fmt.Sprintf("Aggregate of %d migrated subnets", len(children)),
+				SectionID:     children[0].SectionID,
+				IsFolder:      phpipam.BoolIntString(folder),
+			},
+			Aggregate: true,
+		})
+	}
+	return out
+}
+
+// contained returns true if n is contained in a wider subnet in nets.
+func contained(n *net.IPNet, nets []*net.IPNet) bool {
+	size, _ := n.Mask.Size()
+	for _, v := range nets {
+		if v == nil {
+			continue
+		}
+		if vs, _ := v.Mask.Size(); vs < size && v.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubnetsToWrite returns nets as a []subnets.Subnet.
+func SubnetsToWrite(nets []legacydb.Subnet) []subnets.Subnet {
+	out := make([]subnets.Subnet, len(nets))
+	for i, v := range nets {
+		out[i] = v.Subnet
+	}
+	return out
+}
+
+// AddressesToWrite returns addrs as a []addresses.Address. If changeNotes is
+// true, a summary of the changes made to each address is appended to its
+// note.
+func AddressesToWrite(addrs []legacydb.Address, changeNotes bool) []addresses.Address {
+	out := make([]addresses.Address, len(addrs))
+	for i, v := range addrs {
+		out[i] = v.Address
+		if changeNotes {
+			out[i].Note = helper.ChangeNote(v.Note, v.Changes)
+		}
+	}
+	return out
+}
+
+// Overlap is a conflict between two legacy subnets in the same section, which
+// PHPIPAM refuses to create both of.
+type Overlap struct {
+	// The subnets, in address order.
+	First, Second legacydb.Subnet
+
+	// Whether the subnets are the same network, rather than overlapping
+	// without one nesting in the other.
+	Duplicate bool
+}
+
+// String implements fmt.Stringer for Overlap.
+func (o Overlap) String() string {
+	if o.Duplicate {
+		return fmt.Sprintf("subnet %s is duplicated (%q and %q)", subnetCIDR(o.First), o.First.Description, o.Second.Description)
+	}
+	return fmt.Sprintf("subnet %s overlaps subnet %s without nesting in it", subnetCIDR(o.Second), subnetCIDR(o.First))
+}
+
+// Suggestion returns a suggested resolution of the conflict in the legacy DB.
+func (o Overlap) Suggestion() string {
+	if o.Duplicate {
+		return fmt.Sprintf("merge the duplicates of %s into one subnet, or route one of them to another section", subnetCIDR(o.First))
+	}
+	var fixes []string
+	for _, v := range []legacydb.Subnet{o.First, o.Second} {
+		if n := subnetNetwork(v); n != nil && n.IP.String() != v.SubnetAddress {
+			fixes = append(fixes, fmt.Sprintf("change %s to its network address %s", subnetCIDR(v), n))
+		}
+	}
+	if len(fixes) == 0 {
+		return fmt.Sprintf("resize %s or %s so that one nests in the other", subnetCIDR(o.First), subnetCIDR(o.Second))
+	}
+	return strings.Join(fixes, ", and ")
+}
+
+// SubnetOverlaps returns the conflicts between the IPv4 subnets in nets that
+// are in the same section: subnets that are duplicated, and subnets whose
+// ranges overlap without one nesting in the other, which happens when a
+// legacy subnet address is not the network address of its mask.
+func SubnetOverlaps(nets []legacydb.Subnet) (out []Overlap) {
+	type span struct {
+		start, end uint64
+		v          legacydb.Subnet
+	}
+	sections := make(map[int][]span)
+	var order []int
+	for _, v := range nets {
+		ip := net.ParseIP(v.SubnetAddress).To4()
+		if ip == nil || v.Mask < 0 || v.Mask > 32 {
+			continue
+		}
+		start := uint64(ip[0])<<24 | uint64(ip[1])<<16 | uint64(ip[2])<<8 | uint64(ip[3])
+		if _, ok := sections[v.SectionID]; !ok {
+			order = append(order, v.SectionID)
+		}
+		sections[v.SectionID] = append(sections[v.SectionID], span{start, start + 1<<uint(32-v.Mask) - 1, v})
+	}
+	for _, id := range order {
+		spans := sections[id]
+		sort.SliceStable(spans, func(i, j int) bool {
+			if spans[i].start != spans[j].start {
+				return spans[i].start < spans[j].start
+			}
+			return spans[i].end > spans[j].end
+		})
+		// open holds the spans that may contain the next one, widest first.
+		var open []span
+		for _, s := range spans {
+			for len(open) > 0 && open[len(open)-1].end < s.start {
+				open = open[:len(open)-1]
+			}
+			duplicate := false
+			for _, o := range open {
+				switch {
+				case o.start == s.start && o.end == s.end:
+					out = append(out, Overlap{First: o.v, Second: s.v, Duplicate: true})
+					duplicate = true
+				case o.end >= s.start && o.end < s.end:
+					out = append(out, Overlap{First: o.v, Second: s.v})
+				}
+			}
+			if !duplicate {
+				open = append(open, s)
+			}
+		}
+	}
+	return out
+}
+
+// subnetCIDR returns the address and mask of v in CIDR notation.
+func subnetCIDR(v legacydb.Subnet) string {
+	return fmt.Sprintf("%s/%d", v.SubnetAddress, v.Mask)
+}
+
+// subnetNetwork returns the network of v, or nil if it is not valid.
+func subnetNetwork(v legacydb.Subnet) *net.IPNet {
+	_, n, err := net.ParseCIDR(subnetCIDR(v))
+	if err != nil {
+		return nil
+	}
+	return n
+}
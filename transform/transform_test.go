@@ -0,0 +1,310 @@
+package transform
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/subnets"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/vlans"
+)
+
+func TestSortSubnets(t *testing.T) {
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.10.0.0", Mask: 16}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.9.0.0", Mask: 16}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 8}},
+	}
+	SortSubnets(nets)
+
+	var actual []string
+	for _, v := range nets {
+		actual = append(actual, v.SubnetAddress)
+	}
+	expected := []string{"10.0.0.0", "10.9.0.0", "10.10.0.0"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestMarkGateways(t *testing.T) {
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.254"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.0.9", Description: "Core Router"}, SubnetCIDR: "10.0.0.0/24"},
+		{Address: addresses.Address{IPAddress: "10.0.1.1"}, SubnetCIDR: "10.0.1.0/31"},
+	}
+	if marked := MarkGateways(addrs, "first", regexp.MustCompile("(?i)router")); marked != 2 {
+		t.Fatalf("Expected 2 gateways marked, got %d", marked)
+	}
+	var actual []bool
+	for _, v := range addrs {
+		actual = append(actual, bool(v.IsGateway))
+	}
+	if expected := []bool{true, false, true, false}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+	if expected := []string{"marked as gateway, as the first usable address of subnet 10.0.0.0/24"}; !reflect.DeepEqual(expected, addrs[0].Changes) {
+		t.Fatalf("Expected changes %#v, got %#v", expected, addrs[0].Changes)
+	}
+
+	if marked := MarkGateways(addrs, "last", nil); marked != 1 || !addrs[1].IsGateway {
+		t.Fatalf("Expected the last usable address to be marked, got %d marked", marked)
+	}
+}
+
+func TestNetworkOrBroadcast(t *testing.T) {
+	for _, v := range []struct {
+		ip, cidr, expected string
+	}{
+		{"10.0.0.0", "10.0.0.0/24", "network"},
+		{"10.0.0.255", "10.0.0.0/24", "broadcast"},
+		{"10.0.0.1", "10.0.0.0/24", ""},
+		{"10.0.0.0", "10.0.0.0/31", ""},
+		{"10.0.0.0", "bogus", ""},
+	} {
+		if actual := NetworkOrBroadcast(v.ip, v.cidr); actual != v.expected {
+			t.Fatalf("Expected %q for %s in %s, got %q", v.expected, v.ip, v.cidr, actual)
+		}
+	}
+}
+
+func TestAggregateParents(t *testing.T) {
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.1.1.0", Mask: 24, SectionID: 2}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.1.2.0", Mask: 24, SectionID: 2}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.2.1.0", Mask: 24, SectionID: 2}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.3.0.0", Mask: 20, SectionID: 2}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.3.1.0", Mask: 24, SectionID: 2}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.3.2.0", Mask: 24, SectionID: 2}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.4.0.0", Mask: 16, SectionID: 2}},
+	}
+	actual := AggregateParents(nets, 16, true)
+	expected := []legacydb.Subnet{{
+		Subnet: subnets.Subnet{
+			SubnetAddress: "10.1.0.0",
+			Mask:          16,
+			Description:   "Aggregate of 2 migrated subnets",
+			SectionID:     2,
+			IsFolder:      true,
+		},
+		Aggregate: true,
+	}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestSubnetOverlaps(t *testing.T) {
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.0.0", Mask: 16, SectionID: 1}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.1.0", Mask: 24, SectionID: 1, Description: "a"}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.1.0", Mask: 24, SectionID: 1, Description: "b"}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.2.128", Mask: 24, SectionID: 1}},
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.3.0", Mask: 24, SectionID: 1}},
+		// The same network in another section is not a conflict.
+		{Subnet: subnets.Subnet{SubnetAddress: "10.0.1.0", Mask: 24, SectionID: 2}},
+	}
+	actual := SubnetOverlaps(nets)
+	expected := []Overlap{
+		{First: nets[1], Second: nets[2], Duplicate: true},
+		{First: nets[3], Second: nets[4]},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %#v, got %#v", expected, actual)
+	}
+	if expected := "change 10.0.2.128/24 to its network address 10.0.2.0/24"; actual[1].Suggestion() != expected {
+		t.Fatalf("Expected suggestion %q, got %q", expected, actual[1].Suggestion())
+	}
+	if expected := "subnet 10.0.3.0/24 overlaps subnet 10.0.2.128/24 without nesting in it"; actual[1].String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, actual[1].String())
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	long := strings.Repeat("x", MaxAddressDescription+6)
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Hostname: " host.example.com\n", Description: long, Note: "note"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Hostname: "ok.example.com", Description: "ok", Port: "Gi0/1"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.3", Port: strings.Repeat("p", MaxAddressPort+1)}},
+	}
+	Addresses(addrs)
+
+	if addrs[0].Hostname != "host.example.com" {
+		t.Fatalf("Expected hostname to be trimmed, got %q", addrs[0].Hostname)
+	}
+	if len(addrs[0].Description) != MaxAddressDescription {
+		t.Fatalf("Expected description of %d characters, got %d", MaxAddressDescription, len(addrs[0].Description))
+	}
+	if len(addrs[0].Changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %#v", addrs[0].Changes)
+	}
+	if len(addrs[1].Changes) != 0 || addrs[1].Port != "Gi0/1" {
+		t.Fatalf("Expected no changes, got %#v", addrs[1])
+	}
+	if len(addrs[2].Port) != MaxAddressPort || len(addrs[2].Changes) != 1 {
+		t.Fatalf("Expected port of %d characters and 1 change, got %#v", MaxAddressPort, addrs[2])
+	}
+
+	out := AddressesToWrite(addrs, true)
+	if !strings.HasPrefix(out[0].Note, "note\n[migration] hostname sanitized") {
+		t.Fatalf("Expected change note, got %q", out[0].Note)
+	}
+	if out[1].Note != "" {
+		t.Fatalf("Expected blank note, got %q", out[1].Note)
+	}
+	if out := AddressesToWrite(addrs, false); out[0].Note != "note" {
+		t.Fatalf("Expected note without changes, got %q", out[0].Note)
+	}
+}
+
+func TestNormalizeHostnames(t *testing.T) {
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Hostname: "Host.Example.COM."}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Hostname: "host.example.com"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.3"}},
+	}
+	NormalizeHostnames(addrs, true, true)
+
+	if addrs[0].Hostname != "host.example.com" || !reflect.DeepEqual(addrs[0].Changes, []string{`hostname normalized from "Host.Example.COM."`}) {
+		t.Fatalf("Expected normalized hostname and 1 change, got %#v", addrs[0])
+	}
+	for _, v := range addrs[1:] {
+		if len(v.Changes) != 0 {
+			t.Fatalf("Expected no changes, got %#v", v)
+		}
+	}
+
+	addrs = []legacydb.Address{{Address: addresses.Address{IPAddress: "10.0.0.1", Hostname: "Host.Example.COM."}}}
+	NormalizeHostnames(addrs, false, true)
+	if addrs[0].Hostname != "Host.Example.COM" {
+		t.Fatalf("Expected only the trailing dot to be removed, got %q", addrs[0].Hostname)
+	}
+}
+
+func TestInvalidHostnames(t *testing.T) {
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Hostname: "host.example.com"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Hostname: "file_server"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.3"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.4", Hostname: "-bad-.example.com"}},
+	}
+	expected := []string{"10.0.0.2 (file_server)", "10.0.0.4 (-bad-.example.com)"}
+	if actual := InvalidHostnames(addrs); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected invalid hostnames %q, got %q", expected, actual)
+	}
+}
+
+func TestStamp(t *testing.T) {
+	const stamp = "[migrated 2024-05-01]"
+	vs := []legacydb.VLAN{{VLAN: vlans.VLAN{Number: 10, Description: "Servers"}}, {VLAN: vlans.VLAN{Number: 20}}}
+	StampVLANs(vs, stamp, "")
+	if vs[0].Description != "Servers [migrated 2024-05-01]" || vs[1].Description != stamp {
+		t.Fatalf("Expected stamped VLAN descriptions, got %#v", vs)
+	}
+	nets := []legacydb.Subnet{{Subnet: subnets.Subnet{Description: "LAN"}}}
+	StampSubnets(nets, stamp, "custom_Migrated")
+	if nets[0].Description != "LAN" || !reflect.DeepEqual(nets[0].CustomFields, map[string]string{"custom_Migrated": stamp}) {
+		t.Fatalf("Expected stamped subnet custom field, got %#v", nets[0])
+	}
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Description: "host"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Description: strings.Repeat("x", MaxAddressDescription)}},
+	}
+	StampAddresses(addrs, stamp, "")
+	if addrs[0].Description != "host [migrated 2024-05-01]" || len(addrs[0].Changes) != 0 {
+		t.Fatalf("Expected stamped address description, got %#v", addrs[0])
+	}
+	if d := addrs[1].Description; len(d) != MaxAddressDescription || !strings.HasSuffix(d, " "+stamp) || len(addrs[1].Changes) != 1 {
+		t.Fatalf("Expected truncated and stamped address description, got %#v", addrs[1])
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	vs := []legacydb.VLAN{
+		{VLAN: vlans.VLAN{Number: 10, Name: "r\xe9seau", Description: "caf\xe9"}},
+		{VLAN: vlans.VLAN{Number: 20, Name: "réseau"}},
+	}
+	if n := Latin1VLANs(vs); n != 1 || vs[0].Name != "réseau" || vs[0].Description != "café" || vs[1].Name != "réseau" {
+		t.Fatalf("Expected 1 VLAN transcoded, got %d: %#v", n, vs)
+	}
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{Description: "M\xfcnchen"}},
+		{Subnet: subnets.Subnet{Description: "plain"}},
+	}
+	if n := Latin1Subnets(nets); n != 1 || nets[0].Description != "München" || nets[1].Description != "plain" {
+		t.Fatalf("Expected 1 subnet transcoded, got %d: %#v", n, nets)
+	}
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Description: "na\xefve", Hostname: "h\xf4te", Note: "\x93quoted\x94"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Description: "naïve"}},
+	}
+	if n := Latin1Addresses(addrs); n != 1 {
+		t.Fatalf("Expected 1 address transcoded, got %d", n)
+	}
+	if a := addrs[0]; a.Description != "naïve" || a.Hostname != "hôte" || a.Note != "“quoted”" || len(a.Changes) != 0 {
+		t.Fatalf("Expected transcoded address with no changes, got %#v", a)
+	}
+	if addrs[1].Description != "naïve" {
+		t.Fatalf("Expected UTF-8 description to be left as it is, got %q", addrs[1].Description)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	vs := []legacydb.VLAN{
+		{VLAN: vlans.VLAN{Number: 10, Name: "R&amp;D", Description: "&quot;lab&quot;"}},
+		{VLAN: vlans.VLAN{Number: 20, Name: "AT&T"}},
+	}
+	if n := UnescapeVLANs(vs); n != 1 || vs[0].Name != "R&D" || vs[0].Description != `"lab"` || vs[1].Name != "AT&T" {
+		t.Fatalf("Expected 1 VLAN unescaped, got %d: %#v", n, vs)
+	}
+	nets := []legacydb.Subnet{
+		{Subnet: subnets.Subnet{Description: "Sales &amp; Marketing"}},
+		{Subnet: subnets.Subnet{Description: "plain"}},
+	}
+	if n := UnescapeSubnets(nets); n != 1 || nets[0].Description != "Sales & Marketing" || nets[1].Description != "plain" {
+		t.Fatalf("Expected 1 subnet unescaped, got %d: %#v", n, nets)
+	}
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", Description: "&lt;core&gt;", Hostname: "gw", Note: "it&#39;s"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", Description: "Q&A"}},
+	}
+	if n := UnescapeAddresses(addrs); n != 1 {
+		t.Fatalf("Expected 1 address unescaped, got %d", n)
+	}
+	expected := []string{`description HTML entities decoded (was "&lt;core&gt;")`, `note HTML entities decoded (was "it&#39;s")`}
+	if a := addrs[0]; a.Description != "<core>" || a.Note != "it's" || !reflect.DeepEqual(a.Changes, expected) {
+		t.Fatalf("Expected unescaped address with changes %q, got %#v", expected, a)
+	}
+	if len(addrs[1].Changes) != 0 {
+		t.Fatalf("Expected no changes, got %#v", addrs[1])
+	}
+}
+
+func TestNormalizeMACs(t *testing.T) {
+	addrs := []legacydb.Address{
+		{Address: addresses.Address{IPAddress: "10.0.0.1", MACAddress: "001A.2B3C.4D5E"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.2", MACAddress: "00:1a:2b:3c:4d:5e"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.3", MACAddress: "not a mac"}},
+		{Address: addresses.Address{IPAddress: "10.0.0.4"}},
+	}
+	invalid := NormalizeMACs(addrs)
+
+	if addrs[0].MACAddress != "00:1a:2b:3c:4d:5e" || len(addrs[0].Changes) != 1 {
+		t.Fatalf("Expected normalized MAC address and 1 change, got %#v", addrs[0])
+	}
+	for _, v := range addrs[1:] {
+		if len(v.Changes) != 0 {
+			t.Fatalf("Expected no changes, got %#v", v)
+		}
+	}
+	if addrs[2].MACAddress != "not a mac" {
+		t.Fatalf("Expected invalid MAC address to be left as it is, got %q", addrs[2].MACAddress)
+	}
+	if !reflect.DeepEqual(invalid, []string{"not a mac"}) {
+		t.Fatalf("Expected invalid MAC addresses %v, got %v", []string{"not a mac"}, invalid)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/helper"
+	"github.com/paybyphone/phpipam-legacy-migrator/journal"
+	"github.com/paybyphone/phpipam-legacy-migrator/metrics"
+	"github.com/paybyphone/phpipam-legacy-migrator/ratelimit"
+	"github.com/paybyphone/phpipam-legacy-migrator/token"
+	"github.com/sirupsen/logrus"
+)
+
+// setupAPITransport configures the HTTP transport used to talk to the
+// PHPIPAM API.
+//
+// The PHPIPAM SDK always sends requests with http.DefaultTransport, so it is
+// replaced with a copy carrying our options. Proxies set in the HTTPS_PROXY,
+// HTTP_PROXY, and NO_PROXY environment variables are honoured by default, and
+// can be overridden with -api-proxy. TLS verification can be pointed at a
+// private CA with -api-ca-file, or disabled with -api-insecure, and a client
+// certificate for mutual TLS can be supplied with -api-client-cert and
+// -api-client-key. Requests are limited by -api-timeout and
+// -api-connect-timeout, so that an unresponsive instance fails rather than
+// hanging, and can be throttled with -api-rate. The session token is
+// refreshed transparently if it expires during the run, and mutating requests
+// are journaled with -journal. With -target netbox or nautobot, the same
+// options apply to the NetBox or Nautobot API instead.
+func setupAPITransport() {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   apiConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	t.DialContext = dialer.DialContext
+	t.TLSHandshakeTimeout = apiConnectTimeout
+
+	if apiCAFile != "" || apiInsecure || apiClientCert != "" || apiClientKey != "" {
+		tlsConfig, err := helper.TLSConfig(apiCAFile, apiClientCert, apiClientKey, apiInsecure)
+		if err != nil {
+			logrus.Fatalf("Error configuring TLS for the PHPIPAM API: %s", err)
+		}
+		if apiInsecure {
+			logrus.Warn("TLS certificate verification for the PHPIPAM API is disabled")
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+
+	if apiProxy != "" {
+		u, err := url.Parse(apiProxy)
+		if err != nil || u.Host == "" {
+			logrus.Fatalf("Invalid -api-proxy URL %q: %v", apiProxy, err)
+		}
+		logrus.Debugf("Using proxy %s for PHPIPAM API requests", u.Redacted())
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	var rt http.RoundTripper = t
+	if apiTimeout > 0 {
+		rt = &helper.TimeoutTransport{Transport: rt, Timeout: apiTimeout}
+	}
+	rt = &metrics.Transport{Transport: rt, Histogram: apiDuration}
+	if apiRate < 0 {
+		logrus.Fatalf("Invalid -api-rate %v: must not be negative", apiRate)
+	}
+	if apiRate > 0 {
+		logrus.Debugf("Limiting PHPIPAM API requests to %v per second (burst %d)", apiRate, apiBurst)
+		rt = &ratelimit.Transport{Transport: rt, Limiter: ratelimit.New(apiRate, apiBurst)}
+	}
+	if target == "phpipam" {
+		rt = &token.Transport{Transport: rt, Config: ipamSession.Config}
+	}
+	if journalFile != "" {
+		rt = journalTransport(rt)
+	}
+	http.DefaultTransport = rt
+}
+
+// journalTransport opens journalFile, and returns rt wrapped so that every
+// mutating API request is written to it. The journal is closed on exit,
+// including fatal exits.
+func journalTransport(rt http.RoundTripper) http.RoundTripper {
+	var err error
+	if apiJournal, err = journal.Open(journalFile); err != nil {
+		logrus.Fatalf("Error opening journal: %s", err)
+	}
+	logrus.Infof("Journaling API mutations to %s", journalFile)
+	logrus.RegisterExitHandler(closeJournal)
+	return &journal.Transport{Transport: rt, Journal: apiJournal}
+}
+
+// closeJournal closes the journal, if any.
+func closeJournal() {
+	if apiJournal == nil {
+		return
+	}
+	if err := apiJournal.Close(); err != nil {
+		logrus.Errorf("Error closing journal %s: %s", journalFile, err)
+	}
+	apiJournal = nil
+}
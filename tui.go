@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// tuiLogLines is the number of log lines shown on the full-screen display.
+const tuiLogLines = 10
+
+var (
+	// tui switches the progress display to a full-screen terminal UI, which
+	// shows the log instead of it streaming to stderr.
+	tui bool
+
+	// tuiLog is the hook that shows the log on the full-screen display while
+	// it is running.
+	tuiLog *tuiHook
+)
+
+// tuiHook is a logrus hook that passes log messages to the full-screen
+// display, and counts the warnings and errors. Warnings and errors are kept,
+// so that they can be written to stderr once the display stops.
+type tuiHook struct {
+	mu       sync.Mutex
+	stopped  bool
+	errors   int
+	warnings int
+	kept     []string
+}
+
+// Levels implements logrus.Hook for tuiHook.
+func (h *tuiHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook for tuiHook.
+func (h *tuiHook) Fire(e *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		return nil
+	}
+	line := fmt.Sprintf("%s %-5s %s", e.Time.Format("15:04:05"), strings.ToUpper(e.Level.String()), e.Message)
+	progressDisplay.Log(line)
+	switch {
+	case e.Level <= logrus.ErrorLevel:
+		h.errors++
+	case e.Level == logrus.WarnLevel:
+		h.warnings++
+	default:
+		return nil
+	}
+	h.kept = append(h.kept, line)
+	return nil
+}
+
+// counts returns the number of errors and warnings logged.
+func (h *tuiHook) counts() (errors, warnings int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.errors, h.warnings
+}
+
+// stop stops passing log messages to the display, and returns the warnings
+// and errors that were logged.
+func (h *tuiHook) stop() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopped = true
+	return h.kept
+}
+
+// setupTUI switches the progress display to the full-screen display, sized
+// to the terminal, and redirects the log to it. The terminal is restored on
+// exit, including fatal exits.
+func setupTUI() {
+	width, _, err := terminal.GetSize(int(os.Stderr.Fd()))
+	if err != nil {
+		width = 80
+	}
+	progressDisplay.Screen = true
+	progressDisplay.Width = width
+	progressDisplay.LogLines = tuiLogLines
+	progressDisplay.Interval = time.Second
+	progressDisplay.Stats = tuiStats()
+	tuiLog = &tuiHook{}
+	logrus.AddHook(tuiLog)
+	logrus.SetOutput(ioutil.Discard)
+	logrus.RegisterExitHandler(stopProgress)
+}
+
+// tuiStats returns the extra status lines of the full-screen display: the
+// number of errors and warnings logged, and the latency of the API requests
+// made since the last update, and of all of them.
+func tuiStats() func() []string {
+	var lastSum float64
+	var lastCount uint64
+	return func() []string {
+		errors, warnings := tuiLog.counts()
+		sum, count := apiDuration.Totals()
+		latency := "no requests"
+		if count > 0 {
+			latency = fmt.Sprintf("%s average", milliseconds(sum/float64(count)))
+			if count > lastCount {
+				latency = fmt.Sprintf("%s now, %s", milliseconds((sum-lastSum)/float64(count-lastCount)), latency)
+			}
+		}
+		lastSum, lastCount = sum, count
+		return []string{
+			fmt.Sprintf("Errors: %d   Warnings: %d", errors, warnings),
+			fmt.Sprintf("API requests: %d   Latency: %s", count, latency),
+		}
+	}
+}
+
+// milliseconds formats a latency in seconds in milliseconds.
+func milliseconds(s float64) string {
+	return fmt.Sprintf("%.1fms", s*1000)
+}
+
+// stopProgress stops the progress display. If it was the full-screen display,
+// the log is sent back to stderr, and the warnings and errors logged while it
+// was running are written there, so that they are not lost.
+func stopProgress() {
+	progressDisplay.Stop()
+	if tuiLog == nil {
+		return
+	}
+	kept := tuiLog.stop()
+	tuiLog = nil
+	logrus.SetOutput(os.Stderr)
+	if len(kept) > 0 {
+		fmt.Fprintln(os.Stderr, "Warnings and errors logged during the migration:")
+		for _, v := range kept {
+			fmt.Fprintln(os.Stderr, v)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package tunnel provides SSH tunnels for reaching legacy databases that only
+// accept local connections.
+//
+// Tunnels are opened with the system's ssh client, so that the user's usual
+// SSH configuration, known hosts, and agent are all honoured.
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readyTimeout is how long to wait for the tunnel to start accepting
+// connections.
+const readyTimeout = 30 * time.Second
+
+// Config holds the options for an SSH tunnel.
+type Config struct {
+	// The SSH server to connect to, optionally with a port (ie:
+	// bastion.example.com:2222).
+	Host string
+
+	// The user to log in to the SSH server as. The ssh client's default is
+	// used if this is blank.
+	User string
+
+	// The path to the private key to authenticate with. The ssh client's
+	// default keys and agent are used if this is blank.
+	KeyFile string
+
+	// The address to forward to, as seen from the SSH server (ie:
+	// 127.0.0.1:3306).
+	RemoteAddr string
+}
+
+// Tunnel represents an open SSH tunnel.
+type Tunnel struct {
+	// The local address that is forwarded to Config.RemoteAddr.
+	LocalAddr string
+
+	cmd  *exec.Cmd
+	done chan error
+}
+
+// Open opens an SSH tunnel, forwarding a free local port to
+// cfg.RemoteAddr. It returns once the tunnel is accepting connections.
+func Open(cfg Config) (*Tunnel, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, fmt.Errorf("error finding a free local port: %s", err)
+	}
+	args, err := cfg.args(port)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{
+		LocalAddr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		cmd:       exec.Command("ssh", args...),
+		done:      make(chan error, 1),
+	}
+	if err := t.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting ssh: %s", err)
+	}
+	go func() { t.done <- t.cmd.Wait() }()
+
+	deadline := time.Now().Add(readyTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-t.done:
+			return nil, fmt.Errorf("ssh exited before the tunnel was ready: %v", err)
+		default:
+		}
+		if conn, err := net.DialTimeout("tcp", t.LocalAddr, time.Second); err == nil {
+			conn.Close()
+			return t, nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	t.Close()
+	return nil, fmt.Errorf("timed out waiting for SSH tunnel to %s", cfg.Host)
+}
+
+// Close closes the tunnel.
+func (t *Tunnel) Close() error {
+	if t.cmd.Process == nil {
+		return nil
+	}
+	return t.cmd.Process.Kill()
+}
+
+// args returns the ssh client arguments for forwarding the local port to the
+// remote address. Hosts and users starting with a dash are rejected, so that
+// they cannot be read by ssh as options (ie: -oProxyCommand=...).
+func (cfg Config) args(port int) ([]string, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("no SSH host supplied")
+	}
+	host, sshPort := cfg.Host, ""
+	if h, p, err := net.SplitHostPort(cfg.Host); err == nil {
+		host, sshPort = h, p
+	}
+	if strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid SSH host %q: must not start with -", host)
+	}
+	if strings.HasPrefix(cfg.User, "-") {
+		return nil, fmt.Errorf("invalid SSH user %q: must not start with -", cfg.User)
+	}
+
+	args := []string{
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "BatchMode=yes",
+		"-L", fmt.Sprintf("127.0.0.1:%d:%s", port, cfg.RemoteAddr),
+	}
+	if sshPort != "" {
+		args = append(args, "-p", sshPort)
+	}
+	if cfg.User != "" {
+		args = append(args, "-l", cfg.User)
+	}
+	if cfg.KeyFile != "" {
+		args = append(args, "-i", cfg.KeyFile)
+	}
+	return append(args, "--", host), nil
+}
+
+// freePort finds a free local TCP port.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,46 @@
+package tunnel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigArgs(t *testing.T) {
+	cfg := Config{
+		Host:       "bastion.example.com:2222",
+		User:       "jdoe",
+		KeyFile:    "/home/jdoe/.ssh/id_rsa",
+		RemoteAddr: "127.0.0.1:3306",
+	}
+	expected := []string{
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "BatchMode=yes",
+		"-L", "127.0.0.1:13306:127.0.0.1:3306",
+		"-p", "2222",
+		"-l", "jdoe",
+		"-i", "/home/jdoe/.ssh/id_rsa",
+		"--",
+		"bastion.example.com",
+	}
+	actual, err := cfg.args(13306)
+	if err != nil {
+		t.Fatalf("Error building args: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+
+	if _, err := (Config{}).args(13306); err == nil {
+		t.Fatal("Expected error with no host, got none")
+	}
+	for _, v := range []Config{
+		{Host: "-oProxyCommand=touch /tmp/pwned"},
+		{Host: "-oProxyCommand=x:22"},
+		{Host: "bastion.example.com", User: "-oProxyCommand=x"},
+	} {
+		if _, err := v.args(13306); err == nil {
+			t.Fatalf("Expected error with host %q and user %q, got none", v.Host, v.User)
+		}
+	}
+}
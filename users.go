@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/controllers/users"
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+)
+
+var (
+	// migrateUsers enables user migration. The legacy users and groups are
+	// created in the new PHPIPAM instance, and users are added to their
+	// groups. As the API cannot create users, this needs a sink that
+	// implements ipamsink.UserCreator.
+	migrateUsers bool
+
+	// usersDefaultPassword is the password that migrated users are given, as
+	// legacy password hashes are not migrated. Users without one cannot log
+	// in until an administrator sets their password. Either way, users must
+	// change their password at their next login.
+	usersDefaultPassword string
+)
+
+// fetchUsers gets the users and groups from the legacy DB.
+func fetchUsers(conn *sql.DB) ([]users.User, []users.Group, error) {
+	stageLog.Info("Fetching users and groups from legacy DB")
+
+	r := &legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}
+	groups, err := r.Groups()
+	if err != nil {
+		return nil, nil, err
+	}
+	accounts, err := r.UserAccounts()
+	if err != nil {
+		return nil, nil, err
+	}
+	stageLog.Infof("Found %d users and %d groups to migrate", len(accounts), len(groups))
+	return accounts, groups, nil
+}
+
+// userKey normalizes a username or group name, so that names that only
+// differ in case match, as they do in PHPIPAM's database.
+func userKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// addGroups adds the legacy groups that are not in the new PHPIPAM instance,
+// which has its own default groups (ie: Operators and Guests), with c, and
+// then returns the IDs of the groups in the new PHPIPAM instance, keyed by
+// their legacy IDs.
+func addGroups(c ipamsink.UserCreator, groups []users.Group) (map[int]int, error) {
+	ids := make(map[string]int)
+	list := func() error {
+		found, err := c.Groups()
+		if err != nil {
+			return err
+		}
+		for _, v := range found {
+			if _, ok := ids[userKey(v.Name)]; !ok {
+				ids[userKey(v.Name)] = v.ID
+			}
+		}
+		return nil
+	}
+	if err := list(); err != nil {
+		return nil, err
+	}
+
+	stageLog.Info("Adding groups.")
+	created := make(map[string]bool)
+	for _, v := range groups {
+		key := userKey(v.Name)
+		if _, ok := ids[key]; ok {
+			stageLog.Debugf("Group %s already exists in new PHPIPAM database", v.Name)
+			continue
+		}
+		if created[key] {
+			continue
+		}
+		if err := c.CreateGroup(users.Group{Name: v.Name, Description: v.Description}); err != nil {
+			return nil, err
+		}
+		created[key] = true
+		recordsTotal.Inc("groups", "migrated")
+		stageLog.WithField("group", v.Name).Infof("Group %s added successfully", v.Name)
+	}
+	// The IDs of the created groups are not returned, so look them up.
+	if len(created) > 0 {
+		if err := list(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(map[int]int)
+	for _, v := range groups {
+		if id, ok := ids[userKey(v.Name)]; ok {
+			out[v.ID] = id
+		}
+	}
+	return out, nil
+}
+
+// addUsers adds the legacy users that are not in the new PHPIPAM instance
+// with c, after adding their groups, and gives them usersDefaultPassword.
+func addUsers(c ipamsink.UserCreator, accounts []users.User, groups []users.Group) error {
+	groupIDs, err := addGroups(c, groups)
+	if err != nil {
+		return err
+	}
+	existing, err := c.Users()
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool)
+	for _, v := range existing {
+		found[userKey(v.Username)] = true
+	}
+
+	stageLog.Info("Adding users.")
+
+	tracker := progressDisplay.Track("users", len(accounts))
+	defer tracker.Finish()
+
+	for _, v := range accounts {
+		tracker.Add(1)
+		log := stageLog.WithField("user", v.Username)
+		if found[userKey(v.Username)] {
+			recordsTotal.Inc("users", "skipped")
+			log.Infof("User %s already exists in new PHPIPAM database, skipping", v.Username)
+			continue
+		}
+		u := v
+		u.Groups = nil
+		for _, id := range v.Groups {
+			if newID, ok := groupIDs[id]; ok {
+				u.Groups = append(u.Groups, newID)
+			} else {
+				log.Warnf("User %s belongs to legacy group ID %d, which does not exist", v.Username, id)
+			}
+		}
+		u.Password = usersDefaultPassword
+		if err := c.CreateUser(u); err != nil {
+			return err
+		}
+		found[userKey(v.Username)] = true
+		recordsTotal.Inc("users", "migrated")
+		log.Infof("User %s added successfully", v.Username)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package vault provides a minimal HashiCorp Vault client, used to read
+// migration credentials from a secret so that they never need to be supplied
+// in plaintext.
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The default Vault address, matching the Vault CLI default.
+const defaultAddress = "https://127.0.0.1:8200"
+
+// Client is a Vault API client.
+type Client struct {
+	// The address of the Vault server (ie: https://vault.example.com:8200).
+	// Defaults to VAULT_ADDR, or https://127.0.0.1:8200 if that is not set.
+	Address string
+
+	// The token used to authenticate to Vault. Defaults to VAULT_TOKEN.
+	Token string
+
+	// The HTTP client to use. http.DefaultClient is used if this is nil.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a new Client, with defaults taken from the VAULT_ADDR and
+// VAULT_TOKEN environment variables for any blank values.
+func NewClient(addr, token string) *Client {
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		addr = defaultAddress
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	return &Client{
+		Address: strings.TrimSuffix(addr, "/"),
+		Token:   token,
+	}
+}
+
+// secretResponse represents a Vault secret read response.
+type secretResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// ReadSecret reads the secret at path and returns its values as strings.
+//
+// Both the KV version 1 and version 2 secret engines are supported. For
+// version 2, path should include the "data/" component (ie:
+// secret/data/phpipam), and the nested data object is returned.
+func (c *Client) ReadSecret(path string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/%s", c.Address, strings.TrimPrefix(path, "/")), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.Token)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting Vault: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret secretResponse
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("error parsing Vault response (%s): %s", resp.Status, err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("error from Vault (%s): %s", resp.Status, strings.Join(secret.Errors, ", "))
+	}
+
+	data := secret.Data
+	// KV version 2 nests the secret values under another data key.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	out := make(map[string]string)
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			out[k] = s
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,51 @@
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestReadSecret(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/phpipam":
+			w.Write([]byte(`{"data":{"db_password":"foo","phpipam_password":"bar"}}`))
+		case "/v1/secret/data/phpipam":
+			w.Write([]byte(`{"data":{"data":{"db_password":"foo","phpipam_password":"bar"},"metadata":{"version":1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer ts.Close()
+
+	expected := map[string]string{
+		"db_password":      "foo",
+		"phpipam_password": "bar",
+	}
+
+	c := NewClient(ts.URL, "token")
+	for _, path := range []string{"secret/phpipam", "secret/data/phpipam"} {
+		actual, err := c.ReadSecret(path)
+		if err != nil {
+			t.Fatalf("Error reading secret %s: %s", path, err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("Expected %#v, got %#v", expected, actual)
+		}
+	}
+
+	if _, err := c.ReadSecret("secret/missing"); err == nil {
+		t.Fatal("Expected error reading missing secret, got none")
+	}
+	if _, err := NewClient(ts.URL, "bad").ReadSecret("secret/phpipam"); err == nil {
+		t.Fatal("Expected error reading secret with bad token, got none")
+	}
+}
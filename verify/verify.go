@@ -0,0 +1,120 @@
+// Package verify provides functionality for verifying migrated data against
+// the new PHPIPAM instance.
+//
+// Verification is done in bulk: instead of looking up each address
+// individually, all of the addresses in a subnet are fetched with a single
+// request, and compared locally.
+package verify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/client"
+	"github.com/paybyphone/phpipam-sdk-go/phpipam/session"
+)
+
+// Mismatch represents a single difference found between the legacy data and
+// the new PHPIPAM instance.
+type Mismatch struct {
+	// The IP address that the mismatch was found on.
+	IPAddress string
+
+	// The ID of the subnet the address belongs to in the new PHPIPAM instance.
+	SubnetID int
+
+	// The field that differs. This is "address" if the address is missing
+	// from, or unexpectedly present in, the new PHPIPAM instance.
+	Field string
+
+	// The expected (legacy) value.
+	Expected string
+
+	// The actual value in the new PHPIPAM instance.
+	Actual string
+}
+
+// String implements fmt.Stringer for Mismatch.
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s (subnet ID %d): %s: expected %q, got %q", m.IPAddress, m.SubnetID, m.Field, m.Expected, m.Actual)
+}
+
+// CompareAddresses compares the expected addresses for a subnet against the
+// actual addresses found in it, and returns any mismatches. The IP address
+// sets are compared in both directions, and then the key fields of each
+// address found in both sets are compared.
+func CompareAddresses(subnetID int, expected, actual []addresses.Address) (out []Mismatch) {
+	found := make(map[string]addresses.Address)
+	for _, v := range actual {
+		found[v.IPAddress] = v
+	}
+	wanted := make(map[string]bool)
+	for _, e := range expected {
+		wanted[e.IPAddress] = true
+		a, ok := found[e.IPAddress]
+		if !ok {
+			out = append(out, Mismatch{IPAddress: e.IPAddress, SubnetID: subnetID, Field: "address", Expected: "present", Actual: "missing"})
+			continue
+		}
+		fields := []struct {
+			name             string
+			expected, actual string
+		}{
+			{"description", e.Description, a.Description},
+			{"hostname", e.Hostname, a.Hostname},
+			{"note", e.Note, a.Note},
+		}
+		for _, f := range fields {
+			if strings.TrimSpace(f.expected) != strings.TrimSpace(f.actual) {
+				out = append(out, Mismatch{IPAddress: e.IPAddress, SubnetID: subnetID, Field: f.name, Expected: f.expected, Actual: f.actual})
+			}
+		}
+	}
+	for _, a := range actual {
+		if !wanted[a.IPAddress] {
+			out = append(out, Mismatch{IPAddress: a.IPAddress, SubnetID: subnetID, Field: "address", Expected: "missing", Actual: "present"})
+		}
+	}
+	return
+}
+
+// Addresses verifies addrs against the new PHPIPAM instance. The addresses
+// are grouped by subnet ID, and each subnet's addresses are fetched with a
+// single request.
+func Addresses(sess *session.Session, addrs []addresses.Address) ([]Mismatch, error) {
+	bySubnet := make(map[int][]addresses.Address)
+	for _, v := range addrs {
+		bySubnet[v.SubnetID] = append(bySubnet[v.SubnetID], v)
+	}
+	var ids []int
+	for id := range bySubnet {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var out []Mismatch
+	for _, id := range ids {
+		actual, err := subnetAddresses(sess, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CompareAddresses(id, bySubnet[id], actual)...)
+	}
+	return out, nil
+}
+
+// subnetAddresses GETs all of the addresses in a subnet. An empty subnet is
+// not treated as an error.
+func subnetAddresses(sess *session.Session, id int) (out []addresses.Address, err error) {
+	c := client.NewClient(sess)
+	err = c.SendRequest("GET", fmt.Sprintf("/subnets/%d/addresses/", id), &struct{}{}, &out)
+	if err != nil && strings.HasPrefix(err.Error(), "Error from API (404)") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching addresses for subnet ID %d: %s", id, err)
+	}
+	return
+}
@@ -0,0 +1,32 @@
+package verify
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/paybyphone/phpipam-sdk-go/controllers/addresses"
+)
+
+func TestCompareAddresses(t *testing.T) {
+	expected := []addresses.Address{
+		{IPAddress: "10.10.1.1", Description: "foo", Hostname: "foo.example.com"},
+		{IPAddress: "10.10.1.2", Description: "bar"},
+		{IPAddress: "10.10.1.3"},
+	}
+	actual := []addresses.Address{
+		{IPAddress: "10.10.1.1", Description: "foo", Hostname: "foo.example.com"},
+		{IPAddress: "10.10.1.2", Description: "baz"},
+		{IPAddress: "10.10.1.4"},
+	}
+
+	want := []Mismatch{
+		{IPAddress: "10.10.1.2", SubnetID: 3, Field: "description", Expected: "bar", Actual: "baz"},
+		{IPAddress: "10.10.1.3", SubnetID: 3, Field: "address", Expected: "present", Actual: "missing"},
+		{IPAddress: "10.10.1.4", SubnetID: 3, Field: "address", Expected: "missing", Actual: "present"},
+	}
+	got := CompareAddresses(3, expected, actual)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("Expected %s, got %s", spew.Sdump(want), spew.Sdump(got))
+	}
+}
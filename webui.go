@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/notify"
+	"github.com/paybyphone/phpipam-legacy-migrator/progress"
+	"github.com/sirupsen/logrus"
+)
+
+// webUIMessages is the number of recent warnings and errors shown on the web
+// dashboard.
+const webUIMessages = 20
+
+var (
+	// webUIAddr is the address to serve the web dashboard on, if any.
+	webUIAddr string
+
+	// webUILog keeps the recent warnings and errors for the web dashboard.
+	webUILog = &messageHook{}
+
+	// webUIFinal is the final report of the run, shown on the web dashboard
+	// once the run has finished. It is guarded by webUIMu.
+	webUIFinal *notify.Report
+	webUIMu    sync.Mutex
+)
+
+// messageHook is a logrus hook that keeps the most recent warnings and errors,
+// and counts them.
+type messageHook struct {
+	mu       sync.Mutex
+	errors   int
+	warnings int
+	recent   []string
+}
+
+// Levels implements logrus.Hook for messageHook.
+func (h *messageHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+// Fire implements logrus.Hook for messageHook.
+func (h *messageHook) Fire(e *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e.Level == logrus.WarnLevel {
+		h.warnings++
+	} else {
+		h.errors++
+	}
+	h.recent = append(h.recent, fmt.Sprintf("%s %s %s", e.Time.Format("15:04:05"), e.Level, e.Message))
+	if len(h.recent) > webUIMessages {
+		h.recent = h.recent[len(h.recent)-webUIMessages:]
+	}
+	return nil
+}
+
+// webUIStatus is the status of the run shown on the web dashboard, and served
+// as JSON on /status.json.
+type webUIStatus struct {
+	Status   string                    `json:"status"`
+	Error    string                    `json:"error,omitempty"`
+	Started  time.Time                 `json:"started"`
+	Elapsed  string                    `json:"elapsed"`
+	Stages   []string                  `json:"stages"`
+	Phases   []progress.Phase          `json:"phases"`
+	Errors   int                       `json:"errors"`
+	Warnings int                       `json:"warnings"`
+	Recent   []string                  `json:"recent_messages"`
+	Counts   map[string]map[string]int `json:"counts"`
+	Sections []notify.SectionReport    `json:"sections,omitempty"`
+}
+
+// webUIReport returns the final report of the run if it has finished, or the
+// report of the run so far otherwise.
+func webUIReport() *notify.Report {
+	webUIMu.Lock()
+	defer webUIMu.Unlock()
+	if webUIFinal != nil {
+		return webUIFinal
+	}
+	return runReport(notify.StatusRunning, "")
+}
+
+// currentStatus returns the status of the run so far, or its final status if
+// it has finished.
+func currentStatus() *webUIStatus {
+	r := webUIReport()
+	elapsed := time.Since(r.Started)
+	if r.Status != notify.StatusRunning {
+		elapsed = r.Finished.Sub(r.Started)
+	}
+	webUILog.mu.Lock()
+	defer webUILog.mu.Unlock()
+	return &webUIStatus{
+		Status:   r.Status,
+		Error:    r.Error,
+		Started:  r.Started,
+		Elapsed:  elapsed.Round(time.Second).String(),
+		Stages:   stages,
+		Phases:   progressDisplay.Phases(),
+		Errors:   webUILog.errors,
+		Warnings: webUILog.warnings,
+		Recent:   append([]string(nil), webUILog.recent...),
+		Counts:   r.Counts,
+		Sections: r.Sections,
+	}
+}
+
+// startWebUI starts serving the web dashboard at webUIAddr, in the
+// background. The dashboard shows the progress of each phase, the warnings
+// and errors logged, and the records processed, and links to the report of
+// the run, which can be downloaded as JSON. The progress of each phase is
+// tracked by progressDisplay even if it is not displayed on the terminal.
+// If the run fails, the final status is served until the migrator is
+// interrupted, as it is when the run succeeds (see finishWebUI).
+func startWebUI() {
+	l, err := net.Listen("tcp", webUIAddr)
+	if err != nil {
+		logrus.Fatalf("Error starting web dashboard listener: %s", err)
+	}
+	logrus.AddHook(webUILog)
+	if notifyURL == "" {
+		logrus.AddHook(fatalHook{})
+	}
+	logrus.RegisterExitHandler(func() {
+		finishWebUI(notify.StatusFailed, fatalMessage)
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveDashboard)
+	mux.HandleFunc("/status.json", serveStatus)
+	mux.HandleFunc("/report.json", serveReport)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			logrus.Errorf("Error serving web dashboard: %s", err)
+		}
+	}()
+	webUIAddr = l.Addr().String()
+	logrus.Infof("Serving web dashboard on http://%s/", webUIAddr)
+}
+
+// finishWebUI records the final status of the run for the web dashboard, and
+// keeps serving it until the migrator is interrupted, so that the outcome of
+// the run can be seen and its report downloaded.
+func finishWebUI(status, errMsg string) {
+	stopProgress()
+	webUIMu.Lock()
+	if webUIFinal != nil {
+		webUIMu.Unlock()
+		return
+	}
+	webUIFinal = runReport(status, errMsg)
+	webUIMu.Unlock()
+	logrus.Infof("The migration has %s. Serving its final status on the web dashboard at http://%s/ until interrupted (ie: with Ctrl-C).", status, webUIAddr)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+}
+
+// serveStatus serves the status of the run as JSON.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentStatus())
+}
+
+// serveReport serves the report of the run so far, or its final report, as a
+// JSON download, in the format sent to -notify-url.
+func serveReport(w http.ResponseWriter, r *http.Request) {
+	report := webUIReport()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=migration-report-%s.json", report.Started.Format("20060102-150405")))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// serveDashboard serves the web dashboard, which refreshes itself every 5
+// seconds.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s := currentStatus()
+	var entities []string
+	for k := range s.Counts {
+		entities = append(entities, k)
+	}
+	sort.Strings(entities)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, struct {
+		*webUIStatus
+		Entities []string
+		Results  []string
+	}{s, entities, []string{"migrated", "merged", "updated", "skipped", "error"}}); err != nil {
+		logrus.Debugf("Error serving web dashboard: %s", err)
+	}
+}
+
+// dashboardTemplate is the web dashboard page.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>PHPIPAM migration: {{.Status}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+progress { width: 20em; }
+.failed { color: #b00; }
+.succeeded { color: #070; }
+pre { background: #f4f4f4; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>PHPIPAM migration: <span class="{{.Status}}">{{.Status}}</span></h1>
+{{if .Error}}<p class="failed">{{.Error}}</p>
+{{end}}<p>Started {{.Started.Format "2006-01-02 15:04:05 MST"}}, {{if eq .Status "running"}}{{.Elapsed}} ago{{else}}and finished after {{.Elapsed}}{{end}}. Stages: {{range $i, $v := .Stages}}{{if $i}}, {{end}}{{$v}}{{end}}.</p>
+<p><a href="report.json">Download the report</a> (JSON)</p>
+
+<h2>Progress</h2>
+{{if .Phases}}<table>
+{{range .Phases}}<tr><td>{{.Name}}</td><td><progress max="{{.Total}}" value="{{.Done}}"></progress></td><td>{{printf "%.1f" .Percent}}%</td><td>{{.Done}}/{{.Total}}</td><td>{{printf "%.1f" .Rate}}/s</td><td>{{.ETA}}</td><td>{{.Current}}</td></tr>
+{{end}}</table>{{else}}<p>No phases have started yet.</p>{{end}}
+
+<h2>Records</h2>
+{{if .Entities}}<table>
+<tr><th></th>{{range .Results}}<th>{{.}}</th>{{end}}</tr>
+{{range $e := .Entities}}<tr><td>{{$e}}</td>{{range $.Results}}<td>{{index (index $.Counts $e) .}}</td>{{end}}</tr>
+{{end}}</table>{{else}}<p>No records have been processed yet.</p>{{end}}
+{{if .Sections}}
+<h2>Sections</h2>
+<table>
+<tr><th>Legacy section</th><th>Section</th><th>Status</th><th>Subnets added</th><th>Addresses added</th><th>Errors</th></tr>
+{{range .Sections}}<tr><td>{{if .LegacyID}}{{.LegacyID}}{{else}}all{{end}}</td><td>{{.ID}}</td><td class="{{.Status}}">{{.Status}}{{if .Error}}: {{.Error}}{{end}}</td><td>{{.SubnetsAdded}}</td><td>{{.AddressesAdded}}</td><td>{{.Errors}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Errors and Warnings</h2>
+<p>{{.Errors}} errors and {{.Warnings}} warnings logged.</p>
+{{if .Recent}}<pre>{{range .Recent}}{{.}}
+{{end}}</pre>{{end}}
+</body>
+</html>
+`))
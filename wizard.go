@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/paybyphone/phpipam-legacy-migrator/ipamsink"
+	"github.com/paybyphone/phpipam-legacy-migrator/legacydb"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	// interactive switches on the wizard, which prompts for the connection
+	// details, the section to migrate into, and the entities to migrate, and
+	// asks for confirmation before migrating.
+	interactive bool
+
+	// wizardIn and wizardOut are read and written by the wizard. They are
+	// replaced in tests.
+	wizardIn  io.Reader = os.Stdin
+	wizardOut io.Writer = os.Stdout
+
+	// wiz is the running wizard, if -interactive is supplied.
+	wiz *wizard
+)
+
+// wizard prompts for the options of a migration. The options answered are
+// collected, so that the equivalent command line can be shown at the end.
+type wizard struct {
+	in   *bufio.Reader
+	out  io.Writer
+	args []string
+}
+
+// newWizard returns a wizard reading wizardIn and writing wizardOut.
+func newWizard() *wizard {
+	return &wizard{in: bufio.NewReader(wizardIn), out: wizardOut}
+}
+
+// ask prompts for a value, and returns the answer, or def if the answer is
+// blank.
+func (w *wizard) ask(prompt, def string) string {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", prompt, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", prompt)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && line == "" {
+		logrus.Fatalf("Error reading answer: %s", err)
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def
+	}
+	return line
+}
+
+// askInt prompts for a number until a valid one is given.
+func (w *wizard) askInt(prompt string, def int) int {
+	for {
+		v, err := strconv.Atoi(w.ask(prompt, strconv.Itoa(def)))
+		if err == nil {
+			return v
+		}
+		fmt.Fprintln(w.out, "Please enter a number.")
+	}
+}
+
+// confirm prompts for a yes or no answer until one is given, returning def
+// if the answer is blank.
+func (w *wizard) confirm(prompt string, def bool) bool {
+	if def {
+		prompt += " (Y/n)"
+	} else {
+		prompt += " (y/N)"
+	}
+	for {
+		switch strings.ToLower(w.ask(prompt, "")) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintln(w.out, "Please answer yes or no.")
+	}
+}
+
+// set records an option answered in the wizard, for the equivalent command
+// line. Options left at their defaults are not recorded.
+func (w *wizard) set(name, value, def string) {
+	if value != def {
+		w.args = append(w.args, fmt.Sprintf("-%s=%s", name, value))
+	}
+}
+
+// setupInteractive checks that the wizard can be run, and prompts for the
+// connection details of the legacy DB and the PHPIPAM API. The passwords are
+// prompted for afterwards, as they are without -interactive.
+func setupInteractive() {
+	switch {
+	case output != "api" || target != "phpipam" || targetDSN != "":
+		logrus.Fatal("-interactive can only be used with the PHPIPAM API, as the sections to migrate into are listed through it")
+	case sectionsFlag != "" || sectionName != "":
+		logrus.Fatal("-interactive cannot be used with -sections or -section-name, as the section to migrate into is chosen in the wizard")
+	case freezeCheck || auditLegacy || verifyOnly || replayFile != "":
+		logrus.Fatal("-interactive cannot be combined with -freeze-check, -audit, -verify, or -replay")
+	case wizardIn == os.Stdin && !terminal.IsTerminal(int(os.Stdin.Fd())):
+		logrus.Fatal("-interactive requires a terminal")
+	}
+	wiz = newWizard()
+	fmt.Fprintln(wiz.out, "This wizard walks you through migrating a legacy PHPIPAM instance. Press enter to accept the default shown in brackets.")
+	wiz.connection()
+}
+
+// connection prompts for the connection details of the legacy DB, unless it
+// is read from a dump, CSV files, or a DSN, and of the PHPIPAM API.
+func (w *wizard) connection() {
+	if sourceDump == "" && sourceCSV == "" && dbDSN == "" {
+		fmt.Fprintln(w.out, "\nThe legacy DB:")
+		if dbSocket == "" {
+			h := w.ask("Host (blank for the local socket)", dbHost)
+			w.set("dbhost", h, dbHost)
+			dbHost = h
+		}
+		if dbHost != "" {
+			p := w.askInt("Port", dbPort)
+			w.set("dbport", strconv.Itoa(p), strconv.Itoa(dbPort))
+			dbPort = p
+		}
+		u := w.ask("User", dbUser)
+		w.set("dbuser", u, "phpipam")
+		dbUser = u
+		n := w.ask("Database name", dbName)
+		w.set("dbname", n, "phpipam")
+		dbName = n
+	}
+
+	fmt.Fprintln(w.out, "\nThe new PHPIPAM instance:")
+	for _, v := range []struct {
+		prompt, name string
+		value        *string
+	}{
+		{"API endpoint (ie: https://phpipam.example.com/api)", "endpoint", &ipamEndpoint},
+		{"API application ID", "appid", &ipamAppID},
+		{"User", "user", &ipamUser},
+	} {
+		a := w.ask(v.prompt, *v.value)
+		for a == "" {
+			a = w.ask(v.prompt, "")
+		}
+		w.set(v.name, a, "")
+		*v.value = a
+	}
+}
+
+// chooseSection lists the sections in the new PHPIPAM instance, and prompts
+// for the one to migrate into.
+func (w *wizard) chooseSection(c ipamsink.SectionCreator) {
+	secs, err := c.Sections()
+	if err != nil {
+		logrus.Fatalf("Error listing sections: %s", err)
+	}
+	if len(secs) == 0 {
+		logrus.Fatal("The new PHPIPAM instance has no sections - create one to migrate into first")
+	}
+	fmt.Fprintln(w.out, "\nSections in the new PHPIPAM instance:")
+	def := secs[0].ID
+	names := make(map[int]string)
+	for _, v := range secs {
+		if v.ID == sectionID {
+			def = v.ID
+		}
+		names[v.ID] = v.Name
+		if v.Description != "" {
+			fmt.Fprintf(w.out, "  %d: %s (%s)\n", v.ID, v.Name, v.Description)
+		} else {
+			fmt.Fprintf(w.out, "  %d: %s\n", v.ID, v.Name)
+		}
+	}
+	for {
+		id := w.askInt("Section ID to migrate into", def)
+		if names[id] != "" {
+			w.set("sectionid", strconv.Itoa(id), "1")
+			sectionID = id
+			return
+		}
+		fmt.Fprintf(w.out, "There is no section with ID %d.\n", id)
+	}
+}
+
+// wizardEntities are the optional entities that the wizard offers to
+// migrate, along with the VLANs, subnets, and IP addresses.
+var wizardEntities = []struct {
+	name, flag string
+	value      *bool
+}{
+	{"VRFs", "migrate-vrfs", &migrateVRFs},
+	{"nameserver sets", "migrate-nameservers", &migrateNameservers},
+	{"devices (from the switch names of addresses)", "migrate-devices", &migrateDevices},
+}
+
+// chooseEntities prompts for the optional entities to migrate.
+func (w *wizard) chooseEntities() {
+	fmt.Fprintln(w.out, "\nVLANs, subnets, and IP addresses are always migrated.")
+	for _, v := range wizardEntities {
+		*v.value = w.confirm("Migrate "+v.name+"?", *v.value)
+		w.set(v.flag, strconv.FormatBool(*v.value), "false")
+	}
+}
+
+// confirmMigration counts the VLANs, subnets, and IP addresses in the legacy
+// DB, and asks for confirmation before migrating them. The options answered
+// are shown, so that the migration can be run again without the wizard.
+func (w *wizard) confirmMigration(conn *sql.DB) bool {
+	r := &legacydb.Reader{DB: conn, Log: stageLog, Queries: legacyQueries}
+	vlans, err := r.VLANs()
+	if err != nil {
+		logrus.Fatalf("Error counting legacy VLANs: %s", err)
+	}
+	subnets, _, err := r.Subnets()
+	if err != nil {
+		logrus.Fatalf("Error counting legacy subnets: %s", err)
+	}
+	addrs, _, err := r.Addresses()
+	if err != nil {
+		logrus.Fatalf("Error counting legacy IP addresses: %s", err)
+	}
+	fmt.Fprintf(w.out, "\nReady to migrate %d VLANs, %d subnets, and %d IP addresses from the legacy DB into section %d at %s.\n", len(vlans), len(subnets), len(addrs), sectionID, ipamEndpoint)
+	var also []string
+	for _, v := range wizardEntities {
+		if *v.value {
+			also = append(also, v.name)
+		}
+	}
+	if len(also) > 0 {
+		fmt.Fprintf(w.out, "Also migrating: %s.\n", strings.Join(also, ", "))
+	}
+	if len(w.args) > 0 {
+		fmt.Fprintf(w.out, "To run this migration again without the wizard, replace -interactive with: %s\n", strings.Join(w.args, " "))
+	}
+	return w.confirm("Start the migration?", false)
+}